@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	RegisterCellMagic("benchmark", "%%benchmark [-count <n>] [-benchtime <d> | <n>x]", "benchmark the cell with testing.B, which it can use as b\nA cell not using b runs b.N times.\n-count runs the benchmark several times, and -benchtime sets how long a run lasts, as for go test.", benchmarkMagic)
+}
+
+// benchmarkResult is a run of the benchmark of a cell, as reported by the
+// gophernotes package in the metadata of the execute_reply.
+type benchmarkResult struct {
+	Name        string
+	N           int
+	NsPerOp     float64
+	BytesPerOp  int64
+	AllocsPerOp int64
+}
+
+// benchmarkRun is what a %%benchmark cell measured.
+type benchmarkRun struct {
+	Count   int
+	Code    string
+	Results []benchmarkResult
+}
+
+// benchtimeRe matches the values of -benchtime counting iterations rather
+// than time.
+var benchtimeRe = regexp.MustCompile(`^[1-9][0-9]*x$`)
+
+// benchmarkMagic runs the body of the cell as the body of a benchmark
+// function, in a function of the session taking b *testing.B, which
+// testing.Benchmark calibrates as go test -bench does. A body not using b is
+// run b.N times. The runs are printed as go test -benchmem prints them, and
+// summed up in a table; they are kept for later comparisons too. -count runs
+// the benchmark several times, and -benchtime sets how long a run lasts, as
+// for go test.
+func benchmarkMagic(ctx *MagicContext, args []string, body string) error {
+	count, benchtime := 1, ""
+	for i := 0; i < len(args); i++ {
+		if args[i] != "-count" && args[i] != "-benchtime" {
+			return fmt.Errorf("unknown argument %s", args[i])
+		}
+		if i+1 == len(args) {
+			return fmt.Errorf("%s needs a value", args[i])
+		}
+		i++
+		if args[i-1] == "-count" {
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("-count needs a positive number, not %s", args[i])
+			}
+			count = n
+			continue
+		}
+		if _, err := time.ParseDuration(args[i]); err != nil && !benchtimeRe.MatchString(args[i]) {
+			return fmt.Errorf("-benchtime needs a duration or a number of iterations such as 100x, not %s", args[i])
+		}
+		benchtime = args[i]
+	}
+	if strings.TrimSpace(body) == "" {
+		return errors.New("no code to benchmark")
+	}
+	if !ctx.Session.Runtime() {
+		return errNoRuntime
+	}
+
+	code := body
+	if expr, err := parser.ParseExpr(code); err == nil {
+		if _, ok := expr.(*ast.CallExpr); !ok {
+			code = "_ = " + code
+		}
+	}
+	if !usesIdent(code, "b") {
+		code = "for gophernotesN := 0; gophernotesN < b.N; gophernotesN++ {\n" + code + "\n}"
+	}
+	delete(ctx.ReplyMetadata, "benchmark")
+	if err := ctx.Run(fmt.Sprintf(":import testing\ngophernotes.Benchmark(func(b *testing.B) {\n%s\n}, %d, %q)", code, count, benchtime)); err != nil {
+		return err
+	}
+
+	results := benchmarkResults(ctx.ReplyMetadata["benchmark"])
+	if len(results) == 0 {
+		return nil
+	}
+	k := ctx.Kernel
+	k.benchmarks = append(k.benchmarks, benchmarkRun{Count: k.execCount, Code: body, Results: results})
+	ctx.Display(benchmarkTable(results), nil)
+	return nil
+}
+
+// usesIdent reports whether the identifier name appears in code, outside of
+// strings and comments.
+func usesIdent(code, name string) bool {
+	fset := token.NewFileSet()
+	var sc scanner.Scanner
+	sc.Init(fset.AddFile("", -1, len(code)), []byte(code), nil, 0)
+	for {
+		_, tok, lit := sc.Scan()
+		switch {
+		case tok == token.EOF:
+			return false
+		case tok == token.IDENT && lit == name:
+			return true
+		}
+	}
+}
+
+// benchmarkResults decodes the results in the metadata the gophernotes
+// package reports.
+func benchmarkResults(v interface{}) []benchmarkResult {
+	list, _ := v.([]interface{})
+	var results []benchmarkResult
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var r benchmarkResult
+		r.Name, _ = m["name"].(string)
+		n, _ := m["n"].(float64)
+		r.NsPerOp, _ = m["ns_per_op"].(float64)
+		bytes, _ := m["bytes_per_op"].(float64)
+		allocs, _ := m["allocs_per_op"].(float64)
+		r.N, r.BytesPerOp, r.AllocsPerOp = int(n), int64(bytes), int64(allocs)
+		results = append(results, r)
+	}
+	return results
+}
+
+// benchmarkTable returns the table summing up results, as HTML and as text,
+// with the mean of the runs when there are several.
+func benchmarkTable(results []benchmarkResult) map[string]interface{} {
+	rows := [][]string{{"run", "iterations", "time/op", "B/op", "allocs/op"}}
+	var ns float64
+	var bytesPerOp, allocs int64
+	for i, r := range results {
+		rows = append(rows, []string{strconv.Itoa(i + 1), strconv.Itoa(r.N), formatNsPerOp(r.NsPerOp), strconv.FormatInt(r.BytesPerOp, 10), strconv.FormatInt(r.AllocsPerOp, 10)})
+		ns += r.NsPerOp
+		bytesPerOp += r.BytesPerOp
+		allocs += r.AllocsPerOp
+	}
+	if n := len(results); n > 1 {
+		rows = append(rows, []string{"mean", "", formatNsPerOp(ns / float64(n)), strconv.FormatInt(bytesPerOp/int64(n), 10), strconv.FormatInt(allocs/int64(n), 10)})
+	}
+
+	var text, h bytes.Buffer
+	w := tabwriter.NewWriter(&text, 0, 8, 2, ' ', tabwriter.AlignRight)
+	h.WriteString("<table>\n")
+	for i, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t")+"\t")
+		cell := "td"
+		if i == 0 {
+			cell = "th"
+		}
+		h.WriteString("<tr>")
+		for _, v := range row {
+			fmt.Fprintf(&h, "<%s>%s</%s>", cell, html.EscapeString(v), cell)
+		}
+		h.WriteString("</tr>\n")
+	}
+	h.WriteString("</table>")
+	w.Flush()
+	return map[string]interface{}{"text/plain": text.String(), "text/html": h.String()}
+}
+
+// formatNsPerOp formats a number of nanoseconds per operation with the unit
+// keeping it short.
+func formatNsPerOp(ns float64) string {
+	switch {
+	case ns < 1e3:
+		return fmt.Sprintf("%.4g ns", ns)
+	case ns < 1e6:
+		return fmt.Sprintf("%.4g µs", ns/1e3)
+	case ns < 1e9:
+		return fmt.Sprintf("%.4g ms", ns/1e6)
+	}
+	return fmt.Sprintf("%.4g s", ns/1e9)
+}
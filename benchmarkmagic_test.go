@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUsesIdent tests that identifiers are found in code, but not in strings
+// and comments
+func TestUsesIdent(t *testing.T) {
+	assert.True(t, usesIdent("for i := 0; i < b.N; i++ {}", "b"))
+	assert.False(t, usesIdent("s := \"b\" // b\nbb := 1", "b"))
+}
+
+// TestBenchmarkMagic tests that %%benchmark runs the cell as a benchmark,
+// b.N times unless it uses b, printing its runs as go test does, summing them
+// up and keeping them
+func TestBenchmarkMagic(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+	defer c.execute("%reset -f")
+
+	c.execute("benchS := []int{3, 1, 2}")
+	reply, published := c.execute("%%benchmark -count 2 -benchtime 100x\n_ = append([]int(nil), benchS...)")
+	content := reply.Content.(map[string]interface{})
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	assert.Regexp(t, `^(BenchmarkCell(-\d+)?\t +100\t +\d.* ns/op\t +\d+ B/op\t +1 allocs/op\n){2}$`, streamText(published, "stdout"))
+	if msgs := msgTypes(published); assert.NotEmpty(t, msgs) && assert.Equal(t, "display_data", msgs[len(msgs)-1]) {
+		table := published[len(published)-1].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"]
+		assert.Contains(t, table, "mean")
+	}
+
+	runs := testKernel.benchmarks
+	if assert.NotEmpty(t, runs) {
+		last := runs[len(runs)-1]
+		assert.Equal(t, "_ = append([]int(nil), benchS...)", last.Code)
+		if assert.Len(t, last.Results, 2) {
+			assert.Equal(t, 100, last.Results[0].N)
+			assert.Equal(t, int64(1), last.Results[0].AllocsPerOp)
+		}
+	}
+
+	reply, published = c.execute("%%benchmark -benchtime 10x\nfor i := 0; i < b.N; i++ {\n\tbenchS[0]++\n}")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Regexp(t, `^BenchmarkCell(-\d+)?\t +10\t`, streamText(published, "stdout"))
+
+	reply, _ = c.execute("%%benchmark -benchtime soon\nbenchS")
+	assert.Equal(t, "%%benchmark: -benchtime needs a duration or a number of iterations such as 100x, not soon", reply.Content.(map[string]interface{})["evalue"])
+}
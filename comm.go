@@ -0,0 +1,226 @@
+package main
+
+import (
+	"sync"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// CommHandler handles the messages a comm receives from the frontend.
+type CommHandler interface {
+	// Receive handles a comm_msg, with its decoded data and raw buffers.
+	Receive(c *Comm, data map[string]interface{}, buffers [][]byte)
+
+	// Closed handles the comm_close of the frontend. The comm is closed
+	// already, and nothing can be sent on it anymore.
+	Closed(c *Comm, data map[string]interface{}, buffers [][]byte)
+}
+
+// A CommTarget returns the handler of a comm the frontend opens with its
+// target name, given the data and buffers of the comm_open message, or nil to
+// refuse the comm.
+type CommTarget func(c *Comm, data map[string]interface{}, buffers [][]byte) CommHandler
+
+// commRegistry holds the open comms of a kernel, by ID, and the targets comms
+// may be opened with, by name. Cell code registers targets too.
+type commRegistry struct {
+	sync.Mutex
+	m       map[string]*Comm
+	targets map[string]CommTarget
+}
+
+// init sets up the registry with the targets of the kernel itself.
+func (r *commRegistry) init() {
+	r.m = map[string]*Comm{}
+	r.targets = map[string]CommTarget{
+		"echo": func(*Comm, map[string]interface{}, [][]byte) CommHandler { return echoComm{} },
+
+		"jupyter.widget.version": openVersionComm,
+	}
+}
+
+// RegisterCommTarget makes comms opened by the frontend with name handled by
+// the handlers target returns, in place of those of the target registered
+// before with name, if any.
+func (k *Kernel) RegisterCommTarget(name string, target CommTarget) {
+	k.comms.Lock()
+	k.comms.targets[name] = target
+	k.comms.Unlock()
+}
+
+// Comm is one end of a comm, a channel of messages between the kernel and the
+// frontend. Its methods publish on the IOPub socket, which is not safe for
+// concurrent use: they are meant to be called while handling messages.
+type Comm struct {
+	ID     string
+	Target string
+
+	// receipt is that of the last message received on the comm, or of the
+	// one during which the kernel opened it, to which the messages sent
+	// are replies.
+	receipt MsgReceipt
+	handler CommHandler
+	kernel  *Kernel
+}
+
+// OpenComm opens a comm with target on the frontend, as part of handling the
+// message of receipt, and returns it. Messages the frontend sends on the comm
+// go to handler, which must not be nil.
+func (k *Kernel) OpenComm(receipt MsgReceipt, target string, data map[string]interface{}, handler CommHandler) *Comm {
+	u, err := uuid.NewV4()
+	if err != nil {
+		k.logger.Println("Could not generate comm ID:", err)
+		return nil
+	}
+	c := &Comm{ID: u.String(), Target: target, receipt: receipt, handler: handler, kernel: k}
+	k.comms.Lock()
+	k.comms.m[c.ID] = c
+	k.comms.Unlock()
+
+	c.publish("comm_open", map[string]interface{}{
+		"comm_id":     c.ID,
+		"target_name": target,
+		"data":        commData(data),
+	}, nil)
+	return c
+}
+
+// Send sends data to the frontend in a comm_msg, along with buffers of binary
+// data, which travel as frames of their own rather than in JSON.
+func (c *Comm) Send(data map[string]interface{}, buffers ...[]byte) {
+	c.publish("comm_msg", map[string]interface{}{
+		"comm_id": c.ID,
+		"data":    commData(data),
+	}, buffers)
+}
+
+// Close closes the comm, sending data to the frontend in a comm_close.
+func (c *Comm) Close(data map[string]interface{}) {
+	c.kernel.comms.Lock()
+	delete(c.kernel.comms.m, c.ID)
+	c.kernel.comms.Unlock()
+	c.publish("comm_close", map[string]interface{}{
+		"comm_id": c.ID,
+		"data":    commData(data),
+	}, nil)
+}
+
+func (c *Comm) publish(msgType string, content map[string]interface{}, buffers [][]byte) {
+	msg := NewMsg(msgType, c.receipt.Msg)
+	msg.Content = content
+	msg.Buffers = buffers
+	c.receipt.SendResponse(c.receipt.Sockets.IOPubSocket, msg)
+}
+
+// commData returns data, or an empty map if it is nil, as the data of comm
+// messages is always an object.
+func commData(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return map[string]interface{}{}
+	}
+	return data
+}
+
+// commContent returns the comm ID and data of the comm message of receipt.
+func commContent(receipt MsgReceipt) (id string, data map[string]interface{}, content map[string]interface{}) {
+	content, _ = receipt.Msg.Content.(map[string]interface{})
+	id, _ = content["comm_id"].(string)
+	data, _ = content["data"].(map[string]interface{})
+	return id, commData(data), content
+}
+
+// HandleCommOpen opens the comm of a comm_open from the frontend, with the
+// handler its target returns. Comms with an unknown target, or refused by
+// their target, are closed right away, as the protocol asks.
+func (k *Kernel) HandleCommOpen(receipt MsgReceipt) {
+	id, data, content := commContent(receipt)
+	name, _ := content["target_name"].(string)
+	c := &Comm{ID: id, Target: name, receipt: receipt, kernel: k}
+
+	k.comms.Lock()
+	target, ok := k.comms.targets[name]
+	k.comms.Unlock()
+	if ok {
+		c.handler = target(c, data, receipt.Msg.Buffers)
+	} else {
+		k.logger.Println("Unknown comm target:", name)
+	}
+	if c.handler == nil {
+		c.publish("comm_close", map[string]interface{}{
+			"comm_id": id,
+			"data":    map[string]interface{}{},
+		}, nil)
+		return
+	}
+	k.comms.Lock()
+	k.comms.m[id] = c
+	k.comms.Unlock()
+}
+
+// HandleCommMsg passes a comm_msg to the handler of its comm. Messages for
+// comms that are not open are ignored, as the protocol asks.
+func (k *Kernel) HandleCommMsg(receipt MsgReceipt) {
+	id, data, _ := commContent(receipt)
+	k.comms.Lock()
+	c, ok := k.comms.m[id]
+	k.comms.Unlock()
+	if !ok {
+		k.logger.Println("Message for unknown comm:", id)
+		return
+	}
+	c.receipt = receipt
+	c.handler.Receive(c, data, receipt.Msg.Buffers)
+}
+
+// HandleCommClose tears down the comm of a comm_close from the frontend.
+func (k *Kernel) HandleCommClose(receipt MsgReceipt) {
+	id, data, _ := commContent(receipt)
+	k.comms.Lock()
+	c, ok := k.comms.m[id]
+	delete(k.comms.m, id)
+	k.comms.Unlock()
+	if !ok {
+		return
+	}
+	c.receipt = receipt
+	c.handler.Closed(c, data, receipt.Msg.Buffers)
+}
+
+// commInfo returns the open comms with target, or all of them if target is
+// empty, as the comms of a comm_info_reply. The comms are those open as the
+// registry is read: one closing meanwhile is either left out, or listed and
+// then sent its comm_close.
+func (k *Kernel) commInfo(target string) map[string]interface{} {
+	k.comms.Lock()
+	defer k.comms.Unlock()
+	info := map[string]interface{}{}
+	for id, c := range k.comms.m {
+		if target == "" || c.Target == target {
+			info[id] = map[string]interface{}{"target_name": c.Target}
+		}
+	}
+	return info
+}
+
+// HandleCommInfoRequest replies to a comm_info_request with the open comms,
+// those with the target_name of the request if it has one.
+func (k *Kernel) HandleCommInfoRequest(receipt MsgReceipt) {
+	content, _ := receipt.Msg.Content.(map[string]interface{})
+	target, _ := content["target_name"].(string)
+	reply := NewMsg("comm_info_reply", receipt.Msg)
+	reply.Content = map[string]interface{}{
+		"status": "ok",
+		"comms":  k.commInfo(target),
+	}
+	receipt.SendResponse(receipt.Sockets.ShellSocket, reply)
+}
+
+// echoComm is the handler of the comms of the "echo" target, which sends back
+// every message it receives.
+type echoComm struct{}
+
+func (echoComm) Receive(c *Comm, data map[string]interface{}, buffers [][]byte) {
+	c.Send(data, buffers...)
+}
+
+func (echoComm) Closed(*Comm, map[string]interface{}, [][]byte) {}
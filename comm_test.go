@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	zmq "github.com/alecthomas/gozmq"
+	"github.com/stretchr/testify/assert"
+)
+
+// recvReply receives iopub messages until one replying to req arrives, and
+// returns it along with the number of messages replying to others of ignored.
+// The statuses framing the requests are left out.
+func (c *testClient) recvReply(req ComposedMsg, ignored ...ComposedMsg) (ComposedMsg, int) {
+	unexpected := 0
+	for {
+		msg := c.recv(c.iopub)
+		if msg.Header.MsgType == "status" {
+			continue
+		}
+		if msg.ParentHeader.MsgID == req.Header.MsgID {
+			return msg, unexpected
+		}
+		for _, other := range ignored {
+			if msg.ParentHeader.MsgID == other.Header.MsgID {
+				unexpected++
+			}
+		}
+	}
+}
+
+// TestComm_echo tests that comms opened by the frontend get their messages,
+// buffers included, routed to the handler of their target, and that messages
+// for comms not open are ignored
+func TestComm_echo(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	c.send("comm_open", map[string]interface{}{"comm_id": "echo-1", "target_name": "echo", "data": map[string]interface{}{}})
+	unknown := c.send("comm_msg", map[string]interface{}{"comm_id": "nope", "data": map[string]interface{}{"x": 1}})
+	req := c.sendBuffers("comm_msg", map[string]interface{}{"comm_id": "echo-1", "data": map[string]interface{}{"x": 2}}, [][]byte{[]byte("raw")})
+
+	msg, unexpected := c.recvReply(req, unknown)
+	assert.Equal(t, 0, unexpected)
+	assert.Equal(t, "comm_msg", msg.Header.MsgType)
+	content := msg.Content.(map[string]interface{})
+	assert.Equal(t, "echo-1", content["comm_id"])
+	assert.Equal(t, map[string]interface{}{"x": 2.0}, content["data"])
+	assert.Equal(t, [][]byte{[]byte("raw")}, msg.Buffers)
+
+	// Once closed, the comm gets no more messages.
+	c.send("comm_close", map[string]interface{}{"comm_id": "echo-1", "data": map[string]interface{}{}})
+	closed := c.send("comm_msg", map[string]interface{}{"comm_id": "echo-1", "data": map[string]interface{}{}})
+	c.send("comm_open", map[string]interface{}{"comm_id": "echo-2", "target_name": "echo", "data": map[string]interface{}{}})
+	req = c.send("comm_msg", map[string]interface{}{"comm_id": "echo-2", "data": map[string]interface{}{}})
+	_, unexpected = c.recvReply(req, closed)
+	assert.Equal(t, 0, unexpected)
+	c.send("comm_close", map[string]interface{}{"comm_id": "echo-2", "data": map[string]interface{}{}})
+}
+
+// TestComm_unknownTarget tests that comms opened with an unknown target are
+// closed right away
+func TestComm_unknownTarget(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	req := c.send("comm_open", map[string]interface{}{"comm_id": "lost-1", "target_name": "no.such.target", "data": map[string]interface{}{}})
+	msg, _ := c.recvReply(req)
+	assert.Equal(t, "comm_close", msg.Header.MsgType)
+	assert.Equal(t, "lost-1", msg.Content.(map[string]interface{})["comm_id"])
+}
+
+// TestOpenComm tests that comms opened by the kernel publish their opening,
+// messages and closing on iopub
+func TestOpenComm(t *testing.T) {
+	ctx, err := zmq.NewContext()
+	noError(t, err)
+	pub, err := ctx.NewSocket(zmq.PUB)
+	noError(t, err)
+	defer pub.Close()
+	address := fmt.Sprintf("tcp://127.0.0.1:%d", freePort(t))
+	noError(t, pub.Bind(address))
+	sub, err := ctx.NewSocket(zmq.SUB)
+	noError(t, err)
+	defer sub.Close()
+	noError(t, sub.SetSockOptString(zmq.SUBSCRIBE, ""))
+	noError(t, sub.Connect(address))
+	time.Sleep(100 * time.Millisecond)
+
+	key := []byte("test-key")
+	c := &testClient{t: t, iopub: sub, key: key}
+	var parent ComposedMsg
+	parent.Header = MsgHeader{MsgID: "parent", MsgType: "execute_request"}
+	receipt := MsgReceipt{Msg: parent, Sockets: SocketGroup{IOPubSocket: pub, Key: key, sends: newSendLocks()}}
+	k := &Kernel{}
+	k.comms.init()
+
+	comm := k.OpenComm(receipt, "test.target", map[string]interface{}{"a": 1}, echoComm{})
+	msg := c.recv(sub)
+	assert.Equal(t, "comm_open", msg.Header.MsgType)
+	assert.Equal(t, "parent", msg.ParentHeader.MsgID)
+	assert.Equal(t, map[string]interface{}{
+		"comm_id":     comm.ID,
+		"target_name": "test.target",
+		"data":        map[string]interface{}{"a": 1.0},
+	}, msg.Content)
+
+	comm.Send(nil, []byte{1, 2})
+	msg = c.recv(sub)
+	assert.Equal(t, "comm_msg", msg.Header.MsgType)
+	assert.Equal(t, map[string]interface{}{}, msg.Content.(map[string]interface{})["data"])
+	assert.Equal(t, [][]byte{[]byte{1, 2}}, msg.Buffers)
+
+	comm.Close(nil)
+	msg = c.recv(sub)
+	assert.Equal(t, "comm_close", msg.Header.MsgType)
+	k.comms.Lock()
+	_, open := k.comms.m[comm.ID]
+	k.comms.Unlock()
+	assert.False(t, open)
+}
+
+// commInfoReply sends a comm_info_request for target, if not empty, and
+// returns the comms of the reply.
+func (c *testClient) commInfoReply(target string) map[string]interface{} {
+	content := map[string]interface{}{}
+	if target != "" {
+		content["target_name"] = target
+	}
+	req := c.send("comm_info_request", content)
+	for {
+		reply := c.recv(c.shell)
+		if reply.ParentHeader.MsgID == req.Header.MsgID {
+			assert.Equal(c.t, "comm_info_reply", reply.Header.MsgType)
+			return reply.Content.(map[string]interface{})["comms"].(map[string]interface{})
+		}
+	}
+}
+
+// TestCommInfo tests that comm_info_request lists the open comms, filtered by
+// target when asked to, leaving out those closed before
+func TestCommInfo(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	assert.Empty(t, c.commInfoReply("echo"))
+
+	testKernel.RegisterCommTarget("test.info", func(*Comm, map[string]interface{}, [][]byte) CommHandler { return echoComm{} })
+	c.send("comm_open", map[string]interface{}{"comm_id": "info-1", "target_name": "echo", "data": map[string]interface{}{}})
+	c.send("comm_open", map[string]interface{}{"comm_id": "info-2", "target_name": "test.info", "data": map[string]interface{}{}})
+	c.send("comm_open", map[string]interface{}{"comm_id": "info-3", "target_name": "echo", "data": map[string]interface{}{}})
+	c.send("comm_close", map[string]interface{}{"comm_id": "info-3", "data": map[string]interface{}{}})
+	defer c.send("comm_close", map[string]interface{}{"comm_id": "info-1", "data": map[string]interface{}{}})
+	defer c.send("comm_close", map[string]interface{}{"comm_id": "info-2", "data": map[string]interface{}{}})
+
+	all := c.commInfoReply("")
+	assert.Equal(t, map[string]interface{}{"target_name": "echo"}, all["info-1"])
+	assert.Equal(t, map[string]interface{}{"target_name": "test.info"}, all["info-2"])
+	assert.NotContains(t, all, "info-3")
+
+	assert.Equal(t, map[string]interface{}{
+		"info-2": map[string]interface{}{"target_name": "test.info"},
+	}, c.commInfoReply("test.info"))
+}
+
+// TestCommInfo_closing tests that a comm closing while the open comms are
+// listed is either left out or listed whole
+func TestCommInfo_closing(t *testing.T) {
+	k := &Kernel{}
+	k.comms.init()
+	c := &Comm{ID: "closing", Target: "echo", kernel: k}
+	k.comms.Lock()
+	k.comms.m[c.ID] = c
+	k.comms.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		k.comms.Lock()
+		delete(k.comms.m, c.ID)
+		k.comms.Unlock()
+		close(done)
+	}()
+	info := k.commInfo("echo")
+	<-done
+	if entry, ok := info["closing"]; ok {
+		assert.Equal(t, map[string]interface{}{"target_name": "echo"}, entry)
+	}
+	assert.NotContains(t, k.commInfo("echo"), "closing")
+}
+
+// float64Bytes encodes xs as little-endian IEEE 754 numbers.
+func float64Bytes(xs []float64) []byte {
+	b := make([]byte, 8*len(xs))
+	for i, x := range xs {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(x))
+	}
+	return b
+}
+
+// TestComm_largeBuffers tests that buffers of several megabytes go through an
+// echo comm intact and in order
+func TestComm_largeBuffers(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	xs := make([]float64, 1<<19)
+	for i := range xs {
+		xs[i] = math.Sin(float64(i)) * 1e6
+	}
+	buffers := [][]byte{float64Bytes(xs), {}, float64Bytes(xs[:1000]), []byte("tail")}
+
+	c.send("comm_open", map[string]interface{}{"comm_id": "large-1", "target_name": "echo", "data": map[string]interface{}{}})
+	defer c.send("comm_close", map[string]interface{}{"comm_id": "large-1", "data": map[string]interface{}{}})
+	req := c.sendBuffers("comm_msg", map[string]interface{}{
+		"comm_id": "large-1",
+		"data":    map[string]interface{}{"dtype": "float64", "shape": []interface{}{len(xs)}},
+	}, buffers)
+
+	msg, _ := c.recvReply(req)
+	assert.Equal(t, "comm_msg", msg.Header.MsgType)
+	if !assert.Len(t, msg.Buffers, len(buffers)) {
+		return
+	}
+	for i := range buffers {
+		assert.True(t, string(buffers[i]) == string(msg.Buffers[i]), "buffer %d differs", i)
+	}
+	got := msg.Buffers[0]
+	for i, x := range xs {
+		if y := math.Float64frombits(binary.LittleEndian.Uint64(got[8*i:])); y != x {
+			t.Fatalf("element %d is %v instead of %v", i, y, x)
+		}
+	}
+}
+
+// TestComm_cellTarget tests that comms the frontend opens with a target
+// registered by cell code reach it, messages going both ways, buffers
+// included, and that registering the target again replaces it
+func TestComm_cellTarget(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	reply, published := c.execute(`gophernotes.RegisterCommTarget("test.cell", func(c *gophernotes.Comm, data map[string]interface{}) {
+	c.Send(map[string]interface{}{"opened": data["n"]})
+	c.OnMsg(func(data map[string]interface{}, buffers [][]byte) {
+		if data["close"] == true {
+			c.Close(nil)
+			return
+		}
+		c.Send(map[string]interface{}{"got": data["x"]}, buffers...)
+	})
+	c.OnClose(func(data map[string]interface{}) {
+		gophernotes.Display(data["bye"])
+	})
+})`)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Empty(t, published)
+
+	req := c.send("comm_open", map[string]interface{}{"comm_id": "cell-1", "target_name": "test.cell", "data": map[string]interface{}{"n": 1}})
+	msg, _ := c.recvReply(req)
+	assert.Equal(t, "comm_msg", msg.Header.MsgType)
+	assert.Equal(t, map[string]interface{}{"opened": 1.0}, msg.Content.(map[string]interface{})["data"])
+
+	req = c.sendBuffers("comm_msg", map[string]interface{}{"comm_id": "cell-1", "data": map[string]interface{}{"x": 2}}, [][]byte{[]byte("raw")})
+	msg, _ = c.recvReply(req)
+	assert.Equal(t, "comm_msg", msg.Header.MsgType)
+	assert.Equal(t, "cell-1", msg.Content.(map[string]interface{})["comm_id"])
+	assert.Equal(t, map[string]interface{}{"got": 2.0}, msg.Content.(map[string]interface{})["data"])
+	assert.Equal(t, [][]byte{[]byte("raw")}, msg.Buffers)
+
+	// The comm is handed to its target again on later runs, silently.
+	_, published = c.execute(`x := 1`)
+	assert.Empty(t, published)
+
+	req = c.send("comm_close", map[string]interface{}{"comm_id": "cell-1", "data": map[string]interface{}{"bye": "closed"}})
+	msg, _ = c.recvReply(req)
+	assert.Equal(t, "display_data", msg.Header.MsgType)
+	assert.Equal(t, "closed", msg.Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	assert.Empty(t, c.commInfoReply("test.cell"))
+
+	c.execute(`gophernotes.RegisterCommTarget("test.cell", func(c *gophernotes.Comm, data map[string]interface{}) {
+	c.Send(map[string]interface{}{"again": true})
+	c.OnMsg(func(data map[string]interface{}, buffers [][]byte) {
+		c.Close(nil)
+	})
+})`)
+	req = c.send("comm_open", map[string]interface{}{"comm_id": "cell-2", "target_name": "test.cell", "data": map[string]interface{}{}})
+	msg, _ = c.recvReply(req)
+	assert.Equal(t, map[string]interface{}{"again": true}, msg.Content.(map[string]interface{})["data"])
+
+	// Cell code closing the comm closes it for the kernel too.
+	req = c.send("comm_msg", map[string]interface{}{"comm_id": "cell-2", "data": map[string]interface{}{}})
+	msg, _ = c.recvReply(req)
+	assert.Equal(t, "comm_close", msg.Header.MsgType)
+	assert.Empty(t, c.commInfoReply("test.cell"))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+)
+
+// CompleteReply holds the content of a complete_reply message. It carries both
+// the matched_text of version 4 of the protocol and the cursor range of later
+// versions.
+type CompleteReply struct {
+	Status      string                 `json:"status"`
+	Matches     []string               `json:"matches"`
+	MatchedText string                 `json:"matched_text"`
+	CursorStart int                    `json:"cursor_start"`
+	CursorEnd   int                    `json:"cursor_end"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+// completionType is an entry of the _jupyter_types_experimental metadata
+// through which JupyterLab shows the kind of each match. Import, which
+// frontends ignore, names the package the match needs imported.
+type completionType struct {
+	Start     int    `json:"start"`
+	End       int    `json:"end"`
+	Text      string `json:"text"`
+	Type      string `json:"type"`
+	Signature string `json:"signature,omitempty"`
+	Import    string `json:"import,omitempty"`
+}
+
+// jupyterTypes maps the kinds of completions to the types JupyterLab knows
+// icons for.
+var jupyterTypes = map[string]string{
+	"func":      "function",
+	"method":    "function",
+	"var":       "instance",
+	"const":     "instance",
+	"type":      "class",
+	"package":   "module",
+	"directory": "module",
+	"keyword":   "keyword",
+	"field":     "field",
+	"snippet":   "statement",
+	"file":      "path",
+	"magic":     "keyword",
+}
+
+// magicPrefixRe matches the start of a line invoking a magic, up to the
+// cursor, capturing the name typed so far with its % or %%.
+var magicPrefixRe = regexp.MustCompile(`^[ \t]*(%%?\w*)$`)
+
+// HandleCompleteRequest answers a complete_request with the completions of
+// the token at the cursor.
+func (k *Kernel) HandleCompleteRequest(receipt MsgReceipt) {
+	content := receipt.Msg.Content.(map[string]interface{})
+
+	// Version 4 of the protocol sends the line being edited, with the cursor
+	// position in it; later versions send the whole cell.
+	code, ok := content["code"].(string)
+	if !ok {
+		code, _ = content["line"].(string)
+	}
+	pos, _ := content["cursor_pos"].(float64)
+	cursor, units := cursorOffset(code, int(pos))
+
+	completions, start, end, ok := completeMagic(k.magics, code, cursor)
+	if !ok {
+		completions, start, end = k.readSessionOf(receipt).Complete(code, cursor)
+	}
+	msg := NewMsg("complete_reply", receipt.Msg)
+	msg.Content = newCompleteReply(code, cursor, units, completions, start, end)
+	receipt.SendResponse(receipt.Sockets.ShellSocket, msg)
+}
+
+// completeMagic returns the completions of the name of the magic the line of
+// code at cursor invokes, if it invokes one and the cursor is on its name, and
+// the range of the name. Cell magics complete on the first line of the cell
+// only, where they are invoked. The magics are those of magics.
+func completeMagic(magics *magicRegistry, code string, cursor int) (completions []repl.Completion, start, end int, ok bool) {
+	lineStart := strings.LastIndexByte(code[:cursor], '\n') + 1
+	m := magicPrefixRe.FindStringSubmatch(code[lineStart:cursor])
+	if m == nil {
+		return nil, 0, 0, false
+	}
+	start, end = cursor-len(m[1]), cursor
+	for end < len(code) && isWordByte(code[end]) {
+		end++
+	}
+
+	magics.Lock()
+	completions = magicCompletions(magics.line, "%", m[1])
+	if lineStart == 0 {
+		completions = append(completions, magicCompletions(magics.cell, "%%", m[1])...)
+	}
+	magics.Unlock()
+	return completions, start, end, true
+}
+
+// magicCompletions returns the completions of the magics of registry, invoked
+// with prefix, whose name starts with typed, detailed with their summary.
+func magicCompletions(registry map[string]magic, prefix, typed string) []repl.Completion {
+	var completions []repl.Completion
+	for _, name := range magicNames(registry, prefix) {
+		if strings.HasPrefix(name, typed) {
+			completions = append(completions, repl.Completion{
+				Text:   name,
+				Kind:   "magic",
+				Detail: registry[strings.TrimPrefix(name, prefix)].summary(),
+			})
+		}
+	}
+	return completions
+}
+
+// isWordByte reports whether b may be part of the name of a magic.
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// newCompleteReply builds the reply for completions of code at cursor, which
+// replace the bytes from start to end, its cursor range counted in units. The
+// entries of the type metadata are parallel to the matches.
+func newCompleteReply(code string, cursor int, units cursorUnits, completions []repl.Completion, start, end int) CompleteReply {
+	reply := CompleteReply{
+		Status:      "ok",
+		Matches:     []string{},
+		MatchedText: code[start:cursor],
+		CursorStart: cursorPos(code, start, units),
+		CursorEnd:   cursorPos(code, end, units),
+	}
+	types := []completionType{}
+	for _, c := range completions {
+		t, ok := jupyterTypes[c.Kind]
+		if !ok {
+			t = "instance"
+		}
+		reply.Matches = append(reply.Matches, c.Text)
+		types = append(types, completionType{
+			Start:     reply.CursorStart,
+			End:       reply.CursorEnd,
+			Text:      c.Text,
+			Type:      t,
+			Signature: c.Detail,
+			Import:    c.Import,
+		})
+	}
+	reply.Metadata = map[string]interface{}{"_jupyter_types_experimental": types}
+	return reply
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+	"github.com/stretchr/testify/assert"
+)
+
+// completionTexts returns the texts of completions.
+func completionTexts(completions []repl.Completion) []string {
+	texts := make([]string, len(completions))
+	for i, c := range completions {
+		texts[i] = c.Text
+	}
+	return texts
+}
+
+// TestComplete_identifiers tests that session variables, keywords and builtins
+// complete, with the range of the token replaced
+func TestComplete_identifiers(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	_, _, err = s.Eval("counter := 1\nconst limit = 3")
+	noError(t, err)
+
+	completions, start, end := s.Complete("x := co", 7)
+	assert.Equal(t, 5, start)
+	assert.Equal(t, 7, end)
+	texts := completionTexts(completions)
+	assert.Contains(t, texts, "counter")
+	assert.Contains(t, texts, "const")
+	assert.Contains(t, texts, "continue")
+	assert.Contains(t, texts, "complex")
+	assert.NotContains(t, texts, "limit")
+
+	// The whole token under the cursor is replaced.
+	_, start, end = s.Complete("fmt.Println(lim + 1)", 15)
+	assert.Equal(t, 12, start)
+	assert.Equal(t, 15, end)
+
+	completions, _, _ = s.Complete("l", 1)
+	assert.Contains(t, completionTexts(completions), "limit")
+	assert.Contains(t, completionTexts(completions), "len")
+}
+
+// TestComplete_fuzzy tests that names complete from a subsequence of their
+// runes when few of them begin with the token
+func TestComplete_fuzzy(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	_, _, err = s.Eval("handleConnection := 1\nhandled := 2")
+	noError(t, err)
+
+	completions, _, _ := s.Complete("hndl", 4)
+	assert.Equal(t, []string{"handleConnection", "handled"}, completionTexts(completions)[:2])
+
+	completions = waitCompletions(s, "x := os.readf")
+	assert.Contains(t, completionTexts(completions), "ReadFile")
+}
+
+// TestComplete_emptyPrefix tests that an empty prefix only offers the names of
+// the session and of the cell
+func TestComplete_emptyPrefix(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	_, _, err = s.Eval("total := 0")
+	noError(t, err)
+
+	completions, _, _ := s.Complete("item := 2\n", 10)
+	texts := completionTexts(completions)
+	assert.Contains(t, texts, "total")
+	assert.Contains(t, texts, "item")
+	assert.Contains(t, texts, "fmt")
+	assert.NotContains(t, texts, "break")
+	assert.NotContains(t, texts, "main")
+	assert.True(t, len(texts) < 20, "got %d completions", len(texts))
+}
+
+// TestComplete_literals tests that nothing completes inside strings and
+// comments
+func TestComplete_literals(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	for _, code := range []string{`x := "co`, `x := "co"`[:8], "// co", "/* co */"[:5]} {
+		completions, _, _ := s.Complete(code, len(code))
+		assert.Empty(t, completions, code)
+	}
+	completions, _, _ := s.Complete(`x := "a" + co`, 13)
+	assert.NotEmpty(t, completions)
+}
+
+// TestNewCompleteReply tests that the type metadata of a completion reply is
+// parallel to its matches, with the kinds of completions mapped to JupyterLab
+// types
+func TestNewCompleteReply(t *testing.T) {
+	code := "x := é.pr"
+	completions := []repl.Completion{
+		{Text: "print", Kind: "func", Detail: "func print(args ...Type)"},
+		{Text: "proc", Kind: "var"},
+		{Text: "proto", Kind: "package", Import: "example.com/proto"},
+		{Text: "promise", Kind: "keyword"},
+	}
+	reply := newCompleteReply(code, len(code), codePoints, completions, len(code)-2, len(code))
+
+	assert.Equal(t, []string{"print", "proc", "proto", "promise"}, reply.Matches)
+	assert.Equal(t, "pr", reply.MatchedText)
+	assert.Equal(t, 7, reply.CursorStart)
+	assert.Equal(t, 9, reply.CursorEnd)
+	assert.Equal(t, []completionType{
+		{Start: 7, End: 9, Text: "print", Type: "function", Signature: "func print(args ...Type)"},
+		{Start: 7, End: 9, Text: "proc", Type: "instance"},
+		{Start: 7, End: 9, Text: "proto", Type: "module", Import: "example.com/proto"},
+		{Start: 7, End: 9, Text: "promise", Type: "keyword"},
+	}, reply.Metadata["_jupyter_types_experimental"])
+}
+
+// TestComplete_packageMembers tests that the exported members of imported and
+// importable packages complete after their name, exact-case matches first
+func TestComplete_packageMembers(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	_, _, err = s.Eval(`import "strings"`)
+	noError(t, err)
+
+	// Packages may take a moment to load the first time.
+	var completions []repl.Completion
+	for i := 0; i < 100 && len(completions) == 0; i++ {
+		completions, _, _ = s.Complete("strings.Spl", 11)
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, text := range []string{"Split", "SplitAfter", "SplitAfterN", "SplitN"} {
+		assert.Contains(t, completionTexts(completions), text)
+	}
+	assert.Equal(t, "Split", completions[0].Text)
+	assert.Equal(t, "func", completions[0].Kind)
+	assert.Equal(t, "func Split(s string, sep string) []string", completions[0].Detail)
+	assert.Equal(t, "", completions[0].Import)
+
+	completions, start, end := s.Complete("strings.b", 9)
+	assert.Equal(t, 8, start)
+	assert.Equal(t, 9, end)
+	texts := completionTexts(completions)
+	assert.Contains(t, texts, "Builder")
+	for _, text := range texts {
+		assert.NotEqual(t, "bytealg", text)
+	}
+
+	completions = nil
+	for i := 0; i < 100 && len(completions) == 0; i++ {
+		completions, _, _ = s.Complete("time.Sec", 8)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if assert.NotEmpty(t, completions) {
+		assert.Equal(t, "Second", completions[0].Text)
+		assert.Equal(t, "time", completions[0].Import)
+	}
+
+	// rand is both math/rand and crypto/rand.
+	completions, _, _ = s.Complete("rand.In", 7)
+	assert.Empty(t, completions)
+}
+
+// waitCompletions completes code at its end until something besides keywords
+// and snippets completes, as the session and packages may take a moment to
+// load the first time.
+func waitCompletions(s *repl.Session, code string) []repl.Completion {
+	var completions []repl.Completion
+	for i := 0; i < 100 && !hasLoadedCompletions(completions); i++ {
+		completions, _, _ = s.Complete(code, len(code))
+		time.Sleep(10 * time.Millisecond)
+	}
+	return completions
+}
+
+// hasLoadedCompletions reports whether completions has any that the session
+// or its packages offer, rather than only keywords and snippets.
+func hasLoadedCompletions(completions []repl.Completion) bool {
+	for _, c := range completions {
+		if c.Kind != "keyword" && c.Kind != "snippet" {
+			return true
+		}
+	}
+	return false
+}
+
+// TestComplete_valueMembers tests that the fields and methods of session
+// variables complete from their static types
+func TestComplete_valueMembers(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	for _, code := range []string{
+		`import "os"`,
+		`import "io"`,
+		`import "net/http"`,
+		`f, _ := os.Open("/nonexistent")`,
+		"type inner struct{ Depth, hidden int }",
+		"type outer struct { inner; Name string }",
+		"o := outer{}",
+		`var r io.Reader = os.Stdin`,
+		"resp := &http.Response{}",
+	} {
+		_, _, err := s.Eval(code)
+		noError(t, err)
+	}
+
+	texts := completionTexts(waitCompletions(s, "f."))
+	for _, text := range []string{"Read", "Close", "Name"} {
+		assert.Contains(t, texts, text)
+	}
+
+	completions := waitCompletions(s, "o.")
+	assert.Equal(t, []string{"Depth", "Name", "hidden", "inner"}, completionTexts(completions))
+	assert.Equal(t, "field", completions[0].Kind)
+	assert.Equal(t, "field Depth int", completions[0].Detail)
+
+	assert.Equal(t, []string{"Read"}, completionTexts(waitCompletions(s, "r.")))
+
+	// Chained selectors resolve one level at a time.
+	texts = completionTexts(waitCompletions(s, "resp.Body."))
+	assert.Equal(t, []string{"Close", "Read"}, texts)
+	completions, _, _ = s.Complete("resp.Body.Cl", 12)
+	assert.Equal(t, []string{"Close"}, completionTexts(completions))
+}
+
+// TestComplete_importPaths tests that import paths complete inside the strings
+// of import declarations, replacing the partial path
+func TestComplete_importPaths(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+
+	for _, code := range []string{`import "enc`, "import (\n\t\"fmt\"\n\t\"enc", ":import enc"} {
+		completions := waitCompletions(s, code)
+		texts := completionTexts(completions)
+		assert.Contains(t, texts, "encoding/json", code)
+		assert.Contains(t, texts, "encoding/csv", code)
+		assert.NotContains(t, texts, "fmt", code)
+	}
+
+	completions, start, end := s.Complete(`import "encoding/js"`, 18)
+	assert.Equal(t, 8, start)
+	assert.Equal(t, 19, end)
+	if assert.NotEmpty(t, completions) {
+		assert.Equal(t, "encoding/json", completions[0].Text)
+	}
+	assert.NotContains(t, completionTexts(completions), "encoding/csv")
+
+	// Strings elsewhere do not complete.
+	completions, _, _ = s.Complete(`x := "enc`, 9)
+	assert.Empty(t, completions)
+}
+
+// TestComplete_moduleCache tests that import paths complete from the module
+// cache a directory at a time
+func TestComplete_moduleCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophernotes_modcache")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+	for _, p := range []string{"github.com/!burnt!sushi/toml@v1.2.0/internal", "cache/download"} {
+		noError(t, os.MkdirAll(filepath.Join(dir, p), 0755))
+	}
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "github.com/!burnt!sushi/toml@v1.2.0/internal/tz.go"), nil, 0644))
+	os.Setenv("GOMODCACHE", dir)
+	defer os.Unsetenv("GOMODCACHE")
+
+	s, err := repl.NewSession()
+	noError(t, err)
+	for code, want := range map[string]string{
+		`import "github.com/Bu`:                         "github.com/BurntSushi/",
+		`import "github.com/BurntSushi/`:                "github.com/BurntSushi/toml",
+		`import "github.com/BurntSushi/toml/i`:          "github.com/BurntSushi/toml/internal",
+		`import "github.com/BurntSushi/toml/internal/x`: "",
+	} {
+		completions, _, _ := s.Complete(code, len(code))
+		var texts []string
+		for _, text := range completionTexts(completions) {
+			if strings.HasPrefix(text, "github.com/BurntSushi") {
+				texts = append(texts, text)
+			}
+		}
+		if want == "" {
+			assert.Empty(t, texts, code)
+			continue
+		}
+		assert.Equal(t, []string{want}, texts, code)
+	}
+	completions, _, _ := s.Complete(`import "cac`, 11)
+	assert.NotContains(t, completionTexts(completions), "cache/")
+}
+
+// TestComplete_literalFields tests that the fields of struct literals not set
+// yet complete as keys
+func TestComplete_literalFields(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	for _, code := range []string{
+		`import "net/http"`,
+		"type point struct{ X, Y int; label string }",
+		"p := point{}",
+	} {
+		_, _, err := s.Eval(code)
+		noError(t, err)
+	}
+
+	texts := completionTexts(waitCompletions(s, "c := &http.Client{T"))
+	// Fields come in the order of the struct.
+	assert.Equal(t, []string{"Transport: ", "Timeout: "}, texts[:2])
+
+	completions := waitCompletions(s, "q := point{X: 1, ")
+	assert.Equal(t, []string{"Y: ", "label: "}, completionTexts(completions)[:2])
+	assert.Equal(t, "field", completions[0].Kind)
+
+	texts = completionTexts(waitCompletions(s, "ps := []point{{X: 1}, {l"))
+	assert.Equal(t, "label: ", texts[0])
+	texts = completionTexts(waitCompletions(s, "m := map[string]point{\"a\": {\n\tY: 2,\n\t"))
+	assert.Equal(t, []string{"X: ", "label: "}, texts[:2])
+}
+
+// TestComplete_snippets tests that snippets expand from their trigger,
+// indented like the line typed, after the other completions
+func TestComplete_snippets(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	_, _, err = s.Eval("iface := 1")
+	noError(t, err)
+
+	code := "f := func() error {\n\tif"
+	completions, _, _ := s.Complete(code, len(code))
+	texts := completionTexts(completions)
+	assert.Equal(t, []string{"iface", "if"}, texts[:2])
+	last := completions[len(completions)-1]
+	assert.Equal(t, "snippet", last.Kind)
+	assert.Equal(t, "if err != nil {\n\t\treturn err\n\t}", last.Text)
+
+	completions, _, _ = s.Complete("forr", 4)
+	texts = completionTexts(completions)
+	assert.Equal(t, []string{"for i, v := range xs {\n\t\n}"}, texts)
+
+	// Builtins and predeclared identifiers complete too.
+	completions, _, _ = s.Complete("ap", 2)
+	assert.Contains(t, completionTexts(completions), "append")
+	completions, _, _ = s.Complete("io", 2)
+	assert.Contains(t, completionTexts(completions), "iota")
+}
+
+// TestComplete_typesCached tests that the types of the session are checked
+// again only once a cell has changed it
+func TestComplete_typesCached(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	_, _, err = s.Eval(`greeting := "hello"`)
+	noError(t, err)
+
+	details := func(code string) map[string]string {
+		details := map[string]string{}
+		for i := 0; i < 100 && len(details) == 0; i++ {
+			completions, _, _ := s.Complete(code, len(code))
+			for _, c := range completions {
+				if c.Detail != "" {
+					details[c.Text] = c.Detail
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return details
+	}
+	assert.Equal(t, "var greeting string", details("gre")["greeting"])
+
+	_, _, err = s.Eval("greetings := []string{greeting}")
+	noError(t, err)
+	assert.Equal(t, "var greetings []string", details("greetings")["greetings"])
+}
+
+// BenchmarkComplete measures completions against a warm cache, for a session
+// of 50 variables using 10 packages: each should take well under 20ms.
+func BenchmarkComplete(b *testing.B) {
+	s, err := repl.NewSession()
+	if err != nil {
+		b.Fatal(err)
+	}
+	exprs := []string{
+		`strings.Repeat("a", %d)`, `strconv.Itoa(%d)`, `time.Duration(%d)`,
+		`math.Sqrt(%d)`, `bytes.NewBufferString("%d")`, `errors.New("%d")`,
+		`fmt.Sprint(%d)`, `sort.IntSlice{%d}`, `os.FileMode(%d)`, `io.LimitReader(nil, %d)`,
+	}
+	var cell []string
+	for _, pkg := range []string{"bytes", "errors", "fmt", "io", "math", "os", "sort", "strconv", "strings", "time"} {
+		cell = append(cell, fmt.Sprintf("import %q", pkg))
+	}
+	for i := 0; i < 50; i++ {
+		cell = append(cell, fmt.Sprintf("v%d := "+exprs[i%len(exprs)], i, i))
+	}
+	_, stderr, err := s.Eval(strings.Join(cell, "\n"))
+	if err != nil {
+		b.Fatal(err, stderr.String())
+	}
+
+	codes := []string{"x := v1", "x := v4.", "x := strings.Rep", "x := tim", "x := hndl"}
+	for _, code := range codes {
+		waitCompletions(s, code)
+	}
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		code := codes[i%len(codes)]
+		s.Complete(code, len(code))
+	}
+	if d := time.Since(start) / time.Duration(b.N); d > 20*time.Millisecond {
+		b.Errorf("completion took %s", d)
+	}
+}
+
+// TestComplete_filePaths tests that paths complete from the filesystem inside
+// string literals, replacing the last element of the path
+func TestComplete_filePaths(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+
+	dir, err := ioutil.TempDir("", "gophernotes-paths")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"data.csv", "data.json", ".hidden", "notes.txt"} {
+		noError(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	noError(t, os.Mkdir(filepath.Join(dir, "datasets"), 0755))
+
+	code := `f, err := os.Open("` + dir + `/da")`
+	cursor := strings.Index(code, "/da") + 3
+	completions, start, end := s.Complete(code, cursor)
+	assert.Equal(t, []string{"data.csv", "data.json", "datasets/"}, completionTexts(completions))
+	assert.Equal(t, cursor-2, start)
+	assert.Equal(t, cursor, end)
+
+	code = `gophernotes.DisplayFile("` + dir + `/`
+	completions, _, _ = s.Complete(code, len(code))
+	assert.NotContains(t, completionTexts(completions), ".hidden")
+	assert.Contains(t, completionTexts(completions), "notes.txt")
+	code = `gophernotes.DisplayFile("` + dir + `/.`
+	completions, _, _ = s.Complete(code, len(code))
+	assert.Equal(t, []string{".hidden"}, completionTexts(completions))
+
+	// Strings not looking like paths, and paths outside strings, do not
+	// complete from the filesystem.
+	completions, _, _ = s.Complete(`x := "da`, 8)
+	assert.Empty(t, completions)
+	code = "x := " + dir + "/da"
+	completions, _, _ = s.Complete(code, len(code))
+	assert.NotContains(t, completionTexts(completions), "data.csv")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterLineMagic("config", "%config [<option> [<value>]]", "show or set the options of the session\nWithout an option, all of them are listed with their values.", configMagic)
+}
+
+// configOption is an option of the session, shown and set with %config. Its
+// value is kept in the options of the kernel, which get reads and set sets.
+type configOption struct {
+	doc string
+	get func(c *kernelConfig) string
+	set func(c *kernelConfig, value string) error
+}
+
+// configOptions are the registered options, by name.
+var configOptions = struct {
+	sync.Mutex
+	m map[string]configOption
+}{m: map[string]configOption{}}
+
+// registerConfig makes the option name, described by doc, shown by %config
+// with get and set with set, which is called with the value given.
+func registerConfig(name, doc string, get func(c *kernelConfig) string, set func(c *kernelConfig, value string) error) {
+	configOptions.Lock()
+	configOptions.m[name] = configOption{doc, get, set}
+	configOptions.Unlock()
+}
+
+// registerBoolConfig registers the option name, turned on and off by setting
+// it to on or off, whose value is kept in the field of the options field
+// returns.
+func registerBoolConfig(name, doc string, field func(c *kernelConfig) *bool) {
+	registerConfig(name, doc, func(c *kernelConfig) string {
+		if *field(c) {
+			return "on"
+		}
+		return "off"
+	}, func(c *kernelConfig, value string) error {
+		switch strings.ToLower(value) {
+		case "on", "true", "1":
+			*field(c) = true
+		case "off", "false", "0":
+			*field(c) = false
+		default:
+			return fmt.Errorf("%s is on or off, not %s", name, value)
+		}
+		return nil
+	})
+}
+
+// registerIntConfig registers the option name, set to a number that is not
+// negative, whose value is kept in the field of the options field returns.
+func registerIntConfig(name, doc string, field func(c *kernelConfig) *int) {
+	registerConfig(name, doc, func(c *kernelConfig) string {
+		return strconv.Itoa(*field(c))
+	}, func(c *kernelConfig, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%s is a number that is not negative, not %s", name, value)
+		}
+		*field(c) = n
+		return nil
+	})
+}
+
+// configMagic lists the options of the session with their values and what
+// they do, shows the value of the option given, or sets it to the value given.
+func configMagic(ctx *MagicContext, args []string, body string) error {
+	configOptions.Lock()
+	defer configOptions.Unlock()
+	config := &ctx.Kernel.config
+	config.Lock()
+	defer config.Unlock()
+
+	if len(args) == 0 {
+		var names []string
+		for name := range configOptions.m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var buf bytes.Buffer
+		for _, name := range names {
+			o := configOptions.m[name]
+			fmt.Fprintf(&buf, "%s = %s\n    %s\n", name, o.get(&config.kernelConfig), o.doc)
+		}
+		ctx.Stream("stdout", buf.String())
+		return nil
+	}
+
+	o, ok := configOptions.m[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown option %s", args[0])
+	}
+	switch len(args) {
+	case 1:
+		ctx.Stream("stdout", fmt.Sprintf("%s = %s\n", args[0], o.get(&config.kernelConfig)))
+		return nil
+	case 2:
+		return o.set(&config.kernelConfig, args[1])
+	}
+	return errors.New("too many arguments")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConfigMagic tests that %config lists the options of the session, shows
+// and sets them, and rejects unknown options and values
+func TestConfigMagic(t *testing.T) {
+	var on bool
+	registerBoolConfig("testoption", "an option for the test", func(*kernelConfig) *bool { return &on })
+	defer func() {
+		configOptions.Lock()
+		delete(configOptions.m, "testoption")
+		configOptions.Unlock()
+	}()
+
+	c := newTestClient(t)
+	defer c.Close()
+
+	_, published := c.execute("%config")
+	assert.Contains(t, streamText(published, "stdout"), "testoption = off\n    an option for the test\n")
+	reply, _ := c.execute("%config testoption on")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.True(t, on)
+	_, published = c.execute("%config testoption")
+	assert.Equal(t, "testoption = on\n", streamText(published, "stdout"))
+
+	for _, code := range []string{"%config testoption maybe", "%config nosuchoption", "%config testoption on off"} {
+		reply, _ := c.execute(code)
+		assert.Equal(t, "error", reply.Content.(map[string]interface{})["status"], code)
+	}
+}
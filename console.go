@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+)
+
+// runConsole runs the console subcommand with args: a REPL reading cells from
+// in and running them in a kernel of its own without sockets, as gophernotes
+// run does, showing what they output on out as text. Lines are read until the
+// cell is complete, as is_complete_request tells. Interrupting the console
+// stops the cell running rather than the console, which exits at the end of
+// its input, or once a cell runs :quit.
+func runConsole(args []string, in io.Reader, out io.Writer) error {
+	config := defaultConfig()
+	var noHistoryFile bool
+	flags := flag.NewFlagSet("console", flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.BoolVar(&noHistoryFile, "no-history-file", false, "Do not keep the history of the cells run, nor recall those of earlier sessions")
+	flags.BoolVar(&config.noColor, "no-color", config.noColor, "Do not color tracebacks")
+	flags.BoolVar(&config.keepTemp, "keep-temp", false, "Leave the temporary directory of the kernel, with the images written, behind on exit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: gophernotes console [flags]")
+	}
+	if !noHistoryFile {
+		path, err := defaultHistoryPath()
+		if err != nil {
+			fmt.Fprintln(out, "Not keeping the history:", err)
+		}
+		config.historyPath = path
+	}
+
+	// Tracebacks are only colored on terminals, where the lines of earlier
+	// cells are recalled too.
+	editor := newLineEditor(in, out)
+	if !editor.editing {
+		config.noColor = true
+	}
+	c, err := newConsole(out, config)
+	if err != nil {
+		return err
+	}
+	defer c.kernel.removeSession()
+	if config.keepTemp {
+		defer fmt.Fprintln(out, "Kept the temporary directory", c.kernel.tempDir)
+	}
+	if mc := c.kernel.session.ModuleContext(); mc.File != "" {
+		fmt.Fprintln(out, "Imports resolve in", mc)
+	}
+	if editor.editing {
+		for _, session := range c.kernel.pastSessions() {
+			for _, e := range session {
+				for _, line := range strings.Split(e.Code, "\n") {
+					editor.addHistory(line)
+				}
+			}
+		}
+	}
+
+	// Interrupts reach the cell running, in the process group of the
+	// console, and the commands it runs, which stop; the console goes on.
+	// Those of a cell that does not stop dump its stacks, then kill it, as
+	// in a notebook.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer func() {
+		signal.Stop(interrupts)
+		close(interrupts)
+	}()
+	go func() {
+		for range interrupts {
+			c.kernel.interrupt()
+		}
+	}()
+	for !c.quit {
+		code, err := c.readCell(editor)
+		switch err {
+		case nil:
+		case errLineInterrupted:
+			continue
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+		c.kernel.HandleExecuteRequest(MsgReceipt{Msg: newExecuteRequest(code), Sockets: c.kernel.sockets})
+	}
+	return nil
+}
+
+// consoleImageTypes are the types of the images the console writes to files,
+// with the extension of the files.
+var consoleImageTypes = []struct {
+	mimeType, ext string
+}{
+	{"image/png", ".png"},
+	{"image/jpeg", ".jpg"},
+	{"image/gif", ".gif"},
+	{"image/svg+xml", ".svg"},
+}
+
+// console shows what the cells of its kernel publish as text, as they
+// publish it.
+type console struct {
+	kernel *Kernel
+
+	// mu guards what follows: messages are shown from several goroutines
+	// at once.
+	mu  sync.Mutex
+	out io.Writer
+
+	// images is the directory the images displayed are written to, made
+	// in the temporary directory of the kernel for the first of them, and
+	// removed along with it once the console is done; written counts them.
+	images  string
+	written int
+
+	// quit is set once a cell ran :quit.
+	quit bool
+}
+
+// newConsole returns a console showing output on out, with a kernel of the
+// options of config.
+func newConsole(out io.Writer, config kernelConfig) (*console, error) {
+	c := &console{out: out}
+	sockets := SocketGroup{deliver: c.show}
+	k, err := NewKernel(sockets, log.New(ioutil.Discard, "", 0), config)
+	if err != nil {
+		return nil, err
+	}
+	c.kernel = k
+	return c, nil
+}
+
+// readCell reads the lines of a cell until it is complete, prompting for them
+// as Jupyter consoles do. The lines going on with a cell start with its
+// indentation; a cell still incomplete at the end of the input runs as it is.
+func (c *console) readCell(editor *lineEditor) (string, error) {
+	prompt := fmt.Sprintf("In [%d]: ", c.kernel.execCount+1)
+	more := strings.Repeat(" ", len(prompt)-5) + "...: "
+	var lines []string
+	indent := ""
+	for {
+		line, err := editor.readLine(prompt, indent)
+		if err == io.EOF && len(lines) > 0 {
+			return strings.Join(lines, "\n"), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+		code := strings.Join(lines, "\n")
+		reply := cellCompleteness(code)
+		if reply.Status != "incomplete" || strings.TrimSpace(code) == "" {
+			return code, nil
+		}
+		prompt, indent = more, reply.Indent
+	}
+}
+
+// show shows msg, sent by the kernel: streams as they are written, results and
+// displays as text, images written to files, and tracebacks.
+func (c *console) show(msg ComposedMsg) {
+	content := contentMap(msg.Content)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch msg.Header.MsgType {
+	case "stream":
+		text, ok := content["text"].(string)
+		if !ok {
+			text, _ = content["data"].(string)
+		}
+		io.WriteString(c.out, text)
+	case "display_data":
+		c.showData(content)
+	case "pyout", "execute_result":
+		fmt.Fprintf(c.out, "Out[%v]: ", content["execution_count"])
+		c.showData(content)
+	case "pyerr", "error":
+		if content["evalue"] == repl.ErrQuit.Error() {
+			c.quit = true
+			return
+		}
+		traceback, _ := content["traceback"].([]interface{})
+		if len(traceback) == 0 {
+			traceback = []interface{}{fmt.Sprintf("%v: %v", content["ename"], content["evalue"])}
+		}
+		for _, line := range traceback {
+			fmt.Fprintln(c.out, line)
+		}
+	}
+}
+
+// showData shows the text of the data of content, a display or a result, and
+// writes its images to files, telling where they went.
+func (c *console) showData(content map[string]interface{}) {
+	data, _ := content["data"].(map[string]interface{})
+	if text, ok := data["text/plain"].(string); ok {
+		io.WriteString(c.out, text)
+		if !strings.HasSuffix(text, "\n") {
+			io.WriteString(c.out, "\n")
+		}
+	}
+	for _, t := range consoleImageTypes {
+		encoded, ok := data[t.mimeType].(string)
+		if !ok {
+			continue
+		}
+		path, err := c.writeImage(t.mimeType, t.ext, encoded)
+		if err != nil {
+			fmt.Fprintf(c.out, "Could not write the %s image: %s\n", t.mimeType, err)
+			continue
+		}
+		fmt.Fprintf(c.out, "%s written to %s\n", t.mimeType, path)
+	}
+}
+
+// writeImage writes the image of type mimeType, encoded as in MIME bundles, to
+// a file of the images directory named with ext, and returns its path. The
+// lock is held.
+func (c *console) writeImage(mimeType, ext, encoded string) (string, error) {
+	b := []byte(encoded)
+	if mimeType != "image/svg+xml" {
+		var err error
+		if b, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return "", err
+		}
+	}
+	if c.images == "" {
+		dir, err := ioutil.TempDir(c.kernel.tempDir, "images")
+		if err != nil {
+			return "", err
+		}
+		c.images = dir
+	}
+	c.written++
+	path := filepath.Join(c.images, fmt.Sprintf("image%d%s", c.written, ext))
+	return path, ioutil.WriteFile(path, b, 0644)
+}
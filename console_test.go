@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"go/importer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConsole tests that the console runs the cells it reads as they are
+// complete, in a session kept from one to the next, showing their output as
+// text and writing their images to files, until a cell quits
+func TestConsole(t *testing.T) {
+	if _, err := importer.Default().Import("github.com/gopherds/gophernotes/gophernotes"); err != nil {
+		t.Skip("gophernotes package not installed:", err)
+	}
+	input := strings.Join([]string{
+		"consoleX := 40",
+		"if consoleX > 0 {",
+		"\tconsoleX++",
+		"}",
+		"",
+		"consoleX + 1",
+		"%%bash",
+		"echo from bash",
+		"",
+		"!echo from the shell",
+		":import image",
+		"gophernotes.Display(image.NewGray(image.Rect(0, 0, 2, 3)))",
+		"consoleUndefined",
+		":quit",
+		"consoleX",
+	}, "\n")
+	var out bytes.Buffer
+	noError(t, runConsole([]string{"--no-history-file", "--keep-temp"}, strings.NewReader(input), &out))
+	output := out.String()
+
+	assert.Contains(t, output, "Out[3]: 42\n")
+	assert.Contains(t, output, "from bash\n")
+	assert.Contains(t, output, "from the shell\n")
+	assert.Contains(t, output, "undefined: consoleUndefined")
+	assert.NotContains(t, output, "\x1b[", "tracebacks are not colored off terminals")
+	assert.NotContains(t, output, "Out[9]", "the cells after :quit do not run")
+	assert.Contains(t, output, "Kept the temporary directory ")
+
+	m := regexp.MustCompile(`image\.Gray 2x3\nimage/png written to (.*)\n`).FindStringSubmatch(output)
+	if assert.NotNil(t, m, output) {
+		defer os.RemoveAll(filepath.Dir(filepath.Dir(m[1])))
+		b, err := ioutil.ReadFile(m[1])
+		noError(t, err)
+		assert.True(t, bytes.HasPrefix(b, []byte("\x89PNG")))
+	}
+}
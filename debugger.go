@@ -0,0 +1,629 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gopherds/gophernotes/gophernotes"
+)
+
+// debugHashSeed is the seed of the hashes of cell code the sources of cells
+// are named after, as the frontend works them out too; see debugSourcePath.
+const debugHashSeed = 0xc70f6907
+
+// The scopes of the variables shown at a breakpoint, as variables references:
+// those the cell declares, and those earlier cells declared at their top
+// level.
+const (
+	localsReference  = 1
+	globalsReference = 2
+)
+
+// debugger is the state of the debugger of the frontend, which speaks the
+// Debug Adapter Protocol through debug_request messages on the control socket,
+// and debug_event messages the kernel publishes.
+//
+// Cells run with breakpoints set have a call to gophernotes.Breakpoint
+// inserted before each line with one, which publishes the variables in scope
+// there and waits for the debugger to go on; see instrumentBreakpoints. The
+// cell is run as a single thread whose stack has the cell as its only frame.
+type debugger struct {
+	sync.Mutex
+
+	// seq numbers the messages the kernel sends the debugger, and started
+	// is set while the debugger is attached.
+	seq     int
+	started bool
+
+	// sources are the code of the cells dumped by the debugger, and
+	// breakpoints the lines the debugger set breakpoints on, both by the
+	// path of the source of the cell.
+	sources     map[string]string
+	breakpoints map[string][]int
+
+	// running is the path of the source of the cell running with
+	// breakpoints, globals the variables of earlier cells passed to its
+	// breakpoints, by name, and stop the breakpoint the cell is stopped at,
+	// if it is.
+	running string
+	globals map[string]bool
+	stop    *debugStop
+}
+
+// debugStop is a breakpoint a cell stopped at.
+type debugStop struct {
+	Stop      int                         `json:"stop"`
+	Line      int                         `json:"line"`
+	Variables []gophernotes.DebugVariable `json:"variables"`
+
+	// source is the path of the source of the cell.
+	source string
+}
+
+// debugRequest is the content of a debug_request message.
+type debugRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// debugCommands are the commands of the debugger the kernel handles, which
+// return the body of the response, or the error making it fail. The others
+// fail as not supported.
+var debugCommands = map[string]func(k *Kernel, receipt MsgReceipt, args json.RawMessage) (interface{}, error){
+	"initialize":        (*Kernel).debugInitialize,
+	"attach":            (*Kernel).debugAttach,
+	"disconnect":        (*Kernel).debugDisconnect,
+	"debugInfo":         (*Kernel).debugInfo,
+	"dumpCell":          (*Kernel).debugDumpCell,
+	"setBreakpoints":    (*Kernel).debugSetBreakpoints,
+	"configurationDone": func(*Kernel, MsgReceipt, json.RawMessage) (interface{}, error) { return nil, nil },
+	"threads":           (*Kernel).debugThreads,
+	"continue":          (*Kernel).debugContinue,
+	"stackTrace":        (*Kernel).debugStackTrace,
+	"scopes":            (*Kernel).debugScopes,
+	"variables":         (*Kernel).debugVariables,
+	"source":            (*Kernel).debugSource,
+}
+
+// HandleDebugRequest answers a debug_request message with a debug_reply on
+// the control socket. The commands of debugCommands succeed or fail as they
+// do, and the others fail as not supported, for the frontend to do without.
+func (k *Kernel) HandleDebugRequest(receipt MsgReceipt) {
+	var req debugRequest
+	b, err := json.Marshal(receipt.Msg.Content)
+	if err == nil {
+		err = json.Unmarshal(b, &req)
+	}
+	if err != nil {
+		k.logger.Println("Invalid debug request:", err)
+		return
+	}
+
+	var body interface{}
+	command, ok := debugCommands[req.Command]
+	if ok {
+		body, err = command(k, receipt, req.Arguments)
+	} else {
+		err = fmt.Errorf("%s is not supported", req.Command)
+	}
+	content := map[string]interface{}{
+		"seq":         k.debugSeq(),
+		"type":        "response",
+		"request_seq": req.Seq,
+		"success":     err == nil,
+		"command":     req.Command,
+	}
+	if err != nil {
+		content["message"] = err.Error()
+	}
+	if body == nil {
+		body = map[string]interface{}{}
+	}
+	content["body"] = body
+
+	reply := NewMsg("debug_reply", receipt.Msg)
+	reply.Content = content
+	receipt.SendResponse(receipt.Sockets.ControlSocket, reply)
+}
+
+// debugSeq returns the sequence number of the next message to the debugger.
+func (k *Kernel) debugSeq() int {
+	k.debugger.Lock()
+	defer k.debugger.Unlock()
+	k.debugger.seq++
+	return k.debugger.seq
+}
+
+// sendDebugEvent publishes the debug event named event, with body, as part of
+// handling the message of receipt.
+func (k *Kernel) sendDebugEvent(receipt MsgReceipt, event string, body interface{}) {
+	msg := NewMsg("debug_event", receipt.Msg)
+	msg.Content = map[string]interface{}{
+		"seq":   k.debugSeq(),
+		"type":  "event",
+		"event": event,
+		"body":  body,
+	}
+	receipt.SendResponse(receipt.Sockets.IOPubSocket, msg)
+}
+
+func (k *Kernel) debugInitialize(MsgReceipt, json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"supportsConfigurationDoneRequest": true,
+		"supportsSetVariable":              false,
+		"supportsConditionalBreakpoints":   false,
+		"supportsEvaluateForHovers":        false,
+		"supportsStepBack":                 false,
+		"supportsTerminateRequest":         false,
+	}, nil
+}
+
+func (k *Kernel) debugAttach(MsgReceipt, json.RawMessage) (interface{}, error) {
+	if err := os.MkdirAll(filepath.Join(k.files.debug, "cells"), 0755); err != nil {
+		return nil, err
+	}
+	k.debugger.Lock()
+	k.debugger.started = true
+	k.debugger.Unlock()
+	return nil, nil
+}
+
+// debugDisconnect detaches the debugger, clearing its breakpoints and letting
+// the cell stopped at one, if any, go on.
+func (k *Kernel) debugDisconnect(MsgReceipt, json.RawMessage) (interface{}, error) {
+	k.debugger.Lock()
+	k.debugger.started = false
+	k.debugger.breakpoints = nil
+	stop := k.debugger.stop
+	k.debugger.stop = nil
+	k.debugger.Unlock()
+	if stop != nil {
+		return nil, k.releaseStop(stop)
+	}
+	return nil, nil
+}
+
+func (k *Kernel) debugInfo(MsgReceipt, json.RawMessage) (interface{}, error) {
+	k.debugger.Lock()
+	defer k.debugger.Unlock()
+	paths := make([]string, 0, len(k.debugger.breakpoints))
+	for path := range k.debugger.breakpoints {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	breakpoints := make([]interface{}, len(paths))
+	for i, path := range paths {
+		lines := make([]interface{}, len(k.debugger.breakpoints[path]))
+		for j, line := range k.debugger.breakpoints[path] {
+			lines[j] = map[string]interface{}{"line": line}
+		}
+		breakpoints[i] = map[string]interface{}{"source": path, "breakpoints": lines}
+	}
+	stopped := []int{}
+	if k.debugger.stop != nil {
+		stopped = append(stopped, 1)
+	}
+	return map[string]interface{}{
+		"isStarted":      k.debugger.started,
+		"hashMethod":     "Murmur2",
+		"hashSeed":       debugHashSeed,
+		"tmpFilePrefix":  filepath.Join(k.files.debug, "cells") + string(filepath.Separator),
+		"tmpFileSuffix":  ".go",
+		"breakpoints":    breakpoints,
+		"stoppedThreads": stopped,
+		"richRendering":  false,
+		"exceptionPaths": []string{},
+	}, nil
+}
+
+// debugDumpCell writes the code of a cell to its source, for the debugger to
+// set breakpoints in.
+func (k *Kernel) debugDumpCell(_ MsgReceipt, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	path := k.debugSourcePath(a.Code)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, []byte(a.Code), 0644); err != nil {
+		return nil, err
+	}
+	k.debugger.Lock()
+	if k.debugger.sources == nil {
+		k.debugger.sources = map[string]string{}
+	}
+	k.debugger.sources[path] = a.Code
+	k.debugger.Unlock()
+	return map[string]interface{}{"sourcePath": path}, nil
+}
+
+// debugSetBreakpoints sets the breakpoints of a source, in place of those set
+// before. Those on lines not starting a statement of the cell, or in sources
+// not dumped, are not verified, and are never stopped at.
+func (k *Kernel) debugSetBreakpoints(_ MsgReceipt, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	path := a.Source.Path
+
+	k.debugger.Lock()
+	defer k.debugger.Unlock()
+	code, ok := k.debugger.sources[path]
+	var cell *cellAnalysis
+	if ok {
+		cell = analyzeCell(code)
+	}
+	lines := make([]int, 0, len(a.Breakpoints))
+	breakpoints := make([]interface{}, len(a.Breakpoints))
+	for i, bp := range a.Breakpoints {
+		lines = append(lines, bp.Line)
+		verified := cell != nil && cell.starts[bp.Line] != nil
+		breakpoint := map[string]interface{}{
+			"verified": verified,
+			"line":     bp.Line,
+			"source":   map[string]interface{}{"path": path},
+		}
+		if !verified {
+			breakpoint["message"] = "no statement starts on the line"
+		}
+		breakpoints[i] = breakpoint
+	}
+	if k.debugger.breakpoints == nil {
+		k.debugger.breakpoints = map[string][]int{}
+	}
+	if len(lines) == 0 {
+		delete(k.debugger.breakpoints, path)
+	} else {
+		k.debugger.breakpoints[path] = lines
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+func (k *Kernel) debugThreads(MsgReceipt, json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"threads": []interface{}{map[string]interface{}{"id": 1, "name": "cell"}},
+	}, nil
+}
+
+// debugContinue lets the cell stopped at a breakpoint go on.
+func (k *Kernel) debugContinue(receipt MsgReceipt, _ json.RawMessage) (interface{}, error) {
+	k.debugger.Lock()
+	stop := k.debugger.stop
+	k.debugger.stop = nil
+	k.debugger.Unlock()
+	if stop != nil {
+		if err := k.releaseStop(stop); err != nil {
+			return nil, err
+		}
+		k.sendDebugEvent(receipt, "continued", map[string]interface{}{"threadId": 1, "allThreadsContinued": true})
+	}
+	return map[string]interface{}{"allThreadsContinued": true}, nil
+}
+
+// releaseStop lets the cell stopped at stop go on.
+func (k *Kernel) releaseStop(stop *debugStop) error {
+	return ioutil.WriteFile(filepath.Join(k.files.debug, fmt.Sprintf("continue-%d", stop.Stop)), nil, 0644)
+}
+
+func (k *Kernel) debugStackTrace(MsgReceipt, json.RawMessage) (interface{}, error) {
+	k.debugger.Lock()
+	defer k.debugger.Unlock()
+	frames := []interface{}{}
+	if stop := k.debugger.stop; stop != nil {
+		frames = append(frames, map[string]interface{}{
+			"id":     1,
+			"name":   "cell",
+			"line":   stop.Line,
+			"column": 1,
+			"source": map[string]interface{}{"path": stop.source},
+		})
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+func (k *Kernel) debugScopes(MsgReceipt, json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"scopes": []interface{}{
+			map[string]interface{}{"name": "Locals", "presentationHint": "locals", "variablesReference": localsReference, "expensive": false},
+			map[string]interface{}{"name": "Globals", "variablesReference": globalsReference, "expensive": false},
+		},
+	}, nil
+}
+
+// debugVariables lists the variables of a scope of the breakpoint the cell is
+// stopped at: those the cell declares, or those of earlier cells.
+func (k *Kernel) debugVariables(_ MsgReceipt, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+
+	k.debugger.Lock()
+	defer k.debugger.Unlock()
+	variables := []interface{}{}
+	if stop := k.debugger.stop; stop != nil {
+		for _, v := range stop.Variables {
+			if k.debugger.globals[v.Name] != (a.VariablesReference == globalsReference) {
+				continue
+			}
+			variables = append(variables, map[string]interface{}{
+				"name":               v.Name,
+				"value":              v.Value,
+				"type":               v.Type,
+				"evaluateName":       v.Name,
+				"variablesReference": 0,
+			})
+		}
+	}
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+func (k *Kernel) debugSource(_ MsgReceipt, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	k.debugger.Lock()
+	defer k.debugger.Unlock()
+	code, ok := k.debugger.sources[a.Source.Path]
+	if !ok {
+		return nil, fmt.Errorf("no source %s", a.Source.Path)
+	}
+	return map[string]interface{}{"content": code}, nil
+}
+
+// debugStopped records the breakpoint the cell running for receipt stopped
+// at, as the display relay read it, and tells the debugger.
+func (k *Kernel) debugStopped(receipt MsgReceipt, content json.RawMessage) {
+	stop := &debugStop{}
+	if err := json.Unmarshal(content, stop); err != nil {
+		k.logger.Println("Invalid breakpoint stop:", err)
+		return
+	}
+	k.debugger.Lock()
+	stop.source = k.debugger.running
+	k.debugger.stop = stop
+	k.debugger.Unlock()
+	k.sendDebugEvent(receipt, "stopped", map[string]interface{}{
+		"reason":            "breakpoint",
+		"threadId":          1,
+		"allThreadsStopped": true,
+	})
+}
+
+// debugSourcePath returns the path of the source of the cell of code: a file
+// of the debug directory named after the Murmur2 hash of the code, as the
+// frontend works it out from the prefix, seed and suffix of debugInfo.
+func (k *Kernel) debugSourcePath(code string) string {
+	name := strconv.FormatUint(uint64(murmur2([]byte(code), debugHashSeed)), 10) + ".go"
+	return filepath.Join(k.files.debug, "cells", name)
+}
+
+// murmur2 returns the 32-bit MurmurHash2 of data.
+func murmur2(data []byte, seed uint32) uint32 {
+	const m = 0x5bd1e995
+	h := seed ^ uint32(len(data))
+	for ; len(data) >= 4; data = data[4:] {
+		k := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
+		k *= m
+		k ^= k >> 24
+		k *= m
+		h *= m
+		h ^= k
+	}
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// cellAnalysis is the code of a cell type checked as the body of a function,
+// as the session runs it, for breakpoints to be set on the lines starting
+// statements along with the variables in scope there.
+type cellAnalysis struct {
+	pkg *types.Package
+
+	// starts are the statements starting lines of the cell, by line.
+	starts map[int]ast.Stmt
+}
+
+// analyzeCell parses and type checks code as the statements of a cell,
+// without the lines the session handles itself, such as imports. It returns
+// nil if the cell does not parse as statements, as when it declares functions
+// or types.
+func analyzeCell(code string) *cellAnalysis {
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(line, "import") || strings.HasPrefix(line, ":") || strings.HasPrefix(trimmed, "\"") || strings.HasPrefix(line, ")") {
+			lines[i] = ""
+		}
+	}
+	// The function starts on the line before the cell, for the lines of the
+	// file to be those of the cell.
+	src := "package P; func F() {\n" + strings.Join(lines, "\n") + "\n}"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "cell.go", src, 0)
+	if err != nil {
+		return nil
+	}
+	info := &types.Info{Scopes: make(map[ast.Node]*types.Scope)}
+	conf := types.Config{Error: func(error) {}}
+	pkg, _ := conf.Check("P", fset, []*ast.File{f}, info)
+	if pkg == nil {
+		return nil
+	}
+
+	cell := &cellAnalysis{pkg: pkg, starts: map[int]ast.Stmt{}}
+	record := func(stmts []ast.Stmt) {
+		for _, stmt := range stmts {
+			pos := fset.Position(stmt.Pos())
+			line := pos.Line - 1
+			if cell.starts[line] != nil || line < 1 || line > len(lines) {
+				continue
+			}
+			if indent := len(lines[line-1]) - len(strings.TrimLeft(lines[line-1], " \t")); pos.Column == indent+1 {
+				cell.starts[line] = stmt
+			}
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			record(n.List)
+		case *ast.CaseClause:
+			record(n.Body)
+		case *ast.CommClause:
+			record(n.Body)
+		}
+		return true
+	})
+	return cell
+}
+
+// variables returns the names of the variables the cell declares that are in
+// scope at the start of stmt, innermost first.
+func (c *cellAnalysis) variables(stmt ast.Stmt) []string {
+	var names []string
+	seen := map[string]bool{}
+	for s := c.pkg.Scope().Innermost(stmt.Pos()); s != nil && s != c.pkg.Scope() && s != types.Universe; s = s.Parent() {
+		for _, name := range s.Names() {
+			if v, ok := s.Lookup(name).(*types.Var); ok && name != "_" && !seen[name] && v.Pos() < stmt.Pos() {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// instrumentBreakpoints returns code with a call to gophernotes.Breakpoint
+// inserted before each line the attached debugger set a breakpoint on that
+// starts a statement, passing the variables of the cell, and those of earlier
+// cells, in scope there. It returns code as it is when the debugger is not
+// attached, no breakpoint is set in the cell, or the session cannot import the
+// gophernotes package.
+func (k *Kernel) instrumentBreakpoints(code string) string {
+	path := k.debugSourcePath(code)
+	k.debugger.Lock()
+	started, lines := k.debugger.started, k.debugger.breakpoints[path]
+	k.debugger.Unlock()
+	if !started || len(lines) == 0 || !k.session.Runtime() {
+		return code
+	}
+	cell := analyzeCell(code)
+	if cell == nil {
+		return code
+	}
+
+	var globals []string
+	if bindings, err := k.session.Bindings(); err == nil {
+		for _, b := range bindings {
+			if b.Kind == "var" {
+				globals = append(globals, b.Name)
+			}
+		}
+	}
+
+	// Stops left over from cells interrupted while stopped must not let
+	// those of this one go on.
+	stale, _ := filepath.Glob(filepath.Join(k.files.debug, "continue-*"))
+	for _, f := range stale {
+		os.Remove(f)
+	}
+
+	isGlobal := map[string]bool{}
+	breakpoints := map[int]string{}
+	for _, line := range lines {
+		stmt := cell.starts[line]
+		if stmt == nil {
+			continue
+		}
+		names := cell.variables(stmt)
+		local := map[string]bool{}
+		for _, name := range names {
+			local[name] = true
+		}
+		for _, name := range globals {
+			if !local[name] {
+				names = append(names, name)
+				isGlobal[name] = true
+			}
+		}
+		quoted := make([]string, len(names))
+		for i, name := range names {
+			quoted[i] = strconv.Quote(name)
+		}
+		args := "nil"
+		if len(names) > 0 {
+			args = "[]string{" + strings.Join(quoted, ", ") + "}, " + strings.Join(names, ", ")
+		}
+		breakpoints[line] = fmt.Sprintf("gophernotes.Breakpoint(%d, %s);", line, args)
+	}
+	k.debugger.Lock()
+	k.debugger.running, k.debugger.globals = path, isGlobal
+	k.debugger.Unlock()
+
+	// The calls end with a semicolon, for the session to run them as
+	// statements rather than print them as expressions.
+	codeLines := strings.Split(code, "\n")
+	instrumented := make([]string, 0, len(codeLines)+len(breakpoints))
+	for i, line := range codeLines {
+		if call, ok := breakpoints[i+1]; ok {
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			instrumented = append(instrumented, indent+call)
+		}
+		instrumented = append(instrumented, line)
+	}
+	return strings.Join(instrumented, "\n")
+}
+
+// debugCellDone forgets the breakpoint the cell stopped at, if it did, once
+// it exited.
+func (k *Kernel) debugCellDone() {
+	k.debugger.Lock()
+	k.debugger.stop = nil
+	k.debugger.running, k.debugger.globals = "", nil
+	k.debugger.Unlock()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMurmur2 tests that cell code hashes as the frontend and ipykernel hash
+// it, for the sources of cells to be found by both
+func TestMurmur2(t *testing.T) {
+	for code, want := range map[string]uint32{
+		"":                     3990065800,
+		"abcd":                 804720481,
+		"x := 1":               2984684956,
+		`fmt.Println("héllo")`: 3422996857,
+	} {
+		assert.Equal(t, want, murmur2([]byte(code), debugHashSeed), "%q", code)
+	}
+}
+
+// TestAnalyzeCell tests which lines of a cell breakpoints can be set on, and
+// the variables in scope there
+func TestAnalyzeCell(t *testing.T) {
+	cell := analyzeCell(":import fmt\nx := 1\nfor i := 0; i < 3; i++ {\n\ty := i * x\n\tfmt.Println(y,\n\t\tx)\n}\nz := []int{\n\t1,\n}")
+	if cell == nil {
+		t.Fatal("the cell does not parse")
+	}
+	for line, want := range map[int][]string{
+		2: nil,
+		3: {"x"},
+		4: {"i", "x"},
+		5: {"i", "x", "y"},
+		8: {"x"},
+	} {
+		stmt := cell.starts[line]
+		if assert.NotNil(t, stmt, "line %d", line) {
+			names := cell.variables(stmt)
+			sort.Strings(names)
+			assert.Equal(t, want, names, "line %d", line)
+		}
+	}
+	for _, line := range []int{1, 6, 7, 9, 10, 11} {
+		assert.Nil(t, cell.starts[line], "line %d", line)
+	}
+
+	assert.Nil(t, analyzeCell("func f() {}"))
+}
+
+// debugClient sends the debug requests of a test, numbering them.
+type debugClient struct {
+	*testClient
+	seq int
+}
+
+// request sends the debug request command with args on the control socket,
+// and returns the content of its reply.
+func (c *debugClient) request(command string, args map[string]interface{}) map[string]interface{} {
+	c.seq++
+	req := c.sendOn(c.control, "debug_request", map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	}, nil)
+	reply := c.recv(c.control)
+	assert.Equal(c.t, "debug_reply", reply.Header.MsgType)
+	assert.Equal(c.t, req.Header.MsgID, reply.ParentHeader.MsgID)
+	content := reply.Content.(map[string]interface{})
+	assert.Equal(c.t, float64(c.seq), content["request_seq"])
+	assert.Equal(c.t, command, content["command"])
+	return content
+}
+
+// body sends the debug request command with args, which must succeed, and
+// returns the body of its reply.
+func (c *debugClient) body(command string, args map[string]interface{}) map[string]interface{} {
+	content := c.request(command, args)
+	assert.Equal(c.t, true, content["success"], "%s: %v", command, content["message"])
+	return content["body"].(map[string]interface{})
+}
+
+// event returns the body of the next debug event named name published.
+func (c *debugClient) event(name string) map[string]interface{} {
+	for {
+		msg := c.recv(c.iopub)
+		if msg.Header.MsgType != "debug_event" {
+			continue
+		}
+		content := msg.Content.(map[string]interface{})
+		if content["event"] == name {
+			return content["body"].(map[string]interface{})
+		}
+	}
+}
+
+// variables returns the values and types of the variables of the scope
+// reference of the frame stopped at, by name.
+func (c *debugClient) variables(reference float64) map[string][2]string {
+	vars := map[string][2]string{}
+	for _, v := range c.body("variables", map[string]interface{}{"variablesReference": reference})["variables"].([]interface{}) {
+		v := v.(map[string]interface{})
+		vars[v["name"].(string)] = [2]string{v["value"].(string), v["type"].(string)}
+	}
+	return vars
+}
+
+// TestDebug_session tests a debugging session as the debugger of JupyterLab
+// runs them: the cell stops at its breakpoints, showing its variables and
+// those of earlier cells, goes on from them, and completes
+func TestDebug_session(t *testing.T) {
+	c := &debugClient{testClient: newTestClient(t)}
+	defer c.Close()
+
+	info := c.kernelInfo()
+	assert.Equal(t, true, info["debugger"])
+	assert.Equal(t, "5.3", info["protocol_version"], "debug requests come with version 5.3 of the protocol")
+	assert.Equal(t, "go", info["language_info"].(map[string]interface{})["name"])
+
+	reply, _ := c.execute(`debuggedGlobal := "session"`)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+
+	capabilities := c.body("initialize", map[string]interface{}{"clientID": "test", "adapterID": "gophernotes"})
+	assert.Equal(t, true, capabilities["supportsConfigurationDoneRequest"])
+	c.body("attach", map[string]interface{}{})
+	defer c.body("disconnect", map[string]interface{}{"restart": false, "terminateDebuggee": true})
+	assert.Equal(t, true, c.body("debugInfo", map[string]interface{}{})["isStarted"])
+
+	code := "debugged := 41\ndebugged++\nif debugged > 0 {\n\tnames := []int{1}\n\tdebugged += len(names)\n}"
+	path := c.body("dumpCell", map[string]interface{}{"code": code})["sourcePath"].(string)
+	assert.Equal(t, testKernel.debugSourcePath(code), path)
+	assert.Equal(t, code, c.body("source", map[string]interface{}{"source": map[string]interface{}{"path": path}})["content"])
+
+	set := c.body("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": path},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 2}, map[string]interface{}{"line": 5}, map[string]interface{}{"line": 6}},
+	})
+	var verified []bool
+	for _, bp := range set["breakpoints"].([]interface{}) {
+		verified = append(verified, bp.(map[string]interface{})["verified"].(bool))
+	}
+	assert.Equal(t, []bool{true, true, false}, verified)
+	c.body("configurationDone", map[string]interface{}{})
+
+	req := c.send("execute_request", map[string]interface{}{
+		"code":             code,
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      false,
+	})
+	for _, stop := range []struct {
+		line   float64
+		locals map[string][2]string
+	}{
+		{2, map[string][2]string{"debugged": {"41", "int"}}},
+		{5, map[string][2]string{"debugged": {"42", "int"}, "names": {"[1]", "[]int"}}},
+	} {
+		assert.Equal(t, "breakpoint", c.event("stopped")["reason"])
+		frames := c.body("stackTrace", map[string]interface{}{"threadId": 1})["stackFrames"].([]interface{})
+		if assert.Len(t, frames, 1) {
+			frame := frames[0].(map[string]interface{})
+			assert.Equal(t, stop.line, frame["line"])
+			assert.Equal(t, path, frame["source"].(map[string]interface{})["path"])
+		}
+
+		scopes := c.body("scopes", map[string]interface{}{"frameId": 1})["scopes"].([]interface{})
+		assert.Len(t, scopes, 2)
+		locals := scopes[0].(map[string]interface{})
+		assert.Equal(t, "Locals", locals["name"])
+		assert.Equal(t, stop.locals, c.variables(locals["variablesReference"].(float64)))
+		globals := c.variables(scopes[1].(map[string]interface{})["variablesReference"].(float64))
+		assert.Equal(t, [2]string{"session", "string"}, globals["debuggedGlobal"])
+		assert.NotContains(t, globals, "debugged")
+
+		c.body("continue", map[string]interface{}{"threadId": 1})
+	}
+
+	reply, _ = c.results(req)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Empty(t, c.body("stackTrace", map[string]interface{}{"threadId": 1})["stackFrames"])
+
+	// The breakpoints of the cell are not stopped at as it runs again
+	// before the next cells.
+	reply, published := c.execute("debugged")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "43\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+
+	unsupported := c.request("next", map[string]interface{}{"threadId": 1})
+	assert.Equal(t, false, unsupported["success"])
+	assert.Equal(t, "next is not supported", unsupported["message"])
+}
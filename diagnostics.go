@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerBoolConfig("diagnostics", "show the warnings of the kernel in the notebook, as well as in its log", func(c *kernelConfig) *bool { return &c.diagnostics })
+}
+
+// diagLevel is how much a diagnostic matters.
+type diagLevel int
+
+const (
+	diagDebug diagLevel = iota
+	diagInfo
+	diagWarning
+	diagError
+)
+
+func (l diagLevel) String() string {
+	switch l {
+	case diagDebug:
+		return "Debug"
+	case diagInfo:
+		return "Info"
+	case diagWarning:
+		return "Warning"
+	}
+	return "Error"
+}
+
+// ansiDim dims the diagnostics shown in the output of a cell.
+const ansiDim = "\x1b[2m"
+
+// diagBurst is how many diagnostics of a category are shown in the notebook
+// every diagWindow; those beyond are only logged, and counted in the next one
+// shown.
+const (
+	diagBurst  = 3
+	diagWindow = time.Minute
+)
+
+// diagDisplayPrefix starts the display_id of the displays of the
+// diagnostics shown while no cell runs, which goes on with their category.
+const diagDisplayPrefix = "gophernotes-diagnostics-"
+
+// diagnostics are what the kernel notices as it goes along and tells the
+// frontend of, besides logging it: the cell running, whose output shows them,
+// and how many of each category were shown lately.
+type diagnostics struct {
+	sync.Mutex
+
+	// cell is the request of the cell running, if any, and last that of
+	// the last cell run, which the diagnostics shown while no cell runs
+	// are children of.
+	cell *MsgReceipt
+	last *MsgReceipt
+
+	windows map[string]*diagWindowCount
+}
+
+// diagWindowCount counts the diagnostics of a category shown since start,
+// and those left out.
+type diagWindowCount struct {
+	start      time.Time
+	shown, out int
+}
+
+// cellStarted has the diagnostics shown in the output of the cell of receipt,
+// until cellDone is called.
+func (d *diagnostics) cellStarted(receipt MsgReceipt) {
+	d.Lock()
+	defer d.Unlock()
+	d.cell, d.last = &receipt, &receipt
+}
+
+// cellDone has the diagnostics shown as displays, once the cell is done.
+func (d *diagnostics) cellDone() {
+	d.Lock()
+	defer d.Unlock()
+	d.cell = nil
+}
+
+// admit reports whether a diagnostic of category is to be shown, counting it,
+// along with how many of the category were left out since the last one
+// shown, and the receipts of the cell running and the last one.
+func (d *diagnostics) admit(category string) (ok bool, out int, cell, last *MsgReceipt) {
+	d.Lock()
+	defer d.Unlock()
+	if d.windows == nil {
+		d.windows = make(map[string]*diagWindowCount)
+	}
+	w, found := d.windows[category]
+	now := time.Now()
+	if !found || now.Sub(w.start) >= diagWindow {
+		if !found {
+			w = &diagWindowCount{}
+			d.windows[category] = w
+		}
+		w.start, w.shown = now, 0
+	}
+	if w.shown >= diagBurst {
+		w.out++
+		return false, 0, nil, nil
+	}
+	w.shown++
+	out, w.out = w.out, 0
+	return true, out, d.cell, d.last
+}
+
+// diagnose tells of what the kernel noticed, msg, of category, such as
+// "history", with fields, pairs of names and values. Every diagnostic is
+// logged. Warnings and errors are also shown in the notebook, unless
+// %config diagnostics is off: dimmed on stderr in the output of the cell
+// running, or as a display while none runs. Beyond diagBurst a diagnostic
+// category every diagWindow, they are only logged.
+func (k *Kernel) diagnose(level diagLevel, category, msg string, fields ...interface{}) {
+	k.diagnoseIn(nil, level, category, msg, fields...)
+}
+
+// diagnoseIn is diagnose, showing the diagnostic in the output of the request
+// of receipt rather than in that of the cell running, unless it is nil, as
+// for the output of cells of subshells.
+func (k *Kernel) diagnoseIn(receipt *MsgReceipt, level diagLevel, category, msg string, fields ...interface{}) {
+	text := msg + formatFields(fields)
+	k.logger.Printf("%s (%s): %s\n", level, category, text)
+	config := k.options()
+	if level < diagWarning || !config.diagnostics {
+		return
+	}
+	ok, out, cell, last := k.diagnostics.admit(category)
+	if !ok {
+		return
+	}
+	if receipt != nil {
+		cell = receipt
+	}
+	text = "gophernotes: " + text
+	if out > 0 {
+		text += fmt.Sprintf(" (%d more left out)", out)
+	}
+	if cell != nil {
+		if !config.noColor {
+			text = ansiDim + text + ansiReset
+		}
+		stream := NewMsg("stream", cell.Msg)
+		stream.Content = map[string]interface{}{"name": "stderr", "data": text + "\n", "text": text + "\n"}
+		cell.SendResponse(cell.Sockets.IOPubSocket, stream)
+		return
+	}
+	parent := MsgReceipt{Sockets: k.sockets}
+	if last != nil {
+		parent = *last
+	}
+	display := NewMsg("display_data", parent.Msg)
+	display.Content = map[string]interface{}{
+		"data":      map[string]interface{}{"text/plain": text},
+		"metadata":  map[string]interface{}{},
+		"transient": map[string]interface{}{"display_id": diagDisplayPrefix + category},
+	}
+	parent.SendResponse(parent.Sockets.IOPubSocket, display)
+}
+
+// formatFields formats the fields of a diagnostic, pairs of names and values,
+// as " (name: value, ...)", in the order of their names.
+func formatFields(fields []interface{}) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	var parts []string
+	for i := 0; i+1 < len(fields); i += 2 {
+		parts = append(parts, fmt.Sprintf("%v: %v", fields[i], fields[i+1]))
+	}
+	sort.Strings(parts)
+	return " (" + strings.Join(parts, ", ") + ")"
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestKernel_diagnose tests that diagnostics are logged, that warnings are
+// shown dimmed in the output of the cell running, or as displays once it is
+// done, that each category is shown a few times a minute at most, and that
+// %config diagnostics off only leaves them to the log
+func TestKernel_diagnose(t *testing.T) {
+	_, cleanup := testNotebook(t)
+	defer cleanup()
+	var published []ComposedMsg
+	var logged bytes.Buffer
+	config := defaultConfig()
+	config.noColor = false
+	k, err := NewKernel(SocketGroup{deliver: func(msg ComposedMsg) {
+		published = append(published, msg)
+	}}, log.New(&logged, "", 0), config)
+	noError(t, err)
+	defer k.removeSession()
+	diagnose := func(level diagLevel, category, msg string, fields ...interface{}) []ComposedMsg {
+		published = nil
+		k.diagnose(level, category, msg, fields...)
+		return published
+	}
+
+	receipt := MsgReceipt{Msg: newExecuteRequest("1"), Sockets: k.sockets}
+	k.diagnostics.cellStarted(receipt)
+	msgs := diagnose(diagWarning, "test", "something happened", "path", "/tmp/x", "count", 2)
+	assert.Equal(t, ansiDim+"gophernotes: something happened (count: 2, path: /tmp/x)"+ansiReset+"\n", streamText(msgs, "stderr"))
+	if assert.NotEmpty(t, msgs) {
+		assert.Equal(t, receipt.Msg.Header, msgs[0].ParentHeader)
+	}
+	assert.Empty(t, diagnose(diagInfo, "test", "nothing much"))
+	assert.Contains(t, logged.String(), "Info (test): nothing much\n")
+
+	k.diagnostics.cellDone()
+	msgs = diagnose(diagError, "other", "something failed")
+	if assert.Equal(t, []string{"display_data"}, msgTypes(msgs)) {
+		content := contentMap(msgs[0].Content)
+		assert.Equal(t, "gophernotes: something failed", content["data"].(map[string]interface{})["text/plain"])
+		assert.Equal(t, diagDisplayPrefix+"other", content["transient"].(map[string]interface{})["display_id"])
+		assert.Equal(t, receipt.Msg.Header, msgs[0].ParentHeader)
+	}
+
+	// The first warning of test was shown already.
+	for i := 1; i < diagBurst; i++ {
+		assert.Len(t, diagnose(diagWarning, "test", "again"), 1)
+	}
+	assert.Empty(t, diagnose(diagWarning, "test", "again"))
+	assert.Empty(t, diagnose(diagWarning, "test", "again"))
+	assert.Len(t, diagnose(diagWarning, "other", "again"), 1, "categories are limited apart")
+	k.diagnostics.windows["test"].start = time.Now().Add(-diagWindow)
+	msgs = diagnose(diagWarning, "test", "again")
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "gophernotes: again (2 more left out)", contentMap(msgs[0].Content)["data"].(map[string]interface{})["text/plain"])
+	}
+
+	published = nil
+	k.HandleExecuteRequest(MsgReceipt{Msg: newExecuteRequest("%config diagnostics off"), Sockets: k.sockets})
+	assert.False(t, k.options().diagnostics)
+	assert.Empty(t, diagnose(diagError, "silenced", "not shown"))
+	assert.Contains(t, logged.String(), "Error (silenced): not shown\n")
+	assert.Equal(t, diagBurst+2, strings.Count(logged.String(), "Warning (test): again\n"), "every diagnostic is logged")
+}
+
+// TestKernel_diagnoseFailures tests that what goes wrong around cells, such as
+// writing the state of widgets, is shown as a diagnostic, and that the kernel
+// logs the gophernotes package being imported and the session being reset
+func TestKernel_diagnoseFailures(t *testing.T) {
+	_, cleanup := testNotebook(t)
+	defer cleanup()
+	var published []ComposedMsg
+	var logged bytes.Buffer
+	k, err := NewKernel(SocketGroup{deliver: func(msg ComposedMsg) {
+		published = append(published, msg)
+	}}, log.New(&logged, "", 0), defaultConfig())
+	noError(t, err)
+	defer k.removeSession()
+	if k.session.Runtime() {
+		assert.Contains(t, logged.String(), "Debug (imports): imported the gophernotes package into the session")
+	}
+
+	k.files.widgetState = filepath.Join(k.tempDir, "missing", "widget_state.json")
+	k.writeWidgetStates()
+	if assert.Equal(t, []string{"display_data"}, msgTypes(published)) {
+		text := contentMap(published[0].Content)["data"].(map[string]interface{})["text/plain"]
+		assert.Contains(t, text, "gophernotes: could not write the widget state")
+	}
+
+	k.HandleExecuteRequest(MsgReceipt{Msg: newExecuteRequest("%reset -f"), Sockets: k.sockets})
+	assert.Contains(t, logged.String(), "Info (session): reset the session (imports dropped: false)\n")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterLineMagic("pwd", "%pwd", "print the working directory", pwdMagic)
+	RegisterLineMagic("cd", "%cd [<dir> | -]", "change the working directory\nWithout a directory, the working directory becomes the one the kernel started in; - goes back to the previous one.", cdMagic)
+	RegisterLineMagic("dhist", "%dhist", "list the working directories visited", dhistMagic)
+}
+
+// maxDirHistory is the number of directories %dhist lists at most.
+const maxDirHistory = 20
+
+// dirHistory holds the working directories of a kernel: the one it started
+// in, the one it was in before the last %cd, and those it has been in, oldest
+// first.
+type dirHistory struct {
+	start    string
+	previous string
+	history  []string
+	once     sync.Once
+}
+
+// init records the directory the kernel started in, before the first change
+// of directory.
+func (d *dirHistory) init() {
+	d.once.Do(func() {
+		if wd, err := os.Getwd(); err == nil {
+			d.start, d.previous = wd, wd
+			d.history = []string{wd}
+		}
+	})
+}
+
+// pwdMagic prints the working directory.
+func pwdMagic(ctx *MagicContext, args []string, body string) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	ctx.Stream("stdout", wd+"\n")
+	return nil
+}
+
+// cdMagic changes the working directory of the kernel, and so of the code of
+// the cells and the commands they run, to the directory given, after
+// expanding "~" and environment variables; without one, to the directory the
+// kernel started in, and with "-", to the previous one. The directory left is
+// unchanged if the one given cannot be entered. The imports of the next cells
+// resolve in the module context of the new directory, unless cells import
+// packages of the context they resolve in, which is kept, with a warning.
+func cdMagic(ctx *MagicContext, args []string, body string) error {
+	dirs := &ctx.Kernel.dirs
+	dirs.init()
+	if len(args) > 1 {
+		return errors.New("too many arguments")
+	}
+
+	var dir string
+	switch {
+	case len(args) == 0:
+		dir = dirs.start
+	case args[0] == "-":
+		dir = dirs.previous
+	default:
+		dir = expandPath(args[0])
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		if pathErr, ok := err.(*os.PathError); ok {
+			err = pathErr.Err
+		}
+		return fmt.Errorf("%s: %s", dir, err)
+	}
+	if dir, err = os.Getwd(); err != nil {
+		return err
+	}
+
+	dirs.previous = wd
+	dirs.history = append(dirs.history, dir)
+	if len(dirs.history) > maxDirHistory {
+		dirs.history = dirs.history[len(dirs.history)-maxDirHistory:]
+	}
+	ctx.Stream("stdout", dir+"\n")
+
+	changed, err := ctx.Kernel.useModuleContext(false)
+	switch {
+	case err != nil:
+		ctx.Stream("stderr", "Warning: "+err.Error()+"\n")
+	case changed:
+		ctx.Stream("stdout", fmt.Sprintf("Imports now resolve in %s\n", ctx.Session.ModuleContext()))
+	}
+	return nil
+}
+
+// dhistMagic lists the working directories the kernel has been in, oldest
+// first.
+func dhistMagic(ctx *MagicContext, args []string, body string) error {
+	dirs := &ctx.Kernel.dirs
+	dirs.init()
+	var text string
+	for i, dir := range dirs.history {
+		text += fmt.Sprintf("%d: %s\n", i, dir)
+	}
+	ctx.Stream("stdout", text)
+	return nil
+}
+
+// expandPath expands the environment variables in path, and a leading "~"
+// standing for the home directory.
+func expandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = home + path[1:]
+		}
+	}
+	return path
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCdMagic tests that %cd changes the working directory of the code of
+// later cells and of the commands they run, back to the previous one with
+// "-" and to the first one without a directory, and that directories which
+// cannot be entered are an error leaving the working directory unchanged
+func TestCdMagic(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+	start, err := os.Getwd()
+	noError(t, err)
+	defer os.Chdir(start)
+
+	dir, err := ioutil.TempDir("", "gophernotes_cd")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	noError(t, err)
+	os.Setenv("GOPHERNOTES_TEST_DIR", dir)
+	defer os.Unsetenv("GOPHERNOTES_TEST_DIR")
+
+	reply, published := c.execute("%cd $GOPHERNOTES_TEST_DIR")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Equal(t, dir+"\n", streamText(published, "stdout"))
+
+	c.execute(":import os")
+	_, published = c.execute("cdWd, _ := os.Getwd()\ncdWd")
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Contains(t, published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"], dir)
+	}
+	_, published = c.execute("!pwd")
+	assert.Equal(t, dir+"\n", streamText(published, "stdout"))
+
+	reply, _ = c.execute("%cd no/such/dir")
+	assert.Equal(t, "error", reply.Content.(map[string]interface{})["status"])
+	_, published = c.execute("%pwd")
+	assert.Equal(t, dir+"\n", streamText(published, "stdout"))
+
+	_, published = c.execute("%cd -")
+	assert.Equal(t, start+"\n", streamText(published, "stdout"))
+	_, published = c.execute("%cd -")
+	assert.Equal(t, dir+"\n", streamText(published, "stdout"))
+	_, published = c.execute("%cd")
+	assert.Equal(t, start+"\n", streamText(published, "stdout"))
+
+	_, published = c.execute("%dhist")
+	assert.Contains(t, streamText(published, "stdout"), "0: "+start+"\n")
+	assert.Contains(t, streamText(published, "stdout"), ": "+dir+"\n")
+}
+
+// TestExpandPath tests that "~" and environment variables are expanded
+func TestExpandPath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	noError(t, err)
+	os.Setenv("GOPHERNOTES_TEST_SUB", "data")
+	defer os.Unsetenv("GOPHERNOTES_TEST_SUB")
+	assert.Equal(t, filepath.Join(home, "data"), expandPath("~/$GOPHERNOTES_TEST_SUB"))
+	assert.Equal(t, home, expandPath("~"))
+	assert.Equal(t, "~user", expandPath("~user"))
+}
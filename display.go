@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// displayPollInterval is how often the display file is checked for new
+// messages while a cell is running.
+const displayPollInterval = 20 * time.Millisecond
+
+// DisplayMsg is a message written to the display file by the gophernotes
+// package in cell code.
+type DisplayMsg struct {
+	MsgType  string                 `json:"msg_type"`
+	Content  json.RawMessage        `json:"content"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Buffers  [][]byte               `json:"buffers"`
+}
+
+// displayRelay publishes the messages cell code writes to the display file on
+// the IOPub socket, as children of the execute_request being handled.
+type displayRelay struct {
+	kernel  *Kernel
+	receipt MsgReceipt
+	silent  bool
+
+	// count is the execution count results are numbered with.
+	count int
+
+	// subshell is set when the cell is that of a subshell, whose process
+	// interrupts leave alone.
+	subshell bool
+
+	// metadata, if not nil, gets what cell code adds to the metadata of
+	// the execute_reply.
+	metadata map[string]interface{}
+
+	// streams keeps the stream messages of cell code, and its standard
+	// output, within the budget of the output streams.
+	streams *streamLimiter
+	stdout  streamWriter
+
+	// results is the text of the results of the cell without a richer
+	// representation, published once the cell is done.
+	results bytes.Buffer
+
+	// updates holds back the updates of displays coming too fast.
+	updates *updateCoalescer
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startDisplayRelay truncates the display file of the session and starts
+// publishing whatever gets written to it. Cell results are dropped for silent
+// requests. What cell code adds to the metadata of the execute_reply goes to
+// metadata, if it is not nil.
+func (k *Kernel) startDisplayRelay(receipt MsgReceipt, silent bool, metadata map[string]interface{}) (*displayRelay, error) {
+	return k.startRelay(k.files.display, &displayRelay{
+		receipt:  receipt,
+		silent:   silent,
+		count:    k.execCount,
+		metadata: metadata,
+	})
+}
+
+// startRelay truncates the display file at path and has r start publishing
+// whatever gets written to it.
+func (k *Kernel) startRelay(path string, r *displayRelay) (*displayRelay, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := k.options()
+	r.kernel = k
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	r.streams = newStreamLimiter(r.publishStream, config)
+	r.streams.notice = func(text string) { k.diagnoseIn(&r.receipt, diagWarning, "output", text) }
+	r.stdout = streamWriter{name: "stdout", limiter: r.streams}
+	r.updates = newUpdateCoalescer(r.send, config.updateInterval())
+	go r.run(f)
+	return r, nil
+}
+
+// Stdout returns a writer publishing the standard output of the cell code as
+// it comes, its writes waiting while the output comes faster than the
+// blocking rate, as the session does with repl.Session.Stdout.
+func (r *displayRelay) Stdout() io.Writer {
+	return &r.stdout
+}
+
+// Stop waits for the messages written so far to be published. It must only be
+// called once the cell code has exited.
+func (r *displayRelay) Stop() {
+	close(r.stop)
+	<-r.done
+	r.updates.Close()
+	r.stdout.Flush()
+	r.streams.Close()
+}
+
+// Results returns the text of the results of the cell without a richer
+// representation. It must only be called once the relay is stopped.
+func (r *displayRelay) Results() string {
+	return r.results.String()
+}
+
+func (r *displayRelay) run(f *os.File) {
+	defer close(r.done)
+	defer f.Close()
+
+	rd := bufio.NewReader(f)
+	var line []byte
+	stopping := false
+	for {
+		part, err := rd.ReadBytes('\n')
+		line = append(line, part...)
+		if err == nil {
+			r.publish(line)
+			line = line[:0]
+			continue
+		}
+		if err != io.EOF {
+			r.kernel.logger.Println("Could not read display file:", err)
+			return
+		}
+
+		// Once the cell has exited, reaching the end of the file means every
+		// message has been published.
+		if stopping {
+			return
+		}
+		select {
+		case <-r.stop:
+			stopping = true
+		case <-time.After(displayPollInterval):
+		}
+	}
+}
+
+func (r *displayRelay) publish(line []byte) {
+	var dm DisplayMsg
+	if err := json.Unmarshal(line, &dm); err != nil {
+		r.kernel.diagnoseIn(&r.receipt, diagWarning, "display", "dropped an invalid display message of cell code", "error", err)
+		return
+	}
+
+	// Results are published as execute_result messages, numbered like the text ones.
+	if dm.MsgType == "execute_result" {
+		if r.silent {
+			return
+		}
+		var outContent OutputMsg
+		if err := json.Unmarshal(dm.Content, &outContent); err != nil {
+			r.kernel.logger.Println("Invalid execute_result content:", err)
+			return
+		}
+		outContent.Execcount = r.count
+		out := NewMsg("execute_result", r.receipt.Msg)
+		out.Content = outContent
+		r.receipt.SendResponse(r.receipt.Sockets.IOPubSocket, out)
+		return
+	}
+
+	// Comm targets registered by cell code, the metadata of the reply, the
+	// flushes of closed displays, the breakpoints stopped at and the process
+	// of the cell concern the kernel alone; the stacks it dumps are
+	// published once located in the cell.
+	switch dm.MsgType {
+	case "update_display_data":
+		r.updates.Update(dm)
+		return
+	case "flush_display":
+		r.updates.Flush(displayID(dm.Content))
+		return
+	case "comm_target":
+		r.relayComm(dm)
+		return
+	case "input_request":
+		// The relay goes on publishing while the frontend is asked.
+		go r.kernel.answerCellInput(r.receipt, dm.Content)
+		return
+	case "debug_stopped":
+		r.kernel.debugStopped(r.receipt, dm.Content)
+		return
+	case "cell_process":
+		if r.subshell {
+			return
+		}
+		var content struct {
+			PID int `json:"pid"`
+		}
+		if err := json.Unmarshal(dm.Content, &content); err != nil {
+			r.kernel.logger.Println("Invalid cell process:", err)
+			return
+		}
+		r.kernel.interrupts.setPid(content.PID)
+		return
+	case "stack_dump":
+		if r.subshell {
+			return
+		}
+		r.kernel.stacksDumped(r.receipt, dm.Content)
+		return
+	case "result_text":
+		var content struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(dm.Content, &content); err != nil {
+			r.kernel.logger.Println("Invalid result text:", err)
+			return
+		}
+		r.results.WriteString(content.Text)
+		return
+	case "reply_metadata":
+		var metadata map[string]interface{}
+		if err := json.Unmarshal(dm.Content, &metadata); err != nil {
+			r.kernel.logger.Println("Invalid reply metadata:", err)
+			return
+		}
+		for k, v := range metadata {
+			if r.metadata != nil {
+				r.metadata[k] = v
+			}
+		}
+		return
+	case "comm_open", "comm_msg", "comm_close":
+		r.relayComm(dm)
+	case "stream":
+		// Streams go through the limiter, which publishes them as they
+		// were written within the budget, and together beyond it.
+		var content struct {
+			Name string `json:"name"`
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(dm.Content, &content); err != nil {
+			r.kernel.diagnoseIn(&r.receipt, diagWarning, "display", "dropped an invalid stream message of cell code", "error", err)
+			return
+		}
+		r.streams.pass(content.Name, content.Text)
+		return
+	}
+	r.send(dm)
+}
+
+// send publishes dm on the IOPub socket, as a child of the request of the cell,
+// but for the updates of a display: those are children of the request of the
+// cell that created it, whichever cell makes them.
+func (r *displayRelay) send(dm DisplayMsg) {
+	parent := r.receipt.Msg
+	switch dm.MsgType {
+	case "display_data":
+		if id := displayID(dm.Content); id != "" {
+			r.kernel.recordDisplayOrigin(id, parent.Header)
+		}
+	case "update_display_data":
+		if origin, ok := r.kernel.displayOrigin(displayID(dm.Content)); ok {
+			parent = ComposedMsg{Header: origin}
+		}
+	}
+	msg := NewMsg(dm.MsgType, parent)
+	msg.Content = dm.Content
+	msg.Buffers = dm.Buffers
+	if dm.Metadata != nil {
+		msg.Metadata = dm.Metadata
+	}
+	r.receipt.SendResponse(r.receipt.Sockets.IOPubSocket, msg)
+}
+
+// publishStream publishes text of the stream name, as the stream limiter of
+// the relay does.
+func (r *displayRelay) publishStream(name, text string) {
+	msg := NewMsg("stream", r.receipt.Msg)
+	msg.Content = map[string]interface{}{"name": name, "data": text, "text": text}
+	r.receipt.SendResponse(r.receipt.Sockets.IOPubSocket, msg)
+}
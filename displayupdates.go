@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerIntConfig("display_update_rate", "the updates per second of a display beyond which only the latest is published, 0 for every update", func(c *kernelConfig) *int { return &c.displayUpdateRate })
+}
+
+// pendingUpdate is the latest update of a display held back by an update
+// coalescer, and the timer publishing it.
+type pendingUpdate struct {
+	msg   DisplayMsg
+	timer *time.Timer
+}
+
+// An updateCoalescer publishes the update_display_data messages of cell code
+// through publish, at most one per interval for each display. An update coming
+// sooner replaces the one held back for the display, if any, and is published
+// once the interval has passed, so that the last update of a display always
+// shows. Close publishes the updates still held back, once the cell is done.
+type updateCoalescer struct {
+	publish  func(DisplayMsg)
+	interval time.Duration
+
+	mu      sync.Mutex
+	last    map[string]time.Time
+	pending map[string]*pendingUpdate
+	closed  bool
+}
+
+// newUpdateCoalescer returns a coalescer publishing updates with publish, at
+// most one per interval for each display, or all of them if interval is 0.
+func newUpdateCoalescer(publish func(DisplayMsg), interval time.Duration) *updateCoalescer {
+	return &updateCoalescer{
+		publish:  publish,
+		interval: interval,
+		last:     map[string]time.Time{},
+		pending:  map[string]*pendingUpdate{},
+	}
+}
+
+// Update publishes dm, an update_display_data message, or holds it back if the
+// last update of its display was published less than the interval ago.
+func (c *updateCoalescer) Update(dm DisplayMsg) {
+	id := displayID(dm.Content)
+	if c.interval <= 0 || id == "" {
+		c.publish(dm)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p := c.pending[id]; p != nil {
+		p.msg = dm
+		return
+	}
+	now := time.Now()
+	if wait := c.interval - now.Sub(c.last[id]); wait > 0 && !c.closed {
+		c.pending[id] = &pendingUpdate{msg: dm, timer: time.AfterFunc(wait, func() { c.Flush(id) })}
+		return
+	}
+	c.last[id] = now
+	c.publish(dm)
+}
+
+// Flush publishes the update of the display id held back, if any.
+func (c *updateCoalescer) Flush(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flush(id)
+}
+
+// flush publishes the update of the display id held back. The lock is held.
+func (c *updateCoalescer) flush(id string) {
+	p := c.pending[id]
+	if p == nil {
+		return
+	}
+	p.timer.Stop()
+	delete(c.pending, id)
+	c.last[id] = time.Now()
+	c.publish(p.msg)
+}
+
+// Close publishes the updates held back, once the output has ended. Updates
+// coming later are published right away.
+func (c *updateCoalescer) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for id := range c.pending {
+		c.flush(id)
+	}
+}
+
+// displayID returns the display_id of the transient data of content, the
+// content of a display message.
+func displayID(content json.RawMessage) string {
+	var c struct {
+		Transient struct {
+			DisplayID string `json:"display_id"`
+		} `json:"transient"`
+	}
+	if err := json.Unmarshal(content, &c); err != nil {
+		return ""
+	}
+	return c.Transient.DisplayID
+}
+
+// recordDisplayOrigin records parent, the header of the request of the cell
+// that created the display id, for the updates of the display to be its
+// children whichever cell makes them.
+func (k *Kernel) recordDisplayOrigin(id string, parent MsgHeader) {
+	k.displayOrigins.Lock()
+	defer k.displayOrigins.Unlock()
+	if k.displayOrigins.m == nil {
+		k.displayOrigins.m = map[string]MsgHeader{}
+	}
+	k.displayOrigins.m[id] = parent
+}
+
+// displayOrigin returns the header recorded for the display id, if any.
+func (k *Kernel) displayOrigin(id string) (MsgHeader, bool) {
+	k.displayOrigins.Lock()
+	defer k.displayOrigins.Unlock()
+	parent, ok := k.displayOrigins.m[id]
+	return parent, ok
+}
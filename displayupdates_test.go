@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// updateMsg returns an update_display_data message of the display id showing
+// text.
+func updateMsg(id, text string) DisplayMsg {
+	content, _ := json.Marshal(map[string]interface{}{
+		"data":      map[string]interface{}{"text/plain": text},
+		"metadata":  map[string]interface{}{},
+		"transient": map[string]interface{}{"display_id": id},
+	})
+	return DisplayMsg{MsgType: "update_display_data", Content: content}
+}
+
+// TestUpdateCoalescer tests that updates coming too fast replace one another,
+// the last update of each display being published
+func TestUpdateCoalescer(t *testing.T) {
+	var mu sync.Mutex
+	var published []string
+	c := newUpdateCoalescer(func(dm DisplayMsg) {
+		mu.Lock()
+		published = append(published, displayID(dm.Content)+" "+string(dm.Content))
+		mu.Unlock()
+	}, time.Hour)
+
+	for i := 0; i < 1000; i++ {
+		c.Update(updateMsg("a", fmt.Sprint(i)))
+	}
+	c.Update(updateMsg("b", "only"))
+	mu.Lock()
+	assert.Len(t, published, 2, "the first updates are published right away")
+	mu.Unlock()
+
+	c.Flush("a")
+	c.Flush("a")
+	mu.Lock()
+	if assert.Len(t, published, 3) {
+		assert.True(t, strings.HasPrefix(published[2], "a ") && strings.Contains(published[2], `"999"`), published[2])
+	}
+	mu.Unlock()
+
+	c.Update(updateMsg("a", "after flush"))
+	c.Close()
+	c.Update(updateMsg("a", "after close"))
+	if assert.Len(t, published, 5) {
+		assert.Contains(t, published[3], "after flush")
+		assert.Contains(t, published[4], "after close")
+	}
+}
+
+// TestUpdateCoalescer_interval tests that updates held back are published once
+// the interval has passed
+func TestUpdateCoalescer_interval(t *testing.T) {
+	published := make(chan DisplayMsg, 10)
+	c := newUpdateCoalescer(func(dm DisplayMsg) { published <- dm }, 50*time.Millisecond)
+	defer c.Close()
+
+	c.Update(updateMsg("a", "first"))
+	c.Update(updateMsg("a", "second"))
+	c.Update(updateMsg("a", "third"))
+	assert.Contains(t, string((<-published).Content), "first")
+	select {
+	case dm := <-published:
+		assert.Contains(t, string(dm.Content), "third")
+	case <-time.After(time.Second):
+		t.Fatal("the update held back was not published")
+	}
+}
+
+// TestDisplay_coalesced tests that a display updated in a tight loop only
+// publishes a few updates, the last one showing the final state, and that
+// closing its handle publishes the latest update and ignores later ones
+func TestDisplay_coalesced(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	reply, published := c.execute(`h := gophernotes.NewDisplay("zero")
+h.Update("one")
+h.Update("two")
+h.Close()
+h.Update("three")`)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	if !assert.Equal(t, []string{"display_data", "update_display_data", "update_display_data"}, msgTypes(published)) {
+		return
+	}
+	assert.Equal(t, "two", published[2].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+
+	start := time.Now()
+	reply, published = c.execute(`d := gophernotes.NewDisplay(0)
+for i := 1; i <= 100000; i++ {
+	d.Update(i)
+}`)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	types := msgTypes(published)
+	if !assert.NotEmpty(t, types) {
+		return
+	}
+	assert.Equal(t, "display_data", types[0])
+	// The display_data, an update per interval at most, and the last one.
+	bound := 3 + int(time.Since(start).Seconds()*float64(testKernel.options().displayUpdateRate))
+	assert.True(t, len(types) <= bound, "published %d messages, more than %d", len(types), bound)
+	last := published[len(published)-1]
+	assert.Equal(t, "update_display_data", last.Header.MsgType)
+	assert.Equal(t, "100000", last.Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+}
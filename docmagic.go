@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+)
+
+func init() {
+	RegisterLineMagic("doc", "%doc <package> | <name>", "show the documentation of a package or of a name", docMagic)
+}
+
+// maxDocLines is the number of lines of documentation %doc shows at most in
+// the output of the cell; longer documentation is shown in the pager.
+const maxDocLines = 40
+
+// docMagic shows the documentation of the package given, by import path or by
+// name, with the declarations of its exported members, or that of a member of
+// a package or a name of the session, as inspection finds it, with the methods
+// of a type. Names it does not know are followed by those that may have been
+// meant.
+func docMagic(ctx *MagicContext, args []string, body string) error {
+	if len(args) != 1 {
+		return errors.New("a package or name is needed")
+	}
+	d, found := ctx.Session.Doc(args[0])
+	if !found {
+		msg := fmt.Sprintf("no documentation found for %s", args[0])
+		if names := ctx.Session.Suggest(args[0]); len(names) > 0 {
+			msg += "; did you mean " + strings.Join(names, ", ") + "?"
+		}
+		return errors.New(msg)
+	}
+
+	data := docData(d)
+	if strings.Count(data["text/plain"].(string), "\n") > maxDocLines {
+		ctx.PageData(data)
+	} else {
+		ctx.Display(data, nil)
+	}
+	return nil
+}
+
+// docData renders d as plain text and as markdown, its members following its
+// doc comment.
+func docData(d repl.Documentation) map[string]interface{} {
+	text, markdown := renderDoc(d.Signature, d.Doc)
+	if len(d.Members) > 0 {
+		members := strings.Join(d.Members, "\n") + "\n"
+		text.WriteString("\n" + members)
+		markdown.WriteString("\n```go\n" + members + "```\n")
+	}
+	return map[string]interface{}{
+		"text/plain":    text.String(),
+		"text/markdown": markdown.String(),
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+)
+
+// TestSessionDoc tests that packages are documented by import path or name,
+// with their exported members, that members are documented as inspection
+// describes them, types with their methods, and that names close to unknown
+// ones are suggested
+func TestSessionDoc(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+
+	d, found := s.Doc("encoding/json")
+	if assert.True(t, found) {
+		assert.Equal(t, `package json // import "encoding/json"`, d.Signature)
+		assert.True(t, strings.HasPrefix(d.Doc, "Package json implements"), d.Doc)
+		assert.Contains(t, d.Members, "func Marshal(v any) ([]byte, error)")
+		assert.Contains(t, d.Members, "type Decoder struct{ ... }")
+		assert.Contains(t, d.Members, "    func NewDecoder(r io.Reader) *Decoder")
+	}
+	d, found = s.Doc("strings")
+	if assert.True(t, found) {
+		assert.Equal(t, `package strings // import "strings"`, d.Signature)
+	}
+
+	d, found = s.Doc("strings.Builder")
+	if assert.True(t, found) {
+		in, _ := s.Inspect("strings.Builder", len("strings.Builder"))
+		assert.Equal(t, in, d.Inspection)
+		assert.Contains(t, d.Members, "func (b *Builder) WriteString(s string) (int, error)")
+	}
+	d, found = s.Doc("encoding/json.Marshal")
+	if assert.True(t, found) {
+		assert.Equal(t, "func json.Marshal(v any) ([]byte, error)", d.Signature)
+		assert.Empty(t, d.Members)
+	}
+
+	_, found = s.Doc("strings.Buildr")
+	assert.False(t, found)
+	assert.Contains(t, s.Suggest("strings.Buildr"), "strings.Builder")
+	_, found = s.Doc("encoding/nosuch")
+	assert.False(t, found)
+}
+
+// TestDocMagic tests that %doc displays documentation as markdown and plain
+// text, pages long documentation, and fails on unknown names, suggesting
+// others
+func TestDocMagic(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	reply, published := c.execute("%doc strings.Builder")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	if assert.Equal(t, []string{"display_data"}, msgTypes(published)) {
+		data := published[0].Content.(map[string]interface{})["data"].(map[string]interface{})
+		assert.Contains(t, data["text/plain"], "\nfunc (b *Builder) WriteString(s string) (int, error)\n")
+		assert.Contains(t, data["text/markdown"], "\n```go\nfunc (b *Builder) ")
+		assert.Contains(t, data["text/markdown"], "\nfunc (b *Builder) Grow(n int)\n")
+	}
+
+	reply, _ = c.execute("%doc encoding/json")
+	content := reply.Content.(map[string]interface{})
+	payload := content["payload"].([]interface{})
+	if assert.Len(t, payload, 1) {
+		p := payload[0].(map[string]interface{})
+		assert.Equal(t, "page", p["source"])
+		assert.Contains(t, p["data"].(map[string]interface{})["text/markdown"], "```go\npackage json // import \"encoding/json\"\n```\n")
+	}
+
+	reply, _ = c.execute("%doc strings.Buildr")
+	content = reply.Content.(map[string]interface{})
+	assert.Equal(t, "error", content["status"])
+	assert.Contains(t, content["evalue"], "did you mean strings.Builder")
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterLineMagic("env", "%env [<name>[=<value>]] | -f <file>", "list, get or set environment variables\n-f sets the variables of the dotenv file given.", envMagic)
+}
+
+// secretWords mark the names of environment variables whose values are not
+// shown when all of them are listed.
+var secretWords = []string{"KEY", "TOKEN", "SECRET", "PASSWORD"}
+
+// maskedValue stands for the values of secret environment variables.
+const maskedValue = "********"
+
+// envMagic lists the environment variables of the kernel, prints the one
+// named, or sets it, from "name=value" or "name value", or the variables of
+// the dotenv file given with -f. Variables set are seen by the code of later
+// cells, and by the commands they run, for the rest of the session.
+func envMagic(ctx *MagicContext, args []string, body string) error {
+	switch {
+	case len(args) == 0:
+		ctx.Stream("stdout", envList(os.Environ()))
+		return nil
+	case args[0] == "-f":
+		if len(args) != 2 {
+			return errors.New("-f needs a file")
+		}
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		vars, err := parseDotenv(f, os.LookupEnv, true)
+		if err != nil {
+			return fmt.Errorf("%s:%s", args[1], err)
+		}
+		var text string
+		for _, v := range vars {
+			if err := os.Setenv(v[0], v[1]); err != nil {
+				return err
+			}
+			text += "env: " + v[0] + "=" + maskSecret(v[0], v[1]) + "\n"
+		}
+		ctx.Stream("stdout", text)
+		return nil
+	}
+
+	name, value, set := ctx.Args, "", false
+	if i := strings.IndexAny(name, "= \t"); i >= 0 {
+		name, value, set = name[:i], strings.TrimSpace(name[i+1:]), true
+	}
+	if !set {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return fmt.Errorf("%s is not set", name)
+		}
+		ctx.Stream("stdout", v+"\n")
+		return nil
+	}
+	if err := os.Setenv(name, value); err != nil {
+		return err
+	}
+	ctx.Stream("stdout", "env: "+name+"="+maskSecret(name, value)+"\n")
+	return nil
+}
+
+// envList lists the variables of environ, in "name=value" form, by name, with
+// the values of secret ones masked.
+func envList(environ []string) string {
+	vars := append([]string{}, environ...)
+	sort.Strings(vars)
+	var text string
+	for _, v := range vars {
+		name, value := v, ""
+		if i := strings.IndexByte(v, '='); i >= 0 {
+			name, value = v[:i], v[i+1:]
+		}
+		text += name + "=" + maskSecret(name, value) + "\n"
+	}
+	return text
+}
+
+// maskSecret returns value, or maskedValue if name looks like that of a secret.
+func maskSecret(name, value string) string {
+	upper := strings.ToUpper(name)
+	for _, word := range secretWords {
+		if strings.Contains(upper, word) {
+			return maskedValue
+		}
+	}
+	return value
+}
+
+// parseDotenv reads the variables of a dotenv file from r, as name and value
+// pairs in the order they are set. Each line sets a variable as "name=value",
+// optionally after "export". Values are taken literally within single quotes;
+// within double quotes, backslashes escape quotes, backslashes, dollars and
+// newlines, written "\n". Unquoted values end at a " #" starting a comment, and
+// are trimmed. Blank lines and lines starting with "#" are skipped. Errors are
+// prefixed by their line number.
+//
+// Outside of single quotes, $NAME and ${NAME} are replaced by the value of the
+// variable named, ${NAME:-default} by default if it is unset or empty: that
+// set by an earlier line, else that environ looks up or, unless override, that
+// environ looks up first, as the variables of the environment keep their
+// values then.
+func parseDotenv(r io.Reader, environ func(string) (string, bool), override bool) ([][2]string, error) {
+	var vars [][2]string
+	set := map[string]string{}
+	resolve := func(name string) string {
+		if v, ok := environ(name); ok && !override {
+			return v
+		}
+		if v, ok := set[name]; ok {
+			return v
+		}
+		v, _ := environ(name)
+		return v
+	}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("%d: expected name=value", n)
+		}
+		name := strings.TrimSpace(line[:eq])
+		value, err := dotenvValue(strings.TrimSpace(line[eq+1:]), resolve)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %s", n, err)
+		}
+		vars = append(vars, [2]string{name, value})
+		set[name] = value
+	}
+	return vars, sc.Err()
+}
+
+// dotenvValue returns the value written s in a dotenv file, the variables it
+// refers to replaced by their values as resolve has them.
+func dotenvValue(s string, resolve func(string) string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated ' quote")
+		}
+		return s[1 : end+1], nil
+	case strings.HasPrefix(s, `"`):
+		var value []byte
+		for i := 1; i < len(s); i++ {
+			switch c := s[i]; {
+			case c == '\\' && i+1 < len(s):
+				i++
+				if c = s[i]; c == 'n' {
+					c = '\n'
+				}
+				value = append(value, c)
+			case c == '"':
+				return string(value), nil
+			case c == '$':
+				text, n := expandVar(s[i:], resolve)
+				value = append(value, text...)
+				i += n - 1
+			default:
+				value = append(value, c)
+			}
+		}
+		return "", errors.New(`unterminated " quote`)
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	var value []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			value = append(value, s[i])
+			continue
+		}
+		text, n := expandVar(s[i:], resolve)
+		value = append(value, text...)
+		i += n - 1
+	}
+	return string(value), nil
+}
+
+// dotenvVarRe matches the references to variables of dotenv values: $NAME,
+// ${NAME} and ${NAME:-default}.
+var dotenvVarRe = regexp.MustCompile(`^\$(?:([A-Za-z_][A-Za-z0-9_]*)|\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\})`)
+
+// expandVar returns the value of the reference to a variable s starts with, as
+// resolve has it, and the length of the reference; a "$" that starts none is
+// kept as it is.
+func expandVar(s string, resolve func(string) string) (string, int) {
+	m := dotenvVarRe.FindStringSubmatchIndex(s)
+	if m == nil {
+		return "$", 1
+	}
+	if m[2] >= 0 {
+		return resolve(s[m[2]:m[3]]), m[1]
+	}
+	v := resolve(s[m[4]:m[5]])
+	if v == "" && m[6] >= 0 {
+		v = s[m[6]:m[7]]
+	}
+	return v, m[1]
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseDotenv tests that dotenv files are read with their quotes and
+// comments
+func TestParseDotenv(t *testing.T) {
+	vars, err := parseDotenv(strings.NewReader(`
+# data
+DATA_DIR=/data/in # where the data is
+export MODE = fast
+GREETING="hello \"you\"\nthere" # ignored
+RAW='a \n # b'
+EMPTY=
+`), noEnviron, true)
+	noError(t, err)
+	assert.Equal(t, [][2]string{
+		{"DATA_DIR", "/data/in"},
+		{"MODE", "fast"},
+		{"GREETING", "hello \"you\"\nthere"},
+		{"RAW", `a \n # b`},
+		{"EMPTY", ""},
+	}, vars)
+
+	_, err = parseDotenv(strings.NewReader("A=1\nB\n"), noEnviron, true)
+	assert.EqualError(t, err, "2: expected name=value")
+	_, err = parseDotenv(strings.NewReader(`A="open`), noEnviron, true)
+	assert.EqualError(t, err, `1: unterminated " quote`)
+}
+
+// noEnviron is an environment without variables.
+func noEnviron(string) (string, bool) {
+	return "", false
+}
+
+// TestParseDotenv_interpolation tests that variables are replaced by their
+// values outside of single quotes, those of the environment keeping theirs
+// unless overridden
+func TestParseDotenv_interpolation(t *testing.T) {
+	environ := func(name string) (string, bool) {
+		if name == "HOME" {
+			return "/home/gopher", true
+		}
+		return "", false
+	}
+	file := `HOME=/root
+DATA=$HOME/data
+CACHE="${DATA}/cache \$HOME"
+RAW='$HOME'
+PORT=${PORT:-8888}
+MISSING=a${NOPE}b $ 5
+`
+	vars, err := parseDotenv(strings.NewReader(file), environ, false)
+	noError(t, err)
+	assert.Equal(t, [][2]string{
+		{"HOME", "/root"},
+		{"DATA", "/home/gopher/data"},
+		{"CACHE", "/home/gopher/data/cache $HOME"},
+		{"RAW", "$HOME"},
+		{"PORT", "8888"},
+		{"MISSING", "ab $ 5"},
+	}, vars)
+
+	vars, err = parseDotenv(strings.NewReader(file), environ, true)
+	noError(t, err)
+	assert.Equal(t, [2]string{"DATA", "/root/data"}, vars[1])
+}
+
+// TestEnvList tests that the values of secrets are masked when all variables
+// are listed
+func TestEnvList(t *testing.T) {
+	assert.Equal(t, "API_KEY=********\nHOME=/root\ndb_password=********\n", envList([]string{"db_password=x", "HOME=/root", "API_KEY=k"}))
+}
+
+// TestEnvMagic tests that %env sets variables that later cells, and the
+// commands they run, see, gets them, and loads dotenv files
+func TestEnvMagic(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+	defer os.Unsetenv("GOPHERNOTES_TEST_ENV")
+	defer os.Unsetenv("GOPHERNOTES_TEST_TOKEN")
+	defer os.Unsetenv("GOPHERNOTES_TEST_FILE")
+
+	reply, published := c.execute("%env GOPHERNOTES_TEST_ENV=hello world")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Equal(t, "env: GOPHERNOTES_TEST_ENV=hello world\n", streamText(published, "stdout"))
+
+	c.execute(":import os/exec")
+	_, published = c.execute(`envOut, _ := exec.Command("sh", "-c", "echo $GOPHERNOTES_TEST_ENV").Output()
+envText := string(envOut)
+envText`)
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Contains(t, published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"], "hello world")
+	}
+
+	_, published = c.execute("%env GOPHERNOTES_TEST_ENV")
+	assert.Equal(t, "hello world\n", streamText(published, "stdout"))
+
+	_, published = c.execute("%env GOPHERNOTES_TEST_TOKEN abc")
+	assert.Equal(t, "env: GOPHERNOTES_TEST_TOKEN=********\n", streamText(published, "stdout"))
+	_, published = c.execute("%env")
+	assert.Contains(t, streamText(published, "stdout"), "\nGOPHERNOTES_TEST_TOKEN=********\n")
+
+	dir, err := ioutil.TempDir("", "gophernotes_env")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".env")
+	noError(t, ioutil.WriteFile(path, []byte("GOPHERNOTES_TEST_FILE='from file'\n"), 0644))
+	c.execute("%env -f " + path)
+	_, published = c.execute("!echo $GOPHERNOTES_TEST_FILE")
+	assert.Equal(t, "from file\n", streamText(published, "stdout"))
+
+	reply, _ = c.execute("%env GOPHERNOTES_TEST_UNSET")
+	assert.Equal(t, "%env: GOPHERNOTES_TEST_UNSET is not set", reply.Content.(map[string]interface{})["evalue"])
+}
@@ -2,92 +2,179 @@ package main
 
 import (
 	"fmt"
-	"go/token"
+	"io/ioutil"
 
 	repl "github.com/gopherds/gophernotes/internal/repl"
 )
 
-var (
-	// REPLSession manages the I/O to/from the notebook.
-	REPLSession *repl.Session
-	fset        *token.FileSet
-)
-
-// ExecCounter is incremented each time we run user code in the notebook.
-var ExecCounter int
-
-// SetupExecutionEnvironment initializes the REPL session and set of tmp files.
-func SetupExecutionEnvironment() {
-
-	var err error
-	REPLSession, err = repl.NewSession()
+// takeSnapshot copies the session for the read-only requests to read, once a
+// cell is done with it.
+func (k *Kernel) takeSnapshot() {
+	s, err := k.session.Snapshot()
 	if err != nil {
-		panic(err)
+		k.diagnose(diagWarning, "session", "could not copy the session: completions and inspections do not see the last cell", "error", err)
+		return
 	}
+	k.snapshot.Lock()
+	k.snapshot.session = s
+	k.snapshot.Unlock()
+	k.clients.snapshotTaken(s)
+}
 
-	fset = token.NewFileSet()
+// readSession returns the copy of the session the read-only requests read.
+func (k *Kernel) readSession() *repl.Session {
+	k.snapshot.Lock()
+	defer k.snapshot.Unlock()
+	return k.snapshot.session
 }
 
-// OutputMsg holds the data for a pyout message.
+// readSessionOf returns the copy of the session the read-only request of
+// receipt reads: that of its client, when their sessions are isolated.
+func (k *Kernel) readSessionOf(receipt MsgReceipt) *repl.Session {
+	if k.options().isolateSessions {
+		return k.clients.snapshot(receipt.Msg.Header.Session)
+	}
+	return k.readSession()
+}
+
+// OutputMsg holds the data for an execute_result message.
 type OutputMsg struct {
 	Execcount int                    `json:"execution_count"`
-	Data      map[string]string      `json:"data"`
+	Data      map[string]interface{} `json:"data"`
 	Metadata  map[string]interface{} `json:"metadata"`
 }
 
-// ErrMsg encodes the traceback of errors output to the notebook.
-type ErrMsg struct {
-	EName     string   `json:"ename"`
-	EValue    string   `json:"evalue"`
-	Traceback []string `json:"traceback"`
-}
-
 // HandleExecuteRequest runs code from an execute_request method, and sends the various
 // reply messages.
-func HandleExecuteRequest(receipt MsgReceipt) {
+func (k *Kernel) HandleExecuteRequest(receipt MsgReceipt) {
 
 	reply := NewMsg("execute_reply", receipt.Msg)
-	content := make(map[string]interface{})
 	reqcontent := receipt.Msg.Content.(map[string]interface{})
 	code := reqcontent["code"].(string)
 	silent := reqcontent["silent"].(bool)
+	if expr, detail, ok := inspectionQuery(code); ok {
+		k.HandleInspectionCell(receipt, expr, detail)
+		return
+	}
 	if !silent {
-		ExecCounter++
+		k.execCount++
 	}
-	content["execution_count"] = ExecCounter
+	k.diagnostics.cellStarted(receipt)
+	k.health.executeStarted()
+	defer func() { k.health.executeDone(k.execCount) }()
+	store, ok := reqcontent["store_history"].(bool)
+	store = !silent && (store || !ok)
+	if store {
+		k.history.record(k.execCount, code)
+	}
+
+	// Magic cells are run by their magics rather than evaluated as Go, and
+	// so are the cells formatted before they run.
+	reply.Metadata = make(map[string]interface{})
+	var payload []map[string]interface{}
+	var errContent *KernelError
+	config := k.options()
+	if isMagicCell(code) || config.autoformat {
+		payload, errContent = k.runMagics(receipt, code, silent, reply.Metadata)
+	} else {
+		errContent = k.runCode(receipt, code, silent, reply.Metadata)
+	}
+
+	content := executeReplyContent(receipt, k.execCount, payload, errContent, config.noColor)
 
-	// Do the compilation/execution magic.
-	val, stderr, err := REPLSession.Eval(code)
+	if store {
+		if err := k.history.save(k.execCount); err != nil {
+			k.diagnose(diagWarning, "history", "could not save the history", "error", err)
+		}
+	}
+
+	// The requests answered while the next cells run read the session as
+	// this one leaves it.
+	k.takeSnapshot()
 
-	if err == nil {
+	// Send the reply to the notebook once all of the output of the cell is
+	// published, and so before the idle status: the display relays and the
+	// commands of the cell drain their output before they return, for
+	// frontends such as nbclient to find every output of the cell before the
+	// idle status.
+	reply.Content = content
+	k.diagnostics.cellDone()
+	receipt.SendResponse(receipt.Sockets.ShellSocket, reply)
+}
+
+// executeReplyContent returns the content of the execute_reply to a cell run
+// as execution count, which failed with errContent unless it is nil, in which
+// case it returned payload; the error is published as well.
+func executeReplyContent(receipt MsgReceipt, count int, payload []map[string]interface{}, errContent *KernelError, noColor bool) map[string]interface{} {
+	content := map[string]interface{}{"execution_count": count}
+	if errContent == nil {
+		if payload == nil {
+			payload = make([]map[string]interface{}, 0)
+		}
 		content["status"] = "ok"
-		content["payload"] = make([]map[string]interface{}, 0)
+		content["payload"] = payload
 		content["user_variables"] = make(map[string]string)
 		content["user_expressions"] = make(map[string]string)
-		if len(val) > 0 && !silent {
-			var outContent OutputMsg
-			out := NewMsg("pyout", receipt.Msg)
-			outContent.Execcount = ExecCounter
-			outContent.Data = make(map[string]string)
-			outContent.Data["text/plain"] = fmt.Sprint(val)
-			outContent.Metadata = make(map[string]interface{})
-			out.Content = outContent
-			receipt.SendResponse(receipt.Sockets.IOPubSocket, out)
-		}
 	} else {
-		content["status"] = "error"
-		content["ename"] = "ERROR"
-		content["evalue"] = err.Error()
-		content["traceback"] = []string{stderr.String()}
-		errormsg := NewMsg("pyerr", receipt.Msg)
-		errormsg.Content = ErrMsg{"Error", err.Error(), []string{stderr.String()}}
+		if noColor {
+			errContent.Traceback = uncolored(errContent.Traceback)
+		}
+		for k, v := range errContent.replyContent() {
+			content[k] = v
+		}
+		errormsg := NewMsg("error", receipt.Msg)
+		errormsg.Content = *errContent
 		receipt.SendResponse(receipt.Sockets.IOPubSocket, errormsg)
 	}
+	return content
+}
 
-	// send the output back to the notebook
-	reply.Content = content
-	receipt.SendResponse(receipt.Sockets.ShellSocket, reply)
-	idle := NewMsg("status", receipt.Msg)
-	idle.Content = KernelStatus{"idle"}
-	receipt.SendResponse(receipt.Sockets.IOPubSocket, idle)
+// runCode evaluates code as the code of a cell, publishing what it displays
+// and its result, and returns the error it failed with, if any. What the code
+// adds to the metadata of the execute_reply goes to metadata.
+func (k *Kernel) runCode(receipt MsgReceipt, code string, silent bool, metadata map[string]interface{}) *KernelError {
+	// Publish display output from the cell while it runs.
+	relay, err := k.startDisplayRelay(receipt, silent, metadata)
+	if err != nil {
+		k.diagnose(diagError, "display", "could not start the display relay: the displays of the cell are not shown", "error", err)
+	}
+
+	if err := ioutil.WriteFile(k.files.cell, []byte(code), 0644); err != nil {
+		k.diagnose(diagWarning, "cell", "could not write the cell file: stack traces are not located in the cell", "error", err)
+	}
+	k.writeCommState()
+
+	// Do the compilation/execution magic, stopping at the breakpoints of
+	// the debugger.
+	// What the cell writes to stdout is published as it comes, and its
+	// results once it is done.
+	k.interrupts.started(receipt, code, append([]string{k.session.FilePath}, k.session.ExtraFilePaths...), k.session.ModuleContext().Dirs)
+	if relay != nil && !silent {
+		k.session.Stdout = relay.Stdout()
+	}
+	val, stderr, err := k.session.Eval(k.instrumentBreakpoints(code))
+	k.session.Stdout = nil
+	k.interrupts.done()
+	if relay != nil {
+		relay.Stop()
+		val += relay.Results()
+	}
+	k.debugCellDone()
+
+	if err != nil {
+		errContent := newErrMsg(err, stderr.String(), sessionSource(k.session, code), !k.options().noColor)
+		return &errContent
+	}
+	if len(val) > 0 && !silent {
+		var outContent OutputMsg
+		out := NewMsg("execute_result", receipt.Msg)
+		outContent.Execcount = k.execCount
+		outContent.Data = make(map[string]interface{})
+		outContent.Data["text/plain"] = fmt.Sprint(val)
+		outContent.Metadata = make(map[string]interface{})
+		out.Content = outContent
+		receipt.SendResponse(receipt.Sockets.IOPubSocket, out)
+		k.history.recordOutput(k.execCount, val)
+	}
+	return nil
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+)
+
+func init() {
+	RegisterLineMagic("export", "%export <dir>", "write the code of the session to a directory, as a program of its own\nThe statements of the cells run in its main function, along with the latest declarations of the cells and a go.mod requiring what the session does. Magics and shell commands are skipped, and values displayed are printed with fmt.Println.", exportMagic)
+}
+
+// exportMagic writes the code of the session to the directory given, as a Go
+// program of its own, reporting what it skipped: the magics and shell
+// commands of the cells run, and what the runtime package did.
+func exportMagic(ctx *MagicContext, args []string, body string) error {
+	if len(args) != 1 {
+		return errors.New("a directory is needed")
+	}
+	var skipped []string
+	for _, e := range ctx.Kernel.history.current() {
+		if e.Count != ctx.Kernel.execCount {
+			_, s := cellGoCode(fmt.Sprint("cell ", e.Count), e.Code)
+			skipped = append(skipped, s...)
+		}
+	}
+	dir := expandPath(args[0])
+	report, err := exportSession(ctx.Session, dir, skipped)
+	if err != nil {
+		return err
+	}
+	ctx.Stream("stdout", report)
+	return nil
+}
+
+// runExport runs the export subcommand with args: it writes the code cells of
+// a notebook to --output as a Go program, as %export does for the cells run,
+// without running them, and reports what it skipped to w.
+func runExport(args []string, w io.Writer) error {
+	var output string
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(w)
+	flags.StringVar(&output, "output", "", "Write the program to the directory `DIR`")
+	flags.StringVar(&output, "o", "", "Shorthand for --output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || output == "" {
+		return errors.New("usage: gophernotes export -o dir notebook.ipynb")
+	}
+	path := flags.Arg(0)
+	nb, err := readNotebook(path)
+	if err != nil {
+		return err
+	}
+	output, err = filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+
+	// Imports resolve in the directory of the notebook, as they do when its
+	// cells run.
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	defer os.Chdir(wd)
+
+	r, err := newNotebookRunner(0)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	var skipped []string
+	cells, _ := nb["cells"].([]interface{})
+	for i, c := range cells {
+		cell, ok := c.(map[string]interface{})
+		if !ok || cell["cell_type"] != "code" {
+			continue
+		}
+		label := fmt.Sprint("cell ", i+1)
+		code, s := cellGoCode(label, cellSource(cell["source"]))
+		skipped = append(skipped, s...)
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+		if err := r.kernel.session.Record(code); err != nil {
+			skipped = append(skipped, fmt.Sprintf("Skipped %s: %s", label, err))
+		}
+	}
+	report, err := exportSession(r.kernel.session, output, skipped)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(w, report)
+	return nil
+}
+
+// cellGoCode returns the Go code of the cell code, labeled label, without its
+// magics and shell commands, along with notes telling those skipped.
+func cellGoCode(label, code string) (string, []string) {
+	trimmed := strings.TrimLeft(code, " \t\r\n")
+	if strings.HasPrefix(trimmed, "%%") {
+		line := strings.SplitN(trimmed, "\n", 2)[0]
+		return "", []string{fmt.Sprintf("Skipped %s, run by %s", label, strings.TrimSpace(line))}
+	}
+	if _, _, ok := inspectionQuery(code); ok {
+		return "", nil
+	}
+	lines := strings.Split(code, "\n")
+	var goLines, skipped []string
+	for i, magic := range magicLines(lines) {
+		if !magic {
+			goLines = append(goLines, lines[i])
+			continue
+		}
+		skipped = append(skipped, fmt.Sprintf("Skipped %s of %s", strings.TrimSpace(lines[i]), label))
+	}
+	return strings.Join(goLines, "\n"), skipped
+}
+
+// exportSession writes the code of session s to dir as a program of its own,
+// with the files of Export, and returns the report of what it wrote, along
+// with the notes skipped and those of Export. A decls.go left by an earlier
+// export is removed if the session declares nothing of the kind.
+func exportSession(s *repl.Session, dir string, skipped []string) (string, error) {
+	module := strings.Replace(filepath.Base(dir), " ", "-", -1)
+	if module == "." || module == string(filepath.Separator) {
+		module = "main"
+	}
+	files, notes, err := s.Export(module)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(files))
+	for name, b := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			return "", err
+		}
+		names = append(names, name)
+	}
+	if _, ok := files["decls.go"]; !ok {
+		os.Remove(filepath.Join(dir, "decls.go"))
+	}
+	sort.Strings(names)
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "Wrote %s to %s.\n", strings.Join(names, ", "), dir)
+	for _, note := range append(skipped, notes...) {
+		fmt.Fprintln(&report, note+".")
+	}
+	return report.String(), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runExported builds the program exported to dir with the go command alone,
+// in module mode and offline, and returns its output.
+func runExported(t *testing.T, dir string) string {
+	env := append(os.Environ(), "GO111MODULE=on", "GOFLAGS=-mod=mod", "GOWORK=off", "GOPROXY=off")
+	bin := filepath.Join(dir, "exported")
+	build := exec.Command("go", "build", "-o", bin, ".")
+	build.Dir, build.Env = dir, env
+	out, err := build.CombinedOutput()
+	if !assert.NoError(t, err, string(out)) {
+		return ""
+	}
+	out, err = exec.Command(bin).Output()
+	noError(t, err)
+	return string(out)
+}
+
+// TestRunExport tests that gophernotes export writes the code cells of a
+// notebook as a program building on its own: the latest declarations of the
+// cells, their statements in order, and the values displayed printed, the
+// magics and shell commands skipped and reported
+func TestRunExport(t *testing.T) {
+	path, cleanup := testNotebook(t,
+		[2]string{"markdown", "# Title"},
+		[2]string{"code", ":import strings"},
+		[2]string{"code", "func shout(s string) string {\n\treturn s\n}"},
+		[2]string{"code", "count := 1\n%time count = 2\n!echo hi"},
+		[2]string{"code", "%%bash\necho hi"},
+		[2]string{"code", "func shout(s string) string {\n\treturn strings.ToUpper(s) + \"!\"\n}"},
+		[2]string{"code", "gophernotes.Markdown(shout(\"hi\"))"},
+		[2]string{"code", "gophernotes.ClearOutput(false)"},
+		[2]string{"code", "count++\nunused := count"},
+		[2]string{"code", ":import fmt\nfmt.Println(count)"},
+	)
+	defer cleanup()
+	dir := filepath.Join(filepath.Dir(path), "exported")
+	var report bytes.Buffer
+	noError(t, runExport([]string{"-o", dir, path}, &report))
+
+	assert.Equal(t, "Wrote decls.go, go.mod, main.go to "+dir+".\n"+
+		"Skipped %time count = 2 of cell 4.\n"+
+		"Skipped !echo hi of cell 4.\n"+
+		"Skipped cell 5, run by %%bash.\n"+
+		"Printed the values given to gophernotes.Markdown with fmt.Println.\n"+
+		"Dropped the calls to gophernotes.ClearOutput.\n", report.String())
+	decls, err := ioutil.ReadFile(filepath.Join(dir, "decls.go"))
+	noError(t, err)
+	assert.Equal(t, 1, strings.Count(string(decls), "func shout"))
+	assert.Equal(t, "HI!\n2\n", runExported(t, dir))
+}
+
+// TestExportMagic tests that %export writes the code of the session as a
+// program building on its own, reporting the magics of the cells run
+func TestExportMagic(t *testing.T) {
+	_, cleanup := testNotebook(t)
+	defer cleanup()
+	var published []ComposedMsg
+	k, err := NewKernel(SocketGroup{deliver: func(msg ComposedMsg) {
+		published = append(published, msg)
+	}}, log.New(ioutil.Discard, "", 0), defaultConfig())
+	noError(t, err)
+	defer k.removeSession()
+	execute := func(code string) []ComposedMsg {
+		published = nil
+		k.HandleExecuteRequest(MsgReceipt{Msg: newExecuteRequest(code), Sockets: k.sockets})
+		return published
+	}
+
+	execute("exportY := 3")
+	execute("func double(n int) int {\n\treturn n * 2\n}")
+	execute(":import fmt\nfmt.Println(double(exportY))")
+	execute("%env EXPORT_TEST=1")
+	dir, err := ioutil.TempDir("", "gophernotes_export")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+	assert.Equal(t, "Wrote decls.go, go.mod, main.go to "+dir+".\nSkipped %env EXPORT_TEST=1 of cell 4.\n", streamText(execute("%export "+dir), "stdout"))
+	assert.Equal(t, "6\n", runExported(t, dir))
+}
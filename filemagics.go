@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterLineMagic("load", "%load <path> | <url>", "replace the cell with the content of a file or URL", loadMagic)
+	RegisterCellMagic("writefile", "%%writefile [-a] [<path>]", "write the rest of the cell to a file\n-a appends to the file instead of replacing it. Without a path, the cell goes to a new file in the temporary directory of the kernel.", writefileMagic)
+}
+
+// maxLoadSize is the size of the content %load takes at most.
+const maxLoadSize = 10 << 20
+
+// loadTimeout is how long %load waits for the content of a URL.
+var loadTimeout = 30 * time.Second
+
+// loadMagic replaces the cell with the content of the file given, relative to
+// the working directory of the kernel, or of the http or https URL given,
+// following the line of the magic, commented out.
+func loadMagic(ctx *MagicContext, args []string, body string) error {
+	if len(args) != 1 {
+		return errors.New("a file or URL is needed")
+	}
+	src := args[0]
+
+	var content []byte
+	var err error
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		content, err = fetch(src)
+	} else {
+		content, err = readLimited(expandPath(src))
+	}
+	if err != nil {
+		return err
+	}
+	ctx.SetNextInput("// %load "+ctx.Args+"\n"+string(content), true)
+	return nil
+}
+
+// fetch returns the content of url, which must come with a status of 200.
+func fetch(url string) ([]byte, error) {
+	client := &http.Client{Timeout: loadTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return readAllLimited(resp.Body, url)
+}
+
+// readLimited returns the content of the file at path, up to maxLoadSize.
+func readLimited(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readAllLimited(f, path)
+}
+
+// readAllLimited reads r, the content of name, up to maxLoadSize.
+func readAllLimited(r io.Reader, name string) ([]byte, error) {
+	content, err := ioutil.ReadAll(io.LimitReader(r, maxLoadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxLoadSize {
+		return nil, fmt.Errorf("%s is larger than %d MiB", name, maxLoadSize>>20)
+	}
+	return content, nil
+}
+
+// writefileMagic writes the rest of the cell to the file given, relative to
+// the working directory of the kernel, creating the directories leading to it;
+// with -a, the cell is appended to the file. Without a file, the cell is
+// written to a new one in the temporary directory of the kernel.
+func writefileMagic(ctx *MagicContext, args []string, body string) error {
+	appending := false
+	if len(args) > 0 && args[0] == "-a" {
+		appending, args = true, args[1:]
+	}
+	if len(args) == 0 && !appending {
+		f, err := ioutil.TempFile(ctx.TempDir(), "writefile_")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		args = []string{f.Name()}
+	}
+	if len(args) != 1 {
+		return errors.New("a file is needed")
+	}
+	path := expandPath(args[0])
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	verb := "Wrote"
+	if appending {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		verb = "Appended"
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	n, err := io.WriteString(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	ctx.Stream("stdout", fmt.Sprintf("%s %d bytes to %s\n", verb, n, args[0]))
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFileMagics tests that %%writefile writes and appends the rest of the
+// cell to files relative to the working directory, creating directories, and
+// that %load replaces the cell with the content of a file or URL after the
+// line of the magic, commented out
+func TestFileMagics(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	dir, err := ioutil.TempDir("", "gophernotes_files")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, published := c.execute("%cd " + dir)
+	defer c.execute("%cd -")
+	assert.Equal(t, "", streamText(published, "stderr"))
+
+	reply, published := c.execute("%%writefile sub/helper.go\nfunc helper() int {\n\treturn 1\n}\n")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Equal(t, "Wrote 32 bytes to sub/helper.go\n", streamText(published, "stdout"))
+	_, published = c.execute("%%writefile -a sub/helper.go\n// More.\n")
+	assert.Equal(t, "Appended 9 bytes to sub/helper.go\n", streamText(published, "stdout"))
+	content, err := ioutil.ReadFile(filepath.Join(dir, "sub", "helper.go"))
+	noError(t, err)
+	assert.Equal(t, "func helper() int {\n\treturn 1\n}\n// More.\n", string(content))
+
+	load := func(code string) interface{} {
+		reply, _ := c.execute(code)
+		content := reply.Content.(map[string]interface{})
+		assert.Equal(t, "ok", content["status"], content["evalue"])
+		payload, _ := content["payload"].([]interface{})
+		if !assert.Len(t, payload, 1) {
+			return nil
+		}
+		p := payload[0].(map[string]interface{})
+		assert.Equal(t, "set_next_input", p["source"])
+		assert.Equal(t, true, p["replace"])
+		return p["text"]
+	}
+	assert.Equal(t, "// %load sub/helper.go\n"+string(content), load("%load sub/helper.go"))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/x.go" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "x := 1\n")
+	}))
+	defer server.Close()
+	assert.Equal(t, "// %load "+server.URL+"/x.go\nx := 1\n", load("%load "+server.URL+"/x.go"))
+
+	for _, code := range []string{"%load " + server.URL + "/missing.go", "%load no/such/file.go", "%%writefile sub/helper.go/x\n"} {
+		reply, _ := c.execute(code)
+		assert.Equal(t, "error", reply.Content.(map[string]interface{})["status"], code)
+	}
+}
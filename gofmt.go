@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+func init() {
+	RegisterLineMagic("gofmt", "%gofmt [-check]", "format the rest of the cell with gofmt and run it, or tell whether it is formatted\nThe cell is rewritten in the notebook with the formatted code.\n-check tells whether the cell is formatted without running it.", gofmtMagic)
+	registerBoolConfig("autoformat", "format cells with gofmt before running them, as %gofmt does", func(c *kernelConfig) *bool { return &c.autoformat })
+}
+
+// gofmtMagic formats the rest of the cell with gofmt, rewrites the cell in
+// the notebook with it, if it changed, and runs it; code that cannot be
+// formatted runs as it is, for its errors to be reported. With -check, the
+// cell is not run, but whether it is formatted is told.
+func gofmtMagic(ctx *MagicContext, args []string, body string) error {
+	check := false
+	for _, arg := range args {
+		if arg != "-check" {
+			return fmt.Errorf("unknown flag %s", arg)
+		}
+		check = true
+	}
+
+	if check {
+		formatted, err := formatCell(body)
+		switch {
+		case err != nil:
+			return err
+		case formatted == body:
+			ctx.Stream("stdout", "The cell is formatted.\n")
+		default:
+			ctx.Stream("stdout", "The cell is not formatted.\n")
+		}
+		return nil
+	}
+
+	head := "%gofmt\n"
+	if ctx.Args != "" {
+		head = "%gofmt " + ctx.Args + "\n"
+	}
+	if errContent := ctx.runFormatted(head, body); errContent != nil {
+		return errContent
+	}
+	return nil
+}
+
+// runFormatted runs code formatted with gofmt, after having the cell in the
+// notebook replaced by head followed by the formatted code, if formatting
+// changed it. Code that cannot be formatted runs as it is.
+func (ctx *MagicContext) runFormatted(head, code string) *KernelError {
+	formatted, err := formatCell(code)
+	if err != nil {
+		return ctx.runCell(code)
+	}
+	if formatted != code {
+		ctx.SetNextInput(head+formatted, true)
+	}
+	return ctx.runCell(formatted)
+}
+
+// gofmtCell splits a cell starting with a %gofmt line into that line, without
+// its "%", and the rest of the cell.
+func gofmtCell(code string) (line, rest string, ok bool) {
+	trimmed := strings.TrimLeft(code, " \t\r\n")
+	if !strings.HasPrefix(trimmed, "%") || strings.HasPrefix(trimmed, "%%") {
+		return "", "", false
+	}
+	line = trimmed[1:]
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line, rest = line[:i], line[i+1:]
+	}
+	return line, rest, magicName(line) == "gofmt"
+}
+
+// formatCell formats the Go code of a cell with gofmt, leaving imports as they
+// are but for their order. The lines of magics, shell commands and REPL
+// commands such as ":import" are kept as they are, the code between them
+// formatted on its own.
+func formatCell(code string) (string, error) {
+	lines := strings.Split(code, "\n")
+	magic := magicLines(lines)
+	var out, goLines []string
+	flush := func() error {
+		if len(goLines) == 0 {
+			return nil
+		}
+		src := strings.Join(goLines, "\n")
+		goLines = nil
+		if strings.TrimSpace(src) == "" {
+			out = append(out, src)
+			return nil
+		}
+		formatted, err := format.Source([]byte(src))
+		if err != nil {
+			return err
+		}
+		out = append(out, string(formatted))
+		return nil
+	}
+	for i, line := range lines {
+		if !magic[i] && !strings.HasPrefix(strings.TrimSpace(line), ":") {
+			goLines = append(goLines, line)
+			continue
+		}
+		if err := flush(); err != nil {
+			return "", err
+		}
+		out = append(out, line)
+	}
+	if err := flush(); err != nil {
+		return "", err
+	}
+	return strings.Join(out, "\n"), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFormatCell tests that the Go code of cells is formatted, between the
+// lines of magics and REPL commands, which are kept as they are
+func TestFormatCell(t *testing.T) {
+	formatted, err := formatCell("x:=1+2\n%time\n:import fmt\nif x>2{\nfmt.Println( x )\n}\n")
+	noError(t, err)
+	assert.Equal(t, "x := 1 + 2\n%time\n:import fmt\nif x > 2 {\n\tfmt.Println(x)\n}\n", formatted)
+
+	formatted, err = formatCell("x := 1\n")
+	noError(t, err)
+	assert.Equal(t, "x := 1\n", formatted)
+
+	_, err = formatCell("x := (1\n")
+	assert.Error(t, err)
+}
+
+// TestGofmtMagic tests that %gofmt runs the rest of the cell formatted, having
+// the cell rewritten with it, that code that cannot be formatted runs as it is,
+// that -check tells whether the cell is formatted, and that with the
+// autoformat option, all cells are formatted
+func TestGofmtMagic(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+	defer c.execute("%reset -f")
+
+	nextInput := func(content map[string]interface{}) interface{} {
+		payload := content["payload"].([]interface{})
+		if len(payload) != 1 {
+			return nil
+		}
+		p := payload[0].(map[string]interface{})
+		assert.Equal(t, "set_next_input", p["source"])
+		assert.Equal(t, true, p["replace"])
+		return p["text"]
+	}
+
+	reply, published := c.execute("%gofmt\nfmtX:=40+2\nfmtX")
+	content := reply.Content.(map[string]interface{})
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	assert.Equal(t, "%gofmt\nfmtX := 40 + 2\nfmtX", nextInput(content))
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "42\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+
+	reply, _ = c.execute("%gofmt\nfmtY := 1")
+	assert.Empty(t, reply.Content.(map[string]interface{})["payload"])
+
+	_, published = c.execute("%gofmt -check\nfmtZ:=1")
+	assert.Equal(t, "The cell is not formatted.\n", streamText(published, "stdout"))
+	_, published = c.execute("%gofmt -check\nfmtZ := 1")
+	assert.Equal(t, "The cell is formatted.\n", streamText(published, "stdout"))
+
+	reply, _ = c.execute("fmtY := 2\n%gofmt")
+	assert.Equal(t, "error", reply.Content.(map[string]interface{})["status"])
+
+	_, published = c.execute("%config autoformat")
+	assert.Equal(t, "autoformat = off\n", streamText(published, "stdout"))
+	c.execute("%config autoformat on")
+	defer c.execute("%config autoformat off")
+	reply, _ = c.execute("fmtW:=[]int{1,2}")
+	content = reply.Content.(map[string]interface{})
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	assert.Equal(t, "fmtW := []int{1, 2}", nextInput(content))
+
+	reply, _ = c.execute("%gofmt\nfmtV := undefinedName +")
+	content = reply.Content.(map[string]interface{})
+	assert.Equal(t, "error", content["status"])
+	assert.Empty(t, content["payload"])
+}
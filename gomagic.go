@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+)
+
+func init() {
+	RegisterLineMagic("go", "%go get [-u] <module>[@<version>]... | mod list | mod env | mod reload", "manage the modules the session requires\nget requires the modules given, at their latest version unless one is given, or updates them with -u.\nmod list lists the modules required.\nmod env shows the module context imports resolve in, and the environment of the go command.\nmod reload reads the module context again, along with the packages of its modules.", goMagic)
+}
+
+// goMagic runs the go command in the module of the session: get requires the
+// modules given, at the versions given or, with -u, upgraded along with their
+// requirements, for the packages of the modules to be imported by the next
+// cells; mod list lists the modules required; mod env shows the module
+// context and the environment the go command runs with; mod reload reads the
+// context again.
+func goMagic(ctx *MagicContext, args []string, body string) error {
+	switch {
+	case len(args) > 0 && args[0] == "get":
+		return goGet(ctx, args[1:])
+	case len(args) == 2 && args[0] == "mod" && args[1] == "list":
+		return goModList(ctx)
+	case len(args) == 2 && args[0] == "mod" && args[1] == "env":
+		return goModEnv(ctx)
+	case len(args) == 2 && args[0] == "mod" && args[1] == "reload":
+		return goModReload(ctx)
+	case len(args) == 0:
+		return errors.New("a subcommand is needed: get, mod list, mod env or mod reload")
+	}
+	return fmt.Errorf("unknown subcommand %q: use get, mod list, mod env or mod reload", strings.Join(args, " "))
+}
+
+// goGet runs go get with args in the module of the session, which it makes
+// one first, and prints the versions of the modules required, followed by the
+// changes to their requirements.
+func goGet(ctx *MagicContext, args []string) error {
+	getArgs := []string{"get"}
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if args[0] != "-u" {
+			return fmt.Errorf("unknown flag %s", args[0])
+		}
+		getArgs, args = append(getArgs, args[0]), args[1:]
+	}
+	if len(args) == 0 {
+		return errors.New("a module is needed")
+	}
+
+	s := ctx.Session
+	if err := s.InitModules(); err != nil {
+		return err
+	}
+	before, err := s.ModuleList()
+	if err != nil {
+		return err
+	}
+	out, err := s.GoCommand(append(getArgs, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("go get failed, with GOPROXY=%s:\n%s", goProxy(s), strings.TrimSpace(string(out)))
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "go: downloading ") {
+			ctx.Kernel.diagnose(diagInfo, "modules", "downloaded "+strings.TrimPrefix(line, "go: downloading "))
+		}
+	}
+	s.ResetModules()
+	after, err := s.ModuleList()
+	if err != nil {
+		return err
+	}
+
+	var named []string
+	for _, arg := range args {
+		named = append(named, strings.SplitN(arg, "@", 2)[0])
+	}
+	ctx.Stream("stdout", moduleChanges(named, before, after))
+	return nil
+}
+
+// goProxy returns the GOPROXY the go command of the session uses.
+func goProxy(s *repl.Session) string {
+	out, err := s.GoCommand("env", "GOPROXY").Output()
+	if err != nil {
+		return "?"
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// moduleChanges describes how the modules required went from before to after
+// a go get of the packages or modules named: the module providing each of
+// named with its version, then the modules added, upgraded, downgraded or
+// dropped along.
+func moduleChanges(named []string, before, after []repl.Module) string {
+	versions := map[string]string{}
+	for _, m := range before {
+		versions[m.Path] = m.Version
+	}
+
+	var buf bytes.Buffer
+	shown := map[string]bool{}
+	for _, path := range named {
+		var found *repl.Module
+		for i, m := range after {
+			if (path == m.Path || strings.HasPrefix(path, m.Path+"/")) && (found == nil || len(m.Path) > len(found.Path)) {
+				found = &after[i]
+			}
+		}
+		if found != nil && !shown[found.Path] {
+			shown[found.Path] = true
+			fmt.Fprintf(&buf, "%s %s\n", found.Path, found.Version)
+		}
+	}
+
+	for _, m := range after {
+		old, ok := versions[m.Path]
+		delete(versions, m.Path)
+		switch {
+		case shown[m.Path] || old == m.Version:
+		case !ok:
+			fmt.Fprintf(&buf, "  added %s %s\n", m.Path, m.Version)
+		case semverLess(old, m.Version):
+			fmt.Fprintf(&buf, "  upgraded %s %s => %s\n", m.Path, old, m.Version)
+		default:
+			fmt.Fprintf(&buf, "  downgraded %s %s => %s\n", m.Path, old, m.Version)
+		}
+	}
+	for _, m := range before {
+		if _, ok := versions[m.Path]; ok {
+			fmt.Fprintf(&buf, "  dropped %s %s\n", m.Path, m.Version)
+		}
+	}
+	return buf.String()
+}
+
+// semverLess reports whether version a precedes b, comparing their major,
+// minor and patch numbers, and the rest as text.
+func semverLess(a, b string) bool {
+	pa, pb := semverParts(a), semverParts(b)
+	for i := 0; i < 3; i++ {
+		if pa.numbers[i] != pb.numbers[i] {
+			return pa.numbers[i] < pb.numbers[i]
+		}
+	}
+	switch {
+	case pa.rest == pb.rest:
+		return false
+	case pa.rest == "":
+		return false
+	case pb.rest == "":
+		return true
+	}
+	return pa.rest < pb.rest
+}
+
+type semver struct {
+	numbers [3]int
+	rest    string
+}
+
+// semverParts splits a version such as v1.2.3-pre into its numbers and what
+// follows them.
+func semverParts(v string) semver {
+	var p semver
+	v = strings.TrimPrefix(v, "v")
+	for i := 0; i < 3; i++ {
+		n := 0
+		for n < len(v) && v[n] >= '0' && v[n] <= '9' {
+			p.numbers[i] = p.numbers[i]*10 + int(v[n]-'0')
+			n++
+		}
+		v = v[n:]
+		if i < 2 {
+			v = strings.TrimPrefix(v, ".")
+		}
+	}
+	p.rest = v
+	return p
+}
+
+// goModList prints the modules the session requires, with their versions.
+func goModList(ctx *MagicContext) error {
+	mods, err := ctx.Session.ModuleList()
+	if err != nil {
+		return err
+	}
+	if len(mods) == 0 {
+		ctx.Stream("stdout", "No modules required.\n")
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, m := range mods {
+		fmt.Fprintf(&buf, "%s %s\n", m.Path, m.Version)
+	}
+	ctx.Stream("stdout", buf.String())
+	return nil
+}
+
+// goModEnvVars are the variables of the environment of the go command that
+// %go mod env shows.
+var goModEnvVars = []string{"GO111MODULE", "GOFLAGS", "GOWORK", "GOMOD"}
+
+// goModEnv prints the module context of the session, followed by the
+// variables of goModEnvVars, as the go command of the session has them.
+func goModEnv(ctx *MagicContext) error {
+	s := ctx.Session
+	var stderr bytes.Buffer
+	cmd := s.GoCommand(append([]string{"env"}, goModEnvVars...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("go env: %s", strings.TrimSpace(stderr.String()))
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Imports resolve in %s\n", s.ModuleContext())
+	for i, value := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if i < len(goModEnvVars) {
+			fmt.Fprintf(&buf, "%s=%s\n", goModEnvVars[i], value)
+		}
+	}
+	ctx.Stream("stdout", buf.String())
+	return nil
+}
+
+// goModReload reads the module context of the working directory again, for
+// the changes to its go.work or go.mod files to be seen, and drops what is
+// known of the packages the session imports, for those of the context to be
+// loaded again from their files as they are; changes to those files are
+// otherwise seen before each cell runs.
+func goModReload(ctx *MagicContext) error {
+	if _, err := ctx.Kernel.useModuleContext(true); err != nil {
+		return err
+	}
+	ctx.Session.ResetModules()
+	ctx.Kernel.takeSnapshot()
+	ctx.Stream("stdout", fmt.Sprintf("Imports resolve in %s\n", ctx.Session.ModuleContext()))
+	return nil
+}
+
+// useModuleContext has the imports of cells resolve in the module context of
+// the working directory of the kernel, unless modules are off, and reports
+// whether the context changed; with reload, the files of the context are read
+// again even if it is the same. Once cells import packages of a context, the
+// context is kept for the session to still build, the error telling.
+func (k *Kernel) useModuleContext(reload bool) (bool, error) {
+	if os.Getenv("GO111MODULE") == "off" {
+		return false, nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return false, err
+	}
+	c, err := repl.FindModuleContext(wd)
+	if err != nil {
+		return false, err
+	}
+	s := k.session
+	current := s.ModuleContext()
+	if c.File == current.File && !reload {
+		return false, nil
+	}
+	if c.File != current.File && s.ImportsFrom(current) {
+		return false, fmt.Errorf("imports still resolve in %s, which cells import packages of, rather than in that of %s: restart the kernel for them to", current, wd)
+	}
+	if err := s.UseModuleContext(c); err != nil {
+		return false, err
+	}
+	k.takeSnapshot()
+	return c.File != current.File, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+)
+
+// TestModuleChanges tests that the versions of the modules got are followed by
+// the changes to the other requirements
+func TestModuleChanges(t *testing.T) {
+	before := []repl.Module{
+		{Path: "example.com/a", Version: "v1.0.0"},
+		{Path: "example.com/b", Version: "v1.2.0"},
+		{Path: "example.com/c", Version: "v0.3.0"},
+		{Path: "example.com/d", Version: "v0.1.0"},
+	}
+	after := []repl.Module{
+		{Path: "example.com/a", Version: "v1.1.0"},
+		{Path: "example.com/b", Version: "v1.10.0"},
+		{Path: "example.com/c", Version: "v0.2.0"},
+		{Path: "example.com/e", Version: "v2.0.0-pre"},
+	}
+	assert.Equal(t, "example.com/a v1.1.0\n"+
+		"  upgraded example.com/b v1.2.0 => v1.10.0\n"+
+		"  downgraded example.com/c v0.3.0 => v0.2.0\n"+
+		"  added example.com/e v2.0.0-pre\n"+
+		"  dropped example.com/d v0.1.0\n",
+		moduleChanges([]string{"example.com/a/sub"}, before, after))
+
+	assert.True(t, semverLess("v1.0.0-pre", "v1.0.0"))
+	assert.False(t, semverLess("v1.0.0", "v1.0.0"))
+	assert.True(t, semverLess("v0.9.9", "v0.10.0"))
+}
+
+// writeModuleProxy lays out in dir, as a GOPROXY would serve them, the
+// versions given of the module example.com/greet, whose package says hello
+// with the version.
+func writeModuleProxy(t *testing.T, dir string, versions ...string) {
+	versionDir := filepath.Join(dir, "example.com", "greet", "@v")
+	noError(t, os.MkdirAll(versionDir, 0755))
+	list := ""
+	for _, v := range versions {
+		list += v + "\n"
+		mod := "module example.com/greet\n"
+		noError(t, ioutil.WriteFile(filepath.Join(versionDir, v+".info"), []byte(`{"Version":"`+v+`"}`), 0644))
+		noError(t, ioutil.WriteFile(filepath.Join(versionDir, v+".mod"), []byte(mod), 0644))
+
+		f, err := os.Create(filepath.Join(versionDir, v+".zip"))
+		noError(t, err)
+		z := zip.NewWriter(f)
+		for name, content := range map[string]string{
+			"go.mod":   mod,
+			"greet.go": "package greet\n\nfunc Hello() string { return \"hello " + v + "\" }\n",
+		} {
+			w, err := z.Create("example.com/greet@" + v + "/" + name)
+			noError(t, err)
+			_, err = w.Write([]byte(content))
+			noError(t, err)
+		}
+		noError(t, z.Close())
+		noError(t, f.Close())
+	}
+	noError(t, ioutil.WriteFile(filepath.Join(versionDir, "list"), []byte(list), 0644))
+}
+
+// TestGoMagic tests that %go get requires modules from the GOPROXY, at the
+// versions asked or upgraded, for their packages to be imported by the next
+// cells, that failures name the GOPROXY, and that %go mod list lists the
+// modules required
+func TestGoMagic(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	// The session becomes a module, which the other tests do not expect.
+	saved := testKernel.session
+	s, err := repl.NewSession()
+	noError(t, err)
+	testKernel.session = s
+	defer func() { testKernel.session = saved }()
+
+	proxy, err := ioutil.TempDir("", "gophernotes_proxy")
+	noError(t, err)
+	defer os.RemoveAll(proxy)
+	writeModuleProxy(t, proxy, "v1.0.0", "v1.1.0")
+	s.Env = append(append([]string(nil), saved.Env...), "GOPROXY=file://"+filepath.ToSlash(proxy), "GOSUMDB=off", "GOFLAGS=-mod=mod")
+
+	status := func(code string) (string, map[string]interface{}) {
+		reply, published := c.execute(code)
+		content := reply.Content.(map[string]interface{})
+		return streamText(published, "stdout"), content
+	}
+
+	out, content := status("%go mod list")
+	assert.Equal(t, "No modules required.\n", out)
+
+	out, content = status("%go get example.com/greet@v1.0.0")
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	assert.Equal(t, "example.com/greet v1.0.0\n", out)
+
+	_, content = status(":import example.com/greet")
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	_, published := c.execute("greeting := greet.Hello()\ngreeting")
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "\"hello v1.0.0\"\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+
+	out, content = status("%go get -u example.com/greet")
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	assert.Equal(t, "example.com/greet v1.1.0\n", out)
+	_, published = c.execute("greeting")
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "\"hello v1.1.0\"\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+	out, _ = status("%go mod list")
+	assert.Equal(t, "example.com/greet v1.1.0\n", out)
+
+	_, content = status("%go get example.com/missing@v1.0.0")
+	assert.Equal(t, "error", content["status"])
+	assert.Contains(t, content["evalue"], "GOPROXY=file://")
+}
+
+// writeFiles writes the files of files, by path relative to dir, making their
+// directories.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		noError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		noError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+// TestModuleContext tests that once %cd enters a directory below a go.work,
+// cells import the packages of its modules, with the replace directives of
+// the go.work and of the modules, that %go mod env shows the context, and that
+// the context is kept once cells import its packages
+func TestModuleContext(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	saved := testKernel.session
+	s, err := repl.NewSession()
+	noError(t, err)
+	s.Env = saved.Env
+	testKernel.session = s
+	defer func() {
+		testKernel.session = saved
+		saved.ResetModules()
+	}()
+	wd, err := os.Getwd()
+	noError(t, err)
+	defer os.Chdir(wd)
+	defer os.Setenv("GO111MODULE", os.Getenv("GO111MODULE"))
+	os.Setenv("GO111MODULE", "")
+
+	dir, err := ioutil.TempDir("", "gophernotes_workspace")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	noError(t, err)
+	writeFiles(t, dir, map[string]string{
+		"go.work":           "go 1.18\n\nuse ./lib\n\nreplace example.com/other => ./other\n",
+		"lib/go.mod":        "module example.com/lib\n\nrequire (\n\texample.com/dep v0.0.0\n\texample.com/other v0.0.0\n)\n\nreplace example.com/dep => ../dep\n",
+		"lib/lib.go":        "package lib\n\nimport (\n\t\"example.com/dep\"\n\t\"example.com/other\"\n)\n\nfunc Hello() string { return \"hello \" + dep.Name + \" \" + other.Name }\n",
+		"lib/sub/empty.txt": "",
+		"dep/go.mod":        "module example.com/dep\n",
+		"dep/dep.go":        "package dep\n\nconst Name = \"dep\"\n",
+		"other/go.mod":      "module example.com/other\n",
+		"other/other.go":    "package other\n\nconst Name = \"other\"\n",
+		"single/go.mod":     "module example.com/single\n",
+	})
+	work := filepath.Join(dir, "go.work")
+	single, err := ioutil.TempDir("", "gophernotes_module")
+	noError(t, err)
+	defer os.RemoveAll(single)
+	writeFiles(t, single, map[string]string{"go.mod": "module example.com/single\n"})
+
+	run := func(code string) (map[string]interface{}, []ComposedMsg) {
+		reply, published := c.execute(code)
+		return reply.Content.(map[string]interface{}), published
+	}
+
+	content, published := run("%cd " + filepath.Join(dir, "lib", "sub"))
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	assert.Equal(t, filepath.Join(dir, "lib", "sub")+"\nImports now resolve in the workspace of "+work+"\n", streamText(published, "stdout"))
+
+	content, published = run("%go mod env")
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	out := streamText(published, "stdout")
+	assert.Contains(t, out, "Imports resolve in the workspace of "+work+"\n")
+	assert.Contains(t, out, "GOWORK="+filepath.Join(s.Dir(), "go.work")+"\n")
+
+	content, _ = run(":import example.com/lib")
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	content, published = run("greeting := lib.Hello()\ngreeting")
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "\"hello dep other\"\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+
+	var texts []string
+	completions, _, _ := s.Complete(":import example.com/li", 22)
+	for _, completion := range completions {
+		texts = append(texts, completion.Text)
+	}
+	assert.Contains(t, texts, "example.com/lib")
+	completions, _, _ = s.Complete(":import example.com/lib/", 24)
+	if assert.Len(t, completions, 1) {
+		assert.Equal(t, "example.com/lib/sub/", completions[0].Text)
+	}
+
+	// Changes to the files of the module show in the next cells, and so do
+	// their errors, at the files.
+	writeFiles(t, dir, map[string]string{"lib/bye.go": "package lib\n\nfunc Bye() string { return \"bye\" }\n"})
+	content, published = run("lib.Bye()")
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "\"bye\"\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+	broken := filepath.Join(dir, "lib", "broken.go")
+	writeFiles(t, dir, map[string]string{"lib/broken.go": "package lib\n\nvar broken int = \"x\"\n"})
+	_, published = run("greeting")
+	if assert.Equal(t, []string{"error"}, msgTypes(published)) {
+		pyerr := published[0].Content.(map[string]interface{})
+		assert.Equal(t, "CompileError", pyerr["ename"])
+		assert.Contains(t, fmt.Sprint(pyerr["traceback"]), broken+":3:18")
+	}
+	noError(t, os.Remove(broken))
+
+	content, published = run("%go mod reload")
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	assert.Equal(t, "Imports resolve in the workspace of "+work+"\n", streamText(published, "stdout"))
+
+	content, published = run("%cd " + single)
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	assert.Contains(t, streamText(published, "stderr"), "Warning: imports still resolve in the workspace of "+work)
+	assert.Equal(t, work, s.ModuleContext().File)
+}
@@ -7,13 +7,15 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	zmq "github.com/alecthomas/gozmq"
 	"github.com/pkg/errors"
 )
 
-var logger *log.Logger
-
 // ConnectionInfo stores the contents of the kernel connection file created by Jupyter.
 type ConnectionInfo struct {
 	SignatureScheme string `json:"signature_scheme"`
@@ -35,6 +37,21 @@ type SocketGroup struct {
 	StdinSocket   *zmq.Socket
 	IOPubSocket   *zmq.Socket
 	Key           []byte
+
+	// sends are the locks of the sockets, shared by the copies of the
+	// group, and logger logs the messages sent, if it is not nil.
+	sends  *sendLocks
+	logger *log.Logger
+
+	// context is the context of the sockets, and outbox, while serve runs,
+	// carries the messages on the shell and control sockets to it.
+	context *zmq.Context
+	outbox  *outbox
+
+	// deliver, if it is not nil, gets the messages sent in place of the
+	// sockets, for kernels running without them, as gophernotes run does.
+	// It may be called from several goroutines at once.
+	deliver func(msg ComposedMsg)
 }
 
 // PrepareSockets sets up the ZMQ sockets through which the kernel will communicate.
@@ -75,7 +92,7 @@ func createSockets() (*zmq.Context, SocketGroup, error) {
 		return context, SocketGroup{}, errors.Wrap(err, "Could not create zmq Context")
 	}
 
-	var sg SocketGroup
+	sg := SocketGroup{sends: newSendLocks(), context: context}
 	sg.ShellSocket, err = context.NewSocket(zmq.ROUTER)
 	if err != nil {
 		return context, sg, errors.Wrap(err, "Could not get Shell Socket")
@@ -99,24 +116,155 @@ func createSockets() (*zmq.Context, SocketGroup, error) {
 	return context, sg, nil
 }
 
+// readOnlyRequests are the shell requests that only read the session, which
+// are answered while cells run, from the copy of the session taken when the
+// last cell was done; see serve.
+var readOnlyRequests = map[string]bool{
+	"kernel_info_request": true,
+	"complete_request":    true,
+	"inspect_request":     true,
+	"object_info_request": true,
+	"comm_info_request":   true,
+	"history_request":     true,
+	"is_complete_request": true,
+}
+
+// shellQueueSize is how many shell requests wait for their turn before the
+// kernel stops receiving more.
+const shellQueueSize = 256
+
+// shellRequest is a shell request queued to be handled. A read-only request
+// waits for the requests run in order numbered up to after to be done. A
+// request with a subshellCell is the cell a subshell ran, to be recorded in
+// the session rather than handled.
+type shellRequest struct {
+	receipt      MsgReceipt
+	after        int
+	subshellCell string
+}
+
+// shellProgress counts the requests run in order that are done.
+type shellProgress struct {
+	sync.Mutex
+	cond *sync.Cond
+	done int
+}
+
+// runInOrder handles the shell requests of queue, which may change the
+// session, one after the other, recording each done with idle.
+func (k *Kernel) runInOrder(queue <-chan shellRequest, progress *shellProgress, idle *idleWatch) {
+	for req := range queue {
+		k.useClientSession(req.receipt)
+		if req.subshellCell != "" {
+			k.recordSubshellCell(req.subshellCell)
+			continue
+		}
+		k.handle(req.receipt, k.HandleShellMsg)
+		idle.done()
+		progress.Lock()
+		progress.done++
+		progress.cond.Broadcast()
+		progress.Unlock()
+	}
+}
+
+// runReadOnly handles the read-only shell requests of queue one after the
+// other, each once the requests run in order it waits for are done, recording
+// each done with idle.
+func (k *Kernel) runReadOnly(queue <-chan shellRequest, progress *shellProgress, idle *idleWatch) {
+	for req := range queue {
+		progress.Lock()
+		for progress.done < req.after {
+			progress.cond.Wait()
+		}
+		progress.Unlock()
+		k.handle(req.receipt, k.HandleShellMsg)
+		idle.done()
+	}
+}
+
+// runDebug handles the debug requests of queue one after the other, recording
+// each done with idle. They are answered while cells run, for the debugger to
+// go on from the breakpoints cells are stopped at.
+func (k *Kernel) runDebug(queue <-chan MsgReceipt, idle *idleWatch) {
+	for receipt := range queue {
+		k.handle(receipt, k.HandleDebugRequest)
+		idle.done()
+	}
+}
+
+// handle publishes the busy status, has f handle the request of receipt, and
+// then publishes the idle status, as the kernel does for every request.
+func (k *Kernel) handle(receipt MsgReceipt, f func(MsgReceipt)) {
+	publishStatus(receipt, "busy")
+	f(receipt)
+	publishStatus(receipt, "idle")
+}
+
+// publishStatus publishes the execution state of the kernel, as a child of
+// the request of receipt.
+func publishStatus(receipt MsgReceipt, state string) {
+	status := NewMsg("status", receipt.Msg)
+	status.Content = KernelStatus{state}
+	receipt.SendResponse(receipt.Sockets.IOPubSocket, status)
+}
+
 // HandleShellMsg responds to a message on the shell ROUTER socket.
-func HandleShellMsg(receipt MsgReceipt) {
+func (k *Kernel) HandleShellMsg(receipt MsgReceipt) {
 	switch receipt.Msg.Header.MsgType {
 	case "kernel_info_request":
-		SendKernelInfo(receipt)
+		SendKernelInfo(receipt, k.options().subshells)
 	case "execute_request":
-		HandleExecuteRequest(receipt)
-	case "shutdown_request":
-		HandleShutdownRequest(receipt)
+		k.HandleExecuteRequest(receipt)
+	case "complete_request":
+		k.HandleCompleteRequest(receipt)
+	case "inspect_request", "object_info_request":
+		k.HandleInspectRequest(receipt)
+	case "comm_open":
+		k.HandleCommOpen(receipt)
+	case "comm_msg":
+		k.HandleCommMsg(receipt)
+	case "comm_close":
+		k.HandleCommClose(receipt)
+	case "comm_info_request":
+		k.HandleCommInfoRequest(receipt)
+	case "history_request":
+		k.HandleHistoryRequest(receipt)
+	case "is_complete_request":
+		k.HandleIsCompleteRequest(receipt)
 	default:
-		logger.Println("Unhandled shell message:", receipt.Msg.Header.MsgType)
+		k.logger.Println("Unhandled shell message:", receipt.Msg.Header.MsgType)
+		k.sendErrorReply(receipt, unsupportedMessage(receipt.Msg.Header.MsgType))
 	}
 }
 
+// protocolVersion is the version of the messaging protocol the kernel speaks,
+// that of the debug requests; subshellsProtocolVersion, that of the subshells,
+// is reported instead once they are enabled.
+const (
+	protocolVersion          = "5.3"
+	subshellsProtocolVersion = "5.5"
+)
+
 // KernelInfo holds information about the igo kernel, for kernel_info_reply messages.
+// Debugger tells frontends they may send debug requests; see HandleDebugRequest.
+// SupportedFeatures lists the optional features of the protocol the kernel has.
 type KernelInfo struct {
-	ProtocolVersion []int  `json:"protocol_version"`
-	Language        string `json:"language"`
+	ProtocolVersion   string       `json:"protocol_version"`
+	Implementation    string       `json:"implementation"`
+	Language          string       `json:"language"`
+	LanguageInfo      LanguageInfo `json:"language_info"`
+	Debugger          bool         `json:"debugger"`
+	SupportedFeatures []string     `json:"supported_features,omitempty"`
+}
+
+// LanguageInfo describes the language of the cells, for kernel_info_reply
+// messages.
+type LanguageInfo struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	MIMEType      string `json:"mimetype"`
+	FileExtension string `json:"file_extension"`
 }
 
 // KernelStatus holds a kernel state, for status broadcast messages.
@@ -124,11 +272,28 @@ type KernelStatus struct {
 	ExecutionState string `json:"execution_state"`
 }
 
-// SendKernelInfo sends a kernel_info_reply message.
-func SendKernelInfo(receipt MsgReceipt) {
+// SendKernelInfo sends a kernel_info_reply message, advertising the subshells,
+// and the version of the protocol they come with, if they are enabled.
+func SendKernelInfo(receipt MsgReceipt, subshells bool) {
 	reply := NewMsg("kernel_info_reply", receipt.Msg)
-	reply.Content = KernelInfo{[]int{4, 0}, "go"}
-	receipt.SendResponse(receipt.Sockets.ShellSocket, reply)
+	info := KernelInfo{
+		ProtocolVersion: protocolVersion,
+		Implementation:  "gophernotes",
+		Language:        "go",
+		LanguageInfo: LanguageInfo{
+			Name:          "go",
+			Version:       strings.TrimPrefix(runtime.Version(), "go"),
+			MIMEType:      "text/x-go",
+			FileExtension: ".go",
+		},
+		Debugger: true,
+	}
+	if subshells {
+		info.ProtocolVersion = subshellsProtocolVersion
+		info.SupportedFeatures = []string{"kernel subshells"}
+	}
+	reply.Content = info
+	receipt.SendResponse(receipt.replySocket(), reply)
 }
 
 // ShutdownReply encodes a boolean indication of stutdown/restart
@@ -136,24 +301,40 @@ type ShutdownReply struct {
 	Restart bool `json:"restart"`
 }
 
-// HandleShutdownRequest sends a "shutdown" message
-func HandleShutdownRequest(receipt MsgReceipt) {
+// errShutdown is returned by serve once the kernel has been asked to shut
+// down.
+var errShutdown = errors.New("the kernel was asked to shut down")
+
+// HandleShutdownRequest sends a "shutdown" message, and shuts the kernel down,
+// for serve to return errShutdown once the reply is sent.
+func (k *Kernel) HandleShutdownRequest(receipt MsgReceipt) {
 	reply := NewMsg("shutdown_reply", receipt.Msg)
 	content := receipt.Msg.Content.(map[string]interface{})
 	restart := content["restart"].(bool)
 	reply.Content = ShutdownReply{restart}
-	receipt.SendResponse(receipt.Sockets.ShellSocket, reply)
-	logger.Println("Shutting down in response to shutdown_request")
-	os.Exit(0)
+	receipt.SendResponse(receipt.replySocket(), reply)
+	k.logger.Println("Shutting down in response to shutdown_request")
+	k.shutdown()
 }
 
-// RunKernel is the main entry point to start the kernel.
-func RunKernel(connectionFile string, logwriter io.Writer) {
+// shutdown removes what the kernel leaves behind, its temporary directory
+// included, before it exits. A kernel started with --keep-temp logs the
+// directory instead, for its files to be looked into.
+func (k *Kernel) shutdown() {
+	k.stopHealth()
+	if k.options().keepTemp {
+		k.logger.Println("Keeping the temporary directory", k.tempDir)
+		return
+	}
+	k.clients.remove(k.session)
+	os.RemoveAll(k.tempDir)
+}
 
-	logger = log.New(logwriter, "gophernotes ", log.LstdFlags)
+// RunKernel is the main entry point to start the kernel, with the options of
+// config.
+func RunKernel(connectionFile string, logwriter io.Writer, config kernelConfig) {
 
-	// Set up the "Session" with the replpkg.
-	SetupExecutionEnvironment()
+	logger := log.New(logwriter, "gophernotes ", log.LstdFlags)
 
 	var connInfo ConnectionInfo
 	bs, err := ioutil.ReadFile(connectionFile)
@@ -164,6 +345,12 @@ func RunKernel(connectionFile string, logwriter io.Writer) {
 		log.Fatalln(err)
 	}
 	logger.Printf("%+v\n", connInfo)
+	startKernel(connInfo, logger, config).run()
+}
+
+// startKernel binds the sockets of connInfo and returns the kernel
+// communicating through them, with the options of config.
+func startKernel(connInfo ConnectionInfo, logger *log.Logger, config kernelConfig) *Kernel {
 
 	// Set up the ZMQ sockets through which the kernel will communicate.
 	sockets, err := PrepareSockets(connInfo)
@@ -171,41 +358,179 @@ func RunKernel(connectionFile string, logwriter io.Writer) {
 		log.Fatalln(err)
 	}
 
+	// Set up the "Session" with the replpkg, once the temporary
+	// directories of kernels that did not shut down are swept.
+	sweepTempDirs(os.TempDir(), logger)
+	k, err := NewKernel(sockets, logger, config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if c := k.session.ModuleContext(); c.File != "" {
+		logger.Println("Imports resolve in", c)
+	}
+	return k
+}
+
+// run starts the health checks of the options of the kernel and serves its
+// requests, exiting with idleExitCode once it has been idle for too long, and
+// shutting the kernel down and exiting with 1 if serving fails. SIGINT
+// interrupts the cell running rather than exiting.
+func (k *Kernel) run() {
+	if err := k.startHealth(); err != nil {
+		log.Fatalln("Could not start the health checks:", err)
+	}
+	k.handleInterrupts()
+	go k.touchTempDir()
+	switch err := k.serve(); err {
+	case errIdle:
+		os.Exit(idleExitCode)
+	case errShutdown:
+		os.Exit(0)
+	default:
+		k.logger.Println("Shutting down after serving failed:", err)
+		k.shutdown()
+		os.Exit(1)
+	}
+}
+
+// serve receives and handles messages on the kernel's sockets until receiving
+// fails. Execute requests, and the others that may change the session, are
+// handled one after the other, in the order they arrive; read-only requests
+// are handled meanwhile, in order too, so that completions and inspections are
+// answered while a cell runs. They still follow the other requests that came
+// before them as those are quick to handle, such as comm messages, unless they
+// are queued behind a cell. Debug requests are handled in order of their own,
+// whatever runs, and shutdown and interrupt requests as soon as they arrive.
+// The stdin socket is left to the requests asking for input, which read the
+// replies to their input requests from it.
+//
+// serve alone uses the shell and control sockets: the replies sent on them
+// while requests are handled go through the outbox of the sockets, which it
+// polls along with them.
+//
+// Messages with a bad signature are logged and dropped, and those that cannot
+// be decoded answered with an error reply; failing to poll or receive is
+// logged, and serving goes on. With the idle timeout of its options set, the
+// kernel is shut down once it has received no message and handled no request
+// for that long, and serve returns errIdle. It returns errShutdown once a
+// shutdown request is answered, and otherwise only the error serving could not
+// start with.
+func (k *Kernel) serve() error {
+	sockets := k.sockets
+	outbox, err := newOutbox(sockets.context, sockets.ShellSocket, sockets.ControlSocket)
+	if err != nil {
+		return err
+	}
+	sockets.outbox = outbox
 	pi := zmq.PollItems{
 		zmq.PollItem{Socket: sockets.ShellSocket, Events: zmq.POLLIN},
-		zmq.PollItem{Socket: sockets.StdinSocket, Events: zmq.POLLIN},
 		zmq.PollItem{Socket: sockets.ControlSocket, Events: zmq.POLLIN},
+		zmq.PollItem{Socket: outbox.woken, Events: zmq.POLLIN},
 	}
 
-	// Start a message receiving loop.
-	var msgparts [][]byte
-	for {
-		if _, err = zmq.Poll(pi, -1); err != nil {
-			log.Fatalln(err)
+	progress := &shellProgress{}
+	progress.cond = sync.NewCond(progress)
+	inOrder := make(chan shellRequest, shellQueueSize)
+	readOnly := make(chan shellRequest, shellQueueSize)
+	debug := make(chan MsgReceipt, shellQueueSize)
+	defer close(inOrder)
+	defer close(readOnly)
+	defer close(debug)
+	timeout := k.options().idleTimeout()
+	idle := newIdleWatch(timeout)
+	go k.runInOrder(inOrder, progress, idle)
+	go k.runReadOnly(readOnly, progress, idle)
+	go k.runDebug(debug, idle)
+	k.subshells.serving(inOrder, idle)
+	defer k.subshells.serving(nil, nil)
+
+	// queued counts the requests run in order, and after is the number of
+	// the last of them that is not an execute request. queue reports whether
+	// the request was to shut down.
+	queued, after := 0, 0
+	queue := func(receipt MsgReceipt) bool {
+		idle.received(true)
+		msgType := receipt.Msg.Header.MsgType
+		if err := checkRequest(receipt.Msg); err != nil {
+			k.handle(receipt, func(receipt MsgReceipt) { k.sendErrorReply(receipt, err) })
+			idle.done()
+			return false
 		}
 		switch {
-		case pi[0].REvents&zmq.POLLIN != 0: // shell socket
-			msgparts, _ = pi[0].Socket.RecvMultipart(0)
-			msg, ids, err := WireMsgToComposedMsg(msgparts, sockets.Key)
-			if err != nil {
-				log.Println(err)
-				return
+		case msgType == "shutdown_request":
+			k.handle(receipt, k.HandleShutdownRequest)
+			return true
+		case msgType == "debug_request":
+			debug <- receipt
+		case msgType == "interrupt_request":
+			k.handle(receipt, k.HandleInterruptRequest)
+			idle.done()
+		case subshellRequests[msgType]:
+			k.handle(receipt, k.HandleSubshellRequest)
+			idle.done()
+		case msgType == "execute_request" && receipt.Msg.Header.SubshellID != "":
+			if !k.queueSubshellRequest(receipt) {
+				idle.done()
+			}
+		case readOnlyRequests[msgType]:
+			readOnly <- shellRequest{receipt: receipt, after: after}
+		default:
+			queued++
+			if msgType != "execute_request" {
+				after = queued
+			}
+			inOrder <- shellRequest{receipt: receipt}
+		}
+		return false
+	}
+
+	// Start a message receiving loop. Polling stops short of the time the
+	// kernel would have been idle for too long, for it to shut down then.
+	var last ComposedMsg
+	for {
+		wait := idle.remaining(time.Now())
+		if wait == 0 {
+			k.shutdownIdle(last, timeout)
+			return errIdle
+		}
+		if _, err := zmq.Poll(pi, wait); err != nil {
+			// Signals, such as the SIGINT interrupting a cell, interrupt
+			// polling, which goes on.
+			log.Println("Could not poll the sockets:", err)
+			continue
+		}
+		if pi[2].REvents&zmq.POLLIN != 0 {
+			outbox.forward(true)
+		}
+		// The control socket is read first, for its requests not to wait
+		// behind those of the shell.
+		for _, i := range []int{1, 0} {
+			if pi[i].REvents&zmq.POLLIN == 0 {
+				continue
 			}
-			HandleShellMsg(MsgReceipt{msg, ids, sockets})
-		case pi[1].REvents&zmq.POLLIN != 0: // stdin socket - not implemented.
-			pi[1].Socket.RecvMultipart(0)
-		case pi[2].REvents&zmq.POLLIN != 0: // control socket - treat like shell socket.
-			msgparts, err = pi[2].Socket.RecvMultipart(0)
+			msgparts, err := pi[i].Socket.RecvMultipart(0)
 			if err != nil {
-				log.Println(err)
-				return
+				log.Println("Could not receive a message:", err)
+				continue
 			}
 			msg, ids, err := WireMsgToComposedMsg(msgparts, sockets.Key)
+			receipt := MsgReceipt{Msg: msg, Identities: ids, Sockets: sockets, Control: i == 1}
 			if err != nil {
+				// Messages that cannot be trusted are dropped, and those
+				// whose type is known answered with the error.
 				log.Println(err)
-				return
+				if _, ok := err.(*DecodeError); ok && msg.Header.MsgType != "" {
+					k.handle(receipt, func(receipt MsgReceipt) {
+						k.sendErrorReply(receipt, newKernelError("InvalidRequest", err.Error()))
+					})
+				}
+				continue
+			}
+			last = msg
+			if queue(receipt) {
+				outbox.forward(false)
+				return errShutdown
 			}
-			HandleShellMsg(MsgReceipt{msg, ids, sockets})
 		}
 	}
 }
@@ -0,0 +1,85 @@
+package gophernotes
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// BenchmarkName is the name of the benchmark the %%benchmark magic makes of
+// the code of the cell.
+const BenchmarkName = "BenchmarkCell"
+
+// Benchmark runs f as a benchmark, with testing.Benchmark, count times, as the
+// %%benchmark magic does with the code of the cell. The number of iterations
+// grows as with go test until a run lasts benchtime, which is a duration or a
+// number of iterations followed by "x", as for go test -benchtime, or one
+// second if it is empty. Allocations are reported, as with -benchmem. Each run
+// is printed as go test prints it, and kept in the metadata of the
+// execute_reply, under "benchmark". A benchmark calling b.Fail, b.Fatal or
+// b.Skip stops there, and fails the cell unless it skipped.
+func Benchmark(f func(b *testing.B), count int, benchtime string) {
+	if count <= 0 {
+		count = 1
+	}
+	testing.Init()
+	if benchtime == "" {
+		benchtime = "1s"
+	}
+	if err := flag.Set("test.benchtime", benchtime); err != nil {
+		errorf("invalid benchtime %s: %s", benchtime, err)
+		return
+	}
+
+	name := BenchmarkName
+	if procs := runtime.GOMAXPROCS(0); procs > 1 {
+		name = fmt.Sprintf("%s-%d", name, procs)
+	}
+	var results []interface{}
+	for i := 0; i < count; i++ {
+		var failed, skipped bool
+		r := testing.Benchmark(func(b *testing.B) {
+			defer func() { failed, skipped = b.Failed(), b.Skipped() }()
+			b.ReportAllocs()
+			f(b)
+		})
+		switch {
+		case failed:
+			errorf("--- FAIL: %s", name)
+			return
+		case skipped || r.N == 0:
+			reportBenchmark(fmt.Sprintf("--- SKIP: %s\n", name), results)
+			return
+		}
+		reportBenchmark(fmt.Sprintf("%s\t%s\t%s\n", name, r.String(), r.MemString()), nil)
+		results = append(results, map[string]interface{}{
+			"name":          name,
+			"n":             r.N,
+			"ns_per_op":     float64(r.T.Nanoseconds()) / float64(r.N),
+			"bytes_per_op":  r.AllocedBytesPerOp(),
+			"allocs_per_op": r.AllocsPerOp(),
+		})
+	}
+	reportBenchmark("", results)
+}
+
+// reportBenchmark prints text, if any, on the stdout stream of the cell, and
+// keeps results, if any, in the metadata of the execute_reply.
+func reportBenchmark(text string, results []interface{}) {
+	if !connected() {
+		fmt.Fprint(os.Stdout, text)
+		return
+	}
+	if text != "" {
+		publish("stream", map[string]interface{}{
+			"name": "stdout",
+			"data": text,
+			"text": text,
+		})
+	}
+	if results != nil {
+		publish("reply_metadata", map[string]interface{}{"benchmark": results})
+	}
+}
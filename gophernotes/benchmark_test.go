@@ -0,0 +1,26 @@
+package gophernotes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBenchmark tests that Benchmark runs the benchmark as many times as
+// asked, printing the runs as go test does and reporting them in the metadata
+func TestBenchmark(t *testing.T) {
+	n := 0
+	msgs := published(t, func() {
+		Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				n++
+			}
+		}, 2, "5x")
+	})
+	assert.Equal(t, 2*(1+5), n, "two runs of five iterations, each after one of one")
+	if assert.Len(t, msgs, 3) {
+		assert.Equal(t, "stream", msgs[0].MsgType)
+		assert.Regexp(t, `^BenchmarkCell(-\d+)?\t +5\t.* ns/op\t +0 B/op\t +0 allocs/op\n$`, msgs[0].Content.Text)
+		assert.Equal(t, "reply_metadata", msgs[2].MsgType)
+	}
+}
@@ -0,0 +1,273 @@
+package gophernotes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// CommEventEnv names the environment variable through which the kernel tells
+// cell code where to find the comm message from the frontend to deliver, when
+// it runs the session to deliver one.
+const CommEventEnv = "GOPHERNOTES_COMM_EVENT"
+
+// OpenCommsEnv names the environment variable through which the kernel tells
+// cell code where to find the comms the frontend opened with the targets cell
+// code registered.
+const OpenCommsEnv = "GOPHERNOTES_OPEN_COMMS"
+
+// Comm is the end in cell code of a comm, a channel of messages between cell
+// code and the frontend, such as the one syncing the model of a widget. Comms
+// are opened by cell code with OpenComm, or by the frontend with a target
+// registered with RegisterCommTarget.
+//
+// The frontend can send messages on a comm after the cell that opened it has
+// finished. The kernel then runs the session again, and delivers the message
+// once the code of every cell has run, so that the comm is open again by then:
+// comms are numbered along with display handles, in the order they are
+// created, and keep their ID from one run to the next, and those the frontend
+// opened are handed to their target again before the code of the current cell
+// runs. Like other output, whatever the code of the cells publishes on the way
+// is dropped.
+type Comm struct {
+	id     string
+	target string
+
+	mu       sync.Mutex
+	handlers []func(data map[string]interface{}, buffers [][]byte)
+	closers  []func(data map[string]interface{})
+}
+
+// comms are the open comms, by ID.
+var comms = struct {
+	sync.Mutex
+	m map[string]*Comm
+}{m: map[string]*Comm{}}
+
+// OpenComm opens a comm with target on the frontend, which gets data, along
+// with metadata in the metadata of the comm_open message if it is not nil.
+func OpenComm(target string, data, metadata map[string]interface{}) *Comm {
+	c := newComm(newDisplayID(), target)
+	if connected() {
+		publishMessage(message{
+			MsgType: "comm_open",
+			Content: map[string]interface{}{
+				"comm_id":     c.id,
+				"target_name": target,
+				"data":        commData(data),
+			},
+			Metadata: metadata,
+		})
+	}
+	return c
+}
+
+// newComm registers the comm id with target.
+func newComm(id, target string) *Comm {
+	c := &Comm{id: id, target: target}
+	comms.Lock()
+	comms.m[id] = c
+	comms.Unlock()
+	return c
+}
+
+// ID returns the comm_id identifying the comm.
+func (c *Comm) ID() string {
+	return c.id
+}
+
+// Target returns the name of the target the comm was opened with.
+func (c *Comm) Target() string {
+	return c.target
+}
+
+// Send sends data to the frontend in a comm_msg, along with buffers of binary
+// data, which travel as frames of their own rather than in JSON.
+func (c *Comm) Send(data map[string]interface{}, buffers ...[]byte) {
+	if !connected() {
+		return
+	}
+	publishMessage(message{
+		MsgType: "comm_msg",
+		Content: map[string]interface{}{
+			"comm_id": c.id,
+			"data":    commData(data),
+		},
+		Buffers: buffers,
+	})
+}
+
+// Close closes the comm, sending data to the frontend in a comm_close.
+func (c *Comm) Close(data map[string]interface{}) {
+	comms.Lock()
+	delete(comms.m, c.id)
+	comms.Unlock()
+	if !connected() {
+		return
+	}
+	publish("comm_close", map[string]interface{}{
+		"comm_id": c.id,
+		"data":    commData(data),
+	})
+}
+
+// OnMsg adds h to the handlers of the messages the frontend sends on the comm,
+// which are called with their data and buffers, in the order they were added.
+func (c *Comm) OnMsg(h func(data map[string]interface{}, buffers [][]byte)) {
+	c.mu.Lock()
+	c.handlers = append(c.handlers, h)
+	c.mu.Unlock()
+}
+
+// OnClose adds h to the handlers called with the data of the comm_close of the
+// frontend, when it closes the comm.
+func (c *Comm) OnClose(h func(data map[string]interface{})) {
+	c.mu.Lock()
+	c.closers = append(c.closers, h)
+	c.mu.Unlock()
+}
+
+// commData returns data, or an empty map if it is nil, as the data of comm
+// messages is always an object.
+func commData(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return map[string]interface{}{}
+	}
+	return data
+}
+
+// A CommTarget handles a comm the frontend opens with its target, given the
+// data of the comm_open message, typically by adding handlers to it.
+type CommTarget func(c *Comm, data map[string]interface{})
+
+// targets are the comm targets registered by cell code, by name.
+var targets = struct {
+	sync.Mutex
+	m map[string]CommTarget
+}{m: map[string]CommTarget{}}
+
+// RegisterCommTarget makes the comms the frontend opens with the target name
+// handled by target, which replaces the one registered before with the name,
+// if any:
+//
+//	gophernotes.RegisterCommTarget("example.echo", func(c *gophernotes.Comm, data map[string]interface{}) {
+//		c.OnMsg(func(data map[string]interface{}, buffers [][]byte) {
+//			c.Send(data, buffers...)
+//		})
+//	})
+//
+// A registration lasts for the rest of the session, as the cell making it runs
+// again on every execution.
+func RegisterCommTarget(name string, target CommTarget) {
+	targets.Lock()
+	targets.m[name] = target
+	targets.Unlock()
+	if connected() {
+		publish("comm_target", map[string]interface{}{"target_name": name})
+	}
+}
+
+// openedComm is a comm opened by the frontend, as listed in the file named by
+// OpenCommsEnv, or the comm_open message of a commEvent.
+type openedComm struct {
+	CommID string                 `json:"comm_id"`
+	Target string                 `json:"target_name"`
+	Data   map[string]interface{} `json:"data"`
+}
+
+// commEvent is the content of the file named by CommEventEnv: a comm_open,
+// comm_msg or comm_close message of the frontend.
+type commEvent struct {
+	MsgType string `json:"msg_type"`
+	openedComm
+	Buffers [][]byte `json:"buffers"`
+}
+
+// openTarget hands the comm the frontend opened to the target it was opened
+// with, or reports that there is no such target.
+func openTarget(opened openedComm) {
+	targets.Lock()
+	target, ok := targets.m[opened.Target]
+	targets.Unlock()
+	if !ok {
+		warnf("no comm target %s to open a comm with", opened.Target)
+		return
+	}
+	target(newComm(opened.CommID, opened.Target), commData(opened.Data))
+}
+
+// reopenComms hands the comms the frontend opened earlier to their target
+// again, as the code of the cells that registered the targets has run again.
+func reopenComms() {
+	path := os.Getenv(OpenCommsEnv)
+	if path == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	var opened []openedComm
+	if err == nil {
+		err = json.Unmarshal(b, &opened)
+	}
+	if err != nil {
+		warnf("could not read the comms open: %s", err)
+		return
+	}
+	for _, o := range opened {
+		openTarget(o)
+	}
+}
+
+// deliverCommEvent passes the comm message the kernel left for the session to
+// the target or the handlers of its comm, if there is one.
+func deliverCommEvent() {
+	path := os.Getenv(CommEventEnv)
+	if path == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		warnf("could not read comm message: %s", err)
+		return
+	}
+	var ev commEvent
+	if err := json.Unmarshal(b, &ev); err != nil {
+		warnf("invalid comm message: %s", err)
+		return
+	}
+	if ev.MsgType == "comm_open" {
+		openTarget(ev.openedComm)
+		return
+	}
+
+	comms.Lock()
+	c, ok := comms.m[ev.CommID]
+	if ok && ev.MsgType == "comm_close" {
+		delete(comms.m, ev.CommID)
+	}
+	comms.Unlock()
+	if !ok {
+		warnf("no comm %s to deliver a message to", ev.CommID)
+		return
+	}
+
+	c.mu.Lock()
+	handlers := append([]func(map[string]interface{}, [][]byte){}, c.handlers...)
+	closers := append([]func(map[string]interface{}){}, c.closers...)
+	c.mu.Unlock()
+	if ev.MsgType == "comm_close" {
+		for _, h := range closers {
+			h(commData(ev.Data))
+		}
+		return
+	}
+	for _, h := range handlers {
+		h(commData(ev.Data), ev.Buffers)
+	}
+}
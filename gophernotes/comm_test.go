@@ -0,0 +1,104 @@
+package gophernotes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withCommFiles runs f with the files of the comms the frontend opened and of
+// the comm message to deliver holding opened and event, as the kernel leaves
+// them.
+func withCommFiles(t *testing.T, opened []openedComm, event *commEvent, f func()) {
+	dir, err := ioutil.TempDir("", "gophernotes_comm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(env, name string, v interface{}) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv(env, path)
+	}
+	write(OpenCommsEnv, "open_comms.json", opened)
+	defer os.Unsetenv(OpenCommsEnv)
+	if event != nil {
+		write(CommEventEnv, "comm_event.json", event)
+		defer os.Unsetenv(CommEventEnv)
+	}
+	f()
+}
+
+// TestRegisterCommTarget tests that targets registered by cell code are told
+// to the kernel, and get the comms the frontend opens, again on every run, with
+// the latest registration of a name winning
+func TestRegisterCommTarget(t *testing.T) {
+	var opened []map[string]interface{}
+	var received [][]byte
+	echo := func(c *Comm, data map[string]interface{}) {
+		opened = append(opened, data)
+		c.OnMsg(func(data map[string]interface{}, buffers [][]byte) {
+			received = append(received, buffers...)
+			c.Send(data, buffers...)
+		})
+	}
+
+	msgs := published(t, func() {
+		RegisterCommTarget("test.target", func(*Comm, map[string]interface{}) {
+			t.Error("replaced target called")
+		})
+		RegisterCommTarget("test.target", echo)
+	})
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, "comm_target", msgs[0].MsgType)
+		assert.Equal(t, "comm_target", msgs[1].MsgType)
+	}
+
+	open := openedComm{CommID: "frontend-1", Target: "test.target", Data: map[string]interface{}{"n": 1.0}}
+	event := &commEvent{MsgType: "comm_msg", openedComm: openedComm{CommID: "frontend-1", Data: map[string]interface{}{"x": 2.0}}, Buffers: [][]byte{[]byte("raw")}}
+	withCommFiles(t, []openedComm{open}, event, func() {
+		msgs = published(t, BeginCell)
+	})
+	assert.Equal(t, []map[string]interface{}{{"n": 1.0}}, opened)
+	assert.Equal(t, [][]byte{[]byte("raw")}, received)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "comm_msg", msgs[0].MsgType)
+		assert.Equal(t, map[string]interface{}{"x": 2.0}, msgs[0].Content.Data)
+		assert.Equal(t, [][]byte{[]byte("raw")}, msgs[0].Buffers)
+	}
+}
+
+// TestCommEvent_openClose tests that comms the frontend opens are handed to
+// their target, and that their close handlers run when the frontend closes them
+func TestCommEvent_openClose(t *testing.T) {
+	var closed map[string]interface{}
+	RegisterCommTarget("test.closing", func(c *Comm, data map[string]interface{}) {
+		c.OnClose(func(data map[string]interface{}) { closed = data })
+	})
+
+	open := openedComm{CommID: "frontend-2", Target: "test.closing"}
+	withCommFiles(t, nil, &commEvent{MsgType: "comm_open", openedComm: open}, BeginCell)
+	comms.Lock()
+	_, ok := comms.m["frontend-2"]
+	comms.Unlock()
+	assert.True(t, ok)
+
+	event := &commEvent{MsgType: "comm_close", openedComm: openedComm{CommID: "frontend-2", Data: map[string]interface{}{"bye": true}}}
+	withCommFiles(t, nil, event, BeginCell)
+	assert.Equal(t, map[string]interface{}{"bye": true}, closed)
+	comms.Lock()
+	_, ok = comms.m["frontend-2"]
+	comms.Unlock()
+	assert.False(t, ok)
+}
@@ -0,0 +1,8 @@
+package gophernotes
+
+import "time"
+
+// cpuTime reports that the CPU time the process has used is not known.
+func cpuTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,146 @@
+package gophernotes
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxCSVWarnings is the number of malformed rows reported one by one by CSV;
+// the rest are summed up in a final warning.
+const maxCSVWarnings = 5
+
+// csvOptions are the settings CSVOption functions change.
+type csvOptions struct {
+	delimiter  rune
+	header     int // 1 if the first row is the header, 0 if it is not, -1 to infer it
+	maxRows    int
+	maxColumns int
+}
+
+// CSVOption changes how CSV parses and shows its input.
+type CSVOption func(o *csvOptions)
+
+// CSVDelimiter sets the character separating fields, instead of a comma.
+func CSVDelimiter(r rune) CSVOption {
+	return func(o *csvOptions) { o.delimiter = r }
+}
+
+// CSVNoHeader makes the first row data rather than column names; columns are
+// then numbered from 1.
+func CSVNoHeader() CSVOption {
+	return func(o *csvOptions) { o.header = 0 }
+}
+
+// CSVMaxRows sets the number of rows shown, instead of MaxTableRows. Zero or
+// less shows every row.
+func CSVMaxRows(n int) CSVOption {
+	return func(o *csvOptions) { o.maxRows = n }
+}
+
+// CSVMaxColumns sets the number of columns shown. Zero or less, the default,
+// shows every column.
+func CSVMaxColumns(n int) CSVOption {
+	return func(o *csvOptions) { o.maxColumns = n }
+}
+
+// CSV parses CSV data from r, displays it as a table and returns the records
+// read, header included, for the cell to keep working with:
+//
+//	records := gophernotes.CSV(resp.Body, gophernotes.CSVDelimiter(';'))
+//
+// The first row is taken for the header, unless all of its fields are numbers
+// or CSVNoHeader is given. Malformed rows are reported as warnings, and shown
+// as well as they could be parsed.
+func CSV(r io.Reader, opts ...CSVOption) [][]string {
+	o := csvOptions{delimiter: ',', header: -1, maxRows: MaxTableRows}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cr := csv.NewReader(r)
+	cr.Comma = o.delimiter
+	cr.FieldsPerRecord = -1
+	var records [][]string
+	var malformed []string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if perr, ok := err.(*csv.ParseError); ok {
+			malformed = append(malformed, perr.Error())
+			if record == nil {
+				continue
+			}
+		} else if err != nil {
+			errorf("could not read CSV: %s", err)
+			break
+		}
+		if len(records) > 0 && len(record) != len(records[0]) {
+			malformed = append(malformed, fmt.Sprintf("record %d has %d fields instead of %d", len(records)+1, len(record), len(records[0])))
+		}
+		records = append(records, record)
+	}
+
+	if len(records) > 0 {
+		display(csvTable(records, o).bundle(), map[string]interface{}{})
+	}
+	for i, m := range malformed {
+		if i == maxCSVWarnings {
+			warnf("CSV: %d more malformed rows", len(malformed)-i)
+			break
+		}
+		warnf("CSV: %s", m)
+	}
+	return records
+}
+
+// CSVFile parses the CSV file at path and displays it, like CSV.
+func CSVFile(path string, opts ...CSVOption) [][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		errorf("could not display CSV: %s", err)
+		return nil
+	}
+	defer f.Close()
+	return CSV(f, opts...)
+}
+
+// csvTable builds the table showing records.
+func csvTable(records [][]string, o csvOptions) *table {
+	header := o.header == 1 || o.header == -1 && !allNumbers(records[0])
+	rows := records
+	t := &table{}
+	if header {
+		t.header, rows = records[0], records[1:]
+	} else {
+		for j := range records[0] {
+			t.header = append(t.header, strconv.Itoa(j+1))
+		}
+	}
+	if o.maxColumns > 0 && len(t.header) > o.maxColumns {
+		t.moreColumns = len(t.header) - o.maxColumns
+		t.header = t.header[:o.maxColumns]
+	}
+	t.addRows(len(rows), o.maxRows, func(i int) []string {
+		if len(rows[i]) > len(t.header) {
+			return rows[i][:len(t.header)]
+		}
+		return rows[i]
+	})
+	return t
+}
+
+// allNumbers reports whether every field of record is a number.
+func allNumbers(record []string) bool {
+	for _, f := range record {
+		if _, err := strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil {
+			return false
+		}
+	}
+	return len(record) > 0
+}
@@ -0,0 +1,77 @@
+package gophernotes
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCSV tests that CSV data is shown as a table under its header, and
+// returned as parsed
+func TestCSV(t *testing.T) {
+	var records [][]string
+	msgs := published(t, func() {
+		records = CSV(strings.NewReader("name,age\nada,36\n\"<bob>\",41\n"))
+	})
+	assert.Equal(t, [][]string{{"name", "age"}, {"ada", "36"}, {"<bob>", "41"}}, records)
+
+	data := onlyData(t, msgs)
+	assert.Equal(t, "name   age\n-----  ---\nada    36\n<bob>  41", data["text/plain"])
+	assert.Contains(t, data["text/html"], "<th>name</th><th>age</th>")
+	assert.Contains(t, data["text/html"], "<td>&lt;bob&gt;</td><td>41</td>")
+}
+
+// TestCSV_header tests that a first row of numbers is taken for data, as is
+// any first row with CSVNoHeader
+func TestCSV_header(t *testing.T) {
+	data := onlyData(t, published(t, func() {
+		CSV(strings.NewReader("1;2.5\n3;4\n"), CSVDelimiter(';'))
+	}))
+	assert.Equal(t, "1  2\n-  ---\n1  2.5\n3  4", data["text/plain"])
+
+	data = onlyData(t, published(t, func() {
+		CSV(strings.NewReader("a,b\n"), CSVNoHeader())
+	}))
+	assert.Equal(t, "1  2\n-  -\na  b", data["text/plain"])
+}
+
+// TestCSV_caps tests that rows and columns past the caps are left out
+func TestCSV_caps(t *testing.T) {
+	data := onlyData(t, published(t, func() {
+		CSV(strings.NewReader("a,b,c\n1,2,3\n4,5,6\n7,8,9\n"), CSVMaxRows(2), CSVMaxColumns(2))
+	}))
+	assert.Equal(t, "a  b\n-  -\n1  2\n4  5\n… 1 more row, 1 more column", data["text/plain"])
+}
+
+// TestCSV_malformed tests that malformed rows are reported as warnings after
+// the table
+func TestCSV_malformed(t *testing.T) {
+	var records [][]string
+	msgs := published(t, func() {
+		records = CSV(strings.NewReader("a,b\n1,2,3\n4,5\n"))
+	})
+	assert.Len(t, records, 3)
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, "display_data", msgs[0].MsgType)
+		assert.Equal(t, "stderr", msgs[1].Content.Name)
+		assert.Contains(t, msgs[1].Content.Text, "record 2 has 3 fields instead of 2")
+	}
+}
+
+// TestCSVFile tests reading CSV from a file
+func TestCSVFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "gophernotes_csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("x\n1\n")
+	f.Close()
+
+	var records [][]string
+	published(t, func() { records = CSVFile(f.Name()) })
+	assert.Equal(t, [][]string{{"x"}, {"1"}}, records)
+}
@@ -0,0 +1,71 @@
+package gophernotes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// DebugDirEnv names the environment variable through which the kernel tells
+// cell code the directory where it leaves word to go on from a breakpoint.
+const DebugDirEnv = "GOPHERNOTES_DEBUG_DIR"
+
+// maxDebugValue is the number of bytes of the value of a variable shown to
+// the debugger.
+const maxDebugValue = 500
+
+// debugPollInterval is how often a stopped breakpoint checks whether the
+// kernel let it go on.
+const debugPollInterval = 20 * time.Millisecond
+
+// stops counts the breakpoints the cell stopped at.
+var stops int64
+
+// DebugVariable is a variable in scope at a breakpoint, as the debugger shows
+// it.
+type DebugVariable struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  string `json:"type"`
+}
+
+// Breakpoint stops the cell at line of its code, the variables in scope there
+// being named names and holding values, until the debugger of the frontend
+// goes on. The kernel inserts the calls before the lines of the cell the
+// debugger set breakpoints on; cell code has no reason to make them. When
+// earlier cells run again before the current one, Breakpoint returns at once.
+func Breakpoint(line int, names []string, values ...interface{}) {
+	dir := os.Getenv(DebugDirEnv)
+	if dir == "" || replaying() {
+		return
+	}
+	stop := atomic.AddInt64(&stops, 1)
+	variables := make([]DebugVariable, 0, len(names))
+	for i, name := range names {
+		if i >= len(values) {
+			break
+		}
+		variables = append(variables, DebugVariable{
+			Name:  name,
+			Value: cut([]byte(fmt.Sprintf("%v", values[i])), maxDebugValue),
+			Type:  fmt.Sprintf("%T", values[i]),
+		})
+	}
+	publish("debug_stopped", map[string]interface{}{
+		"stop":      stop,
+		"line":      line,
+		"variables": variables,
+	})
+
+	// The kernel creates the file of the stop for the cell to go on.
+	path := filepath.Join(dir, fmt.Sprintf("continue-%d", stop))
+	for {
+		if _, err := os.Stat(path); err == nil {
+			os.Remove(path)
+			return
+		}
+		time.Sleep(debugPollInterval)
+	}
+}
@@ -0,0 +1,41 @@
+package gophernotes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBreakpoint tests that breakpoints tell the kernel they stopped and wait
+// for it to let them go on, unless no debug directory is set
+func TestBreakpoint(t *testing.T) {
+	msgs := published(t, func() { Breakpoint(1, []string{"x"}, 1) })
+	assert.Empty(t, msgs)
+
+	dir, err := ioutil.TempDir("", "gophernotes_debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv(DebugDirEnv, dir)
+	defer os.Unsetenv(DebugDirEnv)
+
+	path := filepath.Join(dir, fmt.Sprintf("continue-%d", stops+1))
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ioutil.WriteFile(path, nil, 0644)
+	}()
+	start := time.Now()
+	msgs = published(t, func() { Breakpoint(2, []string{"x", "s"}, 1, "text") })
+	assert.True(t, time.Since(start) >= 100*time.Millisecond, "went on after %v", time.Since(start))
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "debug_stopped", msgs[0].MsgType)
+	}
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "the file letting the breakpoint go on is removed")
+}
@@ -0,0 +1,384 @@
+// Package gophernotes lets code running in a gophernotes notebook cell publish
+// rich output, such as images or HTML, to the notebook.
+//
+// The package is imported into every session automatically, and may also be
+// imported explicitly with:
+//
+//	import "gophernotes"
+//
+// Outside of the kernel, displayed values fall back to being printed as plain
+// text on stdout.
+//
+// Values passed to Display, or that a cell evaluates to, go through a pipeline
+// of renderers. Values implementing image.Image are shown as PNG images. Other
+// types can provide their own representations by implementing any of these
+// methods, which are probed in this order:
+//
+//	MIMEBundle() map[string]interface{}  // any MIME types, keyed by type
+//	HTML() string                        // text/html
+//	SVG() string                         // image/svg+xml
+//	PNG() []byte                         // image/png, as encoded PNG data
+//	Markdown() string                    // text/markdown
+//	Latex() string                       // text/latex
+//
+// The representations are merged, with those found first winning. The value's
+// String method, or its default format, always provides text/plain, unless
+// MIMEBundle already did. A method that panics is skipped with a warning. The
+// default format is cut at MaxResultSize. Bundles published without text/plain,
+// such as by DisplayData, get one describing their other representations, for
+// frontends showing text only.
+//
+// Slices of structs, [][]string and []map[string]interface{} values without an
+// HTML representation of their own are shown as tables; see Table. Byte slices
+// and arrays are shown as hexdumps, or as text if they hold text; see Hexdump
+// and BytesAsText. Errors wrapping others are shown with the chain of errors
+// they wrap, one per line, followed by the frames of the cell's code in the
+// stack trace attached to them, such as by github.com/pkg/errors.
+package gophernotes
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// DisplayFileEnv names the environment variable through which the kernel tells
+// cell code where to write the messages it wants published.
+const DisplayFileEnv = "GOPHERNOTES_DISPLAY_FILE"
+
+// MIMEBundle maps MIME types to representations of a value, as carried in the
+// data field of display_data messages.
+type MIMEBundle map[string]interface{}
+
+// Set adds the representation v for mimeType to the bundle and returns the
+// bundle, so that calls can be chained.
+func (b MIMEBundle) Set(mimeType string, v interface{}) MIMEBundle {
+	b[mimeType] = v
+	return b
+}
+
+// Has reports whether the bundle holds a representation for mimeType.
+func (b MIMEBundle) Has(mimeType string) bool {
+	_, ok := b[mimeType]
+	return ok
+}
+
+// PlainText returns the text/plain representation held by the bundle, or an
+// empty string if there is none.
+func (b MIMEBundle) PlainText() string {
+	s, _ := b["text/plain"].(string)
+	return s
+}
+
+// displayData is the content of a display_data or execute_result message.
+type displayData struct {
+	Data      MIMEBundle             `json:"data"`
+	Metadata  map[string]interface{} `json:"metadata"`
+	Transient map[string]interface{} `json:"transient,omitempty"`
+}
+
+// newDisplayData returns the content of a display_data, update_display_data
+// or execute_result message, with the sizes of the images in data added to
+// metadata where it lacks them. Every output published goes through it, and so
+// gets a text/plain representation if data lacks one; see withPlainText.
+func newDisplayData(data MIMEBundle, metadata map[string]interface{}) displayData {
+	imageMetadata(data, metadata, nil)
+	return displayData{
+		Data:     withPlainText(data),
+		Metadata: metadata,
+	}
+}
+
+// message is a single line of the display file: the kernel adds the headers
+// and publishes Content, and Metadata and Buffers if set, as is.
+type message struct {
+	MsgType  string                 `json:"msg_type"`
+	Content  interface{}            `json:"content"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Buffers  [][]byte               `json:"buffers,omitempty"`
+}
+
+// out is the display file shared by all goroutines of the cell.
+var out struct {
+	sync.Mutex
+	once sync.Once
+	f    *os.File
+
+	// replaying is set until the code of the current cell starts; see
+	// BeginCell.
+	replaying bool
+
+	// sink receives the output instead of the kernel, if set; see SetSink.
+	sink Sink
+}
+
+// errorf reports a problem on stderr, which makes the kernel show the cell as
+// failed.
+func errorf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "gophernotes: "+format+"\n", args...)
+}
+
+// warnf reports a problem that does not prevent the cell from running, on the
+// cell's stderr stream.
+func warnf(format string, args ...interface{}) {
+	text := fmt.Sprintf("gophernotes: "+format+"\n", args...)
+	if !connected() {
+		fmt.Fprint(os.Stderr, text)
+		return
+	}
+	// Version 4 of the protocol, which the kernel speaks, calls the text
+	// "data"; later versions call it "text".
+	publish("stream", map[string]interface{}{
+		"name": "stderr",
+		"data": text,
+		"text": text,
+	})
+}
+
+// connected reports whether the code is running under the kernel, or has its
+// output going to a sink.
+func connected() bool {
+	out.once.Do(func() {
+		path := os.Getenv(DisplayFileEnv)
+		if path == "" {
+			return
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			errorf("could not open display file: %s", err)
+			return
+		}
+		out.f = f
+		out.replaying = true
+	})
+	out.Lock()
+	defer out.Unlock()
+	return out.f != nil || out.sink != nil
+}
+
+// BeginCell marks the start of the current cell's code. The kernel runs the code
+// of earlier cells again before it on every execution, and whatever that code
+// publishes is dropped so that it does not show up twice. The kernel inserts the
+// call itself; cell code has no reason to make it.
+//
+// The comms the frontend opened are handed to their target again just before,
+// and the kernel is told the process of the cell, for it to ask for the stacks
+// of its goroutines once interrupting the cell does not stop it. When the
+// kernel runs the session to deliver a comm message from the frontend,
+// the call comes after the code of every cell instead, and delivers the message
+// then.
+func BeginCell() {
+	connected()
+	reopenComms()
+	out.Lock()
+	out.replaying = false
+	out.Unlock()
+	watchStackDumps()
+	deliverCommEvent()
+	beginCellHandles()
+}
+
+// publish asks the kernel to publish an iopub message of type msgType with the
+// given content.
+func publish(msgType string, content interface{}) {
+	publishMessage(message{MsgType: msgType, Content: content})
+}
+
+// publishMessage writes msg to the display file, or passes it to the sink if
+// it is output. Each message is written with a single call, so messages from
+// concurrent goroutines never interleave.
+func publishMessage(msg message) {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		errorf("could not encode display message: %s", err)
+		return
+	}
+	line = append(line, '\n')
+
+	out.Lock()
+	if out.replaying {
+		out.Unlock()
+		return
+	}
+	if sink := out.sink; sink != nil && outputTypes[msg.MsgType] {
+		out.Unlock()
+		var decoded struct {
+			Content map[string]interface{} `json:"content"`
+		}
+		if err := json.Unmarshal(line, &decoded); err != nil {
+			errorf("could not decode display message: %s", err)
+			return
+		}
+		sink(msg.MsgType, decoded.Content)
+		return
+	}
+	defer out.Unlock()
+	if out.f == nil {
+		return
+	}
+	if _, err := out.f.Write(line); err != nil {
+		errorf("could not write display message: %s", err)
+	}
+}
+
+// outputTypes are the types of the messages carrying output, which go to the
+// sink when there is one.
+var outputTypes = map[string]bool{
+	"display_data":        true,
+	"update_display_data": true,
+	"clear_output":        true,
+	"stream":              true,
+	"execute_result":      true,
+}
+
+// A Sink receives the output cell code publishes in place of the kernel: the
+// type and the content, as encoded in JSON, of display_data,
+// update_display_data, clear_output, stream and execute_result messages. Comm
+// messages still go to the kernel.
+type Sink func(msgType string, content map[string]interface{})
+
+// SetSink makes the output published from now on go to sink, until restore is
+// called, which makes it go where it went before. Output published while the
+// code of earlier cells is replayed is dropped all the same. Sinks let output
+// be gathered rather than shown, such as by the Output widget, or by tests:
+//
+//	var types []string
+//	restore := gophernotes.SetSink(func(msgType string, content map[string]interface{}) {
+//		types = append(types, msgType)
+//	})
+//	gophernotes.Display(v)
+//	restore()
+//
+// The sink is shared by all goroutines of the cell, and called from whichever
+// publishes.
+func SetSink(sink Sink) (restore func()) {
+	connected()
+	out.Lock()
+	prev := out.sink
+	out.sink = sink
+	out.Unlock()
+	return func() {
+		out.Lock()
+		out.sink = prev
+		out.Unlock()
+	}
+}
+
+// A Renderer adds what representations it can of v to the data bundle, along
+// with their metadata, and reports whether it recognised v.
+type Renderer func(v interface{}, data MIMEBundle, metadata map[string]interface{}) bool
+
+// renderers build representations of displayed values, in priority order.
+var renderers = []Renderer{
+	renderImage,
+	renderMethods,
+	renderTable,
+	renderBytes,
+	renderError,
+}
+
+// RegisterRenderer adds r to the renderer pipeline, after the renderers already
+// in it. It lets packages teach Display and cell results about types they
+// cannot add methods to, and is meant to be called from an init function.
+func RegisterRenderer(r Renderer) {
+	renderers = append(renderers, r)
+}
+
+// render runs v through the renderer pipeline. The data bundle always holds a
+// text/plain representation.
+func render(v interface{}) (MIMEBundle, map[string]interface{}) {
+	data := MIMEBundle{}
+	metadata := map[string]interface{}{}
+	for _, r := range renderers {
+		r(v, data, metadata)
+	}
+	if !data.Has("text/plain") {
+		data["text/plain"] = plainText(v)
+	}
+	return data, metadata
+}
+
+// Render returns the representations of v produced by the renderer pipeline.
+// The bundle always holds a text/plain representation.
+func Render(v interface{}) MIMEBundle {
+	data, _ := render(v)
+	return data
+}
+
+// Display publishes the representations of v produced by Render.
+func Display(v interface{}) {
+	display(render(v))
+}
+
+// DisplayData publishes bundle as is, but for a text/plain representation
+// added if it has none, describing the others. It is safe to call from any goroutine
+// the cell starts, as long as the cell is still running.
+func DisplayData(bundle MIMEBundle) {
+	display(bundle, map[string]interface{}{})
+}
+
+func display(data MIMEBundle, metadata map[string]interface{}) {
+	if !connected() {
+		fmt.Println(withPlainText(data).PlainText())
+		return
+	}
+	publish("display_data", newDisplayData(data, metadata))
+}
+
+// clearOutput is the content of a clear_output message.
+type clearOutput struct {
+	Wait bool `json:"wait"`
+}
+
+// ClearOutput clears the output of the cell. If wait is set, the frontend only
+// clears it when the next output arrives, which avoids flicker when redrawing,
+// for instance in an animation loop:
+//
+//	for _, frame := range frames {
+//		gophernotes.ClearOutput(true)
+//		gophernotes.Display(frame)
+//	}
+func ClearOutput(wait bool) {
+	if !connected() {
+		return
+	}
+	publish("clear_output", clearOutput{Wait: wait})
+}
+
+// Result publishes v as the result of the cell if the renderer pipeline finds
+// a representation richer than plain text for it, or if its text is larger
+// than MaxResultSize, and reports whether it did. The kernel calls it for each
+// value a cell evaluates to, and prints the value as text if it returns false.
+func Result(v interface{}) bool {
+	if !connected() {
+		return false
+	}
+	data, metadata := render(v)
+	if len(data) == 1 && !oversized(v) {
+		return false
+	}
+	publish("execute_result", newDisplayData(data, metadata))
+	return true
+}
+
+// PrintResult shows text, that of a value Result did not publish, as the
+// result of the cell, apart from what the cell prints. Outside of the kernel,
+// it prints text. The kernel inserts the calls itself; cell code has no reason
+// to make them.
+func PrintResult(text string) {
+	connected()
+	out.Lock()
+	kernel := out.f != nil
+	out.Unlock()
+	if !kernel {
+		fmt.Print(text)
+		return
+	}
+	publish("result_text", resultText{Text: text})
+}
+
+// resultText is the content of the result_text messages of PrintResult.
+type resultText struct {
+	Text string `json:"text"`
+}
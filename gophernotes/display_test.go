@@ -0,0 +1,166 @@
+package gophernotes
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testMessage is a message read back from the display file.
+type testMessage struct {
+	MsgType string `json:"msg_type"`
+	Content struct {
+		Data     map[string]interface{} `json:"data"`
+		Metadata map[string]interface{} `json:"metadata"`
+		Wait     bool                   `json:"wait"`
+
+		// Name and Text are set for stream messages only, whose data is
+		// a string.
+		Name string `json:"-"`
+		Text string `json:"-"`
+	} `json:"content"`
+	Buffers [][]byte `json:"buffers"`
+}
+
+// streamContent is the content of a stream message.
+type streamContent struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+}
+
+// published runs f with the display file redirected to a temporary file, and
+// returns the messages f published.
+func published(t *testing.T, f func()) []testMessage {
+	tmp, err := ioutil.TempFile("", "gophernotes_display")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	out.once.Do(func() {})
+	out.f = tmp
+	f()
+	out.f = nil
+
+	if _, err := tmp.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []testMessage
+	sc := bufio.NewScanner(tmp)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var raw struct {
+			MsgType string          `json:"msg_type"`
+			Content json.RawMessage `json:"content"`
+			Buffers [][]byte        `json:"buffers"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &raw); err != nil {
+			t.Fatal(err)
+		}
+		msg := testMessage{MsgType: raw.MsgType, Buffers: raw.Buffers}
+		if raw.MsgType == "stream" {
+			var stream streamContent
+			if err := json.Unmarshal(raw.Content, &stream); err != nil {
+				t.Fatal(err)
+			}
+			msg.Content.Name, msg.Content.Text = stream.Name, stream.Text
+		} else if err := json.Unmarshal(raw.Content, &msg.Content); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return msgs
+}
+
+// onlyData returns the data bundle of the single display_data message in msgs.
+func onlyData(t *testing.T, msgs []testMessage) map[string]interface{} {
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	if msgs[0].MsgType != "display_data" {
+		t.Fatalf("got %s message, want display_data", msgs[0].MsgType)
+	}
+	return msgs[0].Content.Data
+}
+
+// TestClearOutput tests that clear_output messages are published in order with
+// the displays around them, with the wait flag set as requested
+func TestClearOutput(t *testing.T) {
+	msgs := published(t, func() {
+		Display(1)
+		ClearOutput(true)
+		Display(2)
+		ClearOutput(false)
+	})
+
+	if assert.Len(t, msgs, 4) {
+		assert.Equal(t, "display_data", msgs[0].MsgType)
+		assert.Equal(t, "clear_output", msgs[1].MsgType)
+		assert.True(t, msgs[1].Content.Wait)
+		assert.Equal(t, "display_data", msgs[2].MsgType)
+		assert.Equal(t, "2", msgs[2].Content.Data["text/plain"])
+		assert.Equal(t, "clear_output", msgs[3].MsgType)
+		assert.False(t, msgs[3].Content.Wait)
+	}
+}
+
+// TestSetSink tests that output goes to the sink set, comm messages aside,
+// until it is restored
+func TestSetSink(t *testing.T) {
+	var types []string
+	var contents []map[string]interface{}
+	msgs := published(t, func() {
+		restore := SetSink(func(msgType string, content map[string]interface{}) {
+			types = append(types, msgType)
+			contents = append(contents, content)
+		})
+		Display("sunk")
+		ClearOutput(true)
+		OpenComm("test.sink", nil, nil)
+		restore()
+		Display("published")
+	})
+
+	assert.Equal(t, []string{"display_data", "clear_output"}, types)
+	assert.Equal(t, "sunk", contents[0]["data"].(map[string]interface{})["text/plain"])
+	assert.Equal(t, true, contents[1]["wait"])
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, "comm_open", msgs[0].MsgType)
+		assert.Equal(t, "published", msgs[1].Content.Data["text/plain"])
+	}
+}
+
+// celsius is a type rendered by a registered renderer only.
+type celsius float64
+
+// TestRegisterRenderer tests that registered renderers add representations to
+// the values they recognise, and leave the others alone
+func TestRegisterRenderer(t *testing.T) {
+	defer func(saved []Renderer) { renderers = saved }(renderers)
+	RegisterRenderer(func(v interface{}, data MIMEBundle, metadata map[string]interface{}) bool {
+		c, ok := v.(celsius)
+		if !ok {
+			return false
+		}
+		data["text/html"] = fmt.Sprintf("<b>%g&deg;C</b>", float64(c))
+		data["text/plain"] = fmt.Sprintf("%g°C", float64(c))
+		return true
+	})
+
+	data := Render(celsius(21.5))
+	assert.Equal(t, "<b>21.5&deg;C</b>", data["text/html"])
+	assert.Equal(t, "21.5°C", data["text/plain"])
+
+	data = Render(21.5)
+	assert.False(t, data.Has("text/html"))
+	assert.Equal(t, "21.5", data["text/plain"])
+}
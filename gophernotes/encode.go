@@ -0,0 +1,42 @@
+package gophernotes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+)
+
+// EncodeData returns data, of type mimeType, as it goes in a MIMEBundle, and
+// in the notebook file. Textual types are kept as text, with their line
+// breaks normalized to \n; binary types, such as images, audio or PDF
+// documents, are base64 encoded, in a single line and without a data: URI
+// prefix, as nbformat expects.
+func EncodeData(mimeType string, data []byte) string {
+	if isTextType(mimeType) {
+		data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+		data = bytes.Replace(data, []byte("\r"), []byte("\n"), -1)
+		return string(data)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// isTextType reports whether data of type mimeType is text.
+func isTextType(mimeType string) bool {
+	t := strings.ToLower(mimeType)
+	if i := strings.Index(t, ";"); i >= 0 {
+		t = t[:i]
+	}
+	t = strings.TrimSpace(t)
+
+	switch {
+	case strings.HasPrefix(t, "text/"),
+		strings.HasSuffix(t, "+xml"),
+		strings.HasSuffix(t, "+json"):
+		return true
+	}
+	switch t {
+	case "application/javascript", "application/json", "application/xml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package gophernotes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEncodeData tests that text types are kept as text with normalized line
+// breaks, and binary ones base64 encoded
+func TestEncodeData(t *testing.T) {
+	cases := []struct {
+		mimeType string
+		data     string
+		want     string
+	}{
+		{"text/html", "<p>\r\na</p>\r", "<p>\na</p>\n"},
+		{"text/plain; charset=utf-8", "a\r\nb", "a\nb"},
+		{"image/svg+xml", "<svg/>", "<svg/>"},
+		{"application/vnd.vegalite.v5+json", "{}", "{}"},
+		{"application/javascript", "f()", "f()"},
+		{"image/png", "\x89PNG\r\n", base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n"))},
+		{"application/pdf", "%PDF", "JVBERg=="},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, EncodeData(c.mimeType, []byte(c.data)), c.mimeType)
+	}
+}
+
+// TestEncodeData_notebook tests that bundles round-trip through a display_data
+// output of an nbformat 4 notebook
+func TestEncodeData_notebook(t *testing.T) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 300, 300))); err != nil {
+		t.Fatal(err)
+	}
+	pdf := bytes.Repeat([]byte("%PDF-1.4\x00\xff\r\n"), 100)
+	svg := "<svg>\r\n</svg>"
+
+	cell := map[string]interface{}{
+		"cell_type":       "code",
+		"execution_count": 1,
+		"metadata":        map[string]interface{}{},
+		"source":          []string{"gophernotes.Display(img)"},
+		"outputs": []interface{}{
+			map[string]interface{}{
+				"output_type": "display_data",
+				"metadata":    map[string]interface{}{},
+				"data": MIMEBundle{
+					"image/png":       EncodeData("image/png", img.Bytes()),
+					"application/pdf": EncodeData("application/pdf", pdf),
+					"image/svg+xml":   EncodeData("image/svg+xml", []byte(svg)),
+					"text/plain":      "<image>",
+				},
+			},
+		},
+	}
+	saved, err := json.Marshal(cell)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded struct {
+		Outputs []struct {
+			OutputType string            `json:"output_type"`
+			Data       map[string]string `json:"data"`
+		} `json:"outputs"`
+	}
+	if err := json.Unmarshal(saved, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	data := loaded.Outputs[0].Data
+
+	for mimeType, want := range map[string][]byte{"image/png": img.Bytes(), "application/pdf": pdf} {
+		s := data[mimeType]
+		assert.False(t, strings.HasPrefix(s, "data:"), mimeType)
+		assert.NotContains(t, s, "\n", mimeType)
+		decoded, err := base64.StdEncoding.DecodeString(s)
+		if assert.NoError(t, err, mimeType) {
+			assert.Equal(t, want, decoded, mimeType)
+		}
+	}
+	assert.Equal(t, "<svg>\n</svg>", data["image/svg+xml"])
+}
@@ -0,0 +1,188 @@
+package gophernotes
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/gopherds/gophernotes/internal/trace"
+)
+
+// CellFileEnv names the environment variable through which the kernel tells
+// cell code where to find the code of the cell being run, in which the stack
+// traces attached to errors are located.
+const CellFileEnv = "GOPHERNOTES_CELL_FILE"
+
+// errorLayer is an error of a chain, along with the errors it wraps.
+type errorLayer struct {
+	err     error
+	msg     string // the part of the message the error adds to those it wraps, if any
+	wrapped []errorLayer
+}
+
+// errorChain unwraps err, through the Unwrap methods of the errors package
+// and the Cause method of github.com/pkg/errors, down to the errors it
+// originates from.
+func errorChain(err error) errorLayer {
+	layer := errorLayer{err: err, msg: err.Error()}
+	var wrapped []error
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		wrapped = e.Unwrap()
+	case interface{ Unwrap() error }:
+		wrapped = append(wrapped, e.Unwrap())
+	case interface{ Cause() error }:
+		wrapped = append(wrapped, e.Cause())
+	}
+	for _, w := range wrapped {
+		if w == nil || w == err {
+			continue
+		}
+		inner := errorChain(w)
+		if layer.msg == inner.err.Error() {
+			layer.msg = ""
+		}
+		layer.msg = strings.TrimSuffix(layer.msg, ": "+inner.err.Error())
+		layer.wrapped = append(layer.wrapped, inner)
+	}
+	return layer
+}
+
+// depth returns the number of errors with a message of their own in the
+// longest chain from l.
+func (l errorLayer) depth() int {
+	d := 0
+	for _, w := range l.wrapped {
+		if wd := w.depth(); wd > d {
+			d = wd
+		}
+	}
+	if l.msg == "" {
+		return d
+	}
+	return d + 1
+}
+
+// stackTrace returns the program counters of the stack trace attached to the
+// deepest error of the chain that has one. Stack traces are found through a
+// StackTrace method, such as that of github.com/pkg/errors, returning a
+// slice of program counters.
+func (l errorLayer) stackTrace() []uintptr {
+	for _, w := range l.wrapped {
+		if pcs := w.stackTrace(); pcs != nil {
+			return pcs
+		}
+	}
+	m := reflect.ValueOf(l.err).MethodByName("StackTrace")
+	if !m.IsValid() || m.Type().NumIn() != 0 || m.Type().NumOut() != 1 {
+		return nil
+	}
+	if t := m.Type().Out(0); t.Kind() != reflect.Slice || t.Elem().Kind() != reflect.Uintptr {
+		return nil
+	}
+	frames := m.Call(nil)[0]
+	pcs := make([]uintptr, frames.Len())
+	for i := range pcs {
+		pcs[i] = uintptr(frames.Index(i).Uint())
+	}
+	return pcs
+}
+
+// renderError shows the chain of errors wrapped by an error, each on its own
+// line, and the frames of the cell's own code in the stack trace attached to
+// it, if any. Errors wrapping nothing and without a stack trace are left to
+// their message.
+func renderError(v interface{}, data MIMEBundle, metadata map[string]interface{}) bool {
+	err, ok := v.(error)
+	if !ok || data.Has("text/html") {
+		return false
+	}
+	chain := errorChain(err)
+	frames := cellFrames(chain.stackTrace())
+	if chain.depth() <= 1 && len(frames) == 0 {
+		return false
+	}
+
+	var text, h bytes.Buffer
+	h.WriteString("<pre>")
+	var write func(l errorLayer, indent string)
+	write = func(l errorLayer, indent string) {
+		// Errors adding nothing to the message, such as those only
+		// attaching a stack trace, are left out.
+		if l.msg == "" {
+			for _, w := range l.wrapped {
+				write(w, indent)
+			}
+			return
+		}
+		fmt.Fprintf(&text, "%s%s (%T)\n", indent, l.msg, l.err)
+		fmt.Fprintf(&h, `%s<span style="color: #c00">%s</span> <span style="color: #888">(%s)</span>`+"\n",
+			indent, html.EscapeString(l.msg), html.EscapeString(fmt.Sprintf("%T", l.err)))
+		for _, w := range l.wrapped {
+			write(w, indent+"    ")
+		}
+	}
+	write(chain, "")
+	if len(frames) > 0 {
+		text.WriteString("\nstack trace:\n")
+		h.WriteString("\nstack trace:\n")
+		for _, f := range frames {
+			fmt.Fprintf(&text, "%s in %s\n", f.label, f.function)
+			fmt.Fprintf(&h, `<span style="color: #080">%s</span> in %s`+"\n", html.EscapeString(f.label), html.EscapeString(f.function))
+			if f.text != "" {
+				fmt.Fprintf(&text, "    %s\n", f.text)
+				fmt.Fprintf(&h, "    %s\n", html.EscapeString(f.text))
+			}
+		}
+	}
+	h.WriteString("</pre>")
+
+	data["text/plain"] = strings.TrimSuffix(text.String(), "\n")
+	data["text/html"] = h.String()
+	return true
+}
+
+// cellFrame is a stack frame in the cell's own code.
+type cellFrame struct {
+	function string
+	label    string // where in the cell, or the file, the frame is
+	text     string // the line of the frame
+}
+
+// cellFrames returns the frames of pcs in the cell's own code, located in the
+// cell when the kernel provides its code.
+func cellFrames(pcs []uintptr) []cellFrame {
+	if len(pcs) == 0 {
+		return nil
+	}
+	var src trace.Source
+	if path := os.Getenv(CellFileEnv); path != "" {
+		code, _ := ioutil.ReadFile(path)
+		src.Code = string(code)
+	}
+
+	var frames []cellFrame
+	it := runtime.CallersFrames(pcs)
+	for {
+		f, more := it.Next()
+		if strings.HasPrefix(f.Function, "main.") && !strings.HasPrefix(f.Function, "main."+trace.PrinterName) {
+			if len(src.Files) == 0 && src.Code != "" {
+				// The first frame of the cell's code is in the session file.
+				session, _ := ioutil.ReadFile(f.File)
+				src.Session = string(session)
+				src.Files = []string{f.File}
+			}
+			label, text, _ := src.Locate(f.File, f.Line, 0)
+			frames = append(frames, cellFrame{function: f.Function + "()", label: label, text: text})
+		}
+		if !more {
+			break
+		}
+	}
+	return frames
+}
@@ -0,0 +1,85 @@
+package gophernotes
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wrapped wraps an error through Unwrap, like fmt.Errorf with %w.
+type wrapped struct {
+	msg string
+	err error
+}
+
+func (w wrapped) Error() string { return w.msg + ": " + w.err.Error() }
+func (w wrapped) Unwrap() error { return w.err }
+
+// frame and traced mimic the stack traces of github.com/pkg/errors.
+type frame uintptr
+
+type traced struct {
+	msg   string
+	cause error
+	stack []uintptr
+}
+
+func (t traced) Cause() error { return t.cause }
+
+func (t traced) Error() string {
+	if t.msg == "" {
+		return t.cause.Error()
+	}
+	return t.msg + ": " + t.cause.Error()
+}
+
+func (t traced) StackTrace() []frame {
+	frames := make([]frame, len(t.stack))
+	for i, pc := range t.stack {
+		frames[i] = frame(pc)
+	}
+	return frames
+}
+
+// TestRender_errorChain tests that the errors an error wraps are shown one
+// per line
+func TestRender_errorChain(t *testing.T) {
+	base := errors.New("no such file")
+	err := wrapped{"load config", traced{msg: "open <x>", cause: base}}
+	data := Render(err)
+
+	assert.Equal(t, "load config (gophernotes.wrapped)\n"+
+		"    open <x> (gophernotes.traced)\n"+
+		"        no such file (*errors.errorString)", data["text/plain"])
+	assert.Contains(t, data["text/html"], `    <span style="color: #c00">open &lt;x&gt;</span>`)
+}
+
+// TestRender_errorStackOnly tests that errors only attaching a stack trace are
+// left out of the chain
+func TestRender_errorStackOnly(t *testing.T) {
+	err := traced{cause: wrapped{"save", errors.New("disk full")}}
+	assert.Equal(t, "save (gophernotes.wrapped)\n"+
+		"    disk full (*errors.errorString)", Render(err)["text/plain"])
+}
+
+// TestRender_errorPlain tests that errors wrapping nothing are left to their
+// message
+func TestRender_errorPlain(t *testing.T) {
+	data := Render(errors.New("boom"))
+	assert.Equal(t, MIMEBundle{"text/plain": "boom"}, data)
+}
+
+// TestErrorChain_stackTrace tests that the stack trace is taken from the
+// deepest error that has one
+func TestErrorChain_stackTrace(t *testing.T) {
+	pcs := make([]uintptr, 8)
+	pcs = pcs[:runtime.Callers(1, pcs)]
+	err := wrapped{"outer", traced{msg: "inner", cause: errors.New("base"), stack: pcs}}
+	assert.Equal(t, pcs, errorChain(err).stackTrace())
+	assert.Nil(t, errorChain(errors.New("base")).stackTrace())
+
+	// The frames of the package's own code are not the cell's.
+	assert.Empty(t, cellFrames(pcs))
+}
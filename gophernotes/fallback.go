@@ -0,0 +1,83 @@
+package gophernotes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"sort"
+)
+
+// maxFallbackText is the number of bytes of a textual representation kept in
+// the text/plain representation made from it.
+const maxFallbackText = 2000
+
+// fallbackTypes are the textual types a text/plain representation is made
+// from first, the most readable as text first.
+var fallbackTypes = []string{"text/markdown", "text/html", "text/latex"}
+
+// withPlainText returns data if it holds a text/plain representation, and
+// otherwise a copy of it with one describing the others, so that frontends
+// showing text only, such as jupyter console, nbconvert to scripts or logs,
+// show something of every output.
+func withPlainText(data MIMEBundle) MIMEBundle {
+	if data.Has("text/plain") {
+		return data
+	}
+	withText := MIMEBundle{"text/plain": fallbackText(data)}
+	for t, v := range data {
+		withText[t] = v
+	}
+	return withText
+}
+
+// fallbackText returns the text/plain representation of data: the text of its
+// Markdown, HTML without its tags or LaTeX, cut after maxFallbackText bytes, or
+// else a description of its first representation, such as
+// "<image/png, 640x480, 84 KiB>".
+func fallbackText(data MIMEBundle) string {
+	for _, t := range fallbackTypes {
+		if s, ok := data[t].(string); ok {
+			if t == "text/html" {
+				s = stripTags(s)
+			}
+			return cut([]byte(s), maxFallbackText)
+		}
+	}
+
+	types := make([]string, 0, len(data))
+	for t := range data {
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		return "<no output>"
+	}
+	sort.Strings(types)
+	return describeData(types[0], data[types[0]])
+}
+
+// describeData describes v, the representation of type mimeType in a bundle,
+// by its type, its size and, for images, its dimensions.
+func describeData(mimeType string, v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("<%s>", mimeType)
+		}
+		return fmt.Sprintf("<%s, %s>", mimeType, formatBytes(float64(len(b))))
+	}
+	if isTextType(mimeType) {
+		return fmt.Sprintf("<%s, %s>", mimeType, formatBytes(float64(len(s))))
+	}
+
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Sprintf("<%s>", mimeType)
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(b)); err == nil {
+		return fmt.Sprintf("<%s, %dx%d, %s>", mimeType, config.Width, config.Height, formatBytes(float64(len(b))))
+	}
+	return fmt.Sprintf("<%s, %s>", mimeType, formatBytes(float64(len(b))))
+}
@@ -0,0 +1,119 @@
+package gophernotes
+
+import (
+	"errors"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWithPlainText tests that bundles lacking text/plain get one describing
+// their other representations, and that those having one are left alone
+func TestWithPlainText(t *testing.T) {
+	png := pngData(t, 640, 480)
+	cases := []struct {
+		data MIMEBundle
+		want string
+	}{
+		{MIMEBundle{"text/html": "<p>Hello, <b>world</b></p>", "text/markdown": "Hello, **world**"}, "Hello, **world**"},
+		{MIMEBundle{"text/html": "<p>Hello, <b>world</b></p>", "text/latex": "$x$"}, "Hello, world"},
+		{MIMEBundle{"image/png": EncodeData("image/png", png)}, "<image/png, 640x480, " + formatBytes(float64(len(png))) + ">"},
+		{MIMEBundle{"application/pdf": EncodeData("application/pdf", []byte("%PDF"))}, "<application/pdf, 4 B>"},
+		{MIMEBundle{"image/svg+xml": "<svg></svg>"}, "<image/svg+xml, 11 B>"},
+		{MIMEBundle{VegaLiteMIMEType: map[string]interface{}{"mark": "bar"}}, "<" + VegaLiteMIMEType + ", 14 B>"},
+		{MIMEBundle{}, "<no output>"},
+	}
+	for _, c := range cases {
+		data := withPlainText(c.data)
+		assert.Equal(t, c.want, data.PlainText())
+		assert.False(t, c.data.Has("text/plain"), "the bundle given is left as it is")
+		for mimeType := range c.data {
+			assert.Equal(t, c.data[mimeType], data[mimeType])
+		}
+	}
+
+	data := MIMEBundle{"text/html": "<b>rich</b>", "text/plain": "plain"}
+	assert.Equal(t, data, withPlainText(data))
+
+	long := withPlainText(MIMEBundle{"text/markdown": strings.Repeat("x", maxFallbackText+10)})
+	assert.Equal(t, strings.Repeat("x", maxFallbackText)+"\n… (10 more bytes)", long.PlainText())
+}
+
+// TestDisplayData_plainText tests that outputs published without text/plain
+// get one
+func TestDisplayData_plainText(t *testing.T) {
+	data := onlyData(t, published(t, func() {
+		DisplayData(MIMEBundle{"text/html": "<i>only html</i>"})
+	}))
+	assert.Equal(t, "only html", data["text/plain"])
+}
+
+// TestRender_plainTextEverywhere tests that every built-in renderer, and every
+// helper building bundles, provides a text/plain representation of its own,
+// so that outputs show something in frontends showing text only
+func TestRender_plainTextEverywhere(t *testing.T) {
+	values := []interface{}{
+		image.NewGray(image.Rect(0, 0, 4, 3)),
+		bundleValue{},
+		svgValue{},
+		pngValue{},
+		markdownValue{},
+		latexValue{},
+		htmlValue{},
+		[]point{{1, 2}},
+		[][]string{{"a"}, {"1"}},
+		[]map[string]interface{}{{"a": 1}},
+		[]byte{0, 1, 2},
+		[]byte("text"),
+		errors.New("plain"),
+		wrapped{"outer", errors.New("inner")},
+		42,
+	}
+
+	// Each renderer must recognise one of the values, for the values to
+	// cover the renderers added later.
+	recognised := make([]bool, len(renderers))
+	for _, v := range values {
+		for i, r := range renderers {
+			if r(v, MIMEBundle{}, map[string]interface{}{}) {
+				recognised[i] = true
+			}
+		}
+		data, _ := render(v)
+		assert.NotEmpty(t, data.PlainText(), "%T", v)
+	}
+	for i, ok := range recognised {
+		assert.True(t, ok, "no value for renderer %d", i)
+	}
+
+	var bundles []MIMEBundle
+	for _, b := range []func() (MIMEBundle, error){
+		func() (MIMEBundle, error) { return iframeBundle("https://example.com", 400, 300) },
+		func() (MIMEBundle, error) { return mediaBundle("audio", encodeWAV([]float64{0, 1}, 8000), "audio/wav") },
+		func() (MIMEBundle, error) {
+			return vegaLiteBundle(`{"data": {"values": []}, "mark": "point"}`)
+		},
+		func() (MIMEBundle, error) { return jsonBundle(map[string]interface{}{"a": 1}) },
+	} {
+		bundle, err := b()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundles = append(bundles, bundle)
+	}
+	for _, name := range []string{"a.png", "a.html", "a.md", "a.tex", "a.json", "a.pdf", "a.wav", "a.txt", "a.bin"} {
+		bundles = append(bundles, fileBundle(name, []byte("data")))
+	}
+	table, _ := newTable([]point{{1, 2}})
+	bundles = append(bundles,
+		hexdumpBundle([]byte{0, 1}),
+		textBundle([]byte("text")),
+		table.bundle(),
+		(&Progress{total: 10}).bundle(),
+	)
+	for _, bundle := range bundles {
+		assert.NotEmpty(t, bundle.PlainText(), "%v", bundle)
+	}
+}
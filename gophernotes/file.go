@@ -0,0 +1,123 @@
+package gophernotes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxFilePreview is the number of bytes of a text file shown by DisplayFile
+// when it has no richer representation.
+var MaxFilePreview = 10000
+
+// fileTypes are the MIME types of extensions that matter in notebooks but are
+// missing from the tables of the mime package on some systems.
+var fileTypes = map[string]string{
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".tex":      "text/latex",
+	".csv":      "text/csv",
+	".json":     "application/json",
+	".svg":      "image/svg+xml",
+	".pdf":      "application/pdf",
+	".wav":      "audio/wav",
+	".mp3":      "audio/mpeg",
+	".ogg":      "audio/ogg",
+	".flac":     "audio/flac",
+	".mp4":      "video/mp4",
+	".webm":     "video/webm",
+}
+
+// DisplayFile publishes the file at path, shown according to its type: images
+// inline and laid out according to opts, HTML, Markdown, LaTeX and JSON files
+// rendered, audio and video with a player, and PDF files through the PDF
+// viewer of JupyterLab. Other text files are shown as text, cut after
+// MaxFilePreview bytes, and other binary files as a short description.
+func DisplayFile(path string, opts ...ImageOption) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		errorf("could not display file: %s", err)
+		return
+	}
+	bundle := fileBundle(path, data)
+	metadata := map[string]interface{}{}
+	imageMetadata(bundle, metadata, opts)
+	display(bundle, metadata)
+}
+
+// fileType returns the MIME type of a file named name holding data, from its
+// extension or failing that its content.
+func fileType(name string, data []byte) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	t := fileTypes[ext]
+	if t == "" {
+		t = mime.TypeByExtension(ext)
+	}
+	if t == "" {
+		t = http.DetectContentType(data)
+	}
+	if i := strings.Index(t, ";"); i >= 0 {
+		t = t[:i]
+	}
+	return strings.TrimSpace(t)
+}
+
+// fileBundle builds the bundle published by DisplayFile.
+func fileBundle(name string, data []byte) MIMEBundle {
+	mimeType := fileType(name, data)
+	bundle := MIMEBundle{
+		"text/plain": fmt.Sprintf("<%s: %s, %d bytes>", filepath.Base(name), mimeType, len(data)),
+	}
+
+	switch mimeType {
+	case "image/png", "image/jpeg", "image/gif", "application/pdf", "image/svg+xml":
+		bundle[mimeType] = EncodeData(mimeType, data)
+	case "text/html":
+		bundle[mimeType] = EncodeData(mimeType, data)
+		bundle["text/plain"] = preview([]byte(stripTags(string(data))))
+	case "text/markdown", "text/latex":
+		bundle[mimeType] = EncodeData(mimeType, data)
+		bundle["text/plain"] = preview(data)
+	case "application/json":
+		if b, err := jsonBundle(data); err == nil {
+			return b
+		}
+		bundle["text/plain"] = preview(data)
+	default:
+		for _, kind := range []string{"audio", "video"} {
+			if strings.HasPrefix(mimeType, kind+"/") {
+				if b, err := mediaBundle(kind, data, mimeType); err == nil {
+					return b
+				}
+				return bundle
+			}
+		}
+		if strings.HasPrefix(mimeType, "text/") || utf8.Valid(data) {
+			bundle["text/plain"] = preview(data)
+		}
+	}
+	return bundle
+}
+
+// preview returns text cut after MaxFilePreview bytes, at a character
+// boundary, followed by the number of bytes left out.
+func preview(text []byte) string {
+	return cut(text, MaxFilePreview)
+}
+
+// cut returns text cut after max bytes, as preview does; text is left whole if
+// max is zero or less.
+func cut(text []byte, max int) string {
+	if max <= 0 || len(text) <= max {
+		return EncodeData("text/plain", text)
+	}
+	n := max
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s\n… (%d more bytes)", EncodeData("text/plain", text[:n]), len(text)-n)
+}
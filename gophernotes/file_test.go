@@ -0,0 +1,96 @@
+package gophernotes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFileBundle tests that files are shown according to their type
+func TestFileBundle(t *testing.T) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(img.Bytes())
+
+	cases := []struct {
+		name string
+		data string
+		want MIMEBundle
+	}{
+		{"chart.png", img.String(), MIMEBundle{
+			"image/png":  encoded,
+			"text/plain": "<chart.png: image/png, " + strconv.Itoa(img.Len()) + " bytes>",
+		}},
+		// The type is sniffed from the content when the extension is unknown.
+		{"chart.out", img.String(), MIMEBundle{
+			"image/png":  encoded,
+			"text/plain": "<chart.out: image/png, " + strconv.Itoa(img.Len()) + " bytes>",
+		}},
+		{"report.html", "<h1>Report</h1>", MIMEBundle{
+			"text/html":  "<h1>Report</h1>",
+			"text/plain": "Report",
+		}},
+		{"notes.md", "# Notes", MIMEBundle{
+			"text/markdown": "# Notes",
+			"text/plain":    "# Notes",
+		}},
+		{"doc.pdf", "%PDF-1.4", MIMEBundle{
+			"application/pdf": base64.StdEncoding.EncodeToString([]byte("%PDF-1.4")),
+			"text/plain":      "<doc.pdf: application/pdf, 8 bytes>",
+		}},
+		{"data.json", `{"a": 1}`, MIMEBundle{
+			"application/json": map[string]interface{}{"a": float64(1)},
+			"text/plain":       "{\n  \"a\": 1\n}",
+		}},
+		{"log.txt", "line 1\nline 2", MIMEBundle{
+			"text/plain": "line 1\nline 2",
+		}},
+		{"blob.bin", "\x00\x01\xfe\xff", MIMEBundle{
+			"text/plain": "<blob.bin: application/octet-stream, 4 bytes>",
+		}},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, fileBundle(c.name, []byte(c.data)), c.name)
+	}
+}
+
+// TestDisplayFile tests that DisplayFile publishes the file it reads, and
+// nothing for missing files
+func TestDisplayFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophernotes_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "notes.md")
+	if err := ioutil.WriteFile(path, []byte("# Notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data := onlyData(t, published(t, func() { DisplayFile(path) }))
+	assert.Equal(t, "# Notes", data["text/markdown"])
+
+	msgs := published(t, func() { DisplayFile(filepath.Join(dir, "missing.md")) })
+	assert.Empty(t, msgs)
+}
+
+// TestDisplayFile_preview tests that long text files are cut short, at a
+// character boundary
+func TestDisplayFile_preview(t *testing.T) {
+	defer func(n int) { MaxFilePreview = n }(MaxFilePreview)
+	MaxFilePreview = 5
+
+	bundle := fileBundle("long.txt", []byte("abcdéfgh"))
+	assert.Equal(t, "abcd\n… (5 more bytes)", bundle["text/plain"])
+}
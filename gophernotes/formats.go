@@ -0,0 +1,88 @@
+package gophernotes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Markdown publishes s as text/markdown, with the source itself as the plain
+// text version.
+func Markdown(s string) {
+	DisplayData(MIMEBundle{
+		"text/markdown": s,
+		"text/plain":    s,
+	})
+}
+
+// Latex publishes s as text/latex, with the source itself as the plain text
+// version. Unless s is already delimited, by $ or a \begin environment, it is
+// wrapped in $$ so that it is typeset as display math.
+func Latex(s string) {
+	DisplayData(MIMEBundle{
+		"text/latex": delimitLatex(s),
+		"text/plain": s,
+	})
+}
+
+// delimitLatex wraps s in $$ unless it is already delimited.
+func delimitLatex(s string) string {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "$") || strings.HasPrefix(trimmed, `\begin{`) {
+		return s
+	}
+	return "$$" + trimmed + "$$"
+}
+
+// SVG publishes s, a complete <svg> document, as image/svg+xml.
+func SVG(s string) {
+	DisplayData(MIMEBundle{
+		"image/svg+xml": s,
+		"text/plain":    fmt.Sprintf("<image/svg+xml, %d bytes>", len(s)),
+	})
+}
+
+// JSON publishes v as application/json, so that frontends can show it as a
+// collapsible tree, with its indented encoding as the plain text version. v
+// may be any value encoding/json can encode; []byte and json.RawMessage values
+// are taken to be encoded JSON already.
+func JSON(v interface{}) {
+	data, err := jsonBundle(v)
+	if err != nil {
+		errorf("could not display JSON: %s", err)
+		return
+	}
+	DisplayData(data)
+}
+
+// jsonBundle builds the bundle published by JSON. The application/json entry
+// holds the decoded form of v, so that it is embedded in the message as a JSON
+// value rather than as an encoded string.
+func jsonBundle(v interface{}) (MIMEBundle, error) {
+	var encoded []byte
+	switch v := v.(type) {
+	case json.RawMessage:
+		encoded = v
+	case []byte:
+		encoded = v
+	default:
+		var err error
+		if encoded, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+	text, err := json.MarshalIndent(decoded, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return MIMEBundle{
+		"application/json": decoded,
+		"text/plain":       string(text),
+	}, nil
+}
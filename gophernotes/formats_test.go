@@ -0,0 +1,75 @@
+package gophernotes
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMarkdown tests the bundle published by Markdown
+func TestMarkdown(t *testing.T) {
+	data := onlyData(t, published(t, func() { Markdown("# Title\n*text*") }))
+	assert.Equal(t, map[string]interface{}{
+		"text/markdown": "# Title\n*text*",
+		"text/plain":    "# Title\n*text*",
+	}, data)
+}
+
+// TestLatex tests the bundle published by Latex, and when it adds delimiters
+func TestLatex(t *testing.T) {
+	cases := []struct {
+		in, latex string
+	}{
+		{`e^{i\pi} + 1 = 0`, `$$e^{i\pi} + 1 = 0$$`},
+		{`$x^2$`, `$x^2$`},
+		{`\begin{align}a &= b\end{align}`, `\begin{align}a &= b\end{align}`},
+	}
+
+	for _, c := range cases {
+		data := onlyData(t, published(t, func() { Latex(c.in) }))
+		assert.Equal(t, map[string]interface{}{
+			"text/latex": c.latex,
+			"text/plain": c.in,
+		}, data)
+	}
+}
+
+// TestSVG tests the bundle published by SVG
+func TestSVG(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"></svg>`
+	data := onlyData(t, published(t, func() { SVG(svg) }))
+	assert.Len(t, data, 2)
+	assert.Equal(t, svg, data["image/svg+xml"])
+	assert.Contains(t, data["text/plain"], "image/svg+xml")
+}
+
+// TestJSON tests that JSON publishes structured data, whether it is given a Go
+// value or encoded JSON
+func TestJSON(t *testing.T) {
+	want := map[string]interface{}{
+		"name": "gopher",
+		"tags": []interface{}{"a", "b"},
+	}
+	inputs := []interface{}{
+		struct {
+			Name string   `json:"name"`
+			Tags []string `json:"tags"`
+		}{"gopher", []string{"a", "b"}},
+		[]byte(`{"name": "gopher", "tags": ["a", "b"]}`),
+		json.RawMessage(`{"name": "gopher", "tags": ["a", "b"]}`),
+	}
+
+	for _, in := range inputs {
+		data := onlyData(t, published(t, func() { JSON(in) }))
+		assert.Len(t, data, 2)
+		assert.Equal(t, want, data["application/json"])
+		assert.Equal(t, "{\n  \"name\": \"gopher\",\n  \"tags\": [\n    \"a\",\n    \"b\"\n  ]\n}", data["text/plain"])
+	}
+}
+
+// TestJSON_invalid tests that values JSON cannot encode are not published
+func TestJSON_invalid(t *testing.T) {
+	msgs := published(t, func() { JSON([]byte("{not json")) })
+	assert.Empty(t, msgs)
+}
@@ -0,0 +1,91 @@
+//go:build gonum
+// +build gonum
+
+// Package gonumplot displays gonum.org/v1/plot plots in gophernotes notebooks.
+//
+// It lives apart from the gophernotes package, and is only built with the gonum
+// tag, so that only notebooks making plots depend on gonum: it is installed
+// with go get -tags gonum, and the kernel started with GOFLAGS=-tags=gonum in
+// its environment. Once a cell imports it:
+//
+//	import "github.com/gopherds/gophernotes/gophernotes/gonumplot"
+//
+// cells evaluating to a *plot.Plot show it as an image, and ShowPlot displays
+// one explicitly.
+package gonumplot
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gopherds/gophernotes/gophernotes"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+	"gonum.org/v1/plot/vg/vgsvg"
+)
+
+// Width and Height are the size plots are drawn at.
+var (
+	Width  = 6 * vg.Inch
+	Height = 4 * vg.Inch
+)
+
+// DPI is the resolution of the PNG images plots are rendered to.
+var DPI = 96
+
+// SVG sets whether plots are also rendered as SVG, which frontends prefer over
+// PNG when both are present.
+var SVG = false
+
+func init() {
+	gophernotes.RegisterRenderer(renderPlot)
+}
+
+// ShowPlot displays p.
+func ShowPlot(p *plot.Plot) {
+	gophernotes.DisplayData(gophernotes.Render(p))
+}
+
+// renderPlot adds image/png, and optionally image/svg+xml, representations of
+// *plot.Plot values.
+func renderPlot(v interface{}, data gophernotes.MIMEBundle, metadata map[string]interface{}) bool {
+	p, ok := v.(*plot.Plot)
+	if !ok {
+		return false
+	}
+
+	text := "plot"
+	if p.Title.Text != "" {
+		text = fmt.Sprintf("plot %q", p.Title.Text)
+	}
+
+	c := vgimg.NewWith(vgimg.UseWH(Width, Height), vgimg.UseDPI(DPI))
+	p.Draw(draw.New(c))
+	var buf bytes.Buffer
+	if _, err := (vgimg.PngCanvas{Canvas: c}).WriteTo(&buf); err != nil {
+		data["text/plain"] = fmt.Sprintf("%s (could not render: %s)", text, err)
+		return true
+	}
+	data["image/png"] = gophernotes.EncodeData("image/png", buf.Bytes())
+
+	// The image is shown at its size in points, so that it keeps the same
+	// size whatever the DPI.
+	metadata["image/png"] = map[string]interface{}{
+		"width":  int(Width.Points()),
+		"height": int(Height.Points()),
+	}
+
+	if SVG {
+		svg := vgsvg.New(Width, Height)
+		p.Draw(draw.New(svg))
+		buf.Reset()
+		if _, err := svg.WriteTo(&buf); err == nil {
+			data["image/svg+xml"] = gophernotes.EncodeData("image/svg+xml", buf.Bytes())
+		}
+	}
+
+	data["text/plain"] = text
+	return true
+}
@@ -0,0 +1,104 @@
+//go:build gonum
+// +build gonum
+
+package gonumplot
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"testing"
+
+	"github.com/gopherds/gophernotes/gophernotes"
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+)
+
+// scatter returns a small scatter plot.
+func scatter(t *testing.T) *plot.Plot {
+	p := plot.New()
+	p.Title.Text = "squares"
+	pts := make(plotter.XYs, 10)
+	for i := range pts {
+		pts[i].X = float64(i)
+		pts[i].Y = float64(i * i)
+	}
+	s, err := plotter.NewScatter(pts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Add(s)
+	return p
+}
+
+// TestRender_plot tests that plots are rendered to PNG images of the
+// configured size
+func TestRender_plot(t *testing.T) {
+	data := gophernotes.Render(scatter(t))
+
+	assert.Equal(t, `plot "squares"`, data["text/plain"])
+	assert.False(t, data.Has("image/svg+xml"))
+
+	encoded, ok := data["image/png"].(string)
+	if !assert.True(t, ok, "no image/png representation") {
+		return
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, len(raw) > 1000, "image/png payload of %d bytes", len(raw))
+
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 6*DPI, img.Bounds().Dx())
+	assert.Equal(t, 4*DPI, img.Bounds().Dy())
+}
+
+// TestRender_plot_svg tests that plots are also rendered as SVG when asked to
+func TestRender_plot_svg(t *testing.T) {
+	SVG = true
+	defer func() { SVG = false }()
+
+	data := gophernotes.Render(scatter(t))
+	assert.True(t, data.Has("image/png"))
+	assert.Contains(t, data["image/svg+xml"], "<svg")
+}
+
+// A scatter plot renders to a PNG image of the default size, along with its
+// description.
+func Example() {
+	p := plot.New()
+	p.Title.Text = "squares"
+	s, _ := plotter.NewScatter(plotter.XYs{{X: 1, Y: 1}, {X: 2, Y: 4}, {X: 3, Y: 9}})
+	p.Add(s)
+
+	data := gophernotes.Render(p)
+	raw, _ := base64.StdEncoding.DecodeString(data["image/png"].(string))
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(data["text/plain"])
+	fmt.Println(len(raw) > 1000, img.Bounds().Dx(), img.Bounds().Dy())
+	// Output:
+	// plot "squares"
+	// true 576 384
+}
+
+// Outside of the kernel, ShowPlot falls back to printing a description of the
+// plot.
+func ExampleShowPlot() {
+	p := plot.New()
+	p.Title.Text = "squares"
+	s, _ := plotter.NewScatter(plotter.XYs{{X: 1, Y: 1}, {X: 2, Y: 4}, {X: 3, Y: 9}})
+	p.Add(s)
+
+	ShowPlot(p)
+	// Output: plot "squares"
+}
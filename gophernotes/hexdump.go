@@ -0,0 +1,138 @@
+package gophernotes
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxHexdumpBytes is the number of bytes shown when byte slices and arrays
+// are displayed; the rest are summed up in a final line. Zero or less shows
+// every byte.
+var MaxHexdumpBytes = 1024
+
+// BytesAsText sets whether byte slices and arrays holding mostly printable
+// UTF-8 text are shown as that text, rather than as a hexdump.
+var BytesAsText = true
+
+// Hexdump displays b as a hexdump, with offsets and an ASCII column, like
+// hexdump -C.
+func Hexdump(b []byte) {
+	display(hexdumpBundle(b), map[string]interface{}{})
+}
+
+// renderBytes shows byte slices and arrays as hexdumps, or as text if they
+// hold text and BytesAsText is set, unless they already provided HTML of their
+// own. Types with a String method, such as net.IP, are left to it.
+func renderBytes(v interface{}, data MIMEBundle, metadata map[string]interface{}) bool {
+	if data.Has("text/html") {
+		return false
+	}
+	if _, ok := v.(fmt.Stringer); ok {
+		return false
+	}
+	if _, ok := v.(error); ok {
+		return false
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array || rv.Type().Elem().Kind() != reflect.Uint8 || rv.Len() == 0 {
+		return false
+	}
+
+	b := make([]byte, rv.Len())
+	reflect.Copy(reflect.ValueOf(b), rv)
+	bundle := hexdumpBundle(b)
+	if BytesAsText && isText(b) {
+		bundle = textBundle(b)
+	}
+	for mimeType, rep := range bundle {
+		data[mimeType] = rep
+	}
+	return true
+}
+
+// capBytes returns the first MaxHexdumpBytes of b, and the line ending the
+// output if some were left out.
+func capBytes(b []byte) ([]byte, string) {
+	if MaxHexdumpBytes <= 0 || len(b) <= MaxHexdumpBytes {
+		return b, ""
+	}
+	more := len(b) - MaxHexdumpBytes
+	if more == 1 {
+		return b[:MaxHexdumpBytes], "… 1 more byte"
+	}
+	return b[:MaxHexdumpBytes], fmt.Sprintf("… %d more bytes", more)
+}
+
+// hexdumpBundle builds the bundle published by Hexdump.
+func hexdumpBundle(b []byte) MIMEBundle {
+	shown, trailer := capBytes(b)
+	dump := strings.TrimSuffix(hex.Dump(shown), "\n")
+
+	// Lines of hex.Dump output hold the offset in their first 8 characters,
+	// and the ASCII column from the first |.
+	var h bytes.Buffer
+	h.WriteString(`<pre style="line-height: 1.3">`)
+	for i, line := range strings.Split(dump, "\n") {
+		if i > 0 {
+			h.WriteByte('\n')
+		}
+		gutter := strings.Index(line, "|")
+		if len(line) < 8 || gutter < 0 {
+			h.WriteString(html.EscapeString(line))
+			continue
+		}
+		fmt.Fprintf(&h, `<span style="color: #888">%s</span>%s<span style="color: #888">%s</span>`,
+			line[:8], html.EscapeString(line[8:gutter]), html.EscapeString(line[gutter:]))
+	}
+	h.WriteString("</pre>")
+
+	text := dump
+	if trailer != "" {
+		text += "\n" + trailer
+		fmt.Fprintf(&h, "<p>%s</p>", trailer)
+	}
+	return MIMEBundle{
+		"text/html":  h.String(),
+		"text/plain": text,
+	}
+}
+
+// textBundle shows b, known to be text, as such.
+func textBundle(b []byte) MIMEBundle {
+	shown, trailer := capBytes(b)
+	// Do not cut the last character in two.
+	for len(shown) < len(b) && len(shown) > 0 && !utf8.RuneStart(b[len(shown)]) {
+		shown = shown[:len(shown)-1]
+	}
+
+	note := fmt.Sprintf("(%d bytes of text)", len(b))
+	if trailer != "" {
+		note = fmt.Sprintf("(%d bytes of text, %d shown)", len(b), len(shown))
+	}
+	return MIMEBundle{
+		"text/html":  fmt.Sprintf("<pre>%s</pre><small>%s</small>", html.EscapeString(string(shown)), note),
+		"text/plain": string(shown) + "\n" + note,
+	}
+}
+
+// isText reports whether b is valid UTF-8 made, for the most part, of
+// printable characters.
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	var printable, total int
+	for _, r := range string(b) {
+		total++
+		if unicode.IsPrint(r) || r == '\n' || r == '\t' || r == '\r' {
+			printable++
+		}
+	}
+	return printable*10 >= total*9
+}
@@ -0,0 +1,66 @@
+package gophernotes
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRender_bytes tests that byte slices and arrays are shown as hexdumps
+func TestRender_bytes(t *testing.T) {
+	b := []byte("\x00\x01hello, world\xff\xfe<>")
+	data := Render(b)
+
+	want := "00000000  00 01 68 65 6c 6c 6f 2c  20 77 6f 72 6c 64 ff fe  |..hello, world..|\n" +
+		"00000010  3c 3e                                             |<>|"
+	assert.Equal(t, want, data["text/plain"])
+	html := data["text/html"].(string)
+	assert.Contains(t, html, `<span style="color: #888">00000010</span>  3c 3e`)
+	assert.Contains(t, html, `<span style="color: #888">|&lt;&gt;|</span>`)
+
+	var arr [4]byte
+	assert.Equal(t, "00000000  00 00 00 00                                       |....|", Render(arr)["text/plain"])
+}
+
+// TestRender_bytesText tests that bytes holding text are shown as text, unless
+// BytesAsText is unset
+func TestRender_bytesText(t *testing.T) {
+	b := []byte("hello <world>\n")
+	data := Render(b)
+	assert.Equal(t, "hello <world>\n\n(14 bytes of text)", data["text/plain"])
+	assert.Equal(t, "<pre>hello &lt;world&gt;\n</pre><small>(14 bytes of text)</small>", data["text/html"])
+
+	BytesAsText = false
+	defer func() { BytesAsText = true }()
+	assert.True(t, strings.HasPrefix(Render(b)["text/plain"].(string), "00000000  68 65 6c 6c 6f"))
+}
+
+// TestRender_bytesTruncated tests that only MaxHexdumpBytes bytes are shown
+func TestRender_bytesTruncated(t *testing.T) {
+	defer func(n int) { MaxHexdumpBytes = n }(MaxHexdumpBytes)
+	MaxHexdumpBytes = 16
+
+	data := Render(make([]byte, 40))
+	lines := strings.Split(data["text/plain"].(string), "\n")
+	assert.Len(t, lines, 2)
+	assert.Equal(t, "… 24 more bytes", lines[1])
+	assert.True(t, strings.HasSuffix(data["text/html"].(string), "</pre><p>… 24 more bytes</p>"))
+
+	data = Render([]byte(strings.Repeat("é", 10)))
+	assert.Equal(t, strings.Repeat("é", 8)+"\n(20 bytes of text, 16 shown)", data["text/plain"])
+}
+
+// TestRender_bytesStringer tests that byte types with a String method are
+// shown through it
+func TestRender_bytesStringer(t *testing.T) {
+	assert.Equal(t, MIMEBundle{"text/plain": "127.0.0.1"}, Render(net.IPv4(127, 0, 0, 1)))
+	assert.Equal(t, MIMEBundle{"text/plain": "[]"}, Render([]byte{}))
+}
+
+// TestHexdump tests that Hexdump shows text as a hexdump all the same
+func TestHexdump(t *testing.T) {
+	data := onlyData(t, published(t, func() { Hexdump([]byte("hi")) }))
+	assert.Equal(t, "00000000  68 69                                             |hi|", data["text/plain"])
+}
@@ -0,0 +1,92 @@
+package gophernotes
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+)
+
+// HTML publishes s as text/html, along with a plain text version of it for
+// frontends that cannot show HTML.
+func HTML(s string) {
+	DisplayData(MIMEBundle{
+		"text/html":  s,
+		"text/plain": stripTags(s),
+	})
+}
+
+// IFrame publishes an iframe showing the page at rawurl, width by height pixels
+// large. A width of zero or less fills the width of the output, and a height
+// of zero or less defaults to 400 pixels. Only http and https URLs, and URLs
+// relative to the notebook, are accepted.
+func IFrame(rawurl string, width, height int) {
+	bundle, err := iframeBundle(rawurl, width, height)
+	if err != nil {
+		errorf("could not display iframe: %s", err)
+		return
+	}
+	DisplayData(bundle)
+}
+
+// iframeBundle builds the bundle published by IFrame.
+func iframeBundle(rawurl string, width, height int) (MIMEBundle, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	w := "100%"
+	if width > 0 {
+		w = fmt.Sprint(width)
+	}
+	if height <= 0 {
+		height = 400
+	}
+	src := u.String()
+	return MIMEBundle{
+		"text/html": fmt.Sprintf(`<iframe src="%s" width="%s" height="%d" frameborder="0" allowfullscreen></iframe>`,
+			html.EscapeString(src), w, height),
+		"text/plain": fmt.Sprintf("<iframe %s>", src),
+	}, nil
+}
+
+// JavaScript publishes code as application/javascript, for the frontend to
+// run. Frontends differ: the classic notebook runs the code with element bound
+// to the output area, while JupyterLab only runs it in trusted notebooks, and
+// nbconvert and the console never do, showing a short note instead.
+func JavaScript(code string) {
+	DisplayData(MIMEBundle{
+		"application/javascript": code,
+		"text/plain":             fmt.Sprintf("<JavaScript, %d bytes>", len(code)),
+	})
+}
+
+// stripTags returns the text content of the HTML fragment s. If s has no text
+// content, such as a lone <img> tag, s is returned unchanged.
+func stripTags(s string) string {
+	var text bytes.Buffer
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>' && inTag:
+			inTag = false
+		case !inTag:
+			text.WriteRune(r)
+		}
+	}
+
+	stripped := strings.TrimSpace(html.UnescapeString(text.String()))
+	if stripped == "" {
+		return s
+	}
+	return stripped
+}
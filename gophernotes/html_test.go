@@ -0,0 +1,71 @@
+package gophernotes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type htmlValue struct{}
+
+func (htmlValue) HTML() string   { return "<i>rich</i>" }
+func (htmlValue) String() string { return "plain" }
+
+// TestHTML tests the bundle published by HTML
+func TestHTML(t *testing.T) {
+	cases := []struct {
+		html, text string
+	}{
+		{`<b>bold</b> &amp; <a href="x">link</a>`, "bold & link"},
+		{`<img src="x.png">`, `<img src="x.png">`},
+		{"no tags", "no tags"},
+	}
+
+	for _, c := range cases {
+		data := onlyData(t, published(t, func() { HTML(c.html) }))
+		assert.Len(t, data, 2)
+		assert.Equal(t, c.html, data["text/html"])
+		assert.Equal(t, c.text, data["text/plain"])
+	}
+}
+
+// TestRender_HTML tests that values with an HTML method are rendered as
+// text/html with a text/plain fallback
+func TestRender_HTML(t *testing.T) {
+	data := Render(htmlValue{})
+	assert.Equal(t, MIMEBundle{"text/html": "<i>rich</i>", "text/plain": "plain"}, data)
+
+	data = onlyData(t, published(t, func() { Display(htmlValue{}) }))
+	assert.Equal(t, "<i>rich</i>", data["text/html"])
+	assert.Equal(t, "plain", data["text/plain"])
+}
+
+// TestIFrame tests the bundle published by IFrame, with the URL escaped
+func TestIFrame(t *testing.T) {
+	data := onlyData(t, published(t, func() { IFrame("https://example.com/dash?a=1&b=2", 640, 480) }))
+	assert.Equal(t, MIMEBundle{
+		"text/html":  `<iframe src="https://example.com/dash?a=1&amp;b=2" width="640" height="480" frameborder="0" allowfullscreen></iframe>`,
+		"text/plain": "<iframe https://example.com/dash?a=1&b=2>",
+	}, MIMEBundle(data))
+
+	bundle, err := iframeBundle(`report.html"><script>alert(1)</script>`, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := bundle["text/html"].(string)
+	assert.NotContains(t, html, "<script>")
+	assert.NotContains(t, html, `"><`)
+	assert.Contains(t, html, `width="100%" height="400"`)
+
+	_, err = iframeBundle("javascript:alert(1)", 0, 0)
+	assert.EqualError(t, err, `unsupported URL scheme "javascript"`)
+}
+
+// TestJavaScript tests the bundle published by JavaScript
+func TestJavaScript(t *testing.T) {
+	data := onlyData(t, published(t, func() { JavaScript("element.text('hi')") }))
+	assert.Equal(t, map[string]interface{}{
+		"application/javascript": "element.text('hi')",
+		"text/plain":             "<JavaScript, 18 bytes>",
+	}, data)
+}
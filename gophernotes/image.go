@@ -0,0 +1,143 @@
+package gophernotes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"strings"
+
+	// Registered so that the sizes of GIF and JPEG images can be read.
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+// MaxImagePixels is the pixel budget for displayed images. Larger images are
+// downscaled to fit it before being encoded; zero or less disables the limit.
+var MaxImagePixels = 2000 * 2000
+
+// imageTypes are the MIME types of the images whose size is added to the
+// metadata of the outputs holding them.
+var imageTypes = []string{"image/png", "image/jpeg", "image/gif"}
+
+// An ImageOption changes how images are laid out in the notebook, through the
+// metadata of the output rather than by encoding them again.
+type ImageOption func(size map[string]interface{})
+
+// ImageSize shows images width by height pixels large, rather than at their
+// own size. If either is zero or less, it is left for the frontend to work
+// out from the aspect ratio of the image.
+func ImageSize(width, height int) ImageOption {
+	return func(size map[string]interface{}) {
+		delete(size, "width")
+		delete(size, "height")
+		if width > 0 {
+			size["width"] = width
+		}
+		if height > 0 {
+			size["height"] = height
+		}
+	}
+}
+
+// DisplayImage publishes img as an inline PNG, laid out according to opts:
+//
+//	gophernotes.DisplayImage(img, gophernotes.ImageSize(320, 0))
+func DisplayImage(img image.Image, opts ...ImageOption) {
+	data := MIMEBundle{}
+	metadata := map[string]interface{}{}
+	renderImage(img, data, metadata)
+	imageMetadata(data, metadata, opts)
+	display(data, metadata)
+}
+
+// imageMetadata adds the sizes of the images in data to metadata, keyed by
+// MIME type as in {"image/png": {"width": 640, "height": 480}}, and then
+// applies opts to them. Sizes already in metadata are kept; the others are
+// read from the image headers.
+func imageMetadata(data MIMEBundle, metadata map[string]interface{}, opts []ImageOption) {
+	for _, t := range imageTypes {
+		encoded, ok := data[t].(string)
+		if !ok {
+			continue
+		}
+		size, ok := metadata[t].(map[string]interface{})
+		if !ok {
+			size = map[string]interface{}{}
+			r := base64.NewDecoder(base64.StdEncoding, strings.NewReader(encoded))
+			if config, _, err := image.DecodeConfig(r); err == nil {
+				size["width"] = config.Width
+				size["height"] = config.Height
+			}
+		}
+		for _, opt := range opts {
+			opt(size)
+		}
+		if len(size) > 0 {
+			metadata[t] = size
+		}
+	}
+}
+
+// renderImage adds an image/png representation of values implementing
+// image.Image, along with their dimensions and a text/plain description.
+func renderImage(v interface{}, data MIMEBundle, metadata map[string]interface{}) bool {
+	img, ok := v.(image.Image)
+	if !ok {
+		return false
+	}
+
+	name := strings.TrimPrefix(fmt.Sprintf("%T", img), "*")
+	bounds := img.Bounds()
+	text := fmt.Sprintf("%s %dx%d", name, bounds.Dx(), bounds.Dy())
+
+	scaled, ok := downscale(img, MaxImagePixels)
+	if ok {
+		bounds = scaled.Bounds()
+		text += fmt.Sprintf(" (downscaled to %dx%d)", bounds.Dx(), bounds.Dy())
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaled); err != nil {
+		data["text/plain"] = fmt.Sprintf("%s (could not encode: %s)", text, err)
+		return true
+	}
+
+	data["image/png"] = EncodeData("image/png", buf.Bytes())
+	data["text/plain"] = text
+	metadata["image/png"] = map[string]interface{}{
+		"width":  bounds.Dx(),
+		"height": bounds.Dy(),
+	}
+	return true
+}
+
+// downscale shrinks img, by nearest neighbour sampling, so that it has at most
+// maxPixels pixels. It reports whether img had to be shrunk.
+func downscale(img image.Image, maxPixels int) (image.Image, bool) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if maxPixels <= 0 || w*h <= maxPixels {
+		return img, false
+	}
+
+	scale := math.Sqrt(float64(maxPixels) / float64(w*h))
+	sw, sh := int(float64(w)*scale), int(float64(h)*scale)
+	if sw < 1 {
+		sw = 1
+	}
+	if sh < 1 {
+		sh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	for y := 0; y < sh; y++ {
+		sy := b.Min.Y + y*h/sh
+		for x := 0; x < sw; x++ {
+			dst.Set(x, y, img.At(b.Min.X+x*w/sw, sy))
+		}
+	}
+	return dst, true
+}
@@ -0,0 +1,153 @@
+package gophernotes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pngData returns a width by height PNG image.
+func pngData(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decodePNG decodes the image/png representation of data.
+func decodePNG(t *testing.T, data MIMEBundle) image.Image {
+	b, err := base64.StdEncoding.DecodeString(data["image/png"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// TestRenderImage tests that images are rendered as PNGs, with their sizes in
+// the metadata and a description of them as text
+func TestRenderImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	img.Set(3, 4, color.RGBA{R: 255, A: 255})
+	data, metadata := render(img)
+
+	assert.Equal(t, "image.RGBA 64x48", data["text/plain"])
+	assert.Equal(t, map[string]interface{}{"width": 64, "height": 48}, metadata["image/png"])
+	decoded := decodePNG(t, data)
+	assert.Equal(t, img.Bounds(), decoded.Bounds())
+	r, _, _, _ := decoded.At(3, 4).RGBA()
+	assert.Equal(t, uint32(0xffff), r)
+}
+
+// TestRenderImage_notEncoded tests that images which cannot be encoded are
+// still described as text
+func TestRenderImage_notEncoded(t *testing.T) {
+	data, metadata := render(image.NewGray(image.Rect(0, 0, 0, 10)))
+
+	assert.Nil(t, data["image/png"])
+	assert.Contains(t, data["text/plain"], "image.Gray 0x10 (could not encode: ")
+	assert.Empty(t, metadata)
+}
+
+// TestRenderImage_downscaled tests that images larger than MaxImagePixels
+// are downscaled to fit it, keeping their aspect ratio, with a note
+func TestRenderImage_downscaled(t *testing.T) {
+	defer func(n int) { MaxImagePixels = n }(MaxImagePixels)
+	MaxImagePixels = 100 * 50
+
+	img := image.NewGray(image.Rect(0, 0, 400, 200))
+	for x := 200; x < 400; x++ {
+		for y := 0; y < 200; y++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	data, metadata := render(img)
+
+	assert.Equal(t, "image.Gray 400x200 (downscaled to 100x50)", data["text/plain"])
+	assert.Equal(t, map[string]interface{}{"width": 100, "height": 50}, metadata["image/png"])
+	decoded := decodePNG(t, data)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), decoded.Bounds())
+	left, _, _, _ := decoded.At(10, 25).RGBA()
+	right, _, _, _ := decoded.At(90, 25).RGBA()
+	assert.Equal(t, []uint32{0, 0xffff}, []uint32{left, right})
+
+	MaxImagePixels = 0
+	data, _ = render(img)
+	assert.Equal(t, "image.Gray 400x200", data["text/plain"])
+	assert.Equal(t, image.Rect(0, 0, 400, 200), decodePNG(t, data).Bounds())
+}
+
+// TestImageMetadata tests that the sizes of images are read from their
+// headers into the metadata of the output
+func TestImageMetadata(t *testing.T) {
+	bundle := MIMEBundle{
+		"image/png":  EncodeData("image/png", pngData(t, 64, 48)),
+		"text/plain": "<image>",
+	}
+	msgs := published(t, func() { DisplayData(bundle) })
+
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, map[string]interface{}{
+			"image/png": map[string]interface{}{"width": float64(64), "height": float64(48)},
+		}, msgs[0].Content.Metadata)
+	}
+}
+
+// TestImageMetadata_override tests that explicit sizes win over those of the
+// image
+func TestImageMetadata_override(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	msgs := published(t, func() {
+		DisplayImage(img)
+		DisplayImage(img, ImageSize(320, 240))
+		DisplayImage(img, ImageSize(32, 0))
+	})
+
+	if assert.Len(t, msgs, 3) {
+		assert.Equal(t, map[string]interface{}{"width": float64(64), "height": float64(48)}, msgs[0].Content.Metadata["image/png"])
+		assert.Equal(t, map[string]interface{}{"width": float64(320), "height": float64(240)}, msgs[1].Content.Metadata["image/png"])
+		assert.Equal(t, map[string]interface{}{"width": float64(32)}, msgs[2].Content.Metadata["image/png"])
+	}
+}
+
+// TestImageMetadata_update tests that updates of displays carry the sizes of
+// their images too
+func TestImageMetadata_update(t *testing.T) {
+	msgs := published(t, func() {
+		d := NewDisplay("no image yet")
+		d.UpdateData(MIMEBundle{"image/png": EncodeData("image/png", pngData(t, 10, 20))})
+		d.Update(image.NewGray(image.Rect(0, 0, 30, 40)))
+	})
+
+	if assert.Len(t, msgs, 3) {
+		assert.Empty(t, msgs[0].Content.Metadata)
+		assert.Equal(t, "update_display_data", msgs[1].MsgType)
+		assert.Equal(t, map[string]interface{}{"width": float64(10), "height": float64(20)}, msgs[1].Content.Metadata["image/png"])
+		assert.Equal(t, map[string]interface{}{"width": float64(30), "height": float64(40)}, msgs[2].Content.Metadata["image/png"])
+	}
+}
+
+// TestImageMetadata_updateOptions tests that the options of a display apply to
+// its updates as well
+func TestImageMetadata_updateOptions(t *testing.T) {
+	msgs := published(t, func() {
+		d := NewDisplay(image.NewGray(image.Rect(0, 0, 64, 48)), ImageSize(320, 0))
+		d.Update(image.NewGray(image.Rect(0, 0, 30, 40)))
+		d.UpdateData(MIMEBundle{"image/png": EncodeData("image/png", pngData(t, 10, 20))})
+	})
+
+	if assert.Len(t, msgs, 3) {
+		for _, msg := range msgs {
+			assert.Equal(t, map[string]interface{}{"width": float64(320)}, msg.Content.Metadata["image/png"])
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package gophernotes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// InputDirEnv names the environment variable through which the kernel tells
+// cell code the directory where it leaves the replies to its input requests.
+const InputDirEnv = "GOPHERNOTES_INPUT_DIR"
+
+// inputPollInterval is how often an input request checks whether the kernel
+// replied.
+const inputPollInterval = 20 * time.Millisecond
+
+// ErrStdinNotAllowed is the error of Input and ReadPassword when the frontend
+// does not take input for the execute request, as when allow_stdin is false,
+// or when the code is not running under the kernel.
+var ErrStdinNotAllowed = errors.New("the frontend does not take input for this request")
+
+// inputs counts the input requests of the cell.
+var inputs int64
+
+// Input asks the frontend for a line of input, with prompt, and returns it.
+// When earlier cells run again before the current one, Input returns what was
+// typed for the same prompt, without asking again.
+func Input(prompt string) (string, error) {
+	return readInput(prompt, false)
+}
+
+// ReadPassword asks the frontend for a line of input, with prompt, as Input
+// does, having it hidden as it is typed. The kernel does not publish, record
+// or log what is typed: it is up to cell code to keep it out of its output.
+func ReadPassword(prompt string) (string, error) {
+	return readInput(prompt, true)
+}
+
+// inputReply is the reply of the kernel to an input request.
+type inputReply struct {
+	Value   string `json:"value"`
+	Error   string `json:"error"`
+	NoStdin bool   `json:"no_stdin"`
+}
+
+// readInput has the kernel ask the frontend for input, with prompt, hidden if
+// password is set, and waits for the reply. Requests are written to the
+// display file even while earlier cells run again, for the kernel to answer
+// them with the input of their first run.
+func readInput(prompt string, password bool) (string, error) {
+	dir := os.Getenv(InputDirEnv)
+	if dir == "" || !connected() {
+		return "", ErrStdinNotAllowed
+	}
+	name := fmt.Sprintf("%d-%d", os.Getpid(), atomic.AddInt64(&inputs, 1))
+	line, err := json.Marshal(message{
+		MsgType: "input_request",
+		Content: map[string]interface{}{
+			"name":     name,
+			"prompt":   prompt,
+			"password": password,
+			"replay":   replaying(),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	out.Lock()
+	if out.f == nil {
+		out.Unlock()
+		return "", ErrStdinNotAllowed
+	}
+	_, err = out.f.Write(append(line, '\n'))
+	out.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	// The kernel renames the file of the reply into place once written.
+	path := filepath.Join(dir, "input-"+name)
+	for {
+		b, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			time.Sleep(inputPollInterval)
+			continue
+		}
+		os.Remove(path)
+		if err != nil {
+			return "", err
+		}
+		var reply inputReply
+		if err := json.Unmarshal(b, &reply); err != nil {
+			return "", err
+		}
+		switch {
+		case reply.NoStdin:
+			return "", ErrStdinNotAllowed
+		case reply.Error != "":
+			return "", errors.New(reply.Error)
+		}
+		return reply.Value, nil
+	}
+}
@@ -0,0 +1,54 @@
+package gophernotes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReadPassword tests that password input is asked of the kernel, even
+// while earlier cells run again, and read from the reply it leaves, and that
+// it fails without a kernel to ask
+func TestReadPassword(t *testing.T) {
+	_, err := ReadPassword("Token:")
+	assert.Equal(t, ErrStdinNotAllowed, err)
+
+	dir, err := ioutil.TempDir("", "gophernotes_input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv(InputDirEnv, dir)
+	defer os.Unsetenv(InputDirEnv)
+
+	reply := func(n int64, content string) string {
+		path := filepath.Join(dir, fmt.Sprintf("input-%d-%d", os.Getpid(), n))
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			ioutil.WriteFile(path, []byte(content), 0600)
+		}()
+		return path
+	}
+
+	var value string
+	path := reply(inputs+1, `{"value":"s3cret"}`)
+	out.replaying = true
+	msgs := published(t, func() { value, err = ReadPassword("Token:") })
+	out.replaying = false
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", value)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "input_request", msgs[0].MsgType)
+	}
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "the reply is removed once read")
+
+	reply(inputs+1, `{"no_stdin":true}`)
+	published(t, func() { _, err = ReadPassword("Token:") })
+	assert.Equal(t, ErrStdinNotAllowed, err)
+}
@@ -0,0 +1,123 @@
+package gophernotes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// MaxMediaBytes caps the size of the audio and video published inline, which
+// travels base64 encoded in the messages and ends up in the saved notebook.
+var MaxMediaBytes = 10 << 20
+
+// Audio publishes data, audio of type mimeType such as "audio/wav" or
+// "audio/mpeg", with an HTML5 player. If mimeType is empty, it is detected from
+// the data.
+func Audio(data []byte, mimeType string) {
+	media("audio", data, mimeType)
+}
+
+// AudioFile publishes the audio file at path, like Audio.
+func AudioFile(path string) {
+	mediaFile("audio", path)
+}
+
+// AudioSamples publishes samples, ranging from -1 to 1 and taken rate times a
+// second, as mono 16-bit WAV audio.
+func AudioSamples(samples []float64, rate int) {
+	media("audio", encodeWAV(samples, rate), "audio/wav")
+}
+
+// Video publishes data, video of type mimeType such as "video/mp4", with an
+// HTML5 player. If mimeType is empty, it is detected from the data.
+func Video(data []byte, mimeType string) {
+	media("video", data, mimeType)
+}
+
+// VideoFile publishes the video file at path, like Video.
+func VideoFile(path string) {
+	mediaFile("video", path)
+}
+
+func mediaFile(kind, path string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		errorf("could not display %s: %s", kind, err)
+		return
+	}
+	media(kind, data, fileType(path, data))
+}
+
+func media(kind string, data []byte, mimeType string) {
+	bundle, err := mediaBundle(kind, data, mimeType)
+	if err != nil {
+		errorf("could not display %s: %s", kind, err)
+		return
+	}
+	DisplayData(bundle)
+}
+
+// mediaBundle builds the bundle holding data, of the given kind, "audio" or
+// "video", and MIME type.
+func mediaBundle(kind string, data []byte, mimeType string) (MIMEBundle, error) {
+	if MaxMediaBytes > 0 && len(data) > MaxMediaBytes {
+		return nil, fmt.Errorf("%d bytes is more than MaxMediaBytes (%d); write it to a file next to the notebook and play it with gophernotes.HTML(`<%s controls src=\"file\"></%s>`) instead",
+			len(data), MaxMediaBytes, kind, kind)
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+		if i := strings.Index(mimeType, ";"); i >= 0 {
+			mimeType = mimeType[:i]
+		}
+	}
+	if !strings.HasPrefix(mimeType, kind+"/") {
+		return nil, fmt.Errorf("%s is not a %s type", mimeType, kind)
+	}
+
+	encoded := EncodeData(mimeType, data)
+	return MIMEBundle{
+		mimeType: encoded,
+		"text/html": fmt.Sprintf(`<%s controls><source src="data:%s;base64,%s" type="%s"></%s>`,
+			kind, html.EscapeString(mimeType), encoded, html.EscapeString(mimeType), kind),
+		"text/plain": fmt.Sprintf("<%s, %d bytes>", mimeType, len(data)),
+	}, nil
+}
+
+// encodeWAV encodes samples, ranging from -1 to 1, as mono 16-bit PCM WAV
+// audio of the given sample rate.
+func encodeWAV(samples []float64, rate int) []byte {
+	const (
+		channels      = 1
+		bitsPerSample = 16
+		blockAlign    = channels * bitsPerSample / 8
+	)
+	dataSize := len(samples) * blockAlign
+
+	var buf bytes.Buffer
+	w := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+	buf.WriteString("RIFF")
+	w(uint32(36 + dataSize))
+	buf.WriteString("WAVE")
+
+	buf.WriteString("fmt ")
+	w(uint32(16)) // chunk size
+	w(uint16(1))  // PCM
+	w(uint16(channels))
+	w(uint32(rate))
+	w(uint32(rate * blockAlign)) // bytes per second
+	w(uint16(blockAlign))
+	w(uint16(bitsPerSample))
+
+	buf.WriteString("data")
+	w(uint32(dataSize))
+	for _, s := range samples {
+		s = math.Max(-1, math.Min(1, s))
+		w(int16(math.Floor(s*math.MaxInt16 + 0.5)))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,69 @@
+package gophernotes
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAudioSamples tests that samples are published as WAV audio with an HTML
+// player
+func TestAudioSamples(t *testing.T) {
+	data := onlyData(t, published(t, func() { AudioSamples([]float64{0, 1, -1, 2}, 8000) }))
+
+	assert.Equal(t, "<audio/wav, 52 bytes>", data["text/plain"])
+	wav, err := base64.StdEncoding.DecodeString(data["audio/wav"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "RIFF", string(wav[0:4]))
+	assert.Equal(t, "WAVEfmt ", string(wav[8:16]))
+	assert.Equal(t, uint32(8000), binary.LittleEndian.Uint32(wav[24:28]))
+	assert.Equal(t, "data", string(wav[36:40]))
+	samples := make([]int16, 4)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(wav[44+2*i:]))
+	}
+	assert.Equal(t, []int16{0, 32767, -32767, 32767}, samples)
+
+	html := data["text/html"].(string)
+	assert.True(t, strings.HasPrefix(html, `<audio controls><source src="data:audio/wav;base64,UklGR`), html)
+	assert.True(t, strings.HasSuffix(html, `" type="audio/wav"></audio>`), html)
+}
+
+// TestMediaBundle tests that the type of media is checked, or detected when
+// not given
+func TestMediaBundle(t *testing.T) {
+	data := encodeWAV([]float64{0}, 44100)
+
+	bundle, err := mediaBundle("audio", data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, bundle.Has("audio/wave"))
+
+	bundle, err = mediaBundle("video", []byte("\x00\x00\x00\x18ftypmp42"), "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, bundle["text/html"], "<video controls>")
+
+	_, err = mediaBundle("video", data, "audio/wav")
+	assert.EqualError(t, err, "audio/wav is not a video type")
+}
+
+// TestMediaBundle_tooLarge tests that large media are refused with advice on
+// what to do instead
+func TestMediaBundle_tooLarge(t *testing.T) {
+	defer func(n int) { MaxMediaBytes = n }(MaxMediaBytes)
+	MaxMediaBytes = 10
+
+	_, err := mediaBundle("audio", make([]byte, 11), "audio/wav")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "11 bytes is more than MaxMediaBytes (10)")
+		assert.Contains(t, err.Error(), "write it to a file")
+	}
+}
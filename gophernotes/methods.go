@@ -0,0 +1,70 @@
+package gophernotes
+
+// The methods through which types provide their own representations; see the
+// package documentation.
+type (
+	bundler interface {
+		MIMEBundle() map[string]interface{}
+	}
+	htmler interface {
+		HTML() string
+	}
+	svger interface {
+		SVG() string
+	}
+	pnger interface {
+		PNG() []byte
+	}
+	markdowner interface {
+		Markdown() string
+	}
+	latexer interface {
+		Latex() string
+	}
+)
+
+// renderMethods adds the representations provided by the methods v implements.
+func renderMethods(v interface{}, data MIMEBundle, metadata map[string]interface{}) bool {
+	found := false
+	add := func(mimeType string, rep interface{}) {
+		if !data.Has(mimeType) {
+			data[mimeType] = rep
+		}
+		found = true
+	}
+
+	if b, ok := v.(bundler); ok {
+		callMethod(v, "MIMEBundle", func() {
+			for mimeType, rep := range b.MIMEBundle() {
+				add(mimeType, rep)
+			}
+		})
+	}
+	if h, ok := v.(htmler); ok {
+		callMethod(v, "HTML", func() { add("text/html", h.HTML()) })
+	}
+	if s, ok := v.(svger); ok {
+		callMethod(v, "SVG", func() { add("image/svg+xml", s.SVG()) })
+	}
+	if p, ok := v.(pnger); ok {
+		callMethod(v, "PNG", func() { add("image/png", EncodeData("image/png", p.PNG())) })
+	}
+	if m, ok := v.(markdowner); ok {
+		callMethod(v, "Markdown", func() { add("text/markdown", m.Markdown()) })
+	}
+	if l, ok := v.(latexer); ok {
+		callMethod(v, "Latex", func() { add("text/latex", l.Latex()) })
+	}
+	return found
+}
+
+// callMethod calls f, which calls the named method of v, recovering from any
+// panic with a warning.
+func callMethod(v interface{}, name string, f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			warnf("%T.%s panicked: %v", v, name, r)
+		}
+	}()
+	f()
+}
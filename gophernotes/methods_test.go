@@ -0,0 +1,84 @@
+package gophernotes
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bundleValue struct{}
+
+func (bundleValue) MIMEBundle() map[string]interface{} {
+	return map[string]interface{}{"application/x-test": 1, "text/html": "<p>bundle</p>"}
+}
+
+type svgValue struct{}
+
+func (svgValue) SVG() string { return "<svg></svg>" }
+
+type pngValue struct{}
+
+func (pngValue) PNG() []byte { return []byte("\x89PNG") }
+
+type markdownValue struct{}
+
+func (markdownValue) Markdown() string { return "*md*" }
+
+type latexValue struct{}
+
+func (latexValue) Latex() string { return "$x$" }
+
+type mixedValue struct {
+	bundleValue
+	htmlValue
+	latexValue
+}
+
+type panickingValue struct{}
+
+func (panickingValue) HTML() string     { panic("boom") }
+func (panickingValue) Markdown() string { return "*still here*" }
+
+// TestRender_methods tests each of the methods probed by the renderer pipeline
+func TestRender_methods(t *testing.T) {
+	cases := []struct {
+		v    interface{}
+		want MIMEBundle
+	}{
+		{bundleValue{}, MIMEBundle{"application/x-test": 1, "text/html": "<p>bundle</p>", "text/plain": "{}"}},
+		{htmlValue{}, MIMEBundle{"text/html": "<i>rich</i>", "text/plain": "plain"}},
+		{svgValue{}, MIMEBundle{"image/svg+xml": "<svg></svg>", "text/plain": "{}"}},
+		{pngValue{}, MIMEBundle{"image/png": base64.StdEncoding.EncodeToString([]byte("\x89PNG")), "text/plain": "{}"}},
+		{markdownValue{}, MIMEBundle{"text/markdown": "*md*", "text/plain": "{}"}},
+		{latexValue{}, MIMEBundle{"text/latex": "$x$", "text/plain": "{}"}},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, Render(c.v), "%T", c.v)
+	}
+}
+
+// TestRender_methods_merged tests that representations from several methods
+// are merged, with those probed first winning
+func TestRender_methods_merged(t *testing.T) {
+	data := Render(mixedValue{})
+	assert.Equal(t, "<p>bundle</p>", data["text/html"])
+	assert.Equal(t, 1, data["application/x-test"])
+	assert.Equal(t, "$x$", data["text/latex"])
+	assert.Equal(t, "plain", data["text/plain"])
+}
+
+// TestRender_methods_panic tests that a panicking method is skipped with a
+// warning on the stderr stream
+func TestRender_methods_panic(t *testing.T) {
+	var data MIMEBundle
+	msgs := published(t, func() { data = Render(panickingValue{}) })
+
+	assert.Equal(t, MIMEBundle{"text/markdown": "*still here*", "text/plain": "{}"}, data)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "stream", msgs[0].MsgType)
+		assert.Equal(t, "stderr", msgs[0].Content.Name)
+		assert.Contains(t, msgs[0].Content.Text, "panickingValue.HTML panicked: boom")
+	}
+}
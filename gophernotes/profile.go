@@ -0,0 +1,119 @@
+package gophernotes
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// cpuProfileRate is the rate, in samples per second, at which the CPU profile
+// of the runtime samples the stacks of the process.
+const cpuProfileRate = 100
+
+// heapProfileRate is the average number of bytes allocated between the
+// allocations ProfileHeap samples, instead of the runtime's default of 512 KiB,
+// for cells allocating little to show up.
+const heapProfileRate = 4096
+
+// replaying reports whether the code of earlier cells is running again before
+// the current cell's, in which case the profiles they take are not wanted.
+func replaying() bool {
+	connected()
+	out.Lock()
+	defer out.Unlock()
+	return out.replaying
+}
+
+// ProfileCPU runs f under the CPU profiler, writing the profile to path, as the
+// %%pprof cpu magic does with the code of the cell, and reports how long f ran
+// and what profiling took besides. When earlier cells run again before the
+// current one, f runs without being profiled.
+func ProfileCPU(path string, f func()) {
+	if replaying() {
+		f()
+		return
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		errorf("could not create the CPU profile: %s", err)
+		return
+	}
+	defer file.Close()
+
+	start := time.Now()
+	if err := pprof.StartCPUProfile(file); err != nil {
+		errorf("could not start the CPU profile: %s", err)
+		return
+	}
+	begin := time.Now()
+	f()
+	d := time.Since(begin)
+	pprof.StopCPUProfile()
+	overhead := time.Since(start) - d
+
+	reportProfile(fmt.Sprintf("The cell ran for %s under the CPU profiler, sampling at %d Hz; starting and stopping the profiler took %s.\n",
+		formatNs(float64(d.Nanoseconds())), cpuProfileRate, formatNs(float64(overhead.Nanoseconds()))))
+}
+
+// ProfileHeap runs f between two snapshots of the heap profile, written to
+// basePath before f runs and to path after, as the %%pprof heap magic does with
+// the code of the cell, so that their difference shows what f allocated. An
+// allocation is sampled every heapProfileRate bytes meanwhile. When earlier
+// cells run again before the current one, f runs without being profiled.
+func ProfileHeap(path, basePath string, f func()) {
+	if replaying() {
+		f()
+		return
+	}
+	// The runtime scales the samples written with the rate in effect then,
+	// so both snapshots are written at the same rate.
+	defer func(rate int) { runtime.MemProfileRate = rate }(runtime.MemProfileRate)
+	runtime.MemProfileRate = heapProfileRate
+
+	start := time.Now()
+	if err := writeHeapProfile(basePath); err != nil {
+		errorf("could not write the heap profile: %s", err)
+		return
+	}
+	begin := time.Now()
+	f()
+	d := time.Since(begin)
+	if err := writeHeapProfile(path); err != nil {
+		errorf("could not write the heap profile: %s", err)
+		return
+	}
+	overhead := time.Since(start) - d
+
+	reportProfile(fmt.Sprintf("The cell ran for %s, an allocation sampled every %s; the snapshots of the heap took %s.\n",
+		formatNs(float64(d.Nanoseconds())), formatBytes(heapProfileRate), formatNs(float64(overhead.Nanoseconds()))))
+}
+
+// writeHeapProfile writes the heap profile to path, after a garbage collection
+// for it to be up to date.
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := pprof.Lookup("heap").WriteTo(file, 0); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// reportProfile prints text on the stdout stream of the cell.
+func reportProfile(text string) {
+	if !connected() {
+		fmt.Fprint(os.Stdout, text)
+		return
+	}
+	publish("stream", map[string]interface{}{
+		"name": "stdout",
+		"data": text,
+		"text": text,
+	})
+}
@@ -0,0 +1,58 @@
+package gophernotes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestProfileCPU tests that ProfileCPU runs the code, writes its CPU profile
+// and reports how long it ran
+func TestProfileCPU(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophernotes_profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cpu.pprof")
+
+	ran := false
+	msgs := published(t, func() { ProfileCPU(path, func() { ran = true }) })
+	assert.True(t, ran)
+	if assert.Len(t, msgs, 1) {
+		assert.Contains(t, msgs[0].Content.Text, "under the CPU profiler, sampling at 100 Hz")
+	}
+	info, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.NotZero(t, info.Size())
+	}
+}
+
+// TestProfileHeap tests that ProfileHeap writes the heap profile before and
+// after running the code, and reports how long it ran
+func TestProfileHeap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophernotes_profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path, base := filepath.Join(dir, "heap.pprof"), filepath.Join(dir, "heap.pprof.base")
+
+	var kept [][]byte
+	msgs := published(t, func() {
+		ProfileHeap(path, base, func() { kept = append(kept, make([]byte, 1<<20)) })
+	})
+	assert.Len(t, kept, 1)
+	if assert.Len(t, msgs, 1) {
+		assert.Contains(t, msgs[0].Content.Text, "an allocation sampled every 4 KiB")
+	}
+	for _, p := range []string{path, base} {
+		info, err := os.Stat(p)
+		if assert.NoError(t, err) {
+			assert.NotZero(t, info.Size())
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package gophernotes
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressInterval is the shortest time between two updates of a progress bar.
+var progressInterval = time.Second / 10
+
+// progressWidth is the width, in characters, of the text rendering of a
+// progress bar.
+const progressWidth = 20
+
+// Progress is a progress bar, updated in place in the notebook. Its methods are
+// safe to call from any goroutine the cell starts.
+type Progress struct {
+	mu      sync.Mutex
+	display *DisplayHandle
+	total   int
+	n       int
+	desc    string
+	last    time.Time
+	closed  bool
+}
+
+// ProgressBar displays a progress bar reaching completion once total steps
+// are done. If total is zero or less, the number of steps done is shown
+// instead. However often the bar is advanced, it is redrawn at most ten times a
+// second; Close draws its final state:
+//
+//	bar := gophernotes.ProgressBar(len(items))
+//	defer bar.Close()
+//	for _, item := range items {
+//		process(item)
+//		bar.Add(1)
+//	}
+//
+// Outside of the kernel, only the final state is printed.
+func ProgressBar(total int) *Progress {
+	p := &Progress{
+		display: &DisplayHandle{id: newDisplayID()},
+		total:   total,
+		last:    time.Now(),
+	}
+	if connected() {
+		p.display.publish("display_data", p.bundle(), map[string]interface{}{})
+	}
+	return p
+}
+
+// Add advances the bar by n steps.
+func (p *Progress) Add(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.n += n
+	p.update(false)
+}
+
+// SetDescription sets the text shown before the bar.
+func (p *Progress) SetDescription(s string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.desc = s
+	p.update(false)
+}
+
+// Close draws the final state of the bar, which later calls leave as is.
+func (p *Progress) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	if !connected() {
+		fmt.Println(p.text())
+		return
+	}
+	p.update(true)
+}
+
+// update redraws the bar, unless it was redrawn less than progressInterval ago
+// and force is false. p.mu must be held.
+func (p *Progress) update(force bool) {
+	now := time.Now()
+	if !force && now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = now
+	p.display.publish("update_display_data", p.bundle(), map[string]interface{}{})
+}
+
+func (p *Progress) bundle() MIMEBundle {
+	return MIMEBundle{
+		"text/html":  p.html(),
+		"text/plain": p.text(),
+	}
+}
+
+// fraction returns how much of the work is done, between 0 and 1, or -1 if the
+// total is unknown.
+func (p *Progress) fraction() float64 {
+	if p.total <= 0 {
+		return -1
+	}
+	f := float64(p.n) / float64(p.total)
+	if f < 0 {
+		return 0
+	}
+	if f > 1 {
+		return 1
+	}
+	return f
+}
+
+// status describes the steps done, such as "42% (420/1000)".
+func (p *Progress) status() string {
+	f := p.fraction()
+	if f < 0 {
+		return fmt.Sprintf("%d", p.n)
+	}
+	return fmt.Sprintf("%d%% (%d/%d)", int(f*100), p.n, p.total)
+}
+
+func (p *Progress) html() string {
+	var bar string
+	if f := p.fraction(); f >= 0 {
+		bar = fmt.Sprintf(`<progress value="%d" max="%d" style="width: 40ex"></progress> `, p.n, p.total)
+	} else {
+		bar = `<progress style="width: 40ex"></progress> `
+	}
+	desc := ""
+	if p.desc != "" {
+		desc = html.EscapeString(p.desc) + " "
+	}
+	return "<div>" + desc + bar + p.status() + "</div>"
+}
+
+func (p *Progress) text() string {
+	var parts []string
+	if p.desc != "" {
+		parts = append(parts, p.desc)
+	}
+	if f := p.fraction(); f >= 0 {
+		done := int(f * progressWidth)
+		parts = append(parts, "["+strings.Repeat("#", done)+strings.Repeat("-", progressWidth-done)+"]")
+	}
+	return strings.Join(append(parts, p.status()), " ")
+}
@@ -0,0 +1,57 @@
+package gophernotes
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestProgressBar tests that a progress bar advanced a million times is only
+// redrawn a bounded number of times, ending with its final state
+func TestProgressBar(t *testing.T) {
+	const n = 1000000
+
+	var elapsed time.Duration
+	msgs := published(t, func() {
+		start := time.Now()
+		bar := ProgressBar(n)
+		bar.SetDescription("counting")
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < n/4; i++ {
+					bar.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		bar.Close()
+		bar.Add(1)
+		elapsed = time.Since(start)
+	})
+
+	// The initial display, an update per interval and the final one.
+	limit := 2 + int(elapsed/progressInterval)
+	assert.True(t, len(msgs) <= limit, "%d messages in %s, want at most %d", len(msgs), elapsed, limit)
+	if assert.True(t, len(msgs) >= 2) {
+		assert.Equal(t, "display_data", msgs[0].MsgType)
+		last := msgs[len(msgs)-1]
+		assert.Equal(t, "update_display_data", last.MsgType)
+		assert.Equal(t, "counting [####################] 100% (1000000/1000000)", last.Content.Data["text/plain"])
+		assert.Contains(t, last.Content.Data["text/html"], `<progress value="1000000" max="1000000"`)
+	}
+}
+
+// TestProgressBar_text tests the text rendering of progress bars
+func TestProgressBar_text(t *testing.T) {
+	p := &Progress{total: 4, n: 1}
+	assert.Equal(t, "[#####---------------] 25% (1/4)", p.text())
+
+	p = &Progress{n: 7, desc: "<items>"}
+	assert.Equal(t, "<items> 7", p.text())
+	assert.Equal(t, `<div>&lt;items&gt; <progress style="width: 40ex"></progress> 7</div>`, p.html())
+}
@@ -0,0 +1,54 @@
+package gophernotes
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+)
+
+// maxStackDump is the most bytes of goroutine stacks a dump holds.
+const maxStackDump = 1 << 20
+
+// watchStacks is done once the kernel is told the process of the cell, and
+// the stacks of its goroutines are dumped whenever the kernel asks.
+var watchStacks sync.Once
+
+// watchStackDumps tells the kernel the process running the cell, for it to
+// signal the process with stackDumpSignal when the cell does not stop once
+// interrupted, and publishes the stacks of all of the goroutines of the cell
+// then, for the frontend to show where it is stuck. Only a cell the kernel runs,
+// with the display file in its environment, is watched.
+func watchStackDumps() {
+	if os.Getenv(DisplayFileEnv) == "" || !connected() {
+		return
+	}
+	watchStacks.Do(func() {
+		publish("cell_process", map[string]interface{}{"pid": os.Getpid()})
+		if stackDumpSignal == nil {
+			return
+		}
+		dumps := make(chan os.Signal, 1)
+		signal.Notify(dumps, stackDumpSignal)
+		go func() {
+			for range dumps {
+				dumpStacks()
+			}
+		}()
+	})
+}
+
+// dumpStacks publishes the stacks of all of the goroutines, as runtime.Stack
+// writes them.
+func dumpStacks() {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStackDump {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	publish("stack_dump", map[string]interface{}{"stacks": string(buf)})
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package gophernotes
+
+import (
+	"os"
+	"syscall"
+)
+
+// stackDumpSignal is the signal the kernel sends for the stacks of the
+// goroutines of the cell to be dumped.
+var stackDumpSignal os.Signal = syscall.SIGUSR1
@@ -0,0 +1,7 @@
+package gophernotes
+
+import "os"
+
+// stackDumpSignal is nil, for there are no signals asking for the stacks of
+// the goroutines of the cell on Windows.
+var stackDumpSignal os.Signal
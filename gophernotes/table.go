@@ -0,0 +1,290 @@
+package gophernotes
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxTableRows is the number of rows shown when a table is displayed; the rest
+// are summed up in a final line. Zero or less shows every row.
+var MaxTableRows = 100
+
+// table is tabular data ready to be shown, along with the number of rows and
+// columns left out of it.
+type table struct {
+	header      []string
+	rows        [][]string
+	more        int
+	moreColumns int
+}
+
+// Table displays v as a table. v may be a slice or array of structs, or of
+// pointers to structs, with a column for each exported field; a [][]string,
+// whose first row holds the column names; or a []map[string]interface{},
+// with a column for each key. Struct fields tagged with
+//
+//	`display:"-"`
+//
+// are left out, and a tag with any other value names the column.
+func Table(v interface{}) {
+	t, ok := newTable(v)
+	if !ok {
+		errorf("cannot display %T as a table", v)
+		return
+	}
+	display(t.bundle(), map[string]interface{}{})
+}
+
+// renderTable adds text/html and text/plain tables for the values Table
+// accepts, unless the value already provided HTML of its own.
+func renderTable(v interface{}, data MIMEBundle, metadata map[string]interface{}) bool {
+	if data.Has("text/html") {
+		return false
+	}
+	t, ok := newTable(v)
+	if !ok {
+		return false
+	}
+	for mimeType, rep := range t.bundle() {
+		data[mimeType] = rep
+	}
+	return true
+}
+
+// newTable builds the table for v, and reports whether v is tabular. Empty
+// slices are not, as there is nothing to show but their type.
+func newTable(v interface{}) (*table, bool) {
+	switch v := v.(type) {
+	case [][]string:
+		if len(v) == 0 {
+			return nil, false
+		}
+		t := &table{header: v[0]}
+		t.addRows(len(v)-1, MaxTableRows, func(i int) []string { return v[i+1] })
+		return t, true
+	case []map[string]interface{}:
+		if len(v) == 0 {
+			return nil, false
+		}
+		return mapTable(v), true
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	elem := rv.Type().Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct || rv.Len() == 0 {
+		return nil, false
+	}
+	t := structTable(rv, elem)
+	return t, len(t.header) > 0
+}
+
+// mapTable builds a table with a column for each key found in rows, in
+// sorted order.
+func mapTable(rows []map[string]interface{}) *table {
+	seen := map[string]bool{}
+	t := &table{}
+	for _, row := range rows {
+		for k := range row {
+			if !seen[k] {
+				seen[k] = true
+				t.header = append(t.header, k)
+			}
+		}
+	}
+	sort.Strings(t.header)
+
+	t.addRows(len(rows), MaxTableRows, func(i int) []string {
+		cells := make([]string, len(t.header))
+		for j, k := range t.header {
+			if v, ok := rows[i][k]; ok {
+				cells[j] = cellText(reflect.ValueOf(v))
+			}
+		}
+		return cells
+	})
+	return t
+}
+
+// structFields returns the indexes of the exported fields of the struct type
+// elem, and their names, leaving out those tagged `display:"-"` and naming
+// those with another display tag after it.
+func structFields(elem reflect.Type) (fields []int, names []string) {
+	for i := 0; i < elem.NumField(); i++ {
+		f := elem.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		switch tag := f.Tag.Get("display"); tag {
+		case "-":
+			continue
+		case "":
+		default:
+			name = tag
+		}
+		fields = append(fields, i)
+		names = append(names, name)
+	}
+	return fields, names
+}
+
+// structTable builds a table with a column for each exported field of elem
+// from the slice or array rv.
+func structTable(rv reflect.Value, elem reflect.Type) *table {
+	t := &table{}
+	fields, names := structFields(elem)
+	t.header = names
+
+	t.addRows(rv.Len(), MaxTableRows, func(i int) []string {
+		cells := make([]string, len(fields))
+		row := rv.Index(i)
+		if row.Kind() == reflect.Ptr {
+			if row.IsNil() {
+				cells[0] = "<nil>"
+				return cells
+			}
+			row = row.Elem()
+		}
+		for j, f := range fields {
+			cells[j] = cellText(row.Field(f))
+		}
+		return cells
+	})
+	return t
+}
+
+// addRows adds the first limit of n rows, built by row, to the table. A limit
+// of zero or less adds every row.
+func (t *table) addRows(n, limit int, row func(i int) []string) {
+	shown := n
+	if limit > 0 && n > limit {
+		shown = limit
+	}
+	for i := 0; i < shown; i++ {
+		t.rows = append(t.rows, row(i))
+	}
+	t.more = n - shown
+}
+
+// cellText formats a single table cell, using the value's String method if it
+// has one.
+func cellText(v reflect.Value) string {
+	if !v.IsValid() {
+		return "<nil>"
+	}
+	if v.CanInterface() {
+		return fmt.Sprint(v.Interface())
+	}
+	return fmt.Sprint(v)
+}
+
+// trailer is the line ending a truncated table, or "" if nothing was left out.
+func (t *table) trailer() string {
+	var parts []string
+	switch {
+	case t.more == 1:
+		parts = append(parts, "1 more row")
+	case t.more > 1:
+		parts = append(parts, fmt.Sprintf("%d more rows", t.more))
+	}
+	switch {
+	case t.moreColumns == 1:
+		parts = append(parts, "1 more column")
+	case t.moreColumns > 1:
+		parts = append(parts, fmt.Sprintf("%d more columns", t.moreColumns))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "… " + strings.Join(parts, ", ")
+}
+
+// bundle returns the text/html and text/plain representations of the table.
+func (t *table) bundle() MIMEBundle {
+	return MIMEBundle{
+		"text/html":  t.html(),
+		"text/plain": t.text(),
+	}
+}
+
+func (t *table) html() string {
+	var buf bytes.Buffer
+	buf.WriteString("<table>\n<thead>\n<tr>")
+	for _, h := range t.header {
+		fmt.Fprintf(&buf, "<th>%s</th>", html.EscapeString(h))
+	}
+	buf.WriteString("</tr>\n</thead>\n<tbody>\n")
+	for _, row := range t.rows {
+		buf.WriteString("<tr>")
+		for j := range t.header {
+			cell := ""
+			if j < len(row) {
+				cell = row[j]
+			}
+			fmt.Fprintf(&buf, "<td>%s</td>", html.EscapeString(cell))
+		}
+		buf.WriteString("</tr>\n")
+	}
+	buf.WriteString("</tbody>\n</table>")
+	if trailer := t.trailer(); trailer != "" {
+		fmt.Fprintf(&buf, "\n<p>%s</p>", trailer)
+	}
+	return buf.String()
+}
+
+// text lays the table out in aligned columns.
+func (t *table) text() string {
+	widths := make([]int, len(t.header))
+	measure := func(cells []string) {
+		for j := range widths {
+			if j < len(cells) {
+				if n := utf8.RuneCountInString(cells[j]); n > widths[j] {
+					widths[j] = n
+				}
+			}
+		}
+	}
+	measure(t.header)
+	for _, row := range t.rows {
+		measure(row)
+	}
+
+	var buf bytes.Buffer
+	line := func(cells []string) {
+		var parts []string
+		for j, w := range widths {
+			cell := ""
+			if j < len(cells) {
+				cell = cells[j]
+			}
+			parts = append(parts, cell+strings.Repeat(" ", w-utf8.RuneCountInString(cell)))
+		}
+		buf.WriteString(strings.TrimRight(strings.Join(parts, "  "), " "))
+		buf.WriteByte('\n')
+	}
+	line(t.header)
+	rule := make([]string, len(widths))
+	for j, w := range widths {
+		rule[j] = strings.Repeat("-", w)
+	}
+	line(rule)
+	for _, row := range t.rows {
+		line(row)
+	}
+	if trailer := t.trailer(); trailer != "" {
+		buf.WriteString(trailer)
+		buf.WriteByte('\n')
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
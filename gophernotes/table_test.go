@@ -0,0 +1,117 @@
+package gophernotes
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type point struct{ X, Y int }
+
+func (p point) String() string { return fmt.Sprintf("(%d, %d)", p.X, p.Y) }
+
+// htmlPoints has an HTML representation of its own.
+type htmlPoints []point
+
+func (htmlPoints) HTML() string { return "<i>points</i>" }
+
+type shape struct {
+	Name   string
+	Origin point
+	Sides  int `display:"Number of sides"`
+	ID     int `display:"-"`
+	secret string
+}
+
+// TestTable_structs tests tables of structs, with tagged and unexported fields
+// left out or renamed, and nested values shown through their String method
+func TestTable_structs(t *testing.T) {
+	data := Render([]shape{
+		{Name: "square", Origin: point{1, 2}, Sides: 4, ID: 7},
+		{Name: "<tri>", Sides: 3},
+	})
+
+	assert.Equal(t, strings.Join([]string{
+		"Name    Origin  Number of sides",
+		"------  ------  ---------------",
+		"square  (1, 2)  4",
+		"<tri>   (0, 0)  3",
+	}, "\n"), data["text/plain"])
+
+	html := data["text/html"].(string)
+	assert.Contains(t, html, "<th>Name</th><th>Origin</th><th>Number of sides</th>")
+	assert.Contains(t, html, "<td>&lt;tri&gt;</td><td>(0, 0)</td><td>3</td>")
+	assert.NotContains(t, html, "ID")
+	assert.NotContains(t, html, "secret")
+}
+
+// TestTable_structPointers tests tables of pointers to structs, nil ones
+// giving rows of their own
+func TestTable_structPointers(t *testing.T) {
+	data := Render([]*point{{1, 2}, nil})
+	assert.Equal(t, "X      Y\n-----  -\n1      2\n<nil>", data["text/plain"])
+	assert.Contains(t, data["text/html"], "<tr><td>&lt;nil&gt;</td><td></td></tr>")
+}
+
+// TestTable_strings tests tables of [][]string, the first row being the header
+func TestTable_strings(t *testing.T) {
+	data := Render([][]string{
+		{"city", "population"},
+		{"Zürich", "421878"},
+		{"Bern"},
+	})
+	assert.Equal(t, strings.Join([]string{
+		"city    population",
+		"------  ----------",
+		"Zürich  421878",
+		"Bern",
+	}, "\n"), data["text/plain"])
+	assert.Contains(t, data["text/html"], "<tr><td>Bern</td><td></td></tr>")
+}
+
+// TestTable_maps tests tables of maps, with a column for each key
+func TestTable_maps(t *testing.T) {
+	data := Render([]map[string]interface{}{
+		{"b": 1, "a": "x"},
+		{"c": true},
+	})
+	assert.Equal(t, strings.Join([]string{
+		"a  b  c",
+		"-  -  ----",
+		"x  1",
+		"      true",
+	}, "\n"), data["text/plain"])
+}
+
+// TestTable_truncated tests that only MaxTableRows rows are shown, followed by
+// the number of rows left out
+func TestTable_truncated(t *testing.T) {
+	defer func(n int) { MaxTableRows = n }(MaxTableRows)
+	MaxTableRows = 2
+
+	data := Render([]point{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 5}})
+	assert.Equal(t, "X  Y\n-  -\n1  1\n2  2\n… 3 more rows", data["text/plain"])
+	html := data["text/html"].(string)
+	assert.Equal(t, 2, strings.Count(html, "<tr><td>"))
+	assert.True(t, strings.HasSuffix(html, "</table>\n<p>… 3 more rows</p>"))
+
+	data = Render([][]string{{"n"}, {"1"}, {"2"}, {"3"}})
+	assert.Equal(t, "n\n-\n1\n2\n… 1 more row", data["text/plain"])
+}
+
+// TestTable_notTabular tests that other values, and empty slices, are left to
+// the other renderers
+func TestTable_notTabular(t *testing.T) {
+	assert.Equal(t, MIMEBundle{"text/plain": "[]"}, Render([]point{}))
+	assert.Equal(t, MIMEBundle{"text/plain": "[1 2]"}, Render([]int{1, 2}))
+	assert.Equal(t, "<i>points</i>", Render(htmlPoints{{1, 2}})["text/html"])
+}
+
+// TestTable tests that Table publishes the table as display_data
+func TestTable(t *testing.T) {
+	data := onlyData(t, published(t, func() { Table([][]string{{"a"}, {"1"}}) }))
+	assert.Equal(t, "a\n-\n1", data["text/plain"])
+	assert.Contains(t, data["text/html"], "<th>a</th>")
+}
@@ -0,0 +1,18 @@
+package gophernotes
+
+import "os"
+
+// TempDirEnv names the environment variable through which the kernel tells
+// cell code the temporary directory of the kernel.
+const TempDirEnv = "GOPHERNOTES_TEMP_DIR"
+
+// TempDir returns the temporary directory of the kernel, for cell code to
+// write scratch files in: the kernel removes it, and whatever it holds, as it
+// shuts down. Outside of the kernel, TempDir returns the default directory for
+// temporary files.
+func TempDir() string {
+	if dir := os.Getenv(TempDirEnv); dir != "" {
+		return dir
+	}
+	return os.TempDir()
+}
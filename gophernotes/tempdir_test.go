@@ -0,0 +1,17 @@
+package gophernotes
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTempDir tests that TempDir is the directory the kernel tells, and the
+// default directory for temporary files without a kernel
+func TestTempDir(t *testing.T) {
+	assert.Equal(t, os.TempDir(), TempDir())
+	os.Setenv(TempDirEnv, "/tmp/gophernotes-kernel")
+	defer os.Unsetenv(TempDirEnv)
+	assert.Equal(t, "/tmp/gophernotes-kernel", TempDir())
+}
@@ -0,0 +1,245 @@
+package gophernotes
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"unicode/utf8"
+)
+
+// MaxResultSize is the number of bytes of the default text representation of
+// results and displayed values, beyond which it is cut. Values whose text
+// would be larger are printed only up to it, rather than in full before being
+// cut, with the elements of slices, arrays and maps left out summed up, and
+// the keys of maps in no particular order. Zero or less shows values in full.
+var MaxResultSize = 64 << 10
+
+var (
+	stringerType  = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+	formatterType = reflect.TypeOf((*fmt.Formatter)(nil)).Elem()
+)
+
+// plainText returns the default text representation of v, as fmt.Sprint
+// formats it, but for values larger than MaxResultSize, which are formatted
+// by a textPrinter up to it.
+func plainText(v interface{}) string {
+	if !oversized(v) {
+		return fmt.Sprint(v)
+	}
+	p := &textPrinter{max: MaxResultSize}
+	p.value(reflect.ValueOf(v), 0)
+	return p.buf.String()
+}
+
+// oversized reports whether the text of v is sure to be larger than
+// MaxResultSize, by counting the bytes it takes at least. The count stops once
+// beyond MaxResultSize, so that it takes no longer for huge values.
+func oversized(v interface{}) bool {
+	if MaxResultSize <= 0 {
+		return false
+	}
+	var n int
+	textSize(reflect.ValueOf(v), 0, &n)
+	return n > MaxResultSize
+}
+
+// hasTextMethods reports whether fmt formats values of type t with their
+// methods rather than by their contents.
+func hasTextMethods(t reflect.Type) bool {
+	return t.Implements(stringerType) || t.Implements(errorType) || t.Implements(formatterType)
+}
+
+// textSize adds to n the bytes the text of v takes at least, as fmt formats it
+// at depth, until n is beyond MaxResultSize. Values formatted by their methods
+// count for a byte.
+func textSize(v reflect.Value, depth int, n *int) {
+	if *n > MaxResultSize {
+		return
+	}
+	if !v.IsValid() || v.CanInterface() && hasTextMethods(v.Type()) {
+		*n++
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		*n += v.Len()
+	case reflect.Slice, reflect.Array:
+		*n += 2
+		for i := 0; i < v.Len() && *n <= MaxResultSize; i++ {
+			*n++
+			textSize(v.Index(i), depth+1, n)
+		}
+	case reflect.Map:
+		*n += 5
+		iter := v.MapRange()
+		for *n <= MaxResultSize && iter.Next() {
+			*n += 2
+			textSize(iter.Key(), depth+1, n)
+			textSize(iter.Value(), depth+1, n)
+		}
+	case reflect.Struct:
+		*n += 2
+		for i := 0; i < v.NumField() && *n <= MaxResultSize; i++ {
+			*n++
+			textSize(v.Field(i), depth+1, n)
+		}
+	case reflect.Ptr:
+		if depth == 0 && !v.IsNil() && isComposite(v.Elem().Kind()) {
+			*n++
+			textSize(v.Elem(), depth+1, n)
+			return
+		}
+		*n += 3
+	case reflect.Interface:
+		if v.IsNil() {
+			*n += 5
+			return
+		}
+		textSize(v.Elem(), depth, n)
+	default:
+		*n++
+	}
+}
+
+// isComposite reports whether fmt prints pointers to values of kind k as &
+// followed by the value, when they are not nested in another value.
+func isComposite(k reflect.Kind) bool {
+	return k == reflect.Array || k == reflect.Slice || k == reflect.Struct || k == reflect.Map
+}
+
+// A textPrinter formats values as fmt.Sprint does, but stops once the text
+// reaches max bytes, cutting strings with … and summing up the elements of
+// slices, arrays and maps left out. The separators and closing brackets of the
+// values cut are still printed.
+type textPrinter struct {
+	buf bytes.Buffer
+	max int
+}
+
+// full reports whether the text has reached the size of the printer.
+func (p *textPrinter) full() bool {
+	return p.buf.Len() >= p.max
+}
+
+// write adds s to the text, cut with … at the size of the printer. Nothing is
+// added once the text is full.
+func (p *textPrinter) write(s string) {
+	room := p.max - p.buf.Len()
+	if room <= 0 {
+		return
+	}
+	if len(s) > room {
+		for room > 0 && !utf8.RuneStart(s[room]) {
+			room--
+		}
+		p.buf.WriteString(s[:room])
+		p.buf.WriteString("…")
+		return
+	}
+	p.buf.WriteString(s)
+}
+
+// more sums up the n elements of a value left out.
+func (p *textPrinter) more(n int) {
+	if n == 1 {
+		p.buf.WriteString("… 1 more")
+		return
+	}
+	fmt.Fprintf(&p.buf, "… %d more", n)
+}
+
+// value prints v, at depth in the value printed, unless the text is full.
+func (p *textPrinter) value(v reflect.Value, depth int) {
+	if p.full() {
+		return
+	}
+	if !v.IsValid() {
+		p.write("<nil>")
+		return
+	}
+	if v.CanInterface() && hasTextMethods(v.Type()) {
+		p.write(fmt.Sprint(v.Interface()))
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		p.write(v.String())
+	case reflect.Bool:
+		p.write(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		p.write(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		p.write(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		p.write(strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()))
+	case reflect.Complex64:
+		p.write(fmt.Sprint(complex64(v.Complex())))
+	case reflect.Complex128:
+		p.write(fmt.Sprint(v.Complex()))
+	case reflect.Slice, reflect.Array:
+		p.write("[")
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				p.buf.WriteString(" ")
+			}
+			if p.full() {
+				p.more(v.Len() - i)
+				break
+			}
+			p.value(v.Index(i), depth+1)
+		}
+		p.buf.WriteString("]")
+	case reflect.Map:
+		p.write("map[")
+		iter := v.MapRange()
+		for i := 0; iter.Next(); i++ {
+			if i > 0 {
+				p.buf.WriteString(" ")
+			}
+			if p.full() {
+				p.more(v.Len() - i)
+				break
+			}
+			p.value(iter.Key(), depth+1)
+			p.write(":")
+			p.value(iter.Value(), depth+1)
+		}
+		p.buf.WriteString("]")
+	case reflect.Struct:
+		p.write("{")
+		for i := 0; i < v.NumField(); i++ {
+			if i > 0 {
+				p.buf.WriteString(" ")
+			}
+			if p.full() {
+				p.more(v.NumField() - i)
+				break
+			}
+			p.value(v.Field(i), depth+1)
+		}
+		p.buf.WriteString("}")
+	case reflect.Interface:
+		if v.IsNil() {
+			p.write("<nil>")
+			return
+		}
+		p.value(v.Elem(), depth)
+	case reflect.Ptr:
+		if depth == 0 && !v.IsNil() && isComposite(v.Elem().Kind()) {
+			p.write("&")
+			p.value(v.Elem(), depth+1)
+			return
+		}
+		fallthrough
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		if v.IsNil() {
+			p.write("<nil>")
+			return
+		}
+		p.write("0x" + strconv.FormatUint(uint64(v.Pointer()), 16))
+	default:
+		p.write(v.String())
+	}
+}
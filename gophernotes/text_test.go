@@ -0,0 +1,94 @@
+package gophernotes
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTextPrinter tests that values are printed as fmt.Sprint prints them
+// while they fit
+func TestTextPrinter(t *testing.T) {
+	type point struct {
+		X, y int
+		Name string
+	}
+	x := 3
+	for _, v := range []interface{}{
+		nil,
+		42,
+		-1.5,
+		float32(0.1),
+		complex(1, -2),
+		"héllo",
+		true,
+		[]int{1, 2, 3},
+		[]int(nil),
+		[2]string{"a", "b"},
+		map[string]int{"a": 1},
+		point{1, 2, "p"},
+		&point{1, 2, "p"},
+		[]*int{nil},
+		[]interface{}{1, "a", nil, []byte("hi")},
+		errors.New("failed"),
+		[]error{errors.New("a"), nil},
+		struct{ p *point }{nil},
+		[]uint8{1, 2},
+		&x,
+	} {
+		p := &textPrinter{max: 1 << 20}
+		p.value(reflect.ValueOf(v), 0)
+		assert.Equal(t, fmt.Sprint(v), p.buf.String(), "%#v", v)
+	}
+}
+
+// TestPlainText_oversized tests that values larger than MaxResultSize are
+// printed up to it, with what is left out summed up
+func TestPlainText_oversized(t *testing.T) {
+	saved := MaxResultSize
+	defer func() { MaxResultSize = saved }()
+	MaxResultSize = 20
+
+	assert.Equal(t, "[0 1 2 3 4 5 6 7 8 9 … 90 more]", plainText(make100()))
+	assert.Equal(t, "[aaaaaaaaaaaaaaaaaaa… … 1 more]", plainText([]string{strings.Repeat("a", 30), "b"}))
+	assert.Equal(t, "[1 2 3]", plainText([]int{1, 2, 3}))
+	assert.Equal(t, "{[0 1 2 3 4 5 6 7 8 … 91 more] … 1 more}", plainText(struct{ A, B []int }{make100(), nil}))
+
+	MaxResultSize = 0
+	assert.Equal(t, fmt.Sprint(make100()), plainText(make100()))
+}
+
+// make100 returns the numbers from 0 to 99.
+func make100() []int {
+	s := make([]int, 100)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
+
+// TestRender_hugeSlice tests that rendering a huge slice allocates little more
+// than MaxResultSize, rather than the whole text of it
+func TestRender_hugeSlice(t *testing.T) {
+	huge := make([]int, 10000000)
+	for i := range huge {
+		huge[i] = i
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	text := Render(huge)["text/plain"].(string)
+	runtime.ReadMemStats(&after)
+
+	assert.True(t, len(text) < MaxResultSize+100, "len(text) = %d", len(text))
+	assert.True(t, strings.HasSuffix(text, " more]"), text[len(text)-40:])
+	allocated := after.TotalAlloc - before.TotalAlloc
+	// Some slack for the race detector, which allocates as the printer runs.
+	assert.True(t, allocated < uint64(16*MaxResultSize), "allocated %d bytes", allocated)
+}
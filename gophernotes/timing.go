@@ -0,0 +1,190 @@
+package gophernotes
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// timeItRound is how long the rounds of TimeIt last, once the number of runs
+// per round is adjusted.
+var timeItRound = 200 * time.Millisecond
+
+// timeItRounds is the number of rounds TimeIt runs by default.
+const timeItRounds = 5
+
+// maxTimeItRuns caps the number of runs per round.
+const maxTimeItRuns = 1000000000
+
+// timer is the timer StartTimer starts.
+var timer struct {
+	sync.Mutex
+	start   time.Time
+	cpu     time.Duration
+	mallocs uint64
+	bytes   uint64
+}
+
+// StartTimer starts timing the code of the cell until StopTimer. The %time
+// magic wraps the code it times between the two.
+func StartTimer() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	timer.Lock()
+	defer timer.Unlock()
+	timer.cpu, _ = cpuTime()
+	timer.mallocs, timer.bytes = m.Mallocs, m.TotalAlloc
+	timer.start = time.Now()
+}
+
+// StopTimer reports the wall time elapsed since StartTimer, the CPU time the
+// process used meanwhile, where the system tells, and the memory allocated,
+// which counts the allocations of every goroutine. The report is printed to
+// stdout, and kept in the metadata of the execute_reply, under "timing".
+func StopTimer() {
+	timer.Lock()
+	wall := time.Since(timer.start)
+	cpu, cpuOK := cpuTime()
+	cpu -= timer.cpu
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	mallocs, bytes := m.Mallocs-timer.mallocs, m.TotalAlloc-timer.bytes
+	timer.Unlock()
+
+	timing := map[string]interface{}{
+		"wall_ns": wall.Nanoseconds(),
+		"allocs":  mallocs,
+		"bytes":   bytes,
+	}
+	text := "wall " + formatNs(float64(wall.Nanoseconds()))
+	if cpuOK {
+		timing["cpu_ns"] = cpu.Nanoseconds()
+		text += ", cpu " + formatNs(float64(cpu.Nanoseconds()))
+	}
+	text += fmt.Sprintf(", %d allocs, %s\n", mallocs, formatBytes(float64(bytes)))
+	reportTiming(text, timing)
+}
+
+// TimeIt runs f repeatedly and reports how long a run takes, as the %%timeit
+// magic does with the code of the cell. The runs are made in rounds, of which
+// the fastest is reported, in nanoseconds per run, along with the allocations
+// per run of every round. Unless runs is given, the number of runs in a round
+// grows, as with testing.B, until a round lasts about a fifth of a second; five
+// rounds are run unless rounds is given.
+//
+// The side effects of f are repeated as many times as it runs.
+func TimeIt(f func(), runs, rounds int) {
+	if rounds <= 0 {
+		rounds = timeItRounds
+	}
+	if runs <= 0 {
+		runs = timeItRuns(f)
+	}
+
+	best := math.Inf(1)
+	var mallocs, bytes uint64
+	for r := 0; r < rounds; r++ {
+		d, m, b := timeRuns(f, runs)
+		best = math.Min(best, float64(d.Nanoseconds())/float64(runs))
+		mallocs += m
+		bytes += b
+	}
+	total := float64(runs * rounds)
+	allocs, bytesPerOp := float64(mallocs)/total, float64(bytes)/total
+
+	text := fmt.Sprintf("%s/op, %.4g allocs/op, %s/op (best of %d rounds of %d runs)\n", formatNs(best), allocs, formatBytes(bytesPerOp), rounds, runs)
+	if runs*rounds > 1 {
+		text += fmt.Sprintf("The cell ran %d times, repeating its side effects.\n", runs*rounds)
+	}
+	reportTiming(text, map[string]interface{}{
+		"ns_per_op":     best,
+		"allocs_per_op": allocs,
+		"bytes_per_op":  bytesPerOp,
+		"runs":          runs,
+		"rounds":        rounds,
+	})
+}
+
+// timeItRuns returns the number of runs of f making a round last about
+// timeItRound, predicted from shorter rounds as testing.B does.
+func timeItRuns(f func()) int {
+	n := 1
+	for {
+		d, _, _ := timeRuns(f, n)
+		if d >= timeItRound || n >= maxTimeItRuns {
+			return n
+		}
+		next := maxTimeItRuns
+		if ns := d.Nanoseconds(); ns > 0 {
+			next = int(1.2 * float64(timeItRound.Nanoseconds()) * float64(n) / float64(ns))
+		}
+		if n < maxTimeItRuns/100 && next > 100*n {
+			next = 100 * n
+		}
+		if next <= n {
+			next = n + 1
+		}
+		if next > maxTimeItRuns {
+			next = maxTimeItRuns
+		}
+		n = next
+	}
+}
+
+// timeRuns runs f n times and returns how long it took, and the number and
+// size of the allocations made meanwhile.
+func timeRuns(f func(), n int) (d time.Duration, mallocs, bytes uint64) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		f()
+	}
+	d = time.Since(start)
+	runtime.ReadMemStats(&after)
+	return d, after.Mallocs - before.Mallocs, after.TotalAlloc - before.TotalAlloc
+}
+
+// reportTiming prints text on the stdout stream of the cell, and keeps timing
+// in the metadata of the execute_reply.
+func reportTiming(text string, timing map[string]interface{}) {
+	if !connected() {
+		fmt.Fprint(os.Stdout, text)
+		return
+	}
+	publish("stream", map[string]interface{}{
+		"name": "stdout",
+		"data": text,
+		"text": text,
+	})
+	publish("reply_metadata", map[string]interface{}{"timing": timing})
+}
+
+// formatNs formats a number of nanoseconds with the unit keeping it short.
+func formatNs(ns float64) string {
+	switch {
+	case ns < 1e3:
+		return fmt.Sprintf("%.4g ns", ns)
+	case ns < 1e6:
+		return fmt.Sprintf("%.4g µs", ns/1e3)
+	case ns < 1e9:
+		return fmt.Sprintf("%.4g ms", ns/1e6)
+	}
+	return fmt.Sprintf("%.4g s", ns/1e9)
+}
+
+// formatBytes formats a number of bytes with the unit keeping it short.
+func formatBytes(b float64) string {
+	switch {
+	case b < 1<<10:
+		return fmt.Sprintf("%.4g B", b)
+	case b < 1<<20:
+		return fmt.Sprintf("%.3g KiB", b/(1<<10))
+	case b < 1<<30:
+		return fmt.Sprintf("%.3g MiB", b/(1<<20))
+	}
+	return fmt.Sprintf("%.3g GiB", b/(1<<30))
+}
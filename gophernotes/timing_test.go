@@ -0,0 +1,58 @@
+package gophernotes
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTimeIt tests that TimeIt runs the code as many times as asked, and
+// reports the timing along with a warning about the repeated side effects
+func TestTimeIt(t *testing.T) {
+	n := 0
+	msgs := published(t, func() { TimeIt(func() { n++ }, 3, 2) })
+	assert.Equal(t, 6, n)
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, "stream", msgs[0].MsgType)
+		assert.Contains(t, msgs[0].Content.Text, "/op (best of 2 rounds of 3 runs)\n")
+		assert.Contains(t, msgs[0].Content.Text, "The cell ran 6 times")
+		assert.Equal(t, "reply_metadata", msgs[1].MsgType)
+	}
+}
+
+// TestTimeIt_runs tests that the number of runs per round grows until a round
+// lasts long enough
+func TestTimeIt_runs(t *testing.T) {
+	defer func(d time.Duration) { timeItRound = d }(timeItRound)
+	timeItRound = 5 * time.Millisecond
+
+	runs := timeItRuns(func() { time.Sleep(100 * time.Microsecond) })
+	assert.True(t, runs > 1, "%d runs", runs)
+	assert.True(t, runs < 1000, "%d runs", runs)
+}
+
+// TestStopTimer tests that the time between StartTimer and StopTimer is
+// reported
+func TestStopTimer(t *testing.T) {
+	msgs := published(t, func() {
+		StartTimer()
+		time.Sleep(time.Millisecond)
+		StopTimer()
+	})
+	if assert.Len(t, msgs, 2) {
+		assert.True(t, strings.HasPrefix(msgs[0].Content.Text, "wall "), msgs[0].Content.Text)
+		assert.Contains(t, msgs[0].Content.Text, " allocs, ")
+	}
+}
+
+// TestFormatNs tests that durations are shown with a unit keeping them short
+func TestFormatNs(t *testing.T) {
+	assert.Equal(t, "12 ns", formatNs(12))
+	assert.Equal(t, "1.5 µs", formatNs(1500))
+	assert.Equal(t, "2.345 ms", formatNs(2345000))
+	assert.Equal(t, "3 s", formatNs(3e9))
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+}
@@ -0,0 +1,79 @@
+package gophernotes
+
+import (
+	"context"
+	"os"
+	"runtime/trace"
+	"sync"
+)
+
+// CellRegion is the name of the trace region Trace wraps the code of the cell
+// in, which the kernel looks for to sum the trace up.
+const CellRegion = "gophernotes cell"
+
+// tracing is the state of the trace Trace takes.
+var tracing struct {
+	sync.Mutex
+	on bool
+
+	// region is the region TraceRegion started last, if it is not over.
+	region *trace.Region
+}
+
+// Trace runs f under the execution tracer, writing the trace to path, as the
+// %%trace magic does with the code of the cell, f in a region of its own named
+// CellRegion. When earlier cells run again before the current one, f runs
+// without being traced.
+func Trace(path string, f func()) {
+	if replaying() {
+		f()
+		return
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		errorf("could not create the trace: %s", err)
+		return
+	}
+	defer file.Close()
+	if err := trace.Start(file); err != nil {
+		errorf("could not start the trace: %s", err)
+		return
+	}
+	tracing.Lock()
+	tracing.on = true
+	tracing.Unlock()
+
+	trace.WithRegion(context.Background(), CellRegion, func() {
+		defer endTraceRegion()
+		f()
+	})
+
+	tracing.Lock()
+	tracing.on = false
+	tracing.Unlock()
+	trace.Stop()
+}
+
+// TraceRegion ends the region the previous call started, if any, and starts
+// one named name, which lasts until the next call or the end of the code
+// traced. The %%trace -regions magic makes a region of every statement of the
+// cell so. It must be called by the goroutine running the code traced, and
+// does nothing when no trace is being taken.
+func TraceRegion(name string) {
+	endTraceRegion()
+	tracing.Lock()
+	defer tracing.Unlock()
+	if tracing.on {
+		tracing.region = trace.StartRegion(context.Background(), name)
+	}
+}
+
+// endTraceRegion ends the region TraceRegion started, if any.
+func endTraceRegion() {
+	tracing.Lock()
+	defer tracing.Unlock()
+	if tracing.region != nil {
+		tracing.region.End()
+		tracing.region = nil
+	}
+}
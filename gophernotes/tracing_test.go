@@ -0,0 +1,38 @@
+package gophernotes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTrace tests that Trace runs the code under the execution tracer, in
+// which TraceRegion starts regions, writing the trace to the file given
+func TestTrace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophernotes_trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trace")
+
+	ran := false
+	Trace(path, func() {
+		TraceRegion("line 1")
+		ran = true
+		TraceRegion("line 2")
+	})
+	assert.True(t, ran)
+	assert.Nil(t, tracing.region)
+	info, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.NotZero(t, info.Size())
+	}
+
+	// Outside of a trace, regions are not started.
+	TraceRegion("line 1")
+	assert.Nil(t, tracing.region)
+}
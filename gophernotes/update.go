@@ -0,0 +1,172 @@
+package gophernotes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// SessionEnv names the environment variable through which the kernel passes
+// its session id to cell code.
+const SessionEnv = "GOPHERNOTES_SESSION"
+
+// DisplayHandle is an output that can be replaced in place in the notebook.
+// Updates coming faster than the kernel publishes them, 20 per second unless
+// %config display_update_rate says otherwise, replace one another, the latest
+// one always showing once the cell is done or the handle closed.
+type DisplayHandle struct {
+	id   string
+	opts []ImageOption
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewDisplay publishes the representations of initial, like Display, and returns
+// a handle through which they can later be replaced, by the current cell or by
+// later ones. The images of the output and of its updates are laid out
+// according to opts.
+func NewDisplay(initial interface{}, opts ...ImageOption) *DisplayHandle {
+	d := &DisplayHandle{id: newDisplayID(), opts: opts}
+	data, metadata := render(initial)
+	d.publish("display_data", data, metadata)
+	return d
+}
+
+// ID returns the display_id identifying the output in the notebook.
+func (d *DisplayHandle) ID() string {
+	return d.id
+}
+
+// Update replaces the output with the representations of v, from the cell
+// that created the handle, the goroutines it starts, or later cells, each
+// update a child of the request of the cell that created the handle. Cell code
+// runs in a process that exits once the cell is done, and a cell is not done
+// while the goroutines it started are running and a handle it created or
+// updated is open: their updates are all published, however long after the
+// code of the cell returned. Closing the handle, or interrupting the cell,
+// lets it finish without them.
+func (d *DisplayHandle) Update(v interface{}) {
+	data, metadata := render(v)
+	d.publish("update_display_data", data, metadata)
+}
+
+// UpdateData replaces the output with bundle as is, but for a text/plain
+// representation added as by DisplayData.
+func (d *DisplayHandle) UpdateData(bundle MIMEBundle) {
+	d.publish("update_display_data", bundle, map[string]interface{}{})
+}
+
+// Close shows the latest update of the output right away, rather than once
+// the cell is done. Later updates are ignored.
+func (d *DisplayHandle) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.closed = true
+	cellHandles.Lock()
+	delete(cellHandles.open, d)
+	cellHandles.Unlock()
+	if connected() {
+		publish("flush_display", map[string]interface{}{"transient": map[string]interface{}{"display_id": d.id}})
+	}
+}
+
+func (d *DisplayHandle) publish(msgType string, data MIMEBundle, metadata map[string]interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	imageMetadata(data, metadata, d.opts)
+	if !connected() {
+		if msgType == "display_data" {
+			display(data, metadata)
+		}
+		return
+	}
+	content := newDisplayData(data, metadata)
+	content.Transient = map[string]interface{}{"display_id": d.id}
+	publish(msgType, content)
+
+	cellHandles.Lock()
+	if cellHandles.started && !replaying() {
+		cellHandles.open[d] = true
+	}
+	cellHandles.Unlock()
+}
+
+// endCellPoll is how often EndCell checks whether the goroutines of the cell
+// are done.
+const endCellPoll = 10 * time.Millisecond
+
+// cellHandles holds the display handles the current cell created or updated
+// and has not closed, and how many goroutines were running as the code of the
+// cell started; see EndCell.
+var cellHandles struct {
+	sync.Mutex
+	started    bool
+	goroutines int
+	open       map[*DisplayHandle]bool
+}
+
+// beginCellHandles starts tracking the handles of the cell, as its code starts.
+func beginCellHandles() {
+	cellHandles.Lock()
+	defer cellHandles.Unlock()
+	cellHandles.started = true
+	cellHandles.goroutines = runtime.NumGoroutine()
+	cellHandles.open = map[*DisplayHandle]bool{}
+}
+
+// EndCell marks the end of the current cell's code, after which the process of
+// the cell exits. While a display handle the cell created or updated is open,
+// it waits for the goroutines the cell started to return first, for their
+// updates of the handle to be published. The kernel inserts the call itself;
+// cell code has no reason to make it.
+func EndCell() {
+	for {
+		cellHandles.Lock()
+		wait := cellHandles.started && len(cellHandles.open) > 0 && runtime.NumGoroutine() > cellHandles.goroutines
+		cellHandles.Unlock()
+		if !wait {
+			return
+		}
+		time.Sleep(endCellPoll)
+	}
+}
+
+// displayIDs numbers the display handles of the session.
+var displayIDs struct {
+	sync.Mutex
+	prefix string
+	n      int
+}
+
+// newDisplayID returns the display_id of a new handle. Handles are numbered in
+// the order they are created, which replaying earlier cells preserves, so that
+// a handle keeps its id from one cell to the next.
+func newDisplayID() string {
+	displayIDs.Lock()
+	defer displayIDs.Unlock()
+
+	if displayIDs.prefix == "" {
+		displayIDs.prefix = os.Getenv(SessionEnv)
+	}
+	if displayIDs.prefix == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		displayIDs.prefix = hex.EncodeToString(b)
+	}
+
+	displayIDs.n++
+	return fmt.Sprintf("%s-%d", displayIDs.prefix, displayIDs.n)
+}
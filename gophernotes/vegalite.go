@@ -0,0 +1,243 @@
+package gophernotes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// VegaLiteMIMEType is the MIME type through which JupyterLab renders Vega-Lite
+// charts.
+const VegaLiteMIMEType = "application/vnd.vegalite.v5+json"
+
+// vegaLiteSchema is the schema added to specs that do not name one.
+const vegaLiteSchema = "https://vega.github.io/schema/vega-lite/v5.json"
+
+// vegaLiteViews are the keys of which a spec must have one: a mark, or views
+// combining other specs.
+var vegaLiteViews = []string{"mark", "layer", "concat", "hconcat", "vconcat", "spec"}
+
+// VegaLite publishes a Vega-Lite chart, which JupyterLab renders natively.
+// spec is either a *Chart, or a Vega-Lite specification given as a
+// map[string]interface{} or as encoded JSON in a string, []byte or
+// json.RawMessage. The specification must at least have "data" and "mark"
+// entries:
+//
+//	gophernotes.VegaLite(`{
+//		"data": {"values": [{"a": 1, "b": 2}, {"a": 2, "b": 4}]},
+//		"mark": "line",
+//		"encoding": {
+//			"x": {"field": "a", "type": "quantitative"},
+//			"y": {"field": "b", "type": "quantitative"}
+//		}
+//	}`)
+//
+// Frontends without a Vega-Lite renderer show a short description instead.
+func VegaLite(spec interface{}) {
+	bundle, err := vegaLiteBundle(spec)
+	if err != nil {
+		errorf("could not display Vega-Lite chart: %s", err)
+		return
+	}
+	DisplayData(bundle)
+}
+
+// vegaLiteBundle validates spec and builds the bundle published by VegaLite.
+func vegaLiteBundle(spec interface{}) (MIMEBundle, error) {
+	var m map[string]interface{}
+	switch s := spec.(type) {
+	case *Chart:
+		var err error
+		if m, err = s.Spec(); err != nil {
+			return nil, err
+		}
+	case map[string]interface{}:
+		// Copy the spec, so that adding the schema leaves it unchanged.
+		m = make(map[string]interface{}, len(s)+1)
+		for k, v := range s {
+			m[k] = v
+		}
+	case string:
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			return nil, err
+		}
+	case []byte:
+		if err := json.Unmarshal(s, &m); err != nil {
+			return nil, err
+		}
+	case json.RawMessage:
+		if err := json.Unmarshal(s, &m); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported spec type %T", spec)
+	}
+
+	if _, ok := m["data"]; !ok {
+		return nil, errors.New(`spec has no "data"`)
+	}
+	view := ""
+	for _, k := range vegaLiteViews {
+		if _, ok := m[k]; ok {
+			view = k
+			break
+		}
+	}
+	if view == "" {
+		return nil, errors.New(`spec has no "mark"`)
+	}
+	if _, ok := m["$schema"]; !ok {
+		m["$schema"] = vegaLiteSchema
+	}
+
+	desc := view
+	switch mark := m["mark"].(type) {
+	case string:
+		desc = mark
+	case map[string]interface{}:
+		if t, ok := mark["type"].(string); ok {
+			desc = t
+		}
+	}
+	return MIMEBundle{
+		VegaLiteMIMEType: m,
+		"text/plain":     fmt.Sprintf("<Vega-Lite %s chart>", desc),
+	}, nil
+}
+
+// Chart builds simple Vega-Lite charts, of a single mark, from rows of data:
+//
+//	gophernotes.NewChart(measurements).Mark("point").X("Time", "").Y("Value", "")
+//
+// A Chart shows up as the chart when a cell evaluates to it, or when passed to
+// Display or VegaLite.
+type Chart struct {
+	rows     []map[string]interface{}
+	mark     string
+	encoding map[string]interface{}
+	err      error
+}
+
+// NewChart returns a chart of rows, either a slice of structs, whose exported
+// fields are chosen like the columns of a Table, or a []map[string]interface{}.
+func NewChart(rows interface{}) *Chart {
+	c := &Chart{encoding: map[string]interface{}{}}
+	if m, ok := rows.([]map[string]interface{}); ok {
+		c.rows = m
+		return c
+	}
+
+	rv := reflect.ValueOf(rows)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		c.err = fmt.Errorf("cannot chart %T", rows)
+		return c
+	}
+	elem := rv.Type().Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		c.err = fmt.Errorf("cannot chart %T", rows)
+		return c
+	}
+
+	fields, names := structFields(elem)
+	for i := 0; i < rv.Len(); i++ {
+		row := rv.Index(i)
+		if row.Kind() == reflect.Ptr {
+			if row.IsNil() {
+				continue
+			}
+			row = row.Elem()
+		}
+		m := make(map[string]interface{}, len(fields))
+		for j, f := range fields {
+			m[names[j]] = row.Field(f).Interface()
+		}
+		c.rows = append(c.rows, m)
+	}
+	return c
+}
+
+// Mark sets the mark of the chart, such as "point", "line", "bar" or "area".
+func (c *Chart) Mark(mark string) *Chart {
+	c.mark = mark
+	return c
+}
+
+// X encodes field on the x axis. fieldType is a Vega-Lite type, such as
+// "quantitative", "temporal", "ordinal" or "nominal"; if empty, it is inferred
+// from the values of the field.
+func (c *Chart) X(field, fieldType string) *Chart {
+	return c.Encode("x", field, fieldType)
+}
+
+// Y encodes field on the y axis, like X.
+func (c *Chart) Y(field, fieldType string) *Chart {
+	return c.Encode("y", field, fieldType)
+}
+
+// Encode encodes field on the given channel, such as "color" or "size", like
+// X.
+func (c *Chart) Encode(channel, field, fieldType string) *Chart {
+	if fieldType == "" {
+		fieldType = c.inferType(field)
+	}
+	c.encoding[channel] = map[string]interface{}{
+		"field": field,
+		"type":  fieldType,
+	}
+	return c
+}
+
+// inferType returns the Vega-Lite type of field, from its first value.
+func (c *Chart) inferType(field string) string {
+	for _, row := range c.rows {
+		v, ok := row[field]
+		if !ok || v == nil {
+			continue
+		}
+		if _, ok := v.(time.Time); ok {
+			return "temporal"
+		}
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return "quantitative"
+		}
+		return "nominal"
+	}
+	return "nominal"
+}
+
+// Spec returns the Vega-Lite specification of the chart.
+func (c *Chart) Spec() (map[string]interface{}, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.mark == "" {
+		return nil, errors.New("chart has no mark")
+	}
+	rows := c.rows
+	if rows == nil {
+		rows = []map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"$schema":  vegaLiteSchema,
+		"data":     map[string]interface{}{"values": rows},
+		"mark":     c.mark,
+		"encoding": c.encoding,
+	}, nil
+}
+
+// MIMEBundle returns the representations of the chart.
+func (c *Chart) MIMEBundle() map[string]interface{} {
+	bundle, err := vegaLiteBundle(c)
+	if err != nil {
+		return MIMEBundle{"text/plain": fmt.Sprintf("<invalid Vega-Lite chart: %s>", err)}
+	}
+	return bundle
+}
@@ -0,0 +1,87 @@
+package gophernotes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestVegaLite tests that specs given as JSON are published with the
+// Vega-Lite MIME type and a text fallback
+func TestVegaLite(t *testing.T) {
+	data := onlyData(t, published(t, func() {
+		VegaLite(`{"data": {"values": [{"a": 1}]}, "mark": {"type": "bar"}}`)
+	}))
+
+	assert.Equal(t, "<Vega-Lite bar chart>", data["text/plain"])
+	assert.Equal(t, map[string]interface{}{
+		"$schema": vegaLiteSchema,
+		"data":    map[string]interface{}{"values": []interface{}{map[string]interface{}{"a": float64(1)}}},
+		"mark":    map[string]interface{}{"type": "bar"},
+	}, data[VegaLiteMIMEType])
+}
+
+// TestVegaLite_invalid tests that specs missing data or a mark are refused
+func TestVegaLite_invalid(t *testing.T) {
+	spec := map[string]interface{}{"mark": "point"}
+	_, err := vegaLiteBundle(spec)
+	assert.EqualError(t, err, `spec has no "data"`)
+	assert.NotContains(t, spec, "$schema")
+
+	_, err = vegaLiteBundle(map[string]interface{}{"data": map[string]interface{}{}})
+	assert.EqualError(t, err, `spec has no "mark"`)
+
+	_, err = vegaLiteBundle(`{"data": `)
+	assert.Error(t, err)
+
+	_, err = vegaLiteBundle(42)
+	assert.EqualError(t, err, "unsupported spec type int")
+
+	_, err = vegaLiteBundle(map[string]interface{}{"data": map[string]interface{}{}, "layer": []interface{}{}})
+	assert.NoError(t, err)
+}
+
+type measurement struct {
+	Time   time.Time
+	Value  float64
+	Sensor string
+	Note   string `display:"-"`
+}
+
+// TestChart tests the specs built by charts, with field types inferred from
+// the data
+func TestChart(t *testing.T) {
+	rows := []measurement{{Time: time.Unix(0, 0), Value: 1.5, Sensor: "a", Note: "x"}}
+	spec, err := NewChart(rows).Mark("point").X("Time", "").Y("Value", "").Encode("color", "Sensor", "").Spec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"$schema": vegaLiteSchema,
+		"data": map[string]interface{}{"values": []map[string]interface{}{
+			{"Time": time.Unix(0, 0), "Value": 1.5, "Sensor": "a"},
+		}},
+		"mark": "point",
+		"encoding": map[string]interface{}{
+			"x":     map[string]interface{}{"field": "Time", "type": "temporal"},
+			"y":     map[string]interface{}{"field": "Value", "type": "quantitative"},
+			"color": map[string]interface{}{"field": "Sensor", "type": "nominal"},
+		},
+	}, spec)
+
+	data := Render(NewChart(rows).Mark("line").X("Time", "ordinal"))
+	assert.Equal(t, "<Vega-Lite line chart>", data["text/plain"])
+	assert.True(t, data.Has(VegaLiteMIMEType))
+}
+
+// TestChart_invalid tests that charts of other values, or without a mark,
+// report why they cannot be shown
+func TestChart_invalid(t *testing.T) {
+	_, err := NewChart([]int{1}).Mark("bar").Spec()
+	assert.EqualError(t, err, "cannot chart []int")
+
+	assert.Equal(t, MIMEBundle{"text/plain": "<invalid Vega-Lite chart: chart has no mark>"},
+		Render(NewChart([]map[string]interface{}{{"a": 1}})))
+}
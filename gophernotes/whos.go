@@ -0,0 +1,55 @@
+package gophernotes
+
+import (
+	"fmt"
+	"image"
+	"reflect"
+	"unicode/utf8"
+)
+
+// maxSummary is the number of characters the summary of a value is cut to.
+const maxSummary = 40
+
+// Whos displays a table of the variables named, with their types and values,
+// as the %whos magic does with the variables of the session. The values are
+// summed up: by their length for slices, maps, strings, arrays and channels,
+// by their size for images, and by their representation, cut short, otherwise.
+// The table is cut at MaxTableRows.
+func Whos(names, types []string, values ...interface{}) {
+	t := &table{header: []string{"Name", "Type", "Summary"}}
+	t.addRows(len(names), MaxTableRows, func(i int) []string {
+		row := []string{names[i], "", ""}
+		if i < len(types) {
+			row[1] = types[i]
+		}
+		if i < len(values) {
+			row[2] = summary(values[i])
+		}
+		return row
+	})
+	display(t.bundle(), map[string]interface{}{})
+}
+
+// summary sums up v for Whos.
+func summary(v interface{}) string {
+	if img, ok := v.(image.Image); ok {
+		b := img.Bounds()
+		return fmt.Sprintf("%d×%d", b.Dx(), b.Dy())
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Chan:
+		if rv.IsNil() {
+			return "nil"
+		}
+		return fmt.Sprintf("len %d", rv.Len())
+	case reflect.String, reflect.Array:
+		return fmt.Sprintf("len %d", rv.Len())
+	}
+
+	s := fmt.Sprintf("%v", v)
+	if utf8.RuneCountInString(s) > maxSummary {
+		s = string([]rune(s)[:maxSummary-1]) + "…"
+	}
+	return s
+}
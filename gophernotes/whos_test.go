@@ -0,0 +1,46 @@
+package gophernotes
+
+import (
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWhos tests that the variables are tabled with their types and a summary
+// of their values, by length, size or representation
+func TestWhos(t *testing.T) {
+	data := onlyData(t, published(t, func() {
+		Whos(
+			[]string{"img", "m", "n", "names", "s"},
+			[]string{"*image.RGBA", "map[string]int", "int", "[]string", "string"},
+			image.NewRGBA(image.Rect(0, 0, 4, 3)), map[string]int(nil), 42, []string{"a", "b"}, strings.Repeat("x", 100),
+		)
+	}))
+
+	assert.Equal(t, strings.Join([]string{
+		"Name   Type            Summary",
+		"-----  --------------  -------",
+		"img    *image.RGBA     4×3",
+		"m      map[string]int  nil",
+		"n      int             42",
+		"names  []string        len 2",
+		"s      string          len 100",
+	}, "\n"), data["text/plain"])
+	assert.Contains(t, data["text/html"], "<td>names</td><td>[]string</td><td>len 2</td>")
+}
+
+// TestWhos_many tests that long tables are cut, with a note of what was left
+// out, and that long representations are cut short
+func TestWhos_many(t *testing.T) {
+	defer func(n int) { MaxTableRows = n }(MaxTableRows)
+	MaxTableRows = 2
+
+	data := onlyData(t, published(t, func() {
+		Whos([]string{"a", "b", "c"}, []string{"T", "T", "T"}, struct{ S string }{strings.Repeat("y", 100)}, 2, 3)
+	}))
+	text := data["text/plain"].(string)
+	assert.Contains(t, text, "{"+strings.Repeat("y", maxSummary-2)+"…")
+	assert.True(t, strings.HasSuffix(text, "… 1 more row"), text)
+}
@@ -0,0 +1,202 @@
+package widgets
+
+import "sync"
+
+// observers are the callbacks of a widget observing its value.
+type observers struct {
+	mu sync.Mutex
+	fs []func(v interface{})
+}
+
+func (o *observers) add(f func(v interface{})) {
+	o.mu.Lock()
+	o.fs = append(o.fs, f)
+	o.mu.Unlock()
+}
+
+// notify calls the observers with v, in the order they were added.
+func (o *observers) notify(v interface{}) {
+	o.mu.Lock()
+	fs := append([]func(interface{}){}, o.fs...)
+	o.mu.Unlock()
+	for _, f := range fs {
+		f(v)
+	}
+}
+
+// observeValue makes the changes of the value of m the frontend makes notify o.
+func observeValue(m *model, o *observers) {
+	m.changed = func(state map[string]interface{}) {
+		if v, ok := state["value"]; ok {
+			o.notify(v)
+		}
+	}
+}
+
+// IntSliderWidget is a slider choosing an integer in a range.
+type IntSliderWidget struct {
+	*model
+	observers observers
+}
+
+// IntSlider returns a slider choosing an integer between min and max, set to
+// value. Its value is only sent once the handle is released, rather than as it
+// moves, as each change runs the session again.
+func IntSlider(min, max, value int) *IntSliderWidget {
+	s := &IntSliderWidget{
+		model: newControl("IntSliderModel", "IntSliderView", "SliderStyleModel", map[string]interface{}{
+			"value":             value,
+			"min":               min,
+			"max":               max,
+			"step":              1,
+			"orientation":       "horizontal",
+			"readout":           true,
+			"readout_format":    "d",
+			"continuous_update": false,
+		}, map[string]interface{}{"handle_color": nil}),
+	}
+	observeValue(s.model, &s.observers)
+	return s
+}
+
+// Value returns the value of the slider.
+func (s *IntSliderWidget) Value() int {
+	return int(toFloat(s.get("value")))
+}
+
+// SetValue moves the slider to v, in the frontend too, and calls the observers
+// if the value changes.
+func (s *IntSliderWidget) SetValue(v int) {
+	if v == s.Value() {
+		return
+	}
+	s.set("value", v)
+	s.observers.notify(float64(v))
+}
+
+// Observe adds f to the functions called with the value of the slider when it
+// changes.
+func (s *IntSliderWidget) Observe(f func(v int)) {
+	s.observers.add(func(v interface{}) { f(int(toFloat(v))) })
+}
+
+// FloatSliderWidget is a slider choosing a number in a range.
+type FloatSliderWidget struct {
+	*model
+	observers observers
+}
+
+// FloatSlider returns a slider choosing a number between min and max, in a
+// hundred steps, set to value. Like that of IntSlider, its value is only sent
+// once the handle is released.
+func FloatSlider(min, max, value float64) *FloatSliderWidget {
+	s := &FloatSliderWidget{
+		model: newControl("FloatSliderModel", "FloatSliderView", "SliderStyleModel", map[string]interface{}{
+			"value":             value,
+			"min":               min,
+			"max":               max,
+			"step":              (max - min) / 100,
+			"orientation":       "horizontal",
+			"readout":           true,
+			"readout_format":    ".2f",
+			"continuous_update": false,
+		}, map[string]interface{}{"handle_color": nil}),
+	}
+	observeValue(s.model, &s.observers)
+	return s
+}
+
+// Value returns the value of the slider.
+func (s *FloatSliderWidget) Value() float64 {
+	return toFloat(s.get("value"))
+}
+
+// SetValue moves the slider to v, in the frontend too, and calls the observers
+// if the value changes.
+func (s *FloatSliderWidget) SetValue(v float64) {
+	if v == s.Value() {
+		return
+	}
+	s.set("value", v)
+	s.observers.notify(v)
+}
+
+// Observe adds f to the functions called with the value of the slider when it
+// changes.
+func (s *FloatSliderWidget) Observe(f func(v float64)) {
+	s.observers.add(func(v interface{}) { f(toFloat(v)) })
+}
+
+// TextWidget is a single line text field.
+type TextWidget struct {
+	*model
+	observers observers
+}
+
+// Text returns a text field holding value. Its value is sent when Enter is
+// pressed or the field loses focus, rather than on every key stroke.
+func Text(value string) *TextWidget {
+	t := &TextWidget{
+		model: newControl("TextModel", "TextView", "DescriptionStyleModel", map[string]interface{}{
+			"value":             value,
+			"placeholder":       "",
+			"continuous_update": false,
+		}, nil),
+	}
+	observeValue(t.model, &t.observers)
+	return t
+}
+
+// Value returns the text of the field.
+func (t *TextWidget) Value() string {
+	s, _ := t.get("value").(string)
+	return s
+}
+
+// SetValue sets the text of the field to s, in the frontend too, and calls the
+// observers if it changes.
+func (t *TextWidget) SetValue(s string) {
+	if s == t.Value() {
+		return
+	}
+	t.set("value", s)
+	t.observers.notify(s)
+}
+
+// Observe adds f to the functions called with the text of the field when it
+// changes.
+func (t *TextWidget) Observe(f func(s string)) {
+	t.observers.add(func(v interface{}) {
+		s, _ := v.(string)
+		f(s)
+	})
+}
+
+// ButtonWidget is a button.
+type ButtonWidget struct {
+	*model
+	clicks observers
+}
+
+// Button returns a button labeled description.
+func Button(description string) *ButtonWidget {
+	b := &ButtonWidget{
+		model: newControl("ButtonModel", "ButtonView", "ButtonStyleModel", map[string]interface{}{
+			"description":  description,
+			"button_style": "",
+			"icon":         "",
+			"tooltip":      "",
+		}, map[string]interface{}{"button_color": nil, "font_weight": ""}),
+	}
+	b.custom = func(content map[string]interface{}) {
+		if content["event"] == "click" {
+			b.clicks.notify(nil)
+		}
+	}
+	return b
+}
+
+// OnClick adds f to the functions called when the button is clicked.
+func (b *ButtonWidget) OnClick(f func()) {
+	b.clicks.add(func(interface{}) { f() })
+}
@@ -0,0 +1,154 @@
+package widgets
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/gopherds/gophernotes/gophernotes"
+)
+
+// outputVersion is the version of the @jupyter-widgets/output module.
+const outputVersion = "1.0.0"
+
+// OutputWidget is an area of the notebook showing the output captured from
+// cell code, such as that of the callbacks of other widgets, which has nowhere
+// else to go:
+//
+//	out := widgets.Output()
+//	b := widgets.Button("Sample")
+//	b.OnClick(func() {
+//		out.Capture(func() {
+//			fmt.Println(rand.Float64())
+//		})
+//	})
+//	gophernotes.Display(b)
+//	gophernotes.Display(out)
+//
+// The outputs are kept in the state of the widget, which the notebook shows as
+// they change.
+type OutputWidget struct {
+	*model
+
+	// clearWait is set by clear_output messages asking to wait for the
+	// next output to clear the outputs.
+	clearWait bool
+}
+
+// Output returns an empty output area.
+func Output() *OutputWidget {
+	layout := newModel("@jupyter-widgets/base", baseVersion, "LayoutModel", "@jupyter-widgets/base", "LayoutView", nil)
+	return &OutputWidget{
+		model: newModel("@jupyter-widgets/output", outputVersion, "OutputModel", "@jupyter-widgets/output", "OutputView", map[string]interface{}{
+			"_dom_classes": []string{},
+			"layout":       "IPY_MODEL_" + layout.comm.ID(),
+			"msg_id":       "",
+			"outputs":      []interface{}{},
+		}),
+	}
+}
+
+// Capture runs f with the output it publishes through the gophernotes package,
+// and the text it prints to stdout, going to the output area. The printed text
+// comes after what is displayed. While f runs, the output of every goroutine
+// is captured.
+func (o *OutputWidget) Capture(f func()) {
+	restore := gophernotes.SetSink(o.receiveOutput)
+	defer o.sync()
+	defer restore()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		f()
+		return
+	}
+	printed := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		r.Close()
+		printed <- b
+	}()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+		w.Close()
+		if b := <-printed; len(b) > 0 {
+			o.receiveOutput("stream", map[string]interface{}{"name": "stdout", "text": string(b)})
+		}
+	}()
+	f()
+}
+
+// Clear removes the outputs of the area.
+func (o *OutputWidget) Clear() {
+	o.mu.Lock()
+	o.clearWait = false
+	o.mu.Unlock()
+	o.set("outputs", []interface{}{})
+}
+
+// Outputs returns the outputs of the area, as in the outputs of notebook cells.
+func (o *OutputWidget) Outputs() []map[string]interface{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	outputs, _ := o.state["outputs"].([]interface{})
+	var list []map[string]interface{}
+	for _, out := range outputs {
+		if out, ok := out.(map[string]interface{}); ok {
+			list = append(list, out)
+		}
+	}
+	return list
+}
+
+// receiveOutput adds the output of a message to the outputs of the area, as
+// the sink of Capture.
+func (o *OutputWidget) receiveOutput(msgType string, content map[string]interface{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if msgType == "clear_output" {
+		if wait, _ := content["wait"].(bool); wait {
+			o.clearWait = true
+		} else {
+			o.state["outputs"] = []interface{}{}
+		}
+		return
+	}
+
+	outputs, _ := o.state["outputs"].([]interface{})
+	if o.clearWait {
+		outputs, o.clearWait = nil, false
+	}
+	switch msgType {
+	case "stream":
+		name, _ := content["name"].(string)
+		text, _ := content["text"].(string)
+		// Consecutive text on a stream makes a single output, as in cells.
+		if n := len(outputs); n > 0 {
+			if last, ok := outputs[n-1].(map[string]interface{}); ok && last["output_type"] == "stream" && last["name"] == name {
+				lastText, _ := last["text"].(string)
+				outputs[n-1] = map[string]interface{}{"output_type": "stream", "name": name, "text": lastText + text}
+				break
+			}
+		}
+		outputs = append(outputs, map[string]interface{}{"output_type": "stream", "name": name, "text": text})
+	case "display_data", "execute_result":
+		outputs = append(outputs, map[string]interface{}{
+			"output_type": "display_data",
+			"data":        content["data"],
+			"metadata":    content["metadata"],
+		})
+	default:
+		// Outputs in the state of the widget cannot be updated in place.
+		return
+	}
+	o.state["outputs"] = outputs
+}
+
+// sync sends the outputs of the area to the frontend.
+func (o *OutputWidget) sync() {
+	o.mu.Lock()
+	outputs := o.state["outputs"]
+	o.mu.Unlock()
+	o.set("outputs", outputs)
+}
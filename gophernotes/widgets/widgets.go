@@ -0,0 +1,235 @@
+// Package widgets shows interactive Jupyter widgets, whose values cell code can
+// observe and set:
+//
+//	s := widgets.IntSlider(0, 100, 50)
+//	s.Observe(func(v int) {
+//		fmt.Println("moved to", v)
+//	})
+//	gophernotes.Display(s)
+//
+// Widgets are the models of the controls of version 2 of the jupyter-widgets
+// protocol, that of ipywidgets 7, synced with the frontend over the comms of
+// the "jupyter.widget" target. Setting a value from Go updates the frontend;
+// changing it in the frontend updates the widget and calls its observers.
+//
+// The frontend can only reach a widget after the cell that created it has
+// finished, so the kernel runs the session again to deliver its messages, see
+// gophernotes.Comm: callbacks run one at a time, between the executions of
+// cells, and every widget holds the value the frontend last gave it. Widgets
+// created by earlier cells so hold their current value for later cells as well.
+// What callbacks display is published in reply to the message of the
+// frontend, which notebooks do not show alongside the cell, and what they print
+// is dropped, unless they capture it in an output area; see Output.
+package widgets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gopherds/gophernotes/gophernotes"
+)
+
+// StateEnv names the environment variable through which the kernel tells cell
+// code where to find the state of the widgets, as last synced with the
+// frontend.
+const StateEnv = "GOPHERNOTES_WIDGET_STATE"
+
+// Target is the name of the comm target of widget models.
+const Target = "jupyter.widget"
+
+// ProtocolVersion is the version of the jupyter-widgets protocol spoken.
+const ProtocolVersion = "2.0.0"
+
+// Versions of the model modules of the frontend.
+const (
+	controlsVersion = "1.5.0" // @jupyter-widgets/controls
+	baseVersion     = "1.2.0" // @jupyter-widgets/base
+)
+
+// viewMIMEType is the MIME type through which frontends show widgets.
+const viewMIMEType = "application/vnd.jupyter.widget-view+json"
+
+// restored is the state left by the kernel, by comm ID.
+var restored struct {
+	once  sync.Once
+	state map[string]map[string]interface{}
+}
+
+// restoredState returns the state the kernel last knew of the model of comm
+// id, or nil.
+func restoredState(id string) map[string]interface{} {
+	restored.once.Do(func() {
+		path := os.Getenv(StateEnv)
+		if path == "" {
+			return
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return
+		}
+		if err := json.Unmarshal(b, &restored.state); err != nil {
+			fmt.Fprintln(os.Stderr, "widgets: invalid widget state:", err)
+		}
+	})
+	return restored.state[id]
+}
+
+// model is the model of a widget, synced with the frontend over its comm.
+type model struct {
+	comm *gophernotes.Comm
+
+	mu    sync.Mutex
+	state map[string]interface{}
+
+	// changed is called with the state the frontend changes, and custom
+	// with the content of the custom messages it sends.
+	changed func(state map[string]interface{})
+	custom  func(content map[string]interface{})
+}
+
+// newModel opens the comm of a model with state, after the attributes common
+// to all models of module, and restores the state the kernel left for it.
+func newModel(module, version, name, viewModule, view string, state map[string]interface{}) *model {
+	full := map[string]interface{}{
+		"_model_module":         module,
+		"_model_module_version": version,
+		"_model_name":           name,
+		"_view_module":          viewModule,
+		"_view_module_version":  version,
+		"_view_name":            view,
+		"_view_count":           nil,
+	}
+	if viewModule != module {
+		full["_view_module_version"] = baseVersion
+	}
+	for k, v := range state {
+		full[k] = v
+	}
+
+	m := &model{state: full}
+	m.comm = gophernotes.OpenComm(Target, map[string]interface{}{
+		"state":        full,
+		"buffer_paths": []string{},
+	}, map[string]interface{}{"version": ProtocolVersion})
+	m.comm.OnMsg(m.receive)
+
+	// A cell that failed, and was dropped from the session, may have left
+	// the state of a model of another kind under the same ID.
+	if prev := restoredState(m.comm.ID()); prev != nil && prev["_model_name"] == name {
+		for k, v := range prev {
+			m.state[k] = v
+		}
+	}
+	return m
+}
+
+// newControl opens the model of a control, with its layout and style models.
+func newControl(name, view, styleName string, state, style map[string]interface{}) *model {
+	layout := newModel("@jupyter-widgets/base", baseVersion, "LayoutModel", "@jupyter-widgets/base", "LayoutView", nil)
+	styleState := map[string]interface{}{"description_width": ""}
+	for k, v := range style {
+		styleState[k] = v
+	}
+	st := newModel("@jupyter-widgets/controls", controlsVersion, styleName, "@jupyter-widgets/base", "StyleView", styleState)
+
+	full := map[string]interface{}{
+		"_dom_classes":        []string{},
+		"description":         "",
+		"description_tooltip": nil,
+		"disabled":            false,
+		"layout":              "IPY_MODEL_" + layout.comm.ID(),
+		"style":               "IPY_MODEL_" + st.comm.ID(),
+	}
+	for k, v := range state {
+		full[k] = v
+	}
+	return newModel("@jupyter-widgets/controls", controlsVersion, name, "@jupyter-widgets/controls", view, full)
+}
+
+// ID returns the model_id of the widget, the ID of its comm.
+func (m *model) ID() string {
+	return m.comm.ID()
+}
+
+// SetDescription sets the label shown next to the widget.
+func (m *model) SetDescription(s string) {
+	m.set("description", s)
+}
+
+// MIMEBundle shows the widget in the frontend.
+func (m *model) MIMEBundle() map[string]interface{} {
+	m.mu.Lock()
+	name, _ := m.state["_model_name"].(string)
+	name = strings.TrimSuffix(name, "Model")
+	text := name + "()"
+	if v, ok := m.state["value"]; ok {
+		text = fmt.Sprintf("%s(value=%v)", name, v)
+	} else if d, ok := m.state["description"]; ok {
+		text = fmt.Sprintf("%s(description=%q)", name, d)
+	}
+	m.mu.Unlock()
+	return map[string]interface{}{
+		viewMIMEType: map[string]interface{}{
+			"model_id":      m.comm.ID(),
+			"version_major": 2,
+			"version_minor": 0,
+		},
+		"text/plain": text,
+	}
+}
+
+// get returns the value of the attribute key of the state.
+func (m *model) get(key string) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state[key]
+}
+
+// set sets the attribute key of the state to v, and sends it to the frontend.
+func (m *model) set(key string, v interface{}) {
+	m.mu.Lock()
+	m.state[key] = v
+	m.mu.Unlock()
+	m.comm.Send(map[string]interface{}{
+		"method":       "update",
+		"state":        map[string]interface{}{key: v},
+		"buffer_paths": []string{},
+	})
+}
+
+// receive handles the messages of the frontend: updates of the state, and
+// custom messages such as the clicks of buttons.
+func (m *model) receive(data map[string]interface{}, buffers [][]byte) {
+	switch data["method"] {
+	case "update":
+		state, _ := data["state"].(map[string]interface{})
+		m.mu.Lock()
+		for k, v := range state {
+			m.state[k] = v
+		}
+		m.mu.Unlock()
+		if m.changed != nil {
+			m.changed(state)
+		}
+	case "custom":
+		content, _ := data["content"].(map[string]interface{})
+		if m.custom != nil {
+			m.custom(content)
+		}
+	}
+}
+
+// toFloat returns the number v, decoded from JSON, as a float64.
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	}
+	return 0
+}
@@ -0,0 +1,275 @@
+package widgets
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gopherds/gophernotes/gophernotes"
+	"github.com/stretchr/testify/assert"
+)
+
+// testMessage is a message read back from the display file.
+type testMessage struct {
+	MsgType  string                 `json:"msg_type"`
+	Content  map[string]interface{} `json:"content"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+var (
+	testDirOnce sync.Once
+	testDir     string
+)
+
+// connect points the display file and the comm message file at a temporary
+// directory, as the kernel would, once for all tests of the package.
+func connect(t *testing.T) {
+	testDirOnce.Do(func() {
+		dir, err := ioutil.TempDir("", "gophernotes_widgets")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testDir = dir
+		if err := ioutil.WriteFile(filepath.Join(dir, "display.jsonl"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv(gophernotes.DisplayFileEnv, filepath.Join(dir, "display.jsonl"))
+		os.Setenv(gophernotes.CommEventEnv, filepath.Join(dir, "comm_event.json"))
+		gophernotes.BeginCell()
+	})
+}
+
+// published returns the messages f publishes.
+func published(t *testing.T, f func()) []testMessage {
+	connect(t)
+	path := filepath.Join(testDir, "display.jsonl")
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Seek(before.Size(), 0); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []testMessage
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		var msg testMessage
+		if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// deliver delivers data to the comm with ID id, as the kernel does once the
+// code of the session has run again.
+func deliver(t *testing.T, id string, data map[string]interface{}) {
+	connect(t)
+	b, err := json.Marshal(map[string]interface{}{"comm_id": id, "data": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testDir, "comm_event.json")
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	gophernotes.BeginCell()
+}
+
+// TestIntSlider tests that a slider opens the comms of its model and of its
+// layout and style models, and is shown through a widget view
+func TestIntSlider(t *testing.T) {
+	var s *IntSliderWidget
+	msgs := published(t, func() { s = IntSlider(0, 100, 50) })
+	if !assert.Len(t, msgs, 3) {
+		return
+	}
+	for _, msg := range msgs {
+		assert.Equal(t, "comm_open", msg.MsgType)
+		assert.Equal(t, Target, msg.Content["target_name"])
+		assert.Equal(t, map[string]interface{}{"version": ProtocolVersion}, msg.Metadata)
+	}
+
+	assert.Equal(t, s.ID(), msgs[2].Content["comm_id"])
+	state := msgs[2].Content["data"].(map[string]interface{})["state"].(map[string]interface{})
+	assert.Equal(t, "IntSliderModel", state["_model_name"])
+	assert.Equal(t, "@jupyter-widgets/controls", state["_model_module"])
+	assert.Equal(t, 50.0, state["value"])
+	assert.Equal(t, 100.0, state["max"])
+	assert.Equal(t, "IPY_MODEL_"+msgs[0].Content["comm_id"].(string), state["layout"])
+	assert.Equal(t, "IPY_MODEL_"+msgs[1].Content["comm_id"].(string), state["style"])
+
+	layout := msgs[0].Content["data"].(map[string]interface{})["state"].(map[string]interface{})
+	assert.Equal(t, "LayoutModel", layout["_model_name"])
+	assert.Equal(t, "1.2.0", layout["_model_module_version"])
+
+	data := gophernotes.Render(s)
+	assert.Equal(t, map[string]interface{}{
+		"model_id":      s.ID(),
+		"version_major": 2,
+		"version_minor": 0,
+	}, data[viewMIMEType])
+	assert.Equal(t, "IntSlider(value=50)", data["text/plain"])
+}
+
+// TestIntSlider_setValue tests that setting the value from Go updates the
+// frontend and calls the observers, unless the value does not change
+func TestIntSlider_setValue(t *testing.T) {
+	connect(t)
+	s := IntSlider(0, 10, 5)
+	var seen []int
+	s.Observe(func(v int) { seen = append(seen, v) })
+
+	msgs := published(t, func() {
+		s.SetValue(7)
+		s.SetValue(7)
+	})
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "comm_msg", msgs[0].MsgType)
+		assert.Equal(t, s.ID(), msgs[0].Content["comm_id"])
+		assert.Equal(t, map[string]interface{}{
+			"method":       "update",
+			"state":        map[string]interface{}{"value": 7.0},
+			"buffer_paths": []interface{}{},
+		}, msgs[0].Content["data"])
+	}
+	assert.Equal(t, []int{7}, seen)
+	assert.Equal(t, 7, s.Value())
+}
+
+// TestIntSlider_update tests that updates from the frontend change the value
+// and call the observers
+func TestIntSlider_update(t *testing.T) {
+	connect(t)
+	s := IntSlider(0, 10, 5)
+	var seen []int
+	s.Observe(func(v int) { seen = append(seen, v) })
+
+	deliver(t, s.ID(), map[string]interface{}{
+		"method": "update",
+		"state":  map[string]interface{}{"value": 3},
+	})
+	assert.Equal(t, []int{3}, seen)
+	assert.Equal(t, 3, s.Value())
+
+	// Updates of other attributes leave the observers alone.
+	deliver(t, s.ID(), map[string]interface{}{
+		"method": "update",
+		"state":  map[string]interface{}{"description": "n"},
+	})
+	assert.Equal(t, []int{3}, seen)
+}
+
+// TestFloatSlider tests that float sliders report their values as numbers
+func TestFloatSlider(t *testing.T) {
+	connect(t)
+	s := FloatSlider(0, 1, 0.5)
+	var seen []float64
+	s.Observe(func(v float64) { seen = append(seen, v) })
+
+	deliver(t, s.ID(), map[string]interface{}{
+		"method": "update",
+		"state":  map[string]interface{}{"value": 0.25},
+	})
+	s.SetValue(0.75)
+	assert.Equal(t, []float64{0.25, 0.75}, seen)
+	assert.Equal(t, 0.75, s.Value())
+}
+
+// TestText tests that text fields report their text
+func TestText(t *testing.T) {
+	connect(t)
+	text := Text("hello")
+	var seen []string
+	text.Observe(func(s string) { seen = append(seen, s) })
+
+	deliver(t, text.ID(), map[string]interface{}{
+		"method": "update",
+		"state":  map[string]interface{}{"value": "world"},
+	})
+	assert.Equal(t, []string{"world"}, seen)
+	assert.Equal(t, "world", text.Value())
+	assert.Equal(t, `Text(value=world)`, gophernotes.Render(text)["text/plain"])
+}
+
+// TestButton tests that clicks in the frontend call the click handlers
+func TestButton(t *testing.T) {
+	connect(t)
+	b := Button("Run")
+	clicks := 0
+	b.OnClick(func() { clicks++ })
+
+	deliver(t, b.ID(), map[string]interface{}{
+		"method":  "custom",
+		"content": map[string]interface{}{"event": "click"},
+	})
+	assert.Equal(t, 1, clicks)
+	assert.Equal(t, `Button(description="Run")`, gophernotes.Render(b)["text/plain"])
+}
+
+// outputsState returns the outputs of the update of the state of an output
+// widget msg carries.
+func outputsState(t *testing.T, msg testMessage) interface{} {
+	assert.Equal(t, "comm_msg", msg.MsgType)
+	data := msg.Content["data"].(map[string]interface{})
+	return data["state"].(map[string]interface{})["outputs"]
+}
+
+// TestOutput tests that the output widget captures what is displayed and
+// printed, keeps it in its state, and can be cleared
+func TestOutput(t *testing.T) {
+	connect(t)
+	out := Output()
+	msgs := published(t, func() {
+		out.Capture(func() {
+			gophernotes.Display("shown")
+			fmt.Println("printed")
+			fmt.Println("twice")
+		})
+	})
+	if !assert.Len(t, msgs, 1) {
+		return
+	}
+	assert.Equal(t, out.ID(), msgs[0].Content["comm_id"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"output_type": "display_data",
+			"data":        map[string]interface{}{"text/plain": "shown"},
+			"metadata":    map[string]interface{}{},
+		},
+		map[string]interface{}{"output_type": "stream", "name": "stdout", "text": "printed\ntwice\n"},
+	}, outputsState(t, msgs[0]))
+	assert.Len(t, out.Outputs(), 2)
+
+	// Output waiting to be cleared is cleared by the next one.
+	published(t, func() {
+		out.Capture(func() {
+			gophernotes.ClearOutput(true)
+			gophernotes.Display("next")
+		})
+	})
+	if assert.Len(t, out.Outputs(), 1) {
+		assert.Equal(t, "next", out.Outputs()[0]["data"].(map[string]interface{})["text/plain"])
+	}
+
+	msgs = published(t, func() { out.Clear() })
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, []interface{}{}, outputsState(t, msgs[0]))
+	}
+	assert.Empty(t, out.Outputs())
+	assert.Equal(t, "Output()", gophernotes.Render(out)["text/plain"])
+}
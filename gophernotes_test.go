@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"testing"
 
 	repl "github.com/gopherds/gophernotes/internal/repl"
@@ -111,3 +112,88 @@ func TestRun_Const(t *testing.T) {
 		noError(t, err)
 	}
 }
+
+// TestDisplay_update tests that a display handle publishes display_data and
+// then update_display_data messages sharing its display_id, and that replaying
+// the cell later does not publish them again
+func TestDisplay_update(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	reply, published := c.execute(`d := gophernotes.NewDisplay("step 0")
+d.Update("step 1")
+d.Update("step 2")`)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Equal(t, []string{"display_data", "update_display_data", "update_display_data"}, msgTypes(published))
+
+	var id interface{}
+	for i, msg := range published {
+		content := msg.Content.(map[string]interface{})
+		assert.Equal(t, fmt.Sprintf("step %d", i), content["data"].(map[string]interface{})["text/plain"])
+
+		transient := content["transient"].(map[string]interface{})
+		if i == 0 {
+			id = transient["display_id"]
+			assert.NotEmpty(t, id)
+		}
+		assert.Equal(t, id, transient["display_id"])
+	}
+
+	// The update is a child of the request of the first cell rather than of
+	// this one; see TestDisplay_updateAfterCell.
+	_, published = c.execute(`d.Update("step 3")`)
+	assert.Empty(t, msgTypes(published))
+}
+
+// TestDisplay_updateAfterCell tests that a cell waits for its goroutines
+// updating a display after its code has returned, and that the updates of the
+// display, from those goroutines and from later cells, are children of the
+// request of the cell that created it
+func TestDisplay_updateAfterCell(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	// The goroutine updates the display once the code of the cell has
+	// returned, which the cell waits for.
+	reply, published := c.execute(`:import time
+late := gophernotes.NewDisplay("early")
+go func() {
+	time.Sleep(200 * time.Millisecond)
+	late.Update("late")
+}()`)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	if !assert.Equal(t, []string{"display_data", "update_display_data"}, msgTypes(published)) {
+		return
+	}
+	origin := reply.ParentHeader.MsgID
+	id := published[0].Content.(map[string]interface{})["transient"].(map[string]interface{})["display_id"]
+	content := published[1].Content.(map[string]interface{})
+	assert.Equal(t, "late", content["data"].(map[string]interface{})["text/plain"])
+	assert.Equal(t, id, content["transient"].(map[string]interface{})["display_id"])
+	assert.Equal(t, origin, published[1].ParentHeader.MsgID)
+
+	// Every message published from now on is looked at, whatever its parent.
+	req := c.send("execute_request", map[string]interface{}{
+		"code":             `late.Update("next")`,
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      false,
+	})
+	var updates []ComposedMsg
+	for {
+		msg := c.recv(c.iopub)
+		if msg.Header.MsgType == "update_display_data" {
+			updates = append(updates, msg)
+		}
+		if msg.ParentHeader.MsgID == req.Header.MsgID && msg.Header.MsgType == "status" && msg.Content.(map[string]interface{})["execution_state"] == "idle" {
+			break
+		}
+	}
+	if assert.Len(t, updates, 1) {
+		content := updates[0].Content.(map[string]interface{})
+		assert.Equal(t, "next", content["data"].(map[string]interface{})["text/plain"])
+		assert.Equal(t, id, content["transient"].(map[string]interface{})["display_id"])
+		assert.Equal(t, origin, updates[0].ParentHeader.MsgID)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// readyTouchInterval is how often the ready file is touched, for
+// orchestrators to tell a kernel still alive from one that left the file
+// behind.
+const readyTouchInterval = 10 * time.Second
+
+// healthState is what the health checks of the kernel tell: since when it has
+// been serving, whether a cell is running and how many have run, and whether
+// it is shutting down.
+type healthState struct {
+	sync.Mutex
+	start     time.Time
+	executing bool
+	count     int
+	stopping  bool
+
+	// readyFile is the ready file made, if any, and addr the address the
+	// healthz endpoint listens on, if it does.
+	readyFile string
+	addr      net.Addr
+}
+
+// healthReport is the JSON body of the healthz endpoint.
+type healthReport struct {
+	Status         string  `json:"status"`
+	Uptime         float64 `json:"uptime"`
+	Executing      bool    `json:"executing"`
+	ExecutionCount int     `json:"execution_count"`
+}
+
+// executeStarted records a cell starting to run.
+func (h *healthState) executeStarted() {
+	h.Lock()
+	defer h.Unlock()
+	h.executing = true
+}
+
+// executeDone records a cell done, count cells having run.
+func (h *healthState) executeDone(count int) {
+	h.Lock()
+	defer h.Unlock()
+	h.executing, h.count = false, count
+}
+
+// report returns the health of the kernel as of now, and whether it is
+// healthy: serving, and not shutting down.
+func (h *healthState) report(now time.Time) (healthReport, bool) {
+	h.Lock()
+	defer h.Unlock()
+	r := healthReport{Status: "ok", Executing: h.executing, ExecutionCount: h.count}
+	if !h.start.IsZero() {
+		r.Uptime = now.Sub(h.start).Seconds()
+	}
+	if h.stopping {
+		r.Status = "shutting down"
+	}
+	return r, !h.stopping
+}
+
+// startHealth starts the health checks the options of the kernel ask for,
+// once its sockets are bound and its session made: it makes the ready file and
+// touches it every readyTouchInterval, and serves the healthz endpoint. Both
+// only tell how the kernel is, and give no way to run anything in it.
+func (k *Kernel) startHealth() error {
+	opts := k.options()
+	h := &k.health
+	h.Lock()
+	h.start = time.Now()
+	h.Unlock()
+
+	if opts.healthzAddr != "" {
+		ln, err := net.Listen("tcp", opts.healthzAddr)
+		if err != nil {
+			return err
+		}
+		h.Lock()
+		h.addr = ln.Addr()
+		h.Unlock()
+		mux := http.NewServeMux()
+		mux.HandleFunc("/healthz", k.serveHealthz)
+		go func() {
+			if err := http.Serve(ln, mux); err != nil {
+				k.logger.Println("The healthz endpoint stopped:", err)
+			}
+		}()
+	}
+
+	if opts.readyFile != "" {
+		if err := ioutil.WriteFile(opts.readyFile, nil, 0644); err != nil {
+			return err
+		}
+		h.Lock()
+		h.readyFile = opts.readyFile
+		h.Unlock()
+		go func() {
+			for range time.Tick(readyTouchInterval) {
+				now := time.Now()
+				if err := os.Chtimes(opts.readyFile, now, now); err != nil && !os.IsNotExist(err) {
+					k.logger.Println("Could not touch the ready file:", err)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// stopHealth has the health checks tell the kernel is shutting down: the ready
+// file is removed, and the healthz endpoint answers 503.
+func (k *Kernel) stopHealth() {
+	h := &k.health
+	h.Lock()
+	defer h.Unlock()
+	h.stopping = true
+	if h.readyFile != "" {
+		os.Remove(h.readyFile)
+		h.readyFile = ""
+	}
+}
+
+// serveHealthz answers GET requests of the healthz endpoint with the report of
+// the health of the kernel, with the status 200 while it is healthy and 503
+// once it is shutting down.
+func (k *Kernel) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report, healthy := k.health.report(time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
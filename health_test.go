@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"go/importer"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHealthState tests that the health report counts the cells run, tells
+// whether one runs, and is unhealthy once the kernel shuts down
+func TestHealthState(t *testing.T) {
+	var h healthState
+	h.start = time.Now()
+	r, healthy := h.report(h.start.Add(90 * time.Second))
+	assert.True(t, healthy)
+	assert.Equal(t, healthReport{Status: "ok", Uptime: 90}, r)
+
+	h.executeStarted()
+	r, _ = h.report(h.start)
+	assert.True(t, r.Executing)
+	h.executeDone(3)
+	r, _ = h.report(h.start)
+	assert.Equal(t, healthReport{Status: "ok", ExecutionCount: 3}, r)
+
+	h.stopping = true
+	r, healthy = h.report(h.start)
+	assert.False(t, healthy)
+	assert.Equal(t, "shutting down", r.Status)
+}
+
+// TestHealthChecks tests that the ready file is made once the health checks
+// start and removed on shutdown, and that the healthz endpoint reports the
+// cells run, and 503 once the kernel shuts down
+func TestHealthChecks(t *testing.T) {
+	if _, err := importer.Default().Import("github.com/gopherds/gophernotes/gophernotes"); err != nil {
+		t.Skip("gophernotes package not installed:", err)
+	}
+	dir, err := ioutil.TempDir("", "gophernotes_health")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := defaultConfig()
+	config.readyFile = filepath.Join(dir, "ready")
+	config.healthzAddr = "127.0.0.1:0"
+	k, err := NewKernel(SocketGroup{deliver: func(ComposedMsg) {}}, log.New(ioutil.Discard, "", 0), config)
+	noError(t, err)
+	_, err = os.Stat(config.readyFile)
+	assert.True(t, os.IsNotExist(err))
+	noError(t, k.startHealth())
+	_, err = os.Stat(config.readyFile)
+	assert.NoError(t, err)
+
+	url := "http://" + k.health.addr.String() + "/healthz"
+	get := func() (int, healthReport) {
+		resp, err := http.Get(url)
+		noError(t, err)
+		defer resp.Body.Close()
+		var r healthReport
+		noError(t, json.NewDecoder(resp.Body).Decode(&r))
+		return resp.StatusCode, r
+	}
+	status, r := get()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", r.Status)
+	assert.False(t, r.Executing)
+	assert.Equal(t, 0, r.ExecutionCount)
+
+	k.HandleExecuteRequest(MsgReceipt{Msg: newExecuteRequest(":import time"), Sockets: k.sockets})
+	done := make(chan struct{})
+	go func() {
+		k.HandleExecuteRequest(MsgReceipt{Msg: newExecuteRequest("time.Sleep(3 * time.Second)"), Sockets: k.sockets})
+		close(done)
+	}()
+	executing := false
+	for deadline := time.Now().Add(3 * time.Second); !executing && time.Now().Before(deadline); {
+		_, r = get()
+		executing = r.Executing
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.True(t, executing)
+	<-done
+	_, r = get()
+	assert.False(t, r.Executing)
+	assert.Equal(t, 2, r.ExecutionCount)
+
+	resp, err := http.Post(url, "text/plain", nil)
+	noError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	k.removeSession()
+	_, err = os.Stat(config.readyFile)
+	assert.True(t, os.IsNotExist(err))
+	status, r = get()
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "shutting down", r.Status)
+}
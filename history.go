@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// historyEntry is the code of a cell run, with its execution count and the
+// results it showed. Session numbers the past sessions of the history file
+// from 1, the oldest, and is 0 for the cells of the running kernel.
+type historyEntry struct {
+	Session int
+	Count   int
+	Code    string
+	Output  string
+}
+
+// historyStore holds the cells run with store_history set, in the order they
+// ran, and those of past sessions, once loaded from the history file.
+type historyStore struct {
+	sync.Mutex
+	entries []historyEntry
+
+	// past holds the cells of each past session, oldest first, once
+	// loaded is set.
+	past   [][]historyEntry
+	loaded bool
+
+	// path is the file the history is kept in across restarts of the
+	// kernel, if set, and session identifies the cells of the running
+	// kernel in it.
+	path    string
+	session string
+
+	// maxSize is the size of the history file beyond which it is pruned,
+	// maxHistoryFile if it is 0.
+	maxSize int64
+}
+
+// maxHistoryFile is the size of the history file beyond which the cells of
+// the oldest sessions are dropped from it, until it is back to three quarters
+// of it.
+const maxHistoryFile = 8 << 20
+
+// historyRecord is a line of the history file.
+type historyRecord struct {
+	Session string `json:"session"`
+	Line    int    `json:"line"`
+	Source  string `json:"source"`
+	Output  string `json:"output,omitempty"`
+}
+
+// defaultHistoryPath returns the history file under the Jupyter data
+// directory: $JUPYTER_DATA_DIR, or that of the platform as Jupyter finds it.
+func defaultHistoryPath() (string, error) {
+	dir, err := currentPlatform().userDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gophernotes", "history.jsonl"), nil
+}
+
+// record adds code, run as the cell of execution count count, to the history.
+func (h *historyStore) record(count int, code string) {
+	h.Lock()
+	h.entries = append(h.entries, historyEntry{Count: count, Code: code})
+	h.Unlock()
+}
+
+// recordOutput adds output to the results of the cell of execution count
+// count, if it is in the history.
+func (h *historyStore) recordOutput(count int, output string) {
+	h.Lock()
+	defer h.Unlock()
+	if n := len(h.entries); n > 0 && h.entries[n-1].Count == count {
+		h.entries[n-1].Output += output
+	}
+}
+
+// save appends the cell of execution count count, once it has run, to the
+// history file, if there is one and the cell is in the history.
+func (h *historyStore) save(count int) error {
+	h.Lock()
+	n := len(h.entries)
+	if h.path == "" || n == 0 || h.entries[n-1].Count != count {
+		h.Unlock()
+		return nil
+	}
+	e, path, max := h.entries[n-1], h.path, h.maxFileSize()
+	line, err := json.Marshal(historyRecord{Session: h.session, Line: e.Count, Source: e.Code, Output: e.Output})
+	h.Unlock()
+	if err != nil {
+		return err
+	}
+	return appendHistoryFile(path, append(line, '\n'), max)
+}
+
+// maxFileSize returns the size of the history file beyond which it is
+// pruned. The lock is held.
+func (h *historyStore) maxFileSize() int64 {
+	if h.maxSize == 0 {
+		return maxHistoryFile
+	}
+	return h.maxSize
+}
+
+// appendHistoryFile appends line to the history file at path, pruning the
+// file once it grows beyond max.
+func appendHistoryFile(path string, line []byte, max int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	info, statErr := f.Stat()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || statErr != nil || info.Size() <= max {
+		return err
+	}
+	return pruneHistoryFile(path, max*3/4)
+}
+
+// pruneHistoryFile drops the oldest lines of the history file at path until
+// it is no bigger than size. The file is replaced at once, for other kernels
+// not to read it half written.
+func pruneHistoryFile(path string, size int64) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for int64(len(data)) > size {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			data = nil
+			break
+		}
+		data = data[i+1:]
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".history")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// load reads the cells of past sessions from the history file, the first
+// time it is called. The lock is held.
+func (h *historyStore) load() error {
+	if h.loaded {
+		return nil
+	}
+	h.loaded = true
+	if h.path == "" {
+		return nil
+	}
+	f, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sessions := map[string]int{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, int(h.maxFileSize()))
+	for sc.Scan() {
+		var r historyRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil || r.Session == h.session {
+			continue
+		}
+		i, ok := sessions[r.Session]
+		if !ok {
+			i = len(h.past)
+			sessions[r.Session] = i
+			h.past = append(h.past, nil)
+		}
+		h.past[i] = append(h.past[i], historyEntry{Session: i + 1, Count: r.Line, Code: r.Source, Output: r.Output})
+	}
+	return sc.Err()
+}
+
+// current returns a copy of the history of the running kernel.
+func (h *historyStore) current() []historyEntry {
+	h.Lock()
+	defer h.Unlock()
+	return append([]historyEntry(nil), h.entries...)
+}
+
+// pastSessions returns the cells of the past sessions of the history file,
+// oldest first, loading them the first time. The error is that of loading
+// them, the sessions read until then being returned along with it.
+func (h *historyStore) pastSessions() ([][]historyEntry, error) {
+	h.Lock()
+	defer h.Unlock()
+	err := h.load()
+	return h.past, err
+}
+
+// clear drops the cells recorded in the history of the running kernel. Those
+// of the history file stay.
+func (h *historyStore) clear() {
+	h.Lock()
+	h.entries = nil
+	h.Unlock()
+}
+
+// pastSessions returns the cells of the past sessions of the history file,
+// telling why they could not all be read.
+func (k *Kernel) pastSessions() [][]historyEntry {
+	past, err := k.history.pastSessions()
+	if err != nil {
+		k.diagnose(diagWarning, "history", "could not read the history: the cells of past sessions are left out", "error", err)
+	}
+	return past
+}
+
+// HandleHistoryRequest answers a history_request with the cells of the range,
+// tail or search it asks for. Sessions are numbered from the oldest of the
+// history file, the running kernel being the last; a session of 0 or less
+// counts back from it.
+func (k *Kernel) HandleHistoryRequest(receipt MsgReceipt) {
+	content, _ := receipt.Msg.Content.(map[string]interface{})
+	output, _ := content["output"].(bool)
+	access, _ := content["hist_access_type"].(string)
+	number := func(key string) int {
+		n, _ := content[key].(float64)
+		return int(n)
+	}
+
+	sessions := append(k.pastSessions(), k.history.current())
+	var entries []historyEntry
+	switch access {
+	case "range":
+		session := number("session")
+		if session <= 0 {
+			session += len(sessions)
+		}
+		if session < 1 || session > len(sessions) {
+			break
+		}
+		start, stop := number("start"), number("stop")
+		for _, e := range sessions[session-1] {
+			if e.Count >= start && (stop <= 0 || e.Count < stop) {
+				entries = append(entries, e)
+			}
+		}
+	case "tail", "search":
+		var match *regexp.Regexp
+		if access == "search" {
+			pattern, _ := content["pattern"].(string)
+			match = globRegexp(pattern)
+		}
+		for _, s := range sessions {
+			for _, e := range s {
+				if match == nil || match.MatchString(e.Code) {
+					entries = append(entries, e)
+				}
+			}
+		}
+		if unique, _ := content["unique"].(bool); unique {
+			entries = uniqueHistory(entries)
+		}
+		if n := number("n"); n > 0 && len(entries) > n {
+			entries = entries[len(entries)-n:]
+		}
+	}
+
+	items := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		session := e.Session
+		if session == 0 {
+			session = len(sessions)
+		}
+		var input interface{} = e.Code
+		if output {
+			var out interface{}
+			if e.Output != "" {
+				out = e.Output
+			}
+			input = []interface{}{e.Code, out}
+		}
+		items = append(items, []interface{}{session, e.Count, input})
+	}
+	reply := NewMsg("history_reply", receipt.Msg)
+	reply.Content = map[string]interface{}{"status": "ok", "history": items}
+	receipt.SendResponse(receipt.Sockets.ShellSocket, reply)
+}
+
+// globRegexp returns the regular expression matching the code the glob
+// pattern of a history search matches, whole: * matches any text, and ? any
+// character.
+func globRegexp(pattern string) *regexp.Regexp {
+	var buf bytes.Buffer
+	buf.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			buf.WriteString(".*")
+		case '?':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.MustCompile(buf.String())
+}
+
+// uniqueHistory drops the cells of entries whose code is run again later.
+func uniqueHistory(entries []historyEntry) []historyEntry {
+	seen := map[string]bool{}
+	var unique []historyEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if code := strings.TrimSpace(entries[i].Code); !seen[code] {
+			seen[code] = true
+			unique = append(unique, entries[i])
+		}
+	}
+	for i, j := 0, len(unique)-1; i < j; i, j = i+1, j-1 {
+		unique[i], unique[j] = unique[j], unique[i]
+	}
+	return unique
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withHistoryFile points the history h at a file of its own in a temporary
+// directory holding lines, with no cells of a past session loaded, and
+// returns its path and the function putting the history back as it was.
+func withHistoryFile(t *testing.T, h *historyStore, lines ...historyRecord) (string, func()) {
+	dir, err := ioutil.TempDir("", "history")
+	noError(t, err)
+	path := filepath.Join(dir, "gophernotes", "history.jsonl")
+	for _, r := range lines {
+		line, err := json.Marshal(r)
+		noError(t, err)
+		noError(t, appendHistoryFile(path, append(line, '\n'), maxHistoryFile))
+	}
+
+	h.Lock()
+	savedPath, savedEntries := h.path, h.entries
+	h.path, h.past, h.loaded = path, nil, false
+	h.Unlock()
+	return path, func() {
+		h.Lock()
+		h.path, h.entries, h.past, h.loaded = savedPath, savedEntries, nil, false
+		h.Unlock()
+		os.RemoveAll(dir)
+	}
+}
+
+// TestSaveHistory tests that the cells of the history are appended to the
+// history file, and read back as a past session by the next kernel
+func TestSaveHistory(t *testing.T) {
+	h := &historyStore{session: "first"}
+	path, restore := withHistoryFile(t, h)
+	defer restore()
+
+	h.record(1, "x := 1")
+	noError(t, h.save(1))
+	h.record(2, "x")
+	h.recordOutput(2, "1\n")
+	noError(t, h.save(2))
+	noError(t, h.save(3))
+
+	data, err := ioutil.ReadFile(path)
+	noError(t, err)
+	assert.Equal(t, `{"session":"first","line":1,"source":"x := 1"}
+{"session":"first","line":2,"source":"x","output":"1\n"}
+`, string(data))
+
+	past, err := h.pastSessions()
+	noError(t, err)
+	assert.Empty(t, past, "the cells of the running kernel are not a past session")
+
+	next := &historyStore{path: path, session: "second"}
+	past, err = next.pastSessions()
+	noError(t, err)
+	assert.Equal(t, [][]historyEntry{{
+		{Session: 1, Count: 1, Code: "x := 1"},
+		{Session: 1, Count: 2, Code: "x", Output: "1\n"},
+	}}, past)
+	assert.Equal(t, "1/1: x := 1\n1/2: x\n1/2> 1\n", formatHistory(past[0], true))
+}
+
+// TestPruneHistoryFile tests that the oldest lines of the history file are
+// dropped once it grows beyond its maximum size
+func TestPruneHistoryFile(t *testing.T) {
+	path, restore := withHistoryFile(t, &historyStore{})
+	defer restore()
+
+	for _, line := range []string{"line 1 is here\n", "line 2 is here\n", "line 3 is here\n"} {
+		noError(t, appendHistoryFile(path, []byte(line), 40))
+	}
+	data, err := ioutil.ReadFile(path)
+	noError(t, err)
+	assert.Equal(t, "line 2 is here\nline 3 is here\n", string(data))
+}
+
+// TestGlobRegexp tests the patterns of history searches
+func TestGlobRegexp(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, code string
+		match         bool
+	}{
+		{"*", "x := 1\nx", true},
+		{"x*", "x := 1", true},
+		{"x*", "y := x", false},
+		{"*fmt.Print?n*", "fmt.Println(x)", true},
+		{"a.b", "axb", false},
+	} {
+		assert.Equal(t, tt.match, globRegexp(tt.pattern).MatchString(tt.code), "%q on %q", tt.pattern, tt.code)
+	}
+}
+
+// TestHistoryRequest tests that history requests find the cells of past
+// sessions of the history file and of the running kernel
+func TestHistoryRequest(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+	_, restore := withHistoryFile(t, &testKernel.history,
+		historyRecord{Session: "a", Line: 1, Source: "pastA := 1"},
+		historyRecord{Session: "a", Line: 2, Source: "pastA", Output: "1\n"},
+		historyRecord{Session: "b", Line: 1, Source: "pastB := 2"},
+	)
+	defer restore()
+
+	c.execute("nowC := 3")
+	reply, _ := c.execute("nowC")
+
+	request := func(content map[string]interface{}) []interface{} {
+		req := c.send("history_request", content)
+		reply := c.recv(c.shell)
+		assert.Equal(t, "history_reply", reply.Header.MsgType)
+		assert.Equal(t, req.Header.MsgID, reply.ParentHeader.MsgID)
+		items, _ := reply.Content.(map[string]interface{})["history"].([]interface{})
+		return items
+	}
+	count := reply.Content.(map[string]interface{})["execution_count"].(float64)
+
+	assert.Equal(t, []interface{}{
+		[]interface{}{float64(1), float64(1), []interface{}{"pastA := 1", nil}},
+		[]interface{}{float64(1), float64(2), []interface{}{"pastA", "1\n"}},
+	}, request(map[string]interface{}{"hist_access_type": "range", "session": 1, "start": 1, "stop": 3, "output": true}))
+
+	assert.Equal(t, []interface{}{
+		[]interface{}{float64(2), float64(1), "pastB := 2"},
+	}, request(map[string]interface{}{"hist_access_type": "range", "session": -1, "start": 0, "stop": 0}))
+
+	assert.Equal(t, []interface{}{
+		[]interface{}{float64(3), count, "nowC"},
+	}, request(map[string]interface{}{"hist_access_type": "range", "session": 0, "start": count, "stop": 0}))
+
+	items := request(map[string]interface{}{"hist_access_type": "search", "pattern": "past*", "n": 10})
+	var found []string
+	for _, item := range items {
+		found = append(found, item.([]interface{})[2].(string))
+	}
+	assert.Equal(t, "pastA := 1,pastA,pastB := 2", strings.Join(found, ","))
+
+	assert.Equal(t, []interface{}{
+		[]interface{}{float64(3), count - 1, "nowC := 3"},
+		[]interface{}{float64(3), count, "nowC"},
+	}, request(map[string]interface{}{"hist_access_type": "tail", "n": 2}))
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterLineMagic("history", "%history [-o] [-g <pattern>] [<n> | <n>-<m>...]", "list the cells run\n-o lists the results of the cells as well, and -g the cells whose code matches the regular expression, those of past sessions of the history file too.\nExecution counts and ranges of them select the cells listed.", historyMagic)
+	RegisterLineMagic("recall", "%recall [<n>]", "put the code of a cell run in a new cell\nThe cell is the last one run unless its number is given.", recallMagic)
+}
+
+// recentHistory is the number of cells %history lists without a range or a
+// pattern.
+const recentHistory = 10
+
+// maxHistoryLines is the number of lines %history prints at most in the output
+// of the cell; longer lists are shown in the pager.
+const maxHistoryLines = 40
+
+// historyMagic lists the latest cells run, with their execution counts, or the
+// cells of the ranges of counts given, or those whose code matches the regular
+// expression given with -g, in past sessions of the history file too; -o
+// lists their results as well.
+func historyMagic(ctx *MagicContext, args []string, body string) error {
+	var output bool
+	var pattern *regexp.Regexp
+	var ranges [][2]int
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-o":
+			output = true
+		case arg == "-g":
+			if i+1 == len(args) {
+				return errors.New("-g needs a pattern")
+			}
+			i++
+			re, err := regexp.Compile(args[i])
+			if err != nil {
+				return err
+			}
+			pattern = re
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown flag %s", arg)
+		default:
+			r, err := parseHistoryRange(arg)
+			if err != nil {
+				return err
+			}
+			ranges = append(ranges, r)
+		}
+	}
+
+	entries := pastHistory(ctx)
+	if pattern != nil && len(ranges) == 0 {
+		var all []historyEntry
+		for _, s := range ctx.Kernel.pastSessions() {
+			all = append(all, s...)
+		}
+		entries = append(all, entries...)
+	}
+	if pattern == nil && len(ranges) == 0 {
+		if len(entries) > recentHistory {
+			entries = entries[len(entries)-recentHistory:]
+		}
+	} else {
+		var selected []historyEntry
+		for _, e := range entries {
+			if inHistoryRanges(e.Count, ranges) && (pattern == nil || pattern.MatchString(e.Code)) {
+				selected = append(selected, e)
+			}
+		}
+		entries = selected
+	}
+	if len(entries) == 0 {
+		ctx.Stream("stdout", "No cells found.\n")
+		return nil
+	}
+
+	text := formatHistory(entries, output)
+	if strings.Count(text, "\n") > maxHistoryLines {
+		ctx.Page(text)
+	} else {
+		ctx.Stream("stdout", text)
+	}
+	return nil
+}
+
+// recallMagic puts the code of the cell of the execution count given, or of
+// the last cell run, in a new cell following the cell, to be edited.
+func recallMagic(ctx *MagicContext, args []string, body string) error {
+	entries := pastHistory(ctx)
+	switch len(args) {
+	case 0:
+		if len(entries) == 0 {
+			return errors.New("no cells in the history")
+		}
+		ctx.SetNextInput(entries[len(entries)-1].Code, false)
+		return nil
+	case 1:
+	default:
+		return errors.New("too many arguments")
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid execution count %q", args[0])
+	}
+	for _, e := range entries {
+		if e.Count == n {
+			ctx.SetNextInput(e.Code, false)
+			return nil
+		}
+	}
+	return fmt.Errorf("no cell %d in the history", n)
+}
+
+// pastHistory returns the history, but for the cell running the magic.
+func pastHistory(ctx *MagicContext) []historyEntry {
+	entries := ctx.Kernel.history.current()
+	reqcontent, _ := ctx.Receipt.Msg.Content.(map[string]interface{})
+	code, _ := reqcontent["code"].(string)
+	if n := len(entries); n > 0 && entries[n-1].Count == ctx.Kernel.execCount && entries[n-1].Code == code {
+		entries = entries[:n-1]
+	}
+	return entries
+}
+
+// parseHistoryRange parses a range of execution counts, "n" or "n-m".
+func parseHistoryRange(s string) ([2]int, error) {
+	from, to := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		from, to = s[:i], s[i+1:]
+	}
+	var r [2]int
+	var err error
+	if r[0], err = strconv.Atoi(from); err == nil {
+		r[1], err = strconv.Atoi(to)
+	}
+	if err != nil || r[0] > r[1] {
+		return r, fmt.Errorf("invalid range %q", s)
+	}
+	return r, nil
+}
+
+// inHistoryRanges reports whether count is within one of ranges, or whether
+// there are none.
+func inHistoryRanges(count int, ranges [][2]int) bool {
+	for _, r := range ranges {
+		if count >= r[0] && count <= r[1] {
+			return true
+		}
+	}
+	return len(ranges) == 0
+}
+
+// formatHistory lists entries, the code of each cell after its execution
+// count, and the session of past ones, followed by its results if output is
+// set.
+func formatHistory(entries []historyEntry, output bool) string {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		label := fmt.Sprintf("%4d", e.Count)
+		if e.Session > 0 {
+			label = fmt.Sprintf("%d/%d", e.Session, e.Count)
+		}
+		prefix := label + ": "
+		for _, line := range strings.Split(strings.TrimRight(e.Code, "\n"), "\n") {
+			buf.WriteString(strings.TrimRight(prefix+line, " ") + "\n")
+			prefix = strings.Repeat(" ", len(prefix))
+		}
+		if !output || e.Output == "" {
+			continue
+		}
+		prefix = label + "> "
+		for _, line := range strings.Split(strings.TrimRight(e.Output, "\n"), "\n") {
+			buf.WriteString(strings.TrimRight(prefix+line, " ") + "\n")
+			prefix = strings.Repeat(" ", len(prefix))
+		}
+	}
+	return buf.String()
+}
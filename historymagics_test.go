@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFormatHistory tests that cells are listed after their execution counts,
+// their continuation lines and results indented under them
+func TestFormatHistory(t *testing.T) {
+	entries := []historyEntry{
+		{Count: 3, Code: "x := 1\nx", Output: "1\n"},
+		{Count: 12, Code: "y := 2"},
+	}
+	assert.Equal(t, "   3: x := 1\n      x\n  12: y := 2\n", formatHistory(entries, false))
+	assert.Equal(t, "   3: x := 1\n      x\n   3> 1\n  12: y := 2\n", formatHistory(entries, true))
+}
+
+// TestHistoryMagic_pager tests that long lists of cells are shown in the pager
+func TestHistoryMagic_pager(t *testing.T) {
+	k := &Kernel{}
+	k.history.record(1, strings.Repeat("x++\n", maxHistoryLines+1))
+
+	ctx := &MagicContext{Kernel: k}
+	noError(t, historyMagic(ctx, nil, ""))
+	if assert.Len(t, ctx.payload, 1) {
+		assert.Equal(t, "page", ctx.payload[0]["source"])
+		assert.Equal(t, formatHistory(k.history.current(), false), ctx.payload[0]["text"])
+	}
+}
+
+// TestHistoryMagics tests that %history lists the cells run, by range or by
+// pattern, with their results if asked, but not the silent ones, and that
+// %recall puts the code of a cell in a new one
+func TestHistoryMagics(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	reply, _ := c.execute("histA := 40 + 2\nhistA")
+	first := int(reply.Content.(map[string]interface{})["execution_count"].(float64))
+	c.results(c.send("execute_request", map[string]interface{}{
+		"code":             "histSilent := 1",
+		"silent":           true,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      false,
+	}))
+	c.execute("histB := \"b\"")
+
+	history := func(code string) string {
+		reply, published := c.execute(code)
+		assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"], code)
+		return streamText(published, "stdout")
+	}
+	want := fmt.Sprintf("%4d: histA := 40 + 2\n      histA\n%4d: histB := \"b\"\n", first, first+1)
+	assert.Equal(t, want, history(fmt.Sprintf("%%history %d-%d", first, first+1)))
+	assert.Contains(t, history("%history"), want)
+	assert.Equal(t, fmt.Sprintf("%4d: histB := \"b\"\n", first+1), history(`%history -g 'histB\b'`))
+	assert.Equal(t, fmt.Sprintf("%4d: histA := 40 + 2\n      histA\n%4d> 42\n", first, first), history(fmt.Sprintf("%%history -o %d", first)))
+	assert.NotContains(t, history("%history -g hist"), "histSilent")
+	assert.Equal(t, "No cells found.\n", history("%history -g noSuchCode"))
+
+	reply, _ = c.execute(fmt.Sprintf("%%recall %d", first))
+	payload := reply.Content.(map[string]interface{})["payload"].([]interface{})
+	if assert.Len(t, payload, 1) {
+		assert.Equal(t, map[string]interface{}{
+			"source":  "set_next_input",
+			"text":    "histA := 40 + 2\nhistA",
+			"replace": false,
+		}, payload[0])
+	}
+	reply, _ = c.execute("%recall 100000")
+	assert.Equal(t, "error", reply.Content.(map[string]interface{})["status"])
+}
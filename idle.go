@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// idleExitCode is the exit status of a kernel shut down for having been idle,
+// for supervisors to tell it from a crash.
+const idleExitCode = 3
+
+// errIdle is returned by serve once the kernel has been idle for the
+// idle timeout of its options.
+var errIdle = errors.New("the kernel was idle for too long")
+
+// idleWatch keeps track of how long the kernel has been idle: since it last
+// received a message, or last handled a shell request, with none queued.
+type idleWatch struct {
+	sync.Mutex
+	timeout time.Duration
+	last    time.Time
+	pending int
+}
+
+// newIdleWatch returns a watch of a kernel shut down once idle for timeout,
+// never if it is 0.
+func newIdleWatch(timeout time.Duration) *idleWatch {
+	return &idleWatch{timeout: timeout, last: time.Now()}
+}
+
+// received records a message received, which is a shell request to handle if
+// request is set.
+func (w *idleWatch) received(request bool) {
+	w.Lock()
+	defer w.Unlock()
+	w.last = time.Now()
+	if request {
+		w.pending++
+	}
+}
+
+// done records a shell request handled.
+func (w *idleWatch) done() {
+	w.Lock()
+	defer w.Unlock()
+	w.last = time.Now()
+	w.pending--
+}
+
+// remaining returns how long the kernel has left before it has been idle for
+// the timeout as of now: 0 once it has, and -1 if it is never shut down. While
+// requests are pending, the kernel is no nearer to it than the whole timeout.
+func (w *idleWatch) remaining(now time.Time) time.Duration {
+	w.Lock()
+	defer w.Unlock()
+	switch {
+	case w.timeout <= 0:
+		return -1
+	case w.pending > 0:
+		return w.timeout
+	}
+	if left := w.timeout - now.Sub(w.last); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// shutdownIdle tells the frontend, on the stderr stream of the last message
+// received, that the kernel shuts down for having been idle for timeout, and
+// shuts it down.
+func (k *Kernel) shutdownIdle(last ComposedMsg, timeout time.Duration) {
+	receipt := MsgReceipt{Msg: last, Sockets: k.sockets}
+	notice := NewMsg("stream", last)
+	text := fmt.Sprintf("Shutting down the kernel after %v without activity.\n", timeout)
+	notice.Content = map[string]interface{}{"name": "stderr", "data": text, "text": text}
+	receipt.SendResponse(k.sockets.IOPubSocket, notice)
+	k.logger.Println("Shutting down after", timeout, "idle")
+	k.shutdown()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIdleWatch tests that the time left before the kernel is idle for too
+// long counts from the last message or request done, and stands still while
+// requests are pending
+func TestIdleWatch(t *testing.T) {
+	assert.Equal(t, time.Duration(-1), newIdleWatch(0).remaining(time.Now()))
+
+	w := newIdleWatch(time.Minute)
+	start := w.last
+	assert.Equal(t, 50*time.Second, w.remaining(start.Add(10*time.Second)))
+	assert.Equal(t, time.Duration(0), w.remaining(start.Add(time.Hour)))
+
+	w.received(true)
+	assert.Equal(t, time.Minute, w.remaining(start.Add(time.Hour)))
+	w.received(false)
+	assert.Equal(t, time.Minute, w.remaining(start.Add(time.Hour)))
+	w.done()
+	assert.Equal(t, time.Duration(0), w.remaining(w.last.Add(time.Minute)))
+	assert.Equal(t, 30*time.Second, w.remaining(w.last.Add(30*time.Second)))
+}
+
+// TestDefaultConfig_shutdownIdle tests that the environment of the kernel sets
+// how long it is left idle
+func TestDefaultConfig_shutdownIdle(t *testing.T) {
+	defer os.Setenv(shutdownIdleEnv, os.Getenv(shutdownIdleEnv))
+
+	os.Setenv(shutdownIdleEnv, "90")
+	assert.Equal(t, 90*time.Second, defaultConfig().idleTimeout())
+	os.Setenv(shutdownIdleEnv, "")
+	assert.Equal(t, time.Duration(0), defaultConfig().idleTimeout())
+}
+
+// TestServe_shutdownIdle tests that a kernel left idle shuts down with a notice
+// on stderr, but not while a cell runs for longer than it is left idle
+func TestServe_shutdownIdle(t *testing.T) {
+	info := localConnectionInfo(t)
+	sockets, err := PrepareSockets(info)
+	noError(t, err)
+	config := defaultConfig()
+	config.shutdownIdleSeconds = 1
+	k, err := NewKernel(sockets, log.New(ioutil.Discard, "", 0), config)
+	noError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- k.serve() }()
+
+	c := connectTestClient(t, info)
+	defer c.Close()
+	reply, _ := c.execute(":import time\ntime.Sleep(1500 * time.Millisecond)")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+
+	select {
+	case err := <-served:
+		assert.Equal(t, errIdle, err)
+	case <-time.After(30 * time.Second):
+		t.Fatal("the kernel did not shut down")
+	}
+	msg := c.recv(c.iopub)
+	assert.Equal(t, "stream", msg.Header.MsgType)
+	content := msg.Content.(map[string]interface{})
+	assert.Equal(t, "stderr", content["name"])
+	assert.Contains(t, content["text"], "1s without activity")
+}
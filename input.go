@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// redacted stands for the values of the input_reply messages answering
+// password prompts in the log.
+const redacted = "<redacted>"
+
+// inputAnswers are the answers to the input requests of cell code, by
+// prompt, for the code of earlier cells to get them again as it runs before
+// that of the current cell, without the frontend being asked. They are kept
+// by the kernel alone, those typed as passwords included.
+type inputAnswers struct {
+	sync.Mutex
+	m map[string]string
+}
+
+// cellInputRequest is the content of an input request of cell code. Name
+// names the file of the reply, and replay is set while the code of earlier
+// cells runs again.
+type cellInputRequest struct {
+	Name     string `json:"name"`
+	Prompt   string `json:"prompt"`
+	Password bool   `json:"password"`
+	Replay   bool   `json:"replay"`
+}
+
+// readInput asks the frontend for a line of input, with prompt, as a child of
+// the execute request of receipt, and returns it. The input is hidden as it is
+// typed if password is set, and its value left out of the log. readInput
+// fails with errNoStdin unless the request allows stdin.
+func (k *Kernel) readInput(receipt MsgReceipt, prompt string, password bool) (string, error) {
+	reqcontent, _ := receipt.Msg.Content.(map[string]interface{})
+	if allow, _ := reqcontent["allow_stdin"].(bool); !allow || receipt.Sockets.StdinSocket == nil {
+		return "", errNoStdin
+	}
+
+	req := NewMsg("input_request", receipt.Msg)
+	req.Content = map[string]interface{}{"prompt": prompt, "password": password}
+	receipt.SendResponse(receipt.Sockets.StdinSocket, req)
+
+	// No other request is run meanwhile, and the kernel leaves the stdin
+	// socket to this one.
+	for {
+		msgparts, err := receipt.Sockets.StdinSocket.RecvMultipart(0)
+		if err != nil {
+			return "", err
+		}
+		reply, _, err := WireMsgToComposedMsg(msgparts, receipt.Sockets.Key)
+		if err != nil {
+			return "", err
+		}
+		if reply.Header.MsgType != "input_reply" {
+			continue
+		}
+		content, _ := reply.Content.(map[string]interface{})
+		value, _ := content["value"].(string)
+		if logger := receipt.Sockets.logger; logger != nil {
+			logger.Println("-->", reply.Header.MsgType)
+			logger.Printf("%+v\n", loggedInputReply(content, password))
+		}
+		return value, nil
+	}
+}
+
+// loggedInputReply returns the content of an input_reply as it is logged:
+// without its value if it answers a password prompt.
+func loggedInputReply(content map[string]interface{}, password bool) map[string]interface{} {
+	if !password {
+		return content
+	}
+	logged := make(map[string]interface{}, len(content))
+	for k, v := range content {
+		logged[k] = v
+	}
+	logged["value"] = redacted
+	return logged
+}
+
+// answerCellInput answers an input request of cell code, of content, made
+// while the request of receipt runs. The frontend is asked unless the code of
+// an earlier cell made it as it ran again, and its prompt was answered before,
+// in which case the answer is given again. The reply is left in the input
+// directory of the session, where only the owner of the kernel can read it.
+func (k *Kernel) answerCellInput(receipt MsgReceipt, content json.RawMessage) {
+	var req cellInputRequest
+	if err := json.Unmarshal(content, &req); err != nil || req.Name == "" {
+		k.logger.Println("Invalid input request:", err)
+		return
+	}
+
+	var reply struct {
+		Value   string `json:"value,omitempty"`
+		Error   string `json:"error,omitempty"`
+		NoStdin bool   `json:"no_stdin,omitempty"`
+	}
+	k.inputs.Lock()
+	value, answered := k.inputs.m[req.Prompt]
+	k.inputs.Unlock()
+	if !req.Replay || !answered {
+		var err error
+		value, err = k.readInput(receipt, req.Prompt, req.Password)
+		switch {
+		case err == errNoStdin:
+			reply.NoStdin = true
+		case err != nil:
+			reply.Error = err.Error()
+		default:
+			k.inputs.Lock()
+			if k.inputs.m == nil {
+				k.inputs.m = map[string]string{}
+			}
+			k.inputs.m[req.Prompt] = value
+			k.inputs.Unlock()
+		}
+	}
+	reply.Value = value
+
+	b, err := json.Marshal(reply)
+	if err == nil {
+		err = writeInputReply(filepath.Join(k.files.input, "input-"+filepath.Base(req.Name)), b)
+	}
+	if err != nil {
+		k.logger.Println("Could not reply to an input request:", err)
+	}
+}
+
+// writeInputReply writes the reply b to path, readable by its owner alone, and
+// renames it into place once written, for cell code never to read part of it.
+func writeInputReply(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestServe_readPassword tests that gophernotes.ReadPassword asks the frontend
+// for input to be hidden, that earlier cells get the answer again without it
+// being asked, that requests not allowing stdin fail at once, and that the
+// value typed is published, recorded in the history or logged nowhere
+func TestServe_readPassword(t *testing.T) {
+	c := newTestClient(t)
+	c.Close()
+	logFile, err := ioutil.TempFile("", "gophernotes_log")
+	noError(t, err)
+	defer os.Remove(logFile.Name())
+	defer logFile.Close()
+	info := localConnectionInfo(t)
+	sockets, err := PrepareSockets(info)
+	noError(t, err)
+	k, err := NewKernel(sockets, log.New(logFile, "", 0), defaultConfig())
+	noError(t, err)
+	defer k.removeSession()
+	go k.serve()
+	c = connectTestClient(t, info)
+	defer c.Close()
+
+	const secret = "hunter2-secret"
+	req := c.send("execute_request", map[string]interface{}{
+		"code":             "pwToken, pwErr := gophernotes.ReadPassword(\"Token:\")\npwErr == nil",
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      true,
+	})
+	inputReq := c.recv(c.stdin)
+	assert.Equal(t, map[string]interface{}{"prompt": "Token:", "password": true}, inputReq.Content)
+	c.reply("input_reply", inputReq, map[string]interface{}{"value": secret})
+	reply, published := c.results(req)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"], reply.Content.(map[string]interface{})["evalue"])
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "true\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+	all := append(published, reply)
+
+	// The cell asking runs again before the next one, stdin or not.
+	reply, published = c.execute("pwLen := len(pwToken)\npwLen")
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, fmt.Sprintf("%d\n", len(secret)), published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+	all = append(append(all, published...), reply)
+
+	reply, published = c.execute("_, pwOther := gophernotes.ReadPassword(\"Other:\")\npwOther == gophernotes.ErrStdinNotAllowed")
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "true\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+	all = append(append(all, published...), reply)
+
+	for _, msg := range all {
+		assert.NotContains(t, fmt.Sprint(msg.Content), secret)
+	}
+	assert.NotContains(t, fmt.Sprint(k.history.current()), secret)
+	logged, err := ioutil.ReadFile(logFile.Name())
+	noError(t, err)
+	assert.NotContains(t, string(logged), secret)
+	assert.True(t, strings.Contains(string(logged), "input_reply") && strings.Contains(string(logged), redacted), "the input_reply is logged redacted")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"go/doc"
+	"go/token"
+	"strings"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+)
+
+// InspectReply holds the content of an inspect_reply message, or of the
+// object_info_reply of version 4 of the protocol, whose fields it carries too.
+type InspectReply struct {
+	Status   string                 `json:"status"`
+	Found    bool                   `json:"found"`
+	Data     map[string]interface{} `json:"data"`
+	Metadata map[string]interface{} `json:"metadata"`
+
+	Name       string `json:"name,omitempty"`
+	Definition string `json:"definition,omitempty"`
+	Docstring  string `json:"docstring,omitempty"`
+}
+
+// docWidth is the width to which doc comments are wrapped as plain text.
+const docWidth = 80
+
+// HandleInspectRequest answers an inspect_request, or the object_info_request
+// of version 4 of the protocol, with the documentation of the object at the
+// cursor.
+func (k *Kernel) HandleInspectRequest(receipt MsgReceipt) {
+	content := receipt.Msg.Content.(map[string]interface{})
+
+	// Version 4 of the protocol sends the name of the object alone.
+	replyType := "inspect_reply"
+	var cursor int
+	code, ok := content["code"].(string)
+	if ok {
+		pos, _ := content["cursor_pos"].(float64)
+		cursor, _ = cursorOffset(code, int(pos))
+	} else {
+		replyType = "object_info_reply"
+		code, _ = content["oname"].(string)
+		cursor = len(code)
+	}
+
+	detail, _ := content["detail_level"].(float64)
+
+	in, found := k.readSessionOf(receipt).Inspect(code, cursor)
+	msg := NewMsg(replyType, receipt.Msg)
+	msg.Content = newInspectReply(in, found, int(detail))
+	receipt.SendResponse(receipt.Sockets.ShellSocket, msg)
+}
+
+// newInspectReply builds the reply describing in, as plain text and as
+// markdown, if found is set. At detail level 0, the signature and doc comment
+// of the object are shown; above, its source is shown instead, when it could
+// be located, and otherwise a note saying why not follows them.
+func newInspectReply(in repl.Inspection, found bool, detail int) InspectReply {
+	reply := InspectReply{
+		Status:   "ok",
+		Found:    found,
+		Data:     map[string]interface{}{},
+		Metadata: map[string]interface{}{},
+	}
+	if !found {
+		return reply
+	}
+	reply.Name = in.Name
+	reply.Definition = in.Signature
+	reply.Docstring = in.Doc
+
+	if detail > 0 && in.Source != "" {
+		reply.Data["text/plain"] = in.Source + "\n"
+		reply.Data["text/markdown"] = "```go\n" + in.Source + "\n```\n"
+		return reply
+	}
+
+	text, markdown := renderDoc(in.Signature, in.Doc)
+	if detail > 0 {
+		note := "Source not shown: " + in.NoSource + "."
+		text.WriteString("\n" + note + "\n")
+		markdown.WriteString("\n*" + note + "*\n")
+	}
+
+	reply.Data["text/plain"] = text.String()
+	reply.Data["text/markdown"] = markdown.String()
+	return reply
+}
+
+// renderDoc renders the signature of an object followed by its doc comment,
+// as plain text and as markdown.
+func renderDoc(signature, docText string) (text, markdown *bytes.Buffer) {
+	text, markdown = new(bytes.Buffer), new(bytes.Buffer)
+	text.WriteString(signature + "\n")
+	markdown.WriteString("```go\n" + signature + "\n```\n")
+	if docText != "" {
+		text.WriteString("\n")
+		doc.ToText(text, docText, "", "    ", docWidth)
+		markdown.WriteString("\n" + docMarkdown(docText))
+	}
+	return text, markdown
+}
+
+// inspectionQuery reports whether code asks for the inspection of an object,
+// as an identifier or a chain of selectors followed by "?", or by "??" for its
+// source, and returns the expression and the detail level asked for.
+func inspectionQuery(code string) (expr string, detail int, ok bool) {
+	code = strings.TrimSpace(code)
+	switch {
+	case strings.HasSuffix(code, "??"):
+		expr, detail = strings.TrimSuffix(code, "??"), 1
+	case strings.HasSuffix(code, "?"):
+		expr = strings.TrimSuffix(code, "?")
+	default:
+		return "", 0, false
+	}
+	for _, name := range strings.Split(expr, ".") {
+		if !token.IsIdentifier(name) {
+			return "", 0, false
+		}
+	}
+	return expr, detail, true
+}
+
+// HandleInspectionCell answers the execute_request of a cell asking for the
+// inspection of expr, at the detail level given, with a page payload opening
+// the pager. Such cells do not count as executions.
+func (k *Kernel) HandleInspectionCell(receipt MsgReceipt, expr string, detail int) {
+	in, found := k.session.Inspect(expr, len(expr))
+	reply := NewMsg("execute_reply", receipt.Msg)
+	reply.Content = map[string]interface{}{
+		"status":           "ok",
+		"execution_count":  k.execCount,
+		"payload":          []map[string]interface{}{newPagePayload(expr, newInspectReply(in, found, detail))},
+		"user_variables":   map[string]string{},
+		"user_expressions": map[string]string{},
+	}
+	receipt.SendResponse(receipt.Sockets.ShellSocket, reply)
+}
+
+// newPagePayload returns the payload showing the inspection of expr in the
+// pager.
+func newPagePayload(expr string, reply InspectReply) map[string]interface{} {
+	data := reply.Data
+	if !reply.Found {
+		data = map[string]interface{}{"text/plain": "Nothing named " + expr + " was found.\n"}
+	}
+	return pagePayload(data)
+}
+
+// pagePayload returns the payload showing data, a bundle of representations
+// by MIME type, in the pager: version 4 of the protocol pages its text.
+func pagePayload(data map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"source": "page",
+		"text":   data["text/plain"],
+		"data":   data,
+		"start":  0,
+	}
+}
+
+// docMarkdown renders the text of a doc comment as markdown: indented blocks
+// become fenced code blocks, blank lines between them kept out of the
+// blocks, and paragraphs are kept as they are.
+func docMarkdown(text string) string {
+	var buf bytes.Buffer
+	inCode := false
+	blanks := 0
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if line == "" {
+			blanks++
+			continue
+		}
+		indented := strings.HasPrefix(line, "\t") || strings.HasPrefix(line, " ")
+		if inCode && !indented {
+			buf.WriteString("```\n")
+			inCode = false
+		}
+		buf.WriteString(strings.Repeat("\n", blanks))
+		blanks = 0
+		if indented && !inCode {
+			buf.WriteString("```\n")
+			inCode = true
+		}
+		if inCode {
+			line = strings.TrimPrefix(line, "\t")
+		}
+		buf.WriteString(line + "\n")
+	}
+	if inCode {
+		buf.WriteString("```\n")
+	}
+	return buf.String()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+	"github.com/stretchr/testify/assert"
+)
+
+// inspectAt inspects code at the position of the first "|" in it, which is
+// removed, until the session and its packages are loaded.
+func inspectAt(s *repl.Session, code string) (repl.Inspection, bool) {
+	cursor := strings.Index(code, "|")
+	code = code[:cursor] + code[cursor+1:]
+	var in repl.Inspection
+	var found bool
+	for i := 0; i < 100 && !found; i++ {
+		in, found = s.Inspect(code, cursor)
+		time.Sleep(10 * time.Millisecond)
+	}
+	return in, found
+}
+
+// TestInspect tests that package members, names of the session, builtins and
+// the fields and methods of selector chains are described with their doc
+// comments from the source
+func TestInspect(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	_, _, err = s.Eval("import \"strings\"\nimport \"net/http\"\nvar b strings.Builder\ncount := 3\nc := &http.Client{}")
+	noError(t, err)
+
+	tests := []struct {
+		code, name, signature, doc string
+	}{
+		{"strings.Rep|eat(", "strings.Repeat", "func strings.Repeat(s string, count int) string", "Repeat returns a new string"},
+		{"x := strings.Repeat(|", "strings.Repeat", "func strings.Repeat(s string, count int) string", "Repeat returns"},
+		{"coun|t + 1", "count", "var count int", ""},
+		{"b.WriteStr|ing()", "b.WriteString", "func (*strings.Builder).WriteString(s string) (int, error)", "WriteString appends"},
+		{"var h http.Cli|ent", "http.Client", "type http.Client struct{", "A Client is an HTTP client."},
+		{"c.Timeo|ut", "c.Timeout", "field Timeout time.Duration", "Timeout specifies a time limit"},
+		{"len|(b)", "len", "func len(v Type) int", "The len built-in function"},
+	}
+	for _, test := range tests {
+		in, found := inspectAt(s, test.code)
+		if !assert.True(t, found, test.code) {
+			continue
+		}
+		assert.Equal(t, test.name, in.Name, test.code)
+		assert.True(t, strings.HasPrefix(in.Signature, test.signature), "%s: %s", test.code, in.Signature)
+		assert.Contains(t, in.Doc, test.doc, test.code)
+	}
+
+	for _, code := range []string{"nothingHere", "strings.NoSuchFunc", `x := "strings.Repeat`, "count.Foo", ""} {
+		_, found := s.Inspect(code, len(code))
+		assert.False(t, found, code)
+	}
+}
+
+// TestNewInspectReply tests that inspections are shown as plain text and as
+// markdown, with the examples of doc comments fenced
+func TestNewInspectReply(t *testing.T) {
+	in := repl.Inspection{
+		Name:      "strings.Fields",
+		Signature: "func strings.Fields(s string) []string",
+		Doc:       "Fields splits s.\n\n\tstrings.Fields(\" a b \")\n\nIt trims spaces.\n",
+	}
+	reply := newInspectReply(in, true, 0)
+	assert.True(t, reply.Found)
+	assert.Equal(t, "func strings.Fields(s string) []string\n\nFields splits s.\n\n    strings.Fields(\" a b \")\n\nIt trims spaces.\n", reply.Data["text/plain"])
+	assert.Equal(t, "```go\nfunc strings.Fields(s string) []string\n```\n\nFields splits s.\n\n```\nstrings.Fields(\" a b \")\n```\n\nIt trims spaces.\n", reply.Data["text/markdown"])
+
+	reply = newInspectReply(repl.Inspection{}, false, 0)
+	assert.False(t, reply.Found)
+	assert.Empty(t, reply.Data)
+}
+
+// TestInspect_source tests that the source of the declarations of the session
+// and of packages is found, and why it is not otherwise
+func TestInspect_source(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	_, _, err = s.Eval("import \"strings\"\nimport \"math\"\nimport \"time\"\ntype point struct{ X, Y int }\norigin := point{}")
+	noError(t, err)
+
+	tests := []struct {
+		code, source, noSource string
+	}{
+		{"strings.Repe|at", "// Repeat returns a new string", ""},
+		{"point|{}", "type point struct{ X, Y int }", ""},
+		{"orig|in", "origin := point{}", ""},
+		{"origin.X|", "X, Y int", ""},
+		{"math.Sqr|t", "func Sqrt(x float64) float64 {", ""},
+		{"time.Slee|p", "", "time.Sleep has no Go body, being implemented in assembly or by the runtime"},
+		{"le|n", "", "builtins are implemented by the compiler"},
+	}
+	for _, test := range tests {
+		in, found := inspectAt(s, test.code)
+		if !assert.True(t, found, test.code) {
+			continue
+		}
+		if test.source == "" {
+			assert.Empty(t, in.Source, test.code)
+		} else {
+			assert.Contains(t, in.Source, test.source, test.code)
+		}
+		assert.Equal(t, test.noSource, in.NoSource, test.code)
+	}
+}
+
+// TestNewInspectReply_source tests that detail level 1 shows the source of the
+// object, or notes why it cannot
+func TestNewInspectReply_source(t *testing.T) {
+	in := repl.Inspection{
+		Name:      "double",
+		Signature: "func double(x int) int",
+		Source:    "func double(x int) int {\n\treturn 2 * x\n}",
+	}
+	reply := newInspectReply(in, true, 1)
+	assert.Equal(t, in.Source+"\n", reply.Data["text/plain"])
+	assert.Equal(t, "```go\n"+in.Source+"\n```\n", reply.Data["text/markdown"])
+
+	in = repl.Inspection{
+		Name:      "math.Sqrt",
+		Signature: "func math.Sqrt(x float64) float64",
+		NoSource:  "math.Sqrt has no Go body, being implemented in assembly or by the runtime",
+	}
+	reply = newInspectReply(in, true, 1)
+	assert.Equal(t, "func math.Sqrt(x float64) float64\n\nSource not shown: math.Sqrt has no Go body, being implemented in assembly or by the runtime.\n", reply.Data["text/plain"])
+	assert.Contains(t, reply.Data["text/markdown"], "*Source not shown: math.Sqrt has no Go body, being implemented in assembly or by the runtime.*")
+}
+
+// TestInspectionQuery tests that only cells made of a selector followed by ?
+// or ?? ask for an inspection
+func TestInspectionQuery(t *testing.T) {
+	tests := []struct {
+		code   string
+		expr   string
+		detail int
+		ok     bool
+	}{
+		{"strings.Repeat?", "strings.Repeat", 0, true},
+		{"  resp.Body.Close??\n", "resp.Body.Close", 1, true},
+		{"x?", "x", 0, true},
+		{"x ?", "", 0, false},
+		{"f(x)?", "", 0, false},
+		{"a + b?", "", 0, false},
+		{"x := y?", "", 0, false},
+		{"strings.?", "", 0, false},
+		{"func?", "", 0, false},
+		{"?", "", 0, false},
+		{"x???", "", 0, false},
+		{"strings.Repeat", "", 0, false},
+	}
+	for _, test := range tests {
+		expr, detail, ok := inspectionQuery(test.code)
+		assert.Equal(t, test.ok, ok, test.code)
+		assert.Equal(t, test.expr, expr, test.code)
+		assert.Equal(t, test.detail, detail, test.code)
+	}
+}
+
+// TestNewPagePayload tests that inspections open the pager, with a note when
+// nothing was found
+func TestNewPagePayload(t *testing.T) {
+	reply := newInspectReply(repl.Inspection{Signature: "var x int"}, true, 0)
+	payload := newPagePayload("x", reply)
+	assert.Equal(t, "page", payload["source"])
+	assert.Equal(t, "var x int\n", payload["text"])
+	assert.Equal(t, reply.Data, payload["data"])
+
+	payload = newPagePayload("y", newInspectReply(repl.Inspection{}, false, 0))
+	assert.Equal(t, "Nothing named y was found.\n", payload["text"])
+}
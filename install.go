@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// platform is what the Jupyter directories depend on: the operating system,
+// the environment and the home directory, for the directories of other
+// platforms to be worked out as well.
+type platform struct {
+	goos   string
+	getenv func(key string) string
+	home   func() (string, error)
+}
+
+// currentPlatform returns the platform the kernel runs on.
+func currentPlatform() platform {
+	return platform{goos: runtime.GOOS, getenv: os.Getenv, home: os.UserHomeDir}
+}
+
+// join joins elem into a path with the separators of the platform.
+func (p platform) join(elem ...string) string {
+	if p.goos != "windows" {
+		return path.Join(elem...)
+	}
+	slashed := make([]string, len(elem))
+	for i, e := range elem {
+		slashed[i] = strings.Replace(e, `\`, "/", -1)
+	}
+	return strings.Replace(path.Join(slashed...), "/", `\`, -1)
+}
+
+// userDataDir returns the Jupyter data directory of the user:
+// $JUPYTER_DATA_DIR, or that of the platform as Jupyter finds it.
+func (p platform) userDataDir() (string, error) {
+	if dir := p.getenv("JUPYTER_DATA_DIR"); dir != "" {
+		return dir, nil
+	}
+	home, err := p.home()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case p.goos == "darwin":
+		return p.join(home, "Library", "Jupyter"), nil
+	case p.goos == "windows" && p.getenv("APPDATA") != "":
+		return p.join(p.getenv("APPDATA"), "jupyter"), nil
+	case p.getenv("XDG_DATA_HOME") != "":
+		return p.join(p.getenv("XDG_DATA_HOME"), "jupyter"), nil
+	}
+	return p.join(home, ".local", "share", "jupyter"), nil
+}
+
+// systemDataDir returns the Jupyter data directory shared by the users of the
+// system.
+func (p platform) systemDataDir() (string, error) {
+	if p.goos != "windows" {
+		return "/usr/local/share/jupyter", nil
+	}
+	if dir := p.getenv("PROGRAMDATA"); dir != "" {
+		return p.join(dir, "jupyter"), nil
+	}
+	return "", errors.New("%PROGRAMDATA% is not set")
+}
+
+// sysPrefix returns the prefix of the conda environment or virtualenv the
+// installer runs in, as Jupyter would find as its sys.prefix.
+func (p platform) sysPrefix() (string, error) {
+	for _, key := range []string{"CONDA_PREFIX", "VIRTUAL_ENV"} {
+		if dir := p.getenv(key); dir != "" {
+			return dir, nil
+		}
+	}
+	return "", errors.New("no conda environment or virtualenv is active, for --sys-prefix")
+}
+
+// installOptions are the flags of the install subcommand.
+type installOptions struct {
+	user, sysPrefix   bool
+	prefix            string
+	name, displayName string
+	env               envFlag
+	uninstall         bool
+}
+
+// envFlag gathers the KEY=VALUE entries of the repeated --env flag.
+type envFlag map[string]string
+
+func (e envFlag) String() string {
+	entries := make([]string, 0, len(e))
+	for k, v := range e {
+		entries = append(entries, k+"="+v)
+	}
+	return strings.Join(entries, ",")
+}
+
+func (e envFlag) Set(entry string) error {
+	i := strings.Index(entry, "=")
+	if i <= 0 {
+		return fmt.Errorf("%q is not KEY=VALUE", entry)
+	}
+	e[entry[:i]] = entry[i+1:]
+	return nil
+}
+
+// kernelNameRe matches the names Jupyter accepts for kernelspecs.
+var kernelNameRe = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// kernelDir returns the directory of the kernelspec installed with o, on p:
+// under the data directory of the user for --user, under share/jupyter of the
+// prefix for --prefix and --sys-prefix, and under the data directory of the
+// system otherwise.
+func (p platform) kernelDir(o installOptions) (string, error) {
+	modes := 0
+	for _, set := range []bool{o.user, o.sysPrefix, o.prefix != ""} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return "", errors.New("only one of --user, --prefix and --sys-prefix can be given")
+	}
+	if !kernelNameRe.MatchString(o.name) {
+		return "", fmt.Errorf("invalid kernel name %q: only letters, digits, '.', '_' and '-' are allowed", o.name)
+	}
+
+	var dir string
+	var err error
+	switch {
+	case o.user:
+		dir, err = p.userDataDir()
+	case o.sysPrefix:
+		if dir, err = p.sysPrefix(); err == nil {
+			dir = p.join(dir, "share", "jupyter")
+		}
+	case o.prefix != "":
+		dir = p.join(o.prefix, "share", "jupyter")
+	default:
+		dir, err = p.systemDataDir()
+	}
+	if err != nil {
+		return "", err
+	}
+	return p.join(dir, "kernels", o.name), nil
+}
+
+// kernelSpec is the content of the kernel.json file of a kernelspec.
+type kernelSpec struct {
+	Argv        []string          `json:"argv"`
+	DisplayName string            `json:"display_name"`
+	Language    string            `json:"language"`
+	Env         map[string]string `json:"env,omitempty"`
+}
+
+// runInstall runs the install subcommand with args, installing the kernelspec
+// of the gophernotes binary running, or removing it with --uninstall, and
+// printing what it did to w. The kernel is pointed at its kernelspec, for it
+// to set the env of the kernelspec itself if the launcher does not.
+func runInstall(args []string, p platform, w io.Writer) error {
+	o := installOptions{env: envFlag{}}
+	flags := flag.NewFlagSet("install", flag.ContinueOnError)
+	flags.SetOutput(w)
+	flags.BoolVar(&o.user, "user", false, "Install for the current user, in the Jupyter data directory of the user")
+	flags.StringVar(&o.prefix, "prefix", "", "Install under `PATH`/share/jupyter, as in a conda environment or a Docker image")
+	flags.BoolVar(&o.sysPrefix, "sys-prefix", false, "Install in the active conda environment or virtualenv")
+	flags.StringVar(&o.name, "name", "gophernotes", "The name of the kernelspec")
+	flags.StringVar(&o.displayName, "display-name", "Go", "The name of the kernel shown by frontends")
+	flags.Var(o.env, "env", "Set the environment variable `KEY=VALUE` for the kernel, such as GOPATH, GOFLAGS or GOPROXY; may be repeated")
+	flags.BoolVar(&o.uninstall, "uninstall", false, "Remove the kernelspec of the name given rather than install it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	dir, err := p.kernelDir(o)
+	if err != nil {
+		return err
+	}
+	if o.uninstall {
+		if _, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("no kernelspec %s: %s", o.name, err)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Removed kernelspec %s from %s\n", o.name, dir)
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	spec := kernelSpec{
+		Argv:        []string{exe, "--kernelspec-dir={resource_dir}", "{connection_file}"},
+		DisplayName: o.displayName,
+		Language:    "go",
+		Env:         o.env,
+	}
+	b, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(p.join(dir, "kernel.json"), b, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Installed kernelspec %s in %s:\n%s", o.name, dir, b)
+	return nil
+}
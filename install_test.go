@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPlatform returns a platform of goos with the environment env and the
+// home directory home.
+func testPlatform(goos, home string, env map[string]string) platform {
+	return platform{
+		goos:   goos,
+		getenv: func(key string) string { return env[key] },
+		home: func() (string, error) {
+			if home == "" {
+				return "", errors.New("no home")
+			}
+			return home, nil
+		},
+	}
+}
+
+// TestKernelDir tests where each mode of the installer puts kernelspecs, on
+// unix and Windows layouts
+func TestKernelDir(t *testing.T) {
+	linux := testPlatform("linux", "/home/gopher", map[string]string{"CONDA_PREFIX": "/opt/conda/envs/go"})
+	xdg := testPlatform("linux", "/home/gopher", map[string]string{"XDG_DATA_HOME": "/data", "VIRTUAL_ENV": "/home/gopher/venv"})
+	darwin := testPlatform("darwin", "/Users/gopher", nil)
+	windows := testPlatform("windows", `C:\Users\gopher`, map[string]string{
+		"APPDATA":      `C:\Users\gopher\AppData\Roaming`,
+		"PROGRAMDATA":  `C:\ProgramData`,
+		"CONDA_PREFIX": `C:\Miniconda3\envs\go`,
+	})
+	custom := testPlatform("linux", "/home/gopher", map[string]string{"JUPYTER_DATA_DIR": "/srv/jupyter"})
+
+	cases := []struct {
+		p    platform
+		o    installOptions
+		want string
+	}{
+		{linux, installOptions{}, "/usr/local/share/jupyter/kernels/gophernotes"},
+		{linux, installOptions{user: true}, "/home/gopher/.local/share/jupyter/kernels/gophernotes"},
+		{linux, installOptions{prefix: "/opt/go/"}, "/opt/go/share/jupyter/kernels/gophernotes"},
+		{linux, installOptions{sysPrefix: true}, "/opt/conda/envs/go/share/jupyter/kernels/gophernotes"},
+		{linux, installOptions{user: true, name: "go1.13"}, "/home/gopher/.local/share/jupyter/kernels/go1.13"},
+		{xdg, installOptions{user: true}, "/data/jupyter/kernels/gophernotes"},
+		{xdg, installOptions{sysPrefix: true}, "/home/gopher/venv/share/jupyter/kernels/gophernotes"},
+		{darwin, installOptions{user: true}, "/Users/gopher/Library/Jupyter/kernels/gophernotes"},
+		{darwin, installOptions{}, "/usr/local/share/jupyter/kernels/gophernotes"},
+		{custom, installOptions{user: true}, "/srv/jupyter/kernels/gophernotes"},
+		{windows, installOptions{}, `C:\ProgramData\jupyter\kernels\gophernotes`},
+		{windows, installOptions{user: true}, `C:\Users\gopher\AppData\Roaming\jupyter\kernels\gophernotes`},
+		{windows, installOptions{prefix: `D:\tools\go`}, `D:\tools\go\share\jupyter\kernels\gophernotes`},
+		{windows, installOptions{sysPrefix: true}, `C:\Miniconda3\envs\go\share\jupyter\kernels\gophernotes`},
+	}
+	for _, c := range cases {
+		if c.o.name == "" {
+			c.o.name = "gophernotes"
+		}
+		dir, err := c.p.kernelDir(c.o)
+		noError(t, err)
+		assert.Equal(t, c.want, dir, "%s %+v", c.p.goos, c.o)
+	}
+}
+
+// TestKernelDir_invalid tests that conflicting modes, bad names and a missing
+// environment are refused
+func TestKernelDir_invalid(t *testing.T) {
+	p := testPlatform("linux", "", nil)
+	for _, o := range []installOptions{
+		{user: true, prefix: "/opt", name: "gophernotes"},
+		{user: true, sysPrefix: true, name: "gophernotes"},
+		{user: true, name: "gophernotes"},
+		{sysPrefix: true, name: "gophernotes"},
+		{prefix: "/opt", name: "go notes"},
+		{prefix: "/opt", name: "../go"},
+	} {
+		_, err := p.kernelDir(o)
+		assert.Error(t, err, "%+v", o)
+	}
+	_, err := testPlatform("windows", `C:\Users\gopher`, nil).kernelDir(installOptions{name: "gophernotes"})
+	assert.Error(t, err)
+}
+
+// TestRunInstall tests that the installer writes the kernelspec, with the
+// environment given, prints it, and removes it with --uninstall
+func TestRunInstall(t *testing.T) {
+	prefix, err := ioutil.TempDir("", "gophernotes_install")
+	noError(t, err)
+	defer os.RemoveAll(prefix)
+	p := currentPlatform()
+
+	var out bytes.Buffer
+	noError(t, runInstall([]string{"--prefix", prefix, "--name", "go-test", "--display-name", "Go (test)",
+		"--env", "GOPROXY=off", "--env", "GOFLAGS=-mod=vendor"}, p, &out))
+	dir := filepath.Join(prefix, "share", "jupyter", "kernels", "go-test")
+	b, err := ioutil.ReadFile(filepath.Join(dir, "kernel.json"))
+	noError(t, err)
+	assert.Equal(t, "Installed kernelspec go-test in "+dir+":\n"+string(b), out.String())
+
+	var spec kernelSpec
+	noError(t, json.Unmarshal(b, &spec))
+	exe, err := os.Executable()
+	noError(t, err)
+	assert.Equal(t, kernelSpec{
+		Argv:        []string{exe, "--kernelspec-dir={resource_dir}", "{connection_file}"},
+		DisplayName: "Go (test)",
+		Language:    "go",
+		Env:         map[string]string{"GOPROXY": "off", "GOFLAGS": "-mod=vendor"},
+	}, spec)
+
+	out.Reset()
+	noError(t, runInstall([]string{"--prefix", prefix, "--name", "go-test", "--uninstall"}, p, &out))
+	assert.Equal(t, "Removed kernelspec go-test from "+dir+"\n", out.String())
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+	assert.Error(t, runInstall([]string{"--prefix", prefix, "--name", "go-test", "--uninstall"}, p, &out))
+
+	assert.Error(t, runInstall([]string{"--prefix", prefix, "--env", "GOPATH"}, p, &out))
+	assert.Error(t, runInstall([]string{"--prefix", prefix, "extra"}, p, &out))
+}
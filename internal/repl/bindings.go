@@ -0,0 +1,92 @@
+package replpkg
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"sort"
+)
+
+// isHelper reports whether name is declared by the session rather than by
+// cells.
+func isHelper(name string) bool {
+	return name == "main" || name == printerName || isForgotten(name)
+}
+
+// check type checks the session, and returns what it found along with the
+// scopes of the names cells declare at their top level: that of the package,
+// and that of the main function, which wins over it.
+func (s *Session) check() (*types.Info, []*types.Scope, error) {
+	if err := s.reset(); err != nil {
+		return nil, nil, err
+	}
+
+	files := append([]*ast.File{s.File}, s.ExtraFiles...)
+	info := &types.Info{
+		Defs:   make(map[*ast.Ident]types.Object),
+		Uses:   make(map[*ast.Ident]types.Object),
+		Scopes: make(map[ast.Node]*types.Scope),
+	}
+	conf := types.Config{
+		Importer: s.importer(),
+		Error:    func(error) {},
+	}
+	pkg, _ := conf.Check("main", s.Fset, files, info)
+	if pkg == nil {
+		return nil, nil, fmt.Errorf("could not type check the session")
+	}
+	scopes := []*types.Scope{pkg.Scope()}
+	if sc := info.Scopes[s.mainFunc().Type]; sc != nil {
+		scopes = append(scopes, sc)
+	}
+	return info, scopes, nil
+}
+
+// A Binding is a name declared by cells at their top level.
+type Binding struct {
+	Name string
+
+	// Kind is "var", "const", "func" or "type".
+	Kind string
+
+	// Type is the type of the name, or the type a type name stands for.
+	Type string
+}
+
+// Bindings returns the variables, constants, functions and types declared by
+// cells at their top level, sorted by name. The packages imported and the
+// helpers of the session are left out.
+func (s *Session) Bindings() ([]Binding, error) {
+	_, scopes, err := s.check()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]types.Object{}
+	for _, sc := range scopes {
+		for _, name := range sc.Names() {
+			if name != "_" && !isHelper(name) {
+				byName[name] = sc.Lookup(name)
+			}
+		}
+	}
+	var bindings []Binding
+	for name, obj := range byName {
+		if _, ok := obj.(*types.PkgName); ok {
+			continue
+		}
+		qualifier := func(p *types.Package) string {
+			if p == obj.Pkg() {
+				return ""
+			}
+			return p.Name()
+		}
+		typ := obj.Type()
+		if _, ok := obj.(*types.TypeName); ok {
+			typ = typ.Underlying()
+		}
+		bindings = append(bindings, Binding{name, objectKind(obj), types.TypeString(typ, qualifier)})
+	}
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].Name < bindings[j].Name })
+	return bindings, nil
+}
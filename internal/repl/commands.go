@@ -14,7 +14,6 @@ import (
 
 	"go/ast"
 	"go/build"
-	"go/importer"
 	"go/types"
 
 	"golang.org/x/tools/go/ast/astutil"
@@ -80,14 +79,20 @@ func actionImport(s *Session, arg string) (string, error) {
 	}
 
 	path := strings.Trim(arg, `"`)
+	if path == "gophernotes" {
+		path = runtimePkg
+	}
 
 	// check if the package specified by path is importable
-	_, err := importer.Default().Import(path)
+	_, err := s.importer().Import(path)
 	if err != nil {
 		return "", err
 	}
 
-	astutil.AddImport(s.Fset, s.File, path)
+	// a new import may come with export data newer than that completion loaded
+	if astutil.AddImport(s.Fset, s.File, path) {
+		resetPackages()
+	}
 
 	return "", nil
 }
@@ -0,0 +1,1391 @@
+package replpkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"go/types"
+)
+
+// completionBudget is how long Complete waits for the session to be type
+// checked, after which it answers from the names found in the session AST
+// alone. The type check goes on in the background, and its result serves
+// later completions of the same session.
+var completionBudget = 50 * time.Millisecond
+
+// maxCompletions caps the number of completions returned.
+const maxCompletions = 200
+
+// goKeywords are the keywords of Go.
+var goKeywords = []string{
+	"break", "case", "chan", "const", "continue", "default", "defer", "else",
+	"fallthrough", "for", "func", "go", "goto", "if", "import", "interface",
+	"map", "package", "range", "return", "select", "struct", "switch", "type",
+	"var",
+}
+
+// snippet is a completion expanding a short trigger to a common piece of code,
+// inserted as is.
+type snippet struct {
+	trigger     string
+	description string
+	code        string
+}
+
+// snippets are the snippets offered for the triggers typed.
+var snippets = []snippet{
+	{"iferr", "return the error", "if err != nil {\n\treturn err\n}"},
+	{"forr", "range loop", "for i, v := range xs {\n\t\n}"},
+	{"fori", "counting loop", "for i := 0; i < n; i++ {\n\t\n}"},
+	{"gofunc", "goroutine", "go func() {\n\t\n}()"},
+	{"switcht", "type switch", "switch v := x.(type) {\ncase T:\n\t\n}"},
+}
+
+// Completion is a candidate for the token being completed.
+type Completion struct {
+	Text string
+
+	// Kind is "var", "const", "type", "func", "package", "directory",
+	// "field", "method", "keyword", "snippet" or "file".
+	Kind string
+
+	// Detail describes the object completed, such as "var x int", when it
+	// is known.
+	Detail string
+
+	// Import is the path of the package that must be imported for the
+	// completion to compile, if the session does not import it yet.
+	Import string
+}
+
+// typedSession caches the session as last type checked for completion, so
+// that the completions following one another as the user types share a single
+// type check.
+type typedSession struct {
+	mu sync.Mutex
+
+	// checked is the last type check done, and pending the one in flight,
+	// if any.
+	checked *typeCheck
+	pending *typeCheck
+}
+
+// typeCheck is a type check of the session, run in the background.
+type typeCheck struct {
+	generation int
+
+	// source is the printed session file checked, whose positions fset
+	// holds along with those of the extra files.
+	source  string
+	fset    *token.FileSet
+	objects map[string]types.Object
+
+	// done is closed once the check is over; cancel is closed when the
+	// session changes before that.
+	done   chan struct{}
+	cancel chan struct{}
+}
+
+// Complete returns the completions of the token of code at cursor, a byte
+// offset, along with the byte range of code that the completions replace.
+func (s *Session) Complete(code string, cursor int) (completions []Completion, start, end int) {
+	if cursor < 0 || cursor > len(code) {
+		return nil, cursor, cursor
+	}
+	if start, end, ok := importPathBounds(code, cursor); ok {
+		return completeImportPath(s, code[start:cursor]), start, end
+	}
+	if tok, pos := literalAt(code, cursor); tok != token.ILLEGAL {
+		if tok == token.STRING {
+			if completions, start, ok := completeFilePath(code[pos+1 : cursor]); ok {
+				end := cursor
+				for end < len(code) && !strings.ContainsRune("/\"`\n", rune(code[end])) {
+					end++
+				}
+				return completions, pos + 1 + start, end
+			}
+		}
+		return nil, cursor, cursor
+	}
+	start, end = tokenBounds(code, cursor)
+	prefix := code[start:cursor]
+	if start > 0 && code[start-1] == '.' {
+		return s.completeMember(code, start-1, prefix), start, end
+	}
+	if r, _ := utf8.DecodeRuneInString(prefix); unicode.IsDigit(r) {
+		return nil, start, end
+	}
+
+	seen := map[string]bool{}
+	if lit, ok := compositeLitAt(code, start); ok {
+		completions = s.completeFields(code, lit, prefix)
+		for _, c := range completions {
+			seen[c.Text] = true
+		}
+	}
+	add := func(c Completion) {
+		if seen[c.Text] || fuzzyScore(prefix, c.Text) == 0 || c.Text == printerName || c.Text == "main" {
+			return
+		}
+		seen[c.Text] = true
+		completions = append(completions, c)
+	}
+
+	objects := s.typedObjects()
+	names := append(s.sessionNames(), cellNames(code, start)...)
+	sort.Slice(names, func(i, j int) bool { return names[i].Text < names[j].Text })
+	for _, c := range names {
+		if obj := objects[c.Text]; obj != nil {
+			c.Detail = types.ObjectString(obj, (*types.Package).Name)
+		}
+		add(c)
+	}
+
+	// With nothing typed yet, only the names of the session are worth
+	// offering.
+	if prefix != "" {
+		for _, k := range goKeywords {
+			add(Completion{Text: k, Kind: "keyword"})
+		}
+		for _, name := range types.Universe.Names() {
+			obj := types.Universe.Lookup(name)
+			add(Completion{Text: name, Kind: objectKind(obj), Detail: types.ObjectString(obj, nil)})
+		}
+	}
+
+	// Snippets come last, matched by their trigger rather than the code
+	// they expand to, and indented like the line being typed.
+	completions = rank(completions, prefix)
+	if prefix != "" {
+		lineStart := strings.LastIndex(code[:start], "\n") + 1
+		line := code[lineStart:start]
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		for _, sn := range snippets {
+			if hasPrefixFold(sn.trigger, prefix) {
+				completions = append(completions, Completion{
+					Text:   strings.Replace(sn.code, "\n", "\n"+indent, -1),
+					Kind:   "snippet",
+					Detail: sn.trigger + ": " + sn.description,
+				})
+			}
+		}
+	}
+	return completions, start, end
+}
+
+// hasPrefixFold reports whether s begins with prefix, ignoring case.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// rank orders completions by how well they match prefix, as scored by
+// fuzzyScore, and caps their number. Fuzzy matches are dropped unless few
+// completions begin with prefix.
+func rank(completions []Completion, prefix string) []Completion {
+	scores := make(map[string]int, len(completions))
+	prefixed := 0
+	for _, c := range completions {
+		score := fuzzyScore(prefix, c.Text)
+		scores[c.Text] = score
+		if score >= foldPrefixScore {
+			prefixed++
+		}
+	}
+	if prefixed >= minPrefixMatches {
+		kept := completions[:0]
+		for _, c := range completions {
+			if scores[c.Text] >= foldPrefixScore {
+				kept = append(kept, c)
+			}
+		}
+		completions = kept
+	}
+	sort.SliceStable(completions, func(i, j int) bool {
+		return scores[completions[i].Text] > scores[completions[j].Text]
+	})
+	if len(completions) > maxCompletions {
+		completions = completions[:maxCompletions]
+	}
+	return completions
+}
+
+// completeMember returns the completions of what follows the selector of
+// code ending at dot: the exported members of a package, or the fields and
+// methods of a value of the session, chained selectors being resolved one
+// level at a time.
+func (s *Session) completeMember(code string, dot int, prefix string) []Completion {
+	chain := selectorChain(code, dot)
+	if chain == nil {
+		return nil
+	}
+
+	// Variables of the session shadow packages of the same name. The
+	// unexported names of the session are only visible from its package.
+	var t types.Type
+	var from *types.Package
+	switch obj := s.typedObjects()[chain[0]].(type) {
+	case *types.Var, *types.Const:
+		t, from = obj.Type(), obj.Pkg()
+	}
+	if t == nil {
+		pkgPath, imported := s.resolvePackage(code, chain[0])
+		if pkgPath == "" {
+			return nil
+		}
+		pkg := importPackage(pkgPath)
+		if pkg == nil {
+			return nil
+		}
+		if len(chain) == 1 {
+			return packageMembers(pkg, imported, prefix)
+		}
+		switch obj := pkg.Scope().Lookup(chain[1]).(type) {
+		case *types.Var, *types.Const:
+			if obj.Exported() {
+				t = obj.Type()
+			}
+		}
+		chain = chain[1:]
+	}
+
+	for _, name := range chain[1:] {
+		if t == nil {
+			return nil
+		}
+		obj, _, _ := types.LookupFieldOrMethod(t, true, from, name)
+		field, ok := obj.(*types.Var)
+		if !ok {
+			return nil
+		}
+		t = field.Type()
+	}
+	if t == nil {
+		return nil
+	}
+	return rank(typeMembers(t, from, prefix), prefix)
+}
+
+// selectorChain returns the identifiers of the selector expression of code
+// ending at dot, such as ["resp", "Body"] for "resp.Body.", or nil if it is
+// not made of identifiers alone.
+func selectorChain(code string, dot int) []string {
+	var chain []string
+	for {
+		start, _ := tokenBounds(code[:dot], dot)
+		name := code[start:dot]
+		if name == "" {
+			return nil
+		}
+		if r, _ := utf8.DecodeRuneInString(name); unicode.IsDigit(r) {
+			return nil
+		}
+		chain = append([]string{name}, chain...)
+		if start == 0 || code[start-1] != '.' {
+			if start > 0 && strings.ContainsRune(")]}", rune(code[start-1])) {
+				return nil
+			}
+			return chain
+		}
+		dot = start - 1
+	}
+}
+
+// packageMembers returns the completions of the exported members of pkg.
+func packageMembers(pkg *types.Package, imported bool, prefix string) []Completion {
+	pkgPath := pkg.Path()
+
+	var completions []Completion
+	for _, name := range pkg.Scope().Names() {
+		obj := pkg.Scope().Lookup(name)
+		if !obj.Exported() || fuzzyScore(prefix, name) == 0 {
+			continue
+		}
+		c := Completion{
+			Text:   name,
+			Kind:   objectKind(obj),
+			Detail: types.ObjectString(obj, types.RelativeTo(pkg)),
+		}
+		if !imported {
+			c.Import = pkgPath
+		}
+		completions = append(completions, c)
+	}
+	return rank(completions, prefix)
+}
+
+// typeMembers returns the completions of the fields, promoted ones included,
+// and methods of an addressable value of type t, as seen from package from.
+// Only the names that select something, rather than being ambiguous, shadowed
+// or unexported, complete.
+func typeMembers(t types.Type, from *types.Package, prefix string) []Completion {
+	var names []string
+
+	// Fields are found breadth first through embedded structs.
+	seen := map[types.Type]bool{}
+	queue := []types.Type{t}
+	for len(queue) > 0 {
+		st := queue[0]
+		queue = queue[1:]
+		if p, ok := st.Underlying().(*types.Pointer); ok {
+			st = p.Elem()
+		}
+		if seen[st] {
+			continue
+		}
+		seen[st] = true
+		strct, ok := st.Underlying().(*types.Struct)
+		if !ok {
+			continue
+		}
+		for i := 0; i < strct.NumFields(); i++ {
+			f := strct.Field(i)
+			names = append(names, f.Name())
+			if f.Anonymous() {
+				queue = append(queue, f.Type())
+			}
+		}
+	}
+
+	mt := t
+	if _, ok := t.Underlying().(*types.Interface); !ok {
+		if _, ok := t.(*types.Pointer); !ok {
+			mt = types.NewPointer(t)
+		}
+	}
+	mset := types.NewMethodSet(mt)
+	for i := 0; i < mset.Len(); i++ {
+		names = append(names, mset.At(i).Obj().Name())
+	}
+	sort.Strings(names)
+
+	var completions []Completion
+	done := map[string]bool{}
+	for _, name := range names {
+		if done[name] || fuzzyScore(prefix, name) == 0 {
+			continue
+		}
+		done[name] = true
+		obj, _, _ := types.LookupFieldOrMethod(t, true, from, name)
+		if obj == nil {
+			continue
+		}
+		kind := "method"
+		if _, ok := obj.(*types.Var); ok {
+			kind = "field"
+		}
+		completions = append(completions, Completion{
+			Text:   name,
+			Kind:   kind,
+			Detail: types.ObjectString(obj, types.RelativeTo(obj.Pkg())),
+		})
+	}
+	return completions
+}
+
+// resolvePackage returns the path of the package known as name in the session
+// or in the imports of code, and reports whether it is imported. Failing that,
+// it returns the standard library package of that name, if there is exactly
+// one.
+func (s *Session) resolvePackage(code, name string) (pkgPath string, imported bool) {
+	for _, imp := range s.File.Imports {
+		if importName(imp) == name {
+			p, _ := strconv.Unquote(imp.Path.Value)
+			return p, true
+		}
+	}
+	for _, p := range cellImports(code) {
+		if p == "gophernotes" {
+			p = runtimePkg
+		}
+		if path.Base(p) == name {
+			return p, true
+		}
+	}
+
+	var std []string
+	if !withinBudget(func() { std = stdPackages() }) {
+		return "", false
+	}
+	var candidates []string
+	for _, p := range std {
+		if path.Base(p) == name {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) != 1 {
+		return "", false
+	}
+	return candidates[0], false
+}
+
+// cellImports returns the paths imported by the import declarations of code.
+func cellImports(code string) []string {
+	var paths []string
+	importing, factored := false, false
+	scanCell(code, func(pos int, tok token.Token, lit string) bool {
+		switch {
+		case tok == token.IMPORT:
+			importing, factored = true, false
+		case !importing:
+		case tok == token.LPAREN:
+			factored = true
+		case tok == token.STRING:
+			if p, err := strconv.Unquote(lit); err == nil {
+				paths = append(paths, p)
+			}
+			importing = factored
+		case tok == token.IDENT, tok == token.PERIOD, tok == token.SEMICOLON && factored:
+		default:
+			importing = false
+		}
+		return true
+	})
+	return paths
+}
+
+// importPathBounds reports whether cursor is in the import path of an import
+// declaration of code, or of an :import command, and returns the bounds of
+// the path.
+func importPathBounds(code string, cursor int) (start, end int, ok bool) {
+	lineStart := strings.LastIndex(code[:cursor], "\n") + 1
+	if line := code[lineStart:]; strings.HasPrefix(line, ":import ") {
+		start = lineStart + len(":import ")
+		for start < cursor && code[start] == ' ' {
+			start++
+		}
+		return start, pathEnd(code, cursor), true
+	}
+
+	importing, factored := false, false
+	scanCell(code, func(pos int, tok token.Token, lit string) bool {
+		if pos >= cursor {
+			return false
+		}
+		switch {
+		case tok == token.IMPORT:
+			importing, factored = true, false
+		case !importing:
+		case tok == token.LPAREN:
+			factored = true
+		case tok == token.STRING:
+			if cursor < pos+len(lit) || cursor == pos+len(lit) && !closed(tok, lit) {
+				start, end, ok = pos+1, pathEnd(code, cursor), true
+				return false
+			}
+			importing = factored
+		case tok == token.IDENT, tok == token.PERIOD, tok == token.SEMICOLON && factored:
+		default:
+			importing = false
+		}
+		return true
+	})
+	return start, end, ok
+}
+
+// pathEnd returns the end of the import path of code going on at cursor.
+func pathEnd(code string, cursor int) int {
+	end := cursor
+	for end < len(code) && !strings.ContainsRune("\"` \t\n", rune(code[end])) {
+		end++
+	}
+	return end
+}
+
+// completeImportPath returns the import paths beginning with prefix, from the
+// standard library, GOPATH, the module cache and the modules of the module
+// context of s. Below the standard library,
+// completion goes a directory at a time, directories holding no module of
+// their own completing with a trailing slash.
+func completeImportPath(s *Session, prefix string) []Completion {
+	var completions []Completion
+	seen := map[string]bool{}
+	add := func(p string) {
+		if seen[p] || !strings.HasPrefix(p, prefix) {
+			return
+		}
+		seen[p] = true
+		kind := "package"
+		if strings.HasSuffix(p, "/") {
+			kind = "directory"
+		}
+		completions = append(completions, Completion{Text: p, Kind: kind})
+	}
+
+	var std []string
+	if withinBudget(func() { std = stdPackages() }) {
+		for _, p := range std {
+			add(p)
+		}
+	}
+	for _, p := range completeImport(s, prefix) {
+		add(p)
+	}
+	for _, p := range completeModule(prefix) {
+		add(p)
+	}
+	for _, p := range completeLocalModule(s, prefix) {
+		add(p)
+	}
+	sort.Slice(completions, func(i, j int) bool { return completions[i].Text < completions[j].Text })
+	return rank(completions, prefix)
+}
+
+// completeFilePath returns the completions of the path typed so far in a
+// string literal, if it looks like one: it holds a slash, or starts with "./"
+// or "~". Paths are relative to the working directory, "~" standing for the
+// home directory. The completions replace the last element of the path, which
+// starts at offset start of typed; directories complete with a trailing slash.
+// Hidden files only complete once their dot is typed.
+func completeFilePath(typed string) (completions []Completion, start int, ok bool) {
+	if !strings.Contains(typed, "/") && !strings.HasPrefix(typed, "~") {
+		return nil, 0, false
+	}
+	if typed == "~" {
+		return []Completion{{Text: "~/", Kind: "file"}}, 0, true
+	}
+	start = strings.LastIndex(typed, "/") + 1
+	dir, base := typed[:start], typed[start:]
+	if strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, start, true
+		}
+		dir = home + dir[1:]
+	}
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, start, true
+	}
+	for _, fi := range entries {
+		name := fi.Name()
+		if !strings.HasPrefix(name, base) || strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		if fi.IsDir() {
+			name += "/"
+		}
+		completions = append(completions, Completion{Text: name, Kind: "file"})
+		if len(completions) == maxCompletions {
+			break
+		}
+	}
+	return completions, start, true
+}
+
+// modCacheDir returns the directory of the module cache.
+func modCacheDir() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	gopath := filepath.SplitList(build.Default.GOPATH)
+	if len(gopath) == 0 {
+		return ""
+	}
+	return filepath.Join(gopath[0], "pkg", "mod")
+}
+
+// completeModule returns the paths of the module cache beginning with prefix,
+// a directory at a time. Within modules cached at several versions, the
+// latest is listed.
+func completeModule(prefix string) []string {
+	root := modCacheDir()
+	if root == "" {
+		return nil
+	}
+	d, fn := path.Split(prefix)
+
+	// Find the directory of d, in which the first element naming a module
+	// carries its version.
+	dir := root
+	for _, elem := range strings.Split(strings.Trim(d, "/"), "/") {
+		if elem == "" {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(dir, escapeModPath(elem))); err == nil && fi.IsDir() {
+			dir = filepath.Join(dir, escapeModPath(elem))
+			continue
+		}
+		versions, _ := filepath.Glob(filepath.Join(dir, escapeModPath(elem)+"@*"))
+		if len(versions) == 0 {
+			return nil
+		}
+		sort.Strings(versions)
+		dir = versions[len(versions)-1]
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, fi := range entries {
+		name := fi.Name()
+		if !fi.IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || dir == root && name == "cache" {
+			continue
+		}
+		elem, isModule := name, false
+		if i := strings.Index(name, "@"); i >= 0 {
+			elem, isModule = name[:i], true
+		}
+		elem = unescapeModPath(elem)
+		if !strings.HasPrefix(elem, fn) {
+			continue
+		}
+		p := path.Join(d, elem)
+		if !isModule && !hasGoFiles(filepath.Join(dir, name)) {
+			p += "/"
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// escapeModPath escapes elem as in the module cache, where capital letters
+// are written as "!" followed by the letter in lower case.
+func escapeModPath(elem string) string {
+	var buf bytes.Buffer
+	for _, r := range elem {
+		if unicode.IsUpper(r) {
+			buf.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// unescapeModPath undoes escapeModPath.
+func unescapeModPath(elem string) string {
+	var buf bytes.Buffer
+	upper := false
+	for _, r := range elem {
+		switch {
+		case r == '!':
+			upper = true
+			continue
+		case upper:
+			r = unicode.ToUpper(r)
+		}
+		upper = false
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// hasGoFiles reports whether dir holds Go files, and so is a package.
+func hasGoFiles(dir string) bool {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	return len(matches) > 0
+}
+
+// compositeLit describes the composite literal in which a key is being typed.
+type compositeLit struct {
+	// typeExpr is the type of the innermost literal whose type is written
+	// out, such as "[]image.Point".
+	typeExpr string
+
+	// elided is the number of literals nested in that one, down to the
+	// one being typed in, whose type is elided, such as the elements of a
+	// slice written {X: 1}.
+	elided int
+
+	// keys are the keys already set in the literal being typed in.
+	keys []string
+}
+
+// literalFrame is a bracket opened, and not yet closed, ahead of the cursor.
+type literalFrame struct {
+	tok token.Token
+	lit *compositeLit // for the braces of composite literals
+}
+
+// typeExprTokens are the tokens a type preceding the brace of a composite
+// literal may be made of.
+var typeExprTokens = map[token.Token]bool{
+	token.IDENT: true, token.PERIOD: true, token.MAP: true, token.MUL: true,
+	token.LBRACK: true, token.RBRACK: true, token.INT: true, token.ELLIPSIS: true,
+}
+
+// literalPrecedents are the tokens after which a brace opens a composite
+// literal rather than a block.
+var literalPrecedents = map[token.Token]bool{
+	token.ASSIGN: true, token.DEFINE: true, token.COMMA: true, token.LPAREN: true,
+	token.LBRACE: true, token.LBRACK: true, token.COLON: true, token.RETURN: true,
+	token.AND: true, token.ARROW: true, token.SEMICOLON: true,
+}
+
+// compositeLitAt reports whether the token of code starting at start is a key
+// of a composite literal, right after its opening brace or a comma, and
+// describes the literal. The literal need not be closed.
+func compositeLitAt(code string, start int) (compositeLit, bool) {
+	type tokenAt struct {
+		pos int
+		tok token.Token
+	}
+	var tokens []tokenAt
+	var stack []literalFrame
+	scanCell(code[:start], func(pos int, tok token.Token, lit string) bool {
+		tokens = append(tokens, tokenAt{pos, tok})
+		switch tok {
+		case token.LPAREN, token.LBRACK:
+			stack = append(stack, literalFrame{tok: tok})
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case token.COLON:
+			// An identifier followed by a colon in a literal is a key.
+			if n := len(tokens); n >= 2 && tokens[n-2].tok == token.IDENT && len(stack) > 0 {
+				if top := stack[len(stack)-1]; top.lit != nil {
+					top.lit.keys = append(top.lit.keys, strings.TrimSpace(code[tokens[n-2].pos:pos]))
+				}
+			}
+		case token.LBRACE:
+			frame := literalFrame{tok: tok}
+			// Walk back over the type of the literal.
+			i := len(tokens) - 1
+			depth := 0
+			for i > 0 {
+				prev := tokens[i-1].tok
+				if prev == token.RBRACK {
+					depth++
+				} else if prev == token.LBRACK && depth > 0 {
+					depth--
+				} else if !typeExprTokens[prev] && depth == 0 {
+					break
+				}
+				i--
+			}
+			typeExpr := strings.TrimSpace(code[tokens[i].pos:pos])
+			precedent := token.SEMICOLON
+			if i > 0 {
+				precedent = tokens[i-1].tok
+			}
+			var outer *compositeLit
+			if len(stack) > 0 {
+				outer = stack[len(stack)-1].lit
+			}
+			switch {
+			case typeExpr != "" && literalPrecedents[precedent]:
+				frame.lit = &compositeLit{typeExpr: typeExpr}
+			case typeExpr == "" && outer != nil && (precedent == token.LBRACE || precedent == token.COMMA || precedent == token.COLON):
+				frame.lit = &compositeLit{typeExpr: outer.typeExpr, elided: outer.elided + 1}
+			}
+			stack = append(stack, frame)
+		}
+		return true
+	})
+
+	if len(stack) == 0 || len(tokens) == 0 {
+		return compositeLit{}, false
+	}
+	top := stack[len(stack)-1]
+	last := tokens[len(tokens)-1].tok
+	if top.lit == nil || last != token.LBRACE && last != token.COMMA {
+		return compositeLit{}, false
+	}
+	return *top.lit, true
+}
+
+// completeFields returns the completions of the fields of the struct type of
+// lit not set yet, followed by the colon of their key.
+func (s *Session) completeFields(code string, lit compositeLit, prefix string) []Completion {
+	expr, err := parser.ParseExpr(lit.typeExpr)
+	if err != nil {
+		return nil
+	}
+	t := s.resolveType(code, expr)
+	for i := 0; t != nil && i < lit.elided; i++ {
+		switch u := t.Underlying().(type) {
+		case *types.Slice:
+			t = u.Elem()
+		case *types.Array:
+			t = u.Elem()
+		case *types.Map:
+			t = u.Elem()
+		default:
+			return nil
+		}
+		// A literal elided for a pointer is that of what it points to.
+		if p, ok := t.Underlying().(*types.Pointer); ok {
+			t = p.Elem()
+		}
+	}
+	if t == nil {
+		return nil
+	}
+	strct, ok := t.Underlying().(*types.Struct)
+	if !ok {
+		return nil
+	}
+
+	set := map[string]bool{}
+	for _, k := range lit.keys {
+		set[k] = true
+	}
+	var completions []Completion
+	for i := 0; i < strct.NumFields(); i++ {
+		f := strct.Field(i)
+		if set[f.Name()] || fuzzyScore(prefix, f.Name()) == 0 {
+			continue
+		}
+		// Unexported fields are only settable from the session's own types.
+		if !f.Exported() && (f.Pkg() == nil || f.Pkg().Name() != "main") {
+			continue
+		}
+		completions = append(completions, Completion{
+			Text:   f.Name() + ": ",
+			Kind:   "field",
+			Detail: types.ObjectString(f, types.RelativeTo(f.Pkg())),
+		})
+	}
+	return rank(completions, prefix)
+}
+
+// resolveType returns the type denoted by expr, in the session or code, or nil
+// if it cannot be resolved.
+func (s *Session) resolveType(code string, expr ast.Expr) types.Type {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		if obj, ok := s.typedObjects()[expr.Name].(*types.TypeName); ok {
+			return obj.Type()
+		}
+		if obj, ok := types.Universe.Lookup(expr.Name).(*types.TypeName); ok {
+			return obj.Type()
+		}
+	case *ast.SelectorExpr:
+		x, ok := expr.X.(*ast.Ident)
+		if !ok {
+			return nil
+		}
+		pkgPath, _ := s.resolvePackage(code, x.Name)
+		if pkgPath == "" {
+			return nil
+		}
+		if pkg := importPackage(pkgPath); pkg != nil {
+			if obj, ok := pkg.Scope().Lookup(expr.Sel.Name).(*types.TypeName); ok && obj.Exported() {
+				return obj.Type()
+			}
+		}
+	case *ast.StarExpr:
+		if elem := s.resolveType(code, expr.X); elem != nil {
+			return types.NewPointer(elem)
+		}
+	case *ast.ArrayType:
+		if elem := s.resolveType(code, expr.Elt); elem != nil {
+			return types.NewSlice(elem)
+		}
+	case *ast.MapType:
+		key := s.resolveType(code, expr.Key)
+		if key == nil {
+			key = types.Typ[types.Invalid]
+		}
+		if elem := s.resolveType(code, expr.Value); elem != nil {
+			return types.NewMap(key, elem)
+		}
+	}
+	return nil
+}
+
+// withinBudget runs f in the background, and reports whether it returned
+// within completionBudget.
+func withinBudget(f func()) bool {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(completionBudget):
+		debugf("complete :: took longer than %s", completionBudget)
+		return false
+	}
+}
+
+var (
+	pkgCacheMu  sync.Mutex
+	pkgCache    = map[string]*types.Package{}
+	pkgImporter types.Importer
+
+	// pkgLookup finds the export data of packages, by default that of the
+	// gc compiler, if not set.
+	pkgLookup importer.Lookup
+
+	// pkgFset holds the positions of the objects of loaded packages, at
+	// which their declarations are found in the source.
+	pkgFset = token.NewFileSet()
+)
+
+// loadPackage returns the package at pkgPath, loaded from its export data, or
+// nil if it cannot be. Packages are cached until resetPackages is called, and
+// shared by the completions and the type checks of the session.
+func loadPackage(pkgPath string) *types.Package {
+	pkgCacheMu.Lock()
+	defer pkgCacheMu.Unlock()
+	if p, ok := pkgCache[pkgPath]; ok {
+		return p
+	}
+	if pkgImporter == nil {
+		pkgImporter = importer.ForCompiler(pkgFset, "gc", pkgLookup)
+	}
+	p, err := pkgImporter.Import(pkgPath)
+	if err != nil {
+		debugf("complete :: import %q: %s", pkgPath, err)
+	}
+	pkgCache[pkgPath] = p
+	return p
+}
+
+// resetPackages empties the cache of loadPackage, for the export data of the
+// packages to be loaded again.
+func resetPackages() {
+	pkgCacheMu.Lock()
+	defer pkgCacheMu.Unlock()
+	pkgCache = map[string]*types.Package{}
+	pkgImporter = nil
+}
+
+// setPackageLookup makes loadPackage find the export data of packages with
+// lookup.
+func setPackageLookup(lookup importer.Lookup) {
+	pkgCacheMu.Lock()
+	defer pkgCacheMu.Unlock()
+	pkgLookup = lookup
+}
+
+// importPackage returns the package at pkgPath, or nil if it cannot be loaded
+// within completionBudget. As packages are cached, one loading too slowly
+// completes the next time.
+func importPackage(pkgPath string) *types.Package {
+	var pkg *types.Package
+	if !withinBudget(func() { pkg = loadPackage(pkgPath) }) {
+		return nil
+	}
+	return pkg
+}
+
+// errCanceled is returned by the imports of a type check that is canceled.
+var errCanceled = errors.New("type check canceled")
+
+// checkImporter imports the packages of a type check through loadPackage,
+// failing once cancel is closed so that the check finishes early.
+type checkImporter struct {
+	cancel <-chan struct{}
+}
+
+func (imp checkImporter) Import(pkgPath string) (*types.Package, error) {
+	select {
+	case <-imp.cancel:
+		return nil, errCanceled
+	default:
+	}
+	if pkg := loadPackage(pkgPath); pkg != nil {
+		return pkg, nil
+	}
+	return nil, fmt.Errorf("could not import %s", pkgPath)
+}
+
+var (
+	stdOnce sync.Once
+	std     []string
+)
+
+// stdPackages returns the import paths of the packages of the standard
+// library, leaving out commands and internal and vendored packages.
+func stdPackages() []string {
+	stdOnce.Do(func() {
+		var walk func(dir, importPath string)
+		walk = func(dir, importPath string) {
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				return
+			}
+			hasGo := false
+			for _, fi := range entries {
+				name := fi.Name()
+				if !fi.IsDir() {
+					hasGo = hasGo || strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go")
+					continue
+				}
+				switch {
+				case name == "testdata", name == "internal", name == "vendor",
+					strings.HasPrefix(name, "."), strings.HasPrefix(name, "_"),
+					importPath == "" && name == "cmd":
+					continue
+				}
+				walk(filepath.Join(dir, name), path.Join(importPath, name))
+			}
+			if hasGo && importPath != "" {
+				std = append(std, importPath)
+			}
+		}
+		walk(gorootSrc, "")
+		sort.Strings(std)
+	})
+	return std
+}
+
+// tokenBounds returns the bounds of the identifier of code around cursor.
+func tokenBounds(code string, cursor int) (start, end int) {
+	start, end = cursor, cursor
+	for start > 0 {
+		r, n := utf8.DecodeLastRuneInString(code[:start])
+		if !isIdentRune(r) {
+			break
+		}
+		start -= n
+	}
+	for end < len(code) {
+		r, n := utf8.DecodeRuneInString(code[end:])
+		if !isIdentRune(r) {
+			break
+		}
+		end += n
+	}
+	return start, end
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// scanCell calls f with the tokens of code, stopping once f returns false.
+// Errors, such as the unterminated literal being typed, are ignored.
+func scanCell(code string, f func(pos int, tok token.Token, lit string) bool) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(code))
+	var sc scanner.Scanner
+	sc.Init(file, []byte(code), func(token.Position, string) {}, scanner.ScanComments)
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF || !f(file.Offset(pos), tok, lit) {
+			return
+		}
+	}
+}
+
+// inLiteral reports whether cursor is inside a string or character literal,
+// or a comment, of code.
+func inLiteral(code string, cursor int) bool {
+	tok, _ := literalAt(code, cursor)
+	return tok != token.ILLEGAL
+}
+
+// literalAt returns the kind and position of the string or character literal,
+// or comment, of code that cursor is inside, or token.ILLEGAL if it is inside
+// none.
+func literalAt(code string, cursor int) (tok token.Token, pos int) {
+	tok = token.ILLEGAL
+	scanCell(code, func(p int, t token.Token, lit string) bool {
+		if p >= cursor {
+			return false
+		}
+		tok = token.ILLEGAL
+		switch t {
+		case token.STRING, token.CHAR, token.COMMENT:
+			// A literal cut short by the cursor only ends there.
+			if cursor < p+len(lit) || cursor == p+len(lit) && !closed(t, lit) {
+				tok, pos = t, p
+			}
+		}
+		return true
+	})
+	return tok, pos
+}
+
+// closed reports whether the literal or comment lit, of kind tok, is
+// terminated.
+func closed(tok token.Token, lit string) bool {
+	switch {
+	case tok == token.COMMENT && strings.HasPrefix(lit, "//"):
+		return false
+	case tok == token.COMMENT:
+		return len(lit) >= 4 && strings.HasSuffix(lit, "*/")
+	case len(lit) < 2:
+		return false
+	}
+	return lit[len(lit)-1] == lit[0]
+}
+
+// cellNames returns the names declared by the code of the cell being typed,
+// ahead of end, so that they complete before the cell has run.
+func cellNames(code string, end int) []Completion {
+	var names, pending []Completion
+	kind := ""
+	scanCell(code[:end], func(pos int, tok token.Token, lit string) bool {
+		switch tok {
+		case token.VAR, token.CONST, token.TYPE, token.FUNC:
+			kind = tok.String()
+			pending = nil
+		case token.IDENT:
+			if kind != "" {
+				names = append(names, Completion{Text: lit, Kind: kind})
+				kind = ""
+				break
+			}
+			pending = append(pending, Completion{Text: lit, Kind: "var"})
+		case token.COMMA:
+		case token.DEFINE:
+			names = append(names, pending...)
+			pending = nil
+		default:
+			kind = ""
+			pending = nil
+		}
+		return true
+	})
+	return names
+}
+
+// sessionNames returns the names declared by the session, found in its AST:
+// imported packages, and the declarations of its files and of the main
+// function.
+func (s *Session) sessionNames() []Completion {
+	var names []Completion
+	for _, imp := range s.File.Imports {
+		if name := importName(imp); name != "." {
+			names = append(names, Completion{Text: name, Kind: "package"})
+		}
+	}
+	for _, f := range append([]*ast.File{s.File}, s.ExtraFiles...) {
+		for _, decl := range f.Decls {
+			names = append(names, declNames(decl)...)
+		}
+	}
+	for _, stmt := range s.mainBody.List {
+		switch stmt := stmt.(type) {
+		case *ast.DeclStmt:
+			names = append(names, declNames(stmt.Decl)...)
+		case *ast.AssignStmt:
+			if stmt.Tok != token.DEFINE {
+				continue
+			}
+			for _, lhs := range stmt.Lhs {
+				if id, ok := lhs.(*ast.Ident); ok && id.Name != "_" {
+					names = append(names, Completion{Text: id.Name, Kind: "var"})
+				}
+			}
+		}
+	}
+
+	// The names Forget removed are not offered.
+	kept := names[:0]
+	for _, name := range names {
+		if !isForgotten(name.Text) {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+// importName returns the name under which imp is known in the file. Imports
+// named "_" are those quick fixes keep while unused, and are known by the name
+// of their package once used.
+func importName(imp *ast.ImportSpec) string {
+	if imp.Name != nil && imp.Name.Name != "_" {
+		return imp.Name.Name
+	}
+	p, _ := strconv.Unquote(imp.Path.Value)
+	if p == runtimePkg {
+		return "gophernotes"
+	}
+	return path.Base(p)
+}
+
+// declNames returns the names declared by decl, other than methods.
+func declNames(decl ast.Decl) []Completion {
+	var names []Completion
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		if decl.Recv == nil {
+			names = append(names, Completion{Text: decl.Name.Name, Kind: "func"})
+		}
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, Completion{Text: spec.Name.Name, Kind: "type"})
+			case *ast.ValueSpec:
+				for _, id := range spec.Names {
+					if id.Name != "_" {
+						names = append(names, Completion{Text: id.Name, Kind: decl.Tok.String()})
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// objectKind returns the Completion kind of obj.
+func objectKind(obj types.Object) string {
+	switch obj.(type) {
+	case *types.Const:
+		return "const"
+	case *types.TypeName:
+		return "type"
+	case *types.Func, *types.Builtin:
+		return "func"
+	case *types.PkgName:
+		return "package"
+	}
+	return "var"
+}
+
+// typedObjects returns the objects in scope at the end of the main function
+// of the session, if it can be type checked within completionBudget.
+func (s *Session) typedObjects() map[string]types.Object {
+	if tc := s.typeChecked(); tc != nil {
+		return tc.objects
+	}
+	return nil
+}
+
+// typeChecked returns the type check of the session as it is, or nil if it
+// cannot be done within completionBudget. The check is cached until the
+// session changes. A completion arriving while the session is being checked
+// waits for that check rather than starting its own, and a check of a session
+// that has changed since is canceled.
+func (s *Session) typeChecked() *typeCheck {
+	s.typed.mu.Lock()
+	if tc := s.typed.checked; tc != nil && tc.generation == s.generation {
+		s.typed.mu.Unlock()
+		return tc
+	}
+	tc := s.typed.pending
+	if tc == nil || tc.generation != s.generation {
+		if tc != nil {
+			close(tc.cancel)
+		}
+		tc = s.checkInBackground()
+		s.typed.pending = tc
+	}
+	s.typed.mu.Unlock()
+
+	select {
+	case <-tc.done:
+		return tc
+	case <-time.After(completionBudget):
+		debugf("complete :: took longer than %s", completionBudget)
+		return nil
+	}
+}
+
+// checkInBackground starts a type check of the session. The session is type
+// checked from its printed source, so that the check can outlive the
+// completion without racing with the cells run next.
+func (s *Session) checkInBackground() *typeCheck {
+	tc := &typeCheck{
+		generation: s.generation,
+		fset:       token.NewFileSet(),
+		done:       make(chan struct{}),
+		cancel:     make(chan struct{}),
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, s.Fset, s.File); err != nil {
+		close(tc.done)
+		return tc
+	}
+	tc.source = buf.String()
+	extra := append([]string{}, s.ExtraFilePaths...)
+
+	go func() {
+		tc.objects = checkSession(tc.fset, tc.source, extra, tc.cancel)
+		s.typed.mu.Lock()
+		if s.typed.pending == tc {
+			s.typed.checked, s.typed.pending = tc, nil
+		}
+		s.typed.mu.Unlock()
+		close(tc.done)
+	}()
+	return tc
+}
+
+// checkSession type checks the session source along with the extra files of
+// the session, their positions added to fset, and returns the objects in scope
+// at the end of main, or nil if cancel is closed before it is done.
+func checkSession(fset *token.FileSet, source string, extra []string, cancel <-chan struct{}) map[string]types.Object {
+	f, err := parser.ParseFile(fset, "gophernotes_session.go", source, 0)
+	if err != nil {
+		return nil
+	}
+	files := []*ast.File{f}
+	for _, p := range extra {
+		src, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		if ef, err := parser.ParseFile(fset, p, src, 0); err == nil {
+			files = append(files, ef)
+		}
+	}
+
+	info := &types.Info{Scopes: make(map[ast.Node]*types.Scope)}
+	conf := types.Config{
+		Importer: checkImporter{cancel},
+		Error:    func(error) {},
+	}
+	conf.Check("main", fset, files, info)
+	select {
+	case <-cancel:
+		return nil
+	default:
+	}
+
+	var main *ast.FuncDecl
+	for _, decl := range f.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok && fd.Recv == nil && fd.Name.Name == "main" {
+			main = fd
+		}
+	}
+	if main == nil {
+		return nil
+	}
+	scope := info.Scopes[main.Type]
+	if scope == nil {
+		return nil
+	}
+
+	objects := map[string]types.Object{}
+	for sc := scope.Innermost(main.Body.Rbrace - 1); sc != nil && sc != types.Universe; sc = sc.Parent() {
+		for _, name := range sc.Names() {
+			if _, ok := objects[name]; !ok && !isForgotten(name) {
+				objects[name] = sc.Lookup(name)
+			}
+		}
+	}
+	// Package names live in the file scope.
+	if fileScope := info.Scopes[f]; fileScope != nil {
+		for _, name := range fileScope.Names() {
+			if _, ok := objects[name]; !ok {
+				objects[name] = fileScope.Lookup(name)
+			}
+		}
+	}
+	return objects
+}
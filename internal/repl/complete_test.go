@@ -0,0 +1,45 @@
+package replpkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCompositeLitAt tests finding the composite literal in which a key is
+// being typed, in code whose literals are nested or not closed yet
+func TestCompositeLitAt(t *testing.T) {
+	tests := []struct {
+		code string
+		want *compositeLit
+	}{
+		{"c := http.Client{", &compositeLit{typeExpr: "http.Client"}},
+		{"c := &http.Client{Timeout: time.Second, ", &compositeLit{typeExpr: "http.Client", keys: []string{"Timeout"}}},
+		{"c := http.Client{\n\tTimeout: 1,\n\t", &compositeLit{typeExpr: "http.Client", keys: []string{"Timeout"}}},
+		{"ps := []image.Point{{X: 1}, {", &compositeLit{typeExpr: "[]image.Point", elided: 1}},
+		{"ps := [][]T{{{A: 1, ", &compositeLit{typeExpr: "[][]T", elided: 2, keys: []string{"A"}}},
+		{`m := map[string]T{"a": {`, &compositeLit{typeExpr: "map[string]T", elided: 1}},
+		{"o := outer{In: inner{", &compositeLit{typeExpr: "inner"}},
+		{"o := outer{In: inner{D: 1}, ", &compositeLit{typeExpr: "outer", keys: []string{"In"}}},
+		{"f(T{", &compositeLit{typeExpr: "T"}},
+		{"return T{", &compositeLit{typeExpr: "T"}},
+
+		// Values, blocks and closed literals are not keys.
+		{"c := http.Client{Timeout: ", nil},
+		{"if ok {", nil},
+		{"for _, v := range xs {", nil},
+		{"f := func() {", nil},
+		{"c := http.Client{}\n", nil},
+		{"s := struct{", nil},
+	}
+	for _, test := range tests {
+		lit, ok := compositeLitAt(test.code, len(test.code))
+		if test.want == nil {
+			assert.False(t, ok, test.code)
+			continue
+		}
+		if assert.True(t, ok, test.code) {
+			assert.Equal(t, *test.want, lit, test.code)
+		}
+	}
+}
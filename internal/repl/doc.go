@@ -0,0 +1,197 @@
+package replpkg
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/doc"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+)
+
+// maxSuggestions is the number of names Suggest returns at most.
+const maxSuggestions = 5
+
+// Documentation documents a package, or what a name of the session or a
+// member of a package is.
+type Documentation struct {
+	Inspection
+
+	// Members declares, one a line, the exported members of a package, or
+	// the methods of a type.
+	Members []string
+}
+
+// Doc documents what name names: a package, by its import path or the name
+// the session knows it by, or what Inspect describes, named by an identifier
+// or a chain of selectors, which may start with an import path, as in
+// "encoding/json.Marshal". It reports whether name names anything.
+func (s *Session) Doc(name string) (Documentation, bool) {
+	var obj types.Object
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		if d, ok := s.packageDoc(name); ok {
+			return d, true
+		}
+		dot := strings.Index(name[i:], ".")
+		if dot < 0 {
+			return Documentation{}, false
+		}
+		pkgPath, chain := name[:i+dot], strings.Split(name[i+dot+1:], ".")
+		pkg := loadPackage(pkgPath)
+		if pkg == nil || !isChain(chain) {
+			return Documentation{}, false
+		}
+		obj = selectChain(types.NewPkgName(token.NoPos, nil, pkg.Name(), pkg), chain)
+	} else {
+		chain := strings.Split(name, ".")
+		if !isChain(chain) {
+			return Documentation{}, false
+		}
+		obj = s.lookupChain("", chain)
+		if pn, ok := obj.(*types.PkgName); ok {
+			return s.packageDoc(pn.Imported().Path())
+		}
+	}
+	if obj == nil {
+		return Documentation{}, false
+	}
+
+	d := Documentation{Inspection: s.inspectObject(name, obj)}
+	if tn, ok := obj.(*types.TypeName); ok {
+		if named, ok := tn.Type().(*types.Named); ok {
+			for i := 0; i < named.NumMethods(); i++ {
+				if m := named.Method(i); m.Exported() {
+					d.Members = append(d.Members, s.methodSignature(m))
+				}
+			}
+		}
+	}
+	return d, true
+}
+
+// isChain reports whether chain is made of identifiers.
+func isChain(chain []string) bool {
+	for _, name := range chain {
+		if !token.IsIdentifier(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// methodSignature returns the declaration of the method m, as in its source if
+// it can be found.
+func (s *Session) methodSignature(m *types.Func) string {
+	if f, decl := s.declaration(m); decl != nil {
+		if sig := f.signature(decl); sig != "" {
+			return sig
+		}
+	}
+	return types.ObjectString(m, qualifier)
+}
+
+// packageDoc documents the package at pkgPath from its source: its doc
+// comment, and the declarations of its exported members.
+func (s *Session) packageDoc(pkgPath string) (Documentation, bool) {
+	dir := s.packageDir(pkgPath)
+	if dir == "" {
+		return Documentation{}, false
+	}
+	bp, err := build.ImportDir(dir, 0)
+	if err != nil {
+		debugf("doc :: %s", err)
+		return Documentation{}, false
+	}
+	fset := token.NewFileSet()
+	files := map[string]*ast.File{}
+	for _, name := range append(bp.GoFiles, bp.CgoFiles...) {
+		filename := filepath.Join(dir, name)
+		if f := parseFile(fset, filename, nil); f != nil {
+			files[filename] = f.file
+		}
+	}
+	p := doc.New(&ast.Package{Name: bp.Name, Files: files}, pkgPath, 0)
+
+	d := Documentation{Inspection: Inspection{
+		Name:      pkgPath,
+		Signature: fmt.Sprintf("package %s // import %q", bp.Name, pkgPath),
+		Doc:       p.Doc,
+		NoSource:  "packages are documented rather than shown",
+	}}
+	for _, v := range p.Consts {
+		d.Members = append(d.Members, valueSummary(v))
+	}
+	for _, v := range p.Vars {
+		d.Members = append(d.Members, valueSummary(v))
+	}
+	for _, f := range p.Funcs {
+		d.Members = append(d.Members, funcSummary(fset, f))
+	}
+	for _, t := range p.Types {
+		d.Members = append(d.Members, typeSummary(fset, t))
+		for _, f := range t.Funcs {
+			d.Members = append(d.Members, "    "+funcSummary(fset, f))
+		}
+	}
+	return d, true
+}
+
+// valueSummary declares the names of a group of constants or variables.
+func valueSummary(v *doc.Value) string {
+	return v.Decl.Tok.String() + " " + strings.Join(v.Names, ", ")
+}
+
+// funcSummary declares a function, without its body.
+func funcSummary(fset *token.FileSet, f *doc.Func) string {
+	fd := *f.Decl
+	fd.Doc, fd.Body = nil, nil
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, &fd)
+	return buf.String()
+}
+
+// typeSummary declares a type, the fields of a struct or the methods of an
+// interface left out.
+func typeSummary(fset *token.FileSet, t *doc.Type) string {
+	for _, spec := range t.Decl.Specs {
+		ts, ok := spec.(*ast.TypeSpec)
+		if !ok || ts.Name.Name != t.Name {
+			continue
+		}
+		switch ts.Type.(type) {
+		case *ast.StructType:
+			return "type " + t.Name + " struct{ ... }"
+		case *ast.InterfaceType:
+			return "type " + t.Name + " interface{ ... }"
+		}
+		var buf bytes.Buffer
+		printer.Fprint(&buf, fset, ts.Type)
+		sep := " "
+		if ts.Assign.IsValid() {
+			sep = " = "
+		}
+		return "type " + t.Name + sep + buf.String()
+	}
+	return "type " + t.Name
+}
+
+// Suggest returns the names completion offers for name, best first, as those
+// that may have been meant by a name Doc does not know.
+func (s *Session) Suggest(name string) []string {
+	completions, start, _ := s.Complete(name, len(name))
+	var names []string
+	for _, c := range completions {
+		if c.Kind == "keyword" || c.Kind == "snippet" {
+			continue
+		}
+		names = append(names, name[:start]+c.Text)
+		if len(names) == maxSuggestions {
+			break
+		}
+	}
+	return names
+}
@@ -0,0 +1,442 @@
+package replpkg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/imports"
+)
+
+// displayFuncs are the functions of the runtime package displaying the value
+// they are given, which exported programs print with fmt.Println instead.
+var displayFuncs = map[string]bool{
+	"Display":  true,
+	"HTML":     true,
+	"Hexdump":  true,
+	"JSON":     true,
+	"Latex":    true,
+	"Markdown": true,
+	"SVG":      true,
+}
+
+// Export returns the files of a program of module path doing what the cells
+// of the session did, for the code prototyped in cells to make a package of
+// its own: main.go runs the statements of the cells in its main function, in
+// the order they ran, decls.go holds the functions and types the cells
+// declared, the latest of each name, and go.mod requires what the session
+// does. Imports are organized, and the files formatted.
+//
+// The calls displaying a value with the runtime package print it with
+// fmt.Println instead, the others are dropped, and so are the statements of
+// the main function still using the package; notes tell what was changed or
+// dropped, and the declarations left using it or packages of GOPATH.
+func (s *Session) Export(module string) (files map[string][]byte, notes []string, err error) {
+	c, err := s.Snapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+	noted := map[string]bool{}
+	note := func(format string, args ...interface{}) {
+		if n := fmt.Sprintf(format, args...); !noted[n] {
+			noted[n] = true
+			notes = append(notes, n)
+		}
+	}
+
+	// The results printed go, along with the helper printing them.
+	if err := c.doQuickFix(); err != nil {
+		return nil, nil, err
+	}
+	c.unwrapResults()
+	for i, decl := range c.File.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Name.Name == printerName {
+			c.File.Decls = append(c.File.Decls[:i], c.File.Decls[i+1:]...)
+			break
+		}
+	}
+	if rt := runtimeName(c.File); rt != "" {
+		printed := false
+		for _, f := range append([]*ast.File{c.File}, c.ExtraFiles...) {
+			printed = c.dropRuntimeCalls(f, rt, note) || printed
+		}
+		if printed {
+			astutil.AddImport(c.Fset, c.File, "fmt")
+		}
+		var stmts []ast.Stmt
+		for _, stmt := range c.mainBody.List {
+			if uses(stmt, rt) {
+				note("Dropped %s, which uses the %s package", firstLine(showNode(c.Fset, stmt)), rt)
+				continue
+			}
+			stmts = append(stmts, stmt)
+		}
+		c.mainBody.List = stmts
+		for _, f := range c.ExtraFiles {
+			for _, decl := range f.Decls {
+				if uses(decl, rt) {
+					note("%s still uses the %s package", declName(decl), rt)
+				}
+			}
+		}
+	}
+
+	// The variables declared but not used are used, as in the session, and
+	// the imports left unused are dropped as imports are organized.
+	c.doQuickFix()
+	for _, f := range append([]*ast.File{c.File}, c.ExtraFiles...) {
+		for _, spec := range f.Imports {
+			if spec.Name != nil && spec.Name.Name == "_" {
+				spec.Name = nil
+			}
+		}
+	}
+
+	files = map[string][]byte{}
+	var mainSrc bytes.Buffer
+	if err := printer.Fprint(&mainSrc, c.Fset, c.File); err != nil {
+		return nil, nil, err
+	}
+	if files["main.go"], err = imports.Process("main.go", mainSrc.Bytes(), nil); err != nil {
+		return nil, nil, err
+	}
+	if len(c.ExtraFiles) > 0 {
+		decls, err := c.mergedExtraFiles()
+		if err != nil {
+			return nil, nil, err
+		}
+		if files["decls.go"], err = imports.Process("decls.go", decls, nil); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var paths []string
+	for _, name := range []string{"main.go", "decls.go"} {
+		paths = append(paths, importPaths(files[name])...)
+	}
+	mod, err := c.exportGoMod(module, paths, note)
+	if err != nil {
+		return nil, nil, err
+	}
+	files["go.mod"] = mod
+	return files, notes, nil
+}
+
+// unwrapResults replaces the statements of the main body printing the results
+// of cells by the calls among those results, which print or have effects of
+// their own, as statements, and drops the other results. The "_ = x"
+// statements go too, for doQuickFix to add those still needed. The type
+// information must be that of the session as it is.
+func (s *Session) unwrapResults() {
+	var stmts []ast.Stmt
+	for _, stmt := range s.mainBody.List {
+		if assign, ok := stmt.(*ast.AssignStmt); ok && len(assign.Lhs) == 1 && isNamedIdent(assign.Lhs[0], "_") {
+			continue
+		}
+		exprs := printedExprs(stmt)
+		if exprs == nil {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		for _, expr := range exprs {
+			switch expr := expr.(type) {
+			case *ast.CallExpr:
+				tv := s.TypeInfo.Types[expr.Fun]
+				if tv.IsType() || tv.IsBuiltin() && s.isPureExpr(expr) {
+					continue
+				}
+			case *ast.UnaryExpr:
+				if expr.Op != token.ARROW {
+					continue
+				}
+			default:
+				continue
+			}
+			stmts = append(stmts, &ast.ExprStmt{X: expr})
+		}
+	}
+	s.mainBody.List = stmts
+}
+
+// dropRuntimeCalls rewrites the calls of the functions of the runtime
+// package, imported as rt, that are statements of f: those displaying a value
+// print it with fmt.Println, and the others are dropped. It reports whether
+// any call prints.
+func (s *Session) dropRuntimeCalls(f *ast.File, rt string, note func(string, ...interface{})) bool {
+	printed := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		block, ok := n.(*ast.BlockStmt)
+		if !ok {
+			return true
+		}
+		var stmts []ast.Stmt
+		for _, stmt := range block.List {
+			name, args, ok := runtimeCall(stmt, rt)
+			switch {
+			case !ok:
+				stmts = append(stmts, stmt)
+			case displayFuncs[name] && len(args) == 1:
+				note("Printed the values given to %s.%s with fmt.Println", rt, name)
+				printed = true
+				stmts = append(stmts, &ast.ExprStmt{X: &ast.CallExpr{
+					Fun:  &ast.SelectorExpr{X: ast.NewIdent("fmt"), Sel: ast.NewIdent("Println")},
+					Args: args,
+				}})
+			default:
+				note("Dropped the calls to %s.%s", rt, name)
+			}
+		}
+		block.List = stmts
+		return true
+	})
+	return printed
+}
+
+// runtimeCall returns the name of the function of the runtime package,
+// imported as rt, that stmt calls, and its arguments, if it is such a call.
+func runtimeCall(stmt ast.Stmt, rt string) (string, []ast.Expr, bool) {
+	expr, ok := stmt.(*ast.ExprStmt)
+	if !ok {
+		return "", nil, false
+	}
+	call, ok := expr.X.(*ast.CallExpr)
+	if !ok {
+		return "", nil, false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || !isNamedIdent(sel.X, rt) {
+		return "", nil, false
+	}
+	return sel.Sel.Name, call.Args, true
+}
+
+// uses reports whether node refers to the package imported as name.
+func uses(node ast.Node, name string) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok && isNamedIdent(sel.X, name) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// runtimeName returns the name f imports the runtime package as, or "" if it
+// does not import it.
+func runtimeName(f *ast.File) string {
+	for _, spec := range f.Imports {
+		if p, err := strconv.Unquote(spec.Path.Value); err == nil && p == runtimePkg {
+			return importName(spec)
+		}
+	}
+	return ""
+}
+
+// declName returns the name of the function or of the first spec decl
+// declares.
+func declName(decl ast.Decl) string {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		return decl.Name.Name
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				return spec.Name.Name
+			case *ast.ValueSpec:
+				return spec.Names[0].Name
+			}
+		}
+	}
+	return "a declaration"
+}
+
+// firstLine returns the first line of s, marking those cut.
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i] + " ..."
+	}
+	return s
+}
+
+// mergedExtraFiles returns the source of a file declaring what the extra
+// files of the session do, along with their imports.
+func (s *Session) mergedExtraFiles() ([]byte, error) {
+	var src bytes.Buffer
+	src.WriteString("package main\n")
+	seen := map[string]bool{}
+	for _, f := range s.ExtraFiles {
+		for _, spec := range f.Imports {
+			var imp bytes.Buffer
+			if err := printer.Fprint(&imp, s.Fset, spec); err != nil {
+				return nil, err
+			}
+			if !seen[imp.String()] {
+				seen[imp.String()] = true
+				src.WriteString("\nimport " + imp.String())
+			}
+		}
+	}
+	for _, f := range s.ExtraFiles {
+		for _, decl := range f.Decls {
+			if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+				continue
+			}
+			src.WriteString("\n\n")
+			if err := printer.Fprint(&src, s.Fset, decl); err != nil {
+				return nil, err
+			}
+		}
+	}
+	src.WriteString("\n")
+	return src.Bytes(), nil
+}
+
+// importPaths returns the import paths of the Go file src.
+func importPaths(src []byte) []string {
+	if src == nil {
+		return nil
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// exportGoMod returns the go.mod of module, an export of the session importing
+// paths: it requires the modules the session requires, and those of its
+// module context imported, replaced by their directories. The packages of
+// GOPATH the session imports as modules of its own are noted, not being
+// required.
+func (s *Session) exportGoMod(module string, paths []string, note func(string, ...interface{})) ([]byte, error) {
+	var goVersion string
+	var requires, replaces []string
+	shims := map[string]bool{}
+	if s.modules {
+		b, err := ioutil.ReadFile(filepath.Join(s.Dir(), "go.mod"))
+		if err != nil {
+			return nil, err
+		}
+		goVersion, requires, replaces = goModDirectives(b)
+		var kept []string
+		for _, r := range replaces {
+			if fields := strings.Fields(r); len(fields) > 2 && strings.HasPrefix(fields[2], "./"+gopathShims+"/") {
+				shims[fields[0]] = true
+				continue
+			}
+			kept = append(kept, r)
+		}
+		replaces = kept
+		kept = nil
+		for _, r := range requires {
+			if !shims[strings.Fields(r)[0]] {
+				kept = append(kept, r)
+			}
+		}
+		requires = kept
+	}
+	if goVersion == "" {
+		goVersion = s.context.Go
+	}
+
+	imported := func(mod string) bool {
+		for _, path := range paths {
+			if path == mod || strings.HasPrefix(path, mod+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	for mod := range shims {
+		if imported(mod) {
+			note("%s is a package of GOPATH, which go.mod does not require", mod)
+		}
+	}
+	for i, mod := range s.context.Paths {
+		if imported(mod) {
+			requires = append(requires, mod+" v0.0.0")
+			replaces = append(replaces, mod+" => "+s.context.Dirs[i])
+		}
+	}
+	if len(requires) > 0 {
+		replaces = append(replaces, s.context.Replace...)
+	}
+	sort.Strings(requires)
+
+	var mod bytes.Buffer
+	fmt.Fprintf(&mod, "module %s\n", module)
+	if goVersion != "" {
+		fmt.Fprintf(&mod, "\ngo %s\n", goVersion)
+	}
+	for _, block := range []struct {
+		directive string
+		lines     []string
+	}{{"require", requires}, {"replace", replaces}} {
+		if len(block.lines) > 0 {
+			fmt.Fprintf(&mod, "\n%s (\n", block.directive)
+			for _, line := range block.lines {
+				fmt.Fprintf(&mod, "\t%s\n", line)
+			}
+			fmt.Fprintf(&mod, ")\n")
+		}
+	}
+	return mod.Bytes(), nil
+}
+
+// goModDirectives returns the go version of the go.mod b, and the arguments
+// of its require and replace directives.
+func goModDirectives(b []byte) (goVersion string, requires, replaces []string) {
+	var block string
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if i := strings.Index(line, "//"); i >= 0 && !strings.Contains(line[i:], "indirect") {
+			line = strings.TrimSpace(line[:i])
+		}
+		switch {
+		case line == "":
+			continue
+		case block != "" && line == ")":
+			block = ""
+			continue
+		case block == "":
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 2 && fields[1] == "(":
+				block = fields[0]
+			case fields[0] == "go" && len(fields) > 1:
+				goVersion = fields[1]
+			case fields[0] == "require":
+				requires = append(requires, strings.Join(fields[1:], " "))
+			case fields[0] == "replace":
+				replaces = append(replaces, strings.Join(fields[1:], " "))
+			}
+			continue
+		}
+		switch block {
+		case "require":
+			requires = append(requires, line)
+		case "replace":
+			replaces = append(replaces, line)
+		}
+	}
+	return goVersion, requires, replaces
+}
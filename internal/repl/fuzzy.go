@@ -0,0 +1,111 @@
+package replpkg
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Scores of the candidates beginning with the pattern, above those of any
+// fuzzy match.
+const (
+	prefixScore     = 1000 // in the case typed
+	foldPrefixScore = 900  // ignoring case
+)
+
+// Bonuses of fuzzy matches, on top of the point each matched rune scores.
+const (
+	boundaryBonus    = 8 // for a rune starting a word of the candidate
+	consecutiveBonus = 4 // for a rune following the previous one matched
+)
+
+// minFuzzyPattern is the length of the shortest pattern matched fuzzily, shorter
+// ones matching about anything.
+const minFuzzyPattern = 2
+
+// minPrefixMatches is the number of candidates beginning with the pattern
+// below which fuzzy matches are offered as well.
+const minPrefixMatches = 5
+
+// fuzzyScore scores how well candidate matches pattern, or returns 0 if it
+// does not. Candidates beginning with pattern score highest, those beginning
+// with it only when ignoring case next. Otherwise, the runes of pattern must
+// appear in order in candidate, ignoring case, and the match scores more the
+// more of them start words, as in handleConnection for hndl or in ReadAll for
+// rall, and follow one another.
+func fuzzyScore(pattern, candidate string) int {
+	if strings.HasPrefix(candidate, pattern) {
+		return prefixScore
+	}
+	if hasPrefixFold(candidate, pattern) {
+		return foldPrefixScore
+	}
+	p, c := []rune(pattern), []rune(candidate)
+	if len(p) < minFuzzyPattern || len(p) > len(c) {
+		return 0
+	}
+
+	// prev[j] is the best score of matching the runes of pattern up to the
+	// previous one with the last at c[j], or -1 if they cannot be; cur is
+	// the same up to the current one.
+	prev := make([]int, len(c))
+	cur := make([]int, len(c))
+	for i, pr := range p {
+		// gap is the best of prev[k] for k < j-1, the matches that the
+		// rune at j does not follow directly.
+		gap := -1
+		for j, cr := range c {
+			cur[j] = -1
+			if i > 0 && j >= 2 && prev[j-2] > gap {
+				gap = prev[j-2]
+			}
+			if unicode.ToLower(pr) != unicode.ToLower(cr) {
+				continue
+			}
+			score := 1
+			if wordStart(c, j) {
+				score += boundaryBonus
+			}
+			if i == 0 {
+				cur[j] = score
+				continue
+			}
+			from := gap
+			if j >= 1 && prev[j-1] >= 0 && prev[j-1]+consecutiveBonus > from {
+				from = prev[j-1] + consecutiveBonus
+			}
+			if from >= 0 {
+				cur[j] = from + score
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	best := 0
+	for _, score := range prev {
+		if score > best {
+			best = score
+		}
+	}
+	if best >= foldPrefixScore {
+		best = foldPrefixScore - 1
+	}
+	return best
+}
+
+// wordStart reports whether the rune of c at i starts a word, as the first
+// rune, one following an underscore or a digit, or an upper case letter
+// following a lower case one.
+func wordStart(c []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev := c[i-1]
+	switch {
+	case prev == '_':
+		return true
+	case unicode.IsDigit(prev):
+		return unicode.IsLetter(c[i])
+	default:
+		return unicode.IsLower(prev) && unicode.IsUpper(c[i])
+	}
+}
@@ -0,0 +1,47 @@
+package replpkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFuzzyScore_ranking tests that exact prefixes rank above prefixes in
+// another case, above fuzzy matches on word boundaries, above scattered ones
+func TestFuzzyScore_ranking(t *testing.T) {
+	tests := []struct {
+		pattern string
+		ranked  []string
+	}{
+		{"Read", []string{"ReadFile", "readLine", "ReturnAddress", "spreadsheet"}},
+		{"readf", []string{"ReadFile", "bufferedReadFrom", "breadcrumbFilter"}},
+		{"hndl", []string{"hndlr", "handleConnection", "thousandsLeft"}},
+		{"rall", []string{"ReadAll", "carryall"}},
+		{"nc", []string{"newConn", "nonce"}},
+		{"mx", []string{"max_x", "maximum"}},
+	}
+	for _, test := range tests {
+		for i := 1; i < len(test.ranked); i++ {
+			better, worse := test.ranked[i-1], test.ranked[i]
+			b, w := fuzzyScore(test.pattern, better), fuzzyScore(test.pattern, worse)
+			assert.True(t, w > 0, "%q does not match %q", test.pattern, worse)
+			assert.True(t, b > w, "%q: %q scores %d, not above %q with %d", test.pattern, better, b, worse, w)
+		}
+	}
+}
+
+// TestFuzzyScore_noMatch tests that candidates lacking the runes of the
+// pattern in order do not match, nor do fuzzy ones for patterns of one rune
+func TestFuzzyScore_noMatch(t *testing.T) {
+	tests := []struct {
+		pattern, candidate string
+	}{
+		{"dh", "handle"},
+		{"xz", "handle"},
+		{"handles", "handle"},
+		{"l", "handle"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, 0, fuzzyScore(test.pattern, test.candidate), "%q %q", test.pattern, test.candidate)
+	}
+}
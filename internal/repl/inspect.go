@@ -0,0 +1,387 @@
+package replpkg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+)
+
+// Inspection describes the object named by the identifier at the cursor.
+type Inspection struct {
+	// Name is the expression naming the object, such as "json.Marshal".
+	Name string
+
+	// Signature declares the object, such as
+	// "func json.Marshal(v any) ([]byte, error)".
+	Signature string
+
+	// Doc is the doc comment of the object, if it has one.
+	Doc string
+
+	// Source is the source of the declaration of the object, doc comment
+	// included, when it can be located. NoSource says why otherwise.
+	Source   string
+	NoSource string
+}
+
+// Inspect describes the object named by the identifier of code at cursor, a
+// byte offset: a package member, a name declared by the session, a builtin,
+// or a field or method along a chain of selectors. When the cursor follows
+// the opening parenthesis of a call, the function called is described. It
+// reports whether the identifier names anything.
+func (s *Session) Inspect(code string, cursor int) (Inspection, bool) {
+	if cursor < 0 || cursor > len(code) || inLiteral(code, cursor) {
+		return Inspection{}, false
+	}
+	start, end := tokenBounds(code, cursor)
+	if start == end && cursor > 0 && code[cursor-1] == '(' {
+		start, end = tokenBounds(code, cursor-1)
+	}
+	if start == end {
+		return Inspection{}, false
+	}
+
+	chain := []string{code[start:end]}
+	if start > 0 && code[start-1] == '.' {
+		chain = selectorChain(code[:end], end)
+		if chain == nil {
+			return Inspection{}, false
+		}
+	}
+	obj := s.lookupChain(code, chain)
+	if obj == nil {
+		return Inspection{}, false
+	}
+	return s.inspectObject(strings.Join(chain, "."), obj), true
+}
+
+// inspectObject describes obj, named by the expression name.
+func (s *Session) inspectObject(name string, obj types.Object) Inspection {
+	in := Inspection{
+		Name:      name,
+		Signature: types.ObjectString(obj, qualifier),
+	}
+	if obj.Pkg() == nil {
+		// Builtins are documented by the declarations of package builtin,
+		// which give them a more telling signature too.
+		in.NoSource = "builtins are implemented by the compiler"
+		if f := parseSource(filepath.Join(gorootSrc, "builtin", "builtin.go")); f != nil {
+			if decl := builtinDecl(f.file, obj.Name()); decl != nil {
+				in.Signature, in.Doc = f.signature(decl), docOf(decl)
+			}
+		}
+		return in
+	}
+
+	f, decl := s.declaration(obj)
+	switch fd, _ := decl.(*ast.FuncDecl); {
+	case decl == nil:
+		in.NoSource = "the source of " + in.Name + " could not be found"
+	case fd != nil && fd.Body == nil:
+		in.Doc = docOf(decl)
+		in.NoSource = in.Name + " has no Go body, being implemented in assembly or by the runtime"
+	default:
+		in.Doc = docOf(decl)
+		in.Source = f.text(decl)
+	}
+	return in
+}
+
+// qualifier qualifies the names of other packages than the session's with
+// the name of their package.
+func qualifier(pkg *types.Package) string {
+	if pkg.Path() == "main" {
+		return ""
+	}
+	return pkg.Name()
+}
+
+// lookupChain returns the object selected by the chain of identifiers, such
+// as ["resp", "Body", "Close"], starting from a name of the session, an
+// imported package or a builtin, or nil if the chain selects nothing.
+func (s *Session) lookupChain(code string, chain []string) types.Object {
+	obj := s.typedObjects()[chain[0]]
+	if obj == nil {
+		if pkgPath, _ := s.resolvePackage(code, chain[0]); pkgPath != "" {
+			if pkg := importPackage(pkgPath); pkg != nil {
+				obj = types.NewPkgName(token.NoPos, nil, chain[0], pkg)
+			}
+		}
+	}
+	if obj == nil {
+		obj = types.Universe.Lookup(chain[0])
+	}
+	return selectChain(obj, chain[1:])
+}
+
+// selectChain returns the object selected from obj by the chain of
+// identifiers, or nil if the chain selects nothing.
+func selectChain(obj types.Object, chain []string) types.Object {
+	for _, name := range chain {
+		switch o := obj.(type) {
+		case *types.PkgName:
+			obj = o.Imported().Scope().Lookup(name)
+			if obj != nil && !obj.Exported() {
+				obj = nil
+			}
+		case *types.Var, *types.Const, *types.TypeName:
+			obj, _, _ = types.LookupFieldOrMethod(o.Type(), true, o.Pkg(), name)
+		default:
+			obj = nil
+		}
+		if obj == nil {
+			return nil
+		}
+	}
+	return obj
+}
+
+// parsedFile is a source file parsed with its comments.
+type parsedFile struct {
+	fset *token.FileSet
+	file *ast.File
+	src  []byte
+}
+
+// parseFile parses the file at filename, with the contents src if it is not
+// nil, or returns nil if it cannot be parsed.
+func parseFile(fset *token.FileSet, filename string, src []byte) *parsedFile {
+	if src == nil {
+		var err error
+		if src, err = ioutil.ReadFile(filename); err != nil {
+			debugf("inspect :: %s", err)
+			return nil
+		}
+	}
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		debugf("inspect :: %s", err)
+		return nil
+	}
+	return &parsedFile{fset, f, src}
+}
+
+var (
+	sourceMu    sync.Mutex
+	sourceFiles = map[string]*parsedFile{}
+	sourceFset  = token.NewFileSet()
+)
+
+// parseSource returns the source file of a package at filename, parsed, or nil
+// if it cannot be. As packages are loaded from export data once, their files
+// are cached.
+func parseSource(filename string) *parsedFile {
+	sourceMu.Lock()
+	defer sourceMu.Unlock()
+	if f, ok := sourceFiles[filename]; ok {
+		return f
+	}
+	f := parseFile(sourceFset, filename, nil)
+	sourceFiles[filename] = f
+	return f
+}
+
+// sourcePath returns the path of the source file in which the object of
+// package pkgPath at pos, as recorded by its export data, is declared.
+func (s *Session) sourcePath(pkgPath string, pos token.Position) string {
+	filename := pos.Filename
+	if strings.HasPrefix(filename, "$GOROOT/") {
+		return filepath.Join(filepath.Dir(gorootSrc), filepath.FromSlash(strings.TrimPrefix(filename, "$GOROOT/")))
+	}
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	dir := s.packageDir(pkgPath)
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, filepath.Base(filename))
+}
+
+// declaration returns the node declaring obj, along with the file holding it,
+// or a nil node if it cannot be found. The objects of the session are found in
+// its printed source or its extra files, and those of packages in the source
+// of the package.
+func (s *Session) declaration(obj types.Object) (*parsedFile, ast.Node) {
+	var f *parsedFile
+	var pos token.Position
+	if obj.Pkg().Path() == "main" {
+		tc := s.typeChecked()
+		if tc == nil {
+			return nil, nil
+		}
+		pos = tc.fset.Position(obj.Pos())
+		if pos.Filename == "gophernotes_session.go" {
+			f = parseFile(token.NewFileSet(), pos.Filename, []byte(tc.source))
+		} else if pos.IsValid() {
+			f = parseFile(token.NewFileSet(), pos.Filename, nil)
+		}
+	} else {
+		pos = pkgFset.Position(obj.Pos())
+		if filename := s.sourcePath(obj.Pkg().Path(), pos); pos.IsValid() && filename != "" {
+			f = parseSource(filename)
+		}
+	}
+	if f == nil {
+		return nil, nil
+	}
+	return f, f.declAt(obj.Name(), pos.Line)
+}
+
+// declAt returns the node declaring name on line of f: a function or method
+// declaration, a type or value spec, a field, or the definition of a
+// variable, or nil if there is none.
+func (f *parsedFile) declAt(name string, line int) ast.Node {
+	onLine := func(id ast.Expr) bool {
+		ident, ok := id.(*ast.Ident)
+		return ok && ident.Name == name && f.fset.Position(ident.Pos()).Line == line
+	}
+	var found ast.Node
+	var decl *ast.GenDecl
+	ast.Inspect(f.file, func(n ast.Node) bool {
+		if found != nil {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.GenDecl:
+			decl = n
+		case *ast.FuncDecl:
+			if onLine(n.Name) {
+				found = n
+			}
+		case *ast.TypeSpec:
+			if onLine(n.Name) {
+				found = specDecl(decl, n)
+			}
+		case *ast.ValueSpec:
+			for _, id := range n.Names {
+				if onLine(id) {
+					found = specDecl(decl, n)
+				}
+			}
+		case *ast.Field:
+			for _, id := range n.Names {
+				if onLine(id) {
+					found = n
+				}
+			}
+		case *ast.AssignStmt:
+			for _, lhs := range n.Lhs {
+				if n.Tok == token.DEFINE && onLine(lhs) {
+					found = n
+				}
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// text returns the source of node in f, along with its doc comment.
+func (f *parsedFile) text(node ast.Node) string {
+	start := node.Pos()
+	if doc := docGroup(node); doc != nil {
+		start = doc.Pos()
+	}
+	return string(f.src[f.fset.Position(start).Offset:f.fset.Position(node.End()).Offset])
+}
+
+// specDecl returns the declaration of spec, if it is the only spec of decl,
+// so that the doc comment of the declaration documents it.
+func specDecl(decl *ast.GenDecl, spec ast.Spec) ast.Node {
+	if decl != nil && !decl.Lparen.IsValid() {
+		return decl
+	}
+	return spec
+}
+
+// builtinDecl returns the declaration of the builtin name in f, the source of
+// package builtin, or nil if it cannot be found.
+func builtinDecl(f *ast.File, name string) ast.Node {
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Name.Name == name {
+				return decl
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if spec.Name.Name == name {
+						return specDecl(decl, spec)
+					}
+				case *ast.ValueSpec:
+					for _, id := range spec.Names {
+						if id.Name == name {
+							return specDecl(decl, spec)
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// docGroup returns the doc comment of node.
+func docGroup(node ast.Node) *ast.CommentGroup {
+	switch n := node.(type) {
+	case *ast.FuncDecl:
+		return n.Doc
+	case *ast.GenDecl:
+		return n.Doc
+	case *ast.TypeSpec:
+		return n.Doc
+	case *ast.ValueSpec:
+		return n.Doc
+	case *ast.Field:
+		return n.Doc
+	}
+	return nil
+}
+
+// docOf returns the text of the doc comment of node, or of the comment
+// following it on the same line.
+func docOf(node ast.Node) string {
+	doc := docGroup(node)
+	if doc == nil {
+		switch n := node.(type) {
+		case *ast.TypeSpec:
+			doc = n.Comment
+		case *ast.ValueSpec:
+			doc = n.Comment
+		case *ast.Field:
+			doc = n.Comment
+		}
+	}
+	return doc.Text()
+}
+
+// signature returns the source of decl, a declaration of f, without its doc
+// comment, nor the body of a function.
+func (f *parsedFile) signature(decl ast.Node) string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		fd := *d
+		fd.Doc, fd.Body = nil, nil
+		decl = &fd
+	case *ast.GenDecl:
+		gd := *d
+		gd.Doc = nil
+		decl = &gd
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, f.fset, decl); err != nil {
+		return ""
+	}
+	return buf.String()
+}
@@ -0,0 +1,257 @@
+package replpkg
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sessionModule is the module path of a session using modules.
+const sessionModule = "gophernotes_session"
+
+// gopathShims is the directory, within that of the session, holding the
+// modules standing for the packages the session imports from GOPATH once it
+// uses modules.
+const gopathShims = "gopath"
+
+// Dir returns the directory of the session files, which holds the go.mod of a
+// session using modules.
+func (s *Session) Dir() string {
+	return filepath.Dir(s.FilePath)
+}
+
+// Modules reports whether the session uses modules, its requirements recorded
+// in the go.mod of its directory.
+func (s *Session) Modules() bool {
+	return s.modules
+}
+
+// GoCommand returns the go command running with args in the directory of the
+// session, with its environment, in module mode once the session uses
+// modules.
+func (s *Session) GoCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = s.Dir()
+	cmd.Env = append(os.Environ(), s.goEnv()...)
+	return cmd
+}
+
+// goEnv returns the environment variables the go command runs with for the
+// session, besides those of the kernel: in the workspace of the session once
+// it has a module context.
+func (s *Session) goEnv() []string {
+	if !s.modules {
+		return s.Env
+	}
+	env := append(append([]string(nil), s.Env...), "GO111MODULE=on")
+	if s.context.File != "" {
+		env = append(env, "GOWORK="+filepath.Join(s.Dir(), "go.work"))
+	}
+	return env
+}
+
+// InitModules makes the session a module, for the modules it requires to be
+// managed with the go command. The packages the session imports from GOPATH,
+// such as the gophernotes package, are required as modules of their own,
+// replaced by the directories holding them.
+func (s *Session) InitModules() error {
+	if s.modules {
+		return nil
+	}
+
+	shims := map[string]string{}
+	for _, spec := range s.File.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return err
+		}
+		if err := shimGopathPackage(filepath.Join(s.Dir(), gopathShims), path, shims); err != nil {
+			return err
+		}
+	}
+
+	var mod bytes.Buffer
+	fmt.Fprintf(&mod, "module %s\n", sessionModule)
+	paths := make([]string, 0, len(shims))
+	for path := range shims {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&mod, "\nrequire %s v0.0.0\n", path)
+		fmt.Fprintf(&mod, "replace %s => ./%s\n", path, filepath.ToSlash(filepath.Join(gopathShims, path)))
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.Dir(), "go.mod"), mod.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	s.modules = true
+	s.ResetModules()
+	return nil
+}
+
+// gopathContext finds packages in GOPATH whatever GO111MODULE says: the go
+// command is not asked to find them, as it is not with file system functions
+// of its own.
+var gopathContext = func() build.Context {
+	c := build.Default
+	c.JoinPath = filepath.Join
+	return c
+}()
+
+// shimGopathPackage records in shims the module standing for the package at
+// path, if it is found in GOPATH, and in turn those for the packages it
+// imports from GOPATH: a directory of dir linking to the files of the package,
+// along with a go.mod naming it.
+func shimGopathPackage(dir, path string, shims map[string]string) error {
+	if _, ok := shims[path]; ok || path == "C" {
+		return nil
+	}
+	pkg, err := gopathContext.Import(path, "", 0)
+	if err != nil || pkg.Goroot {
+		return nil
+	}
+
+	shim := filepath.Join(dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(shim, 0755); err != nil {
+		return err
+	}
+	for _, files := range [][]string{pkg.GoFiles, pkg.CgoFiles} {
+		for _, name := range files {
+			link := filepath.Join(shim, name)
+			os.Remove(link)
+			if err := os.Symlink(filepath.Join(pkg.Dir, name), link); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(shim, "go.mod"), []byte("module "+path+"\n"), 0644); err != nil {
+		return err
+	}
+	shims[path] = shim
+
+	for _, imp := range pkg.Imports {
+		if err := shimGopathPackage(dir, imp, shims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetModules drops what is known of the packages the session imports, for
+// those of modules just required or upgraded to be loaded again.
+func (s *Session) ResetModules() {
+	s.exports = map[string]string{}
+	s.local = map[string]localPackage{}
+	s.Types.Importer = s.importer()
+	if s.modules {
+		setPackageLookup(s.lookupExport)
+	} else {
+		setPackageLookup(nil)
+	}
+	resetPackages()
+}
+
+// importer returns the importer of the packages the session imports, whose
+// export data is found by the go command of the session once it uses
+// modules.
+func (s *Session) importer() types.Importer {
+	if !s.modules {
+		return importer.Default()
+	}
+	return importer.ForCompiler(token.NewFileSet(), "gc", s.lookupExport)
+}
+
+// lookupExport opens the export data of the package at path, as built by the
+// go command of the session. The packages of its module context are stamped,
+// for their export data to be dropped once their files change.
+func (s *Session) lookupExport(path string) (io.ReadCloser, error) {
+	file, ok := s.exports[path]
+	if !ok {
+		var stderr bytes.Buffer
+		cmd := s.GoCommand("list", "-export", "-f", "{{.Export}}\n{{.Dir}}", path)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("go list %s: %s", path, absolutePaths(s.Dir(), strings.TrimSpace(stderr.String())))
+		}
+		lines := strings.Split(strings.TrimRight(string(out), "\r\n"), "\n")
+		file = strings.TrimSpace(lines[0])
+		if file == "" {
+			return nil, fmt.Errorf("no export data for %s", path)
+		}
+		s.exports[path] = file
+		if len(lines) > 1 {
+			s.stampLocal(path, strings.TrimSpace(lines[1]))
+		}
+	}
+	return os.Open(file)
+}
+
+// Module is a module the session requires.
+type Module struct {
+	Path    string
+	Version string
+}
+
+// ModuleList returns the modules the session requires, directly or not, with
+// their versions, sorted by path; the session module itself, those standing
+// for packages in GOPATH, and those of its module context, are left out.
+func (s *Session) ModuleList() ([]Module, error) {
+	if !s.modules {
+		return nil, nil
+	}
+	var stderr bytes.Buffer
+	cmd := s.GoCommand("list", "-m", "-f", "{{.Path}} {{.Version}}{{with .Replace}} {{.Path}}{{end}}", "all")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -m all: %s", strings.TrimSpace(stderr.String()))
+	}
+	var mods []Module
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] == sessionModule || s.inContext(fields[0]) {
+			continue
+		}
+		if len(fields) > 2 && strings.HasPrefix(fields[2], "./"+gopathShims+"/") {
+			continue
+		}
+		m := Module{Path: fields[0]}
+		if len(fields) > 1 {
+			m.Version = fields[1]
+		}
+		mods = append(mods, m)
+	}
+	sort.Slice(mods, func(i, j int) bool { return mods[i].Path < mods[j].Path })
+	return mods, nil
+}
+
+// packageDir returns the directory of the source of the package at path, as
+// found by the go command once the session uses modules, or "" if it cannot
+// be found.
+func (s *Session) packageDir(path string) string {
+	if s.modules {
+		out, err := s.GoCommand("list", "-f", "{{.Dir}}", path).Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+	p, err := build.Import(path, "", build.FindOnly)
+	if err != nil {
+		return ""
+	}
+	return p.Dir
+}
@@ -39,3 +39,16 @@ func normalizeNodePos(node ast.Node) {
 		return true
 	})
 }
+
+// lineAfter returns a position on the line after that of pos, in a file of its
+// own added to fset.
+func lineAfter(fset *token.FileSet, pos token.Pos) token.Pos {
+	n := fset.Position(pos).Line + 1
+	lines := make([]int, n)
+	for i := range lines {
+		lines[i] = i
+	}
+	f := fset.AddFile("", -1, n)
+	f.SetLines(lines)
+	return f.Pos(n - 1)
+}
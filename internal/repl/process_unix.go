@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package replpkg
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes cmd run in a process group of its own, for
+// killProcessGroup to kill it along with the processes it starts, such as the
+// program go run builds.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the process group of cmd.
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -21,6 +21,7 @@ quickFixAttempt:
 	for i := 0; i < maxAttempts; i++ {
 		s.TypeInfo = types.Info{
 			Types: make(map[ast.Expr]types.TypeAndValue),
+			Uses:  make(map[*ast.Ident]types.Object),
 		}
 
 		files := s.ExtraFiles
@@ -179,6 +180,50 @@ var pureNotBuiltinFuncNames = map[string]bool{
 	"Printf":  true,
 }
 
+// pureRuntimeFuncNames are the functions of the runtime package, and of the
+// packages next to it, by import path, which only publish output. The calls
+// to them that earlier cells made are not run again.
+var pureRuntimeFuncNames = map[string]map[string]bool{
+	runtimePkg: {
+		"Display":      true,
+		"DisplayData":  true,
+		"HTML":         true,
+		"Markdown":     true,
+		"Latex":        true,
+		"SVG":          true,
+		"JSON":         true,
+		"ClearOutput":  true,
+		"DisplayFile":  true,
+		"Audio":        true,
+		"AudioFile":    true,
+		"AudioSamples": true,
+		"Video":        true,
+		"VideoFile":    true,
+		"VegaLite":     true,
+		"IFrame":       true,
+		"JavaScript":   true,
+		"Hexdump":      true,
+		"Table":        true,
+		"CSV":          true,
+		"CSVFile":      true,
+		"Whos":         true,
+	},
+	runtimePkg + "/gonumplot": {
+		"ShowPlot": true,
+	},
+}
+
+// isPureRuntimeFunc reports whether name, the selector of a call, is one of
+// pureRuntimeFuncNames, as resolved by the last type check of the session:
+// methods, and functions of other packages, of the same name are not.
+func (s *Session) isPureRuntimeFunc(name *ast.Ident) bool {
+	fn, ok := s.TypeInfo.Uses[name].(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Type().(*types.Signature).Recv() != nil {
+		return false
+	}
+	return pureRuntimeFuncNames[fn.Pkg().Path()][fn.Name()]
+}
+
 // isPureExpr checks if an expression expr is "pure", which means
 // removing this expression will no affect the entire program.
 // - identifiers ("x")
@@ -224,7 +269,7 @@ func (s *Session) isPureExpr(expr ast.Expr) bool {
 
 		if !tv.IsBuiltin() {
 			if selectorExpr, ok := expr.Fun.(*ast.SelectorExpr); ok {
-				if pureNotBuiltinFuncNames[selectorExpr.Sel.Name] {
+				if pureNotBuiltinFuncNames[selectorExpr.Sel.Name] || s.isPureRuntimeFunc(selectorExpr.Sel) {
 					return true
 				}
 			}
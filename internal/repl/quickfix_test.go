@@ -0,0 +1,69 @@
+package replpkg
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRuntime imports a runtime package declaring a Table function and a
+// type with a Display method, in place of the real one.
+type fakeRuntime struct{}
+
+func (fakeRuntime) Import(path string) (*types.Package, error) {
+	pkg := types.NewPackage(path, "gophernotes")
+	v := types.NewParam(token.NoPos, pkg, "v", types.NewInterfaceType(nil, nil))
+	sig := types.NewSignatureType(nil, nil, nil, types.NewTuple(v), nil, false)
+	pkg.Scope().Insert(types.NewFunc(token.NoPos, pkg, "Table", sig))
+	handle := types.NewNamed(types.NewTypeName(token.NoPos, pkg, "Handle", nil), types.NewStruct(nil, nil), nil)
+	recv := types.NewParam(token.NoPos, pkg, "h", handle)
+	handle.AddMethod(types.NewFunc(token.NoPos, pkg, "Display", types.NewSignatureType(recv, nil, nil, types.NewTuple(v), nil, false)))
+	pkg.Scope().Insert(handle.Obj())
+	pkg.MarkComplete()
+	return pkg, nil
+}
+
+// TestIsPureExpr_runtimeFuncs tests that only the calls to the functions of
+// the runtime package that publish output are pure, not those to methods or
+// functions of the same names
+func TestIsPureExpr_runtimeFuncs(t *testing.T) {
+	src := `package main
+
+import rt "` + runtimePkg + `"
+
+type enc struct{}
+
+func (enc) Table(v interface{}) {}
+
+func main() {
+	var e enc
+	var h rt.Handle
+	rt.Table(1)
+	e.Table(1)
+	h.Display(1)
+}
+`
+	s := &Session{Fset: token.NewFileSet()}
+	f, err := parser.ParseFile(s.Fset, "main.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.TypeInfo = types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	config := types.Config{Importer: fakeRuntime{}}
+	if _, err := config.Check("main", s.Fset, []*ast.File{f}, &s.TypeInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	var pure []bool
+	for _, stmt := range f.Scope.Lookup("main").Decl.(*ast.FuncDecl).Body.List[2:] {
+		pure = append(pure, s.isPureExpr(stmt.(*ast.ExprStmt).X))
+	}
+	assert.Equal(t, []bool{true, false, false}, pure)
+}
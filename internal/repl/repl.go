@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"go/ast"
 	"go/build"
@@ -24,9 +26,16 @@ import (
 	"golang.org/x/tools/imports"
 
 	"github.com/motemen/go-quickfix"
+
+	"github.com/gopherds/gophernotes/internal/trace"
 )
 
-const printerName = "__gophernotes"
+const printerName = trace.PrinterName
+
+// runtimePkg is the package through which cell code publishes rich output. It
+// is imported into every session in which it is available, and can be
+// imported explicitly as "gophernotes".
+const runtimePkg = "github.com/gopherds/gophernotes/gophernotes"
 
 // Session encodes info about the current REPL session.
 type Session struct {
@@ -38,14 +47,67 @@ type Session struct {
 	ExtraFilePaths []string
 	ExtraFiles     []*ast.File
 
+	// Env holds extra environment variables, in "key=value" form, for the
+	// process running the session code.
+	Env []string
+
+	// Stdout, if set, gets the standard output of the process running the
+	// session code as it is written, rather than Run returning it. A Write
+	// that blocks holds back the process once the pipe between them is
+	// full.
+	Stdout io.Writer
+
+	// Timeout, if not zero, is how long the process running the session
+	// code is given, go run building it included; once it is over, the
+	// process is killed along with those it started, and Run fails with a
+	// timeoutError.
+	Timeout time.Duration
+
 	mainBody         *ast.BlockStmt
 	storedBodyLength int
+
+	// runtime is set if the session imports runtimePkg.
+	runtime bool
+
+	// lastSource is the session file as of the last run.
+	lastSource string
+
+	// initialSource is the session file before any cell ran, which Reset
+	// returns to.
+	initialSource string
+
+	// forgotten counts the names Forget removed.
+	forgotten int
+
+	// modules is set once the session is a module, its requirements in the
+	// go.mod of its directory.
+	modules bool
+
+	// context is the module context the imports of the session resolve in,
+	// with the session, in the go.work of its directory.
+	context ModuleContext
+
+	// exports caches the export data files of the packages of a session
+	// using modules, by import path.
+	exports map[string]string
+
+	// local holds the packages of the module context whose export data is
+	// cached, by import path.
+	local map[string]localPackage
+
+	// generation counts the evaluations, which may change the session.
+	generation int
+
+	// typed caches the session as last type checked for completion.
+	typed typedSession
 }
 
 const initialSourceTemplate = `
 package main
 
-import %q
+import (
+	%s
+)
 
 func ` + printerName + `(xx ...interface{}) {
 	for _, x := range xx {
@@ -58,18 +120,27 @@ func main() {
 `
 
 // printerPkgs is a list of packages that provides
-// pretty printing function.
+// pretty printing function. Sessions importing the runtime package pass the
+// text formatted by text to it, rather than printing it with code, for the
+// kernel to tell results from the output of the cell.
 var printerPkgs = []struct {
 	path string
 	code string
+	text string
 }{
-	{"github.com/k0kubun/pp", `pp.Println(x)`},
-	{"github.com/davecgh/go-spew/spew", `spew.Printf("%#v\n", x)`},
-	{"fmt", `fmt.Printf("%#v\n", x)`},
+	{"github.com/k0kubun/pp", `pp.Println(x)`, `pp.Sprintln(x)`},
+	{"github.com/davecgh/go-spew/spew", `spew.Printf("%#v\n", x)`, `spew.Sprintf("%#v\n", x)`},
+	{"fmt", `fmt.Printf("%#v\n", x)`, `fmt.Sprintf("%#v\n", x)`},
 }
 
 // NewSession initiates a new REPL
 func NewSession() (*Session, error) {
+	return NewSessionIn("")
+}
+
+// NewSessionIn initiates a new REPL whose directory is made in dir, or in the
+// default directory for temporary files if dir is "".
+func NewSessionIn(dir string) (*Session, error) {
 
 	s := &Session{
 		Fset: token.NewFileSet(),
@@ -79,16 +150,32 @@ func NewSession() (*Session, error) {
 	}
 
 	var err error
-	s.FilePath, err = tempFile()
+	s.FilePath, err = tempFile(dir)
 	if err != nil {
 		return nil, err
 	}
 
+	// Values with a rich representation are published by the runtime
+	// package, the others are printed, through it if the session imports
+	// it.
+	var extraImports, resultCode string
+	if _, err := importer.Default().Import(runtimePkg); err == nil {
+		s.runtime = true
+		extraImports = fmt.Sprintf("\n\t%q", runtimePkg)
+		resultCode = "if gophernotes.Result(x) {\n\t\t\tcontinue\n\t\t}\n\t\t"
+	} else {
+		debugf("could not import %q: %s", runtimePkg, err)
+	}
+
 	var initialSource string
 	for _, pp := range printerPkgs {
 		_, err := importer.Default().Import(pp.path)
 		if err == nil {
-			initialSource = fmt.Sprintf(initialSourceTemplate, pp.path, pp.code)
+			code := pp.code
+			if s.runtime {
+				code = "gophernotes.PrintResult(" + pp.text + ")"
+			}
+			initialSource = fmt.Sprintf(initialSourceTemplate, fmt.Sprintf("%q", pp.path)+extraImports, resultCode+code)
 			break
 		}
 		debugf("could not import %q: %s", pp.path, err)
@@ -101,6 +188,7 @@ func NewSession() (*Session, error) {
 	if err != nil {
 		return nil, err
 	}
+	s.initialSource = initialSource
 
 	s.mainBody = s.mainFunc().Body
 
@@ -113,22 +201,55 @@ func (s *Session) mainFunc() *ast.FuncDecl {
 
 // Run calls "go run" with appropriate files appended.
 func (s *Session) Run() ([]byte, bytes.Buffer, error) {
-	f, err := os.Create(s.FilePath)
+	s.spreadMainBody()
+
+	var buf bytes.Buffer
+	err := printer.Fprint(&buf, s.Fset, s.File)
 	if err != nil {
 		return nil, bytes.Buffer{}, err
 	}
 
-	err = printer.Fprint(f, s.Fset, s.File)
+	err = ioutil.WriteFile(s.FilePath, buf.Bytes(), 0666)
 	if err != nil {
 		return nil, bytes.Buffer{}, err
 	}
+	s.lastSource = buf.String()
+
+	files := append(s.ExtraFilePaths, s.FilePath)
+	if s.modules {
+		return s.buildAndRun(files)
+	}
+	return goRun(files, s.Env, s.Stdout, s.Timeout)
+}
+
+// spreadMainBody moves the closing brace of the main function to the line
+// after its opening one if they are on the same line. A short main function is
+// printed on a single line when its braces are, as resetting its positions
+// leaves them. Printing a statement per line keeps the line numbers in stack
+// traces meaningful.
+func (s *Session) spreadMainBody() {
+	body := s.mainBody
+	if s.Fset.Position(body.Lbrace).Line == s.Fset.Position(body.Rbrace).Line {
+		body.Rbrace = lineAfter(s.Fset, body.Lbrace)
+	}
+}
+
+// Runtime reports whether the session imports the gophernotes package, through
+// which cell code publishes rich output.
+func (s *Session) Runtime() bool {
+	return s.runtime
+}
 
-	return goRun(append(s.ExtraFilePaths, s.FilePath))
+// LastSource returns the session file as it was when the session last ran, so
+// that the positions in compiler errors and stack traces can be looked up.
+func (s *Session) LastSource() string {
+	return s.lastSource
 }
 
-// tempFile prepares the temporary session file for the REPL.
-func tempFile() (string, error) {
-	dir, err := ioutil.TempDir("", "")
+// tempFile prepares the temporary session file for the REPL, in a directory
+// made in parent.
+func tempFile(parent string) (string, error) {
+	dir, err := ioutil.TempDir(parent, "")
 	if err != nil {
 		return "", err
 	}
@@ -141,16 +262,42 @@ func tempFile() (string, error) {
 	return filepath.Join(dir, "gophernotes_session.go"), nil
 }
 
-func goRun(files []string) ([]byte, bytes.Buffer, error) {
+func goRun(files []string, env []string, stdout io.Writer, timeout time.Duration) ([]byte, bytes.Buffer, error) {
 
 	var stderr bytes.Buffer
 
 	args := append([]string{"run"}, files...)
 	debugf("go %s", strings.Join(args, " "))
 	cmd := exec.Command("go", args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
 	cmd.Stderr = &stderr
-	out, err := cmd.Output()
+	out, err := output(cmd, timeout)
+	return out, stderr, err
+}
+
+// buildAndRun builds files in the module of the session, and runs the program
+// in the working directory of the kernel, as go run would but for the module
+// being that of the session directory.
+func (s *Session) buildAndRun(files []string) ([]byte, bytes.Buffer, error) {
+	var stderr bytes.Buffer
+
+	bin := filepath.Join(s.Dir(), "gophernotes_session.bin")
+	compile := s.GoCommand(append([]string{"build", "-o", bin}, files...)...)
+	compile.Stderr = &stderr
+	if err := compile.Run(); err != nil {
+		return nil, *bytes.NewBufferString(absolutePaths(s.Dir(), stderr.String())), err
+	}
+
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), s.Env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = s.Stdout
+	cmd.Stderr = &stderr
+	out, err := output(cmd, s.Timeout)
 	return out, stderr, err
 }
 
@@ -222,6 +369,9 @@ func (s *Session) evalStmt(in string, noPrint bool) error {
 			}
 		}
 
+		// The declarations are in the extra file; what failed to parse
+		// as statements is not kept.
+		return nil
 	}
 
 	enclosingFunc := f.Scope.Lookup("F").Decl.(*ast.FuncDecl)
@@ -316,10 +466,71 @@ func (s *Session) reset() error {
 // Eval handles the evaluation of code parsed from received messages
 func (s *Session) Eval(in string) (string, bytes.Buffer, error) {
 	debugf("eval >>> %q", in)
+	s.generation++
+	s.dropStale()
 
 	s.clearQuickFix()
 	s.storeMainBody()
 
+	in, err := s.runCommands(in)
+	if err != nil {
+		return "", bytes.Buffer{}, err
+	}
+	if len(in) == 0 {
+		s.doQuickFix()
+		return "", bytes.Buffer{}, nil
+	}
+
+	// Extract statements.
+	priorListLength := len(s.mainBody.List)
+	if err := s.separateEvalStmt(in); err != nil {
+		return "", *bytes.NewBuffer([]byte(err.Error())), err
+	}
+
+	s.doQuickFix()
+	s.markCellStart(priorListLength)
+	s.markCellEnd()
+
+	output, stderr, runErr := s.Run()
+	if runErr != nil || stderr.String() != "" {
+		if exitErr, ok := runErr.(*exec.ExitError); ok {
+			// if failed with status 2, remove the last statement
+			if st, ok := exitErr.ProcessState.Sys().(syscall.WaitStatus); ok {
+				if st.ExitStatus() == 2 {
+					debugf("got exit status 2, popping out last input")
+					s.restoreMainBody()
+					runErr = nil
+				}
+			}
+		}
+	}
+
+	// Cleanup the session file.
+	s.mainBody.List = s.mainBody.List[0:priorListLength]
+	if err := s.cleanEvalStmt(in); err != nil {
+		return string(output), stderr, err
+	}
+	f, err := os.Create(s.FilePath)
+	if err != nil {
+		return string(output), stderr, err
+	}
+	err = printer.Fprint(f, s.Fset, s.File)
+	if err != nil {
+		return string(output), stderr, err
+	}
+
+	// Catch any unexpected stderr.
+	if stderr.String() != "" {
+		runErr = errors.New("Unexpected stderr from execution")
+	}
+
+	return string(output), stderr, runErr
+}
+
+// runCommands applies the special commands of the lines of in, such as
+// :import, and returns the other lines, joined back together for evaluation.
+// It only fails with ErrQuit, the commands logging their errors.
+func (s *Session) runCommands(in string) (string, error) {
 	// Split the lines of the input to check for special commands.
 	inLines := strings.Split(in, "\n")
 	var nonImportLines []string
@@ -366,7 +577,7 @@ func (s *Session) Eval(in string) (string, bytes.Buffer, error) {
 					_, err := command.action(s, arg)
 					if err != nil {
 						if err == ErrQuit {
-							return "", bytes.Buffer{}, err
+							return "", err
 						}
 						errorf("%s: %s", command.name, err.Error())
 					}
@@ -375,55 +586,91 @@ func (s *Session) Eval(in string) (string, bytes.Buffer, error) {
 		}
 	}
 
-	// Join the non-special lines back together for evaluation.
-	in = strings.Join(nonImportLines, "\n")
-	if len(in) == 0 {
-		s.doQuickFix()
-		return "", bytes.Buffer{}, nil
+	return strings.Join(nonImportLines, "\n"), nil
+}
+
+// Record adds the code of a cell to the session as Eval does, but without
+// running it, for the session to go on from code that ran elsewhere, as that
+// of a subshell does in a fork of the session.
+func (s *Session) Record(in string) error {
+	s.generation++
+	s.dropStale()
+
+	s.clearQuickFix()
+	s.storeMainBody()
+	in, err := s.runCommands(in)
+	if err != nil {
+		return err
+	}
+	if len(in) > 0 {
+		if err := s.cleanEvalStmt(in); err != nil {
+			s.restoreMainBody()
+			return err
+		}
 	}
+	s.doQuickFix()
 
-	// Extract statements.
-	priorListLength := len(s.mainBody.List)
-	if err := s.separateEvalStmt(in); err != nil {
-		return "", *bytes.NewBuffer([]byte(err.Error())), err
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, s.Fset, s.File); err != nil {
+		return err
 	}
+	return ioutil.WriteFile(s.FilePath, buf.Bytes(), 0666)
+}
 
+// Replay runs the code of the session again, with the marker of markCellStart
+// after the code of every cell, so that all of its output is dropped and what
+// runs once the marker is reached is not. The kernel replays the session to
+// deliver comm messages from the frontend to cell code.
+func (s *Session) Replay() (string, bytes.Buffer, error) {
+	s.clearQuickFix()
 	s.doQuickFix()
+	end := len(s.mainBody.List)
+	s.markCellStart(end)
 
-	output, stderr, runErr := s.Run()
-	if runErr != nil || stderr.String() != "" {
-		if exitErr, ok := runErr.(*exec.ExitError); ok {
-			// if failed with status 2, remove the last statement
-			if st, ok := exitErr.ProcessState.Sys().(syscall.WaitStatus); ok {
-				if st.ExitStatus() == 2 {
-					debugf("got exit status 2, popping out last input")
-					s.restoreMainBody()
-					runErr = nil
-				}
-			}
-		}
-	}
+	output, stderr, err := s.Run()
+	s.mainBody.List = s.mainBody.List[0:end]
+	return string(output), stderr, err
+}
 
-	// Cleanup the session file.
-	s.mainBody.List = s.mainBody.List[0:priorListLength]
-	if err := s.cleanEvalStmt(in); err != nil {
-		return string(output), stderr, err
-	}
-	f, err := os.Create(s.FilePath)
-	if err != nil {
-		return string(output), stderr, err
+// markCellStart inserts a call telling the runtime package that the statements
+// of the current cell, starting at index i of the main body, are about to run,
+// so that it can drop the output of the earlier cells being replayed. Like the
+// statements of the current cell, the call is removed once the session has run.
+func (s *Session) markCellStart(i int) {
+	if !s.runtime {
+		return
 	}
-	err = printer.Fprint(f, s.Fset, s.File)
-	if err != nil {
-		return string(output), stderr, err
+	if i > len(s.mainBody.List) {
+		i = len(s.mainBody.List)
 	}
 
-	// Catch any unexpected stderr.
-	if stderr.String() != "" {
-		runErr = errors.New("Unexpected stderr from execution")
+	stmts := append([]ast.Stmt{}, s.mainBody.List[:i]...)
+	stmts = append(stmts, runtimeCallStmt("BeginCell"))
+	s.mainBody.List = append(stmts, s.mainBody.List[i:]...)
+}
+
+// markCellEnd appends a call telling the runtime package that the statements
+// of the current cell have returned, so that it can wait for the goroutines
+// still updating the displays of the cell before the process exits. It is
+// removed once the session has run, like the marker of markCellStart.
+func (s *Session) markCellEnd() {
+	if !s.runtime {
+		return
 	}
+	s.mainBody.List = append(s.mainBody.List, runtimeCallStmt("EndCell"))
+}
 
-	return string(output), stderr, runErr
+// runtimeCallStmt returns the statement calling the function name of the
+// runtime package.
+func runtimeCallStmt(name string) ast.Stmt {
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("gophernotes"),
+				Sel: ast.NewIdent(name),
+			},
+		},
+	}
 }
 
 // separateEvalStmt separates what can be evaluated via evalExpr from what cannot.
@@ -488,8 +735,16 @@ func (s *Session) cleanEvalStmt(in string) error {
 	var stmtLines []string
 
 	inLines := strings.Split(in, "\n")
+	depths := lineDepths(in)
 
-	for _, line := range inLines {
+	for i, line := range inLines {
+
+		// Lines within a block, such as the body of a callback, are kept
+		// whatever they hold: they do not run as the cell does.
+		if depths[i] > 0 {
+			stmtLines = append(stmtLines, line)
+			continue
+		}
 
 		beforeLines := len(s.mainBody.List)
 		if expr, err := s.evalExpr(line); err == nil {
@@ -511,6 +766,39 @@ func (s *Session) cleanEvalStmt(in string) error {
 	return nil
 }
 
+// lineDepths returns, for each line of in, the number of brackets, braces and
+// parentheses open as it starts.
+func lineDepths(in string) []int {
+	depths := make([]int, strings.Count(in, "\n")+1)
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(in))
+	var sc scanner.Scanner
+	sc.Init(file, []byte(in), nil, 0)
+
+	depth, line := 0, 1
+	for {
+		pos, tok, _ := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		for l := fset.Position(pos).Line; line < l; line++ {
+			depths[line] = depth
+		}
+		switch tok {
+		case token.LBRACE, token.LPAREN, token.LBRACK:
+			depth++
+		case token.RBRACE, token.RPAREN, token.RBRACK:
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+	for ; line < len(depths); line++ {
+		depths[line] = depth
+	}
+	return depths
+}
+
 // storeMainBody stores current state of code so that it can be restored
 // actually it saves the length of statements inside main()
 func (s *Session) storeMainBody() {
@@ -547,6 +835,52 @@ func (s *Session) includeFile(file string) {
 	infof("added file %s", file)
 }
 
+// Include adds the declarations of the Go file at path to the session, as if
+// a cell declared them, along with its imports; its package clause and main
+// function, if any, are left out. The file is type checked along with the
+// session first, and its errors, with their positions in the file, keep it out
+// of the session.
+func (s *Session) Include(path string) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f, err := parser.ParseFile(s.Fset, path, src, parser.Mode(0))
+	if err != nil {
+		return err
+	}
+	f.Name.Name = "main"
+	for i, decl := range f.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok && fd.Recv == nil && isNamedIdent(fd.Name, "main") {
+			f.Decls = append(f.Decls[:i], f.Decls[i+1:]...)
+			break
+		}
+	}
+
+	if err := s.reset(); err != nil {
+		return err
+	}
+	var errs []string
+	conf := types.Config{
+		Importer: s.importer(),
+		Error: func(err error) {
+			if terr, ok := err.(types.Error); ok && s.Fset.Position(terr.Pos).Filename == path && !terr.Soft {
+				errs = append(errs, err.Error())
+			}
+		},
+	}
+	conf.Check("main", s.Fset, append([]*ast.File{s.File, f}, s.ExtraFiles...), nil)
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+
+	s.generation++
+	if err := s.importPackages(src); err != nil {
+		return err
+	}
+	return s.importFile(src)
+}
+
 // importPackages includes packages defined on external file into main file
 func (s *Session) importPackages(src []byte) error {
 	astf, err := parser.ParseFile(s.Fset, "", src, parser.Mode(0))
@@ -593,6 +927,12 @@ func (s *Session) importFile(src []byte) error {
 		}
 	}
 
+	// Declarations replace those of the same names imported before, as
+	// when a cell declaring them runs again.
+	if err := s.dropDecls(declKeys(f)); err != nil {
+		return err
+	}
+
 	out, err := os.Create(ext)
 	if err != nil {
 		return err
@@ -611,6 +951,111 @@ func (s *Session) importFile(src []byte) error {
 	return nil
 }
 
+// declKeys returns the keys of the declarations of f which dropDecls drops:
+// the names declared at the top level, and those of methods, after the name of
+// their receiver type.
+func declKeys(f *ast.File) map[string]bool {
+	keys := map[string]bool{}
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			keys[funcKey(decl)] = true
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				for _, name := range specNames(spec) {
+					keys[name] = true
+				}
+			}
+		}
+	}
+	delete(keys, "_")
+	return keys
+}
+
+// funcKey returns the key of a function declaration: its name, following that
+// of the receiver type for methods.
+func funcKey(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return decl.Name.Name
+	}
+	typ := decl.Recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	if id, ok := typ.(*ast.Ident); ok {
+		return id.Name + "." + decl.Name.Name
+	}
+	return decl.Name.Name
+}
+
+// specNames returns the names spec declares; imports declare none.
+func specNames(spec ast.Spec) []string {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		return []string{spec.Name.Name}
+	case *ast.ValueSpec:
+		var names []string
+		for _, id := range spec.Names {
+			names = append(names, id.Name)
+		}
+		return names
+	}
+	return nil
+}
+
+// dropDecls removes the declarations with keys from the extra files of the
+// session, rewriting those it changes.
+func (s *Session) dropDecls(keys map[string]bool) error {
+	for i, f := range s.ExtraFiles {
+		changed := false
+		decls := f.Decls[:0]
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if keys[funcKey(d)] {
+					changed = true
+					continue
+				}
+			case *ast.GenDecl:
+				specs := d.Specs[:0]
+				for _, spec := range d.Specs {
+					names := specNames(spec)
+					if len(names) > 0 && keys[names[0]] {
+						changed = true
+						continue
+					}
+					specs = append(specs, spec)
+				}
+				d.Specs = specs
+				if len(specs) == 0 && d.Tok != token.IMPORT {
+					continue
+				}
+			}
+			decls = append(decls, decl)
+		}
+		f.Decls = decls
+		if changed {
+			// The imports of the declarations dropped may be left unused.
+			quickfix.QuickFix(s.Fset, []*ast.File{f})
+			if err := s.writeExtraFile(i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeExtraFile writes the extra file i of the session, as changed, to its
+// path.
+func (s *Session) writeExtraFile(i int) error {
+	out, err := os.Create(s.ExtraFilePaths[i])
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return printer.Fprint(out, s.Fset, s.ExtraFiles[i])
+}
+
 // fixImports formats and adjusts imports for the current AST.
 func (s *Session) fixImports() error {
 
@@ -0,0 +1,88 @@
+package replpkg
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"os"
+	"strings"
+)
+
+// forgottenPrefix starts the names that Forget gives to the names it removes
+// from the session.
+const forgottenPrefix = "_gophernotes_forgotten"
+
+// isForgotten reports whether name is one that Forget gave.
+func isForgotten(name string) bool {
+	return strings.HasPrefix(name, forgottenPrefix)
+}
+
+// Reset clears the session of the code of the cells run so far, and of the
+// functions and types they declared, keeping the packages imported unless
+// imports is set.
+func (s *Session) Reset(imports bool) error {
+	for _, p := range s.ExtraFilePaths {
+		os.Remove(p)
+	}
+	s.ExtraFilePaths, s.ExtraFiles = nil, nil
+	s.mainBody.List = nil
+	s.generation++
+
+	if !imports {
+		return s.reset()
+	}
+	file, err := parser.ParseFile(s.Fset, "gophernotes_session.go", s.initialSource, parser.Mode(0))
+	if err != nil {
+		return err
+	}
+	s.File = file
+	s.mainBody = s.mainFunc().Body
+	return nil
+}
+
+// Forget removes the named variables, constants, functions and types, declared
+// by cells at their top level, from the session. Rather than dropping the code
+// declaring them, which later cells may depend on, Forget renames them wherever
+// they are declared and used, out of the reach of cells; the names are then
+// undefined, and free to be declared again. No name is removed unless all are
+// found.
+func (s *Session) Forget(names []string) error {
+	info, scopes, err := s.check()
+	if err != nil {
+		return err
+	}
+
+	renamed := map[types.Object]string{}
+	for _, name := range names {
+		var obj types.Object
+		if !isHelper(name) {
+			for _, sc := range scopes {
+				if o := sc.Lookup(name); o != nil {
+					obj = o
+				}
+			}
+		}
+		if obj == nil {
+			return fmt.Errorf("%s is not defined", name)
+		}
+		s.forgotten++
+		renamed[obj] = fmt.Sprintf("%s%d_%s", forgottenPrefix, s.forgotten, name)
+	}
+
+	for _, idents := range []map[*ast.Ident]types.Object{info.Defs, info.Uses} {
+		for id, obj := range idents {
+			if name, ok := renamed[obj]; ok {
+				id.Name = name
+			}
+		}
+	}
+	s.generation++
+
+	for i := range s.ExtraFiles {
+		if err := s.writeExtraFile(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package replpkg
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/types"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/imports"
+)
+
+// Share declares name, as the cells of s declared or imported it, in the
+// session to as well: a package is imported by to under the same name, and a
+// type, constant or function is declared in an extra file of to, a type along
+// with its methods, replacing what to declared of the same names there.
+// Variables hold values of the process of s, and cannot be shared. What a
+// declaration refers to is to be shared with it.
+func (s *Session) Share(name string, to *Session) error {
+	pkg, src, err := s.shared(name)
+	if err != nil {
+		return err
+	}
+	if pkg != nil {
+		return to.shareImport(name, pkg)
+	}
+	to.generation++
+	return to.importFile(src)
+}
+
+// Shareable returns the error Share would fail with for name, if any, but for
+// those of the session shared with.
+func (s *Session) Shareable(name string) error {
+	_, _, err := s.shared(name)
+	return err
+}
+
+// shared returns the package name imports, or else the source of a file
+// declaring name as the cells of s do.
+func (s *Session) shared(name string) (*types.Package, []byte, error) {
+	info, scopes, err := s.check()
+	if err != nil {
+		return nil, nil, err
+	}
+	// The packages imported are in the scopes of the files importing them.
+	var obj types.Object
+	if !isHelper(name) {
+		for _, f := range append([]*ast.File{s.File}, s.ExtraFiles...) {
+			if sc := info.Scopes[f]; sc != nil && obj == nil {
+				obj = sc.Lookup(name)
+			}
+		}
+		for _, sc := range scopes {
+			if o := sc.Lookup(name); o != nil {
+				obj = o
+			}
+		}
+	}
+	switch obj := obj.(type) {
+	case nil:
+		return nil, nil, fmt.Errorf("%s is not defined", name)
+	case *types.Var:
+		return nil, nil, fmt.Errorf("%s is a variable: only packages, types, constants and functions can be shared", name)
+	case *types.PkgName:
+		return obj.Imported(), nil, nil
+	}
+
+	decls := s.declsOf(info, obj)
+	if len(decls) == 0 {
+		return nil, nil, fmt.Errorf("the declaration of %s is not found", name)
+	}
+	var src bytes.Buffer
+	src.WriteString("package main\n")
+	seen := map[string]bool{}
+	for _, f := range append([]*ast.File{s.File}, s.ExtraFiles...) {
+		for _, spec := range f.Imports {
+			var imp bytes.Buffer
+			if err := printer.Fprint(&imp, s.Fset, spec); err != nil {
+				return nil, nil, err
+			}
+			if !seen[imp.String()] {
+				seen[imp.String()] = true
+				src.WriteString("\nimport " + imp.String())
+			}
+		}
+	}
+	for _, decl := range decls {
+		src.WriteString("\n\n")
+		if err := printer.Fprint(&src, s.Fset, decl); err != nil {
+			return nil, nil, err
+		}
+	}
+	src.WriteString("\n")
+
+	// The imports the declarations do not use are dropped.
+	formatted, err := imports.Process("", src.Bytes(), nil)
+	return nil, formatted, err
+}
+
+// declsOf returns the declarations of obj, whose names info defines, as top
+// level declarations: that of the function, or the type or constant spec,
+// followed by the methods of the type.
+func (s *Session) declsOf(info *types.Info, obj types.Object) []ast.Decl {
+	var decls []ast.Decl
+	defines := func(id *ast.Ident) bool {
+		return info.Defs[id] == obj
+	}
+	for _, f := range append([]*ast.File{s.File}, s.ExtraFiles...) {
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncDecl:
+				if defines(n.Name) {
+					decls = append(decls, n)
+				}
+			case *ast.GenDecl:
+				for _, spec := range n.Specs {
+					var ids []*ast.Ident
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						ids = []*ast.Ident{spec.Name}
+					case *ast.ValueSpec:
+						ids = spec.Names
+					}
+					for _, id := range ids {
+						if defines(id) {
+							decls = append(decls, &ast.GenDecl{Tok: n.Tok, Specs: []ast.Spec{spec}})
+							break
+						}
+					}
+				}
+			}
+			return true
+		})
+	}
+	if _, ok := obj.(*types.TypeName); !ok {
+		return decls
+	}
+	for _, f := range s.ExtraFiles {
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv != nil && funcKey(fn) == obj.Name()+"."+fn.Name.Name {
+				decls = append(decls, fn)
+			}
+		}
+	}
+	return decls
+}
+
+// shareImport imports pkg in the session under name.
+func (s *Session) shareImport(name string, pkg *types.Package) error {
+	if _, err := s.importer().Import(pkg.Path()); err != nil {
+		return err
+	}
+	alias := ""
+	if name != pkg.Name() {
+		alias = name
+	}
+	if astutil.AddNamedImport(s.Fset, s.File, alias, pkg.Path()) {
+		resetPackages()
+	}
+	s.generation++
+	return nil
+}
@@ -0,0 +1,133 @@
+package replpkg
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Snapshot returns a copy of the session as it is, for completion and
+// inspection to read while cells run and change the session. The copy holds
+// a parse of its own of the session files, and shares nothing the session
+// changes; it is not to run cells.
+func (s *Session) Snapshot() (*Session, error) {
+	c := &Session{
+		FilePath:         s.FilePath,
+		Fset:             token.NewFileSet(),
+		Types:            s.Types,
+		ExtraFilePaths:   append([]string(nil), s.ExtraFilePaths...),
+		Env:              append([]string(nil), s.Env...),
+		storedBodyLength: s.storedBodyLength,
+		runtime:          s.runtime,
+		lastSource:       s.lastSource,
+		initialSource:    s.initialSource,
+		forgotten:        s.forgotten,
+		modules:          s.modules,
+		context:          s.context,
+		exports:          map[string]string{},
+		local:            map[string]localPackage{},
+		generation:       s.generation,
+	}
+	for path, export := range s.exports {
+		c.exports[path] = export
+	}
+	for path, p := range s.local {
+		c.local[path] = p
+	}
+
+	var err error
+	if c.File, err = reparse(c.Fset, s.Fset, "gophernotes_session.go", s.File); err != nil {
+		return nil, err
+	}
+	c.mainBody = c.mainFunc().Body
+	for i, f := range s.ExtraFiles {
+		ef, err := reparse(c.Fset, s.Fset, s.ExtraFilePaths[i], f)
+		if err != nil {
+			return nil, err
+		}
+		c.ExtraFiles = append(c.ExtraFiles, ef)
+	}
+	return c, nil
+}
+
+// Fork returns a copy of the session in a directory of its own, for cells to
+// run in while those of the session run too, as those of subshells do. Like a
+// snapshot, the copy shares nothing the session changes, and what its cells
+// declare is not declared in the session. The directory of the copy, made next
+// to that of the session, is to be removed once it is done with.
+func (s *Session) Fork() (*Session, error) {
+	c, err := s.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(filepath.Dir(s.Dir()), "fork")
+	if err != nil {
+		return nil, err
+	}
+	c.FilePath = filepath.Join(dir, filepath.Base(s.FilePath))
+	for i, path := range c.ExtraFilePaths {
+		c.ExtraFilePaths[i] = filepath.Join(dir, filepath.Base(path))
+	}
+	c.Timeout = s.Timeout
+	c.Types = &types.Config{Importer: c.importer()}
+
+	if err := s.writeFork(c); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return c, nil
+}
+
+// writeFork writes the files of c, a fork of the session, to its directory.
+func (s *Session) writeFork(c *Session) error {
+	files := append([]*ast.File{c.File}, c.ExtraFiles...)
+	paths := append([]string{c.FilePath}, c.ExtraFilePaths...)
+	for i, f := range files {
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, c.Fset, f); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(paths[i], buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+
+	// The requirements of a session using modules go along, the packages
+	// it shims from GOPATH linked to rather than shimmed again.
+	if c.modules {
+		for _, name := range []string{"go.mod", "go.sum", "go.work"} {
+			b, err := ioutil.ReadFile(filepath.Join(s.Dir(), name))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err == nil {
+				err = ioutil.WriteFile(filepath.Join(c.Dir(), name), b, 0644)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := os.Stat(filepath.Join(s.Dir(), gopathShims)); err == nil {
+			if err := os.Symlink(filepath.Join(s.Dir(), gopathShims), filepath.Join(c.Dir(), gopathShims)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reparse prints f, whose positions are in fset, and parses it again as the
+// file name, its positions added to to.
+func reparse(to, fset *token.FileSet, name string, f *ast.File) (*ast.File, error) {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return parser.ParseFile(to, name, buf.Bytes(), parser.Mode(0))
+}
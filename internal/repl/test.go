@@ -0,0 +1,144 @@
+package replpkg
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/motemen/go-quickfix"
+)
+
+// testDir is the directory, within that of the session, holding the package
+// TestCommand tests.
+const testDir = "gophernotes_test"
+
+// TestFuncs returns the names of the test functions the session declares, in
+// the order they are declared.
+func (s *Session) TestFuncs() []string {
+	var names []string
+	for _, f := range append([]*ast.File{s.File}, s.ExtraFiles...) {
+		for _, decl := range f.Decls {
+			if isTestFunc(decl) {
+				names = append(names, decl.(*ast.FuncDecl).Name.Name)
+			}
+		}
+	}
+	return names
+}
+
+// isTestFunc reports whether decl declares a test function, such as
+// TestParse(t *testing.T), as go test finds them.
+func isTestFunc(decl ast.Decl) bool {
+	fn, ok := decl.(*ast.FuncDecl)
+	if !ok || fn.Recv != nil || !strings.HasPrefix(fn.Name.Name, "Test") {
+		return false
+	}
+	if fn.Type.Params == nil || len(fn.Type.Params.List) != 1 || len(fn.Type.Params.List[0].Names) > 1 {
+		return false
+	}
+	if rest := fn.Name.Name[len("Test"):]; rest != "" {
+		r, _ := utf8.DecodeRuneInString(rest)
+		return !unicode.IsLower(r)
+	}
+	return true
+}
+
+// TestCommand returns the go test command running the test functions of the
+// session with flags. The package tested is made of the files of the session,
+// its main function included, which does not run, with the test functions
+// moved to test files for go test to find them. Like the session, it is built
+// in the module of the session once it uses modules.
+func (s *Session) TestCommand(flags []string) (*exec.Cmd, error) {
+	source, err := s.spreadSource()
+	if err != nil {
+		return nil, err
+	}
+	sources := map[string]string{"gophernotes_session.go": source}
+	names := []string{"gophernotes_session.go"}
+	for _, path := range s.ExtraFilePaths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sources[filepath.Base(path)] = string(src)
+		names = append(names, filepath.Base(path))
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range names {
+		f, tests, err := splitTestFuncs(fset, name, sources[name])
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		if tests != nil {
+			files = append(files, tests)
+		}
+	}
+	quickfix.QuickFix(fset, files)
+
+	dir := filepath.Join(s.Dir(), testDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	args := append([]string{"test"}, flags...)
+	for _, f := range files {
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, f); err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, fset.Position(f.Package).Filename)
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+		args = append(args, path)
+	}
+	return s.GoCommand(args...), nil
+}
+
+// splitTestFuncs parses src, the source of the file name, into the file
+// without its test functions, and a test file holding them, which is nil if
+// there are none. Both keep the imports of the file, so that either has those
+// it needs; the others are to be blanked out by the quick fix.
+func splitTestFuncs(fset *token.FileSet, name, src string) (f, tests *ast.File, err error) {
+	f, err = parser.ParseFile(fset, name, src, parser.Mode(0))
+	if err != nil {
+		return nil, nil, err
+	}
+	var decls []ast.Decl
+	for _, decl := range f.Decls {
+		if !isTestFunc(decl) {
+			decls = append(decls, decl)
+		}
+	}
+	if len(decls) == len(f.Decls) {
+		return f, nil, nil
+	}
+	f.Decls, f.Comments = decls, nil
+
+	tests, err = parser.ParseFile(fset, strings.TrimSuffix(name, ".go")+"_test.go", src, parser.Mode(0))
+	if err != nil {
+		return nil, nil, err
+	}
+	decls = nil
+	for _, decl := range tests.Decls {
+		if gen, ok := decl.(*ast.GenDecl); (ok && gen.Tok == token.IMPORT) || isTestFunc(decl) {
+			decls = append(decls, decl)
+		}
+	}
+	tests.Decls, tests.Comments = decls, nil
+	return f, tests, nil
+}
@@ -0,0 +1,42 @@
+package replpkg
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// timeoutError is the error of the runs of the session killed for taking
+// longer than its timeout.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("the cell was stopped after running for %v", e.timeout)
+}
+
+// output runs cmd and returns its standard output, as cmd.Output does, unless
+// cmd.Stdout is already set, killing it along with the processes it started
+// once timeout is over, if it is not 0.
+func output(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	var stdout bytes.Buffer
+	if cmd.Stdout == nil {
+		cmd.Stdout = &stdout
+	}
+	if timeout <= 0 {
+		err := cmd.Run()
+		return stdout.Bytes(), err
+	}
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	timer := time.AfterFunc(timeout, func() { killProcessGroup(cmd) })
+	err := cmd.Wait()
+	if !timer.Stop() {
+		return stdout.Bytes(), timeoutError{timeout}
+	}
+	return stdout.Bytes(), err
+}
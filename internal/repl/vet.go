@@ -0,0 +1,104 @@
+package replpkg
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/motemen/go-quickfix"
+)
+
+// vetCellFile is the name of the file holding the declarations of the cell
+// vetted, if it declares rather than runs statements.
+const vetCellFile = "gophernotes_cell.go"
+
+// spreadSource returns the source of the session, of which the statements of
+// the main function go on lines of their own even when it is short, as with
+// Run.
+func (s *Session) spreadSource() (string, error) {
+	normalizeNodePos(s.mainFunc())
+	s.spreadMainBody()
+	var buf bytes.Buffer
+	err := (&printer.Config{Tabwidth: 8}).Fprint(&buf, s.Fset, s.File)
+	return buf.String(), err
+}
+
+// Vet runs go vet with flags, which select its analyzers, over the session
+// along with code, the code of a cell that is not run: its statements follow
+// those of the session in the main function, unless it is made of
+// declarations. It returns what go vet reported, and the sources of the files
+// it ran over by name, so that the positions reported can be looked up.
+func (s *Session) Vet(code string, flags []string) (report string, sources map[string]string, err error) {
+	source, err := s.spreadSource()
+	if err != nil {
+		return "", nil, err
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	var names []string
+
+	cell, err := parser.ParseFile(fset, vetCellFile, "package main\n"+code, parser.Mode(0))
+	if err != nil {
+		cell = nil
+		main, err := parser.ParseFile(fset, "", source, parser.Mode(0))
+		if err != nil {
+			return "", nil, err
+		}
+		rbrace := fset.Position(main.Scope.Lookup("main").Decl.(*ast.FuncDecl).Body.Rbrace).Offset
+		source = source[:rbrace] + code + "\n" + source[rbrace:]
+	}
+	f, err := parser.ParseFile(fset, "gophernotes_session.go", source, parser.Mode(0))
+	if err != nil {
+		return "", nil, err
+	}
+	files, names = append(files, f), append(names, "gophernotes_session.go")
+	if cell != nil && strings.TrimSpace(code) != "" {
+		files, names = append(files, cell), append(names, vetCellFile)
+	}
+	for _, path := range s.ExtraFilePaths {
+		f, err := parser.ParseFile(fset, path, nil, parser.Mode(0))
+		if err != nil {
+			return "", nil, err
+		}
+		files, names = append(files, f), append(names, filepath.Base(path))
+	}
+
+	// Like cells, the code vetted may leave names unused.
+	quickfix.QuickFix(fset, files)
+
+	dir := filepath.Join(s.Dir(), "gophernotes_vet")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", nil, err
+	}
+	defer os.RemoveAll(dir)
+	sources = map[string]string{}
+	args := append([]string{"vet"}, flags...)
+	for i, f := range files {
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, f); err != nil {
+			return "", nil, err
+		}
+		path := filepath.Join(dir, names[i])
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return "", nil, err
+		}
+		sources[names[i]] = buf.String()
+		args = append(args, path)
+	}
+
+	out, err := s.GoCommand(args...).CombinedOutput()
+	if err != nil && len(out) == 0 {
+		return "", nil, err
+	}
+	if err != nil && !bytes.Contains(out, []byte(".go:")) {
+		return "", nil, errors.New(strings.TrimSpace(string(out)))
+	}
+	return string(out), sources, nil
+}
@@ -0,0 +1,336 @@
+package replpkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ModuleContext is the module, or the workspace of modules, in which the
+// imports of the cells of a session resolve, along with the modules the
+// session requires: that of the working directory of the kernel, as the go
+// command finds it.
+type ModuleContext struct {
+	// File is the go.work or the go.mod of the context, or "" if there is
+	// none.
+	File string
+
+	// Go is the version of Go the file asks for, Dirs are the directories
+	// of the modules of the context, Paths their module paths, and Replace
+	// the replace directives of a go.work, their directories made absolute.
+	Go      string
+	Dirs    []string
+	Paths   []string
+	Replace []string
+}
+
+// Workspace reports whether the context is that of a go.work.
+func (c ModuleContext) Workspace() bool {
+	return filepath.Base(c.File) == "go.work"
+}
+
+// String describes the context, as "the workspace of" its go.work or "the
+// module of" its go.mod.
+func (c ModuleContext) String() string {
+	switch {
+	case c.File == "":
+		return "no module"
+	case c.Workspace():
+		return "the workspace of " + c.File
+	}
+	return "the module of " + c.File
+}
+
+// FindModuleContext returns the module context of dir: that of the go.work
+// in dir or in the closest of the directories above it, or in GOWORK, or else
+// that of the closest go.mod. GOWORK=off leaves go.work files out.
+func FindModuleContext(dir string) (ModuleContext, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ModuleContext{}, err
+	}
+	work := os.Getenv("GOWORK")
+	if work == "" {
+		work = findUp(dir, "go.work")
+	} else if work == "off" {
+		work = ""
+	}
+	if work != "" {
+		return loadModuleContext(work, "work")
+	}
+	if mod := findUp(dir, "go.mod"); mod != "" {
+		return loadModuleContext(mod, "mod")
+	}
+	return ModuleContext{}, nil
+}
+
+// findUp returns the path of the file name in dir or in the closest of the
+// directories above it, or "" if there is none.
+func findUp(dir, name string) string {
+	for {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// goFile is a go.work or a go.mod, as go work edit -json and go mod edit
+// -json print them.
+type goFile struct {
+	Module struct {
+		Path string
+	}
+	Go      string
+	Use     []struct{ DiskPath string }
+	Replace []struct {
+		Old, New struct{ Path, Version string }
+	}
+}
+
+// readGoFile reads the go.work or go.mod at path, kind being "work" or "mod",
+// with the go command.
+func readGoFile(path, kind string) (goFile, error) {
+	var f goFile
+	var stderr bytes.Buffer
+	cmd := exec.Command("go", kind, "edit", "-json", path)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return f, fmt.Errorf("go %s edit %s: %s", kind, path, strings.TrimSpace(stderr.String()))
+	}
+	err = json.Unmarshal(out, &f)
+	return f, err
+}
+
+// loadModuleContext returns the context of the go.work or go.mod at path.
+func loadModuleContext(path, kind string) (ModuleContext, error) {
+	f, err := readGoFile(path, kind)
+	if err != nil {
+		return ModuleContext{}, err
+	}
+	dir := filepath.Dir(path)
+	c := ModuleContext{File: path, Go: f.Go}
+	if kind == "mod" {
+		c.Dirs, c.Paths = []string{dir}, []string{f.Module.Path}
+		return c, nil
+	}
+
+	for _, use := range f.Use {
+		modDir := use.DiskPath
+		if !filepath.IsAbs(modDir) {
+			modDir = filepath.Join(dir, modDir)
+		}
+		mod, err := readGoFile(filepath.Join(modDir, "go.mod"), "mod")
+		if err != nil {
+			return ModuleContext{}, err
+		}
+		c.Dirs, c.Paths = append(c.Dirs, modDir), append(c.Paths, mod.Module.Path)
+	}
+	for _, r := range f.Replace {
+		old, replacement := r.Old.Path, r.New.Path
+		if r.Old.Version != "" {
+			old += " " + r.Old.Version
+		}
+		if r.New.Version != "" {
+			replacement += " " + r.New.Version
+		} else if !filepath.IsAbs(replacement) {
+			replacement = filepath.Join(dir, replacement)
+		}
+		c.Replace = append(c.Replace, old+" => "+replacement)
+	}
+	return c, nil
+}
+
+// ModuleContext returns the module context the imports of the session resolve
+// in.
+func (s *Session) ModuleContext() ModuleContext {
+	return s.context
+}
+
+// ImportsFrom reports whether the session imports packages of the modules of
+// c.
+func (s *Session) ImportsFrom(c ModuleContext) bool {
+	for _, spec := range s.File.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		for _, mod := range c.Paths {
+			if path == mod || strings.HasPrefix(path, mod+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inContext reports whether the module at path is one of the module context
+// of the session.
+func (s *Session) inContext(path string) bool {
+	for _, p := range s.context.Paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// UseModuleContext has the imports of the session resolve in the modules of
+// c: the session becomes a module, in a go.work of its own holding it along
+// with the modules of c, whose replace directives apply. Without a file in c,
+// the session leaves the workspace it was in, if any.
+func (s *Session) UseModuleContext(c ModuleContext) error {
+	if c.File == "" && s.context.File == "" {
+		return nil
+	}
+	if err := s.InitModules(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.Dir(), "go.work")
+	if c.File == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		var work bytes.Buffer
+		if c.Go != "" {
+			fmt.Fprintf(&work, "go %s\n\n", c.Go)
+		}
+		fmt.Fprintf(&work, "use (\n\t.\n")
+		for _, dir := range c.Dirs {
+			fmt.Fprintf(&work, "\t%s\n", strconv.Quote(filepath.ToSlash(dir)))
+		}
+		fmt.Fprintf(&work, ")\n")
+		for _, r := range c.Replace {
+			fmt.Fprintf(&work, "\nreplace %s\n", r)
+		}
+		if err := ioutil.WriteFile(path, work.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	s.context = c
+	s.ResetModules()
+	return nil
+}
+
+// localPackage is a package of the module context whose export data the
+// session loaded, in dir, with the stamp of its files as they were then.
+type localPackage struct {
+	dir, stamp string
+}
+
+// stampLocal records the stamp of the files of the package at path, in dir,
+// if it is one of the module context of the session.
+func (s *Session) stampLocal(path, dir string) {
+	for _, d := range s.context.Dirs {
+		if dir == d || strings.HasPrefix(dir, d+string(filepath.Separator)) {
+			s.local[path] = localPackage{dir: dir, stamp: sourceStamp(dir)}
+			return
+		}
+	}
+}
+
+// sourceStamp returns what tells whether the Go files of dir changed: their
+// names, sizes and modification times.
+func sourceStamp(dir string) string {
+	entries, _ := ioutil.ReadDir(dir)
+	var buf bytes.Buffer
+	for _, fi := range entries {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".go") {
+			fmt.Fprintf(&buf, "%s %d %d\n", fi.Name(), fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return buf.String()
+}
+
+// dropStale drops what is known of the packages the session imports once the
+// files of a package of the module context it loaded changed, for them to be
+// loaded again as they are now; the go command builds them again in turn.
+func (s *Session) dropStale() {
+	for _, p := range s.local {
+		if sourceStamp(p.dir) != p.stamp {
+			debugf("%s changed, reloading packages", p.dir)
+			s.ResetModules()
+			return
+		}
+	}
+}
+
+// relativePathRe matches the paths of Go files outside of the directory the go
+// command ran in, at the start of the lines of its output.
+var relativePathRe = regexp.MustCompile(`(?m)^\.\.[/\\]\S+\.go\b`)
+
+// absolutePaths makes absolute the paths of the files outside of dir that
+// text, the output of the go command run in dir, holds, for the errors of the
+// packages of the module context to point at their files.
+func absolutePaths(dir, text string) string {
+	return relativePathRe.ReplaceAllStringFunc(text, func(p string) string {
+		return filepath.Join(dir, p)
+	})
+}
+
+// completeLocalModule returns the import paths beginning with prefix of the
+// packages of the modules of the module context of s, a directory at a time,
+// as completeModule does.
+func completeLocalModule(s *Session, prefix string) []string {
+	var paths []string
+	d, fn := path.Split(prefix)
+	for i, mod := range s.context.Paths {
+		dir := s.context.Dirs[i]
+		if strings.HasPrefix(mod, d) && len(mod) > len(d) {
+			// The prefix is in the module path itself.
+			elem := mod[len(d):]
+			if !strings.HasPrefix(elem, fn) {
+				continue
+			}
+			if j := strings.Index(elem, "/"); j >= 0 {
+				paths = append(paths, d+elem[:j+1])
+			} else {
+				p := mod
+				if !hasGoFiles(dir) {
+					p += "/"
+				}
+				paths = append(paths, p)
+			}
+			continue
+		}
+		if !strings.HasPrefix(d, mod+"/") {
+			continue
+		}
+		sub := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(d, mod+"/")))
+		entries, err := ioutil.ReadDir(sub)
+		if err != nil {
+			continue
+		}
+		for _, fi := range entries {
+			name := fi.Name()
+			if !fi.IsDir() || !strings.HasPrefix(name, fn) || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(sub, name, "go.mod")); err == nil {
+				continue
+			}
+			p := d + name
+			if !hasGoFiles(filepath.Join(sub, name)) {
+				p += "/"
+			}
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,121 @@
+// Package trace locates the positions found in compiler errors and stack
+// traces in the code of the cell that produced them.
+package trace
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PrinterName is the function through which the session prints the values a
+// cell evaluates to.
+const PrinterName = "__gophernotes"
+
+// Source is what the errors of a cell are located in.
+type Source struct {
+	// Code is the code of the cell.
+	Code string
+
+	// Session is the session file as it ran.
+	Session string
+
+	// Files are the files the session ran, the session file first.
+	Files []string
+
+	// Local are the directories of the modules whose source the session is
+	// built with, which the errors of a cell may point into too.
+	Local []string
+}
+
+// IsUserFile reports whether file is one of the files the session ran, as
+// opposed to the Go runtime or the packages it imports.
+func (src Source) IsUserFile(file string) bool {
+	for _, f := range src.Files {
+		if file == f || filepath.Base(file) == filepath.Base(f) && !filepath.IsAbs(file) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLocalFile reports whether file, an absolute path, is in one of the
+// directories of the modules whose source the session is built with.
+func (src Source) IsLocalFile(file string) bool {
+	if !filepath.IsAbs(file) {
+		return false
+	}
+	for _, dir := range src.Local {
+		if strings.HasPrefix(file, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// locateLocal describes line n of file, one of the local modules, by its path,
+// along with the text of the line and a caret pointing at col.
+func locateLocal(file string, n, col int) (label, text, caret string) {
+	label = file + ":" + strconv.Itoa(n)
+	if col > 0 {
+		label += ":" + strconv.Itoa(col)
+	}
+	b, err := ioutil.ReadFile(file)
+	lines := strings.Split(string(b), "\n")
+	if err != nil || n < 1 || n > len(lines) {
+		return label, "", ""
+	}
+	line := strings.TrimRight(lines[n-1], "\r")
+	text = strings.TrimLeft(line, " \t")
+	if offset := col - (len(line) - len(text)); col > 0 && offset > 0 && offset <= len(text)+1 {
+		caret = strings.Repeat(" ", offset-1) + "^"
+	}
+	return label, text, caret
+}
+
+// Locate describes line n of file, and column col of it if col is positive.
+// For the session file it also returns the text of the line, as it appears
+// in the cell when it can be found there, and a caret pointing at col; for the
+// files of local modules, the line of the file.
+func (src Source) Locate(file string, n, col int) (label, text, caret string) {
+	if src.IsLocalFile(file) {
+		return locateLocal(file, n, col)
+	}
+	label = filepath.Base(file) + ":" + strconv.Itoa(n)
+	sessionLines := strings.Split(src.Session, "\n")
+	if len(src.Files) == 0 || filepath.Base(file) != filepath.Base(src.Files[0]) || n < 1 || n > len(sessionLines) {
+		return label, "", ""
+	}
+
+	// Cell code appears in the session file as reformatted statements of
+	// the main function, the values the cell evaluates to wrapped in a call
+	// to the printer function.
+	text = sessionLines[n-1]
+	trimmed := strings.TrimLeft(text, " \t")
+	offset := col - (len(text) - len(trimmed))
+	text = trimmed
+	if call := PrinterName + "("; strings.HasPrefix(text, call) && strings.HasSuffix(text, ")") {
+		text = text[len(call) : len(text)-1]
+		offset -= len(call)
+	}
+
+	indent := ""
+	for i, line := range strings.Split(src.Code, "\n") {
+		if strings.TrimSpace(line) == text {
+			indent = line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			label = "cell line " + strconv.Itoa(i+1)
+			if col > 0 {
+				col = len(indent) + offset
+			}
+			break
+		}
+	}
+	if col > 0 {
+		label += ":" + strconv.Itoa(col)
+		if offset > 0 && offset <= len(text)+1 {
+			caret = indent + strings.Repeat(" ", offset-1) + "^"
+		}
+	}
+	return label, indent + text, caret
+}
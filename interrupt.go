@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopherds/gophernotes/internal/trace"
+)
+
+// interruptWindow is how soon after the previous interrupt one has to come to
+// go on to warn that the next kills the cell, and then to kill it.
+const interruptWindow = 10 * time.Second
+
+// interrupts are the interrupts of the cell running, if any. The first tells
+// the cell to stop, as SIGINT does; a second, while the cell still runs, dumps
+// the stacks of its goroutines; a third soon after warns that the next one
+// kills the process of the cell, which the fourth does.
+type interrupts struct {
+	sync.Mutex
+
+	// running is set while the code of a cell runs, for receipt, with
+	// src locating its frames; pid is the process of the cell, once the
+	// gophernotes package told it.
+	running bool
+	receipt MsgReceipt
+	src     trace.Source
+	pid     int
+
+	// count counts the interrupts of the cell, the last coming at last.
+	count int
+	last  time.Time
+}
+
+// started records the code of a cell starting to run, for receipt, in the
+// session files files.
+func (in *interrupts) started(receipt MsgReceipt, code string, files []string, local []string) {
+	in.Lock()
+	defer in.Unlock()
+	in.running, in.receipt, in.pid, in.count = true, receipt, 0, 0
+	in.src = trace.Source{Code: code, Files: files, Local: local}
+}
+
+// done records the code of the cell done.
+func (in *interrupts) done() {
+	in.Lock()
+	defer in.Unlock()
+	in.running, in.pid = false, 0
+}
+
+// setPid records the process of the cell running.
+func (in *interrupts) setPid(pid int) {
+	in.Lock()
+	defer in.Unlock()
+	if in.running {
+		in.pid = pid
+	}
+}
+
+// HandleInterruptRequest answers an interrupt_request, interrupting the cell
+// running as SIGINT does, for kernelspecs whose interrupt_mode is message. It
+// is handled as it arrives, rather than after the cell.
+func (k *Kernel) HandleInterruptRequest(receipt MsgReceipt) {
+	k.interrupt()
+	reply := NewMsg("interrupt_reply", receipt.Msg)
+	reply.Content = map[string]interface{}{"status": "ok"}
+	receipt.SendResponse(receipt.Sockets.ControlSocket, reply)
+}
+
+// handleInterrupts interrupts the cell running whenever the kernel gets
+// SIGINT, which Jupyter sends to interrupt it, rather than exiting.
+func (k *Kernel) handleInterrupts() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		for range sigs {
+			k.interrupt()
+		}
+	}()
+}
+
+// interrupt interrupts the cell running, if any: the first interrupt sends
+// SIGINT to the process of the cell, for it to stop, the second dumps the
+// stacks of its goroutines, and the third, coming within interruptWindow,
+// warns that the next one kills the process, as the fourth does. Interrupts
+// coming later than that start over from the second.
+func (k *Kernel) interrupt() {
+	in := &k.interrupts
+	in.Lock()
+	if !in.running {
+		in.Unlock()
+		return
+	}
+	now := time.Now()
+	if in.count >= 2 && now.Sub(in.last) > interruptWindow {
+		in.count = 1
+	}
+	in.count++
+	in.last = now
+	count, pid, receipt := in.count, in.pid, in.receipt
+	in.Unlock()
+
+	switch {
+	case count == 1:
+		if pid != 0 {
+			interruptProcess(pid)
+		}
+	case pid == 0:
+		k.interruptNotice(receipt, "The cell did not stop, and its process is not known: it does not import the gophernotes package.\n")
+	case count == 2:
+		k.logger.Println("Dumping the stacks of the cell")
+		if err := signalStackDump(pid); err != nil {
+			k.interruptNotice(receipt, fmt.Sprintf("The cell did not stop, and the stacks of its goroutines cannot be dumped: %s.\n", err))
+		}
+	case count == 3:
+		k.interruptNotice(receipt, fmt.Sprintf("The cell still runs: interrupt it again within %v to kill it. The session keeps its cells, which the next cell runs again.\n", interruptWindow))
+	default:
+		k.logger.Println("Killing the cell")
+		if p, err := os.FindProcess(pid); err == nil {
+			p.Kill()
+		}
+		k.interruptNotice(receipt, "Killed the cell.\n")
+	}
+}
+
+// interruptNotice tells the frontend about the interrupts of the cell of
+// receipt, on its stderr stream.
+func (k *Kernel) interruptNotice(receipt MsgReceipt, text string) {
+	msg := NewMsg("stream", receipt.Msg)
+	msg.Content = map[string]interface{}{"name": "stderr", "data": text, "text": text}
+	receipt.SendResponse(receipt.Sockets.IOPubSocket, msg)
+}
+
+// stacksDumped publishes the stacks of the goroutines of the cell of receipt,
+// in content, as the gophernotes package dumped them, on its stderr stream,
+// the frames of the code of the cell highlighted.
+func (k *Kernel) stacksDumped(receipt MsgReceipt, content json.RawMessage) {
+	var dump struct {
+		Stacks string `json:"stacks"`
+	}
+	if err := json.Unmarshal(content, &dump); err != nil {
+		k.logger.Println("Invalid stack dump:", err)
+		return
+	}
+	k.interrupts.Lock()
+	src := k.interrupts.src
+	k.interrupts.Unlock()
+	if len(src.Files) > 0 {
+		// The session file was written before the cell started, and is
+		// read as it ran.
+		if b, err := ioutil.ReadFile(src.Files[0]); err == nil {
+			src.Session = string(b)
+		}
+	}
+	text := "The cell did not stop; its goroutines are at:\n\n" + formatStacks(dump.Stacks, src, !k.options().noColor)
+	k.interruptNotice(receipt, text)
+}
+
+// goroutineRe matches the first line of the stack of a goroutine in a dump,
+// such as "goroutine 1 [chan receive]:".
+var goroutineRe = regexp.MustCompile(`^goroutine \d+ \[.*\]:$`)
+
+// formatStacks describes the stacks of a goroutine dump, as runtime.Stack
+// writes them, located in src: the frames of the code of the cell, and of the
+// local modules, are marked and located in the cell, the line they are at
+// shown, while the others are shown as they are. The goroutine dumping the
+// stacks is left out.
+func formatStacks(stacks string, src trace.Source, color bool) string {
+	paint := func(code, s string) string {
+		if !color {
+			return s
+		}
+		return code + s + ansiReset
+	}
+
+	var buf strings.Builder
+	var goroutine []string
+	flush := func() {
+		if len(goroutine) > 0 && !strings.Contains(strings.Join(goroutine, "\n"), "gophernotes.dumpStacks(") {
+			buf.WriteString(strings.Join(goroutine, "\n") + "\n\n")
+		}
+		goroutine = nil
+	}
+	lines := strings.Split(strings.TrimRight(stacks, "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case goroutineRe.MatchString(line):
+			flush()
+			goroutine = append(goroutine, line)
+		case i+1 < len(lines) && frameFileRe.MatchString(lines[i+1]):
+			m := frameFileRe.FindStringSubmatch(lines[i+1])
+			i++
+			var n int
+			fmt.Sscan(m[2], &n)
+			if !src.IsUserFile(m[1]) && !src.IsLocalFile(m[1]) {
+				goroutine = append(goroutine, "    "+line+"  "+m[1]+":"+m[2])
+				continue
+			}
+			label, text, _ := src.Locate(m[1], n, 0)
+			goroutine = append(goroutine, paint(ansiError, "--> ")+line+"  "+paint(ansiLocation, label))
+			if text != "" {
+				goroutine = append(goroutine, "        "+text)
+			}
+		case line != "":
+			goroutine = append(goroutine, "    "+line)
+		}
+	}
+	flush()
+	return buf.String()
+}
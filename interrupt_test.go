@@ -0,0 +1,116 @@
+package main
+
+import (
+	"go/importer"
+	"io/ioutil"
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testStacks is a goroutine dump of a cell stuck at line 9 of its session
+// file, as runtime.Stack writes it, dumped by the gophernotes package.
+const testStacks = `goroutine 6 [running]:
+github.com/gopherds/gophernotes/gophernotes.dumpStacks()
+	/go/src/github.com/gopherds/gophernotes/gophernotes/stacks.go:45 +0x6a
+created by github.com/gopherds/gophernotes/gophernotes.watchStackDumps.func1
+	/go/src/github.com/gopherds/gophernotes/gophernotes/stacks.go:32 +0x9c
+
+goroutine 1 [chan receive]:
+main.main()
+	/tmp/1/gophernotes_session.go:9 +0x2e
+
+goroutine 7 [sleep]:
+time.Sleep(0x3b9aca00)
+	/usr/local/go/src/runtime/time.go:195 +0x135
+`
+
+// TestFormatStacks tests that the frames of the cell are marked and located
+// in it, those of other packages left as they are, and the goroutine dumping
+// the stacks left out
+func TestFormatStacks(t *testing.T) {
+	assert.Equal(t, "goroutine 1 [chan receive]:\n"+
+		"--> main.main()  cell line 3\n"+
+		"            b := a[x]\n"+
+		"\n"+
+		"goroutine 7 [sleep]:\n"+
+		"    time.Sleep(0x3b9aca00)  /usr/local/go/src/runtime/time.go:195\n"+
+		"\n", formatStacks(testStacks, testSource, false))
+}
+
+// TestInterrupt tests that interrupting a cell that does not stop dumps the
+// stacks of its goroutines, located in the cell, then warns that the next
+// interrupt kills it, as the one after does
+func TestInterrupt(t *testing.T) {
+	if _, err := importer.Default().Import("github.com/gopherds/gophernotes/gophernotes"); err != nil {
+		t.Skip("gophernotes package not installed:", err)
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("no stack dumps on Windows")
+	}
+	var mu sync.Mutex
+	var stderr string
+	sockets := SocketGroup{deliver: func(msg ComposedMsg) {
+		content, ok := msg.Content.(map[string]interface{})
+		if msg.Header.MsgType == "stream" && ok && content["name"] == "stderr" {
+			mu.Lock()
+			stderr += content["text"].(string)
+			mu.Unlock()
+		}
+	}}
+	config := defaultConfig()
+	config.noColor = true
+	k, err := NewKernel(sockets, log.New(ioutil.Discard, "", 0), config)
+	noError(t, err)
+	defer k.removeSession()
+
+	done := make(chan struct{})
+	go func() {
+		for _, path := range []string{"os", "os/signal", "time"} {
+			k.HandleExecuteRequest(MsgReceipt{Msg: newExecuteRequest(":import " + path), Sockets: k.sockets})
+		}
+		code := "signal.Ignore(os.Interrupt)\nfor {\n\ttime.Sleep(10 * time.Millisecond)\n}"
+		k.HandleExecuteRequest(MsgReceipt{Msg: newExecuteRequest(code), Sockets: k.sockets})
+		close(done)
+	}()
+	waitFor := func(what string, cond func() bool) {
+		for deadline := time.Now().Add(60 * time.Second); !cond(); time.Sleep(50 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for", what)
+			}
+		}
+	}
+	waitFor("the cell to start", func() bool {
+		k.interrupts.Lock()
+		defer k.interrupts.Unlock()
+		return k.interrupts.pid != 0
+	})
+	stderrHas := func(s string) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return strings.Contains(stderr, s)
+		}
+	}
+
+	k.interrupt()
+	k.interrupt()
+	waitFor("the stacks", stderrHas("its goroutines are at"))
+	assert.True(t, stderrHas("--> main.main()  cell line 3")())
+	assert.False(t, stderrHas("dumpStacks")())
+
+	k.interrupt()
+	assert.True(t, stderrHas("interrupt it again")())
+	k.interrupt()
+	assert.True(t, stderrHas("Killed the cell.")())
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("the cell still runs once killed")
+	}
+}
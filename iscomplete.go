@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+)
+
+// IsCompleteReply holds the content of an is_complete_reply message. Indent is
+// the indentation of the next line of incomplete code.
+type IsCompleteReply struct {
+	Status string `json:"status"`
+	Indent string `json:"indent"`
+}
+
+// HandleIsCompleteRequest answers an is_complete_request, telling consoles
+// whether the code typed so far runs as a cell or needs more lines.
+func (k *Kernel) HandleIsCompleteRequest(receipt MsgReceipt) {
+	content := receipt.Msg.Content.(map[string]interface{})
+	code, _ := content["code"].(string)
+	msg := NewMsg("is_complete_reply", receipt.Msg)
+	msg.Content = cellCompleteness(code)
+	receipt.SendResponse(receipt.Sockets.ShellSocket, msg)
+}
+
+// cellCompleteness tells whether code is a whole cell. Its Go code is
+// incomplete while it leaves a raw string or brackets open, or its last line
+// continues an expression, the lines of magics and shell commands aside. The
+// cell of a cell magic, whose body may hold anything, is incomplete up to a
+// blank line.
+func cellCompleteness(code string) IsCompleteReply {
+	lines := strings.Split(code, "\n")
+	if strings.HasPrefix(strings.TrimLeft(code, " \t\r\n"), "%%") {
+		if len(lines) > 1 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			return IsCompleteReply{Status: "complete"}
+		}
+		return IsCompleteReply{Status: "incomplete"}
+	}
+
+	var st lineState
+	magic := magicLines(lines)
+	for i, line := range lines {
+		if !magic[i] {
+			st.scan(line)
+		}
+	}
+	switch {
+	case st.topLevel():
+		return IsCompleteReply{Status: "complete"}
+	case st.inRaw:
+		return IsCompleteReply{Status: "incomplete"}
+	case st.depth == 0:
+		return IsCompleteReply{Status: "incomplete", Indent: "\t"}
+	}
+	return IsCompleteReply{Status: "incomplete", Indent: strings.Repeat("\t", st.depth)}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCellCompleteness tests which cells are complete, and the indentation
+// of the next line of those that are not
+func TestCellCompleteness(t *testing.T) {
+	for code, want := range map[string]IsCompleteReply{
+		"":                              {"complete", ""},
+		"x := 1":                        {"complete", ""},
+		"for i := 0; i < 3; i++ {":      {"incomplete", "\t"},
+		"if x {\n\tswitch y {":          {"incomplete", "\t\t"},
+		"if x {\n\tf()\n}":              {"complete", ""},
+		"s := []int{\n\t1,":             {"incomplete", "\t"},
+		"x := 1 +":                      {"incomplete", "\t"},
+		"s := `first\nsecond":           {"incomplete", ""},
+		"s := `first\nsecond`":          {"complete", ""},
+		"%time f(\n!echo (":             {"complete", ""},
+		"%time f(\n!echo (\nx := (":     {"incomplete", "\t"},
+		":import fmt\nfmt.Println(1)":   {"complete", ""},
+		"%%bash\necho hi":               {"incomplete", ""},
+		"%%bash\necho hi\n":             {"complete", ""},
+		"%%bash":                        {"incomplete", ""},
+		"x := 1 // a comment with { in": {"complete", ""},
+	} {
+		assert.Equal(t, want, cellCompleteness(code), "%q", code)
+	}
+}
+
+// TestIsCompleteRequest tests that is_complete_request is answered with the
+// completeness of the code given
+func TestIsCompleteRequest(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	req := c.send("is_complete_request", map[string]interface{}{"code": "if true {"})
+	reply := c.recv(c.shell)
+	assert.Equal(t, "is_complete_reply", reply.Header.MsgType)
+	assert.Equal(t, req.Header.MsgID, reply.ParentHeader.MsgID)
+	assert.Equal(t, map[string]interface{}{"status": "incomplete", "indent": "\t"}, reply.Content)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+)
+
+func init() {
+	RegisterLineMagic("share", "%share <name>...", "share packages imported, types, constants and functions with the sessions of the other clients\nOnly kernels started with --isolate-sessions have sessions of their own for each client.", shareMagic)
+}
+
+// clientSessions are the sessions of the clients of a kernel started with
+// --isolate-sessions, such as consoles attached to the kernel of a notebook,
+// by the session of the headers of their messages: the cells of each client
+// run in a session of its own, for the variables of one not to clobber those
+// of another, the first client taking the session the kernel started with.
+// The packages imported, the types, the constants and the functions of a
+// session are shared with the others by %share alone.
+//
+// The requests handled in order change the sessions; the read-only requests
+// read the snapshots of the sessions, taken as cells are done with them.
+type clientSessions struct {
+	sync.Mutex
+
+	// sessions are the sessions of the clients, and snapshots their copies,
+	// by client; current is the client whose request is handled.
+	sessions  map[string]*repl.Session
+	snapshots map[string]*repl.Session
+	current   string
+
+	// blank is the copy of the session as the kernel started it, read by
+	// the clients yet to send a request handled in order.
+	blank *repl.Session
+
+	// shared are the names shared so far, for the sessions of the clients
+	// coming later to share them too.
+	shared []sharedName
+}
+
+// sharedName is a name shared by %share, and the session it was shared from.
+type sharedName struct {
+	from *repl.Session
+	name string
+}
+
+// snapshotTaken records s, the copy of the session of the current client.
+func (c *clientSessions) snapshotTaken(s *repl.Session) {
+	c.Lock()
+	defer c.Unlock()
+	if c.sessions != nil {
+		c.snapshots[c.current] = s
+	}
+}
+
+// snapshot returns the copy of the session of client.
+func (c *clientSessions) snapshot(client string) *repl.Session {
+	c.Lock()
+	defer c.Unlock()
+	if s, ok := c.snapshots[client]; ok {
+		return s
+	}
+	return c.blank
+}
+
+// remove removes the directories of the sessions of the clients but that of
+// except, which the kernel removes itself.
+func (c *clientSessions) remove(except *repl.Session) {
+	c.Lock()
+	defer c.Unlock()
+	for _, s := range c.sessions {
+		if s != except {
+			os.RemoveAll(traceDir(s))
+			os.RemoveAll(filepath.Dir(s.FilePath))
+		}
+	}
+}
+
+// useClientSession has the request of receipt, handled in order, use the
+// session of its client, when the sessions of the clients are isolated,
+// making it first for new clients.
+func (k *Kernel) useClientSession(receipt MsgReceipt) {
+	if !k.options().isolateSessions {
+		return
+	}
+	client := receipt.Msg.Header.Session
+	c := &k.clients
+	c.Lock()
+	s, ok := c.sessions[client]
+	first := c.sessions == nil
+	c.Unlock()
+	if ok {
+		c.Lock()
+		c.current = client
+		c.Unlock()
+		k.session = s
+		return
+	}
+
+	if first {
+		s = k.session
+	} else {
+		var err error
+		if s, err = k.newClientSession(); err != nil {
+			k.diagnoseIn(&receipt, diagError, "session", "could not make the session of a new client: it runs in that of the last client", "client", client, "error", err)
+			return
+		}
+	}
+	c.Lock()
+	if c.sessions == nil {
+		c.sessions = map[string]*repl.Session{}
+		c.snapshots = map[string]*repl.Session{}
+	}
+	c.sessions[client] = s
+	c.current = client
+	c.Unlock()
+	k.session = s
+	k.takeSnapshot()
+}
+
+// newClientSession makes a session for a new client, talking with the kernel
+// through the files of the others, resolving imports in the same module
+// context, and sharing what was shared so far.
+func (k *Kernel) newClientSession() (*repl.Session, error) {
+	s, err := repl.NewSessionIn(k.tempDir)
+	if err != nil {
+		return nil, err
+	}
+	s.Env = append(s.Env, k.session.Env...)
+	s.Timeout = k.session.Timeout
+	if err := s.UseModuleContext(k.session.ModuleContext()); err != nil {
+		return nil, err
+	}
+	k.clients.Lock()
+	shared := append([]sharedName(nil), k.clients.shared...)
+	k.clients.Unlock()
+	for _, sh := range shared {
+		if err := sh.from.Share(sh.name, s); err != nil {
+			k.diagnose(diagWarning, "share", "could not share a name with the session of a new client", "name", sh.name, "error", err)
+		}
+	}
+	return s, nil
+}
+
+// shareMagic shares the names given, imported or declared by the cells of the
+// session of the client, with the sessions of the other clients, those coming
+// later included.
+func shareMagic(ctx *MagicContext, args []string, body string) error {
+	if len(args) == 0 {
+		return errors.New("a name is needed")
+	}
+	k := ctx.Kernel
+	if !k.options().isolateSessions {
+		return errors.New("the clients share the one session: start the kernel with --isolate-sessions for them to have sessions of their own")
+	}
+	for _, name := range args {
+		if err := ctx.Session.Shareable(name); err != nil {
+			return err
+		}
+	}
+
+	c := &k.clients
+	c.Lock()
+	others := map[string]*repl.Session{}
+	for client, s := range c.sessions {
+		if s != ctx.Session {
+			others[client] = s
+		}
+	}
+	for _, name := range args {
+		c.shared = append(c.shared, sharedName{ctx.Session, name})
+	}
+	c.Unlock()
+
+	for client, s := range others {
+		for _, name := range args {
+			if err := ctx.Session.Share(name, s); err != nil {
+				return err
+			}
+		}
+		snapshot, err := s.Snapshot()
+		if err != nil {
+			return err
+		}
+		c.Lock()
+		c.snapshots[client] = snapshot
+		c.Unlock()
+	}
+	sessions := "sessions"
+	if len(others) == 1 {
+		sessions = "session"
+	}
+	ctx.Stream("stdout", fmt.Sprintf("Shared %s with %d other %s.\n", strings.Join(args, ", "), len(others), sessions))
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"go/importer"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIsolateSessions tests that with --isolate-sessions each client runs
+// cells in a session of its own, which completion and %who read, sharing
+// packages and declarations with %share alone
+func TestIsolateSessions(t *testing.T) {
+	if _, err := importer.Default().Import("github.com/gopherds/gophernotes/gophernotes"); err != nil {
+		t.Skip("gophernotes package not installed:", err)
+	}
+	var published []ComposedMsg
+	config := defaultConfig()
+	config.isolateSessions = true
+	k, err := NewKernel(SocketGroup{deliver: func(msg ComposedMsg) {
+		published = append(published, msg)
+	}}, log.New(ioutil.Discard, "", 0), config)
+	noError(t, err)
+	defer k.removeSession()
+
+	request := func(client string, msg ComposedMsg) []ComposedMsg {
+		msg.Header.Session = client
+		published = nil
+		receipt := MsgReceipt{Msg: msg, Sockets: k.sockets}
+		k.useClientSession(receipt)
+		k.HandleShellMsg(receipt)
+		return published
+	}
+	execute := func(client, code string) []ComposedMsg {
+		return request(client, newExecuteRequest(code))
+	}
+	reply := func(msgs []ComposedMsg, msgType string) map[string]interface{} {
+		for _, msg := range msgs {
+			if msg.Header.MsgType == msgType {
+				return contentMap(msg.Content)
+			}
+		}
+		return nil
+	}
+	result := func(msgs []ComposedMsg) interface{} {
+		if content := reply(msgs, "execute_result"); content != nil {
+			return content["data"].(map[string]interface{})["text/plain"]
+		}
+		return nil
+	}
+
+	execute("a", "x := 1")
+	execute("b", `x := "b"`)
+	assert.Equal(t, "1\n", result(execute("a", "x")))
+	assert.Equal(t, "\"b\"\n", result(execute("b", "x")))
+
+	execute("a", "onlyA := 2")
+	assert.Equal(t, "x\n", streamText(execute("b", "%who"), "stdout"))
+	complete := func(client string) []string {
+		msg := newExecuteRequest("")
+		msg.Header.MsgType = "complete_request"
+		msg.Content = map[string]interface{}{"code": "only", "cursor_pos": 4.0}
+		var matches []string
+		for _, m := range reply(request(client, msg), "complete_reply")["matches"].([]interface{}) {
+			matches = append(matches, m.(string))
+		}
+		return matches
+	}
+	assert.Equal(t, []string{"onlyA"}, complete("a"))
+	assert.Empty(t, complete("b"))
+
+	execute("a", ":import strings")
+	execute("a", "type Point struct{ X int }\n\nfunc (p Point) Shout(s string) string { return strings.ToUpper(s) }")
+	execute("a", "const limit = 3")
+	shared := execute("a", "%share Point strings limit")
+	assert.Equal(t, "Shared Point, strings, limit with 1 other session.\n", streamText(shared, "stdout"), reply(shared, "execute_reply")["evalue"])
+	assert.Equal(t, "\"HI\"\n", result(execute("b", `Point{X: limit}.Shout("hi")`)))
+	assert.Equal(t, "\"C\"\n", result(execute("c", `strings.ToUpper(Point{}.Shout("c"))`)))
+
+	assert.Equal(t, "%share: x is a variable: only packages, types, constants and functions can be shared", reply(execute("a", "%share x"), "execute_reply")["evalue"])
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gopherds/gophernotes/gophernotes"
+	"github.com/gopherds/gophernotes/gophernotes/widgets"
+	repl "github.com/gopherds/gophernotes/internal/repl"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// Kernel is the state of a running kernel: its sockets, the session cells run
+// in, and what the requests keep track of from one to the next.
+//
+// Execute requests, and the other requests changing the kernel, are handled
+// one at a time, in order, by serve; the fields not otherwise documented are
+// only used by them, and by the magics and comm handlers they run. Read-only
+// requests are handled meanwhile, and display relays and timers publish while
+// cells run: what they share with the requests handled in order is guarded by
+// locks of its own, which are never held while another is taken, but for the
+// lock of a socket held while sending on it.
+type Kernel struct {
+	sockets SocketGroup
+	logger  *log.Logger
+
+	// config holds the options set by the flags of the kernel and %config.
+	config struct {
+		sync.Mutex
+		kernelConfig
+	}
+
+	// session is where cells run, and files the files through which it
+	// talks with cell code. execCount is incremented each time user code is
+	// run in the notebook.
+	session   *repl.Session
+	files     sessionFiles
+	execCount int
+
+	// tempDir is the temporary directory of the kernel, named after its
+	// session ID, which holds the directories of its sessions and the
+	// scratch files of magics and cell code.
+	tempDir string
+
+	// magics are the magics registered by the init functions of the
+	// package, copied when the kernel is made, and those registered with
+	// the kernel since.
+	magics *magicRegistry
+
+	// snapshot is a copy of the session as of the last cell run, for the
+	// read-only requests to read.
+	snapshot struct {
+		sync.Mutex
+		session *repl.Session
+	}
+
+	// history holds the cells run, and comms the comms open and the
+	// targets they may be opened with.
+	history historyStore
+	comms   commRegistry
+
+	// inputs are the answers to the input requests of cell code.
+	inputs inputAnswers
+
+	// openedComms are the comms the frontend opened with targets of cell
+	// code, in the order they were opened.
+	openedComms struct {
+		sync.Mutex
+		list []openedComm
+	}
+
+	// widgetStates is the state of the models of the widgets cell code
+	// created, by comm ID, as last synced with the frontend.
+	widgetStates struct {
+		sync.Mutex
+		m map[string]map[string]interface{}
+	}
+
+	// displayOrigins is the header of the request of the cell that
+	// created each display handle, by display_id, which the updates of the
+	// display are children of.
+	displayOrigins struct {
+		sync.Mutex
+		m map[string]MsgHeader
+	}
+
+	// dirs are the working directories of the kernel, and benchmarks the
+	// %%benchmark cells run, oldest first, kept for runs to be compared.
+	dirs       dirHistory
+	benchmarks []benchmarkRun
+
+	// debugger is the state of the debugger of the frontend, used by the
+	// debug requests, handled meanwhile too, and the display relays.
+	debugger debugger
+
+	// health is what the health checks tell of the kernel, and interrupts
+	// the interrupts of the cell running.
+	health     healthState
+	interrupts interrupts
+
+	// clients are the sessions of the clients of the kernel, when they are
+	// isolated, and subshells the subshells frontends created.
+	clients   clientSessions
+	subshells subshells
+
+	// diagnostics are what the kernel tells the frontend of what it
+	// notices; see diagnose.
+	diagnostics diagnostics
+}
+
+// kernelConfig holds the options of a kernel.
+type kernelConfig struct {
+	// noColor disables the ANSI colors in tracebacks.
+	noColor bool
+
+	// autoformat is set for cells to be formatted before they run, as
+	// set by %config autoformat.
+	autoformat bool
+
+	// The budgets of the output streams, per second; see streamLimiter.
+	streamMsgRate, streamDataRate, streamBlockRate int
+
+	// displayUpdateRate is how many update_display_data messages per
+	// second are published for each display.
+	displayUpdateRate int
+
+	// historyPath is the file the history is kept in across restarts of
+	// the kernel, if set.
+	historyPath string
+
+	// shutdownIdleSeconds is how long the kernel is left idle before it
+	// shuts down, 0 for as long as it takes.
+	shutdownIdleSeconds int
+
+	// readyFile is the file made once the kernel serves, if set, and
+	// healthzAddr the address of its healthz endpoint, if it has one.
+	readyFile   string
+	healthzAddr string
+
+	// isolateSessions gives each client of the kernel a session of its
+	// own; see clientSessions.
+	isolateSessions bool
+
+	// subshells enables the subshells of JEP 91, advertised in the
+	// kernel_info_reply; see subshells.
+	subshells bool
+
+	// keepTemp leaves the temporary directory of the kernel behind when it
+	// shuts down.
+	keepTemp bool
+
+	// diagnostics shows the warnings of the kernel in the notebook, as
+	// %config diagnostics sets; they are logged either way.
+	diagnostics bool
+}
+
+// shutdownIdleEnv is the environment variable setting how many seconds the
+// kernel is left idle before it shuts down, for kernelspecs to set it.
+const shutdownIdleEnv = "GOPHERNOTES_SHUTDOWN_IDLE_SECONDS"
+
+// defaultConfig returns the options of a kernel unless its flags say
+// otherwise. Tracebacks are colored unless the NO_COLOR environment variable
+// is set, the history is kept to the running kernel, the kernel is never shut
+// down for being idle unless shutdownIdleEnv is set, and its warnings show in
+// the notebook.
+func defaultConfig() kernelConfig {
+	idle, _ := strconv.Atoi(os.Getenv(shutdownIdleEnv))
+	return kernelConfig{
+		noColor:             os.Getenv("NO_COLOR") != "",
+		streamMsgRate:       1000,
+		streamDataRate:      1 << 20,
+		streamBlockRate:     8 << 20,
+		displayUpdateRate:   20,
+		shutdownIdleSeconds: idle,
+		diagnostics:         true,
+	}
+}
+
+// updateInterval returns the shortest time between two updates of a display
+// published, 0 if they are all published.
+func (c kernelConfig) updateInterval() time.Duration {
+	if c.displayUpdateRate <= 0 {
+		return 0
+	}
+	return time.Second / time.Duration(c.displayUpdateRate)
+}
+
+// idleTimeout returns how long the kernel is left idle before it shuts down,
+// 0 if it never is.
+func (c kernelConfig) idleTimeout() time.Duration {
+	if c.shutdownIdleSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(c.shutdownIdleSeconds) * time.Second
+}
+
+// options returns a copy of the options of the kernel.
+func (k *Kernel) options() kernelConfig {
+	k.config.Lock()
+	defer k.config.Unlock()
+	return k.config.kernelConfig
+}
+
+// sessionFiles are the files through which the kernel talks with cell code.
+type sessionFiles struct {
+	// display is where cell code writes the display messages to publish.
+	display string
+
+	// cell is where the code of the cell being run is kept, for cell code
+	// to locate stack traces in.
+	cell string
+
+	// commEvent is where the comm message from the frontend to deliver to
+	// cell code is kept while it is being delivered, openComms where the
+	// comms the frontend opened with targets of cell code are listed, and
+	// widgetState where the state of widgets is kept for cell code to
+	// restore.
+	commEvent   string
+	openComms   string
+	widgetState string
+
+	// debug is the directory where the sources of the cells the debugger
+	// dumped are, and where the kernel lets cell code stopped at a
+	// breakpoint go on.
+	debug string
+
+	// input is the directory where the kernel leaves the replies to the
+	// input requests of cell code.
+	input string
+}
+
+// NewKernel returns a kernel communicating through sockets, logging to logger,
+// with a new session to run cells in.
+func NewKernel(sockets SocketGroup, logger *log.Logger, config kernelConfig) (*Kernel, error) {
+	u, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	tempDir, err := newTempDir(u.String())
+	if err != nil {
+		return nil, err
+	}
+	session, err := repl.NewSessionIn(tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Kernel{sockets: sockets, logger: logger, session: session, tempDir: tempDir, magics: registeredMagics.clone()}
+	k.sockets.logger = logger
+	k.config.kernelConfig = config
+	k.history.path, k.history.session = config.historyPath, u.String()
+	k.comms.init()
+	k.widgetStates.m = map[string]map[string]interface{}{}
+
+	dir := filepath.Dir(session.FilePath)
+	k.files = sessionFiles{
+		display:     filepath.Join(dir, "display.jsonl"),
+		cell:        filepath.Join(dir, "cell.txt"),
+		commEvent:   filepath.Join(dir, "comm_event.json"),
+		openComms:   filepath.Join(dir, "open_comms.json"),
+		widgetState: filepath.Join(dir, "widget_state.json"),
+		debug:       filepath.Join(dir, "debug"),
+		input:       filepath.Join(dir, "input"),
+	}
+	session.Env = append(session.Env,
+		gophernotes.DisplayFileEnv+"="+k.files.display,
+		gophernotes.CellFileEnv+"="+k.files.cell,
+		gophernotes.SessionEnv+"="+u.String(),
+		gophernotes.CommEventEnv+"="+k.files.commEvent,
+		gophernotes.OpenCommsEnv+"="+k.files.openComms,
+		widgets.StateEnv+"="+k.files.widgetState,
+		gophernotes.DebugDirEnv+"="+k.files.debug,
+		gophernotes.InputDirEnv+"="+k.files.input,
+		gophernotes.TempDirEnv+"="+tempDir,
+	)
+	if session.Runtime() {
+		k.diagnose(diagDebug, "imports", "imported the gophernotes package into the session, for values to be displayed")
+	} else {
+		k.diagnose(diagWarning, "imports", "could not import the gophernotes package into the session: values are printed, not displayed")
+	}
+	if _, err := k.useModuleContext(false); err != nil {
+		logger.Println("Could not resolve the module context:", err)
+	}
+	k.takeSnapshot()
+	k.clients.blank = k.readSession()
+	return k, nil
+}
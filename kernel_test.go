@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"go/importer"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	zmq "github.com/alecthomas/gozmq"
+	uuid "github.com/nu7hatch/gouuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// testKernel is the kernel shared by the tests that talk to it over its
+// sockets, and testKernelInfo its connection info.
+var (
+	testKernel     *Kernel
+	testKernelInfo ConnectionInfo
+	testKernelOnce sync.Once
+)
+
+// testClient talks to the test kernel the way a frontend would.
+type testClient struct {
+	t       *testing.T
+	shell   *zmq.Socket
+	control *zmq.Socket
+	stdin   *zmq.Socket
+	iopub   *zmq.Socket
+	key     []byte
+}
+
+// freePort returns a TCP port nothing is listening on.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	noError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// localConnectionInfo returns the connection info of a kernel serving on
+// local TCP sockets.
+func localConnectionInfo(t *testing.T) ConnectionInfo {
+	return ConnectionInfo{
+		SignatureScheme: "hmac-sha256",
+		Transport:       "tcp",
+		IP:              "127.0.0.1",
+		ShellPort:       freePort(t),
+		ControlPort:     freePort(t),
+		StdinPort:       freePort(t),
+		IOPubPort:       freePort(t),
+		HBPort:          freePort(t),
+		Key:             "test-key",
+	}
+}
+
+// startTestKernel starts the kernel shared by the tests, serving on local TCP
+// sockets.
+func startTestKernel(t *testing.T) {
+	testKernelOnce.Do(func() {
+		testKernelInfo = localConnectionInfo(t)
+		sockets, err := PrepareSockets(testKernelInfo)
+		noError(t, err)
+		testKernel, err = NewKernel(sockets, log.New(ioutil.Discard, "", 0), defaultConfig())
+		noError(t, err)
+		go testKernel.serve()
+	})
+}
+
+// newTestClient connects a client to the test kernel. Tests that run cells
+// using the gophernotes package are skipped if it is not installed.
+func newTestClient(t *testing.T) *testClient {
+	if _, err := importer.Default().Import("github.com/gopherds/gophernotes/gophernotes"); err != nil {
+		t.Skip("gophernotes package not installed:", err)
+	}
+	startTestKernel(t)
+	return connectTestClient(t, testKernelInfo)
+}
+
+// connectTestClient connects a client to the kernel of info.
+func connectTestClient(t *testing.T, info ConnectionInfo) *testClient {
+	ctx, err := zmq.NewContext()
+	noError(t, err)
+	c := &testClient{t: t, key: []byte(info.Key)}
+	address := fmt.Sprintf("tcp://%s:%%d", info.IP)
+
+	// The shell, control and stdin sockets share an identity, for the
+	// kernel to ask the client for input on the stdin socket.
+	id, err := uuid.NewV4()
+	noError(t, err)
+	c.shell, err = ctx.NewSocket(zmq.DEALER)
+	noError(t, err)
+	noError(t, c.shell.SetSockOptString(zmq.IDENTITY, id.String()))
+	noError(t, c.shell.Connect(fmt.Sprintf(address, info.ShellPort)))
+
+	c.control, err = ctx.NewSocket(zmq.DEALER)
+	noError(t, err)
+	noError(t, c.control.SetSockOptString(zmq.IDENTITY, id.String()))
+	noError(t, c.control.Connect(fmt.Sprintf(address, info.ControlPort)))
+
+	c.stdin, err = ctx.NewSocket(zmq.DEALER)
+	noError(t, err)
+	noError(t, c.stdin.SetSockOptString(zmq.IDENTITY, id.String()))
+	noError(t, c.stdin.Connect(fmt.Sprintf(address, info.StdinPort)))
+
+	c.iopub, err = ctx.NewSocket(zmq.SUB)
+	noError(t, err)
+	noError(t, c.iopub.SetSockOptString(zmq.SUBSCRIBE, ""))
+	noError(t, c.iopub.Connect(fmt.Sprintf(address, info.IOPubPort)))
+
+	// Give the subscription time to reach the kernel.
+	time.Sleep(100 * time.Millisecond)
+	return c
+}
+
+// Close disconnects the client.
+func (c *testClient) Close() {
+	c.shell.Close()
+	c.control.Close()
+	c.stdin.Close()
+	c.iopub.Close()
+}
+
+// send sends a request of type msgType on the shell socket and returns it.
+func (c *testClient) send(msgType string, content map[string]interface{}) ComposedMsg {
+	return c.sendBuffers(msgType, content, nil)
+}
+
+// sendBuffers sends a request of type msgType, with buffers, on the shell
+// socket and returns it.
+func (c *testClient) sendBuffers(msgType string, content map[string]interface{}, buffers [][]byte) ComposedMsg {
+	return c.sendOn(c.shell, msgType, content, buffers)
+}
+
+// sendOn sends a request of type msgType, with buffers, on socket and returns
+// it.
+func (c *testClient) sendOn(socket *zmq.Socket, msgType string, content map[string]interface{}, buffers [][]byte) ComposedMsg {
+	u, err := uuid.NewV4()
+	noError(c.t, err)
+	var msg ComposedMsg
+	msg.Header = MsgHeader{MsgID: u.String(), Username: "test", Session: "test", MsgType: msgType}
+	msg.Content = content
+	msg.Buffers = buffers
+
+	parts, err := msg.ToWireMsg(c.key)
+	noError(c.t, err)
+	noError(c.t, socket.SendMultipart(append([][]byte{[]byte("<IDS|MSG>")}, parts...), 0))
+	return msg
+}
+
+// reply sends a reply of type msgType, to parent, on the stdin socket.
+func (c *testClient) reply(msgType string, parent ComposedMsg, content map[string]interface{}) {
+	msg := NewMsg(msgType, parent)
+	msg.Content = content
+	parts, err := msg.ToWireMsg(c.key)
+	noError(c.t, err)
+	noError(c.t, c.stdin.SendMultipart(append([][]byte{[]byte("<IDS|MSG>")}, parts...), 0))
+}
+
+// recv receives a message from socket, failing the test if none arrives in time.
+func (c *testClient) recv(socket *zmq.Socket) ComposedMsg {
+	pi := zmq.PollItems{zmq.PollItem{Socket: socket, Events: zmq.POLLIN}}
+	n, err := zmq.Poll(pi, time.Minute)
+	noError(c.t, err)
+	if n == 0 {
+		c.t.Fatal("timed out waiting for a message")
+	}
+	parts, err := socket.RecvMultipart(0)
+	noError(c.t, err)
+	msg, _, err := WireMsgToComposedMsg(parts, c.key)
+	noError(c.t, err)
+	return msg
+}
+
+// execute runs code in the kernel, and returns the execute_reply along with
+// the iopub messages published for the request, up to the idle status.
+func (c *testClient) execute(code string) (ComposedMsg, []ComposedMsg) {
+	req := c.send("execute_request", map[string]interface{}{
+		"code":             code,
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      false,
+	})
+	return c.results(req)
+}
+
+// executeInput runs code in the kernel as execute does, allowing stdin, and
+// answers the input requests of the kernel with inputs, in order. The prompts
+// of the requests are returned, along with the execute_reply and the iopub
+// messages for the request.
+func (c *testClient) executeInput(code string, inputs ...string) (ComposedMsg, []ComposedMsg, []string) {
+	req := c.send("execute_request", map[string]interface{}{
+		"code":             code,
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      true,
+	})
+	var prompts []string
+	for _, input := range inputs {
+		inputReq := c.recv(c.stdin)
+		prompts = append(prompts, inputReq.Content.(map[string]interface{})["prompt"].(string))
+		c.reply("input_reply", inputReq, map[string]interface{}{"value": input})
+	}
+	reply, published := c.results(req)
+	return reply, published, prompts
+}
+
+// results returns the execute_reply to req, along with the iopub messages
+// published for it, up to the idle status, the busy status left out.
+func (c *testClient) results(req ComposedMsg) (ComposedMsg, []ComposedMsg) {
+	var published []ComposedMsg
+	for {
+		msg := c.recv(c.iopub)
+		if msg.ParentHeader.MsgID != req.Header.MsgID {
+			continue
+		}
+		if msg.Header.MsgType == "status" {
+			if msg.Content.(map[string]interface{})["execution_state"] == "idle" {
+				break
+			}
+			continue
+		}
+		published = append(published, msg)
+	}
+
+	for {
+		reply := c.recv(c.shell)
+		if reply.ParentHeader.MsgID == req.Header.MsgID {
+			return reply, published
+		}
+	}
+}
+
+// msgTypes returns the types of msgs.
+func msgTypes(msgs []ComposedMsg) []string {
+	types := make([]string, len(msgs))
+	for i, msg := range msgs {
+		types[i] = msg.Header.MsgType
+	}
+	return types
+}
+
+// TestServe_readOnly tests that completion, inspection and kernel info
+// requests are answered while a cell runs, from the session as the last cell
+// left it, and that the cell then completes as usual
+func TestServe_readOnly(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	reply, _ := c.execute("servedX := 1")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	req := c.send("execute_request", map[string]interface{}{
+		"code":             ":import time\ntime.Sleep(3 * time.Second)\nservedY := 2\nservedY",
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      false,
+	})
+	time.Sleep(500 * time.Millisecond)
+
+	start := time.Now()
+	for _, r := range []struct {
+		msgType string
+		content map[string]interface{}
+	}{
+		{"complete_request", map[string]interface{}{"code": "served", "cursor_pos": 6}},
+		{"inspect_request", map[string]interface{}{"code": "servedX", "cursor_pos": 7, "detail_level": 0}},
+		{"kernel_info_request", map[string]interface{}{}},
+	} {
+		sent := c.send(r.msgType, r.content)
+		msg := c.recv(c.shell)
+		assert.Equal(t, sent.Header.MsgID, msg.ParentHeader.MsgID, r.msgType)
+		if r.msgType == "complete_request" {
+			matches := msg.Content.(map[string]interface{})["matches"]
+			assert.Contains(t, matches, "servedX")
+			assert.NotContains(t, matches, "servedY")
+		}
+	}
+	assert.True(t, time.Since(start) < 2*time.Second, "answered in %v", time.Since(start))
+
+	reply, published := c.results(req)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Equal(t, []string{"execute_result"}, msgTypes(published))
+	completion := c.send("complete_request", map[string]interface{}{"code": "served", "cursor_pos": 6})
+	msg := c.recv(c.shell)
+	assert.Equal(t, completion.Header.MsgID, msg.ParentHeader.MsgID)
+	assert.Contains(t, msg.Content.(map[string]interface{})["matches"], "servedY")
+}
+
+// TestServe_status tests that every request, read-only ones included, is
+// framed by the busy and idle statuses, the reply coming in between
+func TestServe_status(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	for _, r := range []struct {
+		msgType string
+		content map[string]interface{}
+	}{
+		{"complete_request", map[string]interface{}{"code": "fm", "cursor_pos": 2}},
+		{"kernel_info_request", map[string]interface{}{}},
+		{"execute_request", map[string]interface{}{"code": "statusX := 1", "silent": false, "store_history": true, "user_expressions": map[string]interface{}{}, "allow_stdin": false}},
+	} {
+		req := c.send(r.msgType, r.content)
+		var states []string
+		for len(states) < 2 {
+			msg := c.recv(c.iopub)
+			if msg.ParentHeader.MsgID == req.Header.MsgID && msg.Header.MsgType == "status" {
+				states = append(states, msg.Content.(map[string]interface{})["execution_state"].(string))
+			}
+		}
+		assert.Equal(t, []string{"busy", "idle"}, states, r.msgType)
+		reply := c.recv(c.shell)
+		assert.Equal(t, req.Header.MsgID, reply.ParentHeader.MsgID, r.msgType)
+	}
+}
+
+// TestServe_shutdownControl tests that a shutdown request on the control
+// socket is answered, and the kernel shut down, while a cell runs
+func TestServe_shutdownControl(t *testing.T) {
+	info := localConnectionInfo(t)
+	sockets, err := PrepareSockets(info)
+	noError(t, err)
+	k, err := NewKernel(sockets, log.New(ioutil.Discard, "", 0), defaultConfig())
+	noError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- k.serve() }()
+
+	c := connectTestClient(t, info)
+	defer c.Close()
+	c.send("execute_request", map[string]interface{}{
+		"code":             ":import time\ntime.Sleep(5 * time.Second)",
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      false,
+	})
+	time.Sleep(500 * time.Millisecond)
+
+	start := time.Now()
+	req := c.sendOn(c.control, "shutdown_request", map[string]interface{}{"restart": false}, nil)
+	reply := c.recv(c.control)
+	assert.Equal(t, req.Header.MsgID, reply.ParentHeader.MsgID)
+	assert.Equal(t, "shutdown_reply", reply.Header.MsgType)
+	select {
+	case err := <-served:
+		assert.Equal(t, errShutdown, err)
+	case <-time.After(30 * time.Second):
+		t.Fatal("the kernel did not shut down")
+	}
+	assert.True(t, time.Since(start) < 3*time.Second, "shut down in %v", time.Since(start))
+}
+
+// TestServe_concurrent tests that cells, completions and interrupts sent by
+// several clients at once are all answered, the interrupts stopping the cell
+// running, as it is run under the race detector
+func TestServe_concurrent(t *testing.T) {
+	if _, err := importer.Default().Import("github.com/gopherds/gophernotes/gophernotes"); err != nil {
+		t.Skip("gophernotes package not installed:", err)
+	}
+
+	// The interrupted cell stays in the session, which the other tests do
+	// not expect: the kernel is one of its own.
+	info := localConnectionInfo(t)
+	sockets, err := PrepareSockets(info)
+	noError(t, err)
+	k, err := NewKernel(sockets, log.New(ioutil.Discard, "", 0), defaultConfig())
+	noError(t, err)
+	defer k.removeSession()
+	go k.serve()
+
+	c := connectTestClient(t, info)
+	defer c.Close()
+	completer := connectTestClient(t, info)
+	defer completer.Close()
+	interrupter := connectTestClient(t, info)
+	defer interrupter.Close()
+
+	reply, _ := c.execute(":import time")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			req := completer.send("complete_request", map[string]interface{}{"code": "tim", "cursor_pos": 3})
+			msg := completer.recv(completer.shell)
+			assert.Equal(t, req.Header.MsgID, msg.ParentHeader.MsgID)
+			assert.Equal(t, "complete_reply", msg.Header.MsgType)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(500 * time.Millisecond):
+			}
+			req := interrupter.sendOn(interrupter.control, "interrupt_request", map[string]interface{}{}, nil)
+			msg := interrupter.recv(interrupter.control)
+			assert.Equal(t, req.Header.MsgID, msg.ParentHeader.MsgID)
+			assert.Equal(t, "interrupt_reply", msg.Header.MsgType)
+		}
+	}()
+
+	start := time.Now()
+	reply, _ = c.execute("time.Sleep(20 * time.Second)")
+	close(done)
+	wg.Wait()
+	assert.Equal(t, "error", reply.Content.(map[string]interface{})["status"])
+	assert.True(t, time.Since(start) < 10*time.Second, "interrupted in %v", time.Since(start))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// KernelError is the error of a request: its name, value and traceback are
+// those of the error reply, and of the error message of a failing cell. Every
+// error reply is made from one, with replyContent.
+type KernelError struct {
+	EName     string   `json:"ename"`
+	EValue    string   `json:"evalue"`
+	Traceback []string `json:"traceback"`
+}
+
+// Error returns the name and value of the error, so that errors of cells can
+// be passed on as errors, as by the magics running code.
+func (e *KernelError) Error() string {
+	return e.EName + ": " + e.EValue
+}
+
+// newKernelError returns the error ename of value evalue, such as that of a
+// magic failing, its traceback telling both. The traceback is colored, unless
+// the kernel strips the colors as it replies.
+func newKernelError(ename, evalue string) *KernelError {
+	traceback := ansiError + ename + ansiReset + ": " + evalue
+	return &KernelError{EName: ename, EValue: evalue, Traceback: []string{traceback}}
+}
+
+// replyContent returns the content of a reply failing with e.
+func (e *KernelError) replyContent() map[string]interface{} {
+	return map[string]interface{}{
+		"status":    "error",
+		"ename":     e.EName,
+		"evalue":    e.EValue,
+		"traceback": e.Traceback,
+	}
+}
+
+// sendErrorReply answers the request of receipt with a reply failing with e,
+// on the socket the request came from. The reply of a request of type
+// X_request is of type X_reply, and that of a message of another type X, of
+// type X_reply too.
+func (k *Kernel) sendErrorReply(receipt MsgReceipt, e *KernelError) {
+	if k.options().noColor {
+		e.Traceback = uncolored(e.Traceback)
+	}
+	reply := NewMsg(strings.TrimSuffix(receipt.Msg.Header.MsgType, "_request")+"_reply", receipt.Msg)
+	reply.Content = e.replyContent()
+	receipt.SendResponse(receipt.replySocket(), reply)
+}
+
+// unsupportedMessage returns the error of a message of type msgType, which the
+// kernel does not handle.
+func unsupportedMessage(msgType string) *KernelError {
+	return newKernelError("UnsupportedMessage", fmt.Sprintf("messages of type %q are not supported", msgType))
+}
+
+// requestFields are the fields of the content of requests that their handlers
+// cannot do without, by message type, along with their JSON types.
+var requestFields = map[string]map[string]string{
+	"execute_request":  {"code": "a string", "silent": "a boolean"},
+	"shutdown_request": {"restart": "a boolean"},
+}
+
+// checkRequest returns the error of a request whose content cannot be
+// handled: contents are JSON objects, holding the requestFields of their
+// type. Messages other than requests are left to their handlers.
+func checkRequest(msg ComposedMsg) *KernelError {
+	msgType := msg.Header.MsgType
+	if !strings.HasSuffix(msgType, "_request") {
+		return nil
+	}
+	content, ok := msg.Content.(map[string]interface{})
+	if !ok {
+		return newKernelError("InvalidRequest", fmt.Sprintf("the content of %s is %s, not a JSON object", msgType, jsonType(msg.Content)))
+	}
+	var problems []string
+	for name, want := range requestFields[msgType] {
+		if got := jsonType(content[name]); got != want {
+			problems = append(problems, fmt.Sprintf("%s is %s, not %s", name, got, want))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return newKernelError("InvalidRequest", fmt.Sprintf("the content of %s is not valid: %s", msgType, strings.Join(problems, ", ")))
+	}
+	return nil
+}
+
+// jsonType tells the JSON type of v, as decoded by encoding/json, such as "a
+// string", null being told as missing.
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "missing"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	uuid "github.com/nu7hatch/gouuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// shellReply returns the content of the reply to req on the shell socket,
+// along with its type.
+func (c *testClient) shellReply(req ComposedMsg) (string, map[string]interface{}) {
+	for {
+		reply := c.recv(c.shell)
+		if reply.ParentHeader.MsgID == req.Header.MsgID {
+			return reply.Header.MsgType, reply.Content.(map[string]interface{})
+		}
+	}
+}
+
+// TestServe_errorReplies tests that requests of types the kernel does not
+// handle, or whose content it cannot use, are answered with error replies on
+// the socket they came from, and that the kernel goes on serving
+func TestServe_errorReplies(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	msgType, content := c.shellReply(c.send("frobnicate_request", map[string]interface{}{}))
+	assert.Equal(t, "frobnicate_reply", msgType)
+	assert.Equal(t, "error", content["status"])
+	assert.Equal(t, "UnsupportedMessage", content["ename"])
+	assert.Equal(t, `messages of type "frobnicate_request" are not supported`, content["evalue"])
+	assert.NotEmpty(t, content["traceback"])
+
+	content = c.controlRequest("frobnicate_request", map[string]interface{}{})
+	assert.Equal(t, "UnsupportedMessage", content["ename"])
+
+	msgType, content = c.shellReply(c.send("execute_request", map[string]interface{}{"code": 42}))
+	assert.Equal(t, "execute_reply", msgType)
+	assert.Equal(t, "error", content["status"])
+	assert.Equal(t, "InvalidRequest", content["ename"])
+	assert.Equal(t, "the content of execute_request is not valid: code is a number, not a string, silent is missing, not a boolean", content["evalue"])
+
+	msgType, content = c.shellReply(c.sendOn(c.shell, "complete_request", nil, nil))
+	assert.Equal(t, "complete_reply", msgType)
+	assert.Equal(t, "the content of complete_request is missing, not a JSON object", content["evalue"])
+
+	reply, _ := c.execute("1 + 1")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+}
+
+// sendRaw sends a request of type msgType on the shell socket, its content
+// frame being content as it is, and returns it. The frames are signed unless
+// badSignature is set, or cut to the delimiter and the signature if truncated
+// is.
+func (c *testClient) sendRaw(msgType string, content []byte, badSignature, truncated bool) ComposedMsg {
+	u, err := uuid.NewV4()
+	noError(c.t, err)
+	var msg ComposedMsg
+	msg.Header = MsgHeader{MsgID: u.String(), Username: "test", Session: "test", MsgType: msgType}
+	parts, err := msg.ToWireMsg(c.key)
+	noError(c.t, err)
+	parts[4] = content
+	mac := hmac.New(sha256.New, c.key)
+	for _, part := range parts[1:5] {
+		mac.Write(part)
+	}
+	if badSignature {
+		mac.Write([]byte("tampered"))
+	}
+	parts[0] = []byte(hex.EncodeToString(mac.Sum(nil)))
+	if truncated {
+		parts = parts[:1]
+	}
+	noError(c.t, c.shell.SendMultipart(append([][]byte{[]byte("<IDS|MSG>")}, parts...), 0))
+	return msg
+}
+
+// TestServe_malformed tests that requests whose content is not valid JSON are
+// answered with error replies, that messages with a bad signature, or too few
+// parts, are dropped, and that the kernel goes on serving
+func TestServe_malformed(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	msgType, content := c.shellReply(c.sendRaw("complete_request", []byte(`{"code": `), false, false))
+	assert.Equal(t, "complete_reply", msgType)
+	assert.Equal(t, "error", content["status"])
+	assert.Equal(t, "InvalidRequest", content["ename"])
+	assert.Contains(t, content["evalue"], "The content of a message is not valid JSON")
+
+	dropped := c.sendRaw("kernel_info_request", []byte("{}"), true, false)
+	truncated := c.sendRaw("kernel_info_request", []byte("{}"), false, true)
+	req := c.send("kernel_info_request", map[string]interface{}{})
+	reply := c.recv(c.shell)
+	assert.NotEqual(t, dropped.Header.MsgID, reply.ParentHeader.MsgID)
+	assert.NotEqual(t, truncated.Header.MsgID, reply.ParentHeader.MsgID)
+	assert.Equal(t, req.Header.MsgID, reply.ParentHeader.MsgID)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errLineInterrupted is the error of readLine when Ctrl-C is pressed.
+var errLineInterrupted = errors.New("interrupted")
+
+// maxEditorHistory is how many of the lines read before are recalled.
+const maxEditorHistory = 1000
+
+// lineEditor reads the lines of the console. From a terminal, lines are
+// edited in raw mode, with the keys of readline, and the lines read before
+// recalled with the up and down arrows; from anything else, they are read as
+// they come, without prompts.
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// tty is the terminal lines are read from, if they are, put in raw
+	// mode while a line is edited; editing is set for lines to be edited
+	// as from a terminal, as tests do without one.
+	tty     *os.File
+	editing bool
+
+	// history holds the lines read before, oldest first.
+	history []string
+}
+
+// newLineEditor returns an editor reading lines from in, and echoing them to
+// out if in is a terminal.
+func newLineEditor(in io.Reader, out io.Writer) *lineEditor {
+	e := &lineEditor{in: bufio.NewReader(in), out: out}
+	if f, ok := in.(*os.File); ok && isTerminal(f) {
+		e.tty, e.editing = f, true
+	}
+	return e
+}
+
+// addHistory adds line to the lines recalled, unless it is blank or the last
+// of them already.
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxEditorHistory {
+		e.history = e.history[len(e.history)-maxEditorHistory:]
+	}
+}
+
+// readLine reads a line, without its newline, after showing prompt and with
+// initial typed already when lines are edited. It fails with io.EOF at the end
+// of the input, or when Ctrl-D is pressed on an empty line, and with
+// errLineInterrupted when Ctrl-C is pressed.
+func (e *lineEditor) readLine(prompt, initial string) (string, error) {
+	if !e.editing {
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	if e.tty != nil {
+		restore, err := makeRaw(e.tty)
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+	line, err := e.edit(prompt, []rune(initial))
+	if err == nil {
+		e.addHistory(line)
+	}
+	return line, err
+}
+
+// edit reads the keys editing line until Enter is pressed, redrawing it after
+// each of them.
+func (e *lineEditor) edit(prompt string, line []rune) (string, error) {
+	pos := len(line)
+	recalled, current := len(e.history), ""
+	recall := func(i int) {
+		if recalled == len(e.history) {
+			current = string(line)
+		}
+		recalled = i
+		if i == len(e.history) {
+			line = []rune(current)
+		} else {
+			line = []rune(e.history[i])
+		}
+		pos = len(line)
+	}
+
+	for {
+		e.redraw(prompt, line, pos)
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			io.WriteString(e.out, "\r\n")
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			io.WriteString(e.out, "\r\n")
+			return string(line), nil
+		case 3: // Ctrl-C
+			io.WriteString(e.out, "^C\r\n")
+			return "", errLineInterrupted
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(line)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(line) {
+				pos++
+			}
+		case 11: // Ctrl-K
+			line = line[:pos]
+		case 21: // Ctrl-U
+			line, pos = line[pos:], 0
+		case 16: // Ctrl-P
+			if recalled > 0 {
+				recall(recalled - 1)
+			}
+		case 14: // Ctrl-N
+			if recalled < len(e.history) {
+				recall(recalled + 1)
+			}
+		case 27: // Escape sequences of the arrows and the other keys
+			switch e.escapeKey() {
+			case 'A':
+				if recalled > 0 {
+					recall(recalled - 1)
+				}
+			case 'B':
+				if recalled < len(e.history) {
+					recall(recalled + 1)
+				}
+			case 'C':
+				if pos < len(line) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(line)
+			case '~':
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if r == '\t' || r >= ' ' {
+				line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+				pos++
+			}
+		}
+	}
+}
+
+// escapeKey reads the rest of an escape sequence, and returns the key it is
+// for: 'A' to 'D' for the arrows, 'H' and 'F' for Home and End, '~' for
+// Delete, or 0 for the others.
+func (e *lineEditor) escapeKey() rune {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != '[' && r != 'O' {
+		return 0
+	}
+	var params []rune
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return 0
+		}
+		if r < '0' || r > '9' && r != ';' {
+			break
+		}
+		params = append(params, r)
+	}
+	if r != '~' {
+		return r
+	}
+	switch string(params) {
+	case "1", "7":
+		return 'H'
+	case "4", "8":
+		return 'F'
+	case "3":
+		return '~'
+	}
+	return 0
+}
+
+// redraw shows prompt and line on the current line of the terminal, with the
+// cursor at pos. Tabs are shown as four spaces, for the cursor to be placed
+// whatever the tab stops.
+func (e *lineEditor) redraw(prompt string, line []rune, pos int) {
+	show := func(rs []rune) string {
+		return strings.Replace(string(rs), "\t", "    ", -1)
+	}
+	s := "\r" + prompt + show(line) + "\x1b[K"
+	if back := len([]rune(show(line[pos:]))); back > 0 {
+		s += fmt.Sprintf("\x1b[%dD", back)
+	}
+	io.WriteString(e.out, s)
+}
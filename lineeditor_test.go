@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// editorTyping returns an editor editing lines as from a terminal, with keys
+// typed.
+func editorTyping(keys string, history ...string) *lineEditor {
+	return &lineEditor{in: bufio.NewReader(strings.NewReader(keys)), out: ioutil.Discard, editing: true, history: history}
+}
+
+// TestLineEditor tests the keys editing lines, and that the lines read are
+// recalled with the arrows
+func TestLineEditor(t *testing.T) {
+	for _, test := range []struct {
+		keys, initial, want string
+	}{
+		{"abc\r", "", "abc"},
+		{"b\x01a\x05c\r", "", "abc"},
+		{"ac\x1b[Db\r", "", "abc"},
+		{"abd\x7fc\r", "", "abc"},
+		{"xabc\x01\x1b[3~\r", "", "abc"},
+		{"c\x1b[Hb\x02a\r", "", "abc"},
+		{"ab\x01\x1b[C\x1b[Cc\r", "", "abc"},
+		{"abcdef\x02\x02\x02\x0b\r", "", "abc"},
+		{"zzabc\x01\x1b[C\x1b[C\x15\r", "", "abc"},
+		{"\x1b[A\r", "", "second"},
+		{"\x1b[A\x1b[A\r", "", "first"},
+		{"\x1b[A\x1b[A\x1b[A\x1b[B\r", "", "second"},
+		{"typed\x1b[A\x1b[B\r", "", "typed"},
+		{"\x10\x10\x0e\r", "", "second"},
+		{"x\r", "\t", "\tx"},
+	} {
+		line, err := editorTyping(test.keys, "first", "second").readLine("> ", test.initial)
+		noError(t, err)
+		assert.Equal(t, test.want, line, "%q", test.keys)
+	}
+
+	e := editorTyping("one\rone\r\rtwo\r\x1b[A\x1b[A\x1b[A\r")
+	for _, want := range []string{"one", "one", "", "two", "one"} {
+		line, err := e.readLine("> ", "")
+		noError(t, err)
+		assert.Equal(t, want, line)
+	}
+	assert.Equal(t, []string{"one", "two", "one"}, e.history)
+
+	_, err := editorTyping("abc\x03").readLine("> ", "")
+	assert.Equal(t, errLineInterrupted, err)
+	_, err = editorTyping("\x04").readLine("> ", "")
+	assert.Equal(t, io.EOF, err)
+	line, err := editorTyping("ab\x01\x04\r").readLine("> ", "")
+	noError(t, err)
+	assert.Equal(t, "b", line)
+}
+
+// TestLineEditor_redraw tests that lines are redrawn with the cursor where it
+// is, past tabs shown as spaces
+func TestLineEditor_redraw(t *testing.T) {
+	var out bytes.Buffer
+	e := editorTyping("\tab\x1b[D\r")
+	e.out = &out
+	_, err := e.readLine("> ", "")
+	noError(t, err)
+	assert.True(t, strings.HasSuffix(out.String(), "\r>     ab\x1b[K\x1b[1D\r\n"), "%q", out.String())
+}
+
+// TestLineEditor_notTerminal tests that lines not read from a terminal are
+// read as they come
+func TestLineEditor_notTerminal(t *testing.T) {
+	var out bytes.Buffer
+	e := newLineEditor(strings.NewReader("a\x1b[D\r\nb\nlast"), &out)
+	for _, want := range []string{"a\x1b[D", "b", "last"} {
+		line, err := e.readLine("> ", "\t")
+		noError(t, err)
+		assert.Equal(t, want, line)
+	}
+	_, err := e.readLine("> ", "")
+	assert.Equal(t, io.EOF, err)
+	assert.Empty(t, out.String())
+	assert.Empty(t, e.history)
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/gopherds/gophernotes/gophernotes"
+	repl "github.com/gopherds/gophernotes/internal/repl"
+)
+
+// A Magic runs a magic command, given the arguments it was invoked with and,
+// for cell magics, the rest of the cell, exactly as written. Errors returned
+// are shown as the error of the cell.
+type Magic func(ctx *MagicContext, args []string, body string) error
+
+// magic is a registered magic command.
+type magic struct {
+	run   Magic
+	usage string
+	doc   string
+}
+
+// summary returns the first line of the doc of the magic, which describes it
+// in the list of magics.
+func (m magic) summary() string {
+	if i := strings.IndexByte(m.doc, '\n'); i >= 0 {
+		return m.doc[:i]
+	}
+	return m.doc
+}
+
+// magicRegistry holds line magics, invoked by lines starting with "%", and
+// cell magics, invoked by cells starting with "%%", by name.
+type magicRegistry struct {
+	sync.Mutex
+	line map[string]magic
+	cell map[string]magic
+}
+
+// newMagicRegistry returns an empty registry.
+func newMagicRegistry() *magicRegistry {
+	return &magicRegistry{line: map[string]magic{}, cell: map[string]magic{}}
+}
+
+// clone returns a copy of the registry.
+func (r *magicRegistry) clone() *magicRegistry {
+	c := newMagicRegistry()
+	r.Lock()
+	defer r.Unlock()
+	for name, m := range r.line {
+		c.line[name] = m
+	}
+	for name, m := range r.cell {
+		c.cell[name] = m
+	}
+	return c
+}
+
+// registeredMagics are the magics registered by the init functions of the
+// package, which every kernel starts with.
+var registeredMagics = newMagicRegistry()
+
+func init() {
+	RegisterLineMagic("lsmagic", "%lsmagic", "list the available magics", lsmagic)
+	RegisterLineMagic("magic", "%magic <name>", "show the help of a magic\nThe name is that of a line or cell magic, with or without its % or %%.", magicHelp)
+}
+
+// RegisterLineMagic makes lines of cells starting with "%name" run by run, in
+// place of the line magic registered before with name, if any, in the kernels
+// made from then on; it is meant to be called by init functions. usage is the
+// synopsis of the magic, starting with "%name", such as "%cd [<dir> | -]",
+// and doc its help: a line describing it in the list of magics, followed by
+// any details. Registering a magic without them panics.
+func RegisterLineMagic(name, usage, doc string, run Magic) {
+	registeredMagics.register("%", name, usage, doc, run)
+}
+
+// RegisterCellMagic makes cells starting with "%%name" run by run, in place of
+// the cell magic registered before with name, if any, as RegisterLineMagic
+// does. usage and doc are as for RegisterLineMagic, usage starting with
+// "%%name".
+func RegisterCellMagic(name, usage, doc string, run Magic) {
+	registeredMagics.register("%%", name, usage, doc, run)
+}
+
+// RegisterLineMagic registers a line magic with the kernel alone, as the
+// function of the package does for the kernels to come.
+func (k *Kernel) RegisterLineMagic(name, usage, doc string, run Magic) {
+	k.magics.register("%", name, usage, doc, run)
+}
+
+// RegisterCellMagic registers a cell magic with the kernel alone.
+func (k *Kernel) RegisterCellMagic(name, usage, doc string, run Magic) {
+	k.magics.register("%%", name, usage, doc, run)
+}
+
+// register registers the magic name, of the line magics if prefix is "%" and
+// of the cell magics if it is "%%".
+func (r *magicRegistry) register(prefix, name, usage, doc string, run Magic) {
+	if name == "" || magicName(name) != name {
+		panic(fmt.Sprintf("invalid magic name %q", name))
+	}
+	if magicName(usage) != prefix+name {
+		panic(fmt.Sprintf("the usage of %s%s does not start with its name: %q", prefix, name, usage))
+	}
+	if strings.TrimSpace(doc) == "" {
+		panic(fmt.Sprintf("%s%s has no doc", prefix, name))
+	}
+	r.Lock()
+	if prefix == "%" {
+		r.line[name] = magic{run, usage, doc}
+	} else {
+		r.cell[name] = magic{run, usage, doc}
+	}
+	r.Unlock()
+}
+
+// MagicContext is what magics run with: the execute_request of the cell, the
+// kernel running it, and the session they can run code in. Its methods publish
+// on the IOPub socket, and are not safe for concurrent use.
+type MagicContext struct {
+	Receipt MsgReceipt
+	Kernel  *Kernel
+	Session *repl.Session
+
+	// Args is the text following the name of the magic on its line, as
+	// written, which the arguments are split from.
+	Args string
+
+	// Silent is set for silent requests, for which nothing is published.
+	Silent bool
+
+	// ReplyMetadata is the metadata of the execute_reply, which magics may
+	// add to.
+	ReplyMetadata map[string]interface{}
+
+	// payload is the payload of the execute_reply.
+	payload []map[string]interface{}
+}
+
+// Publish publishes a message of msgType with content, as a child of the
+// execute_request.
+func (ctx *MagicContext) Publish(msgType string, content interface{}) {
+	if ctx.Silent {
+		return
+	}
+	msg := NewMsg(msgType, ctx.Receipt.Msg)
+	msg.Content = content
+	ctx.Receipt.SendResponse(ctx.Receipt.Sockets.IOPubSocket, msg)
+}
+
+// Stream publishes text on the stream name, "stdout" or "stderr".
+func (ctx *MagicContext) Stream(name, text string) {
+	ctx.Publish("stream", map[string]interface{}{"name": name, "data": text, "text": text})
+}
+
+// Display publishes data, a bundle of representations by MIME type, along
+// with metadata, which may be nil.
+func (ctx *MagicContext) Display(data, metadata map[string]interface{}) {
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	ctx.Publish("display_data", map[string]interface{}{"data": data, "metadata": metadata})
+}
+
+// Page shows text in the pager of the frontend, rather than in the output of
+// the cell.
+func (ctx *MagicContext) Page(text string) {
+	ctx.PageData(map[string]interface{}{"text/plain": text})
+}
+
+// PageData shows data, a bundle of representations by MIME type, in the pager
+// of the frontend.
+func (ctx *MagicContext) PageData(data map[string]interface{}) {
+	ctx.payload = append(ctx.payload, pagePayload(data))
+}
+
+// SetNextInput has the frontend put text in a new cell following the cell, or
+// in the cell itself in place of its code if replace is set.
+func (ctx *MagicContext) SetNextInput(text string, replace bool) {
+	ctx.payload = append(ctx.payload, map[string]interface{}{
+		"source":  "set_next_input",
+		"text":    text,
+		"replace": replace,
+	})
+}
+
+// errNoStdin is the error of Input for requests not allowing stdin, as it is
+// that of cell code asking for input.
+var errNoStdin = gophernotes.ErrStdinNotAllowed
+
+// Input asks the frontend for a line of input, with prompt, and returns it.
+// The input is hidden as it is typed if password is set. Input fails unless
+// the execute_request allows stdin.
+func (ctx *MagicContext) Input(prompt string, password bool) (string, error) {
+	return ctx.Kernel.readInput(ctx.Receipt, prompt, password)
+}
+
+// Run evaluates code in the session as the code of a cell, publishing what it
+// displays and its result. The error it returns, if the code fails, is an
+// *KernelError, which is shown as it is when the magic returns it.
+func (ctx *MagicContext) Run(code string) error {
+	if errContent := ctx.Kernel.runCode(ctx.Receipt, code, ctx.Silent, ctx.ReplyMetadata); errContent != nil {
+		return errContent
+	}
+	return nil
+}
+
+// isMagicCell reports whether code holds magics or shell commands, to be run
+// by runMagics.
+func isMagicCell(code string) bool {
+	for _, magic := range magicLines(strings.Split(code, "\n")) {
+		if magic {
+			return true
+		}
+	}
+	return false
+}
+
+// magicLines reports which lines invoke magics or shell commands: those
+// starting with "%" or "!", after any indentation, where a statement may start
+// at the top level of the cell. Lines within brackets or raw strings, or
+// continuing an expression, are left alone.
+func magicLines(lines []string) []bool {
+	magic := make([]bool, len(lines))
+	var st lineState
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if st.topLevel() && (strings.HasPrefix(trimmed, "%") || strings.HasPrefix(trimmed, "!")) {
+			magic[i] = true
+			continue
+		}
+		st.scan(line)
+	}
+	return magic
+}
+
+// lineState is what the Go code of a cell leaves open past a line: a raw
+// string, brackets, or an expression the next line continues.
+type lineState struct {
+	inRaw     bool
+	depth     int
+	continued bool
+}
+
+// topLevel reports whether a statement may start at the top level of the cell
+// on a line following the state.
+func (st lineState) topLevel() bool {
+	return !st.inRaw && st.depth == 0 && !st.continued
+}
+
+// scan updates the state past line.
+func (st *lineState) scan(line string) {
+	if st.inRaw {
+		end := strings.IndexByte(line, '`')
+		if end < 0 {
+			return
+		}
+		st.inRaw, st.continued = false, false
+		line = line[end+1:]
+	}
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(line))
+	var sc scanner.Scanner
+	sc.Init(file, []byte(line), func(token.Position, string) {}, 0)
+	for {
+		_, tok, lit := sc.Scan()
+		switch tok {
+		case token.EOF:
+			return
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			st.depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if st.depth > 0 {
+				st.depth--
+			}
+		case token.STRING:
+			if strings.HasPrefix(lit, "`") && (len(lit) == 1 || !strings.HasSuffix(lit, "`")) {
+				st.inRaw = true
+				return
+			}
+		}
+		// Lines end statements where semicolons are inserted.
+		st.continued = tok != token.SEMICOLON
+	}
+}
+
+// runMagics runs a cell holding magics, or any cell when they are formatted
+// before they run. A cell starting with "%%" is handed to its cell magic as it
+// is, and one starting with a %gofmt line to %gofmt along with the rest of the
+// cell. Otherwise, the line magics, shell commands and the Go code between
+// them are run in order, up to the first error, which is returned along with
+// the payload of the execute_reply the magics left.
+func (k *Kernel) runMagics(receipt MsgReceipt, code string, silent bool, metadata map[string]interface{}) ([]map[string]interface{}, *KernelError) {
+	ctx := &MagicContext{Receipt: receipt, Kernel: k, Session: k.session, Silent: silent, ReplyMetadata: metadata}
+	var errContent *KernelError
+	if _, _, ok := gofmtCell(code); !ok && k.options().autoformat && !strings.HasPrefix(strings.TrimLeft(code, " \t\r\n"), "%%") {
+		errContent = ctx.runFormatted("", code)
+	} else {
+		errContent = ctx.runCell(code)
+	}
+	return ctx.payload, errContent
+}
+
+// runCell runs the magics and the code of a cell for runMagics.
+func (ctx *MagicContext) runCell(code string) *KernelError {
+	receipt, silent, metadata := ctx.Receipt, ctx.Silent, ctx.ReplyMetadata
+	trimmed := strings.TrimLeft(code, " \t\r\n")
+	if strings.HasPrefix(trimmed, "%%") {
+		line, body := trimmed[2:], ""
+		if i := strings.IndexByte(line, '\n'); i >= 0 {
+			line, body = line[:i], line[i+1:]
+		}
+		return runMagic(ctx, "cell", line, body)
+	}
+	if line, rest, ok := gofmtCell(code); ok {
+		return runMagic(ctx, "line", line, rest)
+	}
+
+	lines := strings.Split(code, "\n")
+	var goLines []string
+	runGo := func() *KernelError {
+		goCode := strings.Join(goLines, "\n")
+		goLines = nil
+		if strings.TrimSpace(goCode) == "" {
+			return nil
+		}
+		return ctx.Kernel.runCode(receipt, goCode, silent, metadata)
+	}
+	for i, magic := range magicLines(lines) {
+		if !magic {
+			goLines = append(goLines, lines[i])
+			continue
+		}
+		if errContent := runGo(); errContent != nil {
+			return errContent
+		}
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "!") {
+			if errContent := runShell(ctx, line[1:]); errContent != nil {
+				return errContent
+			}
+			continue
+		}
+		line = line[1:]
+		if strings.HasPrefix(line, "%") {
+			return newKernelError("UsageError", fmt.Sprintf("cell magic %%%s must start the cell", magicName(line[1:])))
+		}
+		if magicName(line) == "gofmt" {
+			return newKernelError("UsageError", "%gofmt must start the cell")
+		}
+		if errContent := runMagic(ctx, "line", line, ""); errContent != nil {
+			return errContent
+		}
+	}
+	return runGo()
+}
+
+// runMagic runs the magic of kind "line" or "cell" invoked by line, the text
+// following its "%" or "%%", with body.
+func runMagic(ctx *MagicContext, kind, line, body string) *KernelError {
+	magics := ctx.Kernel.magics
+	prefix := "%"
+	registry := magics.line
+	if kind == "cell" {
+		prefix = "%%"
+		registry = magics.cell
+	}
+
+	name := magicName(line)
+	magics.Lock()
+	m, ok := registry[name]
+	available := magicNames(registry, prefix)
+	magics.Unlock()
+	if !ok {
+		list := "none"
+		if len(available) > 0 {
+			list = strings.Join(available, ", ")
+		}
+		return newKernelError("UsageError", fmt.Sprintf("%s magic %s%s not found; available %s magics: %s", kind, prefix, name, kind, list))
+	}
+
+	ctx.Args = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), name))
+	args, err := splitMagicArgs(ctx.Args)
+	if err != nil {
+		return newKernelError("UsageError", fmt.Sprintf("%s%s: %s", prefix, name, err))
+	}
+	if err := m.run(ctx, args, body); err != nil {
+		if errContent, ok := err.(*KernelError); ok {
+			return errContent
+		}
+		return newKernelError("Error", fmt.Sprintf("%s%s: %s", prefix, name, err))
+	}
+	return nil
+}
+
+// magicName returns the name of the magic invoked by line, up to the first
+// space.
+func magicName(line string) string {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// magicNames returns the sorted names of the magics of registry, with prefix.
+func magicNames(registry map[string]magic, prefix string) []string {
+	var names []string
+	for name := range registry {
+		names = append(names, prefix+name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitMagicArgs splits the arguments of a magic at spaces, as a shell does:
+// quotes, single or double, keep spaces within arguments, and a backslash
+// keeps the character after it outside of single quotes.
+func splitMagicArgs(s string) ([]string, error) {
+	var args []string
+	var arg bytes.Buffer
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		arg.WriteRune('\\')
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// lsmagic lists the available magics.
+func lsmagic(ctx *MagicContext, args []string, body string) error {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 4, ' ', 0)
+	magics := ctx.Kernel.magics
+	magics.Lock()
+	for _, kind := range []struct {
+		title    string
+		prefix   string
+		registry map[string]magic
+	}{{"Line magics:", "%", magics.line}, {"Cell magics:", "%%", magics.cell}} {
+		fmt.Fprintln(w, kind.title)
+		names := magicNames(kind.registry, kind.prefix)
+		if len(names) == 0 {
+			fmt.Fprintln(w, "    none")
+		}
+		for _, name := range names {
+			fmt.Fprintf(w, "    %s\t%s\n", name, kind.registry[strings.TrimPrefix(name, kind.prefix)].summary())
+		}
+	}
+	magics.Unlock()
+	w.Flush()
+	ctx.Stream("stdout", buf.String())
+	return nil
+}
+
+// magicHelp shows the usage and doc of the magics named by its argument in the
+// pager: those of the line and cell magics of the name, without its % or %%.
+func magicHelp(ctx *MagicContext, args []string, body string) error {
+	if len(args) != 1 {
+		return errors.New("usage: %magic <name>")
+	}
+	name := args[0]
+	magics := ctx.Kernel.magics
+	var kinds []map[string]magic
+	switch {
+	case strings.HasPrefix(name, "%%"):
+		name, kinds = name[2:], []map[string]magic{magics.cell}
+	case strings.HasPrefix(name, "%"):
+		name, kinds = name[1:], []map[string]magic{magics.line}
+	default:
+		kinds = []map[string]magic{magics.line, magics.cell}
+	}
+
+	var help []string
+	magics.Lock()
+	for _, registry := range kinds {
+		if m, ok := registry[name]; ok {
+			help = append(help, m.usage+"\n\n    "+strings.Replace(m.doc, "\n", "\n    ", -1)+"\n")
+		}
+	}
+	magics.Unlock()
+	if len(help) == 0 {
+		return fmt.Errorf("no magic named %s; %%lsmagic lists them", args[0])
+	}
+	ctx.Page(strings.Join(help, "\n"))
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSplitMagicArgs tests that the arguments of magics are split as a shell
+// splits them
+func TestSplitMagicArgs(t *testing.T) {
+	cases := []struct {
+		in   string
+		args []string
+	}{
+		{"", nil},
+		{"  a b\tc ", []string{"a", "b", "c"}},
+		{`a "b c" 'd e'`, []string{"a", "b c", "d e"}},
+		{`x="1 2"`, []string{"x=1 2"}},
+		{`a\ b ""`, []string{"a b", ""}},
+		{`'\n' "\""`, []string{`\n`, `"`}},
+	}
+	for _, c := range cases {
+		args, err := splitMagicArgs(c.in)
+		noError(t, err)
+		assert.Equal(t, c.args, args, c.in)
+	}
+
+	_, err := splitMagicArgs(`a "b`)
+	assert.EqualError(t, err, `unterminated " quote`)
+}
+
+// TestMagicLines tests that lines starting with % invoke magics, unless they
+// are within raw strings
+func TestMagicLines(t *testing.T) {
+	lines := []string{
+		"%time",
+		"x := 1 % 2",
+		"  %env A=1",
+		"s := `",
+		"%d items",
+		"` + \"`\"",
+		"%lsmagic",
+	}
+	assert.Equal(t, []bool{true, false, true, false, false, false, true}, magicLines(lines))
+	assert.False(t, isMagicCell("fmt.Printf(`\n%d\n`, 1)"))
+	assert.True(t, isMagicCell("%%bash\necho hi"))
+}
+
+// TestMagics tests that line and cell magics are run by their handlers, in
+// order with the Go code of the cell, that the body of cell magics reaches
+// them untouched, and that unknown magics are reported along with those
+// available
+func TestMagics(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	testKernel.RegisterLineMagic("testecho", "%testecho [<arg>...]", "echo the arguments", func(ctx *MagicContext, args []string, body string) error {
+		ctx.Stream("stdout", strings.Join(args, "|"))
+		return nil
+	})
+	testKernel.RegisterCellMagic("testbody", "%%testbody [<arg>...]", "show the body", func(ctx *MagicContext, args []string, body string) error {
+		ctx.Display(map[string]interface{}{"text/plain": strings.Join(args, "|") + ":" + body}, nil)
+		return nil
+	})
+
+	reply, published := c.execute("%testecho a 'b c'\nmagicX := 3\nmagicX\n%testecho done")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	if assert.Equal(t, []string{"stream", "execute_result", "stream"}, msgTypes(published)) {
+		assert.Equal(t, "a|b c", published[0].Content.(map[string]interface{})["text"])
+		assert.Equal(t, "3\n", published[1].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+		assert.Equal(t, "done", published[2].Content.(map[string]interface{})["text"])
+	}
+
+	count := reply.Content.(map[string]interface{})["execution_count"].(float64)
+	body := "func ( not Go {\n\n  %testecho kept\n"
+	reply, published = c.execute("%%testbody x\n" + body)
+	assert.Equal(t, count+1, reply.Content.(map[string]interface{})["execution_count"])
+	if assert.Equal(t, []string{"display_data"}, msgTypes(published)) {
+		data := published[0].Content.(map[string]interface{})["data"].(map[string]interface{})
+		assert.Equal(t, "x:"+body, data["text/plain"])
+	}
+
+	reply, published = c.execute("%nosuchmagic")
+	content := reply.Content.(map[string]interface{})
+	assert.Equal(t, "error", content["status"])
+	assert.Equal(t, "UsageError", content["ename"])
+	assert.Contains(t, content["evalue"], "%lsmagic")
+	assert.Contains(t, content["evalue"], "%testecho")
+	assert.Equal(t, []string{"error"}, msgTypes(published))
+
+	reply, _ = c.execute("magicY := 1\n%%testbody")
+	assert.Equal(t, "UsageError", reply.Content.(map[string]interface{})["ename"])
+
+	_, published = c.execute("%lsmagic")
+	if assert.Equal(t, []string{"stream"}, msgTypes(published)) {
+		text := published[0].Content.(map[string]interface{})["text"].(string)
+		assert.Contains(t, text, "%lsmagic")
+		assert.Contains(t, text, "%%testbody")
+		assert.Contains(t, text, "show the body")
+	}
+}
+
+// TestRegisterMagic tests that magics are registered with their usage and
+// doc, which the list of magics shows the first line of
+func TestRegisterMagic(t *testing.T) {
+	run := func(ctx *MagicContext, args []string, body string) error { return nil }
+	assert.Panics(t, func() { RegisterLineMagic("testnodoc", "%testnodoc", "", run) })
+	assert.Panics(t, func() { RegisterLineMagic("testusage", "%other", "doc", run) })
+	assert.Panics(t, func() { RegisterCellMagic("testusage", "%testusage", "doc", run) })
+
+	RegisterCellMagic("testhelp", "%%testhelp <arg>", "summary line\nmore details", run)
+	m := registeredMagics.cell["testhelp"]
+	assert.Equal(t, "%%testhelp <arg>", m.usage)
+	assert.Equal(t, "summary line", m.summary())
+}
+
+// TestMagicHelp tests that %magic pages the usage and doc of the line and cell
+// magics of a name
+func TestMagicHelp(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	reply, _ := c.execute("%magic test")
+	content := reply.Content.(map[string]interface{})
+	payload := content["payload"].([]interface{})
+	if assert.Len(t, payload, 1) {
+		text := payload[0].(map[string]interface{})["data"].(map[string]interface{})["text/plain"].(string)
+		assert.Contains(t, text, "%test [-run <regexp>]\n\n    run the test functions")
+		assert.Contains(t, text, "%%test [-run <regexp>]\n\n    run the rest of the cell")
+		assert.Contains(t, text, "\n    A failing test fails the cell.\n")
+	}
+
+	reply, _ = c.execute("%magic %%timeit")
+	payload = reply.Content.(map[string]interface{})["payload"].([]interface{})
+	if assert.Len(t, payload, 1) {
+		text := payload[0].(map[string]interface{})["data"].(map[string]interface{})["text/plain"].(string)
+		assert.True(t, strings.HasPrefix(text, "%%timeit"), text)
+	}
+
+	reply, _ = c.execute("%magic nosuchmagic")
+	content = reply.Content.(map[string]interface{})
+	assert.Equal(t, "error", content["status"])
+	assert.Contains(t, content["evalue"], "no magic named nosuchmagic")
+}
+
+// TestCompleteMagic tests that the names of magics complete at the start of
+// lines, cell magics on the first line only, and that other code is left to
+// the session
+func TestCompleteMagic(t *testing.T) {
+	completions, start, end, ok := completeMagic(registeredMagics, "%ls", 3)
+	assert.True(t, ok)
+	assert.Equal(t, 0, start)
+	assert.Equal(t, 3, end)
+	if assert.Len(t, completions, 1) {
+		assert.Equal(t, repl.Completion{Text: "%lsmagic", Kind: "magic", Detail: "list the available magics"}, completions[0])
+	}
+
+	completions, _, _, _ = completeMagic(registeredMagics, "%%ti", 4)
+	assert.Contains(t, completionTexts(completions), "%%timeit")
+
+	code := "x := 1\n  %ti\n"
+	completions, start, end, ok = completeMagic(registeredMagics, code, 11)
+	assert.True(t, ok)
+	assert.Equal(t, 9, start)
+	assert.Equal(t, 12, end)
+	assert.Contains(t, completionTexts(completions), "%time")
+	assert.NotContains(t, completionTexts(completions), "%%timeit")
+
+	for _, code := range []string{"x := 1 % 2", "x := 1 %", "fmt.Println(\"%d"} {
+		_, _, _, ok = completeMagic(registeredMagics, code, len(code))
+		assert.False(t, ok, code)
+	}
+}
@@ -10,10 +10,90 @@ import (
 
 func main() {
 
+	// gophernotes install sets the kernel up for Jupyter.
+	if len(os.Args) > 1 && os.Args[1] == "install" {
+		err := runInstall(os.Args[2:], currentPlatform(), os.Stdout)
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	// gophernotes run executes a notebook without Jupyter.
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		err := runNotebook(os.Args[2:], os.Stderr)
+		switch err {
+		case nil:
+			return
+		case flag.ErrHelp:
+			os.Exit(2)
+		case errCellFailed:
+			os.Exit(1)
+		}
+		log.Fatalln(err)
+	}
+
+	// gophernotes export writes the code of a notebook as a Go program.
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		err := runExport(os.Args[2:], os.Stdout)
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	// gophernotes console runs a REPL in the terminal, without Jupyter.
+	if len(os.Args) > 1 && os.Args[1] == "console" {
+		err := runConsole(os.Args[2:], os.Stdin, os.Stdout)
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	config := defaultConfig()
 	debug := flag.Bool("debug", false, "Log extra info to stderr")
+	noHistoryFile := flag.Bool("no-history-file", false, "Do not keep the history of the cells run across restarts")
+	flag.BoolVar(&config.noColor, "no-color", config.noColor, "Do not color tracebacks")
+	flag.IntVar(&config.streamMsgRate, "stream-msg-rate", config.streamMsgRate, "Stream messages per second beyond which output is published in batches")
+	flag.IntVar(&config.streamDataRate, "stream-data-rate", config.streamDataRate, "Bytes of stream output per second beyond which output is published in batches")
+	flag.IntVar(&config.streamBlockRate, "stream-block-rate", config.streamBlockRate, "Bytes of stream output per second beyond which commands writing it wait, 0 for never")
+	flag.IntVar(&config.shutdownIdleSeconds, "shutdown-idle-seconds", config.shutdownIdleSeconds, "Seconds without activity after which the kernel shuts down, 0 for never")
+	flag.StringVar(&config.readyFile, "ready-file", "", "Make the file at `PATH` once the kernel serves, touch it every 10 seconds, and remove it on shutdown")
+	flag.StringVar(&config.healthzAddr, "healthz-addr", "", "Serve the health of the kernel over HTTP at /healthz on `HOST:PORT`, such as 127.0.0.1:8080")
+	flag.BoolVar(&config.isolateSessions, "isolate-sessions", false, "Run the cells of each client attached to the kernel in a session of its own, sharing imports and declarations with %share")
+	flag.BoolVar(&config.keepTemp, "keep-temp", false, "Leave the temporary directory of the kernel behind on shutdown, for debugging")
+	flag.BoolVar(&config.subshells, "subshells", false, "Let frontends create subshells running cells while those of the main shell run, an experimental feature")
+
+	var launch remoteLaunch
+	launch.registerFlags(flag.CommandLine)
+	var env startupEnv
+	env.registerFlags(flag.CommandLine)
 
 	flag.Parse()
-	if flag.NArg() < 1 {
+	if err := env.load(log.Printf); err != nil {
+		log.Fatalln("Could not load the environment:", err)
+	}
+	// The environment loaded may set the defaults of the flags not given.
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	defaults := defaultConfig()
+	if !given["no-color"] {
+		config.noColor = defaults.noColor
+	}
+	if !given["shutdown-idle-seconds"] {
+		config.shutdownIdleSeconds = defaults.shutdownIdleSeconds
+	}
+	if flag.NArg() < 1 && launch.responseAddress == "" {
 		log.Fatalln("Need a command line argument for the connection file.")
 	}
 
@@ -21,6 +101,19 @@ func main() {
 	if !*debug {
 		logwriter = ioutil.Discard
 	}
+	if !*noHistoryFile {
+		path, err := defaultHistoryPath()
+		if err != nil {
+			log.Println("Not keeping the history:", err)
+		}
+		config.historyPath = path
+	}
 
-	RunKernel(flag.Arg(0), logwriter)
+	// Kernels launched by Jupyter Enterprise Gateway send their connection
+	// info to it rather than reading it from a file.
+	if flag.NArg() < 1 {
+		RunRemoteKernel(launch, logwriter, config)
+		return
+	}
+	RunKernel(flag.Arg(0), logwriter, config)
 }
@@ -1,23 +1,29 @@
 package main
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"hash"
 	"log"
+	"sync"
 
 	zmq "github.com/alecthomas/gozmq"
 	uuid "github.com/nu7hatch/gouuid"
 	"github.com/pkg/errors"
 )
 
-// MsgHeader encodes header info for ZMQ messages.
+// MsgHeader encodes header info for ZMQ messages. SubshellID tags the
+// requests to run in a subshell; see subshells.
 type MsgHeader struct {
-	MsgID    string `json:"msg_id"`
-	Username string `json:"username"`
-	Session  string `json:"session"`
-	MsgType  string `json:"msg_type"`
+	MsgID      string `json:"msg_id"`
+	Username   string `json:"username"`
+	Session    string `json:"session"`
+	MsgType    string `json:"msg_type"`
+	SubshellID string `json:"subshell_id,omitempty"`
 }
 
 // ComposedMsg represents an entire message in a high-level structure.
@@ -26,6 +32,10 @@ type ComposedMsg struct {
 	ParentHeader MsgHeader
 	Metadata     map[string]interface{}
 	Content      interface{}
+
+	// Buffers are the raw binary parts following the content, which the
+	// signature does not cover.
+	Buffers [][]byte
 }
 
 // InvalidSignatureError is returned when the signature on a received message does not
@@ -36,19 +46,38 @@ func (e *InvalidSignatureError) Error() string {
 	return "A message had an invalid signature"
 }
 
+// DecodeError is returned when a part of a received message, whose signature
+// validates, is not valid JSON.
+type DecodeError struct {
+	Part string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("The %s of a message is not valid JSON: %v", e.Part, e.Err)
+}
+
 // WireMsgToComposedMsg translates a multipart ZMQ messages received from a socket into
 // a ComposedMsg struct and a slice of return identities. This includes verifying the
-// message signature.
+// message signature. Messages without the delimiter, or with too few parts after it,
+// are errors; so are those whose parts are not valid JSON, returned with a
+// DecodeError along with the parts decoded before, the header first, and their
+// identities, for them to be answered.
 func WireMsgToComposedMsg(msgparts [][]byte, signkey []byte) (ComposedMsg, [][]byte, error) {
-
+	var msg ComposedMsg
 	i := 0
-	for string(msgparts[i]) != "<IDS|MSG>" {
+	for i < len(msgparts) && string(msgparts[i]) != "<IDS|MSG>" {
 		i++
 	}
+	if i == len(msgparts) {
+		return msg, nil, errors.New("A message had no <IDS|MSG> delimiter")
+	}
 	identities := msgparts[:i]
+	if len(msgparts) < i+6 {
+		return msg, nil, fmt.Errorf("A message had %d parts after its delimiter, not at least 5", len(msgparts)-i-1)
+	}
 
 	// Validate signature
-	var msg ComposedMsg
 	if len(signkey) != 0 {
 		mac := hmac.New(sha256.New, signkey)
 		for _, msgpart := range msgparts[i+2 : i+6] {
@@ -60,55 +89,113 @@ func WireMsgToComposedMsg(msgparts [][]byte, signkey []byte) (ComposedMsg, [][]b
 			return msg, nil, &InvalidSignatureError{}
 		}
 	}
-	json.Unmarshal(msgparts[i+2], &msg.Header)
-	json.Unmarshal(msgparts[i+3], &msg.ParentHeader)
-	json.Unmarshal(msgparts[i+4], &msg.Metadata)
-	json.Unmarshal(msgparts[i+5], &msg.Content)
+	for j, part := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"header", &msg.Header},
+		{"parent header", &msg.ParentHeader},
+		{"metadata", &msg.Metadata},
+		{"content", &msg.Content},
+	} {
+		if err := json.Unmarshal(msgparts[i+2+j], part.v); err != nil {
+			return msg, identities, &DecodeError{Part: part.name, Err: err}
+		}
+	}
+	msg.Buffers = msgparts[i+6:]
 	return msg, identities, nil
 }
 
 // ToWireMsg translates a ComposedMsg into a multipart ZMQ message ready to send, and
-// signs it. This does not add the return identities or the delimiter.
+// signs it. This does not add the return identities or the delimiter. The buffers of
+// the message follow its content, which is sent as it is if it is a json.RawMessage
+// already encoded, rather than encoded again.
 func (msg ComposedMsg) ToWireMsg(signkey []byte) ([][]byte, error) {
+	e := wireEncoders.Get().(*wireEncoder)
+	defer wireEncoders.Put(e)
+	return e.encode(msg, signkey)
+}
 
-	msgparts := make([][]byte, 5)
-	header, err := json.Marshal(msg.Header)
-	if err != nil {
+// emptyMetadata is the frame of the metadata of messages without any, shared
+// by all of them.
+var emptyMetadata = []byte("{}")
+
+// wireEncoders holds the encoders of ToWireMsg, for each sender to take one
+// along with its buffers rather than allocate them for every message.
+var wireEncoders = sync.Pool{New: func() interface{} {
+	e := &wireEncoder{}
+	e.enc = json.NewEncoder(&e.buf)
+	return e
+}}
+
+// wireEncoder encodes messages into their frames, reusing its buffer, JSON
+// encoder and HMAC from one message to the next.
+type wireEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+	mac hash.Hash
+	key []byte
+	sum [sha256.Size]byte
+}
+
+// encode returns the frames of msg signed with signkey, as ToWireMsg does.
+// They are encoded one after the other in the buffer of the encoder, and
+// copied out together, for the frames to outlive the next message.
+func (e *wireEncoder) encode(msg ComposedMsg, signkey []byte) ([][]byte, error) {
+	msgparts := make([][]byte, 5, 5+len(msg.Buffers))
+	e.buf.Reset()
+	var ends [4]int
+	if err := e.enc.Encode(msg.Header); err != nil {
 		return msgparts, errors.Wrap(err, "Could not marshal message header")
 	}
-	msgparts[1] = header
-
-	parentHeader, err := json.Marshal(msg.ParentHeader)
-	if err != nil {
+	ends[0] = e.buf.Len() - 1
+	if err := e.enc.Encode(msg.ParentHeader); err != nil {
 		return msgparts, errors.Wrap(err, "Could not marshal parent header")
 	}
-	msgparts[2] = parentHeader
-
-	if msg.Metadata == nil {
-		msg.Metadata = make(map[string]interface{})
+	ends[1] = e.buf.Len() - 1
+	if len(msg.Metadata) != 0 {
+		if err := e.enc.Encode(msg.Metadata); err != nil {
+			return msgparts, errors.Wrap(err, "Could not marshal metadata")
+		}
 	}
-	metadata, err := json.Marshal(msg.Metadata)
-	if err != nil {
-		return msgparts, errors.Wrap(err, "Could not marshal metadata")
+	ends[2] = e.buf.Len() - 1
+	if raw, ok := msg.Content.(json.RawMessage); ok && len(raw) != 0 {
+		e.buf.Write(raw)
+		e.buf.WriteByte('\n')
+	} else if err := e.enc.Encode(msg.Content); err != nil {
+		return msgparts, errors.Wrap(err, "Could not marshal content")
 	}
-	msgparts[3] = metadata
+	ends[3] = e.buf.Len() - 1
 
-	content, err := json.Marshal(msg.Content)
-	if err != nil {
-		return msgparts, errors.Wrap(err, "Could not marshal content")
+	// The encoder ends each value with a newline, which the frames leave out.
+	// The signature follows them in the same allocation.
+	n := e.buf.Len()
+	data := make([]byte, n+hex.EncodedLen(sha256.Size))
+	copy(data, e.buf.Bytes())
+	begin := 0
+	for i, end := range ends {
+		if i == 2 && end < begin {
+			msgparts[3] = emptyMetadata
+			continue
+		}
+		msgparts[i+1] = data[begin:end:end]
+		begin = end + 1
 	}
-	msgparts[4] = content
 
 	// Sign the message.
 	if len(signkey) != 0 {
-		mac := hmac.New(sha256.New, signkey)
+		if e.mac == nil || !bytes.Equal(e.key, signkey) {
+			e.mac = hmac.New(sha256.New, signkey)
+			e.key = append(e.key[:0], signkey...)
+		}
+		e.mac.Reset()
 		for _, msgpart := range msgparts[1:] {
-			mac.Write(msgpart)
+			e.mac.Write(msgpart)
 		}
-		msgparts[0] = make([]byte, hex.EncodedLen(mac.Size()))
-		hex.Encode(msgparts[0], mac.Sum(nil))
+		msgparts[0] = data[n:]
+		hex.Encode(msgparts[0], e.mac.Sum(e.sum[:0]))
 	}
-	return msgparts, nil
+	return append(msgparts, msg.Buffers...), nil
 }
 
 // MsgReceipt represents a received message, its return identities, and the sockets for
@@ -117,21 +204,157 @@ type MsgReceipt struct {
 	Msg        ComposedMsg
 	Identities [][]byte
 	Sockets    SocketGroup
+
+	// Control is set if the message was received on the control socket,
+	// where its reply goes back.
+	Control bool
 }
 
-// SendResponse sends a message back to return identites of the received message.
-func (receipt *MsgReceipt) SendResponse(socket *zmq.Socket, msg ComposedMsg) {
+// replySocket returns the socket the reply to the message goes on: the one it
+// was received on.
+func (receipt *MsgReceipt) replySocket() *zmq.Socket {
+	if receipt.Control {
+		return receipt.Sockets.ControlSocket
+	}
+	return receipt.Sockets.ShellSocket
+}
 
-	socket.SendMultipart(receipt.Identities, zmq.SNDMORE)
-	socket.Send([]byte("<IDS|MSG>"), zmq.SNDMORE)
+// delimiter separates the return identities of a message from its parts.
+var delimiter = []byte("<IDS|MSG>")
 
+// sendLocks are held while a message is sent on their socket, for the sends
+// of several goroutines on a socket to follow one another.
+type sendLocks struct {
+	sync.Mutex
+	m map[*zmq.Socket]*sync.Mutex
+}
+
+// newSendLocks returns the locks of a socket group.
+func newSendLocks() *sendLocks {
+	return &sendLocks{m: map[*zmq.Socket]*sync.Mutex{}}
+}
+
+// lock returns the lock of socket.
+func (s *sendLocks) lock(socket *zmq.Socket) *sync.Mutex {
+	s.Lock()
+	defer s.Unlock()
+	l, ok := s.m[socket]
+	if !ok {
+		l = &sync.Mutex{}
+		s.m[socket] = l
+	}
+	return l
+}
+
+// An outbox carries the messages to send on the shell and control sockets to
+// the goroutine serving them, which alone uses the sockets, as zmq sockets may
+// not be used from several threads at once: it polls and receives on them
+// while the requests are handled in other goroutines. A message sent to the
+// empty outbox wakes the serving goroutine through an inproc PAIR socket,
+// whose end the senders share under the lock of the outbox.
+type outbox struct {
+	sync.Mutex
+	owned   map[*zmq.Socket]bool
+	pending []outgoing
+
+	// wake is the end the senders send on, and woken the end the serving
+	// goroutine polls.
+	wake, woken *zmq.Socket
+}
+
+// outgoing is a message waiting in an outbox, as the frames to send on socket.
+type outgoing struct {
+	socket *zmq.Socket
+	frames [][]byte
+}
+
+// newOutbox returns an outbox for sockets, made in context, for the goroutine
+// that creates it to serve.
+func newOutbox(context *zmq.Context, sockets ...*zmq.Socket) (*outbox, error) {
+	o := &outbox{owned: map[*zmq.Socket]bool{}}
+	for _, socket := range sockets {
+		o.owned[socket] = true
+	}
+	var err error
+	if o.woken, err = context.NewSocket(zmq.PAIR); err != nil {
+		return nil, errors.Wrap(err, "Could not create the outbox socket")
+	}
+	if o.wake, err = context.NewSocket(zmq.PAIR); err != nil {
+		return nil, errors.Wrap(err, "Could not create the outbox socket")
+	}
+	address := fmt.Sprintf("inproc://gophernotes-outbox-%p", o)
+	if err := o.woken.Bind(address); err != nil {
+		return nil, errors.Wrap(err, "Could not bind the outbox socket")
+	}
+	if err := o.wake.Connect(address); err != nil {
+		return nil, errors.Wrap(err, "Could not connect the outbox socket")
+	}
+	return o, nil
+}
+
+// send queues frames to be sent on socket by the serving goroutine.
+func (o *outbox) send(socket *zmq.Socket, frames [][]byte) {
+	o.Lock()
+	defer o.Unlock()
+	o.pending = append(o.pending, outgoing{socket, frames})
+	if len(o.pending) == 1 {
+		if err := o.wake.Send(nil, 0); err != nil {
+			log.Println("Could not wake the kernel to send a message:", err)
+		}
+	}
+}
+
+// forward sends the messages waiting in the outbox, once woken: the wake up
+// message is received unless woken is false. It must only be called by the
+// serving goroutine.
+func (o *outbox) forward(woken bool) {
+	if woken {
+		if _, err := o.woken.Recv(0); err != nil {
+			log.Println("Could not receive the outbox wake up:", err)
+		}
+	}
+	o.Lock()
+	pending := o.pending
+	o.pending = nil
+	o.Unlock()
+	for _, out := range pending {
+		if err := out.socket.SendMultipart(out.frames, 0); err != nil {
+			log.Println("Could not send a message:", err)
+		}
+	}
+}
+
+// SendResponse sends a message back to return identites of the received message.
+// The identities, the delimiter and the parts of the message are sent together,
+// as a single multipart message, so that messages sent from several goroutines
+// never interleave. Messages on the sockets of the outbox of the group, if it
+// has one, go through it.
+func (receipt *MsgReceipt) SendResponse(socket *zmq.Socket, msg ComposedMsg) {
+	if deliver := receipt.Sockets.deliver; deliver != nil {
+		deliver(msg)
+		return
+	}
 	msgParts, err := msg.ToWireMsg(receipt.Sockets.Key)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	socket.SendMultipart(msgParts, 0)
-	logger.Println("<--", msg.Header.MsgType)
-	logger.Printf("%+v\n", msg.Content)
+	frames := make([][]byte, 0, len(receipt.Identities)+1+len(msgParts))
+	frames = append(frames, receipt.Identities...)
+	frames = append(frames, delimiter)
+	frames = append(frames, msgParts...)
+
+	if o := receipt.Sockets.outbox; o != nil && o.owned[socket] {
+		o.send(socket, frames)
+	} else {
+		l := receipt.Sockets.sends.lock(socket)
+		l.Lock()
+		socket.SendMultipart(frames, 0)
+		l.Unlock()
+	}
+	if logger := receipt.Sockets.logger; logger != nil {
+		logger.Println("<--", msg.Header.MsgType)
+		logger.Printf("%+v\n", msg.Content)
+	}
 }
 
 // NewMsg creates a new ComposedMsg to respond to a parent message. This includes setting
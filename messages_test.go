@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	zmq "github.com/alecthomas/gozmq"
+	"github.com/stretchr/testify/assert"
+)
+
+// marshalWireMsg translates msg into its frames as ToWireMsg did before it
+// reused its buffers, marshaling each part on its own, for the frames of
+// ToWireMsg to be compared with.
+func marshalWireMsg(msg ComposedMsg, signkey []byte) [][]byte {
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]interface{})
+	}
+	msgparts := make([][]byte, 5)
+	msgparts[1], _ = json.Marshal(msg.Header)
+	msgparts[2], _ = json.Marshal(msg.ParentHeader)
+	msgparts[3], _ = json.Marshal(msg.Metadata)
+	msgparts[4], _ = json.Marshal(msg.Content)
+	if len(signkey) != 0 {
+		mac := hmac.New(sha256.New, signkey)
+		for _, msgpart := range msgparts[1:] {
+			mac.Write(msgpart)
+		}
+		msgparts[0] = make([]byte, hex.EncodedLen(mac.Size()))
+		hex.Encode(msgparts[0], mac.Sum(nil))
+	}
+	return append(msgparts, msg.Buffers...)
+}
+
+// newStreamMsg returns a stream message of text, as cells print them.
+func newStreamMsg(text string) ComposedMsg {
+	var parent ComposedMsg
+	parent.Header = MsgHeader{MsgID: "6f1e6a2c-parent", Username: "test", Session: "session", MsgType: "execute_request"}
+	msg := NewMsg("stream", parent)
+	msg.Content = map[string]interface{}{"name": "stdout", "text": text, "data": text}
+	return msg
+}
+
+// TestToWireMsg tests that the frames of messages are those of their parts
+// marshaled on their own, whatever the message encoded before, and that raw
+// content is sent as it is
+func TestToWireMsg(t *testing.T) {
+	withMetadata := newStreamMsg("<b>&</b>\n")
+	withMetadata.Metadata = map[string]interface{}{"transient": map[string]interface{}{"display_id": "x"}}
+	raw := newStreamMsg("")
+	raw.Content = json.RawMessage(`{"name":"stdout","text":"raw\n"}`)
+	buffers := newStreamMsg("")
+	buffers.Buffers = [][]byte{{1, 2, 3}}
+	empty := newStreamMsg("")
+	empty.Metadata = map[string]interface{}{}
+	empty.Content = nil
+
+	for _, key := range [][]byte{nil, []byte("key"), []byte("other key")} {
+		var frames [][][]byte
+		for _, msg := range []ComposedMsg{newStreamMsg("a line\n"), withMetadata, raw, buffers, empty} {
+			parts, err := msg.ToWireMsg(key)
+			noError(t, err)
+			assert.Equal(t, marshalWireMsg(msg, key), parts)
+			frames = append(frames, parts)
+		}
+		// Frames outlive the messages encoded after them.
+		assert.Equal(t, marshalWireMsg(newStreamMsg("a line\n"), key)[4], frames[0][4])
+	}
+}
+
+// TestWireMsgToComposedMsg_malformed tests that messages without the
+// delimiter, or with too few parts, are errors, and that those whose parts are
+// not valid JSON are returned with a DecodeError, along with the parts decoded
+// before and their identities
+func TestWireMsgToComposedMsg_malformed(t *testing.T) {
+	key := []byte("key")
+	parts, err := newStreamMsg("text\n").ToWireMsg(key)
+	noError(t, err)
+	frames := append([][]byte{[]byte("id"), []byte("<IDS|MSG>")}, parts...)
+
+	_, _, err = WireMsgToComposedMsg(frames[:1], key)
+	assert.EqualError(t, err, "A message had no <IDS|MSG> delimiter")
+	_, _, err = WireMsgToComposedMsg(frames[:4], key)
+	assert.EqualError(t, err, "A message had 2 parts after its delimiter, not at least 5")
+	_, _, err = WireMsgToComposedMsg(append([][]byte{[]byte("id"), []byte("<IDS|MSG>"), []byte("bad")}, parts[1:]...), key)
+	assert.IsType(t, &InvalidSignatureError{}, err)
+
+	msg, ids, err := WireMsgToComposedMsg(frames, nil)
+	noError(t, err)
+	assert.Equal(t, [][]byte{[]byte("id")}, ids)
+	assert.Equal(t, "stream", msg.Header.MsgType)
+
+	frames[6] = []byte(`{"name": `)
+	msg, ids, err = WireMsgToComposedMsg(frames, nil)
+	if assert.IsType(t, &DecodeError{}, err) {
+		assert.Equal(t, "content", err.(*DecodeError).Part)
+	}
+	assert.Equal(t, [][]byte{[]byte("id")}, ids)
+	assert.Equal(t, "stream", msg.Header.MsgType)
+}
+
+// TestSendResponse_concurrent tests that messages sent on a socket from
+// several goroutines at once reach the other end whole, each with its
+// identities, delimiter and signed parts
+func TestSendResponse_concurrent(t *testing.T) {
+	ctx, err := zmq.NewContext()
+	noError(t, err)
+	pub, err := ctx.NewSocket(zmq.PUB)
+	noError(t, err)
+	defer pub.Close()
+	address := fmt.Sprintf("tcp://127.0.0.1:%d", freePort(t))
+	noError(t, pub.Bind(address))
+	sub, err := ctx.NewSocket(zmq.SUB)
+	noError(t, err)
+	defer sub.Close()
+	noError(t, sub.SetSockOptString(zmq.SUBSCRIBE, ""))
+	noError(t, sub.Connect(address))
+	time.Sleep(100 * time.Millisecond)
+
+	key := []byte("stress-key")
+	receipt := MsgReceipt{Identities: [][]byte{[]byte("topic")}, Sockets: SocketGroup{Key: key, sends: newSendLocks()}}
+	const senders, sends = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < sends; j++ {
+				msg := newStreamMsg(fmt.Sprintf("%d:%d\n", i, j))
+				msg.Buffers = [][]byte{[]byte("buffer")}
+				receipt.SendResponse(pub, msg)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[string]bool{}
+	pi := zmq.PollItems{zmq.PollItem{Socket: sub, Events: zmq.POLLIN}}
+	for len(seen) < senders*sends {
+		n, err := zmq.Poll(pi, 10*time.Second)
+		noError(t, err)
+		if n == 0 {
+			t.Fatalf("received %d messages of %d", len(seen), senders*sends)
+		}
+		parts, err := sub.RecvMultipart(0)
+		noError(t, err)
+		if !assert.Len(t, parts, 8) {
+			return
+		}
+		msg, ids, err := WireMsgToComposedMsg(parts, key)
+		noError(t, err)
+		assert.Equal(t, [][]byte{[]byte("topic")}, ids)
+		assert.Equal(t, [][]byte{[]byte("buffer")}, msg.Buffers)
+		text := msg.Content.(map[string]interface{})["text"].(string)
+		assert.False(t, seen[text], "%q received twice", text)
+		seen[text] = true
+	}
+}
+
+// TestSendResponse_router tests that replies sent on a ROUTER socket from
+// several goroutines at once, through the outbox of the socket, reach the
+// client whole while the socket is polled and received on, as serve does
+func TestSendResponse_router(t *testing.T) {
+	ctx, err := zmq.NewContext()
+	noError(t, err)
+	router, err := ctx.NewSocket(zmq.ROUTER)
+	noError(t, err)
+	address := fmt.Sprintf("tcp://127.0.0.1:%d", freePort(t))
+	noError(t, router.Bind(address))
+	requests, err := ctx.NewSocket(zmq.DEALER)
+	noError(t, err)
+	noError(t, requests.Connect(address))
+	replies, err := ctx.NewSocket(zmq.DEALER)
+	noError(t, err)
+	noError(t, replies.SetSockOptString(zmq.IDENTITY, "client"))
+	noError(t, replies.Connect(address))
+	outbox, err := newOutbox(ctx, router)
+	noError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	// The serving goroutine receives the requests, and sends the replies
+	// waiting in the outbox, until the requests are all received and the
+	// replies all sent, or the test stops. The sockets are closed once the
+	// goroutines using them are done, should the test fail.
+	const senders, sends = 8, 50
+	done, stop := make(chan struct{}), make(chan struct{})
+	served, sent := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(stop)
+		<-served
+		<-sent
+		router.Close()
+		requests.Close()
+		replies.Close()
+	}()
+	go func() {
+		defer close(served)
+		pi := zmq.PollItems{
+			zmq.PollItem{Socket: router, Events: zmq.POLLIN},
+			zmq.PollItem{Socket: outbox.woken, Events: zmq.POLLIN},
+		}
+		received := 0
+		for {
+			select {
+			case <-stop:
+				return
+			case <-done:
+				if received == senders*sends {
+					return
+				}
+			default:
+			}
+			if _, err := zmq.Poll(pi, 10*time.Millisecond); err != nil {
+				continue
+			}
+			if pi[0].REvents&zmq.POLLIN != 0 {
+				if _, err := router.RecvMultipart(0); err == nil {
+					received++
+				}
+			}
+			if pi[1].REvents&zmq.POLLIN != 0 {
+				outbox.forward(true)
+			}
+		}
+	}()
+	go func() {
+		defer close(sent)
+		for i := 0; i < senders*sends; i++ {
+			parts, _ := newStreamMsg("request").ToWireMsg(nil)
+			requests.SendMultipart(append([][]byte{[]byte("<IDS|MSG>")}, parts...), 0)
+		}
+	}()
+
+	key := []byte("stress-key")
+	receipt := MsgReceipt{Identities: [][]byte{[]byte("client")}, Sockets: SocketGroup{Key: key, sends: newSendLocks(), outbox: outbox}}
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < sends; j++ {
+				msg := newStreamMsg(fmt.Sprintf("%d:%d\n", i, j))
+				msg.Buffers = [][]byte{[]byte("buffer")}
+				receipt.SendResponse(router, msg)
+			}
+		}(i)
+	}
+
+	seen := map[string]bool{}
+	pi := zmq.PollItems{zmq.PollItem{Socket: replies, Events: zmq.POLLIN}}
+	for len(seen) < senders*sends {
+		n, err := zmq.Poll(pi, 10*time.Second)
+		noError(t, err)
+		if n == 0 {
+			t.Fatalf("received %d replies of %d", len(seen), senders*sends)
+		}
+		parts, err := replies.RecvMultipart(0)
+		noError(t, err)
+		if !assert.Len(t, parts, 7) {
+			return
+		}
+		msg, _, err := WireMsgToComposedMsg(parts, key)
+		noError(t, err)
+		assert.Equal(t, [][]byte{[]byte("buffer")}, msg.Buffers)
+		text := msg.Content.(map[string]interface{})["text"].(string)
+		assert.False(t, seen[text], "%q received twice", text)
+		seen[text] = true
+	}
+	wg.Wait()
+
+	// The requests sent meanwhile were all received.
+	close(done)
+	select {
+	case <-served:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the requests were not all received")
+	}
+}
+
+// BenchmarkToWireMsg measures the encoding of a stream message, against that
+// of marshaling each part on its own as ToWireMsg used to, and that of content
+// already encoded, as the display relay has it:
+//
+//	BenchmarkToWireMsg/marshal	  122427	  9992 ns/op	  1496 B/op	  27 allocs/op
+//	BenchmarkToWireMsg/encoder	  157678	  8031 ns/op	   936 B/op	  15 allocs/op
+//	BenchmarkToWireMsg/raw    	  264788	  4446 ns/op	   800 B/op	   6 allocs/op
+func BenchmarkToWireMsg(b *testing.B) {
+	key := []byte("a2f6c1a4-4d1b-4c19-a6f8-4e3a7c6d2b10")
+	msg := newStreamMsg("iteration 42: loss 0.0371, accuracy 0.982\n")
+	raw := msg
+	content, _ := json.Marshal(msg.Content)
+	raw.Content = json.RawMessage(content)
+
+	b.Run("marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			marshalWireMsg(msg, key)
+		}
+	})
+	b.Run("encoder", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			msg.ToWireMsg(key)
+		}
+	})
+	b.Run("raw", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			raw.ToWireMsg(key)
+		}
+	})
+}
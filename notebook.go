@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// errCellFailed is the error of runNotebook when a cell failed, which it
+// reported already.
+var errCellFailed = errors.New("a cell failed")
+
+// runNotebook runs the run subcommand with args: it executes the code cells of
+// a notebook in order, in a kernel of its own running without sockets, and
+// writes the notebook with their outputs to --output, or in place with
+// --inplace. It stops at the first cell failing, and fails with errCellFailed,
+// unless --allow-errors is given. What it runs and the cells failing are
+// reported to w.
+func runNotebook(args []string, w io.Writer) error {
+	var output string
+	var inplace, allowErrors bool
+	var timeout int
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.SetOutput(w)
+	flags.StringVar(&output, "output", "", "Write the notebook executed to `PATH`")
+	flags.BoolVar(&inplace, "inplace", false, "Write the notebook executed in place of the one given")
+	flags.BoolVar(&allowErrors, "allow-errors", false, "Run every cell, and succeed, even if cells fail")
+	flags.IntVar(&timeout, "timeout", 0, "Seconds each cell may run for, building it included, 0 for as long as it takes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: gophernotes run [flags] notebook.ipynb")
+	}
+	path := flags.Arg(0)
+	switch {
+	case inplace && output != "":
+		return errors.New("only one of --output and --inplace can be given")
+	case inplace:
+		output = path
+	case output == "":
+		return errors.New("one of --output and --inplace is needed")
+	}
+
+	nb, err := readNotebook(path)
+	if err != nil {
+		return err
+	}
+	output, err = filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+
+	// Cells run in the directory of the notebook, as they do in Jupyter.
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	defer os.Chdir(wd)
+
+	r, err := newNotebookRunner(time.Duration(timeout) * time.Second)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if mc := r.kernel.session.ModuleContext(); mc.File != "" {
+		fmt.Fprintln(w, "Imports resolve in", mc)
+	}
+
+	failed := false
+	cells, _ := nb["cells"].([]interface{})
+	for i, c := range cells {
+		cell, ok := c.(map[string]interface{})
+		if !ok || cell["cell_type"] != "code" {
+			continue
+		}
+		code := cellSource(cell["source"])
+		cell["outputs"] = []interface{}{}
+		cell["execution_count"] = nil
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+
+		fmt.Fprintf(w, "Running cell %d of %d\n", i+1, len(cells))
+		reply, published := r.execute(code)
+		content := contentMap(reply.Content)
+		cell["execution_count"] = content["execution_count"]
+		cell["outputs"] = cellOutputs(published)
+		if content["status"] == "error" {
+			fmt.Fprintf(w, "Cell %d failed: %v: %v\n", i+1, content["ename"], content["evalue"])
+			failed = true
+			if !allowErrors {
+				break
+			}
+		}
+	}
+
+	if err := writeNotebook(output, nb); err != nil {
+		return err
+	}
+	if failed && !allowErrors {
+		return errCellFailed
+	}
+	return nil
+}
+
+// readNotebook reads the notebook of path, which must be of version 4 of the
+// notebook format. Numbers are kept as they were written.
+func readNotebook(path string) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var nb map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&nb); err != nil {
+		return nil, fmt.Errorf("%s is not a notebook: %s", path, err)
+	}
+	if v, _ := nb["nbformat"].(json.Number); v != "4" {
+		return nil, fmt.Errorf("%s is of version %v of the notebook format; only version 4 is supported", path, nb["nbformat"])
+	}
+	if _, ok := nb["cells"].([]interface{}); !ok {
+		return nil, fmt.Errorf("%s has no cells", path)
+	}
+	return nb, nil
+}
+
+// writeNotebook writes nb to path as Jupyter does, with its keys sorted and
+// an indentation of one space.
+func writeNotebook(path string, nb map[string]interface{}) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", " ")
+	if err := enc.Encode(nb); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// cellSource returns the source of a cell, which notebooks hold as a string
+// or as a list of lines.
+func cellSource(source interface{}) string {
+	switch s := source.(type) {
+	case string:
+		return s
+	case []interface{}:
+		var b strings.Builder
+		for _, line := range s {
+			if line, ok := line.(string); ok {
+				b.WriteString(line)
+			}
+		}
+		return b.String()
+	}
+	return ""
+}
+
+// notebookRunner runs cells in a kernel without sockets, gathering the
+// messages it sends.
+type notebookRunner struct {
+	kernel *Kernel
+
+	mu   sync.Mutex
+	msgs []ComposedMsg
+}
+
+// newNotebookRunner returns a runner whose cells are killed once they have
+// run for timeout, if it is not 0.
+func newNotebookRunner(timeout time.Duration) (*notebookRunner, error) {
+	r := &notebookRunner{}
+	sockets := SocketGroup{deliver: r.receive}
+	k, err := NewKernel(sockets, log.New(ioutil.Discard, "", 0), defaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	k.session.Timeout = timeout
+	r.kernel = k
+	return r, nil
+}
+
+// receive gathers msg, sent by the kernel.
+func (r *notebookRunner) receive(msg ComposedMsg) {
+	r.mu.Lock()
+	r.msgs = append(r.msgs, msg)
+	r.mu.Unlock()
+}
+
+// newExecuteRequest returns an execute_request running code, kept in the
+// history, for the kernels running without sockets to handle.
+func newExecuteRequest(code string) ComposedMsg {
+	u, err := uuid.NewV4()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var req ComposedMsg
+	req.Header = MsgHeader{MsgID: u.String(), Username: "gophernotes", Session: "gophernotes-run", MsgType: "execute_request"}
+	req.Content = map[string]interface{}{
+		"code":             code,
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      false,
+	}
+	return req
+}
+
+// execute runs code as an execute request, and returns the execute_reply
+// along with the messages published for the request.
+func (r *notebookRunner) execute(code string) (reply ComposedMsg, published []ComposedMsg) {
+	req := newExecuteRequest(code)
+	r.kernel.HandleExecuteRequest(MsgReceipt{Msg: req, Sockets: r.kernel.sockets})
+
+	r.mu.Lock()
+	msgs := r.msgs
+	r.msgs = nil
+	r.mu.Unlock()
+	for _, msg := range msgs {
+		switch {
+		case msg.ParentHeader.MsgID != req.Header.MsgID:
+		case msg.Header.MsgType == "execute_reply":
+			reply = msg
+		default:
+			published = append(published, msg)
+		}
+	}
+	return reply, published
+}
+
+// Close removes what the kernel of the runner leaves behind.
+func (r *notebookRunner) Close() {
+	r.kernel.removeSession()
+}
+
+// removeSession removes what a kernel running without sockets leaves behind,
+// its session directory included, once it is done.
+func (k *Kernel) removeSession() {
+	k.shutdown()
+	if !k.options().keepTemp {
+		os.RemoveAll(filepath.Dir(k.session.FilePath))
+	}
+}
+
+// contentMap returns content, the content of a message as sent, decoded as
+// the frontend would receive it.
+func contentMap(content interface{}) map[string]interface{} {
+	var m map[string]interface{}
+	if b, err := json.Marshal(content); err == nil {
+		json.Unmarshal(b, &m)
+	}
+	return m
+}
+
+// outputDisplayID returns the ID of the display of an output, from the
+// content of its message, or "" if it has none.
+func outputDisplayID(content map[string]interface{}) string {
+	transient, _ := content["transient"].(map[string]interface{})
+	id, _ := transient["display_id"].(string)
+	return id
+}
+
+// cellOutputs returns the outputs of a cell in the notebook format, from the
+// messages published as it ran: streams of the same name following one
+// another are joined, updates of displays change the outputs they display in,
+// and clear_output messages clear the outputs so far, or those before the
+// next one when they wait.
+func cellOutputs(published []ComposedMsg) []interface{} {
+	outputs := []interface{}{}
+	displays := map[string][]map[string]interface{}{}
+	clear := false
+	add := func(output map[string]interface{}) {
+		if clear {
+			outputs, displays, clear = []interface{}{}, map[string][]map[string]interface{}{}, false
+		}
+		outputs = append(outputs, output)
+	}
+	bundle := func(content map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+		data, _ := content["data"].(map[string]interface{})
+		if data == nil {
+			data = map[string]interface{}{}
+		}
+		metadata, _ := content["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = map[string]interface{}{}
+		}
+		return data, metadata
+	}
+
+	for _, msg := range published {
+		content := contentMap(msg.Content)
+		switch msg.Header.MsgType {
+		case "stream":
+			name, _ := content["name"].(string)
+			text, ok := content["text"].(string)
+			if !ok {
+				text, _ = content["data"].(string)
+			}
+			if n := len(outputs); n > 0 && !clear {
+				if last := outputs[n-1].(map[string]interface{}); last["output_type"] == "stream" && last["name"] == name {
+					last["text"] = last["text"].(string) + text
+					continue
+				}
+			}
+			add(map[string]interface{}{"output_type": "stream", "name": name, "text": text})
+		case "display_data":
+			data, metadata := bundle(content)
+			output := map[string]interface{}{"output_type": "display_data", "data": data, "metadata": metadata}
+			add(output)
+			if id := outputDisplayID(content); id != "" {
+				displays[id] = append(displays[id], output)
+			}
+		case "update_display_data":
+			data, metadata := bundle(content)
+			for _, output := range displays[outputDisplayID(content)] {
+				output["data"], output["metadata"] = data, metadata
+			}
+		case "pyout", "execute_result":
+			data, metadata := bundle(content)
+			add(map[string]interface{}{
+				"output_type":     "execute_result",
+				"execution_count": content["execution_count"],
+				"data":            data,
+				"metadata":        metadata,
+			})
+		case "pyerr", "error":
+			traceback, _ := content["traceback"].([]interface{})
+			if traceback == nil {
+				traceback = []interface{}{}
+			}
+			add(map[string]interface{}{
+				"output_type": "error",
+				"ename":       content["ename"],
+				"evalue":      content["evalue"],
+				"traceback":   traceback,
+			})
+		case "clear_output":
+			if wait, _ := content["wait"].(bool); wait {
+				clear = true
+			} else {
+				outputs, displays, clear = []interface{}{}, map[string][]map[string]interface{}{}, false
+			}
+		}
+	}
+	return outputs
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/importer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testNotebook writes a notebook of cells, given as cell types and sources,
+// to a temporary directory, and returns its path. The directory is removed at
+// the end of the test by the function returned.
+func testNotebook(t *testing.T, cells ...[2]string) (string, func()) {
+	if _, err := importer.Default().Import("github.com/gopherds/gophernotes/gophernotes"); err != nil {
+		t.Skip("gophernotes package not installed:", err)
+	}
+	dir, err := ioutil.TempDir("", "gophernotes_run")
+	noError(t, err)
+	nbCells := make([]interface{}, len(cells))
+	for i, c := range cells {
+		cell := map[string]interface{}{"cell_type": c[0], "metadata": map[string]interface{}{}, "source": c[1]}
+		if c[0] == "code" {
+			cell["execution_count"] = nil
+			cell["outputs"] = []interface{}{}
+		}
+		nbCells[i] = cell
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"cells":          nbCells,
+		"metadata":       map[string]interface{}{"kernelspec": map[string]interface{}{"name": "gophernotes"}},
+		"nbformat":       4,
+		"nbformat_minor": 4,
+	})
+	noError(t, err)
+	path := filepath.Join(dir, "test.ipynb")
+	noError(t, ioutil.WriteFile(path, b, 0644))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+// notebookCells reads back the cells of the notebook of path.
+func notebookCells(t *testing.T, path string) []map[string]interface{} {
+	b, err := ioutil.ReadFile(path)
+	noError(t, err)
+	var nb struct {
+		Cells []map[string]interface{} `json:"cells"`
+	}
+	noError(t, json.Unmarshal(b, &nb))
+	return nb.Cells
+}
+
+// TestRunNotebook tests that the code cells of a notebook run in order, in
+// the directory of the notebook, their outputs written in the notebook
+// executed, and that the other cells are left as they are
+func TestRunNotebook(t *testing.T) {
+	path, cleanup := testNotebook(t,
+		[2]string{"markdown", "# Title"},
+		[2]string{"code", "notebookX := 40"},
+		[2]string{"code", "notebookX + 2"},
+		[2]string{"raw", "raw text"},
+		[2]string{"code", "gophernotes.HTML(\"<b>bold</b>\")"},
+		[2]string{"code", ""},
+		[2]string{"code", ":import os\nwd, _ := os.Getwd()\nwd"},
+	)
+	defer cleanup()
+	output := filepath.Join(filepath.Dir(path), "out.ipynb")
+	var log bytes.Buffer
+	noError(t, runNotebook([]string{"--output", output, path}, &log))
+
+	cells := notebookCells(t, output)
+	if !assert.Len(t, cells, 7) {
+		return
+	}
+	assert.Equal(t, "# Title", cells[0]["source"])
+	assert.Equal(t, "raw text", cells[3]["source"])
+	assert.NotContains(t, cells[0], "outputs")
+
+	assert.Equal(t, float64(1), cells[1]["execution_count"])
+	assert.Equal(t, []interface{}{}, cells[1]["outputs"])
+
+	assert.Equal(t, float64(2), cells[2]["execution_count"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"output_type":     "execute_result",
+			"execution_count": float64(2),
+			"data":            map[string]interface{}{"text/plain": "42\n"},
+			"metadata":        map[string]interface{}{},
+		},
+	}, cells[2]["outputs"])
+
+	if outputs := cells[4]["outputs"].([]interface{}); assert.Len(t, outputs, 1) {
+		display := outputs[0].(map[string]interface{})
+		assert.Equal(t, "display_data", display["output_type"])
+		assert.Equal(t, "<b>bold</b>", display["data"].(map[string]interface{})["text/html"])
+		assert.Contains(t, display, "metadata")
+	}
+	assert.Nil(t, cells[5]["execution_count"])
+
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	noError(t, err)
+	if outputs := cells[6]["outputs"].([]interface{}); assert.Len(t, outputs, 1) {
+		assert.Contains(t, outputs[0].(map[string]interface{})["data"].(map[string]interface{})["text/plain"], dir)
+	}
+	assert.Equal(t, "Running cell 2 of 7\nRunning cell 3 of 7\nRunning cell 5 of 7\nRunning cell 7 of 7\n", log.String())
+}
+
+// TestRunNotebook_errors tests that running a notebook stops at the first
+// cell failing, and fails, unless errors are allowed, and that cells running
+// for too long are stopped
+func TestRunNotebook_errors(t *testing.T) {
+	path, cleanup := testNotebook(t,
+		[2]string{"code", "panic(\"boom\")"},
+		[2]string{"code", "notebookY := 1\nnotebookY"},
+	)
+	defer cleanup()
+
+	var log bytes.Buffer
+	assert.Equal(t, errCellFailed, runNotebook([]string{"--inplace", path}, &log))
+	assert.Contains(t, log.String(), "Cell 1 failed: ")
+	cells := notebookCells(t, path)
+	if outputs := cells[0]["outputs"].([]interface{}); assert.Len(t, outputs, 1) {
+		assert.Equal(t, "error", outputs[0].(map[string]interface{})["output_type"])
+	}
+	assert.Nil(t, cells[1]["execution_count"], "the cells after the one failing are left")
+
+	noError(t, runNotebook([]string{"--inplace", "--allow-errors", path}, &log))
+	cells = notebookCells(t, path)
+	assert.Equal(t, float64(2), cells[1]["execution_count"])
+
+	path, cleanup = testNotebook(t, [2]string{"code", ":import time\ntime.Sleep(time.Minute)"})
+	defer cleanup()
+	start := time.Now()
+	assert.Equal(t, errCellFailed, runNotebook([]string{"--inplace", "--timeout", "10", path}, &log))
+	assert.True(t, time.Since(start) < 30*time.Second, "stopped after %v", time.Since(start))
+	if outputs := notebookCells(t, path)[0]["outputs"].([]interface{}); assert.Len(t, outputs, 1) {
+		assert.Contains(t, outputs[0].(map[string]interface{})["evalue"], "stopped after running for 10s")
+	}
+}
+
+// TestRunNotebook_invalid tests that notebooks of other versions of the format,
+// and missing outputs, are refused
+func TestRunNotebook_invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophernotes_run")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+	old := filepath.Join(dir, "old.ipynb")
+	noError(t, ioutil.WriteFile(old, []byte(`{"nbformat": 3, "nbformat_minor": 0, "worksheets": []}`), 0644))
+
+	var log bytes.Buffer
+	assert.Error(t, runNotebook([]string{"--inplace", old}, &log))
+	assert.Error(t, runNotebook([]string{old}, &log))
+	assert.Error(t, runNotebook([]string{"--inplace", "--output", "x.ipynb", old}, &log))
+	assert.Error(t, runNotebook([]string{"--inplace", filepath.Join(dir, "missing.ipynb")}, &log))
+}
+
+// TestCellOutputs tests that the messages published by a cell make outputs of
+// the notebook format: streams joined, displays updated and outputs cleared
+func TestCellOutputs(t *testing.T) {
+	msg := func(msgType string, content map[string]interface{}) ComposedMsg {
+		m := NewMsg(msgType, ComposedMsg{})
+		m.Content = content
+		return m
+	}
+	stream := func(name, text string) ComposedMsg {
+		return msg("stream", map[string]interface{}{"name": name, "text": text})
+	}
+	display := func(msgType, id, text string) ComposedMsg {
+		return msg(msgType, map[string]interface{}{
+			"data":      map[string]interface{}{"text/plain": text},
+			"transient": map[string]interface{}{"display_id": id},
+		})
+	}
+	displayOutput := func(text string) map[string]interface{} {
+		return map[string]interface{}{"output_type": "display_data", "data": map[string]interface{}{"text/plain": text}, "metadata": map[string]interface{}{}}
+	}
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"output_type": "stream", "name": "stdout", "text": "a\nb\n"},
+		map[string]interface{}{"output_type": "stream", "name": "stderr", "text": "c\n"},
+		displayOutput("50%"),
+		map[string]interface{}{"output_type": "stream", "name": "stdout", "text": "d\n"},
+	}, cellOutputs([]ComposedMsg{
+		stream("stdout", "a\n"),
+		stream("stdout", "b\n"),
+		stream("stderr", "c\n"),
+		display("display_data", "progress", "0%"),
+		msg("status", map[string]interface{}{"execution_state": "busy"}),
+		stream("stdout", "d\n"),
+		display("update_display_data", "progress", "50%"),
+	}))
+
+	assert.Equal(t, []interface{}{displayOutput("2")}, cellOutputs([]ComposedMsg{
+		stream("stdout", "gone\n"),
+		msg("clear_output", map[string]interface{}{"wait": true}),
+		display("display_data", "frame", "2"),
+	}))
+	assert.Equal(t, []interface{}{}, cellOutputs([]ComposedMsg{
+		display("display_data", "frame", "1"),
+		msg("clear_output", map[string]interface{}{"wait": false}),
+		display("update_display_data", "frame", "2"),
+	}))
+}
@@ -0,0 +1,98 @@
+package main
+
+import "unicode/utf8"
+
+// Jupyter counts the positions of cursors in code in Unicode code points,
+// while Go slices strings by bytes. Some frontends count them in UTF-16 code
+// units instead, as JavaScript does, which the protocol asks kernels to put
+// up with. Cursor positions are converted by the handlers of requests with
+// cursorOffset as they arrive, and back with cursorPos as they are replied.
+
+// cursorUnits are what a frontend counts the positions of cursors in.
+type cursorUnits int
+
+const (
+	codePoints cursorUnits = iota
+	utf16Units
+)
+
+// cursorOffset converts pos, the position of a cursor in code sent by a
+// frontend, to an offset in bytes, and tells what pos is counted in, for the
+// positions replied to be counted in it too. Positions are taken as code
+// points, unless they are past the end of code in code points but not in
+// UTF-16 units, where they fall between two runes: only code with runes
+// beyond the Basic Multilingual Plane, such as emoji, tells the two apart,
+// and UTF-16 positions then run ahead. Positions past the end of code either
+// way are brought back to it.
+func cursorOffset(code string, pos int) (int, cursorUnits) {
+	if pos > utf8.RuneCountInString(code) {
+		if i, ok := utf16ByteOffset(code, pos); ok {
+			return i, utf16Units
+		}
+	}
+	return byteOffset(code, pos), codePoints
+}
+
+// cursorPos converts i, an offset in bytes of code, to the position of a
+// cursor counted in units.
+func cursorPos(code string, i int, units cursorUnits) int {
+	if units == utf16Units {
+		return utf16Offset(code, i)
+	}
+	return codePointOffset(code, i)
+}
+
+// byteOffset converts n, an offset in code points of s, to an offset in bytes.
+// Offsets past the end of s are brought back to it.
+func byteOffset(s string, n int) int {
+	i := 0
+	for ; n > 0 && i < len(s); n-- {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return i
+}
+
+// codePointOffset converts i, an offset in bytes of s, to an offset in code
+// points. Offsets past the end of s are brought back to it.
+func codePointOffset(s string, i int) int {
+	if i > len(s) {
+		i = len(s)
+	}
+	return utf8.RuneCountInString(s[:i])
+}
+
+// utf16ByteOffset converts n, an offset in UTF-16 code units of s, to an
+// offset in bytes, and reports whether n falls between two runes of s rather
+// than in the middle of a surrogate pair or past its end. Bytes that are not
+// UTF-8 count as a unit each, as the replacement character they decode to.
+func utf16ByteOffset(s string, n int) (int, bool) {
+	i := 0
+	for n > 0 && i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		n -= utf16Len(r)
+		i += size
+	}
+	return i, n == 0
+}
+
+// utf16Offset converts i, an offset in bytes of s, to an offset in UTF-16
+// code units. Offsets past the end of s are brought back to it.
+func utf16Offset(s string, i int) int {
+	if i > len(s) {
+		i = len(s)
+	}
+	n := 0
+	for _, r := range s[:i] {
+		n += utf16Len(r)
+	}
+	return n
+}
+
+// utf16Len returns the number of UTF-16 code units encoding r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
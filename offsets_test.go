@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestByteOffset tests the conversion of Jupyter cursor positions, in
+// characters, to byte offsets
+func TestByteOffset(t *testing.T) {
+	assert.Equal(t, 3, byteOffset("abc", 3))
+	assert.Equal(t, 4, byteOffset("ébc", 3))
+	assert.Equal(t, 4, byteOffset("ébc", 10))
+	assert.Equal(t, 0, byteOffset("abc", -1))
+	assert.Equal(t, 5, byteOffset("😀b", 2))
+	assert.Equal(t, 7, byteOffset("数据x", 3))
+}
+
+// TestOffsets_roundTrip tests that converting code point offsets to byte
+// offsets and back is lossless, in code mixing scripts, emoji and bytes that
+// are not UTF-8
+func TestOffsets_roundTrip(t *testing.T) {
+	for _, s := range []string{"", "abc", "x := \"数据 😀\" // ö", "a\xffb", "👩‍👩‍👧"} {
+		n := codePointOffset(s, len(s))
+		for i := 0; i <= n; i++ {
+			assert.Equal(t, i, codePointOffset(s, byteOffset(s, i)), "%q %d", s, i)
+		}
+		assert.Equal(t, n, codePointOffset(s, len(s)+10), s)
+	}
+}
+
+// TestCursorOffset tests the conversion of the cursor positions frontends send
+// to byte offsets and back, with emoji, CJK and combining characters before,
+// at and after the cursor, the positions of frontends counting in UTF-16 units
+// being told apart once they run past the end of the code in code points
+func TestCursorOffset(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		code   string
+		pos    int
+		offset int
+		units  cursorUnits
+		back   int
+	}{
+		{"ASCII", "abc", 2, 2, codePoints, 2},
+		{"emoji before", "😀x", 1, 4, codePoints, 1},
+		{"emoji at", "a😀", 1, 1, codePoints, 1},
+		{"emoji after", "ab😀", 2, 2, codePoints, 2},
+		{"emoji before, at the end in code points", "😀x", 2, 5, codePoints, 2},
+		{"emoji before, in UTF-16", "😀x", 3, 5, utf16Units, 3},
+		{"emojis before, in UTF-16", "😀😀x", 4, 8, utf16Units, 4},
+		{"emoji at, in UTF-16", "😀😀x😀", 5, 9, utf16Units, 5},
+		{"in the middle of a surrogate pair", "😀😀", 3, 8, codePoints, 2},
+		{"CJK before", "数据x", 2, 6, codePoints, 2},
+		{"CJK at", "x数据", 1, 1, codePoints, 1},
+		{"CJK after", "ab数", 2, 2, codePoints, 2},
+		{"CJK past the end", "数据", 5, 6, codePoints, 2},
+		{"combining character before", "e\u0301x", 2, 3, codePoints, 2},
+		{"combining character at", "e\u0301x", 1, 1, codePoints, 1},
+		{"combining character after", "ae\u0301", 1, 1, codePoints, 1},
+		{"combining character and emoji before, in UTF-16", "e\u0301😀x", 5, 8, utf16Units, 5},
+		{"past the end", "abc", 10, 3, codePoints, 3},
+		{"negative", "abc", -1, 0, codePoints, 0},
+	} {
+		offset, units := cursorOffset(tt.code, tt.pos)
+		assert.Equal(t, tt.offset, offset, tt.name)
+		assert.Equal(t, tt.units, units, tt.name)
+		assert.Equal(t, tt.back, cursorPos(tt.code, offset, units), tt.name)
+	}
+}
+
+// TestComplete_unicode tests that the cursor positions of completion replies
+// are in code points when non-ASCII text comes before the completion point
+func TestComplete_unicode(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	_, _, err = s.Eval("counter := 1")
+	noError(t, err)
+
+	for _, code := range []string{
+		"label := \"😀🎉\"; x := cou",
+		"label := \"数据分析\" // 注释\nx := cou",
+	} {
+		pos := codePointOffset(code, len(code))
+		cursor := byteOffset(code, pos)
+		completions, start, end := s.Complete(code, cursor)
+		reply := newCompleteReply(code, cursor, codePoints, completions, start, end)
+		assert.Contains(t, reply.Matches, "counter", code)
+		assert.Equal(t, "cou", reply.MatchedText, code)
+		assert.Equal(t, pos-3, reply.CursorStart, code)
+		assert.Equal(t, pos, reply.CursorEnd, code)
+
+		// The range replied replaces the token typed.
+		runes := []rune(code)
+		assert.Equal(t, "cou", string(runes[reply.CursorStart:reply.CursorEnd]), code)
+	}
+}
+
+// TestInspect_unicode tests that inspection finds the identifier at a cursor
+// position given in code points after non-ASCII text
+func TestInspect_unicode(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	code := "s := \"🙂 数据\"; strings.ToUpper(s)"
+	pos := len([]rune("s := \"🙂 数据\"; strings.ToU"))
+	in, found := s.Inspect(code, byteOffset(code, pos))
+	for i := 0; i < 100 && !found; i++ {
+		time.Sleep(10 * time.Millisecond)
+		in, found = s.Inspect(code, byteOffset(code, pos))
+	}
+	assert.True(t, found)
+	assert.Equal(t, "strings.ToUpper", in.Name)
+}
+
+// TestComplete_utf16 tests that the cursor range of a completion reply is
+// counted in UTF-16 units when the frontend counts the cursor position in them
+func TestComplete_utf16(t *testing.T) {
+	s, err := repl.NewSession()
+	noError(t, err)
+	_, _, err = s.Eval("counter := 1")
+	noError(t, err)
+
+	code := "label := \"😀🎉\"; x := cou"
+	pos := len(utf16.Encode([]rune(code)))
+	cursor, units := cursorOffset(code, pos)
+	assert.Equal(t, len(code), cursor)
+	completions, start, end := s.Complete(code, cursor)
+	reply := newCompleteReply(code, cursor, units, completions, start, end)
+	assert.Contains(t, reply.Matches, "counter")
+	assert.Equal(t, pos-3, reply.CursorStart)
+	assert.Equal(t, pos, reply.CursorEnd)
+}
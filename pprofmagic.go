@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterCellMagic("pprof", "%%pprof cpu | heap [-n <rows>]", "profile the cell, showing where it spends its time or what it allocates\ncpu runs the cell under the CPU profiler; heap shows what it allocated, between two snapshots of the heap profile.\n-n sets the number of rows of the table, 20 by default.", pprofMagic)
+}
+
+// pprofRows is the number of rows of the tables of %%pprof, unless -n is
+// given.
+const pprofRows = 20
+
+// pprofMagic runs the body of the cell under the CPU profiler, or between two
+// snapshots of the heap profile, then shows the table of the functions
+// spending the most, as go tool pprof -top lists them, and the call graph as
+// an SVG image when graphviz is installed. The profile stays in a temporary
+// file, the path of which is printed for go tool pprof to dig further.
+func pprofMagic(ctx *MagicContext, args []string, body string) error {
+	if len(args) == 0 || (args[0] != "cpu" && args[0] != "heap") {
+		return errors.New("a profile is needed: cpu or heap")
+	}
+	kind, rows := args[0], pprofRows
+	for i := 1; i < len(args); i++ {
+		if args[i] != "-n" {
+			return fmt.Errorf("unknown argument %s", args[i])
+		}
+		if i+1 == len(args) {
+			return errors.New("-n needs a number")
+		}
+		i++
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("-n needs a positive number, not %s", args[i])
+		}
+		rows = n
+	}
+	if strings.TrimSpace(body) == "" {
+		return errors.New("no code to profile")
+	}
+	if !ctx.Session.Runtime() {
+		return errNoRuntime
+	}
+
+	path, err := profileFile(ctx.TempDir(), kind)
+	if err != nil {
+		return err
+	}
+	// The profile tells what ran, and the heap profile what was allocated,
+	// since its base was written.
+	pprofArgs := []string{"-sample_index=alloc_space", "-diff_base", path + ".base"}
+	code := fmt.Sprintf("gophernotes.ProfileHeap(%q, %q, func() {\n%s\n})", path, path+".base", body)
+	if kind == "cpu" {
+		pprofArgs = nil
+		code = fmt.Sprintf("gophernotes.ProfileCPU(%q, func() {\n%s\n})", path, body)
+	}
+	if err := ctx.Run(code); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		return errors.New("the cell wrote no profile")
+	}
+
+	top, err := goToolPprof(append(pprofArgs, "-top", "-nodecount="+strconv.Itoa(rows), path)...)
+	if err != nil {
+		return err
+	}
+	ctx.Stream("stdout", string(top))
+	if _, err := exec.LookPath("dot"); err == nil {
+		if svg, err := goToolPprof(append(pprofArgs, "-svg", path)...); err == nil {
+			ctx.Display(map[string]interface{}{
+				"image/svg+xml": string(svg),
+				"text/plain":    fmt.Sprintf("<%s profile graph of %s>", kind, path),
+			}, nil)
+		}
+	}
+	command := "go tool pprof " + path
+	if kind == "heap" {
+		command = "go tool pprof -diff_base " + path + ".base " + path
+	}
+	ctx.Stream("stdout", fmt.Sprintf("The profile is in %s; dig further with:\n    %s\n", path, command))
+	return nil
+}
+
+// profileFile returns the path of a new file in dir for a profile of kind.
+func profileFile(dir, kind string) (string, error) {
+	f, err := ioutil.TempFile(dir, "gophernotes_"+kind+"_")
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// goToolPprof runs go tool pprof with args, and returns its output, or its
+// error output as the error if it fails.
+func goToolPprof(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("go", append([]string{"tool", "pprof"}, args...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("go tool pprof: %s", msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
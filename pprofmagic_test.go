@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPprofMagic tests that %%pprof shows the top functions of the CPU or heap
+// profile of the cell, how long it ran, and the file the profile is in
+func TestPprofMagic(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+	defer c.execute("%reset -f")
+
+	profilePath := regexp.MustCompile(`The profile is in (\S+);`)
+
+	reply, published := c.execute("%%pprof cpu -n 5\npprofN := 0\nfor i := 0; i < 1e8; i++ {\n\tpprofN += i % 7\n}")
+	content := reply.Content.(map[string]interface{})
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	stdout := streamText(published, "stdout")
+	assert.Contains(t, stdout, "under the CPU profiler, sampling at 100 Hz")
+	assert.Contains(t, stdout, "Type: cpu")
+	if m := profilePath.FindStringSubmatch(stdout); assert.NotNil(t, m, stdout) {
+		defer os.Remove(m[1])
+		_, err := os.Stat(m[1])
+		assert.NoError(t, err)
+	}
+
+	reply, published = c.execute("%%pprof heap\npprofB := make([][]byte, 0)\nfor i := 0; i < 100; i++ {\n\tpprofB = append(pprofB, make([]byte, 1<<16))\n}")
+	content = reply.Content.(map[string]interface{})
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	stdout = streamText(published, "stdout")
+	assert.Contains(t, stdout, "an allocation sampled every 4 KiB")
+	assert.Contains(t, stdout, "Type: alloc_space")
+	assert.Contains(t, stdout, "go tool pprof -diff_base ")
+	if m := profilePath.FindStringSubmatch(stdout); assert.NotNil(t, m, stdout) {
+		defer os.Remove(m[1])
+		defer os.Remove(m[1] + ".base")
+	}
+
+	reply, _ = c.execute("%%pprof mutex\npprofN")
+	assert.Equal(t, "%%pprof: a profile is needed: cpu or heap", reply.Content.(map[string]interface{})["evalue"])
+}
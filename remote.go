@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// remoteLaunch is how Jupyter Enterprise Gateway, or a kernel provisioner
+// launching kernels as it does, asks the kernel to start: rather than writing
+// a connection file, it gives the address the kernel sends the connection info
+// it makes up to, the public key to encrypt it with, if any, the range of the
+// ports to pick, and the ID of the kernel.
+type remoteLaunch struct {
+	kernelID        string
+	responseAddress string
+	publicKey       string
+	portRange       string
+}
+
+// remoteLauncherVersion is the version of the encrypted payloads of the
+// connection info, as the launchers of the gateway number them.
+const remoteLauncherVersion = 1
+
+// remoteResponseTimeout is how long connecting to the response address may
+// take.
+const remoteResponseTimeout = 30 * time.Second
+
+// remoteConnectionInfo is the connection info sent to the gateway: that of
+// the kernel, with its process and the comm port through which the gateway
+// signals it.
+type remoteConnectionInfo struct {
+	ConnectionInfo
+	KernelID string `json:"kernel_id"`
+	PID      int    `json:"pid"`
+	PGID     int    `json:"pgid"`
+	CommPort int    `json:"comm_port"`
+}
+
+// remoteRequest is a request of the gateway on the comm port: sending the
+// kernel a signal, 0 checking it is alive, or shutting it down.
+type remoteRequest struct {
+	Signum   *int `json:"signum"`
+	Shutdown int  `json:"shutdown"`
+}
+
+// registerFlags adds the flags the launchers of the gateway take to flags,
+// defaulting to the environment variables the kernel images of the gateway
+// set.
+func (l *remoteLaunch) registerFlags(flags *flag.FlagSet) {
+	flags.StringVar(&l.kernelID, "RemoteProcessProxy.kernel-id", os.Getenv("KERNEL_ID"), "The `ID` of the kernel, launched by Jupyter Enterprise Gateway")
+	flags.StringVar(&l.responseAddress, "RemoteProcessProxy.response-address", firstEnv("RESPONSE_ADDRESS", "EG_RESPONSE_ADDRESS"), "Make up the connection info, and send it to `HOST:PORT`, rather than reading a connection file")
+	flags.StringVar(&l.publicKey, "RemoteProcessProxy.public-key", firstEnv("PUBLIC_KEY", "EG_PUBLIC_KEY"), "The RSA public `KEY`, base64-encoded, the connection info is encrypted with")
+	flags.StringVar(&l.portRange, "RemoteProcessProxy.port-range", firstEnv("PORT_RANGE", "EG_PORT_RANGE"), "The `LOW..HIGH` range of the ports of the kernel, 0..0 for any")
+	flags.String("RemoteProcessProxy.spark-context-initialization-mode", "none", "Ignored, for there is no Spark context in Go")
+}
+
+// firstEnv returns the value of the first of the environment variables names
+// that is set.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// RunRemoteKernel starts the kernel as the gateway asked it to in launch,
+// sending it the connection info the kernel makes up before binding its
+// sockets, and then runs as RunKernel does.
+func RunRemoteKernel(launch remoteLaunch, logwriter io.Writer, config kernelConfig) {
+	logger := log.New(logwriter, "gophernotes ", log.LstdFlags)
+
+	connInfo, comm, err := launch.connect()
+	if err != nil {
+		log.Fatalln("Could not send the connection info to the gateway:", err)
+	}
+	logger.Printf("%+v\n", connInfo)
+	k := startKernel(connInfo, logger, config)
+	go serveRemoteRequests(comm, logger, func() {
+		k.shutdown()
+		os.Exit(0)
+	})
+	k.run()
+}
+
+// connect picks the ports and the key of the kernel, and sends the connection
+// info to the response address, encrypted if there is a public key. It
+// returns the connection info, along with the listener of the comm port. The
+// sockets are bound once the gateway has the connection info, on every
+// interface; the gateway is told the address of the interface it is reached
+// through.
+func (l remoteLaunch) connect() (ConnectionInfo, net.Listener, error) {
+	low, high, err := parsePortRange(l.portRange)
+	if err != nil {
+		return ConnectionInfo{}, nil, err
+	}
+
+	// The listeners are held until every port is picked, for them to
+	// differ.
+	var ports [6]int
+	var listeners []net.Listener
+	for i := range ports {
+		ln, err := listenInRange(low, high)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return ConnectionInfo{}, nil, err
+		}
+		listeners = append(listeners, ln)
+		ports[i] = ln.Addr().(*net.TCPAddr).Port
+	}
+	comm := listeners[5]
+	for _, ln := range listeners[:5] {
+		ln.Close()
+	}
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		comm.Close()
+		return ConnectionInfo{}, nil, err
+	}
+	connInfo := ConnectionInfo{
+		SignatureScheme: "hmac-sha256",
+		Transport:       "tcp",
+		ShellPort:       ports[0],
+		IOPubPort:       ports[1],
+		StdinPort:       ports[2],
+		ControlPort:     ports[3],
+		HBPort:          ports[4],
+		Key:             u.String(),
+		IP:              "0.0.0.0",
+	}
+	if err := l.respond(connInfo, ports[5]); err != nil {
+		comm.Close()
+		return ConnectionInfo{}, nil, err
+	}
+	return connInfo, comm, nil
+}
+
+// respond sends connInfo, with the comm port commPort, to the response
+// address.
+func (l remoteLaunch) respond(connInfo ConnectionInfo, commPort int) error {
+	conn, err := net.DialTimeout("tcp", l.responseAddress, remoteResponseTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	info := remoteConnectionInfo{
+		ConnectionInfo: connInfo,
+		KernelID:       l.kernelID,
+		PID:            os.Getpid(),
+		PGID:           processGroup(),
+		CommPort:       commPort,
+	}
+	info.IP = conn.LocalAddr().(*net.TCPAddr).IP.String()
+	payload, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if l.publicKey != "" {
+		if payload, err = encryptConnectionInfo(payload, l.publicKey); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Write(payload)
+	return err
+}
+
+// parsePortRange parses a range of ports, LOW..HIGH, of which 0..0, or none,
+// means any port.
+func parsePortRange(s string) (low, high int, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	parts := strings.Split(s, "..")
+	if len(parts) == 2 {
+		low, err = strconv.Atoi(parts[0])
+		if err == nil {
+			high, err = strconv.Atoi(parts[1])
+		}
+	}
+	switch {
+	case len(parts) != 2 || err != nil:
+		return 0, 0, fmt.Errorf("invalid port range %q: want LOW..HIGH", s)
+	case low == 0 && high == 0:
+		return 0, 0, nil
+	case low <= 0 || high > 65535 || low > high:
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return low, high, nil
+}
+
+// listenInRange listens on a free port from low to high, on every interface,
+// or any port when they are 0. The ports are tried from one picked at random,
+// for kernels started together not to try the same.
+func listenInRange(low, high int) (net.Listener, error) {
+	if low == 0 {
+		return net.Listen("tcp", ":0")
+	}
+	n := high - low + 1
+	start := mathrand.New(mathrand.NewSource(time.Now().UnixNano())).Intn(n)
+	for i := 0; i < n; i++ {
+		port := low + (start+i)%n
+		if ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port)); err == nil {
+			return ln, nil
+		}
+	}
+	return nil, fmt.Errorf("no free port from %d to %d", low, high)
+}
+
+// encryptConnectionInfo encrypts info as the launchers of the gateway do: with
+// a random AES key, in ECB mode with PKCS #7 padding, the key being encrypted
+// with the RSA key publicKey, base64-encoded DER or PEM, with PKCS #1 v1.5. The
+// payload returned is the JSON of both, base64-encoded.
+func encryptConnectionInfo(info []byte, publicKey string) ([]byte, error) {
+	pub, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(info)%aes.BlockSize
+	encrypted := append(append([]byte(nil), info...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	for i := 0; i < len(encrypted); i += aes.BlockSize {
+		block.Encrypt(encrypted[i:], encrypted[i:])
+	}
+	encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"version":   remoteLauncherVersion,
+		"key":       base64.StdEncoding.EncodeToString(encryptedKey),
+		"conn_info": base64.StdEncoding.EncodeToString(encrypted),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(payload)), nil
+}
+
+// parsePublicKey parses an RSA public key, PEM, or DER encoded in base64, as
+// the gateway passes it.
+func parsePublicKey(s string) (*rsa.PublicKey, error) {
+	var der []byte
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		der = block.Bytes
+	} else {
+		var err error
+		if der, err = base64.StdEncoding.DecodeString(strings.TrimSpace(s)); err != nil {
+			return nil, fmt.Errorf("invalid public key: %s", err)
+		}
+	}
+	if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+		if pub, ok := pub.(*rsa.PublicKey); ok {
+			return pub, nil
+		}
+		return nil, errors.New("the public key is not an RSA key")
+	}
+	pub, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %s", err)
+	}
+	return pub, nil
+}
+
+// serveRemoteRequests handles the requests of the gateway on the comm port
+// listener: signals are sent to the process group of the kernel, as Jupyter
+// sends them, and shutdown is called on shutdown requests.
+func serveRemoteRequests(listener net.Listener, logger *log.Logger, shutdown func()) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Println("Could not accept requests of the gateway:", err)
+			return
+		}
+		dec := json.NewDecoder(conn)
+		for {
+			var req remoteRequest
+			if err := dec.Decode(&req); err != nil {
+				if err != io.EOF {
+					logger.Println("Invalid request of the gateway:", err)
+				}
+				break
+			}
+			if req.Signum != nil && *req.Signum != 0 {
+				if err := signalProcessGroup(syscall.Signal(*req.Signum)); err != nil {
+					logger.Println("Could not signal the kernel:", err)
+				}
+			}
+			if req.Shutdown == 1 {
+				conn.Close()
+				shutdown()
+				return
+			}
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParsePortRange tests the ranges of ports the gateway may give
+func TestParsePortRange(t *testing.T) {
+	for s, want := range map[string][2]int{
+		"":             {0, 0},
+		"0..0":         {0, 0},
+		"40000..40100": {40000, 40100},
+	} {
+		low, high, err := parsePortRange(s)
+		noError(t, err)
+		assert.Equal(t, want, [2]int{low, high}, "%q", s)
+	}
+	for _, s := range []string{"40000", "40000-40100", "a..b", "40100..40000", "1..70000"} {
+		_, _, err := parsePortRange(s)
+		assert.Error(t, err, "%q", s)
+	}
+}
+
+// gatewayResponse runs a response address, as the gateway does, and returns
+// it along with the channel the payload it gets is sent to.
+func gatewayResponse(t *testing.T) (string, <-chan []byte) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	noError(t, err)
+	payloads := make(chan []byte, 1)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		b, _ := ioutil.ReadAll(conn)
+		conn.Close()
+		payloads <- b
+	}()
+	return ln.Addr().String(), payloads
+}
+
+// TestRemoteLaunch tests that the connection info the kernel makes up is sent
+// to the response address, with ports of the range given, the kernel process
+// and the comm port
+func TestRemoteLaunch(t *testing.T) {
+	addr, payloads := gatewayResponse(t)
+	launch := remoteLaunch{kernelID: "kernel-1", responseAddress: addr, portRange: "46000..46100"}
+	connInfo, comm, err := launch.connect()
+	noError(t, err)
+	defer comm.Close()
+
+	var sent map[string]interface{}
+	noError(t, json.Unmarshal(<-payloads, &sent))
+	assert.Equal(t, "kernel-1", sent["kernel_id"])
+	assert.Equal(t, float64(os.Getpid()), sent["pid"])
+	assert.Equal(t, "127.0.0.1", sent["ip"])
+	assert.Equal(t, "0.0.0.0", connInfo.IP)
+	assert.Equal(t, connInfo.Key, sent["key"])
+	assert.Equal(t, "hmac-sha256", sent["signature_scheme"])
+	assert.Equal(t, float64(comm.Addr().(*net.TCPAddr).Port), sent["comm_port"])
+	ports := map[float64]bool{}
+	for _, name := range []string{"shell_port", "iopub_port", "stdin_port", "control_port", "hb_port", "comm_port"} {
+		port := sent[name].(float64)
+		assert.True(t, port >= 46000 && port <= 46100, "%s %v", name, port)
+		ports[port] = true
+	}
+	assert.Len(t, ports, 6, "the ports differ")
+}
+
+// TestRemoteLaunch_encrypted tests that the connection info is encrypted as
+// the gateway decrypts it, with the public key given
+func TestRemoteLaunch_encrypted(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	noError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	noError(t, err)
+
+	for _, publicKey := range []string{
+		base64.StdEncoding.EncodeToString(der),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	} {
+		addr, payloads := gatewayResponse(t)
+		launch := remoteLaunch{kernelID: "kernel-2", responseAddress: addr, publicKey: publicKey}
+		connInfo, comm, err := launch.connect()
+		noError(t, err)
+		comm.Close()
+
+		b, err := base64.StdEncoding.DecodeString(string(<-payloads))
+		noError(t, err)
+		var payload struct {
+			Version  int    `json:"version"`
+			Key      string `json:"key"`
+			ConnInfo string `json:"conn_info"`
+		}
+		noError(t, json.Unmarshal(b, &payload))
+		assert.Equal(t, 1, payload.Version)
+
+		encryptedKey, err := base64.StdEncoding.DecodeString(payload.Key)
+		noError(t, err)
+		key, err := rsa.DecryptPKCS1v15(rand.Reader, private, encryptedKey)
+		noError(t, err)
+		block, err := aes.NewCipher(key)
+		noError(t, err)
+		info, err := base64.StdEncoding.DecodeString(payload.ConnInfo)
+		noError(t, err)
+		for i := 0; i < len(info); i += aes.BlockSize {
+			block.Decrypt(info[i:], info[i:])
+		}
+		info = info[:len(info)-int(info[len(info)-1])]
+
+		var sent map[string]interface{}
+		noError(t, json.Unmarshal(info, &sent))
+		assert.Equal(t, "kernel-2", sent["kernel_id"])
+		assert.Equal(t, connInfo.Key, sent["key"])
+	}
+
+	_, err = encryptConnectionInfo([]byte("{}"), "not a key")
+	assert.Error(t, err)
+}
+
+// TestServeRemoteRequests tests that the kernel is shut down on the shutdown
+// requests of the gateway, after the requests checking it is alive
+func TestServeRemoteRequests(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	noError(t, err)
+	defer ln.Close()
+	shutdown := make(chan struct{})
+	go serveRemoteRequests(ln, log.New(ioutil.Discard, "", 0), func() { close(shutdown) })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	noError(t, err)
+	conn.Write([]byte(`{"signum": 0}`))
+	conn.Close()
+	select {
+	case <-shutdown:
+		t.Fatal("shut down on a signal 0")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	conn, err = net.Dial("tcp", ln.Addr().String())
+	noError(t, err)
+	defer conn.Close()
+	conn.Write([]byte(`{"signum": 0}` + "\n" + `{"shutdown": 1}`))
+	select {
+	case <-shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not shut down")
+	}
+}
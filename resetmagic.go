@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterLineMagic("reset", "%reset [-f] [-s] [-h] [<name>...]", "clear the names defined by cells\n-f does not ask for confirmation; -s removes the packages imported too, and -h clears the history.\nGiven names, only those are removed, without asking.", resetMagic)
+}
+
+// resetPrompt asks for confirmation before clearing the session.
+const resetPrompt = "Once deleted, the names defined cannot be recovered. Proceed (y/[n])? "
+
+// resetMagic clears the session of the variables, functions and types defined
+// by cells, after asking for confirmation, unless -f is given. The packages
+// imported are kept, unless -s is given, as is the execution count; -h clears
+// the history as well. Given names, only those are removed, without asking.
+func resetMagic(ctx *MagicContext, args []string, body string) error {
+	var force, imports, hist bool
+	var names []string
+	for _, arg := range args {
+		switch {
+		case arg == "-f":
+			force = true
+		case arg == "-s":
+			imports = true
+		case arg == "-h":
+			hist = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown flag %s", arg)
+		default:
+			names = append(names, arg)
+		}
+	}
+
+	if len(names) > 0 {
+		if imports {
+			return errors.New("-s drops the imports along with all names, not with some")
+		}
+		if err := ctx.Session.Forget(names); err != nil {
+			return err
+		}
+		if hist {
+			ctx.Kernel.history.clear()
+		}
+		return nil
+	}
+
+	if !force {
+		answer, err := ctx.Input(resetPrompt, false)
+		if err == errNoStdin {
+			return errors.New("cannot ask for confirmation; use -f to reset without asking")
+		}
+		if err != nil {
+			return err
+		}
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			ctx.Stream("stdout", "Nothing done.\n")
+			return nil
+		}
+	}
+	if err := ctx.Session.Reset(imports); err != nil {
+		return err
+	}
+	ctx.Kernel.diagnose(diagInfo, "session", "reset the session", "imports dropped", imports)
+	if hist {
+		ctx.Kernel.history.clear()
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestResetMagic tests that %reset asks before clearing the session, that the
+// names it clears are undefined afterwards and may be defined again, that it
+// removes only the names given, if any, and that it keeps the imports and the
+// execution count unless told otherwise
+func TestResetMagic(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	result := func(reply ComposedMsg, published []ComposedMsg) interface{} {
+		assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+		for _, msg := range published {
+			if msg.Header.MsgType == "execute_result" {
+				return msg.Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"]
+			}
+		}
+		return nil
+	}
+	evalue := func(reply ComposedMsg) string {
+		content := reply.Content.(map[string]interface{})
+		assert.Equal(t, "error", content["status"])
+		v, _ := content["evalue"].(string)
+		return v
+	}
+
+	c.execute(":import strings")
+	c.execute("resetA := 1")
+	c.execute("resetB := 1")
+	c.execute("func resetF() int {\n\treturn 2\n}")
+
+	reply, published, prompts := c.executeInput("%reset", "n")
+	if assert.Len(t, prompts, 1) {
+		assert.Contains(t, prompts[0], "Proceed")
+	}
+	assert.Equal(t, "Nothing done.\n", streamText(published, "stdout"))
+	assert.Equal(t, "1\n", result(c.execute("resetV := resetA\nresetV")))
+
+	reply, _ = c.execute("%reset")
+	assert.Contains(t, evalue(reply), "-f")
+
+	reply, _ = c.execute("%reset resetA nosuchname")
+	assert.Contains(t, evalue(reply), "nosuchname is not defined")
+	assert.Equal(t, "1\n", result(c.execute("resetV2 := resetA\nresetV2")))
+
+	// Cells failing to compile stay in the session until it is reset.
+	result(c.execute("%reset resetA resetB"))
+	assert.Equal(t, "\"again\"\n", result(c.execute("resetA := \"again\"\nresetA")))
+	assert.Equal(t, "2\n", result(c.execute("resetX := resetF()\nresetX")))
+	reply, _ = c.execute("resetW := resetB")
+	assert.Contains(t, evalue(reply), "undefined: resetB")
+
+	count := reply.Content.(map[string]interface{})["execution_count"].(float64)
+	reply, published, _ = c.executeInput("%reset -h", "y")
+	result(reply, published)
+	assert.Equal(t, count+1, reply.Content.(map[string]interface{})["execution_count"])
+	assert.Empty(t, testKernel.history.current())
+
+	for _, name := range []string{"resetA", "resetF", "resetV"} {
+		reply, _ = c.execute("resetY := " + name)
+		assert.Contains(t, evalue(reply), "undefined: "+name)
+		result(c.execute("%reset -f"))
+	}
+	c.execute("func resetF() int {\n\treturn 3\n}")
+	assert.Equal(t, "3\n", result(c.execute("resetZ := resetF()\nresetZ")))
+	assert.Equal(t, "\"A\"\n", result(c.execute("resetU := strings.ToUpper(\"a\")\nresetU")))
+
+	result(c.execute("%reset -f -s"))
+	reply, _ = c.execute("resetU := strings.ToUpper(\"a\")")
+	assert.Contains(t, evalue(reply), "undefined: strings")
+	result(c.execute("%reset -f"))
+	assert.Equal(t, "4\n", result(c.execute("resetN := 4\nresetN")))
+}
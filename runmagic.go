@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	RegisterLineMagic("run", "%run [-d <dir>] <file> | -x [-d <dir>] <package> [<arg>...]", "bring the declarations of a Go file into the session, or go run a package\n-x runs the package with go run instead, with the arguments following it.\n-d sets the directory the file or package is relative to, and the one the package runs in.", runFileMagic)
+}
+
+// runFileMagic brings the declarations of the Go file given into the session, for
+// later cells to use, as if a cell declared them. With -x, the package given
+// is run with go run instead, with the arguments following it, its output
+// shown as it comes, and a nonzero exit status failing the cell. -d sets the
+// directory the file or package is relative to, and the one the package runs
+// in, which is otherwise the working directory of the kernel.
+func runFileMagic(ctx *MagicContext, args []string, body string) error {
+	var subprocess bool
+	var dir string
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-x":
+			subprocess = true
+		case "-d":
+			if len(args) < 2 {
+				return errors.New("-d needs a directory")
+			}
+			dir = expandPath(args[1])
+			args = args[1:]
+		default:
+			return fmt.Errorf("unknown flag %s", args[0])
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return errors.New("a file or package is needed")
+	}
+	target := expandPath(args[0])
+
+	if subprocess {
+		cmd := exec.Command("go", append([]string{"run", target}, args[1:]...)...)
+		cmd.Dir = dir
+		exited, failed := runProcess(ctx, cmd, "%run")
+		if failed != nil {
+			return failed
+		}
+		return exited
+	}
+
+	if len(args) > 1 {
+		return errors.New("arguments are only passed to packages run with -x")
+	}
+	if dir != "" && !filepath.IsAbs(target) {
+		target = filepath.Join(dir, target)
+	}
+	return ctx.Session.Include(target)
+}
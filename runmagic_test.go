@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRunMagic tests that %run brings the declarations of a file into the
+// session, its errors given with their positions in the file, and that with -x
+// it runs a package with the arguments given, its exit status that of the cell
+func TestRunMagic(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+	defer c.execute("%reset -f")
+
+	dir, err := ioutil.TempDir("", "gophernotes_run")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		noError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		noError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	write("helpers.go", "package helpers\n\nimport \"strings\"\n\nfunc runShout(s string) string {\n\treturn strings.ToUpper(s) + \"!\"\n}\n\nfunc main() {}\n")
+	write("broken.go", "package main\n\nfunc runBroken() int {\n\treturn \"one\"\n}\n")
+	write("tool/main.go", "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nfunc main() {\n\tfmt.Println(os.Args[1:])\n\tif len(os.Args) > 2 {\n\t\tos.Exit(3)\n\t}\n}\n")
+	write("tool/go.mod", "module tool\n")
+
+	reply, _ := c.execute("%run " + filepath.Join(dir, "helpers.go"))
+	content := reply.Content.(map[string]interface{})
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	_, published := c.execute("runS := runShout(\"hi\")\nrunS")
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "\"HI!\"\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+
+	reply, _ = c.execute("%run -d " + dir + " broken.go")
+	content = reply.Content.(map[string]interface{})
+	assert.Equal(t, "error", content["status"])
+	assert.Contains(t, content["evalue"], filepath.Join(dir, "broken.go")+":4:")
+
+	reply, published = c.execute("%run -x -d " + filepath.Join(dir, "tool") + " . a")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Equal(t, "[a]\n", streamText(published, "stdout"))
+	reply, published = c.execute("%run -x -d " + filepath.Join(dir, "tool") + " . a b")
+	assert.Equal(t, "error", reply.Content.(map[string]interface{})["status"])
+	assert.Equal(t, "[a b]\n", streamText(published, "stdout"))
+
+	reply, _ = c.execute("%run helpers.go extra")
+	assert.Equal(t, "error", reply.Content.(map[string]interface{})["status"])
+}
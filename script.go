@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	RegisterCellMagic("bash", "%%bash [--no-raise-error] [<arg>...]", "run the cell with bash\n--no-raise-error reports a nonzero exit status on stderr instead of failing the cell.", scriptMagic("bash"))
+	RegisterCellMagic("sh", "%%sh [--no-raise-error] [<arg>...]", "run the cell with sh\n--no-raise-error reports a nonzero exit status on stderr instead of failing the cell.", scriptMagic("sh"))
+	RegisterCellMagic("script", "%%script <interpreter> [--no-raise-error] [<arg>...]", "run the cell with the interpreter given\nThe arguments are passed to the interpreter, which reads the cell from stdin.\n--no-raise-error reports a nonzero exit status on stderr instead of failing the cell.", scriptMagic(""))
+}
+
+// scriptMagic returns the cell magic running the body of cells with
+// interpreter, or with the interpreter given as its first argument if
+// interpreter is empty, as with "%%script python3". The other arguments are
+// passed to the interpreter, which reads the body from stdin, in the working
+// directory and with the environment of the kernel. A nonzero exit status
+// fails the cell, unless "--no-raise-error" is given, in which case it is
+// reported on stderr.
+func scriptMagic(interpreter string) Magic {
+	return func(ctx *MagicContext, args []string, body string) error {
+		raise := true
+		var interpreterArgs []string
+		for _, arg := range args {
+			if arg == "--no-raise-error" {
+				raise = false
+				continue
+			}
+			interpreterArgs = append(interpreterArgs, arg)
+		}
+		name := interpreter
+		if name == "" {
+			if len(interpreterArgs) == 0 {
+				return errors.New("no interpreter given")
+			}
+			name, interpreterArgs = interpreterArgs[0], interpreterArgs[1:]
+		}
+
+		cmd := exec.Command(name, interpreterArgs...)
+		cmd.Stdin = strings.NewReader(body)
+		exited, failed := runProcess(ctx, cmd, "%%"+name)
+		if failed != nil {
+			return failed
+		}
+		if exited != nil {
+			if raise {
+				return exited
+			}
+			ctx.Stream("stderr", exited.Error()+"\n")
+		}
+		return nil
+	}
+}
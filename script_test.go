@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// streamText returns the text published on the stream name among msgs.
+func streamText(msgs []ComposedMsg, name string) string {
+	var text string
+	for _, msg := range msgs {
+		content, _ := msg.Content.(map[string]interface{})
+		if msg.Header.MsgType == "stream" && content["name"] == name {
+			text += content["text"].(string)
+		}
+	}
+	return text
+}
+
+// TestScriptMagics tests that cells run by interpreters get their body on
+// stdin, fail with the interpreter unless told not to, and leave the
+// environment of the kernel alone
+func TestScriptMagics(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	if _, err := exec.LookPath("bash"); err == nil {
+		reply, published := c.execute("%%bash\nfor i in 1 2; do echo \"$i\"; done\n")
+		assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+		assert.Equal(t, "1\n2\n", streamText(published, "stdout"))
+	}
+
+	reply, _ := c.execute("%%sh\nexport GOPHERNOTES_TEST_LEAK=1\nexit 2")
+	content := reply.Content.(map[string]interface{})
+	assert.Equal(t, "error", content["status"])
+	assert.Equal(t, "%%sh: exit status 2", content["evalue"])
+	assert.Empty(t, os.Getenv("GOPHERNOTES_TEST_LEAK"))
+
+	reply, published := c.execute("%%sh --no-raise-error\nexit 2")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Equal(t, "exit status 2\n", streamText(published, "stderr"))
+
+	reply, published = c.execute("%%script sh -s two\necho \"via $1\"")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Equal(t, "via two\n", streamText(published, "stdout"))
+
+	reply, _ = c.execute("%%script\necho nothing")
+	assert.Equal(t, "%%script: no interpreter given", reply.Content.(map[string]interface{})["evalue"])
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/gopherds/gophernotes/gophernotes/widgets"
+)
+
+// openedComm is a comm the frontend opened with a target registered by cell
+// code, as listed for cell code to open again on every run.
+type openedComm struct {
+	CommID string                 `json:"comm_id"`
+	Target string                 `json:"target_name"`
+	Data   map[string]interface{} `json:"data"`
+}
+
+func (k *Kernel) forgetOpenedComm(id string) {
+	k.openedComms.Lock()
+	defer k.openedComms.Unlock()
+	for i, o := range k.openedComms.list {
+		if o.CommID == id {
+			k.openedComms.list = append(k.openedComms.list[:i], k.openedComms.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// writeCommState writes the comms the frontend opened with targets of cell
+// code, and the state of widgets, to the files cell code reads them from.
+func (k *Kernel) writeCommState() {
+	k.openedComms.Lock()
+	b, err := json.Marshal(k.openedComms.list)
+	k.openedComms.Unlock()
+	if err == nil {
+		err = ioutil.WriteFile(k.files.openComms, b, 0644)
+	}
+	if err != nil {
+		k.diagnose(diagWarning, "comm", "could not write the comms open: cell code does not see those the frontend opened", "error", err)
+	}
+	k.writeWidgetStates()
+}
+
+// relayComm keeps track of the comm messages cell code sends, which the relay
+// publishes as they are: comms cell code opens are registered, for the messages
+// of the frontend to reach it, as are the targets it registers, which are not
+// published.
+func (r *displayRelay) relayComm(dm DisplayMsg) {
+	k := r.kernel
+	var content struct {
+		CommID string                 `json:"comm_id"`
+		Target string                 `json:"target_name"`
+		Data   map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(dm.Content, &content); err != nil {
+		k.logger.Println("Invalid comm message:", err)
+		return
+	}
+
+	switch dm.MsgType {
+	case "comm_target":
+		k.RegisterCommTarget(content.Target, openSessionComm)
+		return
+	case "comm_open":
+		c := &Comm{ID: content.CommID, Target: content.Target, receipt: r.receipt, handler: sessionComm{}, kernel: k}
+		k.comms.Lock()
+		k.comms.m[c.ID] = c
+		k.comms.Unlock()
+	case "comm_close":
+		k.comms.Lock()
+		delete(k.comms.m, content.CommID)
+		k.comms.Unlock()
+		k.forgetOpenedComm(content.CommID)
+	}
+	k.recordWidgetState(dm.MsgType, content.CommID, content.Target, content.Data)
+}
+
+// openSessionComm is the target of the comms the frontend opens with targets
+// registered by cell code, which runs the session again to hand them to the
+// target of cell code.
+func openSessionComm(c *Comm, data map[string]interface{}, buffers [][]byte) CommHandler {
+	k := c.kernel
+	k.deliverCommEvent(c.receipt, "comm_open", c, data, buffers)
+	k.openedComms.Lock()
+	k.openedComms.list = append(k.openedComms.list, openedComm{c.ID, c.Target, data})
+	k.openedComms.Unlock()
+	return sessionComm{}
+}
+
+// sessionComm is the handler of the comms of cell code, whose messages are
+// delivered to it by running the session again. The state widgets request is
+// sent from the recorded one instead.
+type sessionComm struct{}
+
+func (sessionComm) Receive(c *Comm, data map[string]interface{}, buffers [][]byte) {
+	if c.Target == widgets.Target && receiveWidgetMsg(c, data) {
+		return
+	}
+	c.kernel.deliverCommEvent(c.receipt, "comm_msg", c, data, buffers)
+}
+
+func (sessionComm) Closed(c *Comm, data map[string]interface{}, buffers [][]byte) {
+	// The comm is handed to its target once more, to be closed.
+	c.kernel.deliverCommEvent(c.receipt, "comm_close", c, data, buffers)
+	c.kernel.forgetWidgetState(c.ID)
+	c.kernel.forgetOpenedComm(c.ID)
+}
+
+// deliverCommEvent runs the session again to deliver a message of msgType from
+// the frontend, with data and buffers, to the comm c of cell code. What cell
+// code publishes on the way, and errors, are published in reply to the
+// message.
+func (k *Kernel) deliverCommEvent(receipt MsgReceipt, msgType string, c *Comm, data map[string]interface{}, buffers [][]byte) {
+	b, err := json.Marshal(map[string]interface{}{
+		"msg_type":    msgType,
+		"comm_id":     c.ID,
+		"target_name": c.Target,
+		"data":        data,
+		"buffers":     buffers,
+	})
+	if err == nil {
+		err = ioutil.WriteFile(k.files.commEvent, b, 0644)
+	}
+	if err != nil {
+		k.diagnoseIn(&receipt, diagError, "comm", "could not write the comm message: cell code does not receive it", "error", err)
+		return
+	}
+	defer os.Remove(k.files.commEvent)
+	k.writeCommState()
+
+	relay, err := k.startDisplayRelay(receipt, true, nil)
+	if err != nil {
+		k.diagnoseIn(&receipt, diagError, "display", "could not start the display relay: the displays of the comm message are not shown", "error", err)
+	}
+	_, stderr, err := k.session.Replay()
+	if relay != nil {
+		relay.Stop()
+	}
+	if err != nil {
+		k.diagnoseIn(&receipt, diagError, "comm", "could not deliver the comm message to cell code", "comm", c.ID, "error", err)
+	}
+	if text := stderr.String(); text != "" {
+		msg := NewMsg("stream", receipt.Msg)
+		msg.Content = map[string]interface{}{"name": "stderr", "data": text, "text": text}
+		receipt.SendResponse(receipt.Sockets.IOPubSocket, msg)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// shellOutput is text a shell command wrote to the stream name.
+type shellOutput struct {
+	name string
+	text string
+}
+
+// runShell runs command, from a line of a cell starting with "!", through the
+// system shell in the working directory of the kernel, publishing what it
+// writes to stdout and stderr as it comes. A nonzero exit status is reported
+// on stderr without failing the cell. Interrupting the kernel while the
+// command runs kills it, along with the processes it started, and stops the
+// cell.
+func runShell(ctx *MagicContext, command string) *KernelError {
+	exited, failed := runProcess(ctx, shellCommand(command), "!"+command)
+	if failed != nil {
+		return failed
+	}
+	if exited != nil {
+		ctx.Stream("stderr", exited.Error()+"\n")
+	}
+	return nil
+}
+
+// runProcess runs cmd, in a process group of its own, publishing what it
+// writes to stdout and stderr as it comes, and returns the error it exited
+// with, if any. Interrupting the kernel while cmd runs kills its process
+// group, and the error content returned then, as when cmd cannot start, is
+// that of the line or magic label running it.
+func runProcess(ctx *MagicContext, cmd *exec.Cmd, label string) (exited error, failed *KernelError) {
+	return streamProcess(ctx, cmd, label, ctx.Stream)
+}
+
+// pipeDrainTimeout is how long the output of a command is still read once it
+// has exited, for processes it started in the background, and still writing
+// to its pipes, not to hold up the cell.
+const pipeDrainTimeout = time.Second
+
+// streamProcess runs cmd as runProcess does, passing what it writes to stream,
+// with the name of the stream, as it comes, within the budget of the output
+// streams: a command writing faster than the blocking rate waits on its pipes.
+// It returns once the pipes are drained, what they held published, so that
+// the output of the command comes before whatever the cell goes on with, and
+// before the reply to the cell; once cmd has exited, the pipes are read for
+// pipeDrainTimeout at most.
+func streamProcess(ctx *MagicContext, cmd *exec.Cmd, label string, stream func(name, text string)) (exited error, failed *KernelError) {
+	setProcessGroup(cmd)
+	stdout, stdoutW, err := os.Pipe()
+	if err != nil {
+		return nil, newKernelError("Error", label+": "+err.Error())
+	}
+	defer stdout.Close()
+	stderr, stderrW, err := os.Pipe()
+	if err != nil {
+		stdoutW.Close()
+		return nil, newKernelError("Error", label+": "+err.Error())
+	}
+	defer stderr.Close()
+	cmd.Stdout, cmd.Stderr = stdoutW, stderrW
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	err = cmd.Start()
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		return nil, newKernelError("Error", label+": "+err.Error())
+	}
+
+	output := make(chan shellOutput)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go readShellOutput("stdout", stdout, output, &wg)
+	go readShellOutput("stderr", stderr, output, &wg)
+	go func() {
+		wg.Wait()
+		close(output)
+	}()
+	waited := make(chan error, 1)
+	go func() { waited <- cmd.Wait() }()
+
+	// Once the command has exited, the pipes are closed after the timeout,
+	// which ends the reads still going on.
+	limiter := newStreamLimiter(stream, ctx.Kernel.options())
+	limiter.notice = func(text string) { ctx.Kernel.diagnoseIn(&ctx.Receipt, diagWarning, "output", text) }
+	interrupted := false
+	var drained <-chan time.Time
+	for output != nil {
+		select {
+		case out, ok := <-output:
+			if !ok {
+				output = nil
+				continue
+			}
+			limiter.Stream(out.name, out.text)
+		case <-interrupts:
+			interrupted = true
+			killProcessGroup(cmd)
+		case exited = <-waited:
+			waited = nil
+			drained = time.After(pipeDrainTimeout)
+		case <-drained:
+			drained = nil
+			stdout.Close()
+			stderr.Close()
+		}
+	}
+
+	limiter.Close()
+	if waited != nil {
+		exited = <-waited
+	}
+	if interrupted {
+		return nil, newKernelError("Interrupted", label+": interrupted")
+	}
+	return exited, nil
+}
+
+// readShellOutput sends what is read from r to output, as text of the stream
+// name, until r is exhausted. Characters split across reads are sent whole.
+func readShellOutput(name string, r io.Reader, output chan<- shellOutput, wg *sync.WaitGroup) {
+	defer wg.Done()
+	buf := make([]byte, 4096)
+	var pending []byte
+	for {
+		n, err := r.Read(buf)
+		text := append(pending, buf[:n]...)
+		pending = nil
+		if err == nil {
+			text, pending = wholeRunes(text)
+			pending = append([]byte(nil), pending...)
+		}
+		if len(text) > 0 {
+			output <- shellOutput{name, string(text)}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
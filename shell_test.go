@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMagicLines_shell tests that only lines starting statements at the top
+// level of the cell run shell commands
+func TestMagicLines_shell(t *testing.T) {
+	lines := []string{
+		"!ls",
+		"ok := a &&",
+		"  !b",
+		"if !ok {",
+		"!x",
+		"}",
+		"fmt.Println(!ok)",
+		" !echo hi",
+	}
+	assert.Equal(t, []bool{true, false, false, false, false, false, false, true}, magicLines(lines))
+}
+
+// TestShell tests that shell commands run in order with the Go code of the
+// cell, their output published as it comes, and that failing commands are
+// reported without stopping the cell
+func TestShell(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	reply, published := c.execute("!echo out\n!echo err >&2\nshellX := 2\nshellX\n!exit 3\n!echo after")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	if !assert.Equal(t, []string{"stream", "stream", "execute_result", "stream", "stream"}, msgTypes(published)) {
+		return
+	}
+	streams := [][2]interface{}{}
+	for _, i := range []int{0, 1, 3, 4} {
+		content := published[i].Content.(map[string]interface{})
+		streams = append(streams, [2]interface{}{content["name"], content["text"]})
+	}
+	assert.Equal(t, [][2]interface{}{
+		{"stdout", "out\n"},
+		{"stderr", "err\n"},
+		{"stderr", "exit status 3\n"},
+		{"stdout", "after\n"},
+	}, streams)
+	assert.Equal(t, "2\n", published[2].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+}
+
+// TestShell_interrupt tests that interrupting the kernel kills the shell
+// command running, and the processes it started, and stops the cell
+func TestShell_interrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupts are signals on unix")
+	}
+	c := newTestClient(t)
+	defer c.Close()
+
+	start := time.Now()
+	req := c.send("execute_request", map[string]interface{}{
+		"code":             "!echo started; sleep 30\n!echo never",
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      false,
+	})
+	var published []ComposedMsg
+	for {
+		msg := c.recv(c.iopub)
+		if msg.ParentHeader.MsgID != req.Header.MsgID {
+			continue
+		}
+		if msg.Header.MsgType == "status" {
+			if msg.Content.(map[string]interface{})["execution_state"] == "idle" {
+				break
+			}
+			continue
+		}
+		if msg.Header.MsgType == "stream" && msg.Content.(map[string]interface{})["text"] == "started\n" {
+			p, err := os.FindProcess(os.Getpid())
+			noError(t, err)
+			noError(t, p.Signal(os.Interrupt))
+		}
+		published = append(published, msg)
+	}
+	reply := c.recv(c.shell)
+	for reply.ParentHeader.MsgID != req.Header.MsgID {
+		reply = c.recv(c.shell)
+	}
+	assert.True(t, time.Since(start) < 20*time.Second)
+	assert.Equal(t, []string{"stream", "error"}, msgTypes(published))
+	assert.Equal(t, "Interrupted", reply.Content.(map[string]interface{})["ename"])
+}
+
+// TestShell_deterministic tests that the output of commands writing a lot is
+// all published before the cell is done, the same on every run, as frontends
+// running notebooks expect
+func TestShell_deterministic(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands run through sh")
+	}
+	c := newTestClient(t)
+	defer c.Close()
+
+	code := "!i=0; while [ $i -lt 500 ]; do echo line $i; echo err $i >&2; i=$((i+1)); done\n!printf last"
+	var first map[string]string
+	for run := 0; run < 100; run++ {
+		reply, published := c.execute(code)
+		assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+		streams := map[string]string{}
+		for _, msg := range published {
+			content := msg.Content.(map[string]interface{})
+			streams[content["name"].(string)] += content["text"].(string)
+		}
+		if first == nil {
+			first = streams
+			assert.True(t, strings.HasSuffix(streams["stdout"], "line 499\nlast"), "ends with %q", streams["stdout"])
+			assert.True(t, strings.HasSuffix(streams["stderr"], "err 499\n"))
+		} else if !assert.Equal(t, first, streams, "run %d", run) {
+			return
+		}
+	}
+}
+
+// TestShell_background tests that processes started in the background, keeping
+// the output pipes of a command open, do not hold up the cell
+func TestShell_background(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands run through sh")
+	}
+	c := newTestClient(t)
+	defer c.Close()
+
+	start := time.Now()
+	reply, published := c.execute("!(sleep 5; echo late) &\n!echo done")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.True(t, time.Since(start) < 4*time.Second, "took %v", time.Since(start))
+	if assert.Equal(t, []string{"stream"}, msgTypes(published)) {
+		assert.Equal(t, "done\n", published[0].Content.(map[string]interface{})["text"])
+	}
+}
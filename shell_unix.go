@@ -0,0 +1,48 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand returns the command running command through sh.
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("sh", "-c", command)
+}
+
+// setProcessGroup makes cmd run in a process group of its own, for
+// killProcessGroup to kill it along with the processes it starts.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the process group of cmd.
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// processGroup returns the process group of the kernel.
+func processGroup() int {
+	return syscall.Getpgrp()
+}
+
+// signalProcessGroup sends sig to the process group of the kernel, as
+// Jupyter does to interrupt it.
+func signalProcessGroup(sig syscall.Signal) error {
+	return syscall.Kill(-syscall.Getpgrp(), sig)
+}
+
+// interruptProcess sends SIGINT to the process pid, that of a cell, for it to
+// stop.
+func interruptProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGINT)
+}
+
+// signalStackDump asks the process pid, that of a cell, for the stacks of its
+// goroutines, with the signal the gophernotes package dumps them on.
+func signalStackDump(pid int) error {
+	return syscall.Kill(pid, syscall.SIGUSR1)
+}
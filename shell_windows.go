@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// shellCommand returns the command running command through cmd, which parses
+// its command line itself.
+func shellCommand(command string) *exec.Cmd {
+	cmd := exec.Command("cmd")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: "cmd /C " + command}
+	return cmd
+}
+
+// setProcessGroup makes cmd run in a process group of its own.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// killProcessGroup kills cmd along with the processes it started.
+func killProcessGroup(cmd *exec.Cmd) {
+	exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
+
+// processGroup returns the process group of the kernel, which is the kernel
+// alone on Windows.
+func processGroup() int {
+	return os.Getpid()
+}
+
+// signalProcessGroup sends sig to the kernel. Windows only delivers os.Kill,
+// and fails to send the other signals.
+func signalProcessGroup(sig syscall.Signal) error {
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
+
+// interruptProcess fails to interrupt the process pid, for Windows has no
+// signal to.
+func interruptProcess(pid int) error {
+	return errors.New("processes cannot be interrupted on Windows")
+}
+
+// signalStackDump fails to ask the process pid for the stacks of its
+// goroutines, for Windows has no signal to.
+func signalStackDump(pid int) error {
+	return errors.New("there is no signal asking for them on Windows")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envFileEnv is the environment variable naming the dotenv file the kernel
+// loads at startup, for kernelspecs to set it.
+const envFileEnv = "GOPHERNOTES_ENV_FILE"
+
+// startupEnv is where the kernel reads the environment it sets at startup,
+// before its session starts: the env of its kernelspec, which not every
+// launcher applies, and a dotenv file.
+type startupEnv struct {
+	// kernelspecDir is the directory of the kernel.json of the kernel, file
+	// the dotenv file, and override whether the variables of the file
+	// override those already set.
+	kernelspecDir string
+	file          string
+	override      bool
+}
+
+// registerFlags adds the flags setting e to flags.
+func (e *startupEnv) registerFlags(flags *flag.FlagSet) {
+	flags.StringVar(&e.kernelspecDir, "kernelspec-dir", "", "Set the env of the kernel.json in `DIR` unless the launcher did, as gophernotes install has the kernel do")
+	flags.StringVar(&e.file, "env-file", "", "Set the variables of the dotenv file at `PATH`, or at $"+envFileEnv+", before the session starts")
+	flags.BoolVar(&e.override, "env-file-override", false, "Have the variables of the env file override those of the environment")
+}
+
+// load sets the environment of the kernel as e says, the kernelspec first, for
+// its env to set GOPHERNOTES_ENV_FILE, logging the names of the variables set
+// with logf, but not their values.
+func (e startupEnv) load(logf func(format string, args ...interface{})) error {
+	if e.kernelspecDir != "" {
+		names, err := applyKernelspecEnv(filepath.Join(e.kernelspecDir, "kernel.json"))
+		if err != nil {
+			return err
+		}
+		if len(names) > 0 {
+			logf("Set %s from the kernelspec", strings.Join(names, ", "))
+		}
+	}
+
+	file := e.file
+	if file == "" {
+		file = os.Getenv(envFileEnv)
+	}
+	if file == "" {
+		return nil
+	}
+	set, kept, err := applyEnvFile(file, e.override)
+	if err != nil {
+		return err
+	}
+	if len(set) > 0 {
+		logf("Set %s from %s", strings.Join(set, ", "), file)
+	}
+	if len(kept) > 0 {
+		logf("Kept %s as set in the environment, rather than from %s", strings.Join(kept, ", "), file)
+	}
+	return nil
+}
+
+// kernelspecVarRe matches the references to variables Jupyter replaces in the
+// env of kernelspecs: $NAME and ${NAME}.
+var kernelspecVarRe = regexp.MustCompile(`\$(?:([A-Za-z_][A-Za-z0-9_]*)|\{([A-Za-z_][A-Za-z0-9_]*)\})`)
+
+// expandKernelspecValue replaces the variables value refers to by their
+// values, leaving those unset as they are, as Jupyter does.
+func expandKernelspecValue(value string) string {
+	return kernelspecVarRe.ReplaceAllStringFunc(value, func(ref string) string {
+		m := kernelspecVarRe.FindStringSubmatch(ref)
+		if v, ok := os.LookupEnv(m[1] + m[2]); ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// applyKernelspecEnv sets the env of the kernelspec at path, unless the
+// launcher did, and returns the names of the variables set, in order. The
+// launcher did when the variables are all set, to the values of the kernelspec
+// for those whose values refer to no variable, which the launcher expanded.
+func applyKernelspecEnv(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec kernelSpec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	names := make([]string, 0, len(spec.Env))
+	applied := true
+	for name, value := range spec.Env {
+		names = append(names, name)
+		v, ok := os.LookupEnv(name)
+		if !ok || (!kernelspecVarRe.MatchString(value) && v != value) {
+			applied = false
+		}
+	}
+	if applied {
+		return nil, nil
+	}
+	sort.Strings(names)
+	values := map[string]string{}
+	for _, name := range names {
+		values[name] = expandKernelspecValue(spec.Env[name])
+	}
+	for _, name := range names {
+		if err := os.Setenv(name, values[name]); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// applyEnvFile sets the variables of the dotenv file at path, and returns the
+// names of those set and of those kept as they were, being set already, unless
+// override.
+func applyEnvFile(path string, override bool) (set, kept []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	vars, err := parseDotenv(f, os.LookupEnv, override)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s:%s", path, err)
+	}
+	preset := map[string]bool{}
+	for _, v := range vars {
+		if _, ok := os.LookupEnv(v[0]); ok && !override {
+			preset[v[0]] = true
+		}
+	}
+	listed := map[string]bool{}
+	for _, v := range vars {
+		name := v[0]
+		if !preset[name] {
+			if err := os.Setenv(name, v[1]); err != nil {
+				return nil, nil, err
+			}
+		}
+		if listed[name] {
+			continue
+		}
+		listed[name] = true
+		if preset[name] {
+			kept = append(kept, name)
+		} else {
+			set = append(set, name)
+		}
+	}
+	return set, kept, nil
+}
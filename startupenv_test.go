@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStartupEnv tests that the kernel sets the env of its kernelspec unless
+// the launcher did, then the variables of the env file it names, keeping those
+// already set unless overridden, and logs their names alone
+func TestStartupEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophernotes_startupenv")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+	envFile := filepath.Join(dir, ".env")
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "kernel.json"), []byte(`{
+  "argv": ["gophernotes", "{connection_file}"],
+  "display_name": "Go",
+  "language": "go",
+  "env": {"GOPHERNOTES_TEST_SPEC": "spec", "`+envFileEnv+`": "`+filepath.ToSlash(envFile)+`"}
+}`), 0644))
+	noError(t, ioutil.WriteFile(envFile, []byte("GOPHERNOTES_TEST_A=${GOPHERNOTES_TEST_SPEC}-a\nGOPHERNOTES_TEST_SECRET=file\n"), 0644))
+	for _, name := range []string{"GOPHERNOTES_TEST_SPEC", envFileEnv, "GOPHERNOTES_TEST_A", "GOPHERNOTES_TEST_SECRET"} {
+		defer os.Unsetenv(name)
+	}
+	os.Setenv("GOPHERNOTES_TEST_SECRET", "process")
+
+	var logged []string
+	logf := func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	noError(t, startupEnv{kernelspecDir: dir}.load(logf))
+	assert.Equal(t, "spec", os.Getenv("GOPHERNOTES_TEST_SPEC"))
+	assert.Equal(t, "spec-a", os.Getenv("GOPHERNOTES_TEST_A"))
+	assert.Equal(t, "process", os.Getenv("GOPHERNOTES_TEST_SECRET"))
+	assert.Equal(t, []string{
+		"Set " + envFileEnv + ", GOPHERNOTES_TEST_SPEC from the kernelspec",
+		"Set GOPHERNOTES_TEST_A from " + filepath.ToSlash(envFile),
+		"Kept GOPHERNOTES_TEST_SECRET as set in the environment, rather than from " + filepath.ToSlash(envFile),
+	}, logged)
+
+	// The kernelspec is applied already, and the file overrides.
+	logged = nil
+	noError(t, startupEnv{kernelspecDir: dir, override: true}.load(logf))
+	assert.Equal(t, "file", os.Getenv("GOPHERNOTES_TEST_SECRET"))
+	assert.Equal(t, []string{
+		"Set GOPHERNOTES_TEST_A, GOPHERNOTES_TEST_SECRET from " + filepath.ToSlash(envFile),
+	}, logged)
+
+	assert.Error(t, startupEnv{file: filepath.Join(dir, "missing")}.load(logf))
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+func init() {
+	registerIntConfig("stream_msg_rate", "the stream messages per second beyond which output is held back and published in batches", func(c *kernelConfig) *int { return &c.streamMsgRate })
+	registerIntConfig("stream_data_rate", "the bytes of stream output per second beyond which output is held back and published in batches", func(c *kernelConfig) *int { return &c.streamDataRate })
+	registerIntConfig("stream_block_rate", "the bytes of stream output per second beyond which the commands writing it wait, 0 for never", func(c *kernelConfig) *int { return &c.streamBlockRate })
+}
+
+// streamFlushInterval is how often output held back by a stream limiter is
+// published.
+const streamFlushInterval = 100 * time.Millisecond
+
+// heldOutput is the text of the stream name held back by a stream limiter.
+type heldOutput struct {
+	name string
+	text bytes.Buffer
+}
+
+// queuedOutput is the text of the stream name a stream limiter is to publish,
+// or a notice if notice is set.
+type queuedOutput struct {
+	name, text string
+	notice     bool
+}
+
+// A streamLimiter publishes output streams through publish, keeping them
+// within what iopub, the notebook server and the browser can take. Beyond the
+// messages or bytes per second of the budget, output is held back and
+// published once every streamFlushInterval, and beyond the blocking rate
+// Stream makes its caller wait for the second to end, which holds back a
+// command writing to a pipe the output is read from. A notice is published
+// the first time each threshold is crossed. Held back output is published
+// again as it comes once a second passes within the budget. Notices go to
+// notice, if set, rather than to stderr.
+//
+// A limiter may be fed by several goroutines, and is closed once the output
+// ends. What is to be published is queued under the lock of the limiter, and
+// published in order by whichever goroutine finds none publishing, once the
+// lock is released.
+type streamLimiter struct {
+	publish                      func(name, text string)
+	notice                       func(text string)
+	msgRate, dataRate, blockRate int
+
+	mu          sync.Mutex
+	start       time.Time
+	msgs, bytes int
+	batching    bool
+	held        []*heldOutput
+	timer       *time.Timer
+	closed      bool
+
+	// queue is what is to be published, publishing is set while a
+	// goroutine publishes it, and drained is signalled once it is done.
+	queue      []queuedOutput
+	publishing bool
+	drained    sync.Cond
+
+	// batchNoticed and blockNoticed are set once the notices of the
+	// thresholds are published.
+	batchNoticed, blockNoticed bool
+}
+
+// newStreamLimiter returns a limiter publishing streams with publish, within
+// the budgets of config, as set by the -stream-* flags and %config.
+func newStreamLimiter(publish func(name, text string), config kernelConfig) *streamLimiter {
+	l := &streamLimiter{
+		publish:   publish,
+		msgRate:   config.streamMsgRate,
+		dataRate:  config.streamDataRate,
+		blockRate: config.streamBlockRate,
+		start:     time.Now(),
+	}
+	l.drained.L = &l.mu
+	return l
+}
+
+// Stream publishes text of the stream name, or holds it back if output comes
+// too fast, waiting for the second to end if it comes faster than the blocking
+// rate.
+func (l *streamLimiter) Stream(name, text string) {
+	l.pass(name, text)
+	l.wait()
+}
+
+// pass publishes text of the stream name, or holds it back if output comes
+// too fast, without waiting.
+func (l *streamLimiter) pass(name, text string) {
+	l.admit(name, text)
+	l.drain()
+}
+
+// admit counts a message of text of the stream name, and queues it to be
+// published right away, or holds it back.
+func (l *streamLimiter) admit(name, text string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.start) >= time.Second {
+		if l.batching && l.msgs <= l.msgRate && l.bytes <= l.dataRate {
+			l.flush()
+			l.batching = false
+		}
+		l.start, l.msgs, l.bytes = now, 0, 0
+	}
+	l.msgs++
+	l.bytes += len(text)
+	if !l.batching && (l.msgs > l.msgRate || l.bytes > l.dataRate) {
+		l.batching = true
+		if !l.batchNoticed {
+			l.batchNoticed = true
+			l.notify(fmt.Sprintf("output is coming faster than %d messages or %d bytes per second; it is published every %v until it slows down", l.msgRate, l.dataRate, streamFlushInterval))
+		}
+		l.timer = time.AfterFunc(streamFlushInterval, l.tick)
+	}
+	if l.batching {
+		l.keep(name, text)
+	} else {
+		l.queue = append(l.queue, queuedOutput{name: name, text: text})
+	}
+}
+
+// hold keeps text of the stream name for the next flush.
+func (l *streamLimiter) hold(name, text string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.keep(name, text)
+}
+
+// keep is hold, the lock held.
+func (l *streamLimiter) keep(name, text string) {
+	if n := len(l.held); n > 0 && l.held[n-1].name == name {
+		l.held[n-1].text.WriteString(text)
+		return
+	}
+	h := &heldOutput{name: name}
+	h.text.WriteString(text)
+	l.held = append(l.held, h)
+}
+
+// wait waits for the second to end if output comes faster than the blocking
+// rate.
+func (l *streamLimiter) wait() {
+	l.mu.Lock()
+	var wait time.Duration
+	if l.blockRate > 0 && l.bytes > l.blockRate {
+		if !l.blockNoticed {
+			l.blockNoticed = true
+			l.notify(fmt.Sprintf("output is coming faster than %d bytes per second; what writes it is held back", l.blockRate))
+		}
+		wait = time.Second - time.Since(l.start)
+	}
+	l.mu.Unlock()
+	l.drain()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// notify queues the notice text. The lock is held.
+func (l *streamLimiter) notify(text string) {
+	l.queue = append(l.queue, queuedOutput{text: text, notice: true})
+}
+
+// drain publishes what is queued, unless another goroutine is publishing it
+// already, the lock not held.
+func (l *streamLimiter) drain() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.publishing {
+		return
+	}
+	l.publishing = true
+	for len(l.queue) > 0 {
+		queue := l.queue
+		l.queue = nil
+		l.mu.Unlock()
+		for _, q := range queue {
+			switch {
+			case !q.notice:
+				l.publish(q.name, q.text)
+			case l.notice != nil:
+				l.notice(q.text)
+			default:
+				l.publish("stderr", "gophernotes: "+q.text+"\n")
+			}
+		}
+		l.mu.Lock()
+	}
+	l.publishing = false
+	l.drained.Broadcast()
+}
+
+// tick publishes the output held back, and goes on doing so every
+// streamFlushInterval while output is held back.
+func (l *streamLimiter) tick() {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.flush()
+	if l.batching {
+		l.timer.Reset(streamFlushInterval)
+	}
+	l.mu.Unlock()
+	l.drain()
+}
+
+// flush queues the output held back. The lock is held.
+func (l *streamLimiter) flush() {
+	for _, h := range l.held {
+		l.queue = append(l.queue, queuedOutput{name: h.name, text: h.text.String()})
+	}
+	l.held = nil
+}
+
+// Close publishes the output held back, once the output has ended, and waits
+// for all of it to be published.
+func (l *streamLimiter) Close() {
+	l.mu.Lock()
+	l.closed = true
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.flush()
+	l.mu.Unlock()
+	l.drain()
+	l.mu.Lock()
+	for l.publishing || len(l.queue) > 0 {
+		l.drained.Wait()
+	}
+	l.mu.Unlock()
+}
+
+// A streamWriter publishes what is written to it as text of the stream name,
+// through a stream limiter whose blocking rate holds back its writes: written
+// to from a pipe, it holds back the process writing to the pipe once the pipe
+// is full. Characters split across writes are published whole.
+//
+// A streamWriter is written to by a single goroutine, and flushed once the
+// output ends.
+type streamWriter struct {
+	name    string
+	limiter *streamLimiter
+	pending []byte
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	text, pending := wholeRunes(append(w.pending, p...))
+	w.pending = append([]byte(nil), pending...)
+	if len(text) > 0 {
+		w.limiter.Stream(w.name, string(text))
+	}
+	return len(p), nil
+}
+
+// Flush publishes what is left of a character the output ended in the middle
+// of.
+func (w *streamWriter) Flush() {
+	if len(w.pending) > 0 {
+		w.limiter.hold(w.name, string(w.pending))
+		w.pending = nil
+	}
+}
+
+// wholeRunes splits text before the character it ends in the middle of, if
+// any.
+func wholeRunes(text []byte) (whole, rest []byte) {
+	cut := len(text)
+	for i := len(text) - 1; i >= 0 && i >= len(text)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(text[i]) {
+			if !utf8.FullRune(text[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	return text[:cut], text[cut:]
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"go/importer"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordedStreams records what a stream limiter publishes.
+type recordedStreams struct {
+	sync.Mutex
+	msgs [][2]string
+}
+
+func (r *recordedStreams) publish(name, text string) {
+	r.Lock()
+	r.msgs = append(r.msgs, [2]string{name, text})
+	r.Unlock()
+}
+
+// text returns the text published on the stream name, and the number of
+// messages it took.
+func (r *recordedStreams) text(name string) (string, int) {
+	r.Lock()
+	defer r.Unlock()
+	var text string
+	n := 0
+	for _, m := range r.msgs {
+		if m[0] == name {
+			text += m[1]
+			n++
+		}
+	}
+	return text, n
+}
+
+// TestStreamLimiter tests that output beyond the budget is published in
+// batches, whole and in order, with a single notice
+func TestStreamLimiter(t *testing.T) {
+	var r recordedStreams
+	l := newStreamLimiter(r.publish, defaultConfig())
+	l.msgRate, l.dataRate, l.blockRate = 10, 1<<20, 0
+
+	var want string
+	for i := 0; i < 1000; i++ {
+		line := fmt.Sprintf("line %d\n", i)
+		l.Stream("stdout", line)
+		want += line
+	}
+	l.Stream("stderr", "err\n")
+	l.Stream("stdout", "last\n")
+	l.Close()
+
+	text, n := r.text("stdout")
+	assert.Equal(t, want+"last\n", text)
+	assert.True(t, n < 20, "published in %d messages", n)
+	stderr, _ := r.text("stderr")
+	assert.Equal(t, 1, strings.Count(stderr, "output is coming faster than 10 messages"))
+	assert.True(t, strings.HasSuffix(stderr, "err\n"), stderr)
+	last := r.msgs[len(r.msgs)-1]
+	assert.Equal(t, [2]string{"stdout", "last\n"}, last)
+}
+
+// TestStreamLimiter_block tests that output beyond the blocking rate makes
+// the producer wait for the second to end
+func TestStreamLimiter_block(t *testing.T) {
+	var r recordedStreams
+	l := newStreamLimiter(r.publish, defaultConfig())
+	l.msgRate, l.dataRate, l.blockRate = 1000, 100, 1000
+
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		l.Stream("stdout", strings.Repeat("x", 99)+"\n")
+	}
+	l.Close()
+	assert.True(t, time.Since(start) >= 900*time.Millisecond, "took %v", time.Since(start))
+	text, _ := r.text("stdout")
+	assert.Equal(t, 3000, len(text))
+	stderr, _ := r.text("stderr")
+	assert.Equal(t, 1, strings.Count(stderr, "faster than 1000 bytes per second; what writes it is held back"))
+}
+
+// TestStreamLimiter_publishing tests that a stream published while another
+// is still being sent neither waits for it nor overtakes it, and that Close
+// waits for both
+func TestStreamLimiter_publishing(t *testing.T) {
+	var r recordedStreams
+	sending, release := make(chan struct{}), make(chan struct{})
+	l := newStreamLimiter(func(name, text string) {
+		if text == "first\n" {
+			close(sending)
+			<-release
+		}
+		r.publish(name, text)
+	}, defaultConfig())
+
+	go l.Stream("stdout", "first\n")
+	<-sending
+	streamed := make(chan struct{})
+	go func() {
+		l.Stream("stderr", "second\n")
+		close(streamed)
+	}()
+	select {
+	case <-streamed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream waited for the stream being sent")
+	}
+	closed := make(chan struct{})
+	go func() {
+		l.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close did not wait for the stream being sent")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+	assert.Equal(t, [][2]string{{"stdout", "first\n"}, {"stderr", "second\n"}}, r.msgs)
+}
+
+// TestStreamLimiter_config tests that the budget set with %config limits the
+// output of shell commands
+func TestStreamLimiter_config(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+	defer c.execute(fmt.Sprintf("%%config stream_msg_rate %d", defaultConfig().streamMsgRate))
+
+	reply, _ := c.execute("%config stream_msg_rate 5")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	reply, published := c.execute("%%bash\nfor i in $(seq 40); do echo $i; sleep 0.005; done")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	var want string
+	for i := 1; i <= 40; i++ {
+		want += fmt.Sprintf("%d\n", i)
+	}
+	assert.Equal(t, want, streamText(published, "stdout"))
+	assert.Contains(t, streamText(published, "stderr"), "output is coming faster than 5 messages")
+
+	reply, _ = c.execute("%config stream_msg_rate -1")
+	assert.Equal(t, "error", reply.Content.(map[string]interface{})["status"])
+}
+
+// TestStreamLimiter_cellStdout tests that what a cell prints is published as
+// it comes, the cell waiting while it prints faster than the blocking rate,
+// and that its result, what fmt.Print returns, is published apart once it is
+// done
+func TestStreamLimiter_cellStdout(t *testing.T) {
+	if _, err := importer.Default().Import("github.com/gopherds/gophernotes/gophernotes"); err != nil {
+		t.Skip("gophernotes package not installed:", err)
+	}
+
+	// The cell stays in the session, printing again as the cells of the
+	// other tests run: the kernel is one of its own.
+	info := localConnectionInfo(t)
+	sockets, err := PrepareSockets(info)
+	noError(t, err)
+	config := defaultConfig()
+	config.streamDataRate, config.streamBlockRate = 1000, 4000
+	k, err := NewKernel(sockets, log.New(ioutil.Discard, "", 0), config)
+	noError(t, err)
+	defer k.removeSession()
+	go k.serve()
+	c := connectTestClient(t, info)
+	defer c.Close()
+
+	c.execute(":import strings")
+	start := time.Now()
+	reply, published := c.execute(`fmt.Print(strings.Repeat(strings.Repeat("x", 999)+"\n", 20))`)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.True(t, time.Since(start) >= time.Second, "took %v", time.Since(start))
+	assert.Equal(t, strings.Repeat(strings.Repeat("x", 999)+"\n", 20), streamText(published, "stdout"))
+	assert.Contains(t, streamText(published, "stderr"), "faster than 4000 bytes per second; what writes it is held back")
+	types := msgTypes(published)
+	if assert.NotEmpty(t, types) && assert.Equal(t, "execute_result", types[len(types)-1]) {
+		assert.Contains(t, published[len(published)-1].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"], "20000")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gopherds/gophernotes/gophernotes"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// subshellRequests are the control requests managing the subshells, answered
+// as soon as they are received.
+var subshellRequests = map[string]bool{
+	"create_subshell_request": true,
+	"delete_subshell_request": true,
+	"list_subshell_request":   true,
+}
+
+// subshells are the subshells of a kernel started with --subshells, which a
+// frontend creates on the control socket to run cells while those of the main
+// shell run, as JEP 91 has it, tagging the execute requests for a subshell
+// with its ID. Each subshell runs its cells one after the other, in a fork of
+// the session as the last cell of the main shell left it: cells read the
+// session concurrently, but what those that succeed declare is recorded in
+// the session afterwards, without running them again, in order with the
+// requests of the main shell, for writes to be serialized.
+type subshells struct {
+	sync.Mutex
+
+	// shells are the subshells by ID.
+	shells map[string]*subshell
+
+	// inOrder is the queue of the requests handled in order, where cells
+	// are recorded, and idle the watch of the requests handled, while the
+	// kernel serves.
+	inOrder chan<- shellRequest
+	idle    *idleWatch
+}
+
+// subshell is a subshell, whose queue holds the execute requests it is yet
+// to handle. Its cells are numbered apart from those of the main shell.
+type subshell struct {
+	queue     chan MsgReceipt
+	execCount int
+}
+
+// serving has the requests handled in order go to inOrder, and those the
+// subshells handle be recorded with idle, until it is called with nil.
+func (s *subshells) serving(inOrder chan<- shellRequest, idle *idleWatch) {
+	s.Lock()
+	defer s.Unlock()
+	s.inOrder, s.idle = inOrder, idle
+}
+
+// HandleSubshellRequest answers a request managing the subshells, on the
+// control socket. Subshells are only created once enabled with --subshells.
+func (k *Kernel) HandleSubshellRequest(receipt MsgReceipt) {
+	msgType := receipt.Msg.Header.MsgType
+	reply := NewMsg(strings.TrimSuffix(msgType, "_request")+"_reply", receipt.Msg)
+	content, err := k.subshellReply(msgType, receipt.Msg.Content)
+	if err != nil {
+		content = newKernelError("Error", err.Error()).replyContent()
+	}
+	reply.Content = content
+	receipt.SendResponse(receipt.Sockets.ControlSocket, reply)
+}
+
+// subshellReply does what the request of type msgType, with content, asks of
+// the subshells, and returns the content of the reply.
+func (k *Kernel) subshellReply(msgType string, content interface{}) (map[string]interface{}, error) {
+	if !k.options().subshells {
+		return nil, fmt.Errorf("subshells are not enabled: start the kernel with --subshells")
+	}
+	s := &k.subshells
+	switch msgType {
+	case "create_subshell_request":
+		u, err := uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+		sh := &subshell{queue: make(chan MsgReceipt, shellQueueSize)}
+		s.Lock()
+		if s.shells == nil {
+			s.shells = map[string]*subshell{}
+		}
+		s.shells[u.String()] = sh
+		idle := s.idle
+		s.Unlock()
+		go k.runSubshell(sh, idle)
+		return map[string]interface{}{"status": "ok", "subshell_id": u.String()}, nil
+
+	case "delete_subshell_request":
+		id, _ := contentMap(content)["subshell_id"].(string)
+		s.Lock()
+		defer s.Unlock()
+		sh, ok := s.shells[id]
+		if !ok {
+			return nil, fmt.Errorf("no subshell %q", id)
+		}
+		delete(s.shells, id)
+		close(sh.queue)
+		return map[string]interface{}{"status": "ok"}, nil
+
+	default:
+		s.Lock()
+		defer s.Unlock()
+		ids := make([]string, 0, len(s.shells))
+		for id := range s.shells {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return map[string]interface{}{"status": "ok", "subshell_id": ids}, nil
+	}
+}
+
+// queueSubshellRequest has the subshell the execute request of receipt is
+// tagged with handle it, answering it with an error if there is no such
+// subshell. It reports whether the request was queued, for it to be recorded
+// done once handled.
+func (k *Kernel) queueSubshellRequest(receipt MsgReceipt) bool {
+	id := receipt.Msg.Header.SubshellID
+	s := &k.subshells
+	s.Lock()
+	sh, ok := s.shells[id]
+	s.Unlock()
+
+	// Subshells are deleted as requests are queued, by serve alone.
+	if ok {
+		sh.queue <- receipt
+		return true
+	}
+
+	k.handle(receipt, func(receipt MsgReceipt) {
+		reply := NewMsg("execute_reply", receipt.Msg)
+		reply.Content = executeReplyContent(receipt, 0, nil, newKernelError("Error", fmt.Sprintf("no subshell %q", id)), k.options().noColor)
+		receipt.SendResponse(receipt.Sockets.ShellSocket, reply)
+	})
+	return false
+}
+
+// runSubshell handles the execute requests of sh one after the other, until
+// the subshell is deleted, recording each done with idle.
+func (k *Kernel) runSubshell(sh *subshell, idle *idleWatch) {
+	for receipt := range sh.queue {
+		k.handle(receipt, func(receipt MsgReceipt) { k.handleSubshellExecute(sh, receipt) })
+		if idle != nil {
+			idle.done()
+		}
+	}
+}
+
+// handleSubshellExecute runs the cell of an execute request in subshell sh,
+// and sends the replies. Magics, which change the kernel, run in the main
+// shell alone.
+func (k *Kernel) handleSubshellExecute(sh *subshell, receipt MsgReceipt) {
+	reqcontent := receipt.Msg.Content.(map[string]interface{})
+	code := reqcontent["code"].(string)
+	silent := reqcontent["silent"].(bool)
+	if !silent {
+		sh.execCount++
+	}
+
+	var errContent *KernelError
+	if _, _, ok := inspectionQuery(code); ok || isMagicCell(code) {
+		errContent = newKernelError("Error", "magics and inspection queries only run in the main shell")
+	} else {
+		errContent = k.runSubshellCode(sh, receipt, code, silent)
+	}
+	reply := NewMsg("execute_reply", receipt.Msg)
+	reply.Content = executeReplyContent(receipt, sh.execCount, nil, errContent, k.options().noColor)
+	receipt.SendResponse(receipt.Sockets.ShellSocket, reply)
+}
+
+// runSubshellCode evaluates code as the code of a cell of subshell sh, in a
+// fork of the session, publishing what it displays and its result, and
+// returns the error it failed with, if any. Once the code succeeds it is
+// queued to be recorded in the session.
+func (k *Kernel) runSubshellCode(sh *subshell, receipt MsgReceipt, code string, silent bool) *KernelError {
+	fork, err := k.readSessionOf(receipt).Fork()
+	if err != nil {
+		return newKernelError("Error", "could not fork the session: "+err.Error())
+	}
+	defer os.RemoveAll(fork.Dir())
+
+	// The fork talks with the kernel through display and cell files of its
+	// own, and those of the kernel otherwise.
+	display := filepath.Join(fork.Dir(), "display.jsonl")
+	cell := filepath.Join(fork.Dir(), "cell.txt")
+	env := fork.Env[:0]
+	for _, v := range fork.Env {
+		if !strings.HasPrefix(v, gophernotes.DisplayFileEnv+"=") && !strings.HasPrefix(v, gophernotes.CellFileEnv+"=") {
+			env = append(env, v)
+		}
+	}
+	fork.Env = append(env, gophernotes.DisplayFileEnv+"="+display, gophernotes.CellFileEnv+"="+cell)
+
+	relay, err := k.startRelay(display, &displayRelay{receipt: receipt, silent: silent, count: sh.execCount, subshell: true})
+	if err != nil {
+		k.diagnoseIn(&receipt, diagError, "display", "could not start the display relay: the displays of the cell are not shown", "error", err)
+	}
+	if err := ioutil.WriteFile(cell, []byte(code), 0644); err != nil {
+		k.diagnoseIn(&receipt, diagWarning, "cell", "could not write the cell file: stack traces are not located in the cell", "error", err)
+	}
+	if relay != nil && !silent {
+		fork.Stdout = relay.Stdout()
+	}
+	val, stderr, err := fork.Eval(code)
+	if relay != nil {
+		relay.Stop()
+		val += relay.Results()
+	}
+
+	if err != nil {
+		errContent := newErrMsg(err, stderr.String(), sessionSource(fork, code), !k.options().noColor)
+		return &errContent
+	}
+	if len(val) > 0 && !silent {
+		out := NewMsg("execute_result", receipt.Msg)
+		out.Content = OutputMsg{
+			Execcount: sh.execCount,
+			Data:      map[string]interface{}{"text/plain": val},
+			Metadata:  map[string]interface{}{},
+		}
+		receipt.SendResponse(receipt.Sockets.IOPubSocket, out)
+	}
+
+	k.subshells.Lock()
+	if k.subshells.inOrder != nil {
+		k.subshells.inOrder <- shellRequest{receipt: receipt, subshellCell: code}
+	}
+	k.subshells.Unlock()
+	return nil
+}
+
+// recordSubshellCell records code, that of a cell a subshell ran, in the
+// session, for the cells run next to go on from it.
+func (k *Kernel) recordSubshellCell(code string) {
+	if err := k.session.Record(code); err != nil {
+		k.diagnose(diagWarning, "subshell", "could not record the cell of a subshell: the cells run next do not see what it defined", "error", err)
+	}
+	k.takeSnapshot()
+}
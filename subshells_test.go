@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// controlRequest sends a request of type msgType on the control socket, and
+// returns the content of its reply.
+func (c *testClient) controlRequest(msgType string, content map[string]interface{}) map[string]interface{} {
+	req := c.sendOn(c.control, msgType, content, nil)
+	for {
+		reply := c.recv(c.control)
+		if reply.ParentHeader.MsgID == req.Header.MsgID {
+			return reply.Content.(map[string]interface{})
+		}
+	}
+}
+
+// sendToSubshell sends an execute request running code in subshell id, and
+// returns it.
+func (c *testClient) sendToSubshell(id, code string) ComposedMsg {
+	u, err := uuid.NewV4()
+	noError(c.t, err)
+	var msg ComposedMsg
+	msg.Header = MsgHeader{MsgID: u.String(), Username: "test", Session: "test", MsgType: "execute_request", SubshellID: id}
+	msg.Content = map[string]interface{}{
+		"code":             code,
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      false,
+	}
+	parts, err := msg.ToWireMsg(c.key)
+	noError(c.t, err)
+	noError(c.t, c.shell.SendMultipart(append([][]byte{[]byte("<IDS|MSG>")}, parts...), 0))
+	return msg
+}
+
+// kernelInfo returns the content of the kernel_info_reply of the kernel.
+func (c *testClient) kernelInfo() map[string]interface{} {
+	req := c.send("kernel_info_request", map[string]interface{}{})
+	for {
+		reply := c.recv(c.shell)
+		if reply.ParentHeader.MsgID == req.Header.MsgID {
+			return reply.Content.(map[string]interface{})
+		}
+	}
+}
+
+// TestServe_subshells tests that a kernel started with --subshells advertises
+// them, creates, lists and deletes them on the control socket, and runs the
+// cells of a subshell while a cell of the main shell runs, numbered apart,
+// reading the session as the main shell left it and writing to it once done
+func TestServe_subshells(t *testing.T) {
+	c := newTestClient(t)
+	info := localConnectionInfo(t)
+	sockets, err := PrepareSockets(info)
+	noError(t, err)
+	config := defaultConfig()
+	config.subshells = true
+	k, err := NewKernel(sockets, log.New(ioutil.Discard, "", 0), config)
+	noError(t, err)
+	defer k.removeSession()
+	go k.serve()
+
+	// The kernel of the other tests has no subshells.
+	kernelInfo := c.kernelInfo()
+	assert.Nil(t, kernelInfo["supported_features"])
+	assert.Equal(t, "5.3", kernelInfo["protocol_version"])
+	assert.Equal(t, "error", c.controlRequest("create_subshell_request", map[string]interface{}{})["status"])
+	c.Close()
+
+	c = connectTestClient(t, info)
+	defer c.Close()
+	kernelInfo = c.kernelInfo()
+	assert.Equal(t, []interface{}{"kernel subshells"}, kernelInfo["supported_features"])
+	assert.Equal(t, "5.5", kernelInfo["protocol_version"], "subshells come with version 5.5 of the protocol")
+	created := c.controlRequest("create_subshell_request", map[string]interface{}{})
+	assert.Equal(t, "ok", created["status"])
+	id := created["subshell_id"].(string)
+	assert.Equal(t, []interface{}{id}, c.controlRequest("list_subshell_request", map[string]interface{}{})["subshell_id"])
+
+	reply, _ := c.execute("subX := 4")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	main := c.send("execute_request", map[string]interface{}{
+		"code":             ":import time\ntime.Sleep(6 * time.Second)",
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      false,
+	})
+	time.Sleep(500 * time.Millisecond)
+	start := time.Now()
+	reply, published := c.results(c.sendToSubshell(id, "subY := subX * 10\nsubY"))
+	assert.True(t, time.Since(start) < 5*time.Second, "answered in %v", time.Since(start))
+	content := reply.Content.(map[string]interface{})
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	assert.Equal(t, 1.0, content["execution_count"])
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "40\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+	assert.Equal(t, id, reply.ParentHeader.SubshellID)
+
+	reply, _ = c.results(main)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	_, published = c.execute("subY + 2")
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "42\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+
+	reply, _ = c.results(c.sendToSubshell(id, "%who"))
+	assert.Equal(t, "magics and inspection queries only run in the main shell", reply.Content.(map[string]interface{})["evalue"])
+
+	assert.Equal(t, "ok", c.controlRequest("delete_subshell_request", map[string]interface{}{"subshell_id": id})["status"])
+	assert.Equal(t, []interface{}{}, c.controlRequest("list_subshell_request", map[string]interface{}{})["subshell_id"])
+	assert.Equal(t, "error", c.controlRequest("delete_subshell_request", map[string]interface{}{"subshell_id": id})["status"])
+	reply, _ = c.results(c.sendToSubshell(id, "subY"))
+	assert.Equal(t, `no subshell "`+id+`"`, reply.Content.(map[string]interface{})["evalue"])
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tempDirPrefix starts the names of the temporary directories of kernels,
+// which go on with the ID of the kernel session.
+const tempDirPrefix = "gophernotes-"
+
+// staleTempAge is how long the temporary directory of a kernel is left alone
+// without being touched before a kernel starting sweeps it, as that of a
+// kernel that did not shut down.
+const staleTempAge = 7 * 24 * time.Hour
+
+// tempTouchInterval is how often a kernel serving touches its temporary
+// directory, for it not to be swept while the kernel runs.
+const tempTouchInterval = time.Hour
+
+// newTempDir makes the temporary directory of the kernel session id, in the
+// default directory for temporary files, which honors TMPDIR.
+func newTempDir(id string) (string, error) {
+	dir := filepath.Join(os.TempDir(), tempDirPrefix+id)
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// TempDir returns the temporary directory of the kernel, where magics write
+// their scratch files: the kernel removes it as it shuts down.
+func (ctx *MagicContext) TempDir() string {
+	return ctx.Kernel.tempDir
+}
+
+// touchTempDir touches the temporary directory of the kernel every
+// tempTouchInterval, for as long as the kernel runs.
+func (k *Kernel) touchTempDir() {
+	for now := range time.Tick(tempTouchInterval) {
+		os.Chtimes(k.tempDir, now, now)
+	}
+}
+
+// sweepTempDirs removes the temporary directories of kernels in dir that were
+// not touched for staleTempAge, those of kernels that crashed or were killed
+// before they could remove them, logging them.
+func sweepTempDirs(dir string, logger *log.Logger) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		if !info.IsDir() || !strings.HasPrefix(info.Name(), tempDirPrefix) || time.Since(info.ModTime()) < staleTempAge {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		if err := os.RemoveAll(path); err != nil {
+			logger.Println("Could not remove the stale temporary directory", path+":", err)
+			continue
+		}
+		logger.Println("Removed the stale temporary directory", path)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestKernel_tempDir tests that a kernel makes a temporary directory named
+// after its session ID, holding its session, that cell code and magics write
+// their scratch files to, and that it removes it on shutdown unless told to
+// keep it
+func TestKernel_tempDir(t *testing.T) {
+	_, cleanup := testNotebook(t)
+	defer cleanup()
+	var published []ComposedMsg
+	k, err := NewKernel(SocketGroup{deliver: func(msg ComposedMsg) {
+		published = append(published, msg)
+	}}, log.New(ioutil.Discard, "", 0), defaultConfig())
+	noError(t, err)
+	defer k.removeSession()
+	execute := func(code string) []ComposedMsg {
+		published = nil
+		k.HandleExecuteRequest(MsgReceipt{Msg: newExecuteRequest(code), Sockets: k.sockets})
+		return published
+	}
+
+	assert.Equal(t, filepath.Join(os.TempDir(), "gophernotes-"+k.history.session), k.tempDir)
+	assert.True(t, strings.HasPrefix(k.session.FilePath, k.tempDir+string(filepath.Separator)), k.session.FilePath)
+	for _, msg := range execute("gophernotes.TempDir()") {
+		if msg.Header.MsgType == "execute_result" {
+			assert.Equal(t, `"`+k.tempDir+"\"\n", contentMap(msg.Content)["data"].(map[string]interface{})["text/plain"])
+		}
+	}
+	text := streamText(execute("%%writefile\nscratch\n"), "stdout")
+	assert.True(t, strings.HasPrefix(text, "Wrote 8 bytes to "+filepath.Join(k.tempDir, "writefile_")), text)
+
+	k.config.keepTemp = true
+	k.shutdown()
+	_, err = os.Stat(k.session.FilePath)
+	assert.NoError(t, err, "the temporary directory is kept with --keep-temp")
+	k.config.keepTemp = false
+	k.shutdown()
+	_, err = os.Stat(k.tempDir)
+	assert.True(t, os.IsNotExist(err), "the temporary directory is removed on shutdown")
+}
+
+// TestSweepTempDirs tests that the temporary directories of kernels left
+// untouched for long are swept, and that others are left alone
+func TestSweepTempDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophernotes_sweep")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+	old := time.Now().Add(-staleTempAge - time.Hour)
+	for _, name := range []string{"gophernotes-stale", "gophernotes-fresh", "other-stale"} {
+		noError(t, os.MkdirAll(filepath.Join(dir, name, "session"), 0700))
+		if strings.HasSuffix(name, "-stale") {
+			noError(t, os.Chtimes(filepath.Join(dir, name), old, old))
+		}
+	}
+
+	sweepTempDirs(dir, log.New(ioutil.Discard, "", 0))
+	infos, err := ioutil.ReadDir(dir)
+	noError(t, err)
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	assert.Equal(t, []string{"gophernotes-fresh", "other-stale"}, names)
+}
@@ -0,0 +1,9 @@
+package main
+
+import "syscall"
+
+// The requests of ioctl getting and setting the settings of a terminal.
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// isTerminal reports whether f is a terminal, which the console does not
+// edit lines of on this platform, leaving it to the terminal.
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+// makeRaw fails: terminals are left as they are on this platform.
+func makeRaw(f *os.File) (restore func(), err error) {
+	return nil, errors.New("raw mode is not supported on this platform")
+}
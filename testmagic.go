@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	RegisterLineMagic("test", "%test [-run <regexp>]", "run the test functions declared by the cells with go test\n-run is passed to go test, selecting the tests run.\nA failing test fails the cell.", testMagic)
+	RegisterCellMagic("test", "%%test [-run <regexp>]", "run the rest of the cell, then the test functions it declares, with go test\n-run is passed to go test, selecting other tests to run.\nA failing test fails the cell.", testCellMagic)
+}
+
+// testFuncRe matches the declarations of test functions at the start of the
+// lines of a cell, capturing their name.
+var testFuncRe = regexp.MustCompile(`(?m)^func (Test\w*)\(`)
+
+// testResultRe matches the lines of go test -v telling the result of a test
+// function, rather than of a subtest, which are indented.
+var testResultRe = regexp.MustCompile(`^--- (PASS|FAIL|SKIP): `)
+
+// testMagic runs the test functions the session declares with go test -v.
+func testMagic(ctx *MagicContext, args []string, body string) error {
+	run, err := testRunFlag(args)
+	if err != nil {
+		return err
+	}
+	if len(ctx.Session.TestFuncs()) == 0 {
+		return errors.New("no test functions are declared")
+	}
+	return runTests(ctx, run)
+}
+
+// testCellMagic runs the rest of the cell, and then the test functions it
+// declares, unless -run selects others, with go test -v.
+func testCellMagic(ctx *MagicContext, args []string, body string) error {
+	run, err := testRunFlag(args)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Run(body); err != nil {
+		return err
+	}
+	var names []string
+	for _, m := range testFuncRe.FindAllStringSubmatch(body, -1) {
+		names = append(names, regexp.QuoteMeta(m[1]))
+	}
+	if len(names) == 0 {
+		return errors.New("the cell declares no test functions")
+	}
+	if run == "" {
+		run = "^(" + strings.Join(names, "|") + ")$"
+	}
+	return runTests(ctx, run)
+}
+
+// testRunFlag returns the regexp given with -run in args, if any.
+func testRunFlag(args []string) (string, error) {
+	var run string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-run" && i+1 < len(args):
+			i++
+			run = args[i]
+		case strings.HasPrefix(args[i], "-run="):
+			run = strings.TrimPrefix(args[i], "-run=")
+		case args[i] == "-run":
+			return "", errors.New("-run needs a regexp")
+		default:
+			return "", fmt.Errorf("unknown argument %s", args[i])
+		}
+	}
+	return run, nil
+}
+
+// runTests runs the test functions of the session matching run, or all of
+// them if it is empty, with go test -v, in a package made of the declarations
+// of the session. The output of go test is shown as it comes, followed by the
+// number of tests that passed and failed, and fails the cell if a test does.
+func runTests(ctx *MagicContext, run string) error {
+	flags := []string{"-v"}
+	if run != "" {
+		flags = append(flags, "-run", run)
+	}
+	cmd, err := ctx.Session.TestCommand(flags)
+	if err != nil {
+		return err
+	}
+
+	results := map[string]int{}
+	var pending string
+	exited, failed := streamProcess(ctx, cmd, "%test", func(name, text string) {
+		ctx.Stream(name, text)
+		if name != "stdout" {
+			return
+		}
+		lines := strings.Split(pending+text, "\n")
+		pending = lines[len(lines)-1]
+		for _, line := range lines[:len(lines)-1] {
+			if m := testResultRe.FindStringSubmatch(line); m != nil {
+				results[m[1]]++
+			}
+		}
+	})
+	if failed != nil {
+		return failed
+	}
+
+	total := results["PASS"] + results["FAIL"] + results["SKIP"]
+	if exited != nil && results["FAIL"] == 0 {
+		return fmt.Errorf("go test failed: %s", exited)
+	}
+	summary := fmt.Sprintf("%d passed, %d failed", results["PASS"], results["FAIL"])
+	if results["SKIP"] > 0 {
+		summary += fmt.Sprintf(", %d skipped", results["SKIP"])
+	}
+	if total == 0 {
+		summary = "No tests ran"
+	}
+	ctx.Stream("stdout", summary+".\n")
+	if results["FAIL"] > 0 {
+		return fmt.Errorf("%d of %d tests failed", results["FAIL"], total)
+	}
+	return nil
+}
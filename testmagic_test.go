@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTestMagics tests that %test runs the test functions declared by cells,
+// with their output and a summary, failing the cell if a test fails, that
+// %%test runs the tests of its cell only, and that -run selects tests
+func TestTestMagics(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+	defer c.execute("%reset -f")
+
+	reply, _ := c.execute("%test")
+	assert.Equal(t, "%test: no test functions are declared", reply.Content.(map[string]interface{})["evalue"])
+
+	c.execute(":import testing")
+	c.execute("func double(n int) int {\n\treturn 2 * n\n}")
+	reply, published := c.execute("%%test\nfunc TestDouble(t *testing.T) {\n\tif double(2) != 4 {\n\t\tt.Fatal(\"double(2) != 4\")\n\t}\n}")
+	content := reply.Content.(map[string]interface{})
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	stdout := streamText(published, "stdout")
+	assert.Contains(t, stdout, "--- PASS: TestDouble")
+	assert.Contains(t, stdout, "1 passed, 0 failed.\n")
+
+	reply, published = c.execute("%%test\nfunc TestTriple(t *testing.T) {\n\tif double(3) != 6 {\n\t\tt.Error(\"double(3) != 6\")\n\t}\n\tif double(3) != 9 {\n\t\tt.Error(\"double(3) != 9\")\n\t}\n}")
+	content = reply.Content.(map[string]interface{})
+	assert.Equal(t, "error", content["status"])
+	assert.Equal(t, "%%test: 1 of 1 tests failed", content["evalue"])
+	stdout = streamText(published, "stdout")
+	assert.NotContains(t, stdout, "TestDouble")
+	assert.Contains(t, stdout, "double(3) != 9")
+	assert.Contains(t, stdout, "0 passed, 1 failed.\n")
+
+	reply, published = c.execute("%test -run Double")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.NotContains(t, streamText(published, "stdout"), "TestTriple")
+
+	reply, published = c.execute("%test")
+	assert.Equal(t, "error", reply.Content.(map[string]interface{})["status"])
+	assert.Contains(t, streamText(published, "stdout"), "1 passed, 1 failed.\n")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterLineMagic("time", "%time <statement>", "time a statement or expression, run once", timeMagic)
+	RegisterCellMagic("timeit", "%%timeit [-n <runs>] [-r <rounds>]", "time the cell over many runs\n-n sets the number of runs per round, and -r the number of rounds.", timeitMagic)
+}
+
+// errNoRuntime is returned by the magics relying on the gophernotes package,
+// such as those timing code, in sessions without it.
+var errNoRuntime = errors.New("the gophernotes package is not installed")
+
+// timeMagic runs the statement or expression following %time once, in the
+// scope of the session, between the start and the end of a timer.
+func timeMagic(ctx *MagicContext, args []string, body string) error {
+	if ctx.Args == "" {
+		return errors.New("no code to time")
+	}
+	if !ctx.Session.Runtime() {
+		return errNoRuntime
+	}
+	return ctx.Run("gophernotes.StartTimer()\n" + ctx.Args + "\ngophernotes.StopTimer()")
+}
+
+// timeitMagic runs the body of the cell repeatedly, in a function of the
+// session, for gophernotes.TimeIt to time. The number of runs per round and
+// of rounds are given by -n and -r, or else chosen by TimeIt.
+func timeitMagic(ctx *MagicContext, args []string, body string) error {
+	var runs, rounds int
+	for i := 0; i < len(args); i++ {
+		var n *int
+		switch args[i] {
+		case "-n":
+			n = &runs
+		case "-r":
+			n = &rounds
+		default:
+			return fmt.Errorf("unknown argument %s", args[i])
+		}
+		if i+1 == len(args) {
+			return fmt.Errorf("%s needs a number", args[i])
+		}
+		i++
+		v, err := strconv.Atoi(args[i])
+		if err != nil || v <= 0 {
+			return fmt.Errorf("%s needs a positive number, not %s", args[i-1], args[i])
+		}
+		*n = v
+	}
+	if strings.TrimSpace(body) == "" {
+		return errors.New("no code to time")
+	}
+	if !ctx.Session.Runtime() {
+		return errNoRuntime
+	}
+
+	// An expression other than a call is not a statement of its own.
+	if expr, err := parser.ParseExpr(body); err == nil {
+		if _, ok := expr.(*ast.CallExpr); !ok {
+			body = "_ = " + body
+		}
+	}
+	return ctx.Run(fmt.Sprintf("gophernotes.TimeIt(func() {\n%s\n}, %d, %d)", body, runs, rounds))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTimeMagics tests that %time runs code once in the scope of the session,
+// and %%timeit many times, both reporting their timing on stdout and in the
+// metadata of the reply
+func TestTimeMagics(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	reply, _ := c.execute("timedN := 0")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+
+	reply, published := c.execute("%time timedX := timedN + 1")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.True(t, strings.HasPrefix(streamText(published, "stdout"), "wall "), streamText(published, "stdout"))
+	timing, _ := reply.Metadata["timing"].(map[string]interface{})
+	assert.Contains(t, timing, "wall_ns")
+
+	reply, published = c.execute("%%timeit -n 10 -r 2\ntimedN += timedX")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Contains(t, streamText(published, "stdout"), "(best of 2 rounds of 10 runs)")
+	assert.Contains(t, streamText(published, "stdout"), "The cell ran 20 times")
+	timing, _ = reply.Metadata["timing"].(map[string]interface{})
+	assert.Equal(t, 10.0, timing["runs"])
+
+	_, published = c.execute("timedN")
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "20\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+
+	// The value of an expression timed is shown as that of the cell.
+	_, published = c.execute("%time timedN * 2")
+	if assert.Equal(t, []string{"stream", "execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "40\n", published[1].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+
+	reply, _ = c.execute("%%timeit -n x\ntimedN")
+	assert.Equal(t, "%%timeit: -n needs a positive number, not x", reply.Content.(map[string]interface{})["evalue"])
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+	"github.com/gopherds/gophernotes/internal/trace"
+)
+
+// ANSI escape codes used in tracebacks.
+const (
+	ansiError    = "\x1b[1;31m"
+	ansiLocation = "\x1b[32m"
+	ansiReset    = "\x1b[0m"
+)
+
+// ansiRe matches the ANSI escape codes coloring text.
+var ansiRe = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// uncolored returns traceback without its colors.
+func uncolored(traceback []string) []string {
+	plain := make([]string, len(traceback))
+	for i, line := range traceback {
+		plain[i] = ansiRe.ReplaceAllString(line, "")
+	}
+	return plain
+}
+
+var (
+	// compileErrorRe matches the errors reported by the compiler, such as
+	// "/tmp/123/gophernotes_session.go:21:16: undefined: x".
+	compileErrorRe = regexp.MustCompile(`^(\S+\.go):(\d+):(?:(\d+):)? (.*)$`)
+
+	// frameFileRe matches the location of a stack frame in a goroutine dump,
+	// such as "\t/tmp/123/gophernotes_session.go:16 +0x14".
+	frameFileRe = regexp.MustCompile(`^\t(\S+\.go):(\d+)(?: \+0x[0-9a-f]+)?$`)
+)
+
+// sessionSource returns the source of the cell code just run by session.
+func sessionSource(session *repl.Session, code string) trace.Source {
+	return trace.Source{
+		Code:    code,
+		Session: session.LastSource(),
+		Files:   append([]string{session.FilePath}, session.ExtraFilePaths...),
+		Local:   session.ModuleContext().Dirs,
+	}
+}
+
+// newErrMsg builds the error content for a cell that failed with err, after
+// writing stderr. The traceback holds whatever the cell wrote to stderr
+// along with the compiler errors or the stack frames of the cell's own code,
+// colored unless color is false; evalue is never colored.
+func newErrMsg(err error, stderr string, src trace.Source, color bool) KernelError {
+	paint := func(code, s string) string {
+		if !color {
+			return s
+		}
+		return code + s + ansiReset
+	}
+
+	msg := KernelError{EName: "Error", EValue: err.Error()}
+	var output, details []string
+	var compileErrors []string
+	panicking := false
+
+	lines := strings.Split(strings.TrimRight(stderr, "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "# "), strings.HasPrefix(line, "exit status "):
+		case panicking:
+			// Past the panic message comes the goroutine dump, of which
+			// only the frames of the cell's code are kept.
+			if i+1 == len(lines) {
+				continue
+			}
+			m := frameFileRe.FindStringSubmatch(lines[i+1])
+			if m == nil {
+				continue
+			}
+			i++
+			if !src.IsUserFile(m[1]) || strings.HasPrefix(line, "main."+trace.PrinterName+"(") {
+				continue
+			}
+			n, _ := strconv.Atoi(m[2])
+			label, text, _ := src.Locate(m[1], n, 0)
+			details = append(details, paint(ansiLocation, label)+" in "+line)
+			if text != "" {
+				details = append(details, "    "+text)
+			}
+		case strings.HasPrefix(line, "panic: "), strings.HasPrefix(line, "fatal error: "):
+			sep := strings.Index(line, ": ")
+			msg.EName, msg.EValue = line[:sep], line[sep+2:]
+			panicking = true
+		default:
+			m := compileErrorRe.FindStringSubmatch(line)
+			if m == nil || !src.IsUserFile(m[1]) && !src.IsLocalFile(m[1]) {
+				output = append(output, line)
+				continue
+			}
+			n, _ := strconv.Atoi(m[2])
+			col, _ := strconv.Atoi(m[3])
+			label, text, caret := src.Locate(m[1], n, col)
+			compileErrors = append(compileErrors, m[4])
+			details = append(details, paint(ansiLocation, label)+": "+m[4])
+			if text != "" {
+				details = append(details, "    "+text)
+				if caret != "" {
+					details = append(details, "    "+caret)
+				}
+			}
+		}
+	}
+
+	if len(compileErrors) > 0 {
+		msg.EName, msg.EValue = "CompileError", compileErrors[0]
+		if len(compileErrors) > 1 {
+			msg.EValue += fmt.Sprintf(" (and %d more errors)", len(compileErrors)-1)
+		}
+	}
+
+	msg.Traceback = append(output, paint(ansiError, msg.EName)+": "+msg.EValue)
+	msg.Traceback = append(msg.Traceback, details...)
+	return msg
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gopherds/gophernotes/internal/trace"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSession is a session file as it runs a cell.
+const testSession = `package main
+
+func __gophernotes(xx ...interface{}) {
+}
+
+func main() {
+	a := []int{1}
+	x := 2
+	b := a[x]
+	__gophernotes(undefinedThing + 1)
+}
+`
+
+var testSource = trace.Source{
+	Code:    "x := 2\nif true {\n    b := a[x]\n}\nundefinedThing + 1",
+	Session: testSession,
+	Files:   []string{"/tmp/1/gophernotes_session.go"},
+}
+
+var errStderr = errors.New("Unexpected stderr from execution")
+
+// TestNewErrMsg_compile tests that compiler errors are located in the cell,
+// with a caret under the offending column
+func TestNewErrMsg_compile(t *testing.T) {
+	stderr := "# command-line-arguments\n" +
+		"/tmp/1/gophernotes_session.go:10:16: undefined: undefinedThing\n" +
+		"/tmp/1/gophernotes_session.go:9:2: declared and not used: b\n"
+
+	msg := newErrMsg(errStderr, stderr, testSource, false)
+	assert.Equal(t, "CompileError", msg.EName)
+	assert.Equal(t, "undefined: undefinedThing (and 1 more errors)", msg.EValue)
+	assert.Equal(t, []string{
+		"CompileError: undefined: undefinedThing (and 1 more errors)",
+		"cell line 5:1: undefined: undefinedThing",
+		"    undefinedThing + 1",
+		"    ^",
+		"cell line 3:5: declared and not used: b",
+		"        b := a[x]",
+		"        ^",
+	}, msg.Traceback)
+}
+
+// TestNewErrMsg_local tests that the errors of the packages of local modules
+// are compile errors too, located at the lines of their files, while those of
+// other packages are left as they are
+func TestNewErrMsg_local(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophernotes_local")
+	noError(t, err)
+	defer os.RemoveAll(dir)
+	lib := filepath.Join(dir, "lib.go")
+	noError(t, ioutil.WriteFile(lib, []byte("package lib\n\n\tvar broken int = \"x\"\n"), 0644))
+
+	src := testSource
+	src.Local = []string{dir}
+	stderr := "# example.com/lib\n" +
+		lib + ":3:19: cannot use \"x\" as int value in variable declaration\n" +
+		"/go/src/example.com/other/other.go:1:1: expected 'package', found x\n"
+	msg := newErrMsg(errStderr, stderr, src, false)
+	assert.Equal(t, "CompileError", msg.EName)
+	assert.Equal(t, []string{
+		"/go/src/example.com/other/other.go:1:1: expected 'package', found x",
+		"CompileError: cannot use \"x\" as int value in variable declaration",
+		lib + ":3:19: cannot use \"x\" as int value in variable declaration",
+		"    var broken int = \"x\"",
+		"                     ^",
+	}, msg.Traceback)
+}
+
+// TestNewErrMsg_panic tests that panics keep only the frames of the cell's
+// code
+func TestNewErrMsg_panic(t *testing.T) {
+	stderr := "some output\n" +
+		"panic: runtime error: index out of range [2] with length 1\n\n" +
+		"goroutine 1 [running]:\n" +
+		"github.com/gopherds/gophernotes/gophernotes.Display(...)\n" +
+		"\t/go/src/github.com/gopherds/gophernotes/gophernotes/display.go:12 +0x1d\n" +
+		"main.main()\n" +
+		"\t/tmp/1/gophernotes_session.go:9 +0x14\n" +
+		"exit status 2\n"
+
+	msg := newErrMsg(errStderr, stderr, testSource, false)
+	assert.Equal(t, "panic", msg.EName)
+	assert.Equal(t, "runtime error: index out of range [2] with length 1", msg.EValue)
+	assert.Equal(t, []string{
+		"some output",
+		"panic: runtime error: index out of range [2] with length 1",
+		"cell line 3 in main.main()",
+		"        b := a[x]",
+	}, msg.Traceback)
+}
+
+// TestNewErrMsg_stderr tests that other output on stderr is kept as is
+func TestNewErrMsg_stderr(t *testing.T) {
+	msg := newErrMsg(errStderr, "warning: careful\n", testSource, false)
+	assert.Equal(t, "Error", msg.EName)
+	assert.Equal(t, "Unexpected stderr from execution", msg.EValue)
+	assert.Equal(t, []string{"warning: careful", "Error: Unexpected stderr from execution"}, msg.Traceback)
+}
+
+// TestNewErrMsg_color tests that the error name and locations are colored,
+// but not evalue
+func TestNewErrMsg_color(t *testing.T) {
+	stderr := "/tmp/1/gophernotes_session.go:10:16: undefined: undefinedThing\n"
+
+	msg := newErrMsg(errStderr, stderr, testSource, true)
+	assert.Equal(t, "undefined: undefinedThing", msg.EValue)
+	assert.Equal(t, ansiError+"CompileError"+ansiReset+": undefined: undefinedThing", msg.Traceback[0])
+	assert.True(t, strings.HasPrefix(msg.Traceback[1], ansiLocation+"cell line 5:1"+ansiReset))
+}
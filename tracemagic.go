@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gopherds/gophernotes/gophernotes"
+	repl "github.com/gopherds/gophernotes/internal/repl"
+)
+
+func init() {
+	RegisterCellMagic("trace", "%%trace [-regions]", "take an execution trace of the cell\nThe trace is kept in the session directory until the kernel shuts down, and opens with go tool trace.\n-regions makes a trace region of every statement of the cell.", traceMagic)
+}
+
+// maxRegionName is the length the names of the regions of %%trace -regions are
+// cut to, from the code of their statement.
+const maxRegionName = 40
+
+// traceMagic runs the body of the cell under the execution tracer, in a
+// function of the session, writing the trace to a file of the session
+// directory, which is removed when the kernel shuts down. It prints how long
+// the cell ran and how many goroutines it created, along with the go tool
+// trace command opening the trace. With -regions, every statement of the cell
+// is a trace region of its own, for the trace viewer to show its timing.
+func traceMagic(ctx *MagicContext, args []string, body string) error {
+	regions := false
+	for _, arg := range args {
+		if arg != "-regions" {
+			return fmt.Errorf("unknown flag %s", arg)
+		}
+		regions = true
+	}
+	if strings.TrimSpace(body) == "" {
+		return errors.New("no code to trace")
+	}
+	if !ctx.Session.Runtime() {
+		return errNoRuntime
+	}
+
+	dir := traceDir(ctx.Session)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "trace_")
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	f.Close()
+
+	if regions {
+		body = traceRegions(body)
+	}
+	if err := ctx.Run(fmt.Sprintf("gophernotes.Trace(%q, func() {\n%s\n})", path, body)); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		return errors.New("the cell wrote no trace")
+	}
+
+	summary := "The trace of the cell is in " + path + "."
+	if d, goroutines, err := traceSummary(path); err == nil {
+		summary = fmt.Sprintf("The cell ran for %v and created %d goroutines; the trace is in %s.", time.Duration(d).Round(time.Microsecond), goroutines, path)
+	}
+	ctx.Stream("stdout", summary+"\nOpen it with:\n    go tool trace "+path+"\n")
+	return nil
+}
+
+// traceDir returns the directory of session holding the traces of %%trace.
+func traceDir(session *repl.Session) string {
+	return filepath.Join(session.Dir(), "traces")
+}
+
+// traceRegions returns the code of a cell with a call to
+// gophernotes.TraceRegion before each of its statements, naming the region
+// after the line and code of the statement. Code that does not parse is
+// returned as it is, for its errors to be reported when it runs.
+func traceRegions(code string) string {
+	const head = "package p\nfunc _() {\n"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", head+code+"\n}", parser.Mode(0))
+	if err != nil {
+		return code
+	}
+	var out bytes.Buffer
+	last := 0
+	for _, stmt := range f.Decls[0].(*ast.FuncDecl).Body.List {
+		if _, ok := stmt.(*ast.EmptyStmt); ok {
+			continue
+		}
+		start := fset.Position(stmt.Pos()).Offset - len(head)
+		line := fset.Position(stmt.Pos()).Line - 2
+		text := code[start:]
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if len(text) > maxRegionName {
+			text = text[:maxRegionName-3] + "..."
+		}
+		out.WriteString(code[last:start])
+		fmt.Fprintf(&out, "gophernotes.TraceRegion(%q); ", fmt.Sprintf("line %d: %s", line, text))
+		last = start
+	}
+	out.WriteString(code[last:])
+	return out.String()
+}
+
+// traceEventRe matches the events go tool trace -d=parsed dumps, one per
+// line, capturing their kind and what follows their time.
+var traceEventRe = regexp.MustCompile(`^M=\S+ P=\S+ G=\S+ (\w+) Time=(\d+) (.*)$`)
+
+// traceSummary returns how long the region of the cell lasted in the trace at
+// path, and the number of goroutines created meanwhile, from the events go
+// tool trace dumps.
+func traceSummary(path string) (d int64, goroutines int, err error) {
+	cmd := exec.Command("go", "tool", "trace", "-d=parsed", path)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, 0, err
+	}
+	defer cmd.Wait()
+	defer out.Close()
+
+	cellType := fmt.Sprintf("Type=%q", gophernotes.CellRegion)
+	var begin int64 = -1
+	sc := bufio.NewScanner(out)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		m := traceEventRe.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		t, _ := strconv.ParseInt(m[2], 10, 64)
+		switch {
+		case m[1] == "RegionBegin" && strings.Contains(m[3], cellType):
+			begin = t
+		case m[1] == "RegionEnd" && strings.Contains(m[3], cellType) && begin >= 0:
+			return t - begin, goroutines, nil
+		case m[1] == "StateTransition" && begin >= 0 && strings.Contains(m[3], " NotExist->Runnable"):
+			goroutines++
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, 0, err
+	}
+	return 0, 0, errors.New("the trace holds no region for the cell")
+}
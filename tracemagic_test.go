@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTraceRegions tests that every statement of a cell starts a trace region
+// named after its line and code, the lines of the cell staying where they are
+func TestTraceRegions(t *testing.T) {
+	assert.Equal(t, "gophernotes.TraceRegion(\"line 1: x := 1\"); x := 1\n"+
+		"gophernotes.TraceRegion(\"line 2: for i := 0; i < 3; i++ {\"); for i := 0; i < 3; i++ {\n\tx += i\n}\n"+
+		"gophernotes.TraceRegion(\"line 5: _ = aVeryLongFunctionName(x, x, x, x,...\"); _ = aVeryLongFunctionName(x, x, x, x, x, x)",
+		traceRegions("x := 1\nfor i := 0; i < 3; i++ {\n\tx += i\n}\n_ = aVeryLongFunctionName(x, x, x, x, x, x)"))
+
+	assert.Equal(t, "x := (1", traceRegions("x := (1"))
+}
+
+// TestTraceMagic tests that %%trace writes the trace of the cell to a file of
+// the session and sums it up
+func TestTraceMagic(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+	defer c.execute("%reset -f")
+
+	reply, published := c.execute("%%trace -regions\ndone := make(chan bool)\nfor i := 0; i < 3; i++ {\n\tgo func() { done <- true }()\n}\nfor i := 0; i < 3; i++ {\n\t<-done\n}")
+	content := reply.Content.(map[string]interface{})
+	assert.Equal(t, "ok", content["status"], content["evalue"])
+	stdout := streamText(published, "stdout")
+	assert.Regexp(t, `^The cell ran for \S+ and created 3 goroutines; the trace is in \S+\.\nOpen it with:\n    go tool trace \S+\n$`, stdout)
+	if m := regexp.MustCompile(`go tool trace (\S+)`).FindStringSubmatch(stdout); m != nil {
+		_, err := os.Stat(m[1])
+		assert.NoError(t, err)
+		assert.Contains(t, m[1], traceDir(testKernel.session))
+	}
+
+	reply, _ = c.execute("%%trace -x\ndone")
+	assert.Equal(t, "%%trace: unknown flag -x", reply.Content.(map[string]interface{})["evalue"])
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gopherds/gophernotes/internal/trace"
+)
+
+func init() {
+	RegisterLineMagic("vet", "%vet [-strict]", "run go vet over the code of the session\nThe issues found are shown on stderr at the cell and line they come from.\n-strict fails the cell if go vet reports anything.", vetMagic)
+	RegisterCellMagic("vet", "%%vet [-strict]", "run go vet over the rest of the cell along with the session, without running it\nThe issues found are shown on stderr at the cell and line they come from.\n-strict fails the cell if go vet reports anything.", vetMagic)
+}
+
+// vetAnalyzers are the flags selecting the analyzers of go vet that %vet runs.
+var vetAnalyzers = []string{"-printf", "-unreachable", "-copylocks", "-loopclosure", "-unusedresult"}
+
+// vetMagic runs go vet over the code of the session, along with the rest of
+// the cell for %%vet, which does not run, and shows what it reports on
+// stderr, at the cells and lines the code comes from. The cell does not fail
+// unless -strict is given and go vet reports anything.
+func vetMagic(ctx *MagicContext, args []string, body string) error {
+	strict := false
+	for _, arg := range args {
+		if arg != "-strict" {
+			return fmt.Errorf("unknown flag %s", arg)
+		}
+		strict = true
+	}
+
+	report, sources, err := ctx.Session.Vet(body, vetAnalyzers)
+	if err != nil {
+		return err
+	}
+	text, n := formatVetReport(report, sources, body, pastHistory(ctx))
+	if n == 0 {
+		ctx.Stream("stdout", "go vet found nothing.\n")
+		return nil
+	}
+	ctx.Stream("stderr", text)
+	if strict {
+		return fmt.Errorf("go vet reported %d issues", n)
+	}
+	return nil
+}
+
+// formatVetReport rewrites the diagnostics of report, a go vet output of
+// which sources are the files, with the cell and line the code reported comes
+// from, which is looked for in body, the cell vetted, and then in the cells
+// run, the latest first. It returns the text, along with the number of
+// diagnostics.
+func formatVetReport(report string, sources map[string]string, body string, entries []historyEntry) (string, int) {
+	var buf bytes.Buffer
+	n := 0
+	for _, line := range strings.Split(strings.TrimRight(report, "\n"), "\n") {
+		line = strings.TrimPrefix(line, "vet: ")
+		if line == "" || strings.HasPrefix(line, "# ") {
+			continue
+		}
+		m := compileErrorRe.FindStringSubmatch(line)
+		if m == nil {
+			buf.WriteString(line + "\n")
+			continue
+		}
+		n++
+		name := filepath.Base(m[1])
+		label := name + ":" + m[2]
+		var text string
+		if lines := strings.Split(sources[name], "\n"); sources[name] != "" {
+			if i, _ := strconv.Atoi(m[2]); i >= 1 && i <= len(lines) {
+				text = strings.TrimSpace(lines[i-1])
+			}
+			// Values the cells evaluate to are passed to the printer
+			// function.
+			if call := trace.PrinterName + "("; strings.HasPrefix(text, call) && strings.HasSuffix(text, ")") {
+				text = text[len(call) : len(text)-1]
+			}
+		}
+		if text != "" {
+			if l := cellLine(body, text); l > 0 {
+				label = "cell line " + strconv.Itoa(l)
+			} else {
+				for i := len(entries) - 1; i >= 0; i-- {
+					if l := cellLine(entries[i].Code, text); l > 0 {
+						label = fmt.Sprintf("cell [%d] line %d", entries[i].Count, l)
+						break
+					}
+				}
+			}
+		}
+		fmt.Fprintf(&buf, "%s: %s\n", label, m[4])
+		if text != "" {
+			buf.WriteString("    " + text + "\n")
+		}
+	}
+	return buf.String(), n
+}
+
+// cellLine returns the number of the line of code that is text, ignoring
+// indentation, or 0 if there is none.
+func cellLine(code, text string) int {
+	for i, line := range strings.Split(code, "\n") {
+		if strings.TrimSpace(line) == text {
+			return i + 1
+		}
+	}
+	return 0
+}
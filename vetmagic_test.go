@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFormatVetReport tests that the diagnostics of go vet are given at the
+// line of the cell vetted, or of the cell run, the code of which was reported
+func TestFormatVetReport(t *testing.T) {
+	sources := map[string]string{
+		"gophernotes_session.go": "package main\n\nfunc main() {\n\tx := 1\n\tfmt.Printf(\"%s\", x)\n\tfor {\n\t}\n\ty := 2\n}\n",
+	}
+	report := "# command-line-arguments\n" +
+		"/tmp/1/gophernotes_vet/gophernotes_session.go:5:2: fmt.Printf format %s has arg x of wrong type int\n" +
+		"/tmp/1/gophernotes_vet/gophernotes_session.go:8:2: unreachable code\n" +
+		"/tmp/1/gophernotes_vet/gophernotes_session.go:30:2: out of range\n"
+	entries := []historyEntry{{Count: 3, Code: "x := 1\nfmt.Printf(\"%s\", x)"}, {Count: 4, Code: "%vet"}}
+
+	text, n := formatVetReport(report, sources, "for {\n}\ny := 2", entries)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "cell [3] line 2: fmt.Printf format %s has arg x of wrong type int\n"+
+		"    fmt.Printf(\"%s\", x)\n"+
+		"cell line 3: unreachable code\n"+
+		"    y := 2\n"+
+		"gophernotes_session.go:30: out of range\n", text)
+}
+
+// TestVetMagics tests that %vet reports the issues of the code of the session
+// at the cells it comes from, that %%vet vets the rest of the cell without
+// running it, and that issues only fail the cell with -strict
+func TestVetMagics(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+	defer c.execute("%reset -f")
+
+	c.execute(":import fmt")
+	c.execute("vetS := \"s\"")
+	reply, _ := c.execute("fmt.Printf(\"%d\\n\", vetS)")
+	count := int(reply.Content.(map[string]interface{})["execution_count"].(float64))
+
+	reply, published := c.execute("%vet")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Contains(t, streamText(published, "stderr"), fmt.Sprintf("cell [%d] line 1: fmt.Printf format %%d has arg vetS of wrong type string\n", count))
+
+	reply, published = c.execute("%%vet\nvetT := []int{}\nfmt.Printf(\"%s\\n\", len(vetT))")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	assert.Equal(t, "", streamText(published, "stdout"))
+	assert.Contains(t, streamText(published, "stderr"), "cell line 2: fmt.Printf format %s has arg len(vetT) of wrong type int\n")
+
+	reply, _ = c.execute("%%vet -strict\nfmt.Printf(\"%s\\n\", 1)")
+	assert.Equal(t, "error", reply.Content.(map[string]interface{})["status"])
+
+	c.execute("%reset -f")
+	c.execute(":import fmt")
+	_, published = c.execute("%%vet\nvetU := 1\nfmt.Println(vetU)")
+	assert.Equal(t, "go vet found nothing.\n", streamText(published, "stdout"))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	repl "github.com/gopherds/gophernotes/internal/repl"
+)
+
+func init() {
+	RegisterLineMagic("who", "%who [var | const | func | type | <type>...]", "list the names defined by cells\nKinds and types given select the names listed.", whoMagic)
+	RegisterLineMagic("whos", "%whos [const | <type>...]", "table the variables defined by cells\nconst tables the constants instead, and types given select the variables tabled.", whosMagic)
+}
+
+// maxWhoNames is the number of names %who lists at most.
+const maxWhoNames = 200
+
+// whoWidth is the width %who wraps the list of names at.
+const whoWidth = 80
+
+// whoMagic lists the names of the variables defined by cells, or those of the
+// constants, functions or types, or the variables and constants of the types
+// given.
+func whoMagic(ctx *MagicContext, args []string, body string) error {
+	bindings, err := whoBindings(ctx, args)
+	if err != nil {
+		return err
+	}
+	if len(bindings) == 0 {
+		ctx.Stream("stdout", "Nothing defined.\n")
+		return nil
+	}
+
+	var buf bytes.Buffer
+	width := 0
+	for i, b := range bindings {
+		if i == maxWhoNames {
+			fmt.Fprintf(&buf, "\n… %d more", len(bindings)-i)
+			break
+		}
+		if width > 0 && width+2+len(b.Name) > whoWidth {
+			buf.WriteByte('\n')
+			width = 0
+		} else if width > 0 {
+			buf.WriteString("  ")
+			width += 2
+		}
+		buf.WriteString(b.Name)
+		width += len(b.Name)
+	}
+	buf.WriteByte('\n')
+	ctx.Stream("stdout", buf.String())
+	return nil
+}
+
+// whosMagic tables the variables defined by cells, or the constants, or the
+// variables and constants of the types given, with their types and a summary
+// of their values, which the session runs to get.
+func whosMagic(ctx *MagicContext, args []string, body string) error {
+	for _, arg := range args {
+		if arg == "func" || arg == "type" {
+			return fmt.Errorf("%s names have no values; use %%who %s", arg, arg)
+		}
+	}
+	if !ctx.Session.Runtime() {
+		return errNoRuntime
+	}
+	bindings, err := whoBindings(ctx, args)
+	if err != nil {
+		return err
+	}
+	if len(bindings) == 0 {
+		ctx.Stream("stdout", "Nothing defined.\n")
+		return nil
+	}
+
+	names := make([]string, len(bindings))
+	types := make([]string, len(bindings))
+	for i, b := range bindings {
+		names[i], types[i] = b.Name, b.Type
+	}
+	return ctx.Run(fmt.Sprintf("gophernotes.Whos(%s, %s, %s)", goStrings(names), goStrings(types), strings.Join(names, ", ")))
+}
+
+// whoBindings returns the bindings of the session %who and %whos list, given
+// their arguments: the variables, or the bindings of the kinds given, or the
+// variables and constants of the types given.
+func whoBindings(ctx *MagicContext, args []string) ([]repl.Binding, error) {
+	all, err := ctx.Session.Bindings()
+	if err != nil {
+		return nil, err
+	}
+
+	var bindings []repl.Binding
+	for _, b := range all {
+		if whoMatches(b, args) {
+			bindings = append(bindings, b)
+		}
+	}
+	return bindings, nil
+}
+
+// whoMatches reports whether b is listed by %who with args.
+func whoMatches(b repl.Binding, args []string) bool {
+	if len(args) == 0 {
+		return b.Kind == "var"
+	}
+	for _, arg := range args {
+		switch arg {
+		case "var", "const", "func", "type":
+			if b.Kind == arg {
+				return true
+			}
+			continue
+		}
+		if b.Kind != "var" && b.Kind != "const" {
+			continue
+		}
+		// A type matches by its name, with or without its package.
+		t := strings.TrimLeft(b.Type, "*")
+		if b.Type == arg || t == arg || t[strings.LastIndex(t, ".")+1:] == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// goStrings returns the Go literal of the slice of ss.
+func goStrings(ss []string) string {
+	quoted := make([]string, len(ss))
+	for i, s := range ss {
+		quoted[i] = strconv.Quote(s)
+	}
+	return "[]string{" + strings.Join(quoted, ", ") + "}"
+}
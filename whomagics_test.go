@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWhoMagics tests that %who lists the names defined by cells, by kind or
+// by type, leaving out the imports, and that %whos tables the variables with
+// their types and a summary of their values
+func TestWhoMagics(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	c.execute("type whoPoint struct{ X int }")
+	c.execute("whoP := whoPoint{1}\nwhoS := []string{\"a\"}")
+	c.execute("const whoC = 3")
+	c.execute("func whoF() {}")
+
+	who := func(code string) string {
+		reply, published := c.execute(code)
+		assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"], code)
+		return streamText(published, "stdout")
+	}
+	assert.Equal(t, "whoP\n", who("%who whoPoint"))
+	assert.Equal(t, "whoS\n", who("%who []string"))
+	names := who("%who")
+	assert.Contains(t, names, "whoP")
+	assert.Contains(t, names, "whoS")
+	for _, name := range []string{"whoC", "whoF", "whoPoint", "fmt", "main"} {
+		assert.NotContains(t, names, name)
+	}
+	assert.Contains(t, who("%who const"), "whoC")
+	assert.Contains(t, who("%who func"), "whoF")
+	assert.Contains(t, who("%who type"), "whoPoint")
+	assert.Equal(t, "Nothing defined.\n", who("%who noSuchType"))
+
+	reply, published := c.execute("%whos []string const")
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	if assert.Equal(t, []string{"display_data"}, msgTypes(published)) {
+		data := published[0].Content.(map[string]interface{})["data"].(map[string]interface{})
+		text := data["text/plain"].(string)
+		assert.Contains(t, text, "whoS  []string     len 1")
+		assert.Contains(t, text, "whoC  untyped int  3")
+		assert.Contains(t, data["text/html"], "<td>whoS</td>")
+	}
+
+	reply, _ = c.execute("%whos func")
+	assert.Equal(t, "error", reply.Content.(map[string]interface{})["status"])
+}
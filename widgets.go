@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/gopherds/gophernotes/gophernotes/widgets"
+)
+
+// mergeWidgetState merges state into the recorded state of the widget of comm
+// id, replacing it if replace is set.
+func (k *Kernel) mergeWidgetState(id string, state map[string]interface{}, replace bool) {
+	k.widgetStates.Lock()
+	defer k.widgetStates.Unlock()
+	if k.widgetStates.m[id] == nil || replace {
+		k.widgetStates.m[id] = map[string]interface{}{}
+	}
+	for key, v := range state {
+		k.widgetStates.m[id][key] = v
+	}
+}
+
+// widgetState returns a copy of the recorded state of the widget of comm id.
+func (k *Kernel) widgetState(id string) map[string]interface{} {
+	k.widgetStates.Lock()
+	defer k.widgetStates.Unlock()
+	state := map[string]interface{}{}
+	for key, v := range k.widgetStates.m[id] {
+		state[key] = v
+	}
+	return state
+}
+
+func (k *Kernel) forgetWidgetState(id string) {
+	k.widgetStates.Lock()
+	delete(k.widgetStates.m, id)
+	k.widgetStates.Unlock()
+}
+
+// writeWidgetStates writes the recorded state of the widgets to the file cell
+// code restores them from.
+func (k *Kernel) writeWidgetStates() {
+	k.widgetStates.Lock()
+	b, err := json.Marshal(k.widgetStates.m)
+	k.widgetStates.Unlock()
+	if err == nil {
+		err = ioutil.WriteFile(k.files.widgetState, b, 0644)
+	}
+	if err != nil {
+		k.diagnose(diagWarning, "widgets", "could not write the widget state: cell code does not see the changes of the frontend", "error", err)
+	}
+}
+
+// recordWidgetState records the state of the widgets in the comm messages of
+// msgType cell code sends, with the target of the comm, if it opens it, and
+// data.
+func (k *Kernel) recordWidgetState(msgType, id, target string, data map[string]interface{}) {
+	state, _ := data["state"].(map[string]interface{})
+	switch msgType {
+	case "comm_open":
+		if target == widgets.Target {
+			k.mergeWidgetState(id, state, true)
+		}
+	case "comm_msg":
+		if data["method"] == "update" {
+			k.mergeWidgetState(id, state, false)
+		}
+	case "comm_close":
+		k.forgetWidgetState(id)
+	}
+}
+
+// receiveWidgetMsg records the updates the frontend makes to the state of the
+// widget of c, and answers its requests of the whole state, from the recorded
+// one. It reports whether the message was answered, rather than being left for
+// cell code.
+func receiveWidgetMsg(c *Comm, data map[string]interface{}) bool {
+	switch data["method"] {
+	case "request_state":
+		c.Send(map[string]interface{}{
+			"method":       "update",
+			"state":        c.kernel.widgetState(c.ID),
+			"buffer_paths": []string{},
+		})
+		return true
+	case "update":
+		state, _ := data["state"].(map[string]interface{})
+		c.kernel.mergeWidgetState(c.ID, state, false)
+	}
+	return false
+}
+
+// openVersionComm answers the frontend checking the version of the widget
+// protocol spoken, on the comms of the "jupyter.widget.version" target.
+func openVersionComm(c *Comm, data map[string]interface{}, buffers [][]byte) CommHandler {
+	c.Send(map[string]interface{}{"version": widgets.ProtocolVersion})
+	return versionComm{}
+}
+
+// versionComm receives the verdict of the frontend on the version of the
+// widget protocol.
+type versionComm struct{}
+
+func (versionComm) Receive(c *Comm, data map[string]interface{}, buffers [][]byte) {
+	if validated, _ := data["validated"].(bool); !validated {
+		c.kernel.diagnose(diagWarning, "widgets", "the frontend does not support the version of the widget protocol of the kernel: widgets may not show", "version", widgets.ProtocolVersion)
+	}
+}
+
+func (versionComm) Closed(*Comm, map[string]interface{}, [][]byte) {}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"go/importer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWidgets tests that widgets created by cells are synced with the frontend:
+// their state is sent on request, updates from the frontend reach the
+// observers of cell code, whose changes reach the frontend, and later cells
+// see the values the frontend set
+func TestWidgets(t *testing.T) {
+	if _, err := importer.Default().Import("github.com/gopherds/gophernotes/gophernotes/widgets"); err != nil {
+		t.Skip("widgets package not installed:", err)
+	}
+	c := newTestClient(t)
+	defer c.Close()
+
+	reply, _ := c.execute(`import "github.com/gopherds/gophernotes/gophernotes/widgets"`)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	reply, published := c.execute(`s := widgets.IntSlider(0, 10, 5)
+double := widgets.IntSlider(0, 20, 10)
+s.Observe(func(v int) { double.SetValue(2 * v) })
+gophernotes.Display(s)`)
+	assert.Equal(t, "ok", reply.Content.(map[string]interface{})["status"])
+	if !assert.Equal(t, []string{"comm_open", "comm_open", "comm_open", "comm_open", "comm_open", "comm_open", "display_data"}, msgTypes(published)) {
+		return
+	}
+	assert.Equal(t, "2.0.0", published[2].Metadata["version"])
+	view := published[6].Content.(map[string]interface{})["data"].(map[string]interface{})["application/vnd.jupyter.widget-view+json"]
+	sID := view.(map[string]interface{})["model_id"]
+	assert.Equal(t, published[2].Content.(map[string]interface{})["comm_id"], sID)
+	doubleID := published[5].Content.(map[string]interface{})["comm_id"]
+
+	req := c.send("comm_msg", map[string]interface{}{
+		"comm_id": sID,
+		"data":    map[string]interface{}{"method": "request_state"},
+	})
+	msg, _ := c.recvReply(req)
+	data := msg.Content.(map[string]interface{})["data"].(map[string]interface{})
+	assert.Equal(t, "update", data["method"])
+	assert.Equal(t, 5.0, data["state"].(map[string]interface{})["value"])
+
+	req = c.send("comm_msg", map[string]interface{}{
+		"comm_id": sID,
+		"data": map[string]interface{}{
+			"method":       "update",
+			"state":        map[string]interface{}{"value": 3},
+			"buffer_paths": []interface{}{},
+		},
+	})
+	msg, _ = c.recvReply(req)
+	assert.Equal(t, "comm_msg", msg.Header.MsgType)
+	content := msg.Content.(map[string]interface{})
+	assert.Equal(t, doubleID, content["comm_id"])
+	assert.Equal(t, map[string]interface{}{"value": 6.0}, content["data"].(map[string]interface{})["state"])
+
+	_, published = c.execute(`double.Value()`)
+	if assert.Equal(t, []string{"execute_result"}, msgTypes(published)) {
+		assert.Equal(t, "6\n", published[0].Content.(map[string]interface{})["data"].(map[string]interface{})["text/plain"])
+	}
+}
+
+// TestWidgets_version tests that the kernel tells the frontend checking it the
+// version of the widget protocol
+func TestWidgets_version(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	req := c.send("comm_open", map[string]interface{}{"comm_id": "version-1", "target_name": "jupyter.widget.version", "data": map[string]interface{}{}})
+	msg, _ := c.recvReply(req)
+	assert.Equal(t, "comm_msg", msg.Header.MsgType)
+	assert.Equal(t, map[string]interface{}{"version": "2.0.0"}, msg.Content.(map[string]interface{})["data"])
+	c.send("comm_close", map[string]interface{}{"comm_id": "version-1", "data": map[string]interface{}{}})
+}